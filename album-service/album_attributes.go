@@ -0,0 +1,339 @@
+// album_attributes.go - genre- and format-specific album attributes, e.g. the composer and conductor
+// of a classical recording or the speed and disc count of a vinyl pressing. Attributes are stored in
+// the albums.attributes JSONB column and checked against the schemas below, so every album uses the
+// same names and types and the storefront can filter on them (?attr.composer=Beethoven) and count
+// them as facets (GET /api/albums/facets?attrFacets=composer).
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"album-store/jsonnaming"
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// Attribute value types
+const (
+	attributeText    = "text"
+	attributeInteger = "integer"
+	attributeBoolean = "boolean"
+)
+
+// maxAttributeTextLength is the maximum length of a text attribute, in characters
+const maxAttributeTextLength = 200
+
+// attributeField is one attribute an album may carry. Integer fields are limited to [Min, Max] when
+// Max > 0; Allowed, when set, lists every valid value.
+type attributeField struct {
+	Name    string
+	Type    string
+	Min     int
+	Max     int
+	Allowed []string
+}
+
+// attributeSchema is the set of attributes available to albums of a genre or in one of the formats
+type attributeSchema struct {
+	Genre   string
+	Formats []string
+	Fields  []attributeField
+}
+
+// attributeSchemas defines every attribute. An album may use the fields of its genre's schema and of
+// its format's schema; genres and formats are matched case-insensitively.
+var attributeSchemas = []attributeSchema{
+	{
+		Genre: "Classical",
+		Fields: []attributeField{
+			{Name: "composer", Type: attributeText},
+			{Name: "conductor", Type: attributeText},
+			{Name: "orchestra", Type: attributeText},
+			{Name: "soloist", Type: attributeText},
+		},
+	},
+	{
+		Formats: []string{"LP", "EP", "Vinyl", `7"`, `10"`, `12"`},
+		Fields: []attributeField{
+			{Name: "rpm", Type: attributeInteger, Allowed: []string{"33", "45", "78"}},
+			{Name: "discs", Type: attributeInteger, Min: 1, Max: 12},
+			{Name: "color", Type: attributeText},
+			{Name: "weightGrams", Type: attributeInteger, Min: 1, Max: 1000},
+			{Name: "gatefold", Type: attributeBoolean},
+		},
+	},
+}
+
+// attributeFieldsFor returns the fields available to an album of the genre and format, by name
+func attributeFieldsFor(genre, format string) map[string]attributeField {
+	fields := map[string]attributeField{}
+	for _, s := range attributeSchemas {
+		matches := s.Genre != "" && strings.EqualFold(s.Genre, genre)
+		for _, f := range s.Formats {
+			matches = matches || strings.EqualFold(f, strings.TrimSpace(format))
+		}
+		if matches {
+			for _, f := range s.Fields {
+				fields[f.Name] = f
+			}
+		}
+	}
+	return fields
+}
+
+// findAttributeField looks an attribute up in every schema
+func findAttributeField(name string) (attributeField, bool) {
+	for _, s := range attributeSchemas {
+		for _, f := range s.Fields {
+			if f.Name == name {
+				return f, true
+			}
+		}
+	}
+	return attributeField{}, false
+}
+
+// normalize checks a decoded JSON value against the field and returns it as stored: text trimmed,
+// integers as int
+func (f attributeField) normalize(v interface{}) (interface{}, error) {
+	var normalized interface{}
+	switch f.Type {
+	case attributeText:
+		s, ok := v.(string)
+		if s = strings.TrimSpace(s); !ok || s == "" {
+			return nil, fmt.Errorf("must be a non-empty string")
+		}
+		if utf8.RuneCountInString(s) > maxAttributeTextLength {
+			return nil, fmt.Errorf("must be at most %d characters", maxAttributeTextLength)
+		}
+		normalized = s
+	case attributeInteger:
+		n, ok := v.(float64)
+		if i, isInt := v.(int); isInt {
+			n, ok = float64(i), true // Already normalized
+		}
+		if !ok || n != math.Trunc(n) {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		if f.Max > 0 && (n < float64(f.Min) || n > float64(f.Max)) {
+			return nil, fmt.Errorf("must be between %d and %d", f.Min, f.Max)
+		}
+		normalized = int(n)
+	case attributeBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		normalized = b
+	}
+	if len(f.Allowed) > 0 && !containsString(f.Allowed, fmt.Sprint(normalized)) {
+		return nil, fmt.Errorf("must be one of %s", strings.Join(f.Allowed, ", "))
+	}
+	return normalized, nil
+}
+
+// parse converts a query parameter value to the field's type
+func (f attributeField) parse(s string) (interface{}, error) {
+	switch f.Type {
+	case attributeInteger:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return f.normalize(float64(n))
+	case attributeBoolean:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	}
+	return f.normalize(s)
+}
+
+// validateAlbumAttributes checks the attributes of a to be written against the schemas of its genre
+// and format and normalizes their values
+func validateAlbumAttributes(a *Album) error {
+	fields := attributeFieldsFor(a.Genre, a.Format)
+	names := make([]string, 0, len(a.Attributes))
+	for name := range a.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("attribute %q is not defined for genre %q and format %q; see GET /api/albums/attributes", name, a.Genre, a.Format)
+		}
+		v, err := field.normalize(a.Attributes[name])
+		if err != nil {
+			return fmt.Errorf("attribute %q %v", name, err)
+		}
+		a.Attributes[name] = v
+	}
+	return nil
+}
+
+// normalizeAttributePatch normalizes the values of a PATCH's attributes before they are merged in
+// the database; whether the album may carry them is checked on the merged attributes
+func normalizeAttributePatch(patch map[string]interface{}) error {
+	for name, v := range patch {
+		if v == nil {
+			continue
+		}
+		field, ok := findAttributeField(name)
+		if !ok {
+			return fmt.Errorf("unknown attribute %q; see GET /api/albums/attributes", name)
+		}
+		normalized, err := field.normalize(v)
+		if err != nil {
+			return fmt.Errorf("attribute %q %v", name, err)
+		}
+		patch[name] = normalized
+	}
+	return nil
+}
+
+// mergeAttributes returns current with the patch applied; null values remove attributes
+func mergeAttributes(current, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for name, v := range current {
+		merged[name] = v
+	}
+	for name, v := range patch {
+		if v == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = v
+		}
+	}
+	return merged
+}
+
+// marshalAttributes returns the value of the attributes column: NULL for no attributes
+func marshalAttributes(attributes map[string]interface{}) ([]byte, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(attributes)
+}
+
+// unmarshalAttributes reads the attributes column; NULL is no attributes
+func unmarshalAttributes(data []byte, dst *map[string]interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// attributeFilter restricts an attribute to one of Values (typed per the schema)
+type attributeFilter struct {
+	Name   string
+	Values []interface{}
+}
+
+// attributeFilterPrefix prefixes the query parameters filtering on attributes
+const attributeFilterPrefix = "attr."
+
+// parseAttributeFilters reads the ?attr.<name>= filters, ordered by name. Names are accepted in
+// either JSON naming.
+func parseAttributeFilters(c *gin.Context) ([]attributeFilter, error) {
+	var filters []attributeFilter
+	for key := range c.Request.URL.Query() {
+		if !strings.HasPrefix(key, attributeFilterPrefix) {
+			continue
+		}
+		name := jsonnaming.CamelCaseKey(strings.TrimPrefix(key, attributeFilterPrefix))
+		field, ok := findAttributeField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %q; see GET /api/albums/attributes", name)
+		}
+		filter := attributeFilter{Name: name}
+		for _, s := range listing.QueryValues(c, key) {
+			v, err := field.parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s%s %q: %v", attributeFilterPrefix, name, s, err)
+			}
+			filter.Values = append(filter.Values, v)
+		}
+		if len(filter.Values) > 0 {
+			filters = append(filters, filter)
+		}
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+	return filters, nil
+}
+
+// parseAttributeFacets reads ?attrFacets=, the attributes to count in GET /api/albums/facets
+func parseAttributeFacets(c *gin.Context) ([]string, error) {
+	var names []string
+	for _, v := range listing.QueryValues(c, "attrFacets") {
+		name := jsonnaming.CamelCaseKey(v)
+		if _, ok := findAttributeField(name); !ok {
+			return nil, fmt.Errorf("unknown attribute %q; see GET /api/albums/attributes", v)
+		}
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// condition matches albums whose attribute has one of the filter's values, binding each value as a
+// JSON document for the attributes index
+func (f attributeFilter) condition(bind func(interface{}) string) string {
+	conds := make([]string, len(f.Values))
+	for i, v := range f.Values {
+		doc, _ := json.Marshal(map[string]interface{}{f.Name: v})
+		conds[i] = "a.attributes @> " + bind(string(doc)) + "::jsonb"
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// attributeFacet is the facet name counting an attribute's values
+func attributeFacet(name string) string {
+	return attributeFilterPrefix + name
+}
+
+// AttributeSchemaResponse describes the attributes of a genre or formats (GET /api/albums/attributes)
+type AttributeSchemaResponse struct {
+	Genre   string                   `json:"genre,omitempty"`
+	Formats []string                 `json:"formats,omitempty"`
+	Fields  []AttributeFieldResponse `json:"fields"`
+}
+
+// AttributeFieldResponse describes one attribute
+type AttributeFieldResponse struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Min     *int     `json:"min,omitempty"`
+	Max     *int     `json:"max,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// getAttributeSchemas handles GET /api/albums/attributes, listing the attribute schemas
+func getAttributeSchemas(c *gin.Context) {
+	resp := make([]AttributeSchemaResponse, 0, len(attributeSchemas))
+	for _, s := range attributeSchemas {
+		schema := AttributeSchemaResponse{Genre: s.Genre, Formats: s.Formats, Fields: []AttributeFieldResponse{}}
+		for _, f := range s.Fields {
+			field := AttributeFieldResponse{Name: f.Name, Type: f.Type, Allowed: f.Allowed}
+			if f.Max > 0 {
+				min, max := f.Min, f.Max
+				field.Min, field.Max = &min, &max
+			}
+			schema.Fields = append(schema.Fields, field)
+		}
+		resp = append(resp, schema)
+	}
+	c.JSON(http.StatusOK, resp)
+}
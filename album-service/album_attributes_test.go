@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributeSchemas(t *testing.T) {
+	// Names are inlined in facet queries and renamed like JSON fields, so they must be camelCase identifiers
+	identifier := regexp.MustCompile(`^[a-z][A-Za-z0-9]*$`)
+	seen := map[string]bool{}
+	for _, s := range attributeSchemas {
+		assert.True(t, s.Genre != "" || len(s.Formats) > 0, "a schema applies to a genre or formats")
+		for _, f := range s.Fields {
+			assert.Regexp(t, identifier, f.Name)
+			assert.False(t, seen[f.Name], "%s is defined twice", f.Name)
+			assert.Contains(t, []string{attributeText, attributeInteger, attributeBoolean}, f.Type, f.Name)
+			seen[f.Name] = true
+		}
+	}
+}
+
+func TestValidateAlbumAttributes(t *testing.T) {
+	valid := []struct {
+		album Album
+		want  map[string]interface{}
+	}{
+		{Album{Genre: "Classical", Format: "CD", Attributes: map[string]interface{}{"composer": " Beethoven ", "conductor": "Karajan"}},
+			map[string]interface{}{"composer": "Beethoven", "conductor": "Karajan"}},
+		{Album{Genre: "Rock", Format: "lp", Attributes: map[string]interface{}{"rpm": float64(45), "discs": float64(2), "gatefold": true}},
+			map[string]interface{}{"rpm": 45, "discs": 2, "gatefold": true}},
+		{Album{Genre: "Classical", Format: "Vinyl", Attributes: map[string]interface{}{"composer": "Bach", "rpm": float64(33)}},
+			map[string]interface{}{"composer": "Bach", "rpm": 33}},
+		{Album{Genre: "Rock", Format: "CD"}, nil},
+	}
+	for _, tc := range valid {
+		a := tc.album
+		require.NoError(t, validateAlbumAttributes(&a))
+		assert.Equal(t, tc.want, a.Attributes)
+	}
+
+	invalid := map[string]Album{
+		`attribute "composer" is not defined for genre "Rock" and format "CD"`: {Genre: "Rock", Format: "CD", Attributes: map[string]interface{}{"composer": "Bach"}},
+		`attribute "rpm" is not defined for genre "Classical" and format "CD"`: {Genre: "Classical", Format: "CD", Attributes: map[string]interface{}{"rpm": float64(33)}},
+		`attribute "rpm" must be one of 33, 45, 78`:                            {Genre: "Rock", Format: "LP", Attributes: map[string]interface{}{"rpm": float64(44)}},
+		`attribute "rpm" must be an integer`:                                   {Genre: "Rock", Format: "LP", Attributes: map[string]interface{}{"rpm": "45"}},
+		`attribute "discs" must be between 1 and 12`:                           {Genre: "Rock", Format: "LP", Attributes: map[string]interface{}{"discs": float64(0)}},
+		`attribute "gatefold" must be true or false`:                           {Genre: "Rock", Format: "LP", Attributes: map[string]interface{}{"gatefold": "yes"}},
+		`attribute "composer" must be a non-empty string`:                      {Genre: "Classical", Attributes: map[string]interface{}{"composer": "  "}},
+		`attribute "soloist" must be at most 200 characters`:                   {Genre: "Classical", Attributes: map[string]interface{}{"soloist": strings.Repeat("x", 201)}},
+	}
+	for msg, a := range invalid {
+		err := validateAlbumAttributes(&a)
+		require.Error(t, err, msg)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
+
+func TestBuildFacetsQuery_Attributes(t *testing.T) {
+	f := albumFilter{
+		Genres:     []string{"Classical"},
+		Attributes: []attributeFilter{{Name: "rpm", Values: []interface{}{33, 45}}},
+	}
+	query, args := buildFacetsQuery(f, "composer")
+
+	assert.Equal(t, []interface{}{"Classical", `{"rpm":33}`, `{"rpm":45}`}, args)
+	assert.Contains(t, query, "a.attributes->>'rpm' AS attr_rpm,\n\t\t\t\t(a.attributes @> $2::jsonb OR a.attributes @> $3::jsonb) AS m_attr_rpm")
+	assert.Contains(t, query, "a.attributes->>'composer' AS attr_composer,\n\t\t\t\tTRUE AS m_attr_composer")
+	assert.Contains(t, query, "SELECT 'total', '', COUNT(*) FROM base WHERE m_genre AND m_priceBand AND m_decade AND m_availability AND m_attr_rpm AND m_attr_composer")
+	assert.Contains(t, query, "SELECT 'genre', genre, COUNT(*) FROM base WHERE m_priceBand AND m_decade AND m_availability AND m_attr_rpm AND m_attr_composer GROUP BY genre")
+	assert.Contains(t, query, "SELECT 'attr.composer', attr_composer, COUNT(*) FROM base WHERE attr_composer IS NOT NULL AND m_genre AND m_priceBand AND m_decade AND m_availability AND m_attr_rpm GROUP BY attr_composer")
+	assert.NotContains(t, query, "SELECT 'attr.rpm'", "filtered attributes are only counted on request")
+
+	var listArgs []interface{}
+	assert.Equal(t, " WHERE a.genre IN ($1) AND (a.attributes @> $2::jsonb OR a.attributes @> $3::jsonb)", f.whereClause(&listArgs))
+}
+
+func TestAlbumAttributesAPI(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		req.Header.Set("If-Match", `"3"`)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Filters and facets", func(t *testing.T) {
+		mock.ExpectQuery(`WITH base AS .*a.attributes @> \$1::jsonb`).
+			WithArgs(`{"weightGrams":180}`).
+			WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}).
+				AddRow("total", "", 3).
+				AddRow("attr.composer", "Bach", 1).
+				AddRow("attr.composer", "Beethoven", 2))
+
+		rr := send(http.MethodGet, "/api/v1/albums/facets?attr.weight_grams=180&attrFacets=composer", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp FacetsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, []FacetBucket{{"Beethoven", 2}, {"Bach", 1}}, resp.Facets["attr.composer"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		for _, q := range []string{"attr.mood=happy", "attr.rpm=fast", "attr.rpm=44", "attr.gatefold=maybe", "attrFacets=mood"} {
+			rr := send(http.MethodGet, "/api/v1/albums/facets?"+q, "")
+			assert.Equal(t, http.StatusBadRequest, rr.Code, q)
+		}
+		rr := send(http.MethodGet, "/api/v1/albums?attr.mood=happy", "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `unknown attribute \"mood\"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Creates reject attributes outside the schema", func(t *testing.T) {
+		rr := send(http.MethodPost, "/api/v1/albums", `{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","format":"CD","attributes":{"rpm":33}}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `attribute \"rpm\" is not defined`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	expectCurrent := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, title, .*, version, attributes FROM albums WHERE id = \\$1 FOR UPDATE").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "attributes"}).
+				AddRow(4, "Goldberg Variations", "Glenn Gould", 1999, 1955, "Classical", "LP", "", "", 3, []byte(`{"composer":"Bach","rpm":33}`)))
+	}
+
+	t.Run("Patches merge attributes", func(t *testing.T) {
+		expectCurrent()
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET attributes = NULLIF\(jsonb_strip_nulls\(COALESCE\(attributes, '\{\}'::jsonb\) \|\| \$2::jsonb\), '\{\}'::jsonb\) WHERE id = \$1`).
+			WithArgs(4, `{"rpm":null,"soloist":"Glenn Gould"}`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Goldberg Variations", "Glenn Gould", 1999, 1955, "Classical", "LP", "", "", 4, 0, 0, "", "", nil, "published", []byte(`{"composer":"Bach","soloist":"Glenn Gould"}`)))
+		mock.ExpectCommit()
+
+		rr := send(http.MethodPatch, "/api/v1/albums/4", `{"attributes":{"soloist":" Glenn Gould ","rpm":null}}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"attributes":{"composer":"Bach","soloist":"Glenn Gould"}`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Patches check the attributes against a new format", func(t *testing.T) {
+		expectCurrent()
+		mock.ExpectRollback()
+
+		rr := send(http.MethodPatch, "/api/v1/albums/4", `{"format":"CD"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `attribute \"rpm\" is not defined for genre \"Classical\" and format \"CD\"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
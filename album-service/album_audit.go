@@ -0,0 +1,141 @@
+// album_audit.go - the audit log of album changes, for compliance: every create, update and delete
+// of an album with who made it, when, and the old and new value of each changed field. As for price
+// history, a database trigger records the changes so no write path can skip them; writers name who
+// makes them with setAuditActor in the same transaction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"album-store/jsonnaming"
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// albumAuditListSpec pages the audit log newest first (see album-store/listing)
+var albumAuditListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "changedAt", Expr: "changed_at"}},
+	Filterable:  []listing.Field{{Name: "action", Expr: "action"}, {Name: "changedBy", Expr: "changed_by"}},
+	DefaultSort: []listing.SortKey{{Expr: "changed_at", Desc: true}},
+	Unique:      "id",
+}
+
+// AlbumAuditEntry is one change of an album
+type AlbumAuditEntry struct {
+	ID        int64                  `json:"id"`
+	Action    string                 `json:"action"` // create, update or delete
+	Changes   map[string]AuditChange `json:"changes"`
+	ChangedBy string                 `json:"changedBy,omitempty"` // Client IP, or "auto"; absent for changes the service makes itself
+	ChangedAt time.Time              `json:"changedAt"`
+}
+
+// AuditChange is the value of a field before and after a change; null before a create and after a
+// delete
+type AuditChange struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+// setAuditActor names who makes the album changes of the transaction, for the audit trigger
+func setAuditActor(ctx context.Context, tx *sql.Tx, actor string) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('album_store.changed_by', $1, true)`, actor)
+	return err
+}
+
+// auditField returns the API name of an audited column and converts its value to the API's form
+func auditField(column string, value json.RawMessage) (string, json.RawMessage) {
+	if column != "price_cents" {
+		return jsonnaming.CamelCaseKey(column), value
+	}
+	var cents *int64
+	if err := json.Unmarshal(value, &cents); err != nil || cents == nil {
+		return "price", value
+	}
+	price, _ := json.Marshal(Cents(*cents))
+	return "price", price
+}
+
+// getAlbumAudit handles GET /api/albums/:id/audit (admin), newest change first unless sorted
+// otherwise. The log of deleted albums is kept.
+func getAlbumAudit(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	page, err := listing.ParseParams(c, albumAuditListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := []interface{}{id}
+	where := listing.WhereClause(append([]string{"album_id = $1"}, page.Conditions(&args)...))
+	countArgs := args
+	query := "SELECT id, action, changes, COALESCE(changed_by, ''), changed_at FROM album_audit" +
+		where + page.OrderByClause() + page.PageClause(&args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit log: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AlbumAuditEntry{}
+	for rows.Next() {
+		var e AlbumAuditEntry
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.Action, &changes, &e.ChangedBy, &e.ChangedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log: " + err.Error()})
+			return
+		}
+		var columns map[string]AuditChange
+		if err := json.Unmarshal(changes, &columns); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log: " + err.Error()})
+			return
+		}
+		e.Changes = make(map[string]AuditChange, len(columns))
+		for column, change := range columns {
+			var field string
+			field, change.Old = auditField(column, change.Old)
+			_, change.New = auditField(column, change.New)
+			e.Changes[field] = change
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(entries), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM album_audit"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count audit log: " + err.Error()})
+		return
+	}
+	if meta.Total == 0 && len(page.Filters) == 0 {
+		// Albums created before the audit log have no entries until they change
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM albums WHERE id = $1)", id).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+			return
+		}
+	}
+	meta.SetHeaders(c)
+	c.JSON(http.StatusOK, entries)
+}
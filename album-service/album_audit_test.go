@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectAuditActor expects a write to name who makes it (see setAuditActor)
+func expectAuditActor(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("set_config\\('album_store.changed_by'").WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestGetAlbumAudit(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"id", "action", "changes", "changed_by", "changed_at"}
+
+	t.Run("Changes with API field names", func(t *testing.T) {
+		changed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("FROM album_audit WHERE album_id = \\$1 ORDER BY changed_at DESC").WithArgs(4, listing.DefaultLimit, 0).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, "update", []byte(`{"price_cents":{"old":1999,"new":1499},"release_year":{"old":1990,"new":1991}}`), "10.0.0.7", changed).
+				AddRow(1, "create", []byte(`{"title":{"old":null,"new":"Nevermind"},"price_cents":{"old":null,"new":1999}}`), "", changed.AddDate(0, -6, 0)))
+
+		rr := get("/api/albums/4/audit")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `[
+			{"id":2,"action":"update","changedBy":"10.0.0.7","changedAt":"2024-03-01T12:00:00Z",
+			 "changes":{"price":{"old":19.99,"new":14.99},"releaseYear":{"old":1990,"new":1991}}},
+			{"id":1,"action":"create","changedAt":"2023-09-01T12:00:00Z",
+			 "changes":{"title":{"old":null,"new":"Nevermind"},"price":{"old":null,"new":19.99}}}]`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Filtered by action", func(t *testing.T) {
+		mock.ExpectQuery(`FROM album_audit WHERE album_id = \$1 AND action IN \(\$2\)`).WithArgs(4, "delete", listing.DefaultLimit, 0).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		rr := get("/api/albums/4/audit?action=delete")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `[]`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Albums without changes", func(t *testing.T) {
+		mock.ExpectQuery("FROM album_audit").WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("FROM album_audit").WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(99).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		assert.Equal(t, http.StatusOK, get("/api/albums/5/audit").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/99/audit").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/abc/audit").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/albums/4/audit", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestAuditField(t *testing.T) {
+	field, value := auditField("price_cents", json.RawMessage(`2499`))
+	assert.Equal(t, "price", field)
+	assert.JSONEq(t, `24.99`, string(value))
+
+	field, value = auditField("price_cents", json.RawMessage(`null`))
+	assert.Equal(t, "price", field)
+	assert.Equal(t, "null", string(value))
+
+	field, value = auditField("catalog_number", json.RawMessage(`"SP 34"`))
+	assert.Equal(t, "catalogNumber", field)
+	assert.Equal(t, `"SP 34"`, string(value))
+}
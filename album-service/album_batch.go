@@ -0,0 +1,245 @@
+// album_batch.go - POST /api/albums/batch, creating many albums in one transaction
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"album-store/events"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const maxBatchAlbums = 500
+
+// Event publish statuses reported per album in BatchCreateResult
+const (
+	eventStatusPublished = "published"
+	eventStatusQueued    = "queued" // Failed, and queued for retry (see publish_retry.go)
+	eventStatusFailed    = "failed"
+)
+
+// BatchEventStatus is the outcome of publishing one album's album-created event
+type BatchEventStatus struct {
+	AlbumID string `json:"albumId" id:"public"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchCreateResult is returned by POST /api/albums/batch. Albums are listed in request order.
+type BatchCreateResult struct {
+	Created         []Album            `json:"created"`
+	Events          []BatchEventStatus `json:"events"`
+	PublishFailures int                `json:"publishFailures"`
+}
+
+// createAlbumsBatch handles POST /api/albums/batch with a JSON array of albums. Either every album is
+// created or none is; a validation error names the index of the offending album. album-created events
+// are published after the commit in a single batched write.
+func createAlbumsBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var albums []Album
+	if err := c.ShouldBindJSON(&albums); err != nil {
+		var sliceErrs binding.SliceValidationError
+		if errors.As(err, &sliceErrs) {
+			err = albumBatchBindingError(albums)
+		}
+		respondBindingError(c, err)
+		return
+	}
+	if len(albums) == 0 || len(albums) > maxBatchAlbums {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A batch must contain 1 to %d albums", maxBatchAlbums)})
+		return
+	}
+	floors := make([]Cents, len(albums))
+	upcs := make(map[string]int, len(albums))
+	for i := range albums {
+		if err := normalizeGenre(&albums[i].Genre); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album %d: %v", i, err)})
+			return
+		}
+		if err := normalizeAlbumIdentifiers(&albums[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album %d: %v", i, err)})
+			return
+		}
+		if err := validateAlbumAttributes(&albums[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album %d: %v", i, err)})
+			return
+		}
+		if upc := albums[i].UPC; upc != "" {
+			if first, ok := upcs[upc]; ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album %d: upc %s is also used by album %d", i, upc, first)})
+				return
+			}
+			upcs[upc] = i
+		}
+		floor, err := checkPriceFloor(albums[i])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album %d: %v", i, err)})
+			return
+		}
+		floors[i] = floor
+	}
+
+	dbCtx, dbSpan := tracer.Start(ctx, "db.insert_album_batch")
+	err := insertAlbumBatch(dbCtx, albums, floors, c.ClientIP())
+	dbSpan.End()
+	if errors.Is(err, errUPCTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create albums in DB: " + err.Error()})
+		return
+	}
+
+	// As for single creates, the albums stay created when publishing fails; the response says which
+	// events were queued for retry and which were lost, so inventory can be initialized explicitly
+	result := BatchCreateResult{Created: albums, Events: make([]BatchEventStatus, len(albums))}
+	for i, err := range publishAlbumsCreated(ctx, albums) {
+		result.Events[i] = BatchEventStatus{AlbumID: albums[i].ID, Status: eventStatusPublished}
+		if err != nil {
+			result.Events[i].Status, result.Events[i].Error = eventStatusFailed, err.Error()
+			if errors.Is(err, errPublishQueued) {
+				result.Events[i].Status = eventStatusQueued
+			}
+			result.PublishFailures++
+		}
+	}
+	log.Printf("Batch created %d albums, %d publish failures", len(albums), result.PublishFailures)
+	respondJSON(c, http.StatusCreated, result)
+}
+
+// albumBatchBindingError validates each album of a batch that failed validation again, to name the
+// invalid ones by their index
+func albumBatchBindingError(albums []Album) error {
+	var errs batchBindingError
+	for i := range albums {
+		if err := binding.Validator.ValidateStruct(&albums[i]); err != nil {
+			errs = append(errs, elementError{index: i, err: err})
+		}
+	}
+	return errs
+}
+
+// insertAlbumBatch inserts the albums and their price floor override audits in one transaction and
+// sets their IDs. floors[i] is 0 when album i needed no override.
+func insertAlbumBatch(ctx context.Context, albums []Album, floors []Cents, clientIP string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setAuditActor(ctx, tx, clientIP); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre, format, upc, catalog_number, attributes, status) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, '"+albumDraft+"') RETURNING id")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range albums {
+		a := &albums[i]
+		attributes, err := marshalAttributes(a.Attributes)
+		if err != nil {
+			return fmt.Errorf("album %d: %w", i, err)
+		}
+		var id int
+		if err := stmt.QueryRowContext(ctx, a.Title, a.Artist, a.Price, a.ReleaseYear, a.Genre, a.Format, a.UPC, a.CatalogNumber, attributes).Scan(&id); err != nil {
+			if isUPCConflict(err) {
+				err = errUPCTaken
+			}
+			return fmt.Errorf("album %d: %w", i, err)
+		}
+		a.ID = strconv.Itoa(id)
+		a.Version = initialAlbumVersion
+		a.Status = albumDraft
+		if floors[i] > 0 {
+			if err := recordPriceFloorOverride(ctx, tx, a.ID, "batch_create", *a, floors[i], clientIP); err != nil {
+				return fmt.Errorf("album %d: %w", i, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// albumCreatedMessage builds the album-created message for a, keyed by album ID so all events of an
+// album land on the same partition
+func albumCreatedMessage(a Album, headers []kafka.Header) (kafka.Message, error) {
+	event := &events.AlbumCreated{
+		AlbumId:   a.ID,
+		Title:     a.Title,
+		Artist:    a.Artist,
+		Timestamp: timestamppb.Now(),
+	}
+	if a.InitialQuantity != nil {
+		quantity := int32(*a.InitialQuantity)
+		event.InitialQuantity = &quantity
+	}
+	value, err := encodeAlbumEvent(albumCreatedTopic, event)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{Key: []byte(a.ID), Value: value, Headers: headers}, nil
+}
+
+// publishAlbumsCreated publishes album-created for every album in a single batched write. The
+// messages share the caller's trace context. Events that fail are queued for retry. It returns one
+// error per album (nil when published), wrapping errPublishQueued when the event was queued.
+func publishAlbumsCreated(ctx context.Context, albums []Album) []error {
+	ctx, span := tracer.Start(ctx, "kafka.publish_album_created_batch")
+	defer span.End()
+
+	errs := make([]error, len(albums))
+	headers := InjectTraceInfoToKafkaMessage(ctx)
+	msgs := make([]kafka.Message, 0, len(albums))
+	index := make([]int, 0, len(albums)) // msgs[j] is the event of albums[index[j]]
+	for i, a := range albums {
+		msg, err := albumCreatedMessage(a, headers)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		msgs = append(msgs, msg)
+		index = append(index, i)
+	}
+	if len(msgs) == 0 {
+		return errs
+	}
+
+	err := kafkaWriter.WriteMessages(ctx, msgs...)
+	if err == nil {
+		return errs
+	}
+	span.RecordError(err)
+	log.Printf("Error publishing %d album created events to Kafka: %v", len(msgs), err)
+
+	// A synchronous writer reports failures per message; any other error applies to the whole batch
+	var writeErrs kafka.WriteErrors
+	perMessage := errors.As(err, &writeErrs) && len(writeErrs) == len(msgs)
+	for j, i := range index {
+		msgErr := err
+		if perMessage {
+			msgErr = writeErrs[j]
+		}
+		if msgErr == nil {
+			continue
+		}
+		if publishRetries.enqueue(albumCreatedTopic, msgs[j]) {
+			msgErr = fmt.Errorf("%w: %w", errPublishQueued, msgErr)
+		}
+		errs[i] = msgErr
+	}
+	return errs
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAlbumsBatchHandler(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter, originalRetries := db, kafkaWriter, publishRetries
+	db = mockDB
+	t.Cleanup(func() { db, kafkaWriter, publishRetries = originalDB, originalWriter, originalRetries })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/albums/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	const batch = `[
+		{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","format":"LP"},
+		{"title":"Kind of Blue","artist":"Miles Davis","price":24.99,"releaseYear":1959,"genre":"Jazz"}
+	]`
+	expectInserts := func() {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		prep := mock.ExpectPrepare("INSERT INTO albums")
+		prep.ExpectQuery().WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", []byte(nil)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+		prep.ExpectQuery().WithArgs("Kind of Blue", "Miles Davis", 2499, 1959, "Jazz", "", "", "", []byte(nil)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(22))
+		mock.ExpectCommit()
+	}
+
+	t.Run("Creates every album and publishes in one write", func(t *testing.T) {
+		writer := &recordingWriter{}
+		kafkaWriter = writer
+		expectInserts()
+
+		rr := post(batch)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var result BatchCreateResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		require.Len(t, result.Created, 2)
+		assert.Equal(t, "22", result.Created[1].ID)
+		assert.Equal(t, []BatchEventStatus{{AlbumID: "21", Status: eventStatusPublished}, {AlbumID: "22", Status: eventStatusPublished}}, result.Events)
+
+		assert.Equal(t, 1, writer.calls)
+		if assert.Len(t, writer.messages, 2) {
+			assert.Equal(t, "21", string(writer.messages[0].Key))
+			assert.Equal(t, "22", string(writer.messages[1].Key))
+		}
+	})
+
+	t.Run("Reports publish failures per event", func(t *testing.T) {
+		kafkaWriter = &recordingWriter{err: kafka.WriteErrors{nil, errors.New("message too large")}}
+		publishRetries = newPublishRetryBuffer(10, publishRetryWriter)
+		expectInserts()
+
+		rr := post(batch)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var result BatchCreateResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, 1, result.PublishFailures)
+		assert.Equal(t, eventStatusPublished, result.Events[0].Status)
+		assert.Equal(t, BatchEventStatus{AlbumID: "22", Status: eventStatusQueued, Error: "queued for retry: message too large"}, result.Events[1])
+		require.Equal(t, 1, publishRetries.queued())
+		assert.Equal(t, "22", string(publishRetries.pending[0].msg.Key))
+	})
+
+	t.Run("A failed insert rolls back the whole batch", func(t *testing.T) {
+		writer := &recordingWriter{}
+		kafkaWriter = writer
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		prep := mock.ExpectPrepare("INSERT INTO albums")
+		prep.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+		prep.ExpectQuery().WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		rr := post(batch)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Contains(t, rr.Body.String(), "album 1")
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, 0, writer.calls)
+	})
+
+	t.Run("Invalid batches are rejected before touching the database", func(t *testing.T) {
+		for _, body := range []string{
+			`[]`,
+			`{"title":"Nevermind"}`,
+			`[{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock"},{"title":"Bleach"}]`,
+			`[{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","upc":"720642442517"},` +
+				`{"title":"Bleach","artist":"Nirvana","price":14.99,"releaseYear":1989,"genre":"Rock","upc":"0720642442517"}]`,
+		} {
+			rr := post(body)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
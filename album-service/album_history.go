@@ -0,0 +1,26 @@
+// album_history.go - versioned album history kept by a database trigger, so an album can be shown
+// as it looked at any point in time (e.g. the price displayed when an order was placed)
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// findAlbumAsOf loads the version of an album that was current at asOf; sql.ErrNoRows when the album
+// didn't exist then. Albums created before history was enabled have no versions before that.
+func findAlbumAsOf(ctx context.Context, id string, asOf time.Time) (Album, error) {
+	var a Album
+	var albumID int
+	err := db.QueryRowContext(ctx, `
+		SELECT album_id, title, artist, price_cents, release_year, genre, COALESCE(format, '') FROM albums_history
+		WHERE album_id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)`, id, asOf).
+		Scan(&albumID, &a.Title, &a.Artist, &a.Price, &a.ReleaseYear, &a.Genre, &a.Format)
+	if err != nil {
+		return Album{}, err
+	}
+	a.ID = strconv.Itoa(albumID)
+	return a, nil
+}
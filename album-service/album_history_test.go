@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAlbumAsOf(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	asOf := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Returns the version current at the time", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums_history").WithArgs("7", asOf).
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "title", "artist", "price_cents", "release_year", "genre", "format"}).
+				AddRow(7, "Blue Train", "John Coltrane", 1299, 1957, "Jazz", "LP"))
+
+		rr := get("/api/albums/7?asOf=2024-05-01T12:00:00Z")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var a Album
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &a))
+		assert.Equal(t, Album{ID: "7", Title: "Blue Train", Artist: "John Coltrane", Price: 1299, ReleaseYear: 1957, Genre: "Jazz", Format: "LP"}, a)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Album that didn't exist yet", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums_history").WithArgs("7", asOf).WillReturnError(sql.ErrNoRows)
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/7?asOf=2024-05-01T12:00:00Z").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid timestamp", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/albums/7?asOf=yesterday").Code)
+	})
+}
@@ -0,0 +1,100 @@
+// album_identifiers.go - physical product identifiers. An album can carry the UPC printed under its
+// barcode and the label's catalog number, so warehouse scanners can resolve scanned stock to the
+// album with GET /api/albums/by-upc/:upc. A UPC belongs to at most one album.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errUPCLength = errors.New("upc must have 12 (UPC-A) or 13 (EAN-13) digits")
+	errUPCTaken  = errors.New("upc is already used by another album")
+)
+
+// albumUPCIndex is the unique index on albums.upc, named so its violations can be told apart
+const albumUPCIndex = "albums_upc_key"
+
+// normalizeUPC strips spaces and dashes and checks the check digit. Both 12-digit UPC-A and 13-digit
+// EAN-13 codes are accepted; an EAN-13 with a leading 0 is the same product as the UPC-A without it
+// (scanners report either), so it is stored as the UPC-A. "" stays "".
+func normalizeUPC(upc *string) error {
+	code := strings.NewReplacer(" ", "", "-", "").Replace(*upc)
+	if code == "" {
+		*upc = ""
+		return nil
+	}
+	if len(code) != 12 && len(code) != 13 {
+		return errUPCLength
+	}
+	sum := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		d := code[i]
+		if d < '0' || d > '9' {
+			return errUPCLength
+		}
+		// Digits are weighted 1, 3, 1, ... from the right, starting with the check digit
+		weight := 1
+		if (len(code)-1-i)%2 == 1 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	if sum%10 != 0 {
+		return fmt.Errorf("upc %s has a wrong check digit", code)
+	}
+	if len(code) == 13 && code[0] == '0' {
+		code = code[1:]
+	}
+	*upc = code
+	return nil
+}
+
+// normalizeAlbumIdentifiers normalizes the UPC and trims the catalog number of a to be written
+func normalizeAlbumIdentifiers(a *Album) error {
+	a.CatalogNumber = strings.TrimSpace(a.CatalogNumber)
+	return normalizeUPC(&a.UPC)
+}
+
+// isUPCConflict reports whether err is a write rejected because the UPC belongs to another album
+func isUPCConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == albumUPCIndex
+}
+
+// respondUPCConflict answers 409 for a UPC already used by another album
+func respondUPCConflict(c *gin.Context, upc string) {
+	c.JSON(http.StatusConflict, gin.H{"error": "upc " + upc + " is already used by another album"})
+}
+
+// getAlbumByUPC handles GET /api/albums/by-upc/:upc. The code is normalized like on writes, so a
+// UPC-A scanned as EAN-13 finds the album too.
+func getAlbumByUPC(c *gin.Context) {
+	upc := c.Param("upc")
+	if err := normalizeUPC(&upc); err != nil || upc == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	a, err := findAlbumWhere(c.Request.Context(), "upc = $1", upc)
+	if err == sql.ErrNoRows || (err == nil && !albumVisible(c, a)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if !wantsComputedFields(c) {
+		c.Header("ETag", versionETag(a.Version))
+	}
+	withComputedFields(c, &a)
+	respondJSON(c, http.StatusOK, a)
+}
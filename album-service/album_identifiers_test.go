@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeUPC(t *testing.T) {
+	for input, want := range map[string]string{
+		"720642442517":    "720642442517",
+		"0720642442517":   "720642442517", // UPC-A scanned as EAN-13
+		"7 20642-44251 7": "720642442517",
+		"4006381333931":   "4006381333931",
+		"":                "",
+	} {
+		upc := input
+		require.NoError(t, normalizeUPC(&upc), input)
+		assert.Equal(t, want, upc, input)
+	}
+	for _, input := range []string{"720642442518", "72064244251", "72064244251X", "00720642442517"} {
+		upc := input
+		assert.Error(t, normalizeUPC(&upc), input)
+	}
+}
+
+func TestAlbumIdentifiers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+
+	t.Run("Lookup by UPC", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE upc = \\$1").WithArgs("720642442517").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "720642442517", "DGC-24425", 2, 0, 0, "", "", nil, "published", nil))
+
+		req, _ := http.NewRequest("GET", "/api/albums/by-upc/0720642442517", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var a Album
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &a))
+		assert.Equal(t, "DGC-24425", a.CatalogNumber)
+		assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown and malformed UPCs are not found", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE upc = \\$1").WithArgs("4006381333931").WillReturnRows(sqlmock.NewRows(albumColumns))
+		for _, upc := range []string{"4006381333931", "12345"} {
+			req, _ := http.NewRequest("GET", "/api/albums/by-upc/"+upc, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusNotFound, rr.Code, upc)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A UPC belongs to one album", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").WithArgs("Bleach", "Nirvana", 1499, 1989, "Rock", "", "720642442517", "SP 34", "", "", sqlmock.AnyArg(), []byte(nil)).
+			WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: albumUPCIndex})
+		mock.ExpectRollback()
+
+		req, _ := http.NewRequest("POST", "/api/albums", bytes.NewBufferString(
+			`{"title":"Bleach","artist":"Nirvana","price":14.99,"releaseYear":1989,"genre":"Rock","upc":"0720642442517","catalogNumber":" SP 34 "}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,263 @@
+// album_patch.go - PATCH /api/albums/:id, updating only the fields present in the body
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AlbumPatch is a partial album update; nil fields are left unchanged. "" clears the format, UPC,
+// catalog number, release date and label, and [] clears the tracks.
+type AlbumPatch struct {
+	Title              *string                `json:"title" binding:"omitempty,min=1,max=100"`
+	Artist             *string                `json:"artist" binding:"omitempty,min=1,max=100"`
+	Price              *Cents                 `json:"price" binding:"omitempty,gt=0,lte=1000000"`
+	ReleaseYear        *int                   `json:"releaseYear" binding:"omitempty,releaseyear"`
+	Genre              *string                `json:"genre" binding:"omitempty,min=1,max=50"`
+	Format             *string                `json:"format" binding:"omitempty,max=50"`
+	UPC                *string                `json:"upc" binding:"omitempty,max=20"`
+	CatalogNumber      *string                `json:"catalogNumber" binding:"omitempty,max=50"`
+	ReleaseDate        *string                `json:"releaseDate"` // See validateReleaseDate
+	Label              *string                `json:"label" binding:"omitempty,max=200"`
+	Tracks             *[]Track               `json:"tracks" binding:"omitempty,max=200,dive"`
+	Attributes         map[string]interface{} `json:"attributes"` // Merged into the album's attributes; null removes one (see album_attributes.go)
+	PriceFloorOverride *PriceFloorOverride    `json:"priceFloorOverride,omitempty"`
+	PriceChangeReason  string                 `json:"priceChangeReason,omitempty" binding:"max=500"` // See price_history.go
+	Version            int                    `json:"version" binding:"gte=0"`                       // Alternative to If-Match (see album_version.go)
+}
+
+// setClause renders the SET list for the present fields, appending their values to args
+func (p AlbumPatch) setClause(args *[]interface{}) string {
+	var sets []string
+	add := func(column string, value interface{}) {
+		*args = append(*args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(*args)))
+	}
+	if p.Title != nil {
+		add("title", *p.Title)
+	}
+	if p.Artist != nil {
+		add("artist", *p.Artist)
+	}
+	if p.Price != nil {
+		add("price_cents", *p.Price)
+	}
+	if p.ReleaseYear != nil {
+		add("release_year", *p.ReleaseYear)
+	}
+	if p.Genre != nil {
+		add("genre", *p.Genre)
+	}
+	optional := func(column string, value *string) {
+		if value != nil {
+			*args = append(*args, *value)
+			sets = append(sets, fmt.Sprintf("%s = NULLIF($%d, '')", column, len(*args)))
+		}
+	}
+	optional("format", p.Format)
+	optional("upc", p.UPC)
+	optional("catalog_number", p.CatalogNumber)
+	optional("release_date", p.ReleaseDate)
+	optional("label", p.Label)
+	if p.Tracks != nil {
+		tracks, _ := marshalTracks(*p.Tracks)
+		add("tracks", tracks)
+	}
+	if p.Attributes != nil {
+		// Applied like mergeAttributes: jsonb_strip_nulls drops the removed keys, and no attributes
+		// are stored as NULL
+		patch, _ := json.Marshal(p.Attributes)
+		*args = append(*args, string(patch))
+		sets = append(sets, fmt.Sprintf("attributes = NULLIF(jsonb_strip_nulls(COALESCE(attributes, '{}'::jsonb) || $%d::jsonb), '{}'::jsonb)", len(*args)))
+	}
+	return strings.Join(sets, ", ")
+}
+
+// apply returns a with the patch applied
+func (p AlbumPatch) apply(a Album) Album {
+	if p.Title != nil {
+		a.Title = *p.Title
+	}
+	if p.Artist != nil {
+		a.Artist = *p.Artist
+	}
+	if p.Price != nil {
+		a.Price = *p.Price
+	}
+	if p.ReleaseYear != nil {
+		a.ReleaseYear = *p.ReleaseYear
+	}
+	if p.Genre != nil {
+		a.Genre = *p.Genre
+	}
+	if p.Format != nil {
+		a.Format = *p.Format
+	}
+	if p.UPC != nil {
+		a.UPC = *p.UPC
+	}
+	if p.CatalogNumber != nil {
+		a.CatalogNumber = *p.CatalogNumber
+	}
+	if p.ReleaseDate != nil {
+		a.ReleaseDate = *p.ReleaseDate
+	}
+	if p.Label != nil {
+		a.Label = *p.Label
+	}
+	if p.Tracks != nil {
+		a.Tracks = *p.Tracks
+	}
+	if p.Attributes != nil {
+		a.Attributes = mergeAttributes(a.Attributes, p.Attributes)
+	}
+	a.PriceFloorOverride = p.PriceFloorOverride
+	return a
+}
+
+// patchAlbum handles PATCH /api/albums/:id. The album is locked while the patch is applied, so the
+// price floor is checked against the genre and price the album will actually have, and the patch
+// only applies to the version it was based on.
+func patchAlbum(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+
+	var p AlbumPatch
+	if err := c.ShouldBindJSON(&p); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if p.Genre != nil {
+		if err := normalizeGenre(p.Genre); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if p.UPC != nil {
+		if err := normalizeUPC(p.UPC); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if p.CatalogNumber != nil {
+		*p.CatalogNumber = strings.TrimSpace(*p.CatalogNumber)
+	}
+	if p.ReleaseDate != nil {
+		if err := validateReleaseDate(*p.ReleaseDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if p.Label != nil {
+		*p.Label = strings.TrimSpace(*p.Label)
+	}
+	if err := normalizeAttributePatch(p.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	args := []interface{}{id}
+	set := p.setClause(&args)
+	if set == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body contains no fields to update"})
+		return
+	}
+	expected, ok := expectedAlbumVersion(c, p.Version)
+	if !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var current Album
+	var dbID int
+	var attributes []byte
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, title, artist, price_cents, release_year, genre, COALESCE(format, ''), COALESCE(upc, ''), COALESCE(catalog_number, ''), version, attributes FROM albums WHERE id = $1 FOR UPDATE", id).
+		Scan(&dbID, &current.Title, &current.Artist, &current.Price, &current.ReleaseYear, &current.Genre, &current.Format, &current.UPC, &current.CatalogNumber, &current.Version, &attributes)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if current.Version != expected {
+		respondVersionConflict(c, current.Version)
+		return
+	}
+	if err := unmarshalAttributes(attributes, &current.Attributes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Album has invalid attributes: " + err.Error()})
+		return
+	}
+	current.ID = strconv.Itoa(dbID)
+	updated := p.apply(current)
+
+	// The attributes are checked whenever they, the genre or the format change
+	if p.Attributes != nil || p.Genre != nil || p.Format != nil {
+		if err := validateAlbumAttributes(&updated); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Only repricing (or moving to a genre with a higher floor) is checked, so unrelated edits to an
+	// album already priced below the floor don't need an override
+	var floor Cents
+	if p.Price != nil || p.Genre != nil {
+		if floor, err = checkPriceFloor(updated); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if p.Price != nil {
+		if err := setPriceChangeContext(ctx, tx, priceSourcePatch, priceChangeReason(p.PriceChangeReason, p.PriceFloorOverride), c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+			return
+		}
+	}
+	if err := setAuditActor(ctx, tx, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+		return
+	}
+	saved, err := scanAlbum(tx.QueryRowContext(ctx, "UPDATE albums SET "+set+" WHERE id = $1 RETURNING "+albumRowColumns, args...))
+	if isUPCConflict(err) {
+		respondUPCConflict(c, updated.UPC)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+		return
+	}
+	if floor > 0 {
+		if err := recordPriceFloorOverride(ctx, tx, updated.ID, "patch", updated, floor, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to audit price floor override: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit update: " + err.Error()})
+		return
+	}
+	invalidateAlbums(ctx, saved.ID)
+
+	c.Header("ETag", versionETag(saved.Version))
+	respondJSON(c, http.StatusOK, saved)
+}
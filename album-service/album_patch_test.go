@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatchAlbumHandler(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalFloors := db, priceFloors
+	db = mockDB
+	priceFloors = priceFloorConfig{Default: 500}
+	t.Cleanup(func() { db, priceFloors = originalDB, originalFloors })
+
+	patch := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		req.Header.Set("If-Match", `"3"`)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectCurrent := func(price Cents) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, title, artist, price_cents, release_year, genre, COALESCE\\(format, ''\\), COALESCE\\(upc, ''\\), COALESCE\\(catalog_number, ''\\), version, attributes FROM albums WHERE id = \\$1 FOR UPDATE").
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", price, 1991, "Rock", "LP", "", "", 3, nil))
+	}
+	saved := func(price Cents) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+			AddRow(4, "Nevermind", "Nirvana", price, 1991, "Rock", "", "", "", 4, 0, 0, "1991-09-24", "DGC", []byte(`[{"position":1,"title":"Smells Like Teen Spirit"}]`), "published", nil)
+	}
+
+	t.Run("Updates only the given fields", func(t *testing.T) {
+		expectCurrent(1999)
+		mock.ExpectExec("set_config").WithArgs(priceSourcePatch, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET price_cents = \$2, format = NULLIF\(\$3, ''\) WHERE id = \$1 RETURNING id, title`).
+			WithArgs(4, 1250, "").
+			WillReturnRows(saved(1250))
+		mock.ExpectCommit()
+
+		rr := patch("/api/albums/4", `{"price":12.5,"format":""}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var a Album
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &a))
+		assert.Equal(t, Album{ID: "4", Title: "Nevermind", Artist: "Nirvana", Price: 1250, ReleaseYear: 1991, Genre: "Rock", Version: 4,
+			ReleaseDate: "1991-09-24", Label: "DGC", Tracks: []Track{{Position: 1, Title: "Smells Like Teen Spirit"}}, Status: "published"}, a, "the response is the saved album")
+		assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+	})
+
+	t.Run("Updates the release metadata", func(t *testing.T) {
+		expectCurrent(1999)
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET release_date = NULLIF\(\$2, ''\), label = NULLIF\(\$3, ''\), tracks = \$4 WHERE id = \$1`).
+			WithArgs(4, "1991-09-24", "DGC", []byte(`[{"position":1,"title":"Smells Like Teen Spirit"}]`)).
+			WillReturnRows(saved(1999))
+		mock.ExpectCommit()
+
+		rr := patch("/api/albums/4", `{"releaseDate":"1991-09-24","label":" DGC ","tracks":[{"position":1,"title":"Smells Like Teen Spirit"}]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		rr = patch("/api/albums/4", `{"releaseDate":"September 1991"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = patch("/api/albums/4", `{"tracks":[{"position":0,"title":"Smells Like Teen Spirit"}]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "tracks are validated like on create")
+	})
+
+	t.Run("Stale versions conflict", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, title").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 5, nil))
+		mock.ExpectRollback()
+
+		rr := patch("/api/albums/4", `{"title":"Bleach"}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"currentVersion":5`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A version is required", func(t *testing.T) {
+		req, _ := http.NewRequest("PATCH", "/api/albums/4", bytes.NewBufferString(`{"title":"Bleach"}`))
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusPreconditionRequired, rr.Code)
+	})
+
+	t.Run("Empty patch is rejected", func(t *testing.T) {
+		rr := patch("/api/albums/4", `{}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Invalid field values are rejected", func(t *testing.T) {
+		rr := patch("/api/albums/4", `{"price":0}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = patch("/api/albums/4", `{"title":""}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, title").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		rr := patch("/api/albums/4", `{"title":"Bleach"}`)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Repricing below the floor needs an override", func(t *testing.T) {
+		expectCurrent(1999)
+		mock.ExpectRollback()
+
+		rr := patch("/api/albums/4", `{"price":1}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Other fields of an album below the floor can be edited", func(t *testing.T) {
+		expectCurrent(100)
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET title = \$2 WHERE id = \$1`).WithArgs(4, "Bleach").
+			WillReturnRows(saved(100))
+		mock.ExpectCommit()
+
+		rr := patch("/api/albums/4", `{"title":"Bleach"}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Override is audited", func(t *testing.T) {
+		expectCurrent(1999)
+		mock.ExpectExec("set_config").WithArgs(priceSourcePatch, "Promo", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET price_cents").WithArgs(4, 100).WillReturnRows(saved(100))
+		mock.ExpectExec("INSERT INTO price_floor_overrides").
+			WithArgs("4", "patch", 100, 500, "Promo", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		rr := patch("/api/albums/4", `{"price":1,"priceFloorOverride":{"reason":"Promo"}}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
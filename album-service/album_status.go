@@ -0,0 +1,108 @@
+// album_status.go - album lifecycle. Albums are created as drafts, so a half-entered album isn't
+// visible to shoppers, and are published and archived by admins. Public reads (everything but admin
+// calls to /api) only see published albums; admins see every album and can filter by status.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// Album statuses
+const (
+	albumDraft     = "draft"
+	albumPublished = "published"
+	albumArchived  = "archived"
+)
+
+var albumStatuses = []string{albumDraft, albumPublished, albumArchived}
+
+// publicSurfaceKey marks requests to a public API surface (see storefront.go), which only sees
+// published albums whatever the caller's Client-Type
+const publicSurfaceKey = "publicSurface"
+
+// seesAllAlbums reports whether the caller may see albums that aren't published: admins, outside
+// the public surfaces
+func seesAllAlbums(c *gin.Context) bool {
+	return responseProfile(c) == profileAdmin && !c.GetBool(publicSurfaceKey)
+}
+
+// albumVisible reports whether the caller may see a
+func albumVisible(c *gin.Context, a Album) bool {
+	return a.Status == albumPublished || seesAllAlbums(c)
+}
+
+// visibleAlbumCondition returns the condition (" AND ...") limiting a query on albums, aliased as
+// alias, to the albums the caller may see
+func visibleAlbumCondition(c *gin.Context, alias string) string {
+	if seesAllAlbums(c) {
+		return ""
+	}
+	return " AND " + alias + "status = '" + albumPublished + "'"
+}
+
+// parseStatusFilter reads ?status= for admins. Public callers are limited to published albums.
+func parseStatusFilter(c *gin.Context) ([]string, error) {
+	if !seesAllAlbums(c) {
+		return []string{albumPublished}, nil
+	}
+	statuses := listing.QueryValues(c, "status")
+	for _, s := range statuses {
+		if !containsString(albumStatuses, s) {
+			return nil, fmt.Errorf("unknown status %q, expected one of %s", s, strings.Join(albumStatuses, ", "))
+		}
+	}
+	return statuses, nil
+}
+
+// transitionAlbum returns the handler moving an album to status, for POST /api/albums/:id/publish
+// and /archive. Any status can move to either; repeating a transition leaves the album unchanged.
+// Responds with the album.
+func transitionAlbum(status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+			return
+		}
+		defer tx.Rollback()
+
+		if err := setAuditActor(ctx, tx, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album status: " + err.Error()})
+			return
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE albums SET status = $1 WHERE id = $2 AND status <> $1", status, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album status: " + err.Error()})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit status change: " + err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			invalidateAlbums(ctx, c.Param("id"))
+			log.Printf("Album %s %s by %s", c.Param("id"), status, c.ClientIP())
+		}
+
+		a, err := findAlbum(ctx, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		c.Header("ETag", versionETag(a.Version))
+		respondJSON(c, http.StatusOK, a)
+	}
+}
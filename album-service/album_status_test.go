@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlbumStatusVisibility(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+	get := func(path, clientType string) *httptest.ResponseRecorder {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 1, 0, 0, "", "", nil, albumDraft, nil))
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", clientType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Drafts are hidden from the public", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/4", "").Code)
+		assert.Equal(t, http.StatusNotFound, get("/storefront/albums/4", "admin").Code, "the storefront is public for everyone")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admins see drafts", func(t *testing.T) {
+		rr := get("/api/albums/4", "admin")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"status":"draft"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Public revalidation only matches published albums", func(t *testing.T) {
+		mock.ExpectQuery("SELECT version FROM albums WHERE id = \\$1 AND status = 'published'").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		req, _ := http.NewRequest("GET", "/api/albums/4", nil)
+		req.Header.Set("If-None-Match", `"1"`)
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 1, 0, 0, "", "", nil, albumArchived, nil))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestParseStatusFilter(t *testing.T) {
+	parse := func(query, clientType string) (albumFilter, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/albums?"+query, nil)
+		c.Request.Header.Set("Client-Type", clientType)
+		return parseAlbumFilter(c)
+	}
+
+	f, err := parse("status=draft", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{albumPublished}, f.Statuses, "public callers can't ask for drafts")
+
+	f, err = parse("", "admin")
+	require.NoError(t, err)
+	assert.Empty(t, f.Statuses)
+
+	f, err = parse("status=draft,archived", "admin")
+	require.NoError(t, err)
+	var args []interface{}
+	assert.Equal(t, " WHERE a.status IN ('draft', 'archived')", f.whereClause(&args))
+
+	_, err = parse("status=deleted", "admin")
+	assert.Error(t, err)
+}
+
+func TestTransitionAlbum(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+	post := func(path, clientType string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Client-Type", clientType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Publish", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("UPDATE albums SET status = \\$1 WHERE id = \\$2 AND status <> \\$1").WithArgs(albumPublished, "4").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 0, 0, "", "", nil, albumPublished, nil))
+
+		rr := post("/api/albums/4/publish", "admin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"status":"published"`)
+		assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Archiving twice changes nothing", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("UPDATE albums SET status").WithArgs(albumArchived, "4").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 3, 0, 0, "", "", nil, albumArchived, nil))
+
+		rr := post("/api/albums/4/archive", "admin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"status":"archived"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("UPDATE albums SET status").WithArgs(albumPublished, "99").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("99").WillReturnRows(sqlmock.NewRows(albumColumns))
+
+		assert.Equal(t, http.StatusNotFound, post("/api/albums/99/publish", "admin").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admins only", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, post("/api/albums/4/publish", "").Code)
+	})
+}
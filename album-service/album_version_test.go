@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlbumVersions(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	const nevermind = `{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock"`
+	put := func(ifMatch, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/api/albums/4", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Detail ETag is the version", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", 3, 0, 0, "", "", nil, "published", nil))
+
+		req, _ := http.NewRequest("GET", "/api/albums/4", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+		assert.Contains(t, rr.Body.String(), `"version":3`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unchanged albums revalidate without a full read", func(t *testing.T) {
+		get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", path, nil)
+			req.Header.Set("If-None-Match", ifNoneMatch)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+		for _, path := range []string{"/api/albums/4", "/storefront/albums/4"} {
+			mock.ExpectQuery("SELECT version FROM albums WHERE id").WithArgs("4").
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+
+			rr := get(path, `"2", "3"`)
+			assert.Equal(t, http.StatusNotModified, rr.Code, path)
+			assert.Empty(t, rr.Body.String())
+			assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+			assert.NotEqual(t, "no-store", rr.Header().Get("Cache-Control"))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+
+		mock.ExpectQuery("SELECT version FROM albums WHERE id").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", 4, 0, 0, "", "", nil, "published", nil))
+		rr := get("/storefront/albums/4", `"3"`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Update with If-Match", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET .* WHERE id = \$9 AND version = \$10 RETURNING id, title`).
+			WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", "4", 3, []byte(nil), "", "", []byte(nil)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", 4, 0, 0, "", "", nil, "published", nil))
+		mock.ExpectCommit()
+
+		rr := put(`"3"`, nevermind+`}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+		assert.Contains(t, rr.Body.String(), `"version":4`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Update saves the release metadata", func(t *testing.T) {
+		const tracks = `[{"position":1,"title":"Smells Like Teen Spirit","durationSeconds":301}]`
+		savedRow := func() *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", 5, 0, 0, "1991-09-24", "DGC", []byte(tracks), "published", nil)
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery(`UPDATE albums SET .*release_date = NULLIF\(\$12, ''\), label = NULLIF\(\$13, ''\), tracks = \$14 WHERE`).
+			WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", "4", 4, []byte(nil), "1991-09-24", "DGC", []byte(tracks)).
+			WillReturnRows(savedRow())
+		mock.ExpectCommit()
+
+		rr := put(`"4"`, nevermind+`,"releaseDate":"1991-09-24","label":" DGC ","tracks":`+tracks+`}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		put := rr.Body.String()
+		assert.Contains(t, put, `"releaseDate":"1991-09-24","label":"DGC","tracks":`+tracks)
+
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("4").WillReturnRows(savedRow())
+		req, _ := http.NewRequest("GET", "/api/albums/4", nil)
+		req.Header.Set("Client-Type", "admin")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, put, rr.Body.String(), "GET returns what PUT answered")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Update validates the release date", func(t *testing.T) {
+		rr := put(`"4"`, nevermind+`,"releaseDate":"24/09/1991"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "releaseDate must be")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Stale version conflicts", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET").WillReturnRows(sqlmock.NewRows([]string{"version", "attributes"}))
+		mock.ExpectQuery("SELECT version FROM albums").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+		mock.ExpectRollback()
+
+		rr := put("", nevermind+`,"version":3}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"currentVersion":5`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET").WillReturnRows(sqlmock.NewRows([]string{"version", "attributes"}))
+		mock.ExpectQuery("SELECT version FROM albums").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusNotFound, put(`"1"`, nevermind+`}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid preconditions", func(t *testing.T) {
+		assert.Equal(t, http.StatusPreconditionRequired, put("", nevermind+`}`).Code)
+		assert.Equal(t, http.StatusBadRequest, put(`"abc"`, nevermind+`}`).Code)
+		assert.Equal(t, http.StatusBadRequest, put(`W/"3"`, nevermind+`}`).Code)
+		assert.Equal(t, http.StatusBadRequest, put(`"3"`, nevermind+`,"version":2}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,263 @@
+// api_keys.go - API keys for machine clients such as partner feed readers. Admins issue keys with
+// scopes and revoke them. A client sends its key as "Authorization: Bearer <key>" or in the X-API-Key
+// header. Only the SHA-256 of a key is stored, so the key itself is returned once, when it is
+// created. Routes accept keys through requireAPIKeyScope: the product feeds accept keys with the
+// feeds:read scope and, with FEED_REQUIRE_API_KEY=true, only serve requests carrying one. Store
+// services calling with Client-Type: internal need a key with the albums:internal scope (see
+// public_ids.go).
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyPrefix       = "ask_" // Marks album-store keys, e.g. for secret scanners
+	apiKeyPrefixLength = 12     // Characters of a key shown in listings to tell keys apart
+	apiKeyHeader       = "X-API-Key"
+	// apiKeyUsageInterval throttles the last_used_at updates of a busy key
+	apiKeyUsageInterval = time.Minute
+)
+
+// API key scopes
+const (
+	scopeFeedsRead      = "feeds:read"      // GET /feeds/products.xml and /feeds/products.csv
+	scopeAlbumsInternal = "albums:internal" // Client-Type: internal on album routes, for order-service
+)
+
+// apiKeyScopes are the scopes a key can be issued with
+var apiKeyScopes = []string{scopeFeedsRead, scopeAlbumsInternal}
+
+// Results of API key checks, the values of the result label of album_api_key_requests_total
+const (
+	apiKeyAccepted  = "accepted"
+	apiKeyMissing   = "missing"   // No key on a route requiring one
+	apiKeyInvalid   = "invalid"   // Unknown or revoked key
+	apiKeyForbidden = "forbidden" // Valid key without the route's scope
+	apiKeyError     = "error"     // The key could not be looked up
+)
+
+// feedRequireAPIKey is true when FEED_REQUIRE_API_KEY=true
+var feedRequireAPIKey bool
+
+// APIKey is a key issued to a machine client
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name" binding:"required,max=100"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	Prefix     string     `json:"prefix"`        // The first characters of the key
+	Key        string     `json:"key,omitempty"` // Only returned when the key is created
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"` // Updated at most once a minute
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+const apiKeyColumns = "id, name, array_to_string(scopes, ','), key_prefix, created_at, last_used_at, revoked_at"
+
+// loadAPIKeyConfig reads FEED_REQUIRE_API_KEY
+func loadAPIKeyConfig() error {
+	v := os.Getenv("FEED_REQUIRE_API_KEY")
+	if v == "" {
+		feedRequireAPIKey = false
+		return nil
+	}
+	required, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("FEED_REQUIRE_API_KEY %q must be true or false", v)
+	}
+	feedRequireAPIKey = required
+	return nil
+}
+
+// hashAPIKey returns the hex SHA-256 stored for a key. Keys are random, so no salt is needed.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey generates a key
+func newAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+// createAPIKey handles POST /api/api-keys (admin). The response is the only one carrying the key.
+func createAPIKey(c *gin.Context) {
+	var k APIKey
+	if err := c.ShouldBindJSON(&k); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	seen := map[string]bool{}
+	for _, scope := range k.Scopes {
+		if !containsString(apiKeyScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown scope %q, expected one of %s", scope, strings.Join(apiKeyScopes, ", "))})
+			return
+		}
+		if seen[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("scope %q is listed twice", scope)})
+			return
+		}
+		seen[scope] = true
+	}
+	key, err := newAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key: " + err.Error()})
+		return
+	}
+	k.Key, k.Prefix = key, key[:apiKeyPrefixLength]
+
+	err = db.QueryRowContext(c.Request.Context(),
+		"INSERT INTO api_keys (name, key_prefix, key_hash, scopes, created_by) VALUES ($1, $2, $3, string_to_array($4, ','), $5) RETURNING id, created_at",
+		k.Name, k.Prefix, hashAPIKey(key), strings.Join(k.Scopes, ","), c.ClientIP()).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key: " + err.Error()})
+		return
+	}
+	log.Printf("API key %d %q (%s) issued for %v by %s", k.ID, k.Name, k.Prefix, k.Scopes, c.ClientIP())
+	c.JSON(http.StatusCreated, k)
+}
+
+// getAPIKeys handles GET /api/api-keys (admin), revoked keys included
+func getAPIKeys(c *gin.Context) {
+	rows, err := db.QueryContext(c.Request.Context(), "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query API keys: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read API keys: " + err.Error()})
+			return
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read API keys: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeAPIKey handles POST /api/api-keys/:keyId/revoke (admin). Requests with the key fail from now on.
+func revokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	k, err := scanAPIKey(db.QueryRowContext(c.Request.Context(),
+		"UPDATE api_keys SET revoked_at = NOW(), revoked_by = $2 WHERE id = $1 AND revoked_at IS NULL RETURNING "+apiKeyColumns,
+		id, c.ClientIP()))
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRowContext(c.Request.Context(), "SELECT EXISTS (SELECT 1 FROM api_keys WHERE id = $1)", id).Scan(&exists); err == nil && exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "API key has already been revoked"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key: " + err.Error()})
+		return
+	}
+	log.Printf("API key %d %q (%s) revoked by %s", k.ID, k.Name, k.Prefix, c.ClientIP())
+	c.JSON(http.StatusOK, k)
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &scopes, &k.Prefix, &k.CreatedAt, &lastUsed, &revoked); err != nil {
+		return k, err
+	}
+	k.Scopes = strings.Split(scopes, ",")
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		k.RevokedAt = &revoked.Time
+	}
+	return k, nil
+}
+
+// requestAPIKey returns the key sent with the request, "" when there is none
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// requireAPIKeyScope authenticates the request's API key and checks that it has the scope. Requests
+// without a key pass unless required reports true.
+func requireAPIKeyScope(scope string, required func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if checkAPIKeyScope(c, scope, required()) {
+			c.Next()
+		}
+	}
+}
+
+// checkAPIKeyScope is requireAPIKeyScope for one request: it reports whether the request may go on,
+// and answers it otherwise
+func checkAPIKeyScope(c *gin.Context, scope string, required bool) bool {
+	reject := func(result string, status int, message string) bool {
+		apiKeyRequests.WithLabelValues(scope, result).Inc()
+		c.AbortWithStatusJSON(status, gin.H{"error": message})
+		return false
+	}
+	key := requestAPIKey(c)
+	if key == "" {
+		if required {
+			c.Header("WWW-Authenticate", `Bearer realm="album-service"`)
+			return reject(apiKeyMissing, http.StatusUnauthorized, "An API key with the "+scope+" scope is required")
+		}
+		return true
+	}
+
+	ctx := c.Request.Context()
+	k, err := scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", hashAPIKey(key)))
+	switch {
+	case err == sql.ErrNoRows || (err == nil && k.RevokedAt != nil):
+		c.Header("WWW-Authenticate", `Bearer realm="album-service", error="invalid_token"`)
+		return reject(apiKeyInvalid, http.StatusUnauthorized, "Invalid API key")
+	case err != nil:
+		return reject(apiKeyError, http.StatusInternalServerError, "Failed to check API key: "+err.Error())
+	case !containsString(k.Scopes, scope):
+		return reject(apiKeyForbidden, http.StatusForbidden, "API key lacks the "+scope+" scope")
+	}
+
+	if k.LastUsedAt == nil || time.Since(*k.LastUsedAt) >= apiKeyUsageInterval {
+		if _, err := db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", k.ID); err != nil {
+			log.Printf("Failed to record use of API key %d: %v", k.ID, err)
+		}
+	}
+	apiKeyRequests.WithLabelValues(scope, apiKeyAccepted).Inc()
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capturedArg matches any argument and remembers it
+type capturedArg struct{ value driver.Value }
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+var apiKeyRowColumns = []string{"id", "name", "scopes", "key_prefix", "created_at", "last_used_at", "revoked_at"}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Create returns the key once and stores its hash", func(t *testing.T) {
+		prefix, hash := &capturedArg{}, &capturedArg{}
+		mock.ExpectQuery("INSERT INTO api_keys").
+			WithArgs("Shopping partner", prefix, hash, "feeds:read", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, created))
+
+		rr := send("POST", "/api/v1/api-keys", `{"name": "Shopping partner", "scopes": ["feeds:read"]}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var k APIKey
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &k))
+		assert.Equal(t, 7, k.ID)
+		assert.True(t, strings.HasPrefix(k.Key, apiKeyPrefix), k.Key)
+		assert.Equal(t, k.Key[:apiKeyPrefixLength], k.Prefix)
+		assert.Equal(t, k.Prefix, prefix.value)
+		assert.Equal(t, hashAPIKey(k.Key), hash.value, "only the hash is stored")
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	})
+
+	t.Run("Create rejects unknown and repeated scopes", func(t *testing.T) {
+		rr := send("POST", "/api/v1/api-keys", `{"name": "Partner", "scopes": ["albums:write"]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `unknown scope \"albums:write\"`)
+
+		rr = send("POST", "/api/v1/api-keys", `{"name": "Partner", "scopes": ["feeds:read", "feeds:read"]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = send("POST", "/api/v1/api-keys", `{"name": "Partner", "scopes": []}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, name, array_to_string\\(scopes, ','\\), key_prefix").
+			WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).
+				AddRow(7, "Shopping partner", "feeds:read", "ask_0123abcd", created, created, nil).
+				AddRow(8, "Old partner", "feeds:read", "ask_4567ef01", created, nil, created))
+
+		rr := send("GET", "/api/v1/api-keys", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var keys []APIKey
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+		require.Len(t, keys, 2)
+		assert.Equal(t, []string{"feeds:read"}, keys[0].Scopes)
+		assert.Empty(t, keys[0].Key)
+		assert.NotNil(t, keys[0].LastUsedAt)
+		assert.NotNil(t, keys[1].RevokedAt)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE api_keys SET revoked_at = NOW\\(\\)").WithArgs(7, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).AddRow(7, "Shopping partner", "feeds:read", "ask_0123abcd", created, nil, created))
+		rr := send("POST", "/api/v1/api-keys/7/revoke", "")
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		mock.ExpectQuery("UPDATE api_keys SET revoked_at = NOW\\(\\)").WithArgs(7, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(apiKeyRowColumns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		rr = send("POST", "/api/v1/api-keys/7/revoke", "")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		mock.ExpectQuery("UPDATE api_keys SET revoked_at = NOW\\(\\)").WithArgs(99, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(apiKeyRowColumns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(99).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		rr = send("POST", "/api/v1/api-keys/99/revoke", "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Admin only", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/v1/api-keys", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductFeedAPIKeys(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalFeeds := db, catalogFeeds
+	db, catalogFeeds = mockDB, &catalogFeed{}
+	t.Cleanup(func() {
+		db, catalogFeeds = originalDB, originalFeeds
+		feedRequireAPIKey = false
+	})
+
+	mock.ExpectQuery("SELECT a.id, a.title").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "availability"}).
+			AddRow(1, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "in_stock"))
+	require.NoError(t, catalogFeeds.regenerate(context.Background(), mockDB, testFeedConfig))
+
+	const key = "ask_0123456789abcdef"
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectKey := func(scopes string, lastUsed, revoked interface{}) {
+		mock.ExpectQuery("FROM api_keys WHERE key_hash = \\$1").WithArgs(hashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).AddRow(7, "Shopping partner", scopes, "ask_01234567", time.Now(), lastUsed, revoked))
+	}
+
+	t.Run("Keys are optional by default", func(t *testing.T) {
+		rr := get("/feeds/products.xml")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "public, max-age=300", rr.Header().Get("Cache-Control"))
+	})
+
+	t.Run("Accepted key records its use", func(t *testing.T) {
+		expectKey("feeds:read", nil, nil)
+		mock.ExpectExec("UPDATE api_keys SET last_used_at = NOW\\(\\)").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+		rr := get("/feeds/products.csv", "Authorization", "Bearer "+key)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		// A key used a moment ago is not written again
+		expectKey("feeds:read", time.Now(), nil)
+		rr = get("/feeds/products.csv", apiKeyHeader, key)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("Revoked and unknown keys are rejected", func(t *testing.T) {
+		expectKey("feeds:read", nil, time.Now())
+		rr := get("/feeds/products.xml", apiKeyHeader, key)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+
+		mock.ExpectQuery("FROM api_keys WHERE key_hash = \\$1").WithArgs(hashAPIKey(key)).WillReturnRows(sqlmock.NewRows(apiKeyRowColumns))
+		rr = get("/feeds/products.xml", apiKeyHeader, key)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Key without the scope is forbidden", func(t *testing.T) {
+		expectKey("reports:read", nil, nil)
+		rr := get("/feeds/products.xml", apiKeyHeader, key)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Required keys", func(t *testing.T) {
+		feedRequireAPIKey = true
+		rr := get("/feeds/products.xml")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, `Bearer realm="album-service"`, rr.Header().Get("WWW-Authenticate"))
+
+		expectKey("feeds:read", time.Now(), nil)
+		rr = get("/feeds/products.xml", apiKeyHeader, key)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "private, max-age=300", rr.Header().Get("Cache-Control"))
+
+		rr = get("/sitemap.xml")
+		assert.Equal(t, http.StatusOK, rr.Code, "the sitemap stays open to crawlers")
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadAPIKeyConfig(t *testing.T) {
+	t.Cleanup(func() { feedRequireAPIKey = false })
+
+	t.Setenv("FEED_REQUIRE_API_KEY", "true")
+	require.NoError(t, loadAPIKeyConfig())
+	assert.True(t, feedRequireAPIKey)
+
+	t.Setenv("FEED_REQUIRE_API_KEY", "")
+	require.NoError(t, loadAPIKeyConfig())
+	assert.False(t, feedRequireAPIKey)
+
+	t.Setenv("FEED_REQUIRE_API_KEY", "partners")
+	assert.EqualError(t, loadAPIKeyConfig(), `FEED_REQUIRE_API_KEY "partners" must be true or false`)
+}
@@ -0,0 +1,187 @@
+// api_versions.go - versioned REST API. The routes are served under /api/v1 and, for clients written
+// before versioning, under the unversioned /api, which is deprecated: its responses carry the
+// Deprecation header, a Sunset header once a removal date is set (API_UNVERSIONED_SUNSET), and a Link
+// to the same resource under /api/v1. Breaking changes go into a new version, next to the old one,
+// which is deprecated the same way.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"album-store/jsonnaming"
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is one prefix the API routes are served under
+type apiVersion struct {
+	Prefix     string
+	Successor  string    // Prefix of the version replacing this one; "" for current versions
+	Deprecated time.Time // When the version was deprecated
+	Sunset     time.Time // When it will be removed; zero until a date is set
+}
+
+// unversionedAPISunset is when /api will be removed, from API_UNVERSIONED_SUNSET
+var unversionedAPISunset time.Time
+
+// apiVersions returns the versions of the API, current first
+func apiVersions() []apiVersion {
+	return []apiVersion{
+		{Prefix: "/api/v1"},
+		{Prefix: "/api", Successor: "/api/v1", Deprecated: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), Sunset: unversionedAPISunset},
+	}
+}
+
+// loadAPIVersions reads API_UNVERSIONED_SUNSET, a date (2027-06-30) or RFC 3339 time
+func loadAPIVersions() error {
+	v := os.Getenv("API_UNVERSIONED_SUNSET")
+	if v == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			unversionedAPISunset = t
+			return nil
+		}
+	}
+	return fmt.Errorf("API_UNVERSIONED_SUNSET %q must be a date such as 2027-06-30 or an RFC 3339 time", v)
+}
+
+// registerAPI adds the API routes under every version; wrap decorates each handler (tracing in main)
+func registerAPI(router gin.IRouter, wrap func(gin.HandlerFunc, string) gin.HandlerFunc) {
+	registerValidationRules() // Before any body is bound (see validation_errors.go)
+	for _, v := range apiVersions() {
+		api := router.Group(v.Prefix)
+		if v.Successor != "" {
+			api.Use(deprecatedAPIVersion(v))
+		}
+		api.Use(jsonnaming.Middleware(v.Prefix + "/albums/export")) // camelCase or snake_case bodies (see album-store/jsonnaming)
+		api.Use(failFastWhileDBDown())                              // 503 while the database is down (see circuit_breaker.go)
+		registerAPIRoutes(api, wrap)
+	}
+}
+
+// deprecatedAPIVersion announces the deprecation of v on each response (RFC 9745 and RFC 8594) and
+// counts the requests still made to it
+func deprecatedAPIVersion(v apiVersion) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", v.Deprecated.Unix())
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		if !v.Sunset.IsZero() {
+			c.Header("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		successor := v.Successor + strings.TrimPrefix(c.Request.URL.Path, v.Prefix)
+		c.Writer.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		c.Next()
+		deprecatedAPIRequests.WithLabelValues(v.Prefix, c.Request.Method, c.FullPath()).Inc()
+	}
+}
+
+// registerAPIRoutes adds the routes of one API version
+func registerAPIRoutes(api *gin.RouterGroup, wrap func(gin.HandlerFunc, string) gin.HandlerFunc) {
+	albums := api.Group("/albums")
+	albums.Use(authenticateInternalClient(), decodeAlbumIDParam()) // Public album IDs (see public_ids.go)
+	{
+		// Cache policies are declared per route class (see cache.go)
+		albums.GET("", withCachePolicy(cachePublicList), wrap(getAllAlbums, "getAllAlbums"))
+		albums.GET("/facets", withCachePolicy(cachePublicList), wrap(getAlbumFacets, "getAlbumFacets"))
+		albums.GET("/attributes", withCachePolicy(cachePublicList), wrap(getAttributeSchemas, "getAttributeSchemas"))
+		albums.GET("/by-upc/:upc", withCachePolicy(cacheDetail), wrap(getAlbumByUPC, "getAlbumByUPC"))
+		albums.GET("/by-decade", withCachePolicy(cachePublicList), wrap(getAlbumsByDecade, "getAlbumsByDecade"))
+		albums.GET("/:id", withCachePolicy(cacheDetail), wrap(getAlbum, "getAlbum"))
+		albums.POST("/:id/view", withCachePolicy(cacheNoStore), wrap(recordAlbumView, "recordAlbumView"))
+		albums.GET("/:id/effective-price", withCachePolicy(cacheDetail), wrap(getEffectivePrice, "getEffectivePrice"))
+		albums.GET("/:id/related", withCachePolicy(cachePublicList), wrap(getRelatedAlbums, "getRelatedAlbums"))
+		albums.GET("/:id/reviews", withCachePolicy(cachePublicList), wrap(getReviews, "getReviews"))
+		albums.POST("/:id/reviews", withCachePolicy(cacheNoStore), wrap(createReview, "createReview"))
+
+		// Editors propose catalog changes for admins to approve (see pending_changes.go)
+		editorRoutes := albums.Group("")
+		editorRoutes.Use(withCachePolicy(cacheNoStore), requireCatalogEditor())
+		{
+			editorRoutes.GET("/changes", wrap(getPendingChanges, "getPendingChanges"))
+			editorRoutes.POST("/changes", wrap(proposeAlbumCreate, "proposeAlbumCreate"))
+			editorRoutes.POST("/:id/changes", wrap(proposeAlbumUpdate, "proposeAlbumUpdate"))
+		}
+
+		// Group routes requiring admin privileges
+		adminRoutes := albums.Group("")
+		adminRoutes.Use(withCachePolicy(cacheNoStore), requireAdmin()) // Apply admin check middleware
+		{
+			adminRoutes.POST("", wrap(createAlbum, "createAlbum"))
+			adminRoutes.POST("/batch", wrap(createAlbumsBatch, "createAlbumsBatch"))
+			adminRoutes.GET("/export", wrap(exportAlbums, "exportAlbums"))
+			adminRoutes.PUT("/:id", wrap(updateAlbum, "updateAlbum"))
+			adminRoutes.PATCH("/:id", wrap(patchAlbum, "patchAlbum"))
+			adminRoutes.DELETE("/:id", wrap(deleteAlbum, "deleteAlbum"))
+			adminRoutes.POST("/:id/publish", wrap(transitionAlbum(albumPublished), "publishAlbum"))
+			adminRoutes.POST("/:id/archive", wrap(transitionAlbum(albumArchived), "archiveAlbum"))
+			adminRoutes.POST("/import/discogs", wrap(previewDiscogsImport, "previewDiscogsImport"))
+			adminRoutes.POST("/import/discogs/:importId/confirm", wrap(confirmDiscogsImport, "confirmDiscogsImport"))
+			adminRoutes.GET("/price-proposals", wrap(getPriceProposals, "getPriceProposals"))
+			adminRoutes.POST("/price-proposals/:proposalId/approve", wrap(approvePriceProposal, "approvePriceProposal"))
+			adminRoutes.POST("/price-proposals/:proposalId/reject", wrap(rejectPriceProposal, "rejectPriceProposal"))
+			adminRoutes.POST("/changes/:changeId/approve", wrap(approvePendingChange, "approvePendingChange"))
+			adminRoutes.POST("/changes/:changeId/reject", wrap(rejectPendingChange, "rejectPendingChange"))
+			adminRoutes.GET("/:id/price-history", wrap(getPriceHistory, "getPriceHistory"))
+			adminRoutes.GET("/:id/audit", wrap(getAlbumAudit, "getAlbumAudit"))
+			adminRoutes.GET("/promotions", wrap(getPromotions, "getPromotions"))
+			adminRoutes.POST("/promotions", wrap(createPromotion, "createPromotion"))
+			adminRoutes.POST("/promotions/:promotionId/end", wrap(endPromotion, "endPromotion"))
+			adminRoutes.DELETE("/:id/reviews/:reviewId", wrap(deleteReview, "deleteReview"))
+			adminRoutes.GET("/cold-storage", wrap(getColdAlbums, "getColdAlbums"))
+			adminRoutes.POST("/cold-storage/:id/restore", wrap(restoreColdAlbum, "restoreColdAlbum"))
+		}
+	}
+
+	artists := api.Group("/artists")
+	{
+		artists.GET("", withCachePolicy(cachePublicList), wrap(getArtists, "getArtists"))
+		artists.GET("/:id", withCachePolicy(cacheDetail), wrap(getArtist, "getArtist"))
+		artists.GET("/:id/summary", withCachePolicy(cachePublicList), wrap(getArtistSummary, "getArtistSummary"))
+
+		adminArtists := artists.Group("")
+		adminArtists.Use(withCachePolicy(cacheNoStore), requireAdmin())
+		{
+			adminArtists.POST("", wrap(createArtist, "createArtist"))
+			adminArtists.PUT("/:id", wrap(updateArtist, "updateArtist"))
+			adminArtists.DELETE("/:id", wrap(deleteArtist, "deleteArtist"))
+		}
+	}
+
+	genresGroup := api.Group("/genres")
+	{
+		genresGroup.GET("", withCachePolicy(cachePublicList), wrap(getGenres, "getGenres"))
+		genresGroup.POST("", withCachePolicy(cacheNoStore), requireAdmin(), wrap(createGenre, "createGenre"))
+	}
+
+	// Webhook subscriptions to catalog changes (see webhooks.go)
+	webhooks := api.Group("/webhooks")
+	webhooks.Use(withCachePolicy(cacheNoStore), requireAdmin())
+	{
+		webhooks.GET("", wrap(getWebhooks, "getWebhooks"))
+		webhooks.POST("", wrap(createWebhook, "createWebhook"))
+		webhooks.DELETE("/:webhookId", wrap(deleteWebhook, "deleteWebhook"))
+		webhooks.GET("/:webhookId/deliveries", wrap(getWebhookDeliveries, "getWebhookDeliveries"))
+	}
+
+	// API keys for machine clients (see api_keys.go)
+	apiKeys := api.Group("/api-keys")
+	apiKeys.Use(withCachePolicy(cacheNoStore), requireAdmin())
+	{
+		apiKeys.GET("", wrap(getAPIKeys, "getAPIKeys"))
+		apiKeys.POST("", wrap(createAPIKey, "createAPIKey"))
+		apiKeys.POST("/:keyId/revoke", wrap(revokeAPIKey, "revokeAPIKey"))
+	}
+
+	// Sales reports (admin)
+	analytics := api.Group("/analytics")
+	analytics.Use(withCachePolicy(cacheNoStore), requireAdmin())
+	{
+		analytics.GET("/daily-sales", wrap(getDailySales, "getDailySales"))
+	}
+}
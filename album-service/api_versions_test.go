@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIVersions(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalSunset := db, unversionedAPISunset
+	db = mockDB
+	t.Cleanup(func() { db, unversionedAPISunset = originalDB, originalSunset })
+
+	unversionedAPISunset = time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+	versioned := gin.New()
+	registerAPI(versioned, func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 0.0, 0, "", "", nil, "published", nil))
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		versioned.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("v1 is current", func(t *testing.T) {
+		rr := get("/api/v1/albums/4")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"title":"Nevermind"`)
+		assert.Empty(t, rr.Header().Get("Deprecation"))
+		assert.Empty(t, rr.Header().Get("Sunset"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("The unversioned API is deprecated", func(t *testing.T) {
+		rr := get("/api/albums/4")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"title":"Nevermind"`)
+		assert.Regexp(t, `^@\d+$`, rr.Header().Get("Deprecation"))
+		assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", rr.Header().Get("Sunset"))
+		assert.Equal(t, `</api/v1/albums/4>; rel="successor-version"`, rr.Header().Get("Link"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLoadAPIVersions(t *testing.T) {
+	original := unversionedAPISunset
+	t.Cleanup(func() { unversionedAPISunset = original })
+
+	t.Setenv("API_UNVERSIONED_SUNSET", "2027-06-30")
+	require.NoError(t, loadAPIVersions())
+	assert.Equal(t, time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC), unversionedAPISunset)
+
+	t.Setenv("API_UNVERSIONED_SUNSET", "2027-06-30T12:00:00Z")
+	require.NoError(t, loadAPIVersions())
+	assert.Equal(t, 12, unversionedAPISunset.Hour())
+
+	t.Setenv("API_UNVERSIONED_SUNSET", "next summer")
+	assert.Error(t, loadAPIVersions())
+}
@@ -0,0 +1,308 @@
+// artists.go - artists as their own entity, with /api/artists endpoints. Albums keep the artist name
+// in albums.artist; a trigger links them to the artist with that name through albums.artist_id, so
+// every existing write path stays unchanged.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Artist is an artist with the number of albums linked to it
+type Artist struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	AlbumCount int       `json:"albumCount"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ArtistInput is the body of POST and PUT /api/artists
+type ArtistInput struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+const artistSelect = `SELECT ar.id, ar.name, ar.created_at, (SELECT COUNT(*) FROM albums a WHERE a.artist_id = ar.id) FROM artists ar`
+
+func scanArtist(row interface{ Scan(...interface{}) error }) (Artist, error) {
+	var ar Artist
+	var id int
+	if err := row.Scan(&id, &ar.Name, &ar.CreatedAt, &ar.AlbumCount); err != nil {
+		return Artist{}, err
+	}
+	ar.ID = strconv.Itoa(id)
+	return ar, nil
+}
+
+func findArtist(ctx context.Context, id int) (Artist, error) {
+	return scanArtist(db.QueryRowContext(ctx, artistSelect+" WHERE ar.id = $1", id))
+}
+
+// getArtists handles GET /api/artists, optionally filtered by ?q= (case-insensitive name substring)
+func getArtists(c *gin.Context) {
+	query, args := artistSelect, []interface{}{}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query += " WHERE ar.name ILIKE '%' || $1 || '%'"
+		args = append(args, q)
+	}
+	rows, err := db.QueryContext(c.Request.Context(), query+" ORDER BY lower(ar.name), ar.id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query artists: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	artists := []Artist{}
+	for rows.Next() {
+		ar, err := scanArtist(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read artists: " + err.Error()})
+			return
+		}
+		artists = append(artists, ar)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read artists: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, artists)
+}
+
+// getArtist handles GET /api/artists/:id; the artist's albums are listed by GET /api/albums?artistId=
+func getArtist(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	ar, err := findArtist(c.Request.Context(), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ar)
+}
+
+// ArtistSummary is everything an artist landing page shows, from GET /api/artists/:name/summary
+type ArtistSummary struct {
+	ID                  string               `json:"id"`
+	Name                string               `json:"name"`
+	AlbumCount          int                  `json:"albumCount"`
+	AveragePrice        *Cents               `json:"averagePrice,omitempty"` // Absent, like the release years, when the artist has no albums
+	EarliestReleaseYear *int                 `json:"earliestReleaseYear,omitempty"`
+	LatestReleaseYear   *int                 `json:"latestReleaseYear,omitempty"`
+	Availability        ArtistAvailability   `json:"availability"`
+	Albums              []ArtistSummaryAlbum `json:"albums"` // Oldest release first
+}
+
+// ArtistAvailability counts an artist's albums by availability (see availabilityExpr)
+type ArtistAvailability struct {
+	InStock    int `json:"inStock"`
+	OutOfStock int `json:"outOfStock"`
+	Unknown    int `json:"unknown"` // No inventory record
+}
+
+// ArtistSummaryAlbum is an album of the summary with its availability
+type ArtistSummaryAlbum struct {
+	Album
+	Availability string `json:"availability"`
+}
+
+// artistSummaryQuery aggregates an artist's albums and their stock in one pass. $1 is the name;
+// when no artist has that name and $2 is set, the artist with ID $2 is used instead. visible is the
+// caller's visibleAlbumCondition for the albums, aliased as a.
+func artistSummaryQuery(visible string) string {
+	return `
+	WITH artist AS (
+		SELECT id, name FROM artists WHERE lower(name) = lower($1)
+		UNION ALL
+		SELECT id, name FROM artists WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM artists WHERE lower(name) = lower($1))
+	)
+	SELECT ar.id, ar.name, COUNT(a.id), ROUND(AVG(a.price_cents))::bigint, MIN(a.release_year), MAX(a.release_year),
+		COUNT(a.id) FILTER (WHERE i.quantity_available > 0),
+		COUNT(a.id) FILTER (WHERE i.quantity_available <= 0),
+		COUNT(a.id) FILTER (WHERE i.album_id IS NULL),
+		COALESCE(json_agg(json_build_object(
+			'id', a.id::text, 'title', a.title, 'artist', a.artist, 'price', round(a.price_cents / 100.0, 2), 'releaseYear', a.release_year,
+			'genre', a.genre, 'format', COALESCE(a.format, ''), 'version', a.version, 'availability', ` + availabilityExpr + `
+		) ORDER BY a.release_year, a.id) FILTER (WHERE a.id IS NOT NULL), '[]')
+	FROM artist ar
+	LEFT JOIN albums a ON a.artist_id = ar.id` + visible + `
+	` + availabilityJoin + `
+	GROUP BY ar.id, ar.name`
+}
+
+// getArtistSummary handles GET /api/artists/:name/summary. The artist is matched by name,
+// ignoring case; names that can't appear in a path (e.g. "AC/DC") can be given as the artist ID.
+// Public callers only see the artist's published albums.
+func getArtistSummary(c *gin.Context) {
+	// gin needs the same wildcard name as the other /api/artists/:id routes; here it holds a name
+	name := c.Param("id")
+	var fallbackID interface{}
+	if id, err := strconv.Atoi(name); err == nil {
+		fallbackID = id
+	}
+
+	var summary ArtistSummary
+	var id int
+	var averagePrice sql.Null[Cents]
+	var earliest, latest sql.NullInt64
+	var albumsJSON []byte
+	err := db.QueryRowContext(c.Request.Context(), artistSummaryQuery(visibleAlbumCondition(c, "a.")), name, fallbackID).Scan(
+		&id, &summary.Name, &summary.AlbumCount, &averagePrice, &earliest, &latest,
+		&summary.Availability.InStock, &summary.Availability.OutOfStock, &summary.Availability.Unknown, &albumsJSON)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if err := json.Unmarshal(albumsJSON, &summary.Albums); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read artist albums: " + err.Error()})
+		return
+	}
+
+	summary.ID = strconv.Itoa(id)
+	if averagePrice.Valid {
+		summary.AveragePrice = &averagePrice.V
+	}
+	if earliest.Valid && latest.Valid {
+		first, last := int(earliest.Int64), int(latest.Int64)
+		summary.EarliestReleaseYear, summary.LatestReleaseYear = &first, &last
+	}
+	respondJSON(c, http.StatusOK, summary)
+}
+
+// createArtist handles POST /api/artists (admin)
+func createArtist(c *gin.Context) {
+	var in ArtistInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	ar := Artist{Name: strings.TrimSpace(in.Name)}
+	var id int
+	err := db.QueryRowContext(c.Request.Context(),
+		"INSERT INTO artists (name) VALUES ($1) ON CONFLICT ((lower(name))) DO NOTHING RETURNING id, created_at", ar.Name).
+		Scan(&id, &ar.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "An artist with this name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create artist: " + err.Error()})
+		return
+	}
+	ar.ID = strconv.Itoa(id)
+	c.JSON(http.StatusCreated, ar)
+}
+
+// updateArtist handles PUT /api/artists/:id (admin). Renaming also renames the artist on its albums.
+func updateArtist(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	var in ArtistInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	name := strings.TrimSpace(in.Name)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM artists WHERE lower(name) = lower($1) AND id <> $2)", name, id).Scan(&taken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "An artist with this name already exists"})
+		return
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE artists SET name = $2 WHERE id = $1", id, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update artist: " + err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	if err := setAuditActor(ctx, tx, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update artist: " + err.Error()})
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE albums SET artist = $2 WHERE artist_id = $1", id, name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename artist on albums: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit update: " + err.Error()})
+		return
+	}
+	invalidateAllAlbums(ctx) // Renames are rare; flushing beats listing the artist's albums
+
+	ar, err := findArtist(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ar)
+}
+
+// deleteArtist handles DELETE /api/artists/:id (admin). Artists that still have albums can't be deleted.
+func deleteArtist(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+
+	res, err := db.ExecContext(ctx,
+		"DELETE FROM artists WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM albums WHERE artist_id = $1)", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete artist: " + err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// Nothing deleted: tell a missing artist from one that still has albums
+	ar, err := findArtist(ctx, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "Artist still has albums", "albumCount": ar.AlbumCount})
+}
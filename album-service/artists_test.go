@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtistHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	artistColumns := []string{"id", "name", "created_at", "count"}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("FROM artists ar WHERE ar.name ILIKE").WithArgs("nir").
+			WillReturnRows(sqlmock.NewRows(artistColumns).AddRow(1, "Nirvana", created, 3))
+
+		rr := send("GET", "/api/artists?q=nir", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var artists []Artist
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &artists))
+		assert.Equal(t, []Artist{{ID: "1", Name: "Nirvana", AlbumCount: 3, CreatedAt: created}}, artists)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Get unknown artist", func(t *testing.T) {
+		mock.ExpectQuery("FROM artists ar WHERE ar.id").WithArgs(9).WillReturnError(sql.ErrNoRows)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/api/artists/9", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO artists").WithArgs("Miles Davis").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, created))
+		rr := send("POST", "/api/artists", `{"name":" Miles Davis "}`)
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"name":"Miles Davis"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create duplicate", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO artists").WithArgs("nirvana").WillReturnError(sql.ErrNoRows)
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/artists", `{"name":"nirvana"}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rename updates the albums", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("Miles Dewey Davis", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec("UPDATE artists SET name").WithArgs(2, "Miles Dewey Davis").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectExec("UPDATE albums SET artist").WithArgs(2, "Miles Dewey Davis").WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM artists ar WHERE ar.id").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(artistColumns).AddRow(2, "Miles Dewey Davis", created, 4))
+
+		rr := send("PUT", "/api/artists/2", `{"name":"Miles Dewey Davis"}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rename to a taken name", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("Nirvana", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusConflict, send("PUT", "/api/artists/2", `{"name":"Nirvana"}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delete artist with albums", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM artists").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM artists ar WHERE ar.id").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(artistColumns).AddRow(1, "Nirvana", created, 3))
+
+		rr := send("DELETE", "/api/artists/1", "")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"albumCount":3`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM artists").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.Equal(t, http.StatusNoContent, send("DELETE", "/api/artists/2", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Albums can be listed by artist", func(t *testing.T) {
+		mock.ExpectQuery(`WHERE a\.artist_id IN \(\$1\)`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count"}))
+		assert.Equal(t, http.StatusOK, send("GET", "/api/albums?artistId=1", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Writes need admin", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/artists", bytes.NewBufferString(`{"name":"X"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestGetArtistSummary(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"id", "name", "count", "avg", "min", "max", "in_stock", "out_of_stock", "unknown", "albums"}
+
+	t.Run("Aggregates the artist's albums", func(t *testing.T) {
+		albums := `[{"id":"3","title":"Bleach","artist":"Nirvana","price":12.99,"releaseYear":1989,"genre":"Rock","format":"","version":1,"availability":"out_of_stock"},
+			{"id":"4","title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","format":"LP","version":2,"availability":"in_stock"}]`
+		mock.ExpectQuery("WITH artist AS").WithArgs("nirvana", nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "Nirvana", 2, 1649, 1989, 1991, 1, 1, 0, []byte(albums)))
+
+		rr := get("/api/artists/nirvana/summary")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var summary ArtistSummary
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &summary))
+		assert.Equal(t, 2, summary.AlbumCount)
+		assert.Equal(t, Cents(1649), *summary.AveragePrice)
+		assert.Equal(t, 1989, *summary.EarliestReleaseYear)
+		assert.Equal(t, 1991, *summary.LatestReleaseYear)
+		assert.Equal(t, ArtistAvailability{InStock: 1, OutOfStock: 1}, summary.Availability)
+		require.Len(t, summary.Albums, 2)
+		assert.Equal(t, "Nevermind", summary.Albums[1].Title)
+		assert.Equal(t, "in_stock", summary.Albums[1].Availability)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Artists without albums", func(t *testing.T) {
+		mock.ExpectQuery("WITH artist AS").WithArgs("7", 7).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(7, "AC/DC", 0, nil, nil, nil, 0, 0, 0, []byte(`[]`)))
+
+		rr := get("/api/artists/7/summary")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"id":"7","name":"AC/DC","albumCount":0,"availability":{"inStock":0,"outOfStock":0,"unknown":0},"albums":[]}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Leaves out draft albums for public callers", func(t *testing.T) {
+		mock.ExpectQuery(`LEFT JOIN albums a ON a\.artist_id = ar\.id AND a\.status = 'published'\s`).WithArgs("nirvana", nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "Nirvana", 0, nil, nil, nil, 0, 0, 0, []byte(`[]`)))
+		rr := get("/api/artists/nirvana/summary")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"albumCount":0`)
+
+		// Admins see the draft
+		albums := `[{"id":"5","title":"In Utero (demos)","artist":"Nirvana","price":9.99,"releaseYear":1993,"genre":"Rock","format":"","version":1,"availability":"unknown"}]`
+		mock.ExpectQuery(`LEFT JOIN albums a ON a\.artist_id = ar\.id\s+LEFT JOIN`).WithArgs("nirvana", nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "Nirvana", 1, 999, 1993, 1993, 0, 0, 1, []byte(albums)))
+		req, _ := http.NewRequest("GET", "/api/artists/nirvana/summary", nil)
+		req.Header.Set("Client-Type", "admin")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"title":"In Utero (demos)"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown artist", func(t *testing.T) {
+		mock.ExpectQuery("WITH artist AS").WillReturnRows(sqlmock.NewRows(columns))
+		assert.Equal(t, http.StatusNotFound, get("/api/artists/Nobody/summary").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
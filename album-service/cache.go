@@ -0,0 +1,126 @@
+// cache.go - declarative HTTP cache policies per route class, so CDNs in front of the service cache correctly
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachePolicy describes how successful GET/HEAD responses of a route may be cached.
+// Non-2xx responses are always sent with no-store so errors never get pinned in a CDN.
+type cachePolicy struct {
+	CacheControl string
+	ETag         bool // Derive a strong ETag from the body and answer matching If-None-Match with 304
+}
+
+var (
+	// Public listings: shared caches may serve them for a minute, browsers always revalidate
+	cachePublicList = cachePolicy{CacheControl: "public, s-maxage=60"}
+	// Detail views must reflect updates immediately, but unchanged bodies can be revalidated cheaply
+	cacheDetail = cachePolicy{CacheControl: "no-cache", ETag: true}
+	// Generated feeds only change when regenerated; crawlers revalidate after five minutes
+	cacheFeed = cachePolicy{CacheControl: "public, max-age=300", ETag: true}
+	// Feeds requiring an API key: shared caches must not serve them to requests without one
+	cachePartnerFeed = cachePolicy{CacheControl: "private, max-age=300", ETag: true}
+	// Public storefront reads: browsers keep them for a minute, shared caches for five and may serve
+	// stale copies while revalidating
+	cacheStorefront = cachePolicy{CacheControl: "public, max-age=60, s-maxage=300, stale-while-revalidate=600", ETag: true}
+	// Admin and write endpoints
+	cacheNoStore = cachePolicy{CacheControl: "no-store"}
+)
+
+// withCachePolicy applies the policy to every response of the route(s) it is attached to
+func withCachePolicy(p cachePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if p == cacheNoStore || (method != http.MethodGet && method != http.MethodHead) {
+			c.Header("Cache-Control", cacheNoStore.CacheControl)
+			c.Next()
+			return
+		}
+
+		// Buffer the response so headers can depend on the final status and body
+		original := c.Writer
+		buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		defer func() { c.Writer = original }()
+
+		c.Next()
+
+		header := original.Header()
+		status := buffered.status
+		if status == http.StatusNotModified {
+			// The handler revalidated the request itself (see albumNotModified)
+			header.Set("Cache-Control", p.CacheControl)
+			original.WriteHeader(status)
+			original.WriteHeaderNow()
+			return
+		}
+		if status < 200 || status >= 300 {
+			header.Set("Cache-Control", cacheNoStore.CacheControl)
+			original.WriteHeader(status)
+			original.Write(buffered.body.Bytes())
+			return
+		}
+
+		header.Set("Cache-Control", p.CacheControl)
+		if p.ETag {
+			// Handlers may set their own ETag, e.g. the album version (see album_version.go)
+			etag := header.Get("ETag")
+			if etag == "" {
+				sum := sha256.Sum256(buffered.body.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				header.Set("ETag", etag)
+			}
+			if etagMatches(c.GetHeader("If-None-Match"), etag) {
+				header.Del("Content-Type")
+				original.WriteHeader(http.StatusNotModified)
+				original.WriteHeaderNow()
+				return
+			}
+		}
+		original.WriteHeader(status)
+		original.Write(buffered.body.Bytes())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header value matches the ETag (weak comparison, per RFC 9110)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponseWriter holds the status and body until withCachePolicy decides what to send
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) { w.status = code }
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) { return w.body.WriteString(s) }
+
+func (w *bufferedResponseWriter) Status() int { return w.status }
+
+func (w *bufferedResponseWriter) Size() int { return w.body.Len() }
+
+func (w *bufferedResponseWriter) Written() bool { return w.body.Len() > 0 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCachePolicyTestRouter(status *int) *gin.Engine {
+	r := gin.New()
+	handler := func(c *gin.Context) { c.JSON(*status, gin.H{"title": "Kind of Blue"}) }
+	r.GET("/list", withCachePolicy(cachePublicList), handler)
+	r.GET("/detail", withCachePolicy(cacheDetail), handler)
+	r.GET("/admin", withCachePolicy(cacheNoStore), handler)
+	return r
+}
+
+func TestWithCachePolicy(t *testing.T) {
+	status := http.StatusOK
+	r := newCachePolicyTestRouter(&status)
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Public list is shared-cacheable", func(t *testing.T) {
+		rr := get("/list", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "public, s-maxage=60", rr.Header().Get("Cache-Control"))
+		assert.Empty(t, rr.Header().Get("ETag"))
+		assert.JSONEq(t, `{"title":"Kind of Blue"}`, rr.Body.String())
+	})
+
+	t.Run("Detail revalidates with ETag", func(t *testing.T) {
+		rr := get("/detail", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		rr = get("/detail", etag)
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+
+		rr = get("/detail", `"stale"`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Admin is never stored", func(t *testing.T) {
+		assert.Equal(t, "no-store", get("/admin", "").Header().Get("Cache-Control"))
+	})
+
+	t.Run("Errors are never stored", func(t *testing.T) {
+		status = http.StatusNotFound
+		defer func() { status = http.StatusOK }()
+
+		rr := get("/list", "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		assert.JSONEq(t, `{"title":"Kind of Blue"}`, rr.Body.String())
+
+		rr = get("/detail", "")
+		assert.Empty(t, rr.Header().Get("ETag"))
+	})
+}
@@ -0,0 +1,459 @@
+// clearance.go - optional clearance rule bridging inventory and pricing. Albums whose stock hasn't
+// sold for a while (the criteria of inventory-service's aging report) get a discount proposal that
+// catalog managers approve or reject, or that is applied directly with CLEARANCE_AUTO_APPROVE=true.
+// Every proposal and decision is published to the price-proposals topic.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultClearanceJobInterval = 6 * time.Hour
+	maxClearanceCandidates      = 500 // Per run; the rest are proposed on the next one
+	maxPriceProposalsListed     = 500
+	priceProposalsTopic         = "price-proposals"
+)
+
+// Proposal statuses
+const (
+	proposalPending  = "pending"
+	proposalApplied  = "applied"
+	proposalRejected = "rejected"
+	proposalStale    = "stale" // The album was repriced before the proposal was approved
+)
+
+// proposalDecidedAuto is recorded as the decider of proposals applied by CLEARANCE_AUTO_APPROVE
+const proposalDecidedAuto = "auto"
+
+// clearanceRule flags albums with more than QuantityAbove units and no sale in Days days, and
+// proposes DiscountPercent off their price. Days 0 disables the rule.
+type clearanceRule struct {
+	Days            int
+	QuantityAbove   int
+	DiscountPercent float64
+	AutoApprove     bool
+}
+
+var clearance clearanceRule
+
+func (r clearanceRule) enabled() bool { return r.Days > 0 }
+
+// price returns the discounted price for an album, raised to the genre's price floor; false when
+// that isn't below the current price
+func (r clearanceRule) price(current Cents, genre string) (Cents, bool) {
+	proposed := current.percentOff(r.DiscountPercent)
+	if floor := priceFloors.floorFor(genre); proposed < floor {
+		proposed = floor
+	}
+	return proposed, proposed < current
+}
+
+// loadClearanceRule reads CLEARANCE_RULE (e.g. "days=90,quantityAbove=5,discount=25", unset
+// disables the rule) and CLEARANCE_AUTO_APPROVE
+func loadClearanceRule() error {
+	var rule clearanceRule
+	if v := os.Getenv("CLEARANCE_RULE"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("CLEARANCE_RULE entry %q, expected key=value", entry)
+			}
+			value = strings.TrimSpace(value)
+			var err error
+			switch strings.TrimSpace(key) {
+			case "days":
+				rule.Days, err = strconv.Atoi(value)
+			case "quantityAbove":
+				rule.QuantityAbove, err = strconv.Atoi(value)
+			case "discount":
+				rule.DiscountPercent, err = strconv.ParseFloat(value, 64)
+			default:
+				return fmt.Errorf("CLEARANCE_RULE key %q, expected days, quantityAbove or discount", strings.TrimSpace(key))
+			}
+			if err != nil {
+				return fmt.Errorf("CLEARANCE_RULE entry %q is not a number", entry)
+			}
+		}
+		if rule.Days <= 0 || rule.QuantityAbove < 0 || rule.DiscountPercent <= 0 || rule.DiscountPercent >= 100 {
+			return fmt.Errorf("CLEARANCE_RULE needs days above 0, quantityAbove of at least 0 and a discount between 0 and 100 percent")
+		}
+	}
+	if v := os.Getenv("CLEARANCE_AUTO_APPROVE"); v != "" {
+		auto, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CLEARANCE_AUTO_APPROVE %q is not a boolean", v)
+		}
+		rule.AutoApprove = auto
+	}
+	clearance = rule
+	return nil
+}
+
+// PriceProposal is a proposed price change awaiting, or past, a catalog manager's decision
+type PriceProposal struct {
+	ID            int        `json:"id"`
+	AlbumID       string     `json:"albumId" id:"public"`
+	Title         string     `json:"title,omitempty"`
+	Artist        string     `json:"artist,omitempty"`
+	CurrentPrice  Cents      `json:"currentPrice"`
+	ProposedPrice Cents      `json:"proposedPrice"`
+	Quantity      int        `json:"quantity"`
+	IdleDays      int        `json:"idleDays"`
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	DecidedAt     *time.Time `json:"decidedAt,omitempty"`
+}
+
+// PriceProposalEvent is published when a proposal is created and when it is decided
+type PriceProposalEvent struct {
+	ProposalID    int       `json:"proposalId"`
+	AlbumID       string    `json:"albumId"`
+	Status        string    `json:"status"`
+	CurrentPrice  Cents     `json:"currentPrice"`
+	ProposedPrice Cents     `json:"proposedPrice"`
+	Reason        string    `json:"reason"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+var priceProposalWriter messageWriter
+
+// startClearanceJob runs the clearance rule immediately and then on every CLEARANCE_JOB_INTERVAL tick
+func startClearanceJob() {
+	if !clearance.enabled() {
+		log.Println("Clearance rule not configured, no discounts will be proposed")
+		return
+	}
+	interval := defaultClearanceJobInterval
+	if v := os.Getenv("CLEARANCE_JOB_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid CLEARANCE_JOB_INTERVAL %q, using default %s", v, defaultClearanceJobInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Printf("Clearance job scheduled every %s (%+v)", interval, clearance)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runClearanceJob()
+			<-ticker.C
+		}
+	}()
+}
+
+// runClearanceJob runs one traced pass of the clearance rule
+func runClearanceJob() {
+	ctx, span := tracer.Start(context.Background(), "job.clearance")
+	defer span.End()
+
+	proposals, err := proposeClearance(ctx, clearance, time.Now())
+	for _, p := range proposals {
+		publishPriceProposal(ctx, p)
+	}
+	if err != nil {
+		log.Printf("Clearance job failed after %d proposals: %v", len(proposals), err)
+		span.RecordError(err)
+		return
+	}
+	log.Printf("Clearance job proposed %d discounts", len(proposals))
+}
+
+// proposeClearance creates a proposal for each album matching the rule, and applies it when the
+// rule auto-approves. Albums with a pending proposal, or one created within the rule's window,
+// are skipped, so an album is marked down at most once per window. Returns the proposals created.
+func proposeClearance(ctx context.Context, rule clearanceRule, now time.Time) ([]PriceProposal, error) {
+	cutoff := now.AddDate(0, 0, -rule.Days)
+	// inventory is owned by inventory-service; its receipt and sale timestamps drive the aging report
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, a.genre, a.price_cents, i.quantity_available, COALESCE(i.last_sold_at, i.last_received_at, i.last_updated) AS idle_since
+		FROM albums a JOIN inventory i ON i.album_id = a.id::text
+		WHERE i.quantity_available > $1 AND COALESCE(i.last_sold_at, i.last_received_at, i.last_updated) < $2
+			AND NOT EXISTS (SELECT 1 FROM price_proposals p WHERE p.album_id = a.id AND (p.status = 'pending' OR p.created_at >= $2))
+		ORDER BY idle_since, a.id
+		LIMIT $3`, rule.QuantityAbove, cutoff, maxClearanceCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("query slow-moving stock: %w", err)
+	}
+	type candidate struct {
+		PriceProposal
+		genre string
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		var albumID int
+		var idleSince time.Time
+		if err := rows.Scan(&albumID, &c.genre, &c.CurrentPrice, &c.Quantity, &idleSince); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan slow-moving stock: %w", err)
+		}
+		c.AlbumID = strconv.Itoa(albumID)
+		c.IdleDays = int(now.Sub(idleSince).Hours() / 24)
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read slow-moving stock: %w", err)
+	}
+
+	var created []PriceProposal
+	for _, c := range candidates {
+		proposed, ok := rule.price(c.CurrentPrice, c.genre)
+		if !ok {
+			continue // Already at the floor
+		}
+		p := c.PriceProposal
+		p.ProposedPrice = proposed
+		p.Reason = fmt.Sprintf("No sales in %d days with %d in stock; %g%% clearance discount", p.IdleDays, p.Quantity, rule.DiscountPercent)
+		inserted, err := createPriceProposal(ctx, &p, rule.AutoApprove)
+		if err != nil {
+			return created, fmt.Errorf("propose discount for album %s: %w", p.AlbumID, err)
+		}
+		if inserted {
+			created = append(created, p)
+		}
+	}
+	return created, nil
+}
+
+// createPriceProposal inserts the proposal and, with autoApprove, applies it in the same
+// transaction. False when another instance proposed a discount for the album first.
+func createPriceProposal(ctx context.Context, p *PriceProposal, autoApprove bool) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	p.Status = proposalPending
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO price_proposals (album_id, current_price_cents, proposed_price_cents, quantity, idle_days, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (album_id) WHERE status = 'pending' DO NOTHING
+		RETURNING id, created_at`,
+		p.AlbumID, p.CurrentPrice, p.ProposedPrice, p.Quantity, p.IdleDays, p.Reason).Scan(&p.ID, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if autoApprove {
+		if err := applyPriceProposal(ctx, tx, p, proposalDecidedAuto); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if p.Status == proposalApplied {
+		invalidateAlbums(ctx, p.AlbumID)
+	}
+	return true, nil
+}
+
+var errProposalBelowFloor = errors.New("proposed price is below the album's current price floor")
+
+// applyPriceProposal sets the album to the proposed price and marks the proposal applied, or stale
+// when the album's price changed since the proposal. The price floor is checked again, as floors
+// may have been raised since.
+func applyPriceProposal(ctx context.Context, tx *sql.Tx, p *PriceProposal, decidedBy string) error {
+	if err := setPriceChangeContext(ctx, tx, priceSourceClearance, p.Reason, decidedBy); err != nil {
+		return err
+	}
+	if err := setAuditActor(ctx, tx, decidedBy); err != nil {
+		return err
+	}
+	var genre string
+	err := tx.QueryRowContext(ctx,
+		"UPDATE albums SET price_cents = $2 WHERE id = $1 AND price_cents = $3 RETURNING genre",
+		p.AlbumID, p.ProposedPrice, p.CurrentPrice).Scan(&genre)
+	switch {
+	case err == sql.ErrNoRows:
+		p.Status = proposalStale
+	case err != nil:
+		return err
+	default:
+		if _, below := priceFloors.check(p.ProposedPrice, genre); below {
+			return errProposalBelowFloor
+		}
+		p.Status = proposalApplied
+	}
+	return decidePriceProposal(ctx, tx, p, decidedBy)
+}
+
+// decidePriceProposal records the proposal's new status
+func decidePriceProposal(ctx context.Context, tx *sql.Tx, p *PriceProposal, decidedBy string) error {
+	err := tx.QueryRowContext(ctx,
+		"UPDATE price_proposals SET status = $2, decided_at = NOW(), decided_by = $3 WHERE id = $1 RETURNING decided_at",
+		p.ID, p.Status, decidedBy).Scan(&p.DecidedAt)
+	if err == nil {
+		log.Printf("Price proposal %d for album %s %s by %s: %s -> %s", p.ID, p.AlbumID, p.Status, decidedBy, p.CurrentPrice, p.ProposedPrice)
+	}
+	return err
+}
+
+// publishPriceProposal publishes the proposal's current status. Failures are logged: the proposal
+// is stored either way and listed by GET /api/albums/price-proposals.
+func publishPriceProposal(ctx context.Context, p PriceProposal) {
+	ctx, span := tracer.Start(ctx, "kafka.publish_price_proposal")
+	defer span.End()
+
+	event, err := json.Marshal(PriceProposalEvent{
+		ProposalID:    p.ID,
+		AlbumID:       p.AlbumID,
+		Status:        p.Status,
+		CurrentPrice:  p.CurrentPrice,
+		ProposedPrice: p.ProposedPrice,
+		Reason:        p.Reason,
+		Timestamp:     time.Now(),
+	})
+	if err == nil {
+		err = priceProposalWriter.WriteMessages(ctx, kafka.Message{
+			Key:     []byte(p.AlbumID),
+			Value:   event,
+			Headers: InjectTraceInfoToKafkaMessage(ctx),
+		})
+	}
+	if err != nil {
+		log.Printf("Error publishing price proposal %d (%s): %v", p.ID, p.Status, err)
+		span.RecordError(err)
+	}
+}
+
+// getPriceProposals handles GET /api/albums/price-proposals, listing proposals with ?status=
+// (default pending), oldest first
+func getPriceProposals(c *gin.Context) {
+	status := c.DefaultQuery("status", proposalPending)
+	switch status {
+	case proposalPending, proposalApplied, proposalRejected, proposalStale:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, applied, rejected or stale"})
+		return
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT p.id, p.album_id, a.title, a.artist, p.current_price_cents, p.proposed_price_cents, p.quantity, p.idle_days, p.reason, p.status, p.created_at, p.decided_at
+		FROM price_proposals p JOIN albums a ON a.id = p.album_id
+		WHERE p.status = $1
+		ORDER BY p.created_at, p.id
+		LIMIT $2`, status, maxPriceProposalsListed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query price proposals: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	proposals := []PriceProposal{}
+	for rows.Next() {
+		var p PriceProposal
+		var albumID int
+		if err := rows.Scan(&p.ID, &albumID, &p.Title, &p.Artist, &p.CurrentPrice, &p.ProposedPrice, &p.Quantity, &p.IdleDays, &p.Reason, &p.Status, &p.CreatedAt, &p.DecidedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read price proposals: " + err.Error()})
+			return
+		}
+		p.AlbumID = strconv.Itoa(albumID)
+		proposals = append(proposals, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read price proposals: " + err.Error()})
+		return
+	}
+	respondJSON(c, http.StatusOK, proposals)
+}
+
+// approvePriceProposal handles POST /api/albums/price-proposals/:proposalId/approve
+func approvePriceProposal(c *gin.Context) {
+	decidePendingProposal(c, true)
+}
+
+// rejectPriceProposal handles POST /api/albums/price-proposals/:proposalId/reject
+func rejectPriceProposal(c *gin.Context) {
+	decidePendingProposal(c, false)
+}
+
+// decidePendingProposal applies or rejects a pending proposal. Approving a proposal whose album was
+// repriced since marks it stale and returns 409.
+func decidePendingProposal(c *gin.Context, approve bool) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("proposalId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price proposal not found"})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var p PriceProposal
+	var albumID int
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, album_id, current_price_cents, proposed_price_cents, quantity, idle_days, reason, status, created_at
+		FROM price_proposals WHERE id = $1 FOR UPDATE`, id).
+		Scan(&p.ID, &albumID, &p.CurrentPrice, &p.ProposedPrice, &p.Quantity, &p.IdleDays, &p.Reason, &p.Status, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price proposal not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	p.AlbumID = strconv.Itoa(albumID)
+	if p.Status != proposalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Price proposal is already " + p.Status})
+		return
+	}
+
+	if approve {
+		err = applyPriceProposal(ctx, tx, &p, c.ClientIP())
+	} else {
+		p.Status = proposalRejected
+		err = decidePriceProposal(ctx, tx, &p, c.ClientIP())
+	}
+	if errors.Is(err, errProposalBelowFloor) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide price proposal: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit decision: " + err.Error()})
+		return
+	}
+	if p.Status == proposalApplied {
+		invalidateAlbums(ctx, p.AlbumID)
+	}
+	publishPriceProposal(ctx, p)
+
+	if p.Status == proposalStale {
+		c.JSON(http.StatusConflict, gin.H{"error": "Album was repriced since the proposal; it was marked stale"})
+		return
+	}
+	respondJSON(c, http.StatusOK, p)
+}
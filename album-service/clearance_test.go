@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadClearanceRule(t *testing.T) {
+	t.Cleanup(func() { clearance = clearanceRule{} })
+
+	require.NoError(t, loadClearanceRule())
+	assert.False(t, clearance.enabled(), "disabled unless configured")
+
+	t.Setenv("CLEARANCE_RULE", "days=120, quantityAbove=5, discount=25")
+	t.Setenv("CLEARANCE_AUTO_APPROVE", "true")
+	require.NoError(t, loadClearanceRule())
+	assert.Equal(t, clearanceRule{Days: 120, QuantityAbove: 5, DiscountPercent: 25, AutoApprove: true}, clearance)
+
+	t.Setenv("CLEARANCE_RULE", "days=90,discount=100")
+	assert.ErrorContains(t, loadClearanceRule(), "between 0 and 100")
+	t.Setenv("CLEARANCE_RULE", "weeks=4")
+	assert.ErrorContains(t, loadClearanceRule(), `key "weeks"`)
+	t.Setenv("CLEARANCE_RULE", "days=90,discount=25")
+	t.Setenv("CLEARANCE_AUTO_APPROVE", "sometimes")
+	assert.ErrorContains(t, loadClearanceRule(), "not a boolean")
+}
+
+func TestClearanceRulePrice(t *testing.T) {
+	original := priceFloors
+	priceFloors = priceFloorConfig{ByGenre: map[string]Cents{"jazz": 1000}}
+	t.Cleanup(func() { priceFloors = original })
+	rule := clearanceRule{Days: 90, DiscountPercent: 25}
+
+	price, ok := rule.price(1999, "Rock")
+	assert.True(t, ok)
+	assert.Equal(t, Cents(1499), price)
+
+	price, ok = rule.price(1200, "Jazz")
+	assert.True(t, ok)
+	assert.Equal(t, Cents(1000), price, "raised to the floor")
+
+	_, ok = rule.price(1000, "Jazz")
+	assert.False(t, ok, "already at the floor")
+}
+
+func TestProposeClearance(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	now := time.Now()
+	rule := clearanceRule{Days: 90, QuantityAbove: 5, DiscountPercent: 20, AutoApprove: true}
+	mock.ExpectQuery("FROM albums a JOIN inventory i").WithArgs(5, now.AddDate(0, 0, -90), maxClearanceCandidates).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "genre", "price_cents", "quantity_available", "idle_since"}).
+			AddRow(7, "Jazz", 2500, 12, now.AddDate(0, 0, -150)).
+			AddRow(9, "Rock", 2000, 8, now.AddDate(0, 0, -100)))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO price_proposals").
+		WithArgs("7", 2500, 2000, 12, 150, "No sales in 150 days with 12 in stock; 20% clearance discount").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+	mock.ExpectExec("set_config").WithArgs(priceSourceClearance, sqlmock.AnyArg(), proposalDecidedAuto).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditActor(mock)
+	mock.ExpectQuery("UPDATE albums SET price_cents").WithArgs("7", 2000, 2500).
+		WillReturnRows(sqlmock.NewRows([]string{"genre"}).AddRow("Jazz"))
+	mock.ExpectQuery("UPDATE price_proposals SET status").WithArgs(1, proposalApplied, proposalDecidedAuto).
+		WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(now))
+	mock.ExpectCommit()
+	// Album 9 was proposed by another instance in the meantime
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO price_proposals").WithArgs("9", 2000, 1600, 8, 100, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+	mock.ExpectRollback()
+
+	proposals, err := proposeClearance(context.Background(), rule, now)
+	require.NoError(t, err)
+	require.Len(t, proposals, 1)
+	assert.Equal(t, proposalApplied, proposals[0].Status)
+	assert.Equal(t, Cents(2000), proposals[0].ProposedPrice)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPriceProposalHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, priceProposalWriter
+	writer := &recordingWriter{}
+	db, priceProposalWriter = mockDB, writer
+	t.Cleanup(func() { db, priceProposalWriter = originalDB, originalWriter })
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	created := time.Now().Add(-time.Hour)
+	expectPending := func(status string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM price_proposals WHERE id = \\$1 FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "album_id", "current_price_cents", "proposed_price_cents", "quantity", "idle_days", "reason", "status", "created_at"}).
+				AddRow(1, 7, 2500, 2000, 12, 150, "No sales in 150 days", status, created))
+	}
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("FROM price_proposals p JOIN albums a").WithArgs(proposalPending, maxPriceProposalsListed).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "album_id", "title", "artist", "current_price_cents", "proposed_price_cents", "quantity", "idle_days", "reason", "status", "created_at", "decided_at"}).
+				AddRow(1, 7, "Blue Train", "John Coltrane", 2500, 2000, 12, 150, "No sales in 150 days", proposalPending, created, nil))
+
+		rr := send("GET", "/api/albums/price-proposals")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var proposals []PriceProposal
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &proposals))
+		require.Len(t, proposals, 1)
+		assert.Equal(t, "Blue Train", proposals[0].Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, http.StatusBadRequest, send("GET", "/api/albums/price-proposals?status=open").Code)
+	})
+
+	t.Run("Approve applies the price", func(t *testing.T) {
+		expectPending(proposalPending)
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET price_cents").WithArgs("7", 2000, 2500).
+			WillReturnRows(sqlmock.NewRows([]string{"genre"}).AddRow("Jazz"))
+		mock.ExpectQuery("UPDATE price_proposals SET status").WithArgs(1, proposalApplied, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/albums/price-proposals/1/approve")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"status":"applied"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		require.NotEmpty(t, writer.messages)
+		var event PriceProposalEvent
+		require.NoError(t, json.Unmarshal(writer.messages[len(writer.messages)-1].Value, &event))
+		assert.Equal(t, PriceProposalEvent{ProposalID: 1, AlbumID: "7", Status: proposalApplied, CurrentPrice: 2500, ProposedPrice: 2000, Reason: "No sales in 150 days", Timestamp: event.Timestamp}, event)
+	})
+
+	t.Run("Approving after a repricing marks the proposal stale", func(t *testing.T) {
+		expectPending(proposalPending)
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET price_cents").WillReturnRows(sqlmock.NewRows([]string{"genre"}))
+		mock.ExpectQuery("UPDATE price_proposals SET status").WithArgs(1, proposalStale, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/albums/price-proposals/1/approve").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		expectPending(proposalPending)
+		mock.ExpectQuery("UPDATE price_proposals SET status").WithArgs(1, proposalRejected, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/albums/price-proposals/1/reject")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"status":"rejected"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Decided proposals can't be decided again", func(t *testing.T) {
+		expectPending(proposalRejected)
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/albums/price-proposals/1/approve").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/albums/price-proposals", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
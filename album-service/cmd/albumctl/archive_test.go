@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var archiveColumns = []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc",
+	"catalog_number", "release_date", "label", "tracks", "attributes", "status"}
+
+func stringPtr(s string) *string { return &s }
+
+func TestArchiveExport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, .* FROM albums ORDER BY id").WillReturnRows(sqlmock.NewRows(archiveColumns).
+		AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "0720642442524", "DGC-24425", "1991-09-24", "DGC",
+			[]byte(`[{"position":1,"title":"Smells Like Teen Spirit"}]`), []byte(`{"speed":"33"}`), "published").
+		AddRow(9, "Bleach, Deluxe", "Nirvana", 1405, 1989, "Rock", nil, nil, nil, nil, nil, nil, nil, "draft"))
+	mock.ExpectRollback()
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, archiveExport(context.Background(), db, dir, now, &out))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	name := filepath.Join(dir, "album-archive-20240501T123000Z.tar.gz")
+	assert.Equal(t, "Archived 2 albums to "+name+"\n", out.String())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	manifest, albums, err := readArchive(f)
+	require.NoError(t, err)
+	assert.Equal(t, archiveManifest{FormatVersion: archiveFormatVersion, CreatedAt: now, Albums: 2}, manifest)
+	require.Len(t, albums, 2)
+	assert.Equal(t, archivedAlbum{ID: 4, Title: "Nevermind", Artist: "Nirvana", PriceCents: 1999, ReleaseYear: 1991, Genre: "Rock",
+		Format: stringPtr("LP"), UPC: stringPtr("0720642442524"), CatalogNumber: stringPtr("DGC-24425"), ReleaseDate: stringPtr("1991-09-24"),
+		Label: stringPtr("DGC"), Tracks: json.RawMessage(`[{"position":1,"title":"Smells Like Teen Spirit"}]`),
+		Attributes: json.RawMessage(`{"speed":"33"}`), Status: "published"}, compactAlbum(t, albums[0]))
+	assert.Nil(t, albums[1].Format)
+	assert.Equal(t, "null", string(albums[1].Tracks))
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, "id,title,artist,price,release_year,genre,format\n"+
+		"4,Nevermind,Nirvana,19.99,1991,Rock,LP\n"+
+		"9,\"Bleach, Deluxe\",Nirvana,14.05,1989,Rock,\n", archiveFile(t, f, archiveCSVFile))
+}
+
+// compactAlbum compacts the JSON values of an album read back from an indented archive
+func compactAlbum(t *testing.T, a archivedAlbum) archivedAlbum {
+	for _, v := range []*json.RawMessage{&a.Tracks, &a.Attributes} {
+		var buf bytes.Buffer
+		require.NoError(t, json.Compact(&buf, *v))
+		*v = buf.Bytes()
+	}
+	return a
+}
+
+// archiveFile returns the contents of one file of an archive
+func archiveFile(t *testing.T, r io.Reader, name string) string {
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		require.NoError(t, err, "no %s in the archive", name)
+		if hdr.Name == name {
+			body, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			return string(body)
+		}
+	}
+}
+
+func TestReadArchive_Invalid(t *testing.T) {
+	archive := func(manifest archiveManifest, albums []archivedAlbum) io.Reader {
+		var buf bytes.Buffer
+		require.NoError(t, writeArchive(&buf, manifest, albums))
+		return &buf
+	}
+	albums := []archivedAlbum{{ID: 1, Title: "Pink Moon", Artist: "Nick Drake", PriceCents: 1299, ReleaseYear: 1972, Genre: "Folk", Status: "published"}}
+
+	_, _, err := readArchive(archive(archiveManifest{FormatVersion: archiveFormatVersion, Albums: 2}, albums))
+	assert.EqualError(t, err, "the archive has 1 albums but its manifest lists 2")
+
+	_, _, err = readArchive(archive(archiveManifest{FormatVersion: 99, Albums: 1}, albums))
+	assert.EqualError(t, err, "unsupported archive format version 99")
+
+	_, _, err = readArchive(strings.NewReader("id,title\n"))
+	assert.ErrorContains(t, err, "not a gzipped archive")
+}
+
+func TestRestoreCatalog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	albums := []archivedAlbum{
+		{ID: 4, Title: "Nevermind", Artist: "Nirvana", PriceCents: 1999, ReleaseYear: 1991, Genre: "Rock", Format: stringPtr("LP"),
+			Tracks: json.RawMessage(`[{"position":1,"title":"Smells Like Teen Spirit"}]`), Status: "published"},
+		{ID: 9, Title: "Bleach", Artist: "Nirvana", PriceCents: 1405, ReleaseYear: 1989, Genre: "Rock", Tracks: json.RawMessage("null"), Status: "draft"},
+	}
+	expectEmptyCatalog := func(empty bool) {
+		mock.ExpectBegin()
+		mock.ExpectExec("LOCK TABLE albums IN EXCLUSIVE MODE").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM albums\\)").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(!empty))
+	}
+
+	t.Run("Into an empty catalog", func(t *testing.T) {
+		expectEmptyCatalog(true)
+		mock.ExpectExec("set_config\\('album_store.changed_by', \\$1, true\\)").WithArgs(archiveActor).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO albums \\(id, ").
+			WithArgs(4, "Nevermind", "Nirvana", int64(1999), 1991, "Rock", stringPtr("LP"), nil, nil, nil, nil, `[{"position":1,"title":"Smells Like Teen Spirit"}]`, nil, "published").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO albums \\(id, ").
+			WithArgs(9, "Bleach", "Nirvana", int64(1405), 1989, "Rock", nil, nil, nil, nil, nil, nil, nil, "draft").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("SELECT setval\\(pg_get_serial_sequence\\('albums', 'id'\\), MAX\\(id\\)\\) FROM albums").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, restoreCatalog(context.Background(), db, albums))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Not into a catalog with albums", func(t *testing.T) {
+		expectEmptyCatalog(false)
+		mock.ExpectRollback()
+
+		assert.ErrorIs(t, restoreCatalog(context.Background(), db, albums), errCatalogNotEmpty)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestArchiveImport_Confirmation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	path := filepath.Join(t.TempDir(), "album-archive.tar.gz")
+	var buf bytes.Buffer
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, writeArchive(&buf, archiveManifest{FormatVersion: archiveFormatVersion, CreatedAt: created}, []archivedAlbum{}))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	var out bytes.Buffer
+	require.NoError(t, archiveImport(context.Background(), db, path, strings.NewReader("n\n"), &out))
+	assert.Equal(t, path+": 0 albums, archived 2024-05-01T12:30:00Z\nRestore 0 albums? [y/N] Aborted, nothing was changed\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is restored without confirmation")
+}
+
+func TestArchive_ObjectStorage(t *testing.T) {
+	store := startFakeObjectStore(t)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, .* FROM albums ORDER BY id").WillReturnRows(sqlmock.NewRows(archiveColumns).
+		AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", nil, nil, nil, nil, nil, nil, "published"))
+	mock.ExpectRollback()
+
+	var out bytes.Buffer
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, archiveExport(context.Background(), db, "s3://backups/album-store/", now, &out))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	url := "s3://backups/album-store/album-archive-20240501T123000Z.tar.gz"
+	assert.Equal(t, "Archived 1 albums to "+url+"\n", out.String())
+	assert.Contains(t, store.objects, "/backups/album-store/album-archive-20240501T123000Z.tar.gz")
+
+	out.Reset()
+	require.NoError(t, archiveImport(context.Background(), db, url, strings.NewReader("n\n"), &out))
+	assert.Equal(t, url+": 1 albums, archived 2024-05-01T12:30:00Z\nRestore 1 albums? [y/N] Aborted, nothing was changed\n", out.String())
+
+	err = archiveImport(context.Background(), db, "s3://backups/album-store/missing.tar.gz", nil, &out)
+	assert.ErrorContains(t, err, "404 Not Found: NoSuchKey")
+	err = archiveImport(context.Background(), db, "s3://backups", nil, &out)
+	assert.ErrorContains(t, err, "names no archive")
+}
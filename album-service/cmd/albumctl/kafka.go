@@ -0,0 +1,587 @@
+// kafka.go - albumctl kafka: consumer group offsets, offset resets and topic peeks, so operators
+// don't have to shell into the Kafka containers. Topic and group names are used as given, including
+// any KAFKA_TOPIC_PREFIX / KAFKA_TOPIC_SUFFIX.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultKafkaBroker = "localhost:9092"
+	kafkaTimeout       = 10 * time.Second
+	// peekReadTimeout is how long peek waits for a message before it stops
+	peekReadTimeout   = 5 * time.Second
+	defaultPeekCount  = 10
+	unsetResetOffset  = -1
+	groupStateEmpty   = "Empty"
+	groupStateMissing = "Dead" // Reported for groups that don't exist
+)
+
+// kafkaAdmin is the part of *kafka.Client albumctl uses
+type kafkaAdmin interface {
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error)
+	OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	OffsetCommit(ctx context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error)
+	DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error)
+}
+
+// messageSource reads one partition of a topic, like *kafka.Reader
+type messageSource interface {
+	SetOffset(offset int64) error
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	Close() error
+}
+
+// newKafkaAdmin and newMessageSource connect to the broker; tests replace them
+var (
+	newKafkaAdmin = func(broker string) kafkaAdmin {
+		return &kafka.Client{Addr: kafka.TCP(broker), Timeout: kafkaTimeout}
+	}
+	newMessageSource = func(broker, topic string, partition int) messageSource {
+		return kafka.NewReader(kafka.ReaderConfig{Brokers: []string{broker}, Topic: topic, Partition: partition, MaxWait: time.Second})
+	}
+)
+
+// runKafka runs albumctl kafka <subcommand>
+func runKafka(args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("kafka: missing subcommand\n%s", usage)
+	}
+	fs := flag.NewFlagSet("kafka "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	broker := fs.String("broker", kafkaBrokerFromEnv(), "Kafka broker address")
+	ctx := context.Background()
+
+	switch args[0] {
+	case "offsets":
+		group, err := parseCommand(fs, args[1:], "group")
+		if err != nil {
+			return err
+		}
+		return kafkaOffsets(ctx, newKafkaAdmin(*broker), group, out)
+
+	case "reset":
+		topic := fs.String("topic", "", "topic whose offsets to reset (required)")
+		partition := fs.Int("partition", -1, "only reset this partition")
+		toOffset := fs.Int64("to-offset", unsetResetOffset, "reset to this offset")
+		toTime := fs.String("to-time", "", "reset to the first message at or after this RFC 3339 time")
+		toEarliest := fs.Bool("to-earliest", false, "reset to the oldest retained message")
+		toLatest := fs.Bool("to-latest", false, "reset to the end of the topic, skipping every message")
+		yes := fs.Bool("yes", false, "don't ask for confirmation")
+		group, err := parseCommand(fs, args[1:], "group")
+		if err != nil {
+			return err
+		}
+		if *topic == "" {
+			return errors.New("kafka reset: -topic is required")
+		}
+		target, err := parseResetTarget(*toOffset, *toTime, *toEarliest, *toLatest)
+		if err != nil {
+			return err
+		}
+		if *yes {
+			in = nil
+		}
+		return kafkaReset(ctx, newKafkaAdmin(*broker), group, *topic, *partition, target, in, out)
+
+	case "peek":
+		partition := fs.Int("partition", 0, "partition to read")
+		offset := fs.Int64("offset", -1, "first offset to print (default: the last -count messages)")
+		count := fs.Int("count", defaultPeekCount, "number of messages to print")
+		topic, err := parseCommand(fs, args[1:], "topic")
+		if err != nil {
+			return err
+		}
+		if *count < 1 {
+			return errors.New("kafka peek: -count must be at least 1")
+		}
+		return kafkaPeek(ctx, newKafkaAdmin(*broker), newMessageSource(*broker, topic, *partition), topic, *partition, *offset, *count, out)
+	}
+	return fmt.Errorf("kafka: unknown subcommand %q\n%s", args[0], usage)
+}
+
+// parseCommand parses the flags of a subcommand taking one positional argument, named name, which
+// may come before or after the flags
+func parseCommand(fs *flag.FlagSet, args []string, name string) (string, error) {
+	var arg string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		arg, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	rest := fs.Args()
+	if arg == "" && len(rest) > 0 {
+		arg, rest = rest[0], rest[1:]
+	}
+	if arg == "" {
+		return "", fmt.Errorf("%s: missing <%s>", fs.Name(), name)
+	}
+	if len(rest) > 0 {
+		return "", fmt.Errorf("%s: unexpected arguments %s", fs.Name(), strings.Join(rest, " "))
+	}
+	return arg, nil
+}
+
+// kafkaBrokerFromEnv returns KAFKA_BROKER, without a protocol prefix, or the local default
+func kafkaBrokerFromEnv() string {
+	broker := os.Getenv("KAFKA_BROKER")
+	if broker == "" {
+		return defaultKafkaBroker
+	}
+	if i := strings.Index(broker, "://"); i >= 0 {
+		broker = broker[i+3:]
+	}
+	return broker
+}
+
+// kafkaOffsets prints the committed offset, end offset and lag of every partition group has
+// committed offsets for, with the member each partition is assigned to
+func kafkaOffsets(ctx context.Context, client kafkaAdmin, group string, out io.Writer) error {
+	g, err := describeGroup(ctx, client, group)
+	if err != nil {
+		return err
+	}
+	fetched, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group})
+	if err != nil {
+		return fmt.Errorf("fetching offsets of %s: %w", group, err)
+	}
+	if fetched.Error != nil {
+		return fmt.Errorf("fetching offsets of %s: %w", group, fetched.Error)
+	}
+
+	fmt.Fprintf(out, "Group %s: %s, %d members\n", group, g.GroupState, len(g.Members))
+	if len(fetched.Topics) == 0 {
+		fmt.Fprintln(out, "No committed offsets")
+		return nil
+	}
+
+	assigned := map[string]string{} // "topic/partition" -> member
+	for _, m := range g.Members {
+		for _, t := range m.MemberAssignments.Topics {
+			for _, p := range t.Partitions {
+				assigned[fmt.Sprintf("%s/%d", t.Topic, p)] = m.ClientID + "@" + strings.TrimPrefix(m.ClientHost, "/")
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(fetched.Topics))
+	for topic := range fetched.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCOMMITTED\tEND\tLAG\tMEMBER")
+	var totalLag int64
+	for _, topic := range topics {
+		committed := fetched.Topics[topic]
+		sort.Slice(committed, func(i, j int) bool { return committed[i].Partition < committed[j].Partition })
+		partitions := make([]int, len(committed))
+		for i, p := range committed {
+			partitions[i] = p.Partition
+		}
+		ends, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffset)
+		if err != nil {
+			return err
+		}
+		for _, p := range committed {
+			if p.Error != nil {
+				return fmt.Errorf("fetching offset of %s/%d: %w", topic, p.Partition, p.Error)
+			}
+			committedOffset, lag := "-", "-"
+			if p.CommittedOffset >= 0 {
+				committedOffset = fmt.Sprint(p.CommittedOffset)
+				lag = fmt.Sprint(ends[p.Partition] - p.CommittedOffset)
+				totalLag += ends[p.Partition] - p.CommittedOffset
+			}
+			member := assigned[fmt.Sprintf("%s/%d", topic, p.Partition)]
+			if member == "" {
+				member = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%s\n", topic, p.Partition, committedOffset, ends[p.Partition], lag, member)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nTotal lag: %d\n", totalLag)
+	return nil
+}
+
+// resetTarget is where kafka reset moves a group: exactly one of its fields is set
+type resetTarget struct {
+	offset   int64 // unsetResetOffset when not resetting to an offset
+	at       time.Time
+	earliest bool
+	latest   bool
+}
+
+// parseResetTarget checks that exactly one reset target was given
+func parseResetTarget(offset int64, at string, earliest, latest bool) (resetTarget, error) {
+	t := resetTarget{offset: offset, earliest: earliest, latest: latest}
+	given := 0
+	if offset != unsetResetOffset {
+		if offset < 0 {
+			return t, errors.New("kafka reset: -to-offset can't be negative")
+		}
+		given++
+	}
+	if at != "" {
+		var err error
+		if t.at, err = time.Parse(time.RFC3339, at); err != nil {
+			return t, fmt.Errorf("kafka reset: -to-time must be an RFC 3339 time such as 2024-05-01T12:00:00Z: %w", err)
+		}
+		given++
+	}
+	if earliest {
+		given++
+	}
+	if latest {
+		given++
+	}
+	if given != 1 {
+		return t, errors.New("kafka reset: give exactly one of -to-offset, -to-time, -to-earliest and -to-latest")
+	}
+	return t, nil
+}
+
+// offsetChange is the reset of one partition
+type offsetChange struct {
+	partition int
+	current   int64 // -1 when the group has no committed offset
+	next      int64
+}
+
+// kafkaReset moves group's offsets on topic (one partition, or all when partition is -1) to target.
+// The plan is printed first and, unless in is nil, applied only once the operator confirms it on in.
+// Kafka only accepts offsets for a group from outside it while the group has no members, so the
+// group's consumers have to be stopped first.
+func kafkaReset(ctx context.Context, client kafkaAdmin, group, topic string, partition int, target resetTarget, in io.Reader, out io.Writer) error {
+	g, err := describeGroup(ctx, client, group)
+	if err != nil {
+		return err
+	}
+	if len(g.Members) > 0 || (g.GroupState != groupStateEmpty && g.GroupState != groupStateMissing) {
+		return fmt.Errorf("group %s is %s with %d members; stop its consumers before resetting its offsets", group, g.GroupState, len(g.Members))
+	}
+
+	partitions, err := topicPartitions(ctx, client, topic)
+	if err != nil {
+		return err
+	}
+	if partition >= 0 {
+		if !containsInt(partitions, partition) {
+			return fmt.Errorf("topic %s has no partition %d", topic, partition)
+		}
+		partitions = []int{partition}
+	}
+
+	first, err := listOffsets(ctx, client, topic, partitions, kafka.FirstOffset)
+	if err != nil {
+		return err
+	}
+	last, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffset)
+	if err != nil {
+		return err
+	}
+	var atTime map[int]int64
+	if !target.at.IsZero() {
+		if atTime, err = listOffsets(ctx, client, topic, partitions, target.at.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	fetched, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group, Topics: map[string][]int{topic: partitions}})
+	if err != nil {
+		return fmt.Errorf("fetching offsets of %s: %w", group, err)
+	}
+	if fetched.Error != nil {
+		return fmt.Errorf("fetching offsets of %s: %w", group, fetched.Error)
+	}
+	current := map[int]int64{}
+	for _, p := range fetched.Topics[topic] {
+		current[p.Partition] = p.CommittedOffset
+	}
+
+	changes := make([]offsetChange, len(partitions))
+	for i, p := range partitions {
+		cur, ok := current[p]
+		if !ok {
+			cur = -1
+		}
+		changes[i] = offsetChange{partition: p, current: cur, next: target.resolve(first[p], last[p], atTime[p])}
+	}
+	printResetPlan(out, group, topic, changes)
+
+	if in != nil && !confirm(in, out, fmt.Sprintf("Reset group %s on %s?", group, topic)) {
+		fmt.Fprintln(out, "Aborted, nothing was changed")
+		return nil
+	}
+
+	commits := make([]kafka.OffsetCommit, len(changes))
+	for i, c := range changes {
+		commits[i] = kafka.OffsetCommit{Partition: c.partition, Offset: c.next}
+	}
+	// Generation -1 and no member ID commit as an admin client, outside the group's generations
+	res, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{GroupID: group, GenerationID: -1, Topics: map[string][]kafka.OffsetCommit{topic: commits}})
+	if err != nil {
+		return fmt.Errorf("committing offsets of %s: %w", group, err)
+	}
+	for _, p := range res.Topics[topic] {
+		if p.Error != nil {
+			return fmt.Errorf("committing offset of %s/%d: %w", topic, p.Partition, p.Error)
+		}
+	}
+	fmt.Fprintf(out, "Reset %d partitions of %s for group %s\n", len(changes), topic, group)
+	return nil
+}
+
+// resolve returns the offset target moves a partition to, given the partition's first and last
+// (next to be written) offsets and, for time targets, the offset Kafka found for the time (-1 when
+// no message is that recent). Offsets outside the partition are clamped to it.
+func (t resetTarget) resolve(first, last, atTime int64) int64 {
+	switch {
+	case t.earliest:
+		return first
+	case t.latest:
+		return last
+	case !t.at.IsZero():
+		if atTime < 0 {
+			return last
+		}
+		return atTime
+	case t.offset < first:
+		return first
+	case t.offset > last:
+		return last
+	}
+	return t.offset
+}
+
+// printResetPlan prints the offsets kafka reset is about to change
+func printResetPlan(out io.Writer, group, topic string, changes []offsetChange) {
+	fmt.Fprintf(out, "Group %s, topic %s:\n\n", group, topic)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tCURRENT\tNEW\tSKIPPED")
+	for _, c := range changes {
+		current, skipped := "-", "-"
+		if c.current >= 0 {
+			current = fmt.Sprint(c.current)
+			skipped = fmt.Sprint(c.next - c.current) // Negative when messages will be consumed again
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", c.partition, current, c.next, skipped)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+}
+
+// confirm asks question on out and reports whether the answer read from in is yes
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// kafkaPeek prints count messages of a topic partition from offset, or the last count messages
+// when offset is negative. It stops early at the end of the partition.
+func kafkaPeek(ctx context.Context, client kafkaAdmin, src messageSource, topic string, partition int, offset int64, count int, out io.Writer) error {
+	defer src.Close()
+	partitions := []int{partition}
+	first, err := listOffsets(ctx, client, topic, partitions, kafka.FirstOffset)
+	if err != nil {
+		return err
+	}
+	last, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffset)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		offset = last[partition] - int64(count)
+	}
+	if offset < first[partition] {
+		offset = first[partition]
+	}
+	if offset >= last[partition] {
+		fmt.Fprintf(out, "No messages in %s/%d from offset %d (end %d)\n", topic, partition, offset, last[partition])
+		return nil
+	}
+	if err := src.SetOffset(offset); err != nil {
+		return fmt.Errorf("seeking %s/%d to %d: %w", topic, partition, offset, err)
+	}
+
+	for i := 0; i < count && offset < last[partition]; i++ {
+		readCtx, cancel := context.WithTimeout(ctx, peekReadTimeout)
+		m, err := src.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("reading %s/%d at %d: %w", topic, partition, offset, err)
+		}
+		fmt.Fprint(out, formatMessage(m))
+		offset = m.Offset + 1
+	}
+	return nil
+}
+
+// formatMessage renders a message for kafka peek: its position, key and headers, a summary of its
+// event envelope when it has one, and the JSON payload indented (other payloads as they are)
+func formatMessage(m kafka.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s/%d offset %d", m.Topic, m.Partition, m.Offset)
+	if !m.Time.IsZero() {
+		fmt.Fprintf(&b, " at %s", m.Time.UTC().Format(time.RFC3339))
+	}
+	if m.Key != nil {
+		fmt.Fprintf(&b, " key %q", m.Key)
+	}
+	b.WriteString("\n")
+	for _, h := range m.Headers {
+		fmt.Fprintf(&b, "%s: %s\n", h.Key, h.Value)
+	}
+
+	payload := m.Value
+	var envelope map[string]json.RawMessage
+	if json.Unmarshal(m.Value, &envelope) == nil {
+		// v2 event envelopes (see the Event Envelope section of the README), in either JSON field naming
+		version, eventType, eventID, occurredAt := envelopeField(envelope, "schemaVersion", "schema_version"),
+			envelopeField(envelope, "eventType", "event_type"), envelopeField(envelope, "eventId", "event_id"),
+			envelopeField(envelope, "occurredAt", "occurred_at")
+		if data, ok := envelope["data"]; ok && version != "" && eventType != "" {
+			fmt.Fprintf(&b, "envelope: v%s %s, event %s", version, eventType, eventID)
+			if producer := envelopeField(envelope, "producer"); producer != "" {
+				fmt.Fprintf(&b, ", from %s", producer)
+			}
+			fmt.Fprintf(&b, ", occurred %s\n", occurredAt)
+			payload = data
+		}
+	}
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, payload, "", "  ") == nil {
+		b.Write(indented.Bytes())
+	} else {
+		b.Write(payload)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// envelopeField returns the value of the first of keys present in envelope, unquoted, or ""
+func envelopeField(envelope map[string]json.RawMessage, keys ...string) string {
+	for _, key := range keys {
+		raw, ok := envelope[key]
+		if !ok {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+		return string(raw)
+	}
+	return ""
+}
+
+// describeGroup returns the state and members of group
+func describeGroup(ctx context.Context, client kafkaAdmin, group string) (kafka.DescribeGroupsResponseGroup, error) {
+	res, err := client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{group}})
+	if err != nil {
+		return kafka.DescribeGroupsResponseGroup{}, fmt.Errorf("describing group %s: %w", group, err)
+	}
+	for _, g := range res.Groups {
+		if g.GroupID == group {
+			if g.Error != nil {
+				return g, fmt.Errorf("describing group %s: %w", group, g.Error)
+			}
+			return g, nil
+		}
+	}
+	return kafka.DescribeGroupsResponseGroup{}, fmt.Errorf("describing group %s: not in the broker's response", group)
+}
+
+// topicPartitions returns the partition IDs of topic, in order
+func topicPartitions(ctx context.Context, client kafkaAdmin, topic string) ([]int, error) {
+	res, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata of %s: %w", topic, err)
+	}
+	for _, t := range res.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("reading metadata of %s: %w", topic, t.Error)
+		}
+		partitions := make([]int, len(t.Partitions))
+		for i, p := range t.Partitions {
+			partitions[i] = p.ID
+		}
+		sort.Ints(partitions)
+		return partitions, nil
+	}
+	return nil, fmt.Errorf("topic %s not found", topic)
+}
+
+// listOffsets returns the offset of each of partitions of topic at timestamp: kafka.FirstOffset,
+// kafka.LastOffset or a time in Unix milliseconds. Kafka rejects asking for several timestamps of
+// one partition at once, hence one timestamp per call.
+func listOffsets(ctx context.Context, client kafkaAdmin, topic string, partitions []int, timestamp int64) (map[int]int64, error) {
+	requests := make([]kafka.OffsetRequest, len(partitions))
+	for i, p := range partitions {
+		requests[i] = kafka.OffsetRequest{Partition: p, Timestamp: timestamp}
+	}
+	res, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: requests}})
+	if err != nil {
+		return nil, fmt.Errorf("listing offsets of %s: %w", topic, err)
+	}
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range res.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("listing offsets of %s/%d: %w", topic, p.Partition, p.Error)
+		}
+		switch timestamp {
+		case kafka.FirstOffset:
+			offsets[p.Partition] = p.FirstOffset
+		case kafka.LastOffset:
+			offsets[p.Partition] = p.LastOffset
+		default:
+			offsets[p.Partition] = -1
+			for offset := range p.Offsets {
+				offsets[p.Partition] = offset
+			}
+		}
+	}
+	for _, p := range partitions {
+		if _, ok := offsets[p]; !ok {
+			return nil, fmt.Errorf("listing offsets of %s/%d: partition missing from the broker's response", topic, p)
+		}
+	}
+	return offsets, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKafka is a one-topic cluster with a consumer group
+type fakeKafka struct {
+	topic     string
+	first     map[int]int64
+	last      map[int]int64
+	byTime    map[int]int64
+	committed map[int]int64
+	group     kafka.DescribeGroupsResponseGroup
+	commits   []kafka.OffsetCommit
+}
+
+func (f *fakeKafka) Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	t := kafka.Topic{Name: f.topic}
+	for p := range f.first {
+		t.Partitions = append(t.Partitions, kafka.Partition{Topic: f.topic, ID: p})
+	}
+	return &kafka.MetadataResponse{Topics: []kafka.Topic{t}}, nil
+}
+
+func (f *fakeKafka) ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error) {
+	res := &kafka.ListOffsetsResponse{Topics: map[string][]kafka.PartitionOffsets{}}
+	for topic, requests := range req.Topics {
+		for _, r := range requests {
+			p := kafka.PartitionOffsets{Partition: r.Partition, FirstOffset: -1, LastOffset: -1, Offsets: map[int64]time.Time{}}
+			switch r.Timestamp {
+			case kafka.FirstOffset:
+				p.FirstOffset = f.first[r.Partition]
+			case kafka.LastOffset:
+				p.LastOffset = f.last[r.Partition]
+			default:
+				p.Offsets[f.byTime[r.Partition]] = time.UnixMilli(r.Timestamp)
+			}
+			res.Topics[topic] = append(res.Topics[topic], p)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeKafka) OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error) {
+	res := &kafka.OffsetFetchResponse{Topics: map[string][]kafka.OffsetFetchPartition{}}
+	for p, offset := range f.committed {
+		res.Topics[f.topic] = append(res.Topics[f.topic], kafka.OffsetFetchPartition{Partition: p, CommittedOffset: offset})
+	}
+	return res, nil
+}
+
+func (f *fakeKafka) OffsetCommit(ctx context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error) {
+	f.commits = append(f.commits, req.Topics[f.topic]...)
+	return &kafka.OffsetCommitResponse{}, nil
+}
+
+func (f *fakeKafka) DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error) {
+	return &kafka.DescribeGroupsResponse{Groups: []kafka.DescribeGroupsResponseGroup{f.group}}, nil
+}
+
+// fakeSource serves messages from offset 0 on
+type fakeSource struct {
+	messages []kafka.Message
+	next     int64
+}
+
+func (s *fakeSource) SetOffset(offset int64) error { s.next = offset; return nil }
+func (s *fakeSource) Close() error                 { return nil }
+
+func (s *fakeSource) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	if s.next >= int64(len(s.messages)) {
+		return kafka.Message{}, errors.New("no more messages")
+	}
+	m := s.messages[s.next]
+	s.next++
+	return m, nil
+}
+
+func withFakeKafka(t *testing.T, f *fakeKafka, src *fakeSource) {
+	originalAdmin, originalSource := newKafkaAdmin, newMessageSource
+	newKafkaAdmin = func(string) kafkaAdmin { return f }
+	newMessageSource = func(string, string, int) messageSource { return src }
+	t.Cleanup(func() { newKafkaAdmin, newMessageSource = originalAdmin, originalSource })
+}
+
+func TestParseResetTarget(t *testing.T) {
+	target, err := parseResetTarget(unsetResetOffset, "2024-05-01T12:00:00Z", false, false)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), target.at)
+
+	for name, args := range map[string]struct {
+		offset   int64
+		at       string
+		earliest bool
+		latest   bool
+	}{
+		"none":        {offset: unsetResetOffset},
+		"two":         {offset: 5, latest: true},
+		"bad time":    {offset: unsetResetOffset, at: "yesterday"},
+		"negative":    {offset: -3},
+		"time+offset": {offset: 0, at: "2024-05-01T12:00:00Z"},
+	} {
+		_, err := parseResetTarget(args.offset, args.at, args.earliest, args.latest)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestResetTargetResolve(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		target resetTarget
+		atTime int64
+		want   int64
+	}{
+		{resetTarget{offset: unsetResetOffset, earliest: true}, -1, 100},
+		{resetTarget{offset: unsetResetOffset, latest: true}, -1, 250},
+		{resetTarget{offset: 180}, -1, 180},
+		{resetTarget{offset: 3}, -1, 100},    // Already deleted by retention
+		{resetTarget{offset: 9000}, -1, 250}, // Not written yet
+		{resetTarget{offset: unsetResetOffset, at: at}, 204, 204},
+		{resetTarget{offset: unsetResetOffset, at: at}, -1, 250}, // Nothing since
+	} {
+		assert.Equal(t, tc.want, tc.target.resolve(100, 250, tc.atTime), "%+v", tc.target)
+	}
+}
+
+func TestKafkaOffsets(t *testing.T) {
+	f := &fakeKafka{
+		topic:     "order-succeeded",
+		last:      map[int]int64{0: 125, 1: 40},
+		committed: map[int]int64{0: 120, 1: -1},
+		group: kafka.DescribeGroupsResponseGroup{GroupID: "album-service-sales", GroupState: "Stable", Members: []kafka.DescribeGroupsResponseMember{{
+			ClientID: "album-service", ClientHost: "/172.18.0.5",
+			MemberAssignments: kafka.DescribeGroupsResponseAssignments{Topics: []kafka.GroupMemberTopic{{Topic: "order-succeeded", Partitions: []int{0, 1}}}},
+		}}},
+	}
+	withFakeKafka(t, f, nil)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"kafka", "offsets", "album-service-sales"}, nil, &out))
+	assert.Contains(t, out.String(), "Group album-service-sales: Stable, 1 members")
+	assert.Regexp(t, `order-succeeded\s+0\s+120\s+125\s+5\s+album-service@172.18.0.5`, out.String())
+	assert.Regexp(t, `order-succeeded\s+1\s+-\s+40\s+-`, out.String())
+	assert.Contains(t, out.String(), "Total lag: 5")
+}
+
+func TestKafkaReset(t *testing.T) {
+	newFake := func() *fakeKafka {
+		return &fakeKafka{
+			topic:     "order-created",
+			first:     map[int]int64{0: 10, 1: 0},
+			last:      map[int]int64{0: 90, 1: 30},
+			byTime:    map[int]int64{0: 42, 1: -1},
+			committed: map[int]int64{0: 90, 1: 30},
+			group:     kafka.DescribeGroupsResponseGroup{GroupID: "inventory-service-consumers", GroupState: "Empty"},
+		}
+	}
+	reset := func(input string, args ...string) (string, error) {
+		var out bytes.Buffer
+		var in io.Reader = strings.NewReader(input)
+		err := run(append([]string{"kafka", "reset", "inventory-service-consumers", "-topic", "order-created"}, args...), in, &out)
+		return out.String(), err
+	}
+
+	t.Run("Applied after confirmation", func(t *testing.T) {
+		f := newFake()
+		withFakeKafka(t, f, nil)
+		out, err := reset("y\n", "-to-time", "2024-05-01T12:00:00Z")
+		require.NoError(t, err)
+		assert.Regexp(t, `0\s+90\s+42\s+-48`, out)
+		assert.Contains(t, out, "Reset group inventory-service-consumers on order-created? [y/N]")
+		assert.Equal(t, []kafka.OffsetCommit{{Partition: 0, Offset: 42}, {Partition: 1, Offset: 30}}, f.commits)
+	})
+
+	t.Run("Nothing changes without confirmation", func(t *testing.T) {
+		f := newFake()
+		withFakeKafka(t, f, nil)
+		out, err := reset("\n", "-to-earliest")
+		require.NoError(t, err)
+		assert.Contains(t, out, "Aborted")
+		assert.Empty(t, f.commits)
+	})
+
+	t.Run("-yes skips the confirmation", func(t *testing.T) {
+		f := newFake()
+		withFakeKafka(t, f, nil)
+		_, err := reset("", "-to-offset", "50", "-partition", "0", "-yes")
+		require.NoError(t, err)
+		assert.Equal(t, []kafka.OffsetCommit{{Partition: 0, Offset: 50}}, f.commits)
+	})
+
+	t.Run("Groups with members are refused", func(t *testing.T) {
+		f := newFake()
+		f.group.GroupState = "Stable"
+		f.group.Members = []kafka.DescribeGroupsResponseMember{{ClientID: "inventory-service"}}
+		withFakeKafka(t, f, nil)
+		_, err := reset("y\n", "-to-latest")
+		assert.ErrorContains(t, err, "stop its consumers")
+		assert.Empty(t, f.commits)
+	})
+
+	t.Run("Unknown partition", func(t *testing.T) {
+		withFakeKafka(t, newFake(), nil)
+		_, err := reset("y\n", "-to-latest", "-partition", "7")
+		assert.ErrorContains(t, err, "no partition 7")
+	})
+}
+
+func TestKafkaPeek(t *testing.T) {
+	src := &fakeSource{}
+	for i := 0; i < 5; i++ {
+		src.messages = append(src.messages, kafka.Message{Topic: "order-failed", Offset: int64(i), Key: []byte("42"), Value: []byte(`{"orderId":"42"}`)})
+	}
+	withFakeKafka(t, &fakeKafka{topic: "order-failed", first: map[int]int64{0: 0}, last: map[int]int64{0: 5}}, src)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"kafka", "peek", "order-failed", "-count", "2"}, nil, &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "---"))
+	assert.Contains(t, out.String(), "order-failed/0 offset 3")
+	assert.Contains(t, out.String(), "order-failed/0 offset 4")
+
+	out.Reset()
+	require.NoError(t, run([]string{"kafka", "peek", "-offset", "3", "-count", "10", "order-failed"}, nil, &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "---"), "stops at the end of the partition")
+}
+
+func TestFormatMessage(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("v2 envelopes are summarized", func(t *testing.T) {
+		s := formatMessage(kafka.Message{Topic: "order-failed.v2", Partition: 1, Offset: 41, Time: at, Key: []byte("42"),
+			Headers: []kafka.Header{{Key: "traceparent", Value: []byte("00-abc-def-01")}},
+			Value:   []byte(`{"schemaVersion":2,"eventType":"order.failed","eventId":"3f1c2a9e-6b7d-5e40-8a21-9c0d4b6e7f18","producer":"inventory-service","occurredAt":"2024-05-01T12:00:00Z","data":{"orderId":"42","reason":"OUT_OF_STOCK"}}`)})
+		assert.Equal(t, `--- order-failed.v2/1 offset 41 at 2024-05-01T12:00:00Z key "42"
+traceparent: 00-abc-def-01
+envelope: v2 order.failed, event 3f1c2a9e-6b7d-5e40-8a21-9c0d4b6e7f18, from inventory-service, occurred 2024-05-01T12:00:00Z
+{
+  "orderId": "42",
+  "reason": "OUT_OF_STOCK"
+}
+`, s)
+	})
+
+	t.Run("snake_case envelopes", func(t *testing.T) {
+		s := formatMessage(kafka.Message{Value: []byte(`{"schema_version":2,"event_type":"order.succeeded","event_id":"order.succeeded:7","data":{}}`)})
+		assert.Contains(t, s, "envelope: v2 order.succeeded, event order.succeeded:7")
+	})
+
+	t.Run("v1 payloads are indented", func(t *testing.T) {
+		s := formatMessage(kafka.Message{Value: []byte(`{"albumId":"4","initialQuantity":10}`)})
+		assert.NotContains(t, s, "envelope")
+		assert.Contains(t, s, "{\n  \"albumId\": \"4\",\n  \"initialQuantity\": 10\n}\n")
+	})
+
+	t.Run("Other payloads are printed as they are", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(formatMessage(kafka.Message{Value: []byte("not json")}), "\nnot json\n"))
+	})
+}
+
+func TestRun_Usage(t *testing.T) {
+	assert.Error(t, run(nil, nil, io.Discard))
+	assert.ErrorContains(t, run([]string{"kafka", "offsets"}, nil, io.Discard), "missing <group>")
+	assert.ErrorContains(t, run([]string{"kafka", "reset", "g", "-to-latest"}, nil, io.Discard), "-topic is required")
+	assert.ErrorContains(t, run([]string{"kafka", "rewind"}, nil, io.Discard), "unknown subcommand")
+}
@@ -0,0 +1,51 @@
+// albumctl - maintenance commands for operators. It is built into the album-service image and reads
+// the same environment, so it runs with docker compose exec album-service ./albumctl.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: albumctl <command> [arguments]
+
+commands:
+  kafka offsets <group>                 committed offsets and lag of a consumer group
+  kafka reset <group> -topic <topic>    move a stopped consumer group to
+        (-to-offset N | -to-time RFC3339 | -to-earliest | -to-latest) [-partition P] [-yes]
+  kafka peek <topic>                    print messages, decoding event envelopes
+        [-partition P] [-offset N] [-count N]
+  archive export [-dir DIR]             write the catalog to a timestamped tarball
+                                        (default dir $ARCHIVE_DIR, then .)
+  archive import <file> [-yes]          restore an archive into an empty catalog
+
+Every kafka command takes -broker (default $KAFKA_BROKER, then localhost:9092). archive commands
+connect to $DB_CONNECTION. The archive directory may be s3://bucket/prefix and the file
+s3://bucket/key, for S3-compatible object storage configured with the AWS_* variables.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "albumctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command in args, reading confirmations from in and writing output to out
+func run(args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", usage)
+	}
+	switch args[0] {
+	case "kafka":
+		return runKafka(args[1:], in, out)
+	case "archive":
+		return runArchive(args[1:], in, out)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(out, usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
@@ -0,0 +1,211 @@
+// objectstore.go - S3-compatible object storage for archives: archive export writes to an
+// s3://bucket/prefix destination and archive import reads an s3://bucket/key archive. Requests are
+// plain HTTP signed with AWS Signature Version 4, so the same code works against AWS S3, MinIO,
+// Ceph and other S3-compatible stores. Buckets are addressed path-style (endpoint/bucket/key),
+// which every S3-compatible store accepts.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	objectURLScheme  = "s3://"
+	defaultS3Region  = "us-east-1"
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of no bytes
+)
+
+var errNoObjectCredentials = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use object storage")
+
+// objectLocation is a bucket and a key, or a key prefix for export destinations
+type objectLocation struct {
+	bucket string
+	key    string
+}
+
+func (l objectLocation) String() string {
+	return objectURLScheme + l.bucket + "/" + l.key
+}
+
+// isObjectURL reports whether an archive destination or source is in object storage
+func isObjectURL(s string) bool {
+	return strings.HasPrefix(s, objectURLScheme)
+}
+
+// parseObjectURL parses s3://bucket/key. The key may be empty, for an export to the bucket root.
+func parseObjectURL(s string) (objectLocation, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(s, objectURLScheme), "/")
+	if bucket == "" {
+		return objectLocation{}, fmt.Errorf("%q has no bucket, expected s3://bucket/key", s)
+	}
+	return objectLocation{bucket: bucket, key: strings.Trim(key, "/")}, nil
+}
+
+// objectStore is an S3-compatible endpoint and the credentials to sign requests with
+type objectStore struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// objectStoreFromEnv reads the standard AWS variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION (default us-east-1) and AWS_ENDPOINT_URL_S3 (default the AWS S3
+// endpoint of the region; set it for MinIO and other S3-compatible stores).
+func objectStoreFromEnv() (*objectStore, error) {
+	s := &objectStore{
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errNoObjectCredentials
+	}
+	if s.region == "" {
+		s.region = defaultS3Region
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("AWS_ENDPOINT_URL_S3 %q is not an http(s) URL", endpoint)
+	}
+	s.endpoint = u
+	return s, nil
+}
+
+// put uploads body as the object at loc. A single PUT either stores the whole object or nothing,
+// so a failed export never leaves an archive behind.
+func (s *objectStore) put(ctx context.Context, loc objectLocation, body []byte) error {
+	sum := sha256.Sum256(body)
+	resp, err := s.do(ctx, http.MethodPut, loc, body, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get opens the object at loc. The caller closes the body.
+func (s *objectStore) get(ctx context.Context, loc objectLocation) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, loc, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do sends a signed request for the object at loc. Any status but 2xx is an error carrying the
+// S3 error code.
+func (s *objectStore) do(ctx context.Context, method string, loc objectLocation, body []byte, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + "/" + loc.bucket + "/" + loc.key
+	u.RawPath = base + "/" + sigV4Escape(loc.bucket) + "/" + sigV4Escape(loc.key)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "albumctl")
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s %s", method, loc, resp.Status, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, loc, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds the X-Amz-* headers and the Signature Version 4 Authorization header. Every header
+// already set on the request is signed, along with the host.
+func (s *objectStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signedHeaders, payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4Escape percent-encodes everything but unreserved characters and slashes, as Signature
+// Version 4 expects of S3 object paths
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
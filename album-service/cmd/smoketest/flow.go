@@ -0,0 +1,321 @@
+// flow.go - the scripted steps. Each waits for the event it depends on to be processed rather than
+// sleeping a fixed time. Once a step fails the rest are skipped, except the cleanup steps, which
+// delete the album if it was created.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	requestTimeout  = 10 * time.Second
+	pollInterval    = 500 * time.Millisecond
+	initialQuantity = 5
+	orderQuantity   = 1
+	maxErrorBody    = 300
+)
+
+// errSkipped is returned by a cleanup step with nothing to clean up
+var errSkipped = errors.New("skipped")
+
+// permanentError stops eventually from retrying, e.g. for an order that failed
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// step is one check of the flow
+type step struct {
+	name    string
+	cleanup bool // Runs even after a failure
+	run     func(ctx context.Context) error
+}
+
+// flow holds what the steps learn about the album and order they create
+type flow struct {
+	cfg     config
+	client  *http.Client
+	albumID string
+	orderID int64
+	deleted bool
+}
+
+func newFlow(cfg config) *flow {
+	return &flow{cfg: cfg, client: &http.Client{Timeout: requestTimeout}}
+}
+
+// steps lists the flow in order
+func (f *flow) steps() []step {
+	return []step{
+		{name: "create album", run: f.createAlbum},
+		{name: "publish album", run: f.publishAlbum},
+		{name: "inventory initialized", run: f.inventoryInitialized},
+		{name: "place order", run: f.placeOrder},
+		{name: "order succeeded", run: f.orderSucceeded},
+		{name: "stock deducted", run: f.stockDeducted},
+		{name: "order saga completed", run: f.sagaCompleted},
+		{name: "delete album", cleanup: true, run: f.deleteAlbum},
+		{name: "inventory archived", cleanup: true, run: f.inventoryArchived},
+	}
+}
+
+// run runs every step, printing each result to out, and returns the report
+func (f *flow) run(out io.Writer) Report {
+	ctx := context.Background()
+	report := Report{Environment: f.cfg.environment, StartedAt: time.Now().UTC(), Passed: true}
+	failed := false
+	for _, s := range f.steps() {
+		result := StepResult{Name: s.name, Status: statusSkipped}
+		if !failed || s.cleanup {
+			start := time.Now()
+			err := s.run(ctx)
+			result.Duration = time.Since(start)
+			switch {
+			case errors.Is(err, errSkipped):
+			case err != nil:
+				result.Status, result.Error = statusFailed, err.Error()
+				failed, report.Passed = true, false
+			default:
+				result.Status = statusPassed
+			}
+		}
+		printResult(out, result)
+		report.Steps = append(report.Steps, result)
+	}
+	report.Duration = time.Since(report.StartedAt)
+	return report
+}
+
+func (f *flow) createAlbum(ctx context.Context) error {
+	album := map[string]interface{}{
+		"title":           "Smoke Test " + time.Now().UTC().Format("20060102T150405Z"),
+		"artist":          "smoketest",
+		"price":           9.99,
+		"releaseYear":     time.Now().Year(),
+		"genre":           "Unknown",
+		"initialQuantity": initialQuantity,
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := f.call(ctx, http.MethodPost, f.cfg.albumURL+"/api/albums", "admin", album, http.StatusCreated, &created); err != nil {
+		return err
+	}
+	if created.ID == "" {
+		return errors.New("created album has no id")
+	}
+	f.albumID = created.ID
+	return nil
+}
+
+// publishAlbum makes the album orderable, since order-service prices it as a public caller
+func (f *flow) publishAlbum(ctx context.Context) error {
+	return f.call(ctx, http.MethodPost, f.cfg.albumURL+"/api/albums/"+f.albumID+"/publish", "admin", nil, http.StatusOK, nil)
+}
+
+// inventoryInitialized waits for inventory-service to consume album-created
+func (f *flow) inventoryInitialized(ctx context.Context) error {
+	return f.eventually(ctx, func() error {
+		inv, err := f.inventory(ctx)
+		if err != nil {
+			return err
+		}
+		if !inv.Initialized {
+			return errors.New("inventory not initialized")
+		}
+		if inv.QuantityAvailable != initialQuantity {
+			return fmt.Errorf("quantity is %d, expected %d", inv.QuantityAvailable, initialQuantity)
+		}
+		return nil
+	})
+}
+
+func (f *flow) placeOrder(ctx context.Context) error {
+	order := map[string]interface{}{"albumId": f.albumID, "quantity": orderQuantity, "userId": f.cfg.userID}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := f.call(ctx, http.MethodPost, f.cfg.orderURL+"/api/orders", "user", order, http.StatusCreated, &created); err != nil {
+		return err
+	}
+	if created.ID == 0 {
+		return errors.New("created order has no id")
+	}
+	f.orderID = created.ID
+	return nil
+}
+
+// orderSucceeded waits for order-service to consume order-succeeded and record it in the timeline
+func (f *flow) orderSucceeded(ctx context.Context) error {
+	return f.eventually(ctx, func() error {
+		var order struct {
+			Status   string `json:"status"`
+			Timeline []struct {
+				Status        string `json:"status"`
+				Reason        string `json:"reason"`
+				SourceEventID string `json:"sourceEventId"`
+			} `json:"timeline"`
+		}
+		url := f.cfg.orderURL + "/api/orders/" + strconv.FormatInt(f.orderID, 10)
+		if err := f.call(ctx, http.MethodGet, url, "admin", nil, http.StatusOK, &order); err != nil {
+			return err
+		}
+		for _, change := range order.Timeline {
+			if change.Status == "FAILED" {
+				return permanentError{fmt.Errorf("order failed: %s", change.Reason)}
+			}
+			if change.Status == "SUCCEEDED" {
+				if change.SourceEventID == "" {
+					return permanentError{errors.New("order succeeded without a source event")}
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("order is %s", order.Status)
+	})
+}
+
+// stockDeducted checks the order's quantity came off the stock
+func (f *flow) stockDeducted(ctx context.Context) error {
+	inv, err := f.inventory(ctx)
+	if err != nil {
+		return err
+	}
+	if want := initialQuantity - orderQuantity; inv.QuantityAvailable != want {
+		return fmt.Errorf("quantity is %d, expected %d", inv.QuantityAvailable, want)
+	}
+	return nil
+}
+
+// sagaCompleted checks inventory-service recorded the deduction and published the outcome
+func (f *flow) sagaCompleted(ctx context.Context) error {
+	var saga struct {
+		Status string `json:"status"`
+	}
+	url := f.cfg.inventoryURL + "/api/orders/" + strconv.FormatInt(f.orderID, 10) + "/saga"
+	if err := f.call(ctx, http.MethodGet, url, "admin", nil, http.StatusOK, &saga); err != nil {
+		return err
+	}
+	if saga.Status != "succeeded" {
+		return fmt.Errorf("saga is %s", saga.Status)
+	}
+	return nil
+}
+
+func (f *flow) deleteAlbum(ctx context.Context) error {
+	if f.albumID == "" {
+		return errSkipped
+	}
+	if err := f.call(ctx, http.MethodDelete, f.cfg.albumURL+"/api/albums/"+f.albumID, "admin", nil, http.StatusNoContent, nil); err != nil {
+		return err
+	}
+	f.deleted = true
+	return nil
+}
+
+// inventoryArchived waits for inventory-service to consume album-deleted
+func (f *flow) inventoryArchived(ctx context.Context) error {
+	if !f.deleted {
+		return errSkipped
+	}
+	return f.eventually(ctx, func() error {
+		inv, err := f.inventory(ctx)
+		if err != nil {
+			return err
+		}
+		if inv.Initialized {
+			return errors.New("inventory still present")
+		}
+		return nil
+	})
+}
+
+// inventoryRecord is the part of an inventory-service record the steps check
+type inventoryRecord struct {
+	QuantityAvailable int  `json:"quantityAvailable"`
+	Initialized       bool `json:"initialized"`
+}
+
+func (f *flow) inventory(ctx context.Context) (inventoryRecord, error) {
+	var inv inventoryRecord
+	err := f.call(ctx, http.MethodGet, f.cfg.inventoryURL+"/api/inventory/"+f.albumID, "", nil, http.StatusOK, &inv)
+	return inv, err
+}
+
+// eventually retries check every pollInterval until it passes, returns a permanentError, or
+// cfg.wait has passed. The error is the last one check returned.
+func (f *flow) eventually(ctx context.Context, check func() error) error {
+	deadline := time.Now().Add(f.cfg.wait)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("after %s: %w", f.cfg.wait, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// call sends body as JSON, expects status want, and decodes the response into out unless it is nil
+func (f *flow) call(ctx context.Context, method, url, clientType string, body interface{}, want int, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if clientType != "" {
+		req.Header.Set("Client-Type", clientType)
+	}
+	req.Header.Set("User-Agent", "smoketest")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != want {
+		if len(data) > maxErrorBody {
+			data = data[:maxErrorBody]
+		}
+		return fmt.Errorf("%s %s answered %s, expected %d: %s", method, url, resp.Status, want, bytes.TrimSpace(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, url, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// smoketest - post-deploy end-to-end check of album-service, inventory-service and order-service. It
+// creates an album, waits for inventory-service to initialize its stock, places an order, waits for
+// the deduction and the outcome events, and deletes the album again. Each step is reported on
+// stdout and, if asked, as a JUnit and/or JSON report for the deploy pipeline. It exits with status
+// 1 if any step fails.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAlbumServiceURL     = "http://localhost:8080"
+	defaultInventoryServiceURL = "http://localhost:8081"
+	defaultOrderServiceURL     = "http://localhost:8082"
+	defaultWait                = 30 * time.Second
+	defaultUserID              = "smoketest"
+)
+
+// errFailed is returned when the flow ran and at least one step failed
+var errFailed = errors.New("smoke test failed")
+
+// config is where the services are and how long to wait for their events
+type config struct {
+	environment  string
+	albumURL     string
+	inventoryURL string
+	orderURL     string
+	userID       string
+	wait         time.Duration
+	junitPath    string
+	jsonPath     string
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if errors.Is(err, errFailed) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "smoketest:", err)
+		os.Exit(2)
+	}
+}
+
+// run parses args, runs the flow and writes the reports
+func run(args []string, out io.Writer) error {
+	cfg, err := parseConfig(args, out)
+	if err != nil {
+		return err
+	}
+	report := newFlow(cfg).run(out)
+
+	if cfg.junitPath != "" {
+		if err := writeReport(cfg.junitPath, report.writeJUnit); err != nil {
+			return fmt.Errorf("write JUnit report: %w", err)
+		}
+	}
+	if cfg.jsonPath != "" {
+		if err := writeReport(cfg.jsonPath, report.writeJSON); err != nil {
+			return fmt.Errorf("write JSON report: %w", err)
+		}
+	}
+	if !report.Passed {
+		return errFailed
+	}
+	return nil
+}
+
+// parseConfig reads the flags, which default to the environment
+func parseConfig(args []string, out io.Writer) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet("smoketest", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&cfg.environment, "env", os.Getenv("SMOKETEST_ENVIRONMENT"), "environment name for the reports, e.g. staging")
+	fs.StringVar(&cfg.albumURL, "album-url", envOr("ALBUM_SERVICE_URL", defaultAlbumServiceURL), "album-service base URL")
+	fs.StringVar(&cfg.inventoryURL, "inventory-url", envOr("INVENTORY_SERVICE_URL", defaultInventoryServiceURL), "inventory-service base URL")
+	fs.StringVar(&cfg.orderURL, "order-url", envOr("ORDER_SERVICE_URL", defaultOrderServiceURL), "order-service base URL")
+	fs.StringVar(&cfg.userID, "user", envOr("SMOKETEST_USER_ID", defaultUserID), "user ID the test order is placed for")
+	fs.DurationVar(&cfg.wait, "wait", defaultWait, "how long to wait for each event to be processed")
+	fs.StringVar(&cfg.junitPath, "junit", "", "write a JUnit XML report to this file")
+	fs.StringVar(&cfg.jsonPath, "json", "", "write a JSON report to this file")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if cfg.wait <= 0 {
+		return cfg, errors.New("-wait must be positive")
+	}
+	for _, u := range []*string{&cfg.albumURL, &cfg.inventoryURL, &cfg.orderURL} {
+		*u = strings.TrimRight(*u, "/")
+	}
+	return cfg, nil
+}
+
+// envOr returns the environment variable name, or fallback when it is unset
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// writeReport creates path and writes a report to it with write
+func writeReport(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
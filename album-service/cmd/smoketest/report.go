@@ -0,0 +1,142 @@
+// report.go - the results of a run, printed as they come and written as JUnit XML or JSON
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Step statuses
+const (
+	statusPassed  = "passed"
+	statusFailed  = "failed"
+	statusSkipped = "skipped" // After an earlier failure, or a cleanup with nothing to clean up
+)
+
+// Report is the outcome of one run
+type Report struct {
+	Environment string        `json:"environment,omitempty"`
+	StartedAt   time.Time     `json:"startedAt"`
+	Duration    time.Duration `json:"durationMs"`
+	Passed      bool          `json:"passed"`
+	Steps       []StepResult  `json:"steps"`
+}
+
+// StepResult is the outcome of one step
+type StepResult struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"durationMs"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// MarshalJSON writes the durations in milliseconds
+func (r Report) MarshalJSON() ([]byte, error) {
+	type plain Report
+	return json.Marshal(struct {
+		plain
+		Duration int64 `json:"durationMs"`
+	}{plain(r), r.Duration.Milliseconds()})
+}
+
+// MarshalJSON writes the duration in milliseconds
+func (s StepResult) MarshalJSON() ([]byte, error) {
+	type plain StepResult
+	return json.Marshal(struct {
+		plain
+		Duration int64 `json:"durationMs"`
+	}{plain(s), s.Duration.Milliseconds()})
+}
+
+// printResult writes a step's result as one line
+func printResult(out io.Writer, r StepResult) {
+	switch r.Status {
+	case statusPassed:
+		fmt.Fprintf(out, "PASS %s (%s)\n", r.Name, r.Duration.Round(time.Millisecond))
+	case statusFailed:
+		fmt.Fprintf(out, "FAIL %s (%s): %s\n", r.Name, r.Duration.Round(time.Millisecond), r.Error)
+	default:
+		fmt.Fprintf(out, "SKIP %s\n", r.Name)
+	}
+}
+
+func (r Report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// JUnit XML, as read by CI systems: one test suite, one test case per step
+type (
+	junitSuite struct {
+		XMLName    xml.Name        `xml:"testsuite"`
+		Name       string          `xml:"name,attr"`
+		Tests      int             `xml:"tests,attr"`
+		Failures   int             `xml:"failures,attr"`
+		Skipped    int             `xml:"skipped,attr"`
+		Time       string          `xml:"time,attr"`
+		Timestamp  string          `xml:"timestamp,attr"`
+		Properties []junitProperty `xml:"properties>property,omitempty"`
+		Cases      []junitCase     `xml:"testcase"`
+	}
+	junitProperty struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Skipped   *struct{}     `xml:"skipped,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+func (r Report) writeJUnit(w io.Writer) error {
+	className := "smoketest"
+	suite := junitSuite{
+		Name:      className,
+		Tests:     len(r.Steps),
+		Time:      junitSeconds(r.Duration),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+	if r.Environment != "" {
+		className += "." + r.Environment
+		suite.Name = className
+		suite.Properties = []junitProperty{{Name: "environment", Value: r.Environment}}
+	}
+	for _, s := range r.Steps {
+		c := junitCase{Name: s.Name, ClassName: className, Time: junitSeconds(s.Duration)}
+		switch s.Status {
+		case statusFailed:
+			c.Failure = &junitFailure{Message: s.Error}
+			suite.Failures++
+		case statusSkipped:
+			c.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
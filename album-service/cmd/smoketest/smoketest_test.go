@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore answers for all three services. Events are processed on the second poll, to exercise
+// the waits.
+type fakeStore struct {
+	mu          sync.Mutex
+	failOrder   bool
+	quantity    int
+	initialized bool
+	polls       int
+	orderStatus string
+	deleted     bool
+}
+
+func (s *fakeStore) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/albums", func(w http.ResponseWriter, r *http.Request) {
+		var album struct {
+			InitialQuantity int `json:"initialQuantity"`
+		}
+		json.NewDecoder(r.Body).Decode(&album)
+		s.quantity = album.InitialQuantity
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"42","status":"draft"}`))
+	})
+	mux.HandleFunc("/api/albums/42/publish", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"42","status":"published"}`))
+	})
+	mux.HandleFunc("/api/albums/42", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.Header.Get("Client-Type") != "admin" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/api/inventory/42", func(w http.ResponseWriter, r *http.Request) {
+		s.polls++
+		if s.polls == 2 {
+			s.initialized = true
+		}
+		if s.deleted {
+			s.initialized = false
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"albumId": "42", "quantityAvailable": s.quantity, "initialized": s.initialized})
+	})
+	mux.HandleFunc("/api/orders", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Client-Type") != "user" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.orderStatus = "PENDING"
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7,"albumId":"42","quantity":1,"userId":"smoketest","status":"PENDING"}`))
+	})
+	mux.HandleFunc("/api/orders/7", func(w http.ResponseWriter, r *http.Request) {
+		timeline := []map[string]string{{"status": "PENDING", "sourceEventId": "order.created:7"}}
+		if s.orderStatus == "PENDING" {
+			// Processed now, seen on the next poll
+			s.orderStatus = "SUCCEEDED"
+			if s.failOrder {
+				s.orderStatus = "FAILED"
+			} else {
+				s.quantity--
+			}
+		} else {
+			outcome := map[string]string{"status": s.orderStatus, "sourceEventId": "order.succeeded:7"}
+			if s.failOrder {
+				outcome["reason"], outcome["sourceEventId"] = "INSUFFICIENT_STOCK", "order.failed:7"
+			}
+			timeline = append(timeline, outcome)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "status": s.orderStatus, "timeline": timeline})
+	})
+	mux.HandleFunc("/api/orders/7/saga", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"orderId":"7","status":"succeeded","steps":[]}`))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func runAgainst(t *testing.T, store *fakeStore, extra ...string) (string, error) {
+	server := httptest.NewServer(store.handler())
+	t.Cleanup(server.Close)
+	var out bytes.Buffer
+	args := append([]string{"-album-url", server.URL + "/", "-inventory-url", server.URL, "-order-url", server.URL, "-wait", "5s"}, extra...)
+	err := run(args, &out)
+	return out.String(), err
+}
+
+func TestSmokeTestPasses(t *testing.T) {
+	dir := t.TempDir()
+	junitPath, jsonPath := filepath.Join(dir, "smoke.xml"), filepath.Join(dir, "smoke.json")
+	out, err := runAgainst(t, &fakeStore{}, "-env", "staging", "-junit", junitPath, "-json", jsonPath)
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "PASS create album")
+	assert.Contains(t, out, "PASS inventory archived")
+	assert.NotContains(t, out, "FAIL")
+
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	var report struct {
+		Environment string `json:"environment"`
+		Passed      bool   `json:"passed"`
+		Steps       []struct {
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			DurationMs *int64 `json:"durationMs"`
+		} `json:"steps"`
+	}
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "staging", report.Environment)
+	assert.True(t, report.Passed)
+	require.Len(t, report.Steps, 9)
+	assert.Equal(t, "order succeeded", report.Steps[4].Name)
+	assert.Equal(t, statusPassed, report.Steps[4].Status)
+	assert.NotNil(t, report.Steps[4].DurationMs)
+
+	data, err = os.ReadFile(junitPath)
+	require.NoError(t, err)
+	var suite junitSuite
+	require.NoError(t, xml.Unmarshal(data, &suite))
+	assert.Equal(t, "smoketest.staging", suite.Name)
+	assert.Equal(t, 9, suite.Tests)
+	assert.Zero(t, suite.Failures)
+	assert.Equal(t, "smoketest.staging", suite.Cases[0].ClassName)
+}
+
+func TestSmokeTestCleansUpAfterAFailure(t *testing.T) {
+	dir := t.TempDir()
+	junitPath := filepath.Join(dir, "smoke.xml")
+	store := &fakeStore{failOrder: true}
+	out, err := runAgainst(t, store, "-junit", junitPath)
+	assert.ErrorIs(t, err, errFailed)
+	assert.Contains(t, out, "FAIL order succeeded")
+	assert.Contains(t, out, "order failed: INSUFFICIENT_STOCK")
+	assert.Contains(t, out, "SKIP stock deducted")
+	assert.Contains(t, out, "SKIP order saga completed")
+	assert.Contains(t, out, "PASS delete album")
+	assert.True(t, store.deleted, "the album is deleted after a failure")
+
+	data, err := os.ReadFile(junitPath)
+	require.NoError(t, err)
+	var suite junitSuite
+	require.NoError(t, xml.Unmarshal(data, &suite))
+	assert.Equal(t, 1, suite.Failures)
+	assert.Equal(t, 2, suite.Skipped)
+	require.NotNil(t, suite.Cases[4].Failure)
+	assert.Contains(t, suite.Cases[4].Failure.Message, "INSUFFICIENT_STOCK")
+}
+
+func TestSmokeTestUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	var out bytes.Buffer
+	err := run([]string{"-album-url", server.URL, "-wait", time.Second.String()}, &out)
+	assert.ErrorIs(t, err, errFailed)
+	assert.Contains(t, out.String(), "FAIL create album")
+	assert.Contains(t, out.String(), "SKIP delete album", "nothing to clean up")
+}
@@ -0,0 +1,318 @@
+// cold_storage.go - optional cold storage of dormant albums, so the albums table stops growing with
+// releases nobody buys any more. With COLD_STORAGE_AFTER_YEARS set, a job moves albums that weren't
+// changed or sold for that many years into albums_cold, together with the rows of the tables that
+// reference them (reviews, sales, views, price proposals, catalog changes and promotions), which
+// would otherwise be deleted with the album. Albums with stock left (inventory-service's table in the
+// same database), a pending proposal or change, or a promotion that hasn't ended stay.
+//
+// A cold album is gone from the catalog: reads return 404 and orders for it are rejected. Admins list
+// cold albums with GET /api/albums/cold-storage and bring one back, with the same ID and its related
+// rows, with POST /api/albums/cold-storage/:id/restore. Moves are recorded in the audit log as a
+// delete by "cold-storage" and restores as a create by the admin, and notify webhooks as such.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultColdStorageJobInterval = 24 * time.Hour
+	maxColdStorageMoves           = 200 // Per run; the rest are moved on the next one
+	coldStorageActor              = "cold-storage"
+)
+
+// coldStorageAfterYears is how long an album must be unchanged and unsold to move to cold storage;
+// 0 disables the job
+var coldStorageAfterYears int
+
+// coldStorageRelated lists the tables whose rows reference an album and are deleted with it
+var coldStorageRelated = []string{"album_reviews", "album_daily_views", "album_sales", "price_proposals", "pending_changes", "promotions"}
+
+// coldStorageEligible is the condition on album a to move to cold storage, $1 being the cutoff
+const coldStorageEligible = `
+	NOT EXISTS (SELECT 1 FROM album_sales s WHERE s.album_id = a.id AND s.sold_at >= $1)
+	AND NOT EXISTS (SELECT 1 FROM albums_history h WHERE h.album_id = a.id AND h.valid_from >= $1)
+	AND NOT EXISTS (SELECT 1 FROM album_audit au WHERE au.album_id = a.id AND au.changed_at >= $1)
+	AND NOT EXISTS (SELECT 1 FROM price_proposals p WHERE p.album_id = a.id AND p.status = 'pending')
+	AND NOT EXISTS (SELECT 1 FROM pending_changes pc WHERE pc.album_id = a.id AND pc.status = 'pending')
+	AND NOT EXISTS (SELECT 1 FROM promotions pr WHERE pr.album_id = a.id AND pr.ends_at > NOW())
+	AND NOT EXISTS (SELECT 1 FROM inventory i WHERE i.album_id = a.id::text AND i.quantity_available > 0)`
+
+// coldAlbumListSpec pages cold albums, most recently frozen first (see album-store/listing)
+var coldAlbumListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "frozenAt", Expr: "frozen_at"}, {Name: "title", Expr: "title"}, {Name: "artist", Expr: "artist"}},
+	Filterable:  []listing.Field{{Name: "artist", Expr: "artist"}},
+	DefaultSort: []listing.SortKey{{Expr: "frozen_at", Desc: true}},
+	Unique:      "album_id",
+}
+
+// ColdAlbum is an album in cold storage, as listed by GET /api/albums/cold-storage
+type ColdAlbum struct {
+	AlbumID  string    `json:"albumId" id:"public"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist"`
+	FrozenAt time.Time `json:"frozenAt"`
+}
+
+// loadColdStorageConfig reads COLD_STORAGE_AFTER_YEARS
+func loadColdStorageConfig() error {
+	coldStorageAfterYears = 0
+	if v := os.Getenv("COLD_STORAGE_AFTER_YEARS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("COLD_STORAGE_AFTER_YEARS %q is not a positive number of years", v)
+		}
+		coldStorageAfterYears = n
+	}
+	return nil
+}
+
+// startColdStorageJob moves dormant albums immediately and then on every COLD_STORAGE_JOB_INTERVAL tick
+func startColdStorageJob() {
+	if coldStorageAfterYears == 0 {
+		log.Println("COLD_STORAGE_AFTER_YEARS not set, albums stay in the albums table")
+		return
+	}
+	interval := defaultColdStorageJobInterval
+	if v := os.Getenv("COLD_STORAGE_JOB_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid COLD_STORAGE_JOB_INTERVAL %q, using default %s", v, defaultColdStorageJobInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Printf("Cold storage job scheduled every %s for albums dormant for %d years", interval, coldStorageAfterYears)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runColdStorageJob()
+			<-ticker.C
+		}
+	}()
+}
+
+// runColdStorageJob runs one traced pass of the cold storage job
+func runColdStorageJob() {
+	ctx, span := tracer.Start(context.Background(), "job.cold_storage")
+	defer span.End()
+
+	moved, err := freezeDormantAlbums(ctx, time.Now().AddDate(-coldStorageAfterYears, 0, 0))
+	if err != nil {
+		log.Printf("Cold storage job failed after moving %d albums: %v", moved, err)
+		span.RecordError(err)
+		return
+	}
+	log.Printf("Cold storage job moved %d albums", moved)
+}
+
+// freezeDormantAlbums moves up to maxColdStorageMoves albums that weren't changed or sold since cutoff
+// to cold storage, and returns how many it moved
+func freezeDormantAlbums(ctx context.Context, cutoff time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT a.id FROM albums a WHERE"+coldStorageEligible+" ORDER BY a.id LIMIT $2",
+		cutoff, maxColdStorageMoves)
+	if err != nil {
+		return 0, fmt.Errorf("query dormant albums: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan dormant albums: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read dormant albums: %w", err)
+	}
+
+	moved := 0
+	for _, id := range ids {
+		frozen, err := freezeAlbum(ctx, id, cutoff)
+		if err != nil {
+			return moved, fmt.Errorf("move album %d: %w", id, err)
+		}
+		if frozen {
+			moved++
+			coldStorageMoves.WithLabelValues("frozen").Inc()
+			invalidateAlbums(ctx, strconv.Itoa(id))
+		}
+	}
+	return moved, nil
+}
+
+// coldStorageSnapshot is the SQL building the related column for album a
+func coldStorageSnapshot() string {
+	parts := make([]string, len(coldStorageRelated))
+	for i, table := range coldStorageRelated {
+		parts[i] = fmt.Sprintf("'%[1]s', (SELECT COALESCE(jsonb_agg(to_jsonb(r)), '[]') FROM %[1]s r WHERE r.album_id = a.id)", table)
+	}
+	return "jsonb_build_object(" + strings.Join(parts, ", ") + ")"
+}
+
+// freezeAlbum moves one album to cold storage in a transaction. It checks the album again under a
+// lock, so false means it was changed, sold, deleted or taken by another instance since it was
+// selected.
+func freezeAlbum(ctx context.Context, id int, cutoff time.Time) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, "SELECT a.id FROM albums a WHERE a.id = $2 AND"+coldStorageEligible+" FOR UPDATE SKIP LOCKED",
+		cutoff, id).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := setAuditActor(ctx, tx, coldStorageActor); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO albums_cold (album_id, title, artist, album, related)
+		SELECT a.id, a.title, a.artist, to_jsonb(a), `+coldStorageSnapshot()+`
+		FROM albums a WHERE a.id = $1`, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM albums WHERE id = $1", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// getColdAlbums handles GET /api/albums/cold-storage
+func getColdAlbums(c *gin.Context) {
+	ctx := c.Request.Context()
+	page, err := listing.ParseParams(c, coldAlbumListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var args []interface{}
+	where := listing.WhereClause(page.Conditions(&args))
+	countArgs := args
+	rows, err := db.QueryContext(ctx, "SELECT album_id, title, artist, frozen_at FROM albums_cold"+
+		where+page.OrderByClause()+page.PageClause(&args), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query cold storage: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	albums := []ColdAlbum{}
+	for rows.Next() {
+		var a ColdAlbum
+		var albumID int
+		if err := rows.Scan(&albumID, &a.Title, &a.Artist, &a.FrozenAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cold storage: " + err.Error()})
+			return
+		}
+		a.AlbumID = strconv.Itoa(albumID)
+		albums = append(albums, a)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cold storage: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(albums), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM albums_cold"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count cold storage: " + err.Error()})
+		return
+	}
+	meta.SetHeaders(c)
+	respondJSON(c, http.StatusOK, albums)
+}
+
+// restoreColdAlbum handles POST /api/albums/cold-storage/:id/restore: the album returns to the
+// catalog with its ID, status and related rows. Columns added to a table since the album was frozen
+// get their NULL, so a new NOT NULL column needs a default in the saved rows first.
+func restoreColdAlbum(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not in cold storage"})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := setAuditActor(ctx, tx, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore album: " + err.Error()})
+		return
+	}
+	if err := setPriceChangeContext(ctx, tx, priceSourceRestore, "Restored from cold storage", c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore album: " + err.Error()})
+		return
+	}
+	var album, related []byte
+	err = tx.QueryRowContext(ctx, "DELETE FROM albums_cold WHERE album_id = $1 RETURNING album, related", id).Scan(&album, &related)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not in cold storage"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore album: " + err.Error()})
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO albums SELECT * FROM jsonb_populate_record(NULL::albums, $1::jsonb)", album); err != nil {
+		if isUPCConflict(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The album's upc is now used by another album"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore album: " + err.Error()})
+		return
+	}
+	for _, table := range coldStorageRelated {
+		query := fmt.Sprintf("INSERT INTO %[1]s SELECT * FROM jsonb_populate_recordset(NULL::%[1]s, $1::jsonb -> '%[1]s')", table)
+		if _, err := tx.ExecContext(ctx, query, related); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore " + table + ": " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit restore: " + err.Error()})
+		return
+	}
+	coldStorageMoves.WithLabelValues("restored").Inc()
+	invalidateAlbums(ctx, c.Param("id"))
+	log.Printf("Album %d restored from cold storage by %s", id, c.ClientIP())
+
+	a, err := findAlbum(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.Header("ETag", versionETag(a.Version))
+	respondJSON(c, http.StatusOK, a)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadColdStorageConfig(t *testing.T) {
+	t.Setenv("COLD_STORAGE_AFTER_YEARS", "")
+	require.NoError(t, loadColdStorageConfig())
+	assert.Equal(t, 0, coldStorageAfterYears, "disabled by default")
+
+	t.Setenv("COLD_STORAGE_AFTER_YEARS", "3")
+	require.NoError(t, loadColdStorageConfig())
+	assert.Equal(t, 3, coldStorageAfterYears)
+
+	for _, v := range []string{"0", "-1", "two"} {
+		t.Setenv("COLD_STORAGE_AFTER_YEARS", v)
+		assert.Error(t, loadColdStorageConfig(), v)
+	}
+	t.Cleanup(func() { coldStorageAfterYears = 0 })
+}
+
+func TestFreezeDormantAlbums(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	cutoff := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT a.id FROM albums a WHERE.*quantity_available > 0\\) ORDER BY a.id LIMIT \\$2").
+		WithArgs(cutoff, maxColdStorageMoves).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4).AddRow(7))
+
+	// Album 4 moves with its related rows
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT a.id FROM albums a WHERE a.id = \\$2 AND.*FOR UPDATE SKIP LOCKED").WithArgs(cutoff, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec("set_config\\('album_store.changed_by'").WithArgs(coldStorageActor).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO albums_cold .* to_jsonb\\(a\\), jsonb_build_object\\('album_reviews', .*'promotions', .*FROM albums a WHERE a.id = \\$1").
+		WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM albums WHERE id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Album 7 sold since it was selected
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WithArgs(cutoff, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	moved, err := freezeDormantAlbums(context.Background(), cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestColdStorageRoutes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	request := func(method, path, clientType string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Client-Type", clientType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("List", func(t *testing.T) {
+		frozen := time.Date(2026, 1, 5, 3, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT album_id, title, artist, frozen_at FROM albums_cold WHERE artist IN \\(\\$1\\) ORDER BY frozen_at DESC, album_id ASC").
+			WithArgs("Nirvana", listing.DefaultLimit, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "title", "artist", "frozen_at"}).AddRow(4, "Bleach", "Nirvana", frozen))
+
+		rr := request("GET", "/api/albums/cold-storage?artist=Nirvana", "admin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `[{"albumId":"4","title":"Bleach","artist":"Nirvana","frozenAt":"2026-01-05T03:00:00Z"}]`, rr.Body.String())
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+		album := []byte(`{"id":4,"title":"Bleach","artist":"Nirvana","price_cents":999}`)
+		related := []byte(`{"album_reviews":[],"album_sales":[{"order_id":"o-1","album_id":4,"units":1}]}`)
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("set_config\\('album_store.price_source'").WithArgs(priceSourceRestore, "Restored from cold storage", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("DELETE FROM albums_cold WHERE album_id = \\$1 RETURNING album, related").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"album", "related"}).AddRow(album, related))
+		mock.ExpectExec("INSERT INTO albums SELECT \\* FROM jsonb_populate_record\\(NULL::albums, \\$1::jsonb\\)").WithArgs(album).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		for _, table := range coldStorageRelated {
+			mock.ExpectExec("INSERT INTO " + table + " SELECT \\* FROM jsonb_populate_recordset\\(NULL::" + table + ", \\$1::jsonb -> '" + table + "'\\)").
+				WithArgs(related).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Bleach", "Nirvana", 999, 1989, "Rock", "LP", "", "", 1, 0, 0, "", "", nil, albumPublished, nil))
+
+		rr := request("POST", "/api/albums/cold-storage/4/restore", "admin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"title":"Bleach"`)
+		assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Restoring an album not in cold storage", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("set_config\\('album_store.price_source'").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("DELETE FROM albums_cold").WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"album", "related"}))
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusNotFound, request("POST", "/api/albums/cold-storage/9/restore", "admin").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("GET", "/api/albums/cold-storage", "").Code)
+		assert.Equal(t, http.StatusForbidden, request("POST", "/api/albums/cold-storage/4/restore", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
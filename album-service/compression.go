@@ -0,0 +1,131 @@
+// compression.go - gzip compression of responses for clients sending Accept-Encoding: gzip. Catalog
+// listings and exports are megabytes of JSON or CSV that compress to a fraction of that. Bodies under
+// gzipMinSize, bodies of other types and bodies a handler already encoded are sent as they are.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinSize is the smallest body worth compressing. A streamed body is judged by its first chunk.
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+
+// compressResponses gzips the responses of every route it is attached to. It must run before
+// middleware buffering the body, such as withCachePolicy, so that ETags describe the uncompressed body.
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &gzipResponseWriter{ResponseWriter: c.Writer, accepted: acceptsGzip(c.GetHeader("Accept-Encoding"))}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value accepts gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isCompressible reports whether bodies of a Content-Type are worth compressing
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml" ||
+		mediaType == "application/x-ndjson" || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// gzipResponseWriter decides on the first write whether to compress the body
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	accepted bool // The client accepts gzip
+	decided  bool
+	gz       *gzip.Writer
+}
+
+// start decides whether to compress the body starting with b
+func (w *gzipResponseWriter) start(b []byte) {
+	w.decided = true
+	header := w.Header()
+	if len(b) < gzipMinSize || header.Get("Content-Encoding") != "" || !isCompressible(header.Get("Content-Type")) {
+		return
+	}
+	// Caches must keep compressed and uncompressed copies apart
+	header.Add("Vary", "Accept-Encoding")
+	if !w.accepted {
+		return
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.start(b)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends the headers, so a body written afterwards can't be compressed any more
+func (w *gzipResponseWriter) WriteHeaderNow() {
+	w.decided = true
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.decided = true
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close finishes the compressed body
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
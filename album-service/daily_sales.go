@@ -0,0 +1,303 @@
+// daily_sales.go - end-of-day sales summaries. Once a day has ended in SALES_SUMMARY_TIMEZONE, its
+// sales (album_sales, recorded from order-succeeded events) are totalled per album into daily_sales
+// and a DailySalesSummary event is published to the daily-sales-summary topic. Dashboards and
+// accounting exports read the same summaries from GET /api/analytics/daily-sales?date=.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	dailySalesTopic       = "daily-sales-summary"
+	dailySalesJobInterval = time.Minute // How often ended days are looked for, which bounds how late a summary is
+	dailySalesDateLayout  = "2006-01-02"
+)
+
+var (
+	// salesSummaryLocation is the time zone whose midnight ends a day, from SALES_SUMMARY_TIMEZONE
+	salesSummaryLocation = time.UTC
+	dailySalesWriter     messageWriter
+
+	errNoDailySales = errors.New("day not summarized")
+)
+
+// DailySalesSummary is one day's sales, as published and as returned by GET /api/analytics/daily-sales
+type DailySalesSummary struct {
+	Date         string            `json:"date"`
+	TimeZone     string            `json:"timeZone"`
+	Units        int               `json:"units"`
+	Orders       int               `json:"orders"`
+	Revenue      map[string]Cents  `json:"revenue"` // Per currency
+	Albums       []DailyAlbumSales `json:"albums"`  // Highest revenue first
+	SummarizedAt time.Time         `json:"summarizedAt"`
+}
+
+// DailyAlbumSales is an album's sales on one day in one currency
+type DailyAlbumSales struct {
+	AlbumID  string `json:"albumId" id:"public"`
+	Units    int    `json:"units"`
+	Orders   int    `json:"orders"`
+	Revenue  Cents  `json:"revenue"`
+	Currency string `json:"currency,omitempty"` // Empty for sales recorded without a price, which have no revenue
+}
+
+// loadSalesSummaryTimezone reads SALES_SUMMARY_TIMEZONE, an IANA zone such as Europe/Berlin
+func loadSalesSummaryTimezone() error {
+	v := os.Getenv("SALES_SUMMARY_TIMEZONE")
+	if v == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		return fmt.Errorf("SALES_SUMMARY_TIMEZONE: %w", err)
+	}
+	salesSummaryLocation = loc
+	return nil
+}
+
+// salesDay returns the start of the day containing t in salesSummaryLocation
+func salesDay(t time.Time) time.Time {
+	t = t.In(salesSummaryLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, salesSummaryLocation)
+}
+
+// startDailySalesJob summarizes ended days and publishes their events immediately and then every
+// dailySalesJobInterval
+func startDailySalesJob() {
+	log.Printf("Daily sales summaries close at midnight %s", salesSummaryLocation)
+
+	go func() {
+		ticker := time.NewTicker(dailySalesJobInterval)
+		defer ticker.Stop()
+		for {
+			runDailySalesJob()
+			<-ticker.C
+		}
+	}()
+}
+
+// runDailySalesJob runs one traced pass of summarizeEndedDays and publishDailySales
+func runDailySalesJob() {
+	ctx, span := tracer.Start(context.Background(), "job.daily_sales")
+	defer span.End()
+
+	summarized, err := summarizeEndedDays(ctx, time.Now())
+	if err != nil {
+		log.Printf("Daily sales job failed after summarizing %d days: %v", summarized, err)
+		span.RecordError(err)
+		return
+	}
+	if summarized > 0 {
+		log.Printf("Daily sales job summarized %d days", summarized)
+	}
+	if _, err := publishDailySales(ctx); err != nil {
+		log.Printf("Daily sales job failed to publish summaries: %v", err)
+		span.RecordError(err)
+	}
+}
+
+// summarizeEndedDays summarizes every day that ended by now and hasn't been summarized: the days
+// after the last summarized one or, on the first run, since the first recorded sale. Returns the
+// number of days summarized.
+func summarizeEndedDays(ctx context.Context, now time.Time) (int, error) {
+	var last sql.NullTime
+	if err := db.QueryRowContext(ctx, "SELECT MAX(day) FROM daily_sales_days").Scan(&last); err != nil {
+		return 0, fmt.Errorf("find last summarized day: %w", err)
+	}
+	today := salesDay(now)
+	next := today.AddDate(0, 0, -1)
+	if last.Valid {
+		// DATE values come back as midnight UTC; take the calendar date, not the instant
+		next = time.Date(last.Time.Year(), last.Time.Month(), last.Time.Day()+1, 0, 0, 0, 0, salesSummaryLocation)
+	} else {
+		var first sql.NullTime
+		if err := db.QueryRowContext(ctx, "SELECT MIN(sold_at) FROM album_sales").Scan(&first); err != nil {
+			return 0, fmt.Errorf("find first sale: %w", err)
+		}
+		if first.Valid && first.Time.Before(next) {
+			next = salesDay(first.Time)
+		}
+	}
+
+	summarized := 0
+	for day := next; day.Before(today); day = day.AddDate(0, 0, 1) {
+		ok, err := summarizeDay(ctx, day)
+		if err != nil {
+			return summarized, fmt.Errorf("summarize %s: %w", day.Format(dailySalesDateLayout), err)
+		}
+		if ok {
+			summarized++
+		}
+	}
+	return summarized, nil
+}
+
+// summarizeDay totals the sales of the day starting at day into daily_sales. Claiming the day in
+// daily_sales_days first means one instance summarizes it; it reports false if another already had.
+func summarizeDay(ctx context.Context, day time.Time) (bool, error) {
+	date := day.Format(dailySalesDateLayout)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO daily_sales_days (day, time_zone) VALUES ($1, $2) ON CONFLICT (day) DO NOTHING`,
+		date, salesSummaryLocation.String())
+	if err != nil {
+		return false, err
+	}
+	if claimed, err := res.RowsAffected(); err != nil || claimed == 0 {
+		return false, err
+	}
+	// The end is the next midnight, not 24 hours later, so days with a DST change are summarized whole
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO daily_sales (day, album_id, currency, units, orders, revenue_cents)
+		SELECT $1::date, album_id, COALESCE(currency, ''), SUM(units), COUNT(*), COALESCE(SUM(revenue_cents), 0)
+		FROM album_sales WHERE sold_at >= $2 AND sold_at < $3
+		GROUP BY album_id, COALESCE(currency, '')`,
+		date, day.UTC(), day.AddDate(0, 0, 1).UTC())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// publishDailySales publishes the summaries whose event hasn't been published, oldest first. Each
+// is claimed by setting published_at before it's published and released again if publishing fails,
+// so a summary lost to a Kafka outage is published on a later run. Returns the number published.
+func publishDailySales(ctx context.Context) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT day FROM daily_sales_days WHERE published_at IS NULL ORDER BY day")
+	if err != nil {
+		return 0, err
+	}
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, day := range days {
+		date := day.Format(dailySalesDateLayout)
+		res, err := db.ExecContext(ctx, "UPDATE daily_sales_days SET published_at = NOW() WHERE day = $1 AND published_at IS NULL", date)
+		if err != nil {
+			return published, err
+		}
+		if claimed, _ := res.RowsAffected(); claimed == 0 {
+			continue
+		}
+		if err := publishDailySummary(ctx, date); err != nil {
+			if _, rerr := db.ExecContext(ctx, "UPDATE daily_sales_days SET published_at = NULL WHERE day = $1", date); rerr != nil {
+				log.Printf("Failed to release the daily sales summary of %s: %v", date, rerr)
+			}
+			return published, fmt.Errorf("publish %s: %w", date, err)
+		}
+		published++
+	}
+	return published, nil
+}
+
+// publishDailySummary publishes the summary of date to the daily-sales-summary topic
+func publishDailySummary(ctx context.Context, date string) error {
+	ctx, span := tracer.Start(ctx, "kafka.publish_daily_sales")
+	defer span.End()
+
+	summary, err := loadDailySales(ctx, date)
+	if err != nil {
+		return err
+	}
+	event, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if dailySalesWriter == nil {
+		return errors.New("no writer for " + dailySalesTopic)
+	}
+	if err := dailySalesWriter.WriteMessages(ctx, kafka.Message{Key: []byte(date), Value: event, Headers: InjectTraceInfoToKafkaMessage(ctx)}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Printf("Published daily sales summary of %s: %d units in %d orders", date, summary.Units, summary.Orders)
+	return nil
+}
+
+// loadDailySales reads the summary of date, errNoDailySales if the day hasn't been summarized
+func loadDailySales(ctx context.Context, date string) (DailySalesSummary, error) {
+	s := DailySalesSummary{Date: date, Revenue: map[string]Cents{}, Albums: []DailyAlbumSales{}}
+	err := db.QueryRowContext(ctx, "SELECT time_zone, summarized_at FROM daily_sales_days WHERE day = $1", date).
+		Scan(&s.TimeZone, &s.SummarizedAt)
+	if err == sql.ErrNoRows {
+		return s, errNoDailySales
+	}
+	if err != nil {
+		return s, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT album_id, currency, units, orders, revenue_cents FROM daily_sales
+		WHERE day = $1 ORDER BY revenue_cents DESC, units DESC, album_id`, date)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a DailyAlbumSales
+		var albumID int
+		if err := rows.Scan(&albumID, &a.Currency, &a.Units, &a.Orders, &a.Revenue); err != nil {
+			return s, err
+		}
+		a.AlbumID = strconv.Itoa(albumID)
+		s.Albums = append(s.Albums, a)
+		s.Units += a.Units
+		s.Orders += a.Orders
+		if a.Currency != "" {
+			s.Revenue[a.Currency] += a.Revenue
+		}
+	}
+	return s, rows.Err()
+}
+
+// getDailySales handles GET /api/analytics/daily-sales?date=YYYY-MM-DD, yesterday by default
+func getDailySales(c *gin.Context) {
+	date := c.Query("date")
+	if date == "" {
+		date = salesDay(time.Now()).AddDate(0, 0, -1).Format(dailySalesDateLayout)
+	} else if _, err := time.Parse(dailySalesDateLayout, date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", date)})
+		return
+	}
+
+	summary, err := loadDailySales(c.Request.Context(), date)
+	if errors.Is(err, errNoDailySales) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("No sales summary for %s; days are summarized after midnight %s", date, salesSummaryLocation)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading daily sales of %s: %v", date, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load daily sales"})
+		return
+	}
+	respondJSON(c, http.StatusOK, summary)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useSalesSummaryLocation sets the summary time zone until the test ends
+func useSalesSummaryLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+	original := salesSummaryLocation
+	salesSummaryLocation = loc
+	t.Cleanup(func() { salesSummaryLocation = original })
+	return loc
+}
+
+func TestSummarizeEndedDays(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+	berlin := useSalesSummaryLocation(t, "Europe/Berlin")
+
+	t.Run("Days after the last summarized one", func(t *testing.T) {
+		mock.ExpectQuery("SELECT MAX\\(day\\) FROM daily_sales_days").
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)))
+		// Another instance summarized the 30th
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO daily_sales_days").WithArgs("2024-03-30", "Europe/Berlin").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		// The 31st is 23 hours long: clocks go forward at 2:00
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO daily_sales_days").WithArgs("2024-03-31", "Europe/Berlin").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO daily_sales").
+			WithArgs("2024-03-31", time.Date(2024, 3, 30, 23, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 22, 0, 0, 0, time.UTC)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		summarized, err := summarizeEndedDays(context.Background(), time.Date(2024, 4, 1, 0, 30, 0, 0, berlin))
+		require.NoError(t, err)
+		assert.Equal(t, 1, summarized)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("The first run starts at the first sale", func(t *testing.T) {
+		mock.ExpectQuery("SELECT MAX\\(day\\) FROM daily_sales_days").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+		mock.ExpectQuery("SELECT MIN\\(sold_at\\) FROM album_sales").
+			WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC))) // May 2nd in Berlin
+		for _, day := range []string{"2024-05-02", "2024-05-03"} {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO daily_sales_days").WithArgs(day, "Europe/Berlin").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO daily_sales").WithArgs(day, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+		}
+
+		summarized, err := summarizeEndedDays(context.Background(), time.Date(2024, 5, 4, 9, 0, 0, 0, berlin))
+		require.NoError(t, err)
+		assert.Equal(t, 2, summarized)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing before midnight", func(t *testing.T) {
+		mock.ExpectQuery("SELECT MAX\\(day\\) FROM daily_sales_days").
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)))
+
+		summarized, err := summarizeEndedDays(context.Background(), time.Date(2024, 5, 4, 23, 59, 0, 0, berlin))
+		require.NoError(t, err)
+		assert.Zero(t, summarized)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+var dailySalesColumns = []string{"album_id", "currency", "units", "orders", "revenue_cents"}
+
+func expectDailySales(mock sqlmock.Sqlmock, date string, summarizedAt time.Time) {
+	mock.ExpectQuery("SELECT time_zone, summarized_at FROM daily_sales_days").WithArgs(date).
+		WillReturnRows(sqlmock.NewRows([]string{"time_zone", "summarized_at"}).AddRow("Europe/Berlin", summarizedAt))
+	mock.ExpectQuery("FROM daily_sales").WithArgs(date).
+		WillReturnRows(sqlmock.NewRows(dailySalesColumns).
+			AddRow(4, "USD", 3, 2, 5997).
+			AddRow(7, "USD", 1, 1, 1500).
+			AddRow(7, "", 2, 1, 0))
+}
+
+func TestPublishDailySales(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, dailySalesWriter
+	writer := &recordingWriter{}
+	db, dailySalesWriter = mockDB, writer
+	t.Cleanup(func() { db, dailySalesWriter = originalDB, originalWriter })
+
+	summarizedAt := time.Date(2024, 5, 2, 22, 0, 30, 0, time.UTC)
+	pending := func() {
+		mock.ExpectQuery("SELECT day FROM daily_sales_days WHERE published_at IS NULL").
+			WillReturnRows(sqlmock.NewRows([]string{"day"}).AddRow(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)))
+		mock.ExpectExec("UPDATE daily_sales_days SET published_at = NOW\\(\\)").WithArgs("2024-05-02").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectDailySales(mock, "2024-05-02", summarizedAt)
+	}
+
+	t.Run("A failed publish is released for the next run", func(t *testing.T) {
+		writer.err = errors.New("kafka unavailable")
+		t.Cleanup(func() { writer.err = nil })
+		pending()
+		mock.ExpectExec("UPDATE daily_sales_days SET published_at = NULL").WithArgs("2024-05-02").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		published, err := publishDailySales(context.Background())
+		assert.Error(t, err)
+		assert.Zero(t, published)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Summaries are published once", func(t *testing.T) {
+		pending()
+
+		published, err := publishDailySales(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, published)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		require.Len(t, writer.messages, 1)
+		assert.Equal(t, "2024-05-02", string(writer.messages[0].Key))
+		var event DailySalesSummary
+		require.NoError(t, json.Unmarshal(writer.messages[0].Value, &event))
+		assert.Equal(t, DailySalesSummary{
+			Date: "2024-05-02", TimeZone: "Europe/Berlin", Units: 6, Orders: 4,
+			Revenue: map[string]Cents{"USD": 7497},
+			Albums: []DailyAlbumSales{
+				{AlbumID: "4", Units: 3, Orders: 2, Revenue: 5997, Currency: "USD"},
+				{AlbumID: "7", Units: 1, Orders: 1, Revenue: 1500, Currency: "USD"},
+				{AlbumID: "7", Units: 2, Orders: 1},
+			},
+			SummarizedAt: summarizedAt,
+		}, event)
+	})
+}
+
+func TestGetDailySales(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+	useSalesSummaryLocation(t, "Europe/Berlin")
+
+	get := func(path string, admin bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if admin {
+			req.Header.Set("Client-Type", "admin")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("A summarized day", func(t *testing.T) {
+		expectDailySales(mock, "2024-05-02", time.Date(2024, 5, 2, 22, 0, 30, 0, time.UTC))
+		rr := get("/api/v1/analytics/daily-sales?date=2024-05-02", true)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"date":"2024-05-02","timeZone":"Europe/Berlin","units":6,"orders":4,"revenue":{"USD":74.97}`)
+		assert.Contains(t, rr.Body.String(), `{"albumId":"4","units":3,"orders":2,"revenue":59.97,"currency":"USD"}`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Yesterday by default", func(t *testing.T) {
+		yesterday := salesDay(time.Now()).AddDate(0, 0, -1).Format(dailySalesDateLayout)
+		mock.ExpectQuery("SELECT time_zone, summarized_at FROM daily_sales_days").WithArgs(yesterday).
+			WillReturnRows(sqlmock.NewRows([]string{"time_zone", "summarized_at"}))
+		rr := get("/api/v1/analytics/daily-sales", true)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Contains(t, rr.Body.String(), "No sales summary for "+yesterday)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid dates and customers", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/analytics/daily-sales?date=05/02/2024", true).Code)
+		assert.Equal(t, http.StatusForbidden, get("/api/v1/analytics/daily-sales?date=2024-05-02", false).Code)
+	})
+}
+
+func TestLoadSalesSummaryTimezone(t *testing.T) {
+	original := salesSummaryLocation
+	t.Cleanup(func() { salesSummaryLocation = original })
+
+	t.Setenv("SALES_SUMMARY_TIMEZONE", "America/New_York")
+	require.NoError(t, loadSalesSummaryTimezone())
+	assert.Equal(t, "America/New_York", salesSummaryLocation.String())
+
+	t.Setenv("SALES_SUMMARY_TIMEZONE", "Mars/Olympus_Mons")
+	assert.Error(t, loadSalesSummaryTimezone())
+}
@@ -0,0 +1,133 @@
+// db_pool.go - the database connection pool. Connections come from a pgxpool.Pool sized by
+// DB_MAX_CONNS (default 25), DB_MIN_CONNS (default 2) and DB_MAX_CONN_LIFETIME (default 30m), which
+// override pool_* parameters of DB_CONNECTION. Handlers use the pool through database/sql
+// (stdlib.OpenDBFromPool), so queries and transactions are written as before. The pool's stats are
+// exported as album_db_pool_* metrics, to size it from load tests rather than guesses.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultDBMaxConns        = 25
+	defaultDBMinConns        = 2
+	defaultDBMaxConnLifetime = 30 * time.Minute
+)
+
+// dbPoolConfig is the pool sizing read from the environment
+type dbPoolConfig struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+}
+
+func (c dbPoolConfig) String() string {
+	return fmt.Sprintf("%d-%d connections, recycled after %s", c.MinConns, c.MaxConns, c.MaxConnLifetime)
+}
+
+// loadDBPoolConfig reads DB_MAX_CONNS, DB_MIN_CONNS and DB_MAX_CONN_LIFETIME
+func loadDBPoolConfig() (dbPoolConfig, error) {
+	cfg := dbPoolConfig{MaxConns: defaultDBMaxConns, MinConns: defaultDBMinConns, MaxConnLifetime: defaultDBMaxConnLifetime}
+	if v := os.Getenv("DB_MAX_CONNS"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("DB_MAX_CONNS %q is not a positive integer", v)
+		}
+		cfg.MaxConns = int32(n)
+	}
+	if v := os.Getenv("DB_MIN_CONNS"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("DB_MIN_CONNS %q is not a non-negative integer", v)
+		}
+		cfg.MinConns = int32(n)
+	} else if cfg.MinConns > cfg.MaxConns {
+		cfg.MinConns = cfg.MaxConns
+	}
+	if cfg.MinConns > cfg.MaxConns {
+		return cfg, fmt.Errorf("DB_MIN_CONNS %d exceeds DB_MAX_CONNS %d", cfg.MinConns, cfg.MaxConns)
+	}
+	if v := os.Getenv("DB_MAX_CONN_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("DB_MAX_CONN_LIFETIME %q is not a positive duration", v)
+		}
+		cfg.MaxConnLifetime = d
+	}
+	return cfg, nil
+}
+
+// openDBPool creates the pool for connStr. Connections are opened in the background; ping the
+// returned database to wait for the first one. With a breaker, the database takes its connections
+// through it (see circuit_breaker.go).
+func openDBPool(ctx context.Context, connStr string, cfg dbPoolConfig, breaker *circuitBreaker) (*pgxpool.Pool, *sql.DB, error) {
+	poolConfig, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	poolConfig.MaxConns, poolConfig.MinConns, poolConfig.MaxConnLifetime = cfg.MaxConns, cfg.MinConns, cfg.MaxConnLifetime
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if breaker == nil {
+		return pool, stdlib.OpenDBFromPool(pool), nil
+	}
+	// As OpenDBFromPool does: idle connections are kept by the pool, not database/sql
+	database := sql.OpenDB(breakerConnector{Connector: stdlib.GetPoolConnector(pool), breaker: breaker})
+	database.SetMaxIdleConns(0)
+	return pool, database, nil
+}
+
+// dbPoolCollector exports the stats of a pool on every scrape
+type dbPoolCollector struct {
+	pool *pgxpool.Pool
+
+	conns, maxConns, acquires, emptyAcquires, canceledAcquires, acquireWait, newConns, destroyedConns *prometheus.Desc
+}
+
+func newDBPoolCollector(pool *pgxpool.Pool) *dbPoolCollector {
+	return &dbPoolCollector{
+		pool:             pool,
+		conns:            prometheus.NewDesc("album_db_pool_connections", "Open database connections by state: idle, acquired or constructing.", []string{"state"}, nil),
+		maxConns:         prometheus.NewDesc("album_db_pool_max_connections", "The most connections the pool opens (DB_MAX_CONNS).", nil, nil),
+		acquires:         prometheus.NewDesc("album_db_pool_acquires_total", "Connections acquired from the pool.", nil, nil),
+		emptyAcquires:    prometheus.NewDesc("album_db_pool_empty_acquires_total", "Acquires that waited because no connection was idle.", nil, nil),
+		canceledAcquires: prometheus.NewDesc("album_db_pool_canceled_acquires_total", "Acquires canceled by their context before getting a connection.", nil, nil),
+		acquireWait:      prometheus.NewDesc("album_db_pool_acquire_seconds_total", "Time spent acquiring connections.", nil, nil),
+		newConns:         prometheus.NewDesc("album_db_pool_new_connections_total", "Connections opened by the pool.", nil, nil),
+		destroyedConns:   prometheus.NewDesc("album_db_pool_closed_connections_total", "Connections the pool closed by reason: max_lifetime or max_idle.", []string{"reason"}, nil),
+	}
+}
+
+func (c *dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.conns, c.maxConns, c.acquires, c.emptyAcquires, c.canceledAcquires, c.acquireWait, c.newConns, c.destroyedConns} {
+		ch <- d
+	}
+}
+
+func (c *dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquires, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.newConns, prometheus.CounterValue, float64(s.NewConnsCount()))
+	ch <- prometheus.MustNewConstMetric(c.destroyedConns, prometheus.CounterValue, float64(s.MaxLifetimeDestroyCount()), "max_lifetime")
+	ch <- prometheus.MustNewConstMetric(c.destroyedConns, prometheus.CounterValue, float64(s.MaxIdleDestroyCount()), "max_idle")
+}
@@ -0,0 +1,129 @@
+// decades.go - browsing by era. Album responses can carry fields computed from the release year
+// (?computed=true), and GET /api/albums/by-decade groups the catalog by release decade, so clients
+// don't have to derive either themselves.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAlbumsPerDecade = 10
+	maxAlbumsPerDecade     = 50
+	// newReleaseYears is how many calendar years, counting the current one, an album is a new release
+	newReleaseYears = 1
+)
+
+// DecadeGroup is one decade of GET /api/albums/by-decade
+type DecadeGroup struct {
+	Decade int     `json:"decade"` // First year of the decade, e.g. 1990
+	Count  int     `json:"count"`  // Matching albums released in the decade
+	Albums []Album `json:"albums"` // The most popular of them, at most perDecade
+}
+
+// DecadesResponse is returned by GET /api/albums/by-decade
+type DecadesResponse struct {
+	Total   int           `json:"total"`
+	Decades []DecadeGroup `json:"decades"`
+}
+
+// wantsComputedFields reports whether the request asks for computed album fields
+func wantsComputedFields(c *gin.Context) bool {
+	v, _ := strconv.ParseBool(c.Query("computed"))
+	return v
+}
+
+// setComputedFields sets the fields of a derived from its release year as of now
+func setComputedFields(a *Album, now time.Time) {
+	age := now.Year() - a.ReleaseYear
+	if age < 0 {
+		age = 0 // Announced releases
+	}
+	a.Decade = a.ReleaseYear / 10 * 10
+	a.Age = &age
+	a.IsNewRelease = age < newReleaseYears
+}
+
+// withComputedFields sets the computed fields of albums when the request asks for them
+func withComputedFields(c *gin.Context, albums ...*Album) {
+	if !wantsComputedFields(c) {
+		return
+	}
+	now := time.Now()
+	for _, a := range albums {
+		setComputedFields(a, now)
+	}
+}
+
+// getAlbumsByDecade handles GET /api/albums/by-decade: the albums matching the catalog filters (see
+// facets.go) grouped by decade, oldest decade first. Each group has the decade's album count and its
+// perDecade (default 10, at most 50) most popular albums, with computed fields.
+func getAlbumsByDecade(c *gin.Context) {
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	perDecade := defaultAlbumsPerDecade
+	if v := c.Query("perDecade"); v != "" {
+		perDecade, err = strconv.Atoi(v)
+		if err != nil || perDecade < 1 || perDecade > maxAlbumsPerDecade {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("perDecade must be between 1 and %d", maxAlbumsPerDecade)})
+			return
+		}
+	}
+
+	from := " FROM albums a"
+	if filter.needsInventory() {
+		from += " " + availabilityJoin
+	}
+	var args []interface{}
+	from += filter.whereClause(&args)
+	args = append(args, perDecade)
+	query := fmt.Sprintf(`SELECT id, title, artist, price_cents, release_year, genre, format, upc, catalog_number, version, average_rating, review_count, decade, decade_count
+		FROM (SELECT a.id, a.title, a.artist, a.price_cents, a.release_year, a.genre, COALESCE(a.format, '') AS format,
+				COALESCE(a.upc, '') AS upc, COALESCE(a.catalog_number, '') AS catalog_number, a.version, a.average_rating, a.review_count,
+				%[1]s AS decade,
+				COUNT(*) OVER (PARTITION BY %[1]s) AS decade_count,
+				ROW_NUMBER() OVER (PARTITION BY %[1]s ORDER BY a.popularity_score DESC, a.id) AS decade_rank
+			%[2]s) ranked
+		WHERE decade_rank <= $%[3]d ORDER BY decade, decade_rank`, decadeExpr, from, len(args))
+
+	rows, err := db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query albums: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	resp := DecadesResponse{Decades: []DecadeGroup{}}
+	now := time.Now()
+	for rows.Next() {
+		var a Album
+		var id, decade, count int
+		if err := rows.Scan(&id, &a.Title, &a.Artist, &a.Price, &a.ReleaseYear, &a.Genre, &a.Format, &a.UPC, &a.CatalogNumber,
+			&a.Version, &a.AverageRating, &a.ReviewCount, &decade, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read albums: " + err.Error()})
+			return
+		}
+		a.ID = strconv.Itoa(id)
+		setComputedFields(&a, now)
+		if n := len(resp.Decades); n == 0 || resp.Decades[n-1].Decade != decade {
+			resp.Decades = append(resp.Decades, DecadeGroup{Decade: decade, Count: count})
+			resp.Total += count
+		}
+		group := &resp.Decades[len(resp.Decades)-1]
+		group.Albums = append(group.Albums, a)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read albums: " + err.Error()})
+		return
+	}
+	respondJSON(c, http.StatusOK, resp)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetComputedFields(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		year, decade, age int
+		newRelease        bool
+	}{
+		{1991, 1990, 35, false},
+		{2020, 2020, 6, false},
+		{2025, 2020, 1, false},
+		{2026, 2020, 0, true},
+		{2027, 2020, 0, true},
+	} {
+		a := Album{ReleaseYear: tc.year}
+		setComputedFields(&a, now)
+		assert.Equal(t, tc.decade, a.Decade, tc.year)
+		require.NotNil(t, a.Age)
+		assert.Equal(t, tc.age, *a.Age, tc.year)
+		assert.Equal(t, tc.newRelease, a.IsNewRelease, tc.year)
+	}
+}
+
+func TestGetAlbumsByDecade(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	columns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "decade", "decade_count"}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Groups by decade", func(t *testing.T) {
+		mock.ExpectQuery(`PARTITION BY \(a.release_year / 10\) \* 10.*WHERE a.status IN \('published'\) AND a.genre IN \(\$1\).*decade_rank <= \$2`).
+			WithArgs("Rock", 2).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 1, 4.5, 2, 1990, 3).
+				AddRow(5, "Ten", "Pearl Jam", 1499, 1991, "Rock", "CD", "", "", 1, 0.0, 0, 1990, 3).
+				AddRow(6, "Elephant", "The White Stripes", 1799, 2003, "Rock", "", "", "", 1, 0.0, 0, 2000, 1))
+
+		rr := get("/api/albums/by-decade?genre=Rock&perDecade=2")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		body := rr.Body.String()
+		assert.Contains(t, body, `"total":4`)
+		assert.Contains(t, body, `{"decade":1990,"count":3,"albums":[{"id":"4","title":"Nevermind"`)
+		assert.Contains(t, body, `{"decade":2000,"count":1,"albums":[{"id":"6"`)
+		assert.Contains(t, body, `"decade":1990,"age":`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No albums", func(t *testing.T) {
+		mock.ExpectQuery("PARTITION BY").WithArgs(defaultAlbumsPerDecade).WillReturnRows(sqlmock.NewRows(columns))
+
+		rr := get("/api/albums/by-decade")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"total":0,"decades":[]}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Bad parameters", func(t *testing.T) {
+		for _, path := range []string{"/api/albums/by-decade?perDecade=0", "/api/albums/by-decade?perDecade=51", "/api/albums/by-decade?decade=1995"} {
+			assert.Equal(t, http.StatusBadRequest, get(path).Code, path)
+		}
+	})
+}
+
+func TestGetAlbum_ComputedFields(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+			AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 0.0, 0, "", "", nil, "published", nil))
+
+	req, _ := http.NewRequest("GET", "/api/albums/4?computed=true", nil)
+	req.Header.Set("If-None-Match", `"2"`)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "the version doesn't revalidate computed fields")
+	assert.Contains(t, rr.Body.String(), `"decade":1990`)
+	assert.Contains(t, rr.Body.String(), `"age":`)
+	assert.NotEqual(t, `"2"`, rr.Header().Get("ETag"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
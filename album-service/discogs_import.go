@@ -0,0 +1,507 @@
+// discogs_import.go - two-step catalog import from Discogs collection exports (preview, then confirm)
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxDiscogsImportBytes = 5 << 20
+	maxDiscogsImportRows  = 2000
+	discogsImportTTL      = time.Hour // Previews not confirmed within this window have to be re-uploaded
+	defaultImportGenre    = "Unknown" // Discogs CSV exports carry no genre
+)
+
+// Statuses of a previewed import row; only "new" rows are inserted on confirm
+const (
+	importStatusNew       = "new"
+	importStatusDuplicate = "duplicate"
+	importStatusInvalid   = "invalid"
+)
+
+// discogsArtistSuffix matches the numeric suffix Discogs adds to disambiguate artist names, e.g. "Nirvana (2)"
+var discogsArtistSuffix = regexp.MustCompile(`\s+\(\d+\)$`)
+
+// discogsRelease is one release read from an export, before validation
+type discogsRelease struct {
+	Title    string
+	Artist   string
+	Released string // Year or date as exported, e.g. "1991" or "1991-09-24"
+	Genre    string
+	Format   string
+}
+
+// ImportItem is one row of an import preview
+type ImportItem struct {
+	Row             int    `json:"row"` // 1-based position in the export, excluding the CSV header
+	Title           string `json:"title"`
+	Artist          string `json:"artist"`
+	ReleaseYear     int    `json:"releaseYear"`
+	Genre           string `json:"genre"`
+	Format          string `json:"format,omitempty"`
+	Price           Cents  `json:"price"`
+	Status          string `json:"status"`
+	Reason          string `json:"reason,omitempty"`
+	ExistingAlbumID string `json:"existingAlbumId,omitempty" id:"public"`
+}
+
+// ImportSummary counts preview rows by status
+type ImportSummary struct {
+	New       int `json:"new"`
+	Duplicate int `json:"duplicate"`
+	Invalid   int `json:"invalid"`
+}
+
+// ImportPreview is returned by the preview step; confirm it with its ImportID before ExpiresAt
+type ImportPreview struct {
+	ImportID  string        `json:"importId"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Summary   ImportSummary `json:"summary"`
+	Items     []ImportItem  `json:"items"`
+}
+
+// ImportResult is returned by the confirm step
+type ImportResult struct {
+	ImportID        string  `json:"importId"`
+	Created         []Album `json:"created"`
+	Skipped         int     `json:"skipped"`         // New in the preview but matching a catalog entry added since
+	PublishFailures int     `json:"publishFailures"` // Albums created whose album-created event could not be published
+}
+
+// parseDiscogsCSV reads a Discogs collection CSV export. Columns are matched by header name, so
+// exports with extra or reordered columns are accepted; Artist and Title are required.
+func parseDiscogsCSV(r io.Reader) ([]discogsRelease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"artist", "title"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %q column", required)
+		}
+	}
+	field := func(record []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	var releases []discogsRelease
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read CSV: %w", err)
+		}
+		if len(releases) == maxDiscogsImportRows {
+			return nil, fmt.Errorf("too many rows, at most %d per import", maxDiscogsImportRows)
+		}
+		releases = append(releases, discogsRelease{
+			Title:    field(record, "title"),
+			Artist:   field(record, "artist"),
+			Released: field(record, "released", "year"),
+			Genre:    field(record, "genre"),
+			Format:   field(record, "format"),
+		})
+	}
+	return releases, nil
+}
+
+// discogsJSONRelease is a release as returned by the Discogs collection API, which nests the
+// fields under basic_information
+type discogsJSONRelease struct {
+	BasicInformation struct {
+		Title   string `json:"title"`
+		Year    int    `json:"year"`
+		Artists []struct {
+			Name string `json:"name"`
+		} `json:"artists"`
+		Genres  []string `json:"genres"`
+		Formats []struct {
+			Name string `json:"name"`
+		} `json:"formats"`
+	} `json:"basic_information"`
+}
+
+// parseDiscogsJSON reads a Discogs collection JSON export: either the API response
+// ({"releases": [...]}) or a bare array of releases
+func parseDiscogsJSON(data []byte) ([]discogsRelease, error) {
+	var raw []discogsJSONRelease
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var page struct {
+			Releases []discogsJSONRelease `json:"releases"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("parse JSON: %w", err)
+		}
+		raw = page.Releases
+	}
+	if len(raw) > maxDiscogsImportRows {
+		return nil, fmt.Errorf("too many rows, at most %d per import", maxDiscogsImportRows)
+	}
+
+	releases := make([]discogsRelease, 0, len(raw))
+	for _, r := range raw {
+		info := r.BasicInformation
+		release := discogsRelease{Title: info.Title}
+		var artists []string
+		for _, a := range info.Artists {
+			artists = append(artists, discogsArtistSuffix.ReplaceAllString(strings.TrimSpace(a.Name), ""))
+		}
+		release.Artist = strings.Join(artists, ", ")
+		if info.Year > 0 {
+			release.Released = strconv.Itoa(info.Year)
+		}
+		if len(info.Genres) > 0 {
+			release.Genre = info.Genres[0]
+		}
+		if len(info.Formats) > 0 {
+			release.Format = info.Formats[0].Name
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// discogsFormat reduces a Discogs format description like "2xLP, Album, RE" to its medium ("LP")
+func discogsFormat(s string) string {
+	medium := strings.TrimSpace(strings.Split(s, ",")[0])
+	if i := strings.Index(medium, "x"); i > 0 {
+		if _, err := strconv.Atoi(medium[:i]); err == nil {
+			medium = medium[i+1:]
+		}
+	}
+	return medium
+}
+
+// releaseYear extracts the year from a Discogs release date ("1991", "1991-09-24", "1991-00-00")
+func releaseYear(released string) int {
+	if len(released) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(released[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// albumKey identifies an album for duplicate detection: title and artist, case-insensitively
+func albumKey(title, artist string) string {
+	return strings.ToLower(strings.TrimSpace(title)) + "\x00" + strings.ToLower(strings.TrimSpace(artist))
+}
+
+// buildImportItems validates and maps releases to catalog rows. Duplicates against the catalog
+// (existing maps albumKey to album ID) and within the export itself are flagged, not dropped, so
+// the preview accounts for every exported row.
+func buildImportItems(releases []discogsRelease, price Cents, defaultGenre string, existing map[string]string) ([]ImportItem, ImportSummary) {
+	var summary ImportSummary
+	seen := make(map[string]int)
+	items := make([]ImportItem, 0, len(releases))
+	for i, r := range releases {
+		item := ImportItem{
+			Row:         i + 1,
+			Title:       strings.TrimSpace(r.Title),
+			Artist:      discogsArtistSuffix.ReplaceAllString(strings.TrimSpace(r.Artist), ""),
+			ReleaseYear: releaseYear(r.Released),
+			Genre:       strings.TrimSpace(r.Genre),
+			Format:      discogsFormat(r.Format),
+			Price:       price,
+			Status:      importStatusNew,
+		}
+		if item.Genre == "" {
+			item.Genre = defaultGenre
+		}
+		genre, knownGenre := genres.canonical(item.Genre)
+		if knownGenre {
+			item.Genre = genre
+		}
+
+		key := albumKey(item.Title, item.Artist)
+		floor, belowFloor := priceFloors.check(item.Price, item.Genre)
+		switch {
+		case item.Title == "" || item.Artist == "":
+			item.Status, item.Reason = importStatusInvalid, "missing title or artist"
+		case len(item.Title) > 100 || len(item.Artist) > 100:
+			item.Status, item.Reason = importStatusInvalid, "title or artist longer than 100 characters"
+		case item.ReleaseYear == 0:
+			item.Status, item.Reason = importStatusInvalid, "missing release year"
+		case len(item.Genre) > 50 || len(item.Format) > 50:
+			item.Status, item.Reason = importStatusInvalid, "genre or format longer than 50 characters"
+		case !knownGenre:
+			item.Status, item.Reason = importStatusInvalid, "unknown genre"
+		case belowFloor:
+			// Imports can't carry an override reason; such albums must be created individually
+			item.Status, item.Reason = importStatusInvalid, fmt.Sprintf("price below the %s floor for %s", floor, item.Genre)
+		case existing[key] != "":
+			item.Status, item.Reason, item.ExistingAlbumID = importStatusDuplicate, "already in catalog", existing[key]
+		case seen[key] > 0:
+			item.Status, item.Reason = importStatusDuplicate, fmt.Sprintf("same as row %d", seen[key])
+		default:
+			seen[key] = item.Row
+		}
+
+		switch item.Status {
+		case importStatusNew:
+			summary.New++
+		case importStatusDuplicate:
+			summary.Duplicate++
+		default:
+			summary.Invalid++
+		}
+		items = append(items, item)
+	}
+	return items, summary
+}
+
+// findExistingAlbums returns the IDs of catalog albums matching any release, keyed by albumKey
+func findExistingAlbums(ctx context.Context, releases []discogsRelease) (map[string]string, error) {
+	existing := make(map[string]string)
+	if len(releases) == 0 {
+		return existing, nil
+	}
+
+	var pairs []string
+	var args []interface{}
+	for _, r := range releases {
+		args = append(args, strings.ToLower(strings.TrimSpace(r.Title)),
+			strings.ToLower(discogsArtistSuffix.ReplaceAllString(strings.TrimSpace(r.Artist), "")))
+		pairs = append(pairs, fmt.Sprintf("($%d, $%d)", len(args)-1, len(args)))
+	}
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, title, artist FROM albums WHERE (lower(title), lower(artist)) IN ("+strings.Join(pairs, ", ")+")",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var title, artist string
+		if err := rows.Scan(&id, &title, &artist); err != nil {
+			return nil, err
+		}
+		existing[albumKey(title, artist)] = strconv.Itoa(id)
+	}
+	return existing, rows.Err()
+}
+
+// newImportID returns a random, unguessable import ID
+func newImportID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// previewDiscogsImport handles POST /api/albums/import/discogs. The body is the export itself
+// (Content-Type application/json for JSON, anything else is read as CSV); ?price= sets the price of
+// every imported album since exports carry none, and ?genre= the genre for releases without one.
+// Nothing is added to the catalog until the preview is confirmed.
+func previewDiscogsImport(c *gin.Context) {
+	price, err := parseCents(c.Query("price"))
+	if err != nil || price <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter price is required and must be an amount greater than 0, e.g. 19.99"})
+		return
+	}
+	defaultGenre := strings.TrimSpace(c.DefaultQuery("genre", defaultImportGenre))
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxDiscogsImportBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Export too large, at most %d bytes", maxDiscogsImportBytes)})
+		return
+	}
+
+	var releases []discogsRelease
+	if c.ContentType() == "application/json" {
+		releases, err = parseDiscogsJSON(body)
+	} else {
+		releases, err = parseDiscogsCSV(strings.NewReader(string(body)))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Discogs export: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := findExistingAlbums(ctx, releases)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check catalog for duplicates: " + err.Error()})
+		return
+	}
+	items, summary := buildImportItems(releases, price, defaultGenre, existing)
+
+	importID, err := newImportID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import: " + err.Error()})
+		return
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import: " + err.Error()})
+		return
+	}
+	var createdAt time.Time
+	err = db.QueryRowContext(ctx,
+		"INSERT INTO album_imports (id, source, items) VALUES ($1, 'discogs', $2) RETURNING created_at",
+		importID, itemsJSON).Scan(&createdAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store import preview: " + err.Error()})
+		return
+	}
+
+	respondJSON(c, http.StatusCreated, ImportPreview{
+		ImportID:  importID,
+		ExpiresAt: createdAt.Add(discogsImportTTL),
+		Summary:   summary,
+		Items:     items,
+	})
+}
+
+var (
+	errImportNotFound  = errors.New("import not found")
+	errImportConfirmed = errors.New("import already confirmed")
+	errImportExpired   = errors.New("import preview expired")
+)
+
+// confirmDiscogsImport handles POST /api/albums/import/discogs/:importId/confirm. The preview's new
+// rows are inserted in one transaction; rows matching a catalog entry added since the preview are
+// skipped. album-created is published for every inserted album once the transaction commits.
+func confirmDiscogsImport(c *gin.Context) {
+	ctx := c.Request.Context()
+	importID := c.Param("importId")
+
+	created, skipped, err := insertImportedAlbums(ctx, importID, c.ClientIP())
+	switch {
+	case errors.Is(err, errImportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	case errors.Is(err, errImportConfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Import already confirmed"})
+		return
+	case errors.Is(err, errImportExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Import preview expired, upload the export again"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import albums: " + err.Error()})
+		return
+	}
+
+	result := ImportResult{ImportID: importID, Created: created, Skipped: skipped}
+	for _, err := range publishAlbumsCreated(ctx, created) {
+		if err != nil {
+			result.PublishFailures++
+		}
+	}
+	log.Printf("Discogs import %s confirmed: %d created, %d skipped, %d publish failures",
+		importID, len(created), skipped, result.PublishFailures)
+	respondJSON(c, http.StatusOK, result)
+}
+
+// insertImportedAlbums inserts the new rows of a stored preview and marks it confirmed
+func insertImportedAlbums(ctx context.Context, importID, clientIP string) ([]Album, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var itemsJSON []byte
+	var createdAt time.Time
+	var confirmedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT items, created_at, confirmed_at FROM album_imports WHERE id = $1 FOR UPDATE", importID).
+		Scan(&itemsJSON, &createdAt, &confirmedAt)
+	if err == sql.ErrNoRows {
+		return nil, 0, errImportNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if confirmedAt.Valid {
+		return nil, 0, errImportConfirmed
+	}
+	if time.Since(createdAt) > discogsImportTTL {
+		return nil, 0, errImportExpired
+	}
+
+	var items []ImportItem
+	if err := json.Unmarshal(itemsJSON, &items); err != nil {
+		return nil, 0, fmt.Errorf("decode stored preview: %w", err)
+	}
+
+	if err := setAuditActor(ctx, tx, clientIP); err != nil {
+		return nil, 0, err
+	}
+	created := []Album{}
+	skipped := 0
+	for _, item := range items {
+		if item.Status != importStatusNew {
+			continue
+		}
+		var id int
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO albums (title, artist, price_cents, release_year, genre, format, status)
+			SELECT $1::varchar, $2::varchar, $3::numeric, $4::int, $5::varchar, NULLIF($6::varchar, ''), '`+albumDraft+`'
+			WHERE NOT EXISTS (SELECT 1 FROM albums WHERE lower(title) = lower($1) AND lower(artist) = lower($2))
+			RETURNING id`,
+			item.Title, item.Artist, item.Price, item.ReleaseYear, item.Genre, item.Format).Scan(&id)
+		if err == sql.ErrNoRows {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("insert row %d: %w", item.Row, err)
+		}
+		created = append(created, Album{
+			ID:          strconv.Itoa(id),
+			Title:       item.Title,
+			Artist:      item.Artist,
+			Price:       item.Price,
+			ReleaseYear: item.ReleaseYear,
+			Genre:       item.Genre,
+			Format:      item.Format,
+			Version:     initialAlbumVersion,
+			Status:      albumDraft,
+		})
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE album_imports SET confirmed_at = NOW() WHERE id = $1", importID); err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return created, skipped, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const discogsCSVExport = "Catalog#,Artist,Title,Label,Format,Rating,Released,release_id\n" +
+	"DGC-24425,Nirvana (2),Nevermind,DGC,\"LP, Album\",5,1991-09-24,367084\n" +
+	"MOVLP123,Miles Davis,Kind Of Blue,Columbia,\"2xLP, Album, RE\",,1959,1234\n" +
+	"X1,,Untitled,Self,CD,,2001,99\n"
+
+func TestParseDiscogsCSV(t *testing.T) {
+	releases, err := parseDiscogsCSV(strings.NewReader(discogsCSVExport))
+	require.NoError(t, err)
+	require.Len(t, releases, 3)
+	assert.Equal(t, discogsRelease{Title: "Nevermind", Artist: "Nirvana (2)", Released: "1991-09-24", Format: "LP, Album"}, releases[0])
+
+	_, err = parseDiscogsCSV(strings.NewReader("Catalog#,Title\nX1,Nevermind\n"))
+	assert.ErrorContains(t, err, `missing the "artist" column`)
+}
+
+func TestParseDiscogsJSON(t *testing.T) {
+	release := `{"basic_information":{"title":"Nevermind","year":1991,"artists":[{"name":"Nirvana (2)"}],"genres":["Rock"],"formats":[{"name":"Vinyl"}]}}`
+	want := discogsRelease{Title: "Nevermind", Artist: "Nirvana", Released: "1991", Genre: "Rock", Format: "Vinyl"}
+
+	for name, body := range map[string]string{
+		"API page":   `{"pagination":{},"releases":[` + release + `]}`,
+		"Bare array": `[` + release + `]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			releases, err := parseDiscogsJSON([]byte(body))
+			require.NoError(t, err)
+			assert.Equal(t, []discogsRelease{want}, releases)
+		})
+	}
+}
+
+func TestBuildImportItems(t *testing.T) {
+	releases, err := parseDiscogsCSV(strings.NewReader(discogsCSVExport +
+		"Y2,Nirvana,nevermind,DGC,CD,,1991,1\n" + // Same album as row 1 in another pressing
+		"Z3,Nick Drake,Pink Moon,Island,LP,,,2\n"))
+	require.NoError(t, err)
+
+	existing := map[string]string{albumKey("Kind of Blue", "Miles Davis"): "7"}
+	items, summary := buildImportItems(releases, 2499, "Unknown", existing)
+
+	require.Len(t, items, 5)
+	assert.Equal(t, ImportSummary{New: 1, Duplicate: 2, Invalid: 2}, summary)
+
+	assert.Equal(t, ImportItem{Row: 1, Title: "Nevermind", Artist: "Nirvana", ReleaseYear: 1991, Genre: "Unknown",
+		Format: "LP", Price: 2499, Status: importStatusNew}, items[0])
+	assert.Equal(t, importStatusDuplicate, items[1].Status)
+	assert.Equal(t, "7", items[1].ExistingAlbumID)
+	assert.Equal(t, "LP", items[1].Format, "the disc count is dropped from the format")
+	assert.Equal(t, importStatusInvalid, items[2].Status, "missing artist")
+	assert.Equal(t, importStatusDuplicate, items[3].Status)
+	assert.Equal(t, "same as row 1", items[3].Reason)
+	assert.Equal(t, importStatusInvalid, items[4].Status, "missing release year")
+}
+
+func TestDiscogsImportHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, kafkaWriter
+	db = mockDB
+	t.Cleanup(func() { db, kafkaWriter = originalDB, originalWriter })
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Preview requires a price", func(t *testing.T) {
+		rr := post("/api/albums/import/discogs", "text/csv", discogsCSVExport)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Preview stores the classified rows", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT id, title, artist FROM albums WHERE \(lower\(title\), lower\(artist\)\) IN`).
+			WithArgs("nevermind", "nirvana", "kind of blue", "miles davis", "untitled", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist"}).AddRow(7, "Kind of Blue", "Miles Davis"))
+		mock.ExpectQuery("INSERT INTO album_imports").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		rr := post("/api/albums/import/discogs?price=19.99&genre=Jazz", "text/csv", discogsCSVExport)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var preview ImportPreview
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &preview))
+		assert.Len(t, preview.ImportID, 32)
+		assert.Equal(t, ImportSummary{New: 1, Duplicate: 1, Invalid: 1}, preview.Summary)
+		assert.Equal(t, "Jazz", preview.Items[0].Genre)
+	})
+
+	items, err := json.Marshal([]ImportItem{
+		{Row: 1, Title: "Nevermind", Artist: "Nirvana", ReleaseYear: 1991, Genre: "Rock", Format: "LP", Price: 1999, Status: importStatusNew},
+		{Row: 2, Title: "Kind of Blue", Artist: "Miles Davis", ReleaseYear: 1959, Genre: "Jazz", Price: 1999, Status: importStatusDuplicate},
+		{Row: 3, Title: "Pink Moon", Artist: "Nick Drake", ReleaseYear: 1972, Genre: "Folk", Price: 1999, Status: importStatusNew},
+	})
+	require.NoError(t, err)
+	importRows := func(createdAt time.Time, confirmedAt interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"items", "created_at", "confirmed_at"}).AddRow(items, createdAt, confirmedAt)
+	}
+
+	t.Run("Confirm inserts new rows and publishes album-created", func(t *testing.T) {
+		writer := &recordingWriter{}
+		kafkaWriter = writer
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT items, created_at, confirmed_at FROM album_imports").WithArgs("abc").
+			WillReturnRows(importRows(time.Now(), nil))
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "LP").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+		// Pink Moon was added to the catalog after the preview
+		mock.ExpectQuery("INSERT INTO albums").WithArgs("Pink Moon", "Nick Drake", 1999, 1972, "Folk", "").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("UPDATE album_imports SET confirmed_at").WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := post("/api/albums/import/discogs/abc/confirm", "application/json", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var result ImportResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		require.Len(t, result.Created, 1)
+		assert.Equal(t, "11", result.Created[0].ID)
+		assert.Equal(t, 1, result.Skipped)
+		assert.Equal(t, 0, result.PublishFailures)
+		if assert.Len(t, writer.messages, 1) {
+			assert.Equal(t, "11", string(writer.messages[0].Key))
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		rows *sqlmock.Rows
+		code int
+	}{
+		{"Confirm twice", importRows(time.Now(), time.Now()), http.StatusConflict},
+		{"Confirm after expiry", importRows(time.Now().Add(-2*discogsImportTTL), nil), http.StatusGone},
+		{"Confirm unknown import", sqlmock.NewRows([]string{"items", "created_at", "confirmed_at"}), http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT items, created_at, confirmed_at FROM album_imports").WithArgs("abc").WillReturnRows(tc.rows)
+			mock.ExpectRollback()
+
+			rr := post("/api/albums/import/discogs/abc/confirm", "application/json", "")
+			assert.Equal(t, tc.code, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
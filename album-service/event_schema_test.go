@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"album-store/events"
+	"album-store/kafkawriter"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEventSchemaConfig(t *testing.T) {
+	prevVersions, prevConsume := eventPublishVersions, eventConsumeVersion
+	t.Cleanup(func() { eventPublishVersions, eventConsumeVersion = prevVersions, prevConsume })
+
+	require.NoError(t, loadEventSchemaConfig())
+	assert.Equal(t, []int{eventSchemaV1, eventSchemaV2}, eventPublishVersions, "dual-published by default")
+	assert.Equal(t, eventSchemaV2, eventConsumeVersion, "enveloped events are consumed by default")
+
+	t.Setenv("EVENT_SCHEMA_PUBLISH_VERSIONS", "1")
+	t.Setenv("EVENT_SCHEMA_CONSUME_VERSION", "1")
+	require.NoError(t, loadEventSchemaConfig())
+	assert.Equal(t, []int{eventSchemaV1}, eventPublishVersions)
+	assert.Equal(t, eventSchemaV1, eventConsumeVersion)
+
+	t.Setenv("EVENT_SCHEMA_PUBLISH_VERSIONS", "1, 2,1")
+	t.Setenv("EVENT_SCHEMA_CONSUME_VERSION", "2")
+	require.NoError(t, loadEventSchemaConfig())
+	assert.Equal(t, []int{eventSchemaV1, eventSchemaV2}, eventPublishVersions)
+	assert.Equal(t, eventSchemaV2, eventConsumeVersion)
+
+	t.Setenv("EVENT_SCHEMA_PUBLISH_VERSIONS", "1,3")
+	assert.ErrorContains(t, loadEventSchemaConfig(), `EVENT_SCHEMA_PUBLISH_VERSIONS: unsupported schema version "3"`)
+
+	t.Setenv("EVENT_SCHEMA_PUBLISH_VERSIONS", "")
+	t.Setenv("EVENT_SCHEMA_CONSUME_VERSION", "two")
+	assert.ErrorContains(t, loadEventSchemaConfig(), `EVENT_SCHEMA_CONSUME_VERSION: unsupported schema version "two"`)
+}
+
+func TestVersionedEventWriter(t *testing.T) {
+	prevVersions := eventPublishVersions
+	eventPublishVersions = []int{eventSchemaV1, eventSchemaV2}
+	t.Cleanup(func() { eventPublishVersions = prevVersions })
+
+	v1, v2 := &recordingWriter{}, &recordingWriter{}
+	writer := &versionedEventWriter{eventType: events.TypeAlbumCreated, writers: map[int]messageWriter{eventSchemaV1: v1, eventSchemaV2: v2}}
+	payload, err := events.MarshalJSON(&events.AlbumCreated{AlbumId: "42", Title: "Blue Train"})
+	require.NoError(t, err)
+
+	require.NoError(t, writer.WriteMessages(context.Background(),
+		kafka.Message{Key: []byte("42"), Value: payload}, kafka.Message{Key: []byte("43"), Value: payload}))
+	require.Len(t, v1.messages, 2)
+	assert.JSONEq(t, string(payload), string(v1.messages[0].Value), "v1 is published as encoded")
+
+	require.Len(t, v2.messages, 2)
+	var first, second events.Envelope
+	require.NoError(t, json.Unmarshal(v2.messages[0].Value, &first))
+	require.NoError(t, json.Unmarshal(v2.messages[1].Value, &second))
+	assert.Equal(t, eventSchemaV2, first.SchemaVersion)
+	assert.Equal(t, events.TypeAlbumCreated, first.EventType)
+	assert.Equal(t, "album-service", first.Producer)
+	assert.NotEmpty(t, first.EventID)
+	assert.NotEqual(t, first.EventID, second.EventID, "every event gets its own ID")
+	assert.JSONEq(t, string(payload), string(first.Data))
+	assert.Equal(t, "42", string(v2.messages[0].Key))
+
+	// A failing version doesn't keep the event from the others
+	v1.err = kafkawriter.ErrUnavailable
+	err = writer.WriteMessages(context.Background(), kafka.Message{Value: payload})
+	assert.ErrorIs(t, err, kafkawriter.ErrUnavailable)
+	assert.ErrorContains(t, err, "schema v1")
+	assert.Len(t, v2.messages, 3)
+
+	assert.Error(t, writer.WriteMessages(context.Background(), kafka.Message{Value: []byte{0, 0, 0, 0, 1, 2}}), "Avro events have no envelope")
+}
+
+func TestByEventType(t *testing.T) {
+	var handled []string
+	handle := byEventType(orderSucceededTopic, map[string]func(kafka.Message) error{
+		events.TypeOrderSucceeded: func(msg kafka.Message) error {
+			handled = append(handled, string(msg.Key))
+			return nil
+		},
+	})
+	skipped := unhandledEventTypes.WithLabelValues(orderSucceededTopic)
+	before := testutil.ToFloat64(skipped)
+
+	for key, value := range map[string]string{
+		"v1":         `{"orderId":"order-1"}`,
+		"v2":         `{"schemaVersion":2,"eventType":"order.succeeded","data":{"orderId":"order-2"}}`,
+		"snake_case": `{"schema_version":2,"event_type":"order.succeeded","data":{"order_id":"order-3"}}`,
+		"refunded":   `{"schemaVersion":2,"eventType":"order.refunded","data":{"orderId":"order-4"}}`,
+	} {
+		require.NoError(t, handle(kafka.Message{Key: []byte(key), Value: []byte(value)}), key)
+	}
+	assert.ElementsMatch(t, []string{"v1", "v2", "snake_case"}, handled)
+	assert.Equal(t, before+1, testutil.ToFloat64(skipped), "an unhandled type is skipped")
+}
@@ -0,0 +1,182 @@
+// event_serialization.go - wire format of album events. EVENT_SERIALIZATION=json (the default) publishes
+// album-created and album-deleted as JSON. With avro they are encoded with the schemas in schemas/,
+// which are registered at startup in the schema registry at SCHEMA_REGISTRY_URL under "<topic>-value",
+// and sent in the Confluent wire format: a zero byte, the 4-byte schema ID and the Avro body. The
+// registry refuses a schema that isn't compatible with the subject's earlier versions, which stops
+// startup before an event consumers can't read is published.
+//
+// inventory-service reads both formats from the same topic, so upgrade it before switching to avro.
+// Order events stay JSON: order-service doesn't read Avro. Avro events are schema v1 only, so with
+// avro EVENT_SCHEMA_PUBLISH_VERSIONS must be set to 1, and inventory-service's EVENT_SCHEMA_CONSUME_VERSION too.
+
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"album-store/events"
+	"album-store/topics"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	eventSerializationJSON = "json"
+	eventSerializationAvro = "avro"
+)
+
+// schemaRegistryTimeout bounds each request to the schema registry
+const schemaRegistryTimeout = 5 * time.Second
+
+//go:embed schemas/*.avsc
+var eventSchemaFiles embed.FS
+
+// avroEventSchemas names the schema file of each base topic published in Avro
+var avroEventSchemas = map[string]string{
+	albumCreatedTopic: "schemas/album_created.avsc",
+	albumDeletedTopic: "schemas/album_deleted.avsc",
+}
+
+// registeredSchema is a schema and the ID the registry assigned it
+type registeredSchema struct {
+	ID     int
+	Schema avro.Schema
+}
+
+var (
+	// eventSerialization is the format album events are published in (EVENT_SERIALIZATION)
+	eventSerialization = eventSerializationJSON
+	// schemaRegistryURL is the Confluent-compatible schema registry (SCHEMA_REGISTRY_URL)
+	schemaRegistryURL string
+	// registeredEventSchemas holds the schema of each base topic in avroEventSchemas, set by registerEventSchemas
+	registeredEventSchemas = map[string]registeredSchema{}
+)
+
+// loadEventSerialization reads EVENT_SERIALIZATION and SCHEMA_REGISTRY_URL. It runs after
+// loadEventSchemaConfig, whose publish versions it checks.
+func loadEventSerialization() error {
+	schemaRegistryURL = os.Getenv("SCHEMA_REGISTRY_URL")
+	if schemaRegistryURL != "" {
+		if u, err := url.Parse(schemaRegistryURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("SCHEMA_REGISTRY_URL %q is not an absolute URL", schemaRegistryURL)
+		}
+	}
+	switch v := os.Getenv("EVENT_SERIALIZATION"); v {
+	case "", eventSerializationJSON:
+		eventSerialization = eventSerializationJSON
+	case eventSerializationAvro:
+		if schemaRegistryURL == "" {
+			return errors.New("EVENT_SERIALIZATION=avro requires SCHEMA_REGISTRY_URL")
+		}
+		for _, version := range eventPublishVersions {
+			if version != eventSchemaV1 {
+				return fmt.Errorf("EVENT_SERIALIZATION=avro can't be published in schema v%d: only JSON events are wrapped in the envelope, set EVENT_SCHEMA_PUBLISH_VERSIONS=1", version)
+			}
+		}
+		eventSerialization = eventSerializationAvro
+	default:
+		return fmt.Errorf("EVENT_SERIALIZATION %q must be json or avro", v)
+	}
+	return nil
+}
+
+// newSchemaRegistryClient returns a client of the registry at SCHEMA_REGISTRY_URL
+func newSchemaRegistryClient() (*registry.Client, error) {
+	return registry.NewClient(schemaRegistryURL, registry.WithHTTPClient(&http.Client{Timeout: schemaRegistryTimeout}))
+}
+
+// eventSchemaSubject is the registry subject of a base topic's values, named after the
+// environment-scoped topic like the Confluent serializers do
+func eventSchemaSubject(base string) string {
+	return topics.Name(base) + "-value"
+}
+
+// parseEventSchema parses an embedded schema file
+func parseEventSchema(file string) (string, avro.Schema, error) {
+	text, err := eventSchemaFiles.ReadFile(file)
+	if err != nil {
+		return "", nil, err
+	}
+	schema, err := avro.Parse(string(text))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return string(text), schema, nil
+}
+
+// registerEventSchemas registers the schema of every Avro-encoded topic. Registering a schema the
+// registry already has returns its existing ID.
+func registerEventSchemas(ctx context.Context, client *registry.Client) error {
+	schemas := make(map[string]registeredSchema, len(avroEventSchemas))
+	for base, file := range avroEventSchemas {
+		text, _, err := parseEventSchema(file)
+		if err != nil {
+			return err
+		}
+		subject := eventSchemaSubject(base)
+		id, schema, err := client.CreateSchema(ctx, subject, text)
+		if err != nil {
+			var regErr registry.Error
+			if errors.As(err, &regErr) && regErr.StatusCode == http.StatusConflict {
+				return fmt.Errorf("%s is incompatible with the versions registered under %s: %w", file, subject, err)
+			}
+			return fmt.Errorf("registering %s under %s: %w", file, subject, err)
+		}
+		schemas[base] = registeredSchema{ID: id, Schema: schema}
+	}
+	registeredEventSchemas = schemas
+	return nil
+}
+
+// encodeAlbumEvent serializes an event published on a base topic in the configured format
+func encodeAlbumEvent(base string, event proto.Message) ([]byte, error) {
+	if eventSerialization != eventSerializationAvro {
+		return events.MarshalJSON(event)
+	}
+	registered, ok := registeredEventSchemas[base]
+	if !ok {
+		return nil, fmt.Errorf("no registered schema for %s", base)
+	}
+	record, err := events.ToAvroRecord(event)
+	if err != nil {
+		return nil, err
+	}
+	body, err := avro.Marshal(registered.Schema, record)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(value[1:], uint32(registered.ID))
+	return append(value, body...), nil
+}
+
+// checkEventSchemaCompatible checks that the schema of base can read events written with the latest
+// version registered under its subject, the registry's default (backward) compatibility rule. It
+// returns the registered version checked against, or 0 if the subject is new.
+func checkEventSchemaCompatible(ctx context.Context, client *registry.Client, base string) (int, error) {
+	_, schema, err := parseEventSchema(avroEventSchemas[base])
+	if err != nil {
+		return 0, err
+	}
+	latest, err := client.GetLatestSchemaInfo(ctx, eventSchemaSubject(base))
+	var regErr registry.Error
+	if errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := avro.NewSchemaCompatibility().Compatible(schema, latest.Schema); err != nil {
+		return latest.Version, fmt.Errorf("incompatible with version %d: %w", latest.Version, err)
+	}
+	return latest.Version, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeSchemaRegistry serves the registry endpoints used here. Registrations get IDs from 1 up unless
+// conflict is set; latest maps subjects to the schema returned as their latest version.
+type fakeSchemaRegistry struct {
+	conflict bool
+	latest   map[string]string
+	subjects []string
+}
+
+func (f *fakeSchemaRegistry) client(t *testing.T) *registry.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/subjects/"), "/")
+		switch {
+		case r.Method == http.MethodPost && rest == "versions" && f.conflict:
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 409, "message": "Schema being registered is incompatible with an earlier schema"})
+		case r.Method == http.MethodPost && rest == "versions":
+			f.subjects = append(f.subjects, subject)
+			_ = json.NewEncoder(w).Encode(map[string]int{"id": len(f.subjects)})
+		case r.Method == http.MethodGet && rest == "versions/latest" && f.latest[subject] != "":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "version": 3, "schema": f.latest[subject]})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 40401, "message": "Subject not found"})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := registry.NewClient(srv.URL)
+	require.NoError(t, err)
+	return client
+}
+
+func TestLoadEventSerialization(t *testing.T) {
+	t.Cleanup(func() { eventSerialization, schemaRegistryURL = eventSerializationJSON, "" })
+
+	require.NoError(t, loadEventSerialization())
+	assert.Equal(t, eventSerializationJSON, eventSerialization)
+
+	t.Setenv("EVENT_SERIALIZATION", "avro")
+	assert.ErrorContains(t, loadEventSerialization(), "requires SCHEMA_REGISTRY_URL")
+
+	t.Setenv("SCHEMA_REGISTRY_URL", "http://schema-registry:8081")
+	assert.ErrorContains(t, loadEventSerialization(), "can't be published in schema v2", "v2 is published by default")
+
+	prevVersions := eventPublishVersions
+	eventPublishVersions = []int{eventSchemaV1}
+	require.NoError(t, loadEventSerialization())
+	assert.Equal(t, eventSerializationAvro, eventSerialization)
+	eventPublishVersions = prevVersions
+
+	t.Setenv("SCHEMA_REGISTRY_URL", "schema-registry:8081")
+	assert.ErrorContains(t, loadEventSerialization(), "not an absolute URL")
+
+	t.Setenv("SCHEMA_REGISTRY_URL", "")
+	t.Setenv("EVENT_SERIALIZATION", "protobuf")
+	assert.ErrorContains(t, loadEventSerialization(), "must be json or avro")
+}
+
+func TestEncodeAlbumEvent(t *testing.T) {
+	t.Cleanup(func() {
+		eventSerialization = eventSerializationJSON
+		registeredEventSchemas = map[string]registeredSchema{}
+	})
+	quantity := int32(5)
+	event := &events.AlbumCreated{AlbumId: "42", Title: "Blue Train", Artist: "John Coltrane",
+		Timestamp: timestamppb.New(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)), InitialQuantity: &quantity}
+
+	t.Run("JSON", func(t *testing.T) {
+		value, err := encodeAlbumEvent(albumCreatedTopic, event)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"albumId":"42","title":"Blue Train","artist":"John Coltrane","timestamp":"2026-03-01T12:00:00Z","initialQuantity":5}`, string(value))
+	})
+
+	t.Run("Avro in the Confluent wire format", func(t *testing.T) {
+		fake := &fakeSchemaRegistry{}
+		require.NoError(t, registerEventSchemas(context.Background(), fake.client(t)))
+		assert.ElementsMatch(t, []string{"album-created-value", "album-deleted-value"}, fake.subjects)
+		eventSerialization = eventSerializationAvro
+
+		value, err := encodeAlbumEvent(albumCreatedTopic, event)
+		require.NoError(t, err)
+		require.Greater(t, len(value), 5)
+		assert.Equal(t, byte(0), value[0])
+		registered := registeredEventSchemas[albumCreatedTopic]
+		assert.Equal(t, uint32(registered.ID), binary.BigEndian.Uint32(value[1:5]))
+
+		var record map[string]interface{}
+		require.NoError(t, avro.Unmarshal(registered.Schema, value[5:], &record))
+		var decoded events.AlbumCreated
+		require.NoError(t, events.FromAvroRecord(record, &decoded))
+		assert.True(t, proto.Equal(event, &decoded), "decoded %v", &decoded)
+	})
+
+	t.Run("Incompatible schema", func(t *testing.T) {
+		err := registerEventSchemas(context.Background(), (&fakeSchemaRegistry{conflict: true}).client(t))
+		assert.ErrorContains(t, err, "incompatible")
+	})
+}
+
+func TestCheckEventSchemaCompatible(t *testing.T) {
+	fake := &fakeSchemaRegistry{latest: map[string]string{
+		// An earlier version without initialQuantity: the new field has a default
+		"album-created-value": `{"type":"record","name":"AlbumCreated","namespace":"albumstore.events","fields":[
+			{"name":"albumId","type":"string"},{"name":"title","type":"string"},{"name":"artist","type":"string"},
+			{"name":"timestamp","type":{"type":"long","logicalType":"timestamp-millis"}}]}`,
+		// albumId was a number: it can't be read as a string
+		"album-deleted-value": `{"type":"record","name":"AlbumDeleted","namespace":"albumstore.events","fields":[
+			{"name":"albumId","type":"long"},{"name":"timestamp","type":{"type":"long","logicalType":"timestamp-millis"}}]}`,
+	}}
+	client := fake.client(t)
+
+	version, err := checkEventSchemaCompatible(context.Background(), client, albumCreatedTopic)
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	_, err = checkEventSchemaCompatible(context.Background(), client, albumDeletedTopic)
+	assert.ErrorContains(t, err, "incompatible with version 3")
+
+	delete(fake.latest, "album-created-value")
+	version, err = checkEventSchemaCompatible(context.Background(), client, albumCreatedTopic)
+	require.NoError(t, err)
+	assert.Zero(t, version, "a new subject has nothing to be compatible with")
+}
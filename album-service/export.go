@@ -0,0 +1,196 @@
+// export.go - GET /api/albums/export, streaming the whole catalog as CSV or JSON for backups and
+// reporting. Rows are read through a server-side cursor, so memory use doesn't grow with the catalog.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"album-store/jsonnaming"
+	"github.com/gin-gonic/gin"
+)
+
+const exportFetchSize = 500 // Rows fetched from the cursor per round trip
+
+// exportCompleteTrailer is sent after the body: "true" when every album was written. A failure
+// after streaming has started can't change the status code, so clients must check it.
+const exportCompleteTrailer = "X-Export-Complete"
+
+var exportCSVHeader = []string{"id", "title", "artist", "price", "release_year", "genre", "format"}
+
+// exportAlbums handles GET /api/albums/export?format=csv|json (default json)
+func exportAlbums(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	var exp albumExporter
+	switch format {
+	case "csv":
+		exp = &csvAlbumExporter{w: csv.NewWriter(c.Writer)}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	case "json":
+		exp = &jsonAlbumExporter{w: c.Writer, profile: responseProfile(c), naming: jsonnaming.Of(c)}
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := openAlbumCursor(ctx, db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	filename := fmt.Sprintf("albums-%s.%s", time.Now().UTC().Format("20060102"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Trailer", exportCompleteTrailer)
+	c.Status(http.StatusOK)
+
+	count, err := 0, exp.start()
+	if err == nil {
+		count, err = fetchAlbumCursor(ctx, tx, exp.write, func() error {
+			if err := exp.flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	}
+	if err == nil {
+		err = exp.finish()
+	}
+	c.Writer.Header().Set(exportCompleteTrailer, strconv.FormatBool(err == nil))
+	if err != nil {
+		log.Printf("Album export aborted after %d albums: %v", count, err)
+		return
+	}
+	log.Printf("Exported %d albums as %s", count, format)
+}
+
+// albumExporter writes albums in one export format
+type albumExporter interface {
+	start() error
+	write(a Album) error
+	flush() error // Push buffered output to the response
+	finish() error
+}
+
+type csvAlbumExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvAlbumExporter) start() error { return e.w.Write(exportCSVHeader) }
+
+func (e *csvAlbumExporter) write(a Album) error {
+	return e.w.Write([]string{publicIDs.encode(a.ID), a.Title, a.Artist, a.Price.String(),
+		strconv.Itoa(a.ReleaseYear), a.Genre, a.Format})
+}
+
+func (e *csvAlbumExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvAlbumExporter) finish() error { return e.flush() }
+
+// jsonAlbumExporter writes a JSON array one element at a time. The response is streamed, so it is
+// named here instead of by jsonnaming.Middleware.
+type jsonAlbumExporter struct {
+	w       io.Writer
+	profile string
+	naming  string
+	written bool
+}
+
+func (e *jsonAlbumExporter) start() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonAlbumExporter) write(a Album) error {
+	if e.written {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.written = true
+	element, err := json.Marshal(forProfile(a, e.profile))
+	if err == nil && e.naming == jsonnaming.SnakeCase {
+		element, err = jsonnaming.RenameKeys(element, jsonnaming.SnakeCaseKey)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "%s\n", element)
+	return err
+}
+
+func (e *jsonAlbumExporter) flush() error { return nil }
+
+func (e *jsonAlbumExporter) finish() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// openAlbumCursor declares a cursor over every album in ID order. It runs in a read-only repeatable
+// read transaction, so the export is a consistent snapshot even while the catalog changes.
+func openAlbumCursor(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"DECLARE album_export NO SCROLL CURSOR FOR SELECT id, title, artist, price_cents, release_year, genre, COALESCE(format, '') FROM albums ORDER BY id"); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// fetchAlbumCursor passes every album of the cursor to emit and returns how many it passed. flush is
+// called after every fetched batch.
+func fetchAlbumCursor(ctx context.Context, tx *sql.Tx, emit func(Album) error, flush func() error) (int, error) {
+	count := 0
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM album_export", exportFetchSize))
+		if err != nil {
+			return count, err
+		}
+		fetched := 0
+		for rows.Next() {
+			var a Album
+			var id int
+			if err := rows.Scan(&id, &a.Title, &a.Artist, &a.Price, &a.ReleaseYear, &a.Genre, &a.Format); err != nil {
+				rows.Close()
+				return count, err
+			}
+			a.ID = strconv.Itoa(id)
+			if err := emit(a); err != nil {
+				rows.Close()
+				return count, err
+			}
+			fetched++
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+		if err := flush(); err != nil {
+			return count, err
+		}
+		if fetched < exportFetchSize {
+			return count, nil
+		}
+	}
+}
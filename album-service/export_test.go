@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAlbums(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	columns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format"}
+	expectCursor := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec("DECLARE album_export NO SCROLL CURSOR").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	export := func(format string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/albums/export?format="+format, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		expectCursor()
+		mock.ExpectQuery("FETCH FORWARD 500 FROM album_export").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP").
+			AddRow(2, "Kind of Blue, Remastered", "Miles Davis", 2450, 1959, "Jazz", ""))
+		mock.ExpectRollback()
+
+		rr := export("csv")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), ".csv")
+		assert.Equal(t, "id,title,artist,price,release_year,genre,format\n"+
+			"1,Nevermind,Nirvana,19.99,1991,Rock,LP\n"+
+			"2,\"Kind of Blue, Remastered\",Miles Davis,24.50,1959,Jazz,\n", rr.Body.String())
+		assert.Equal(t, "true", rr.Result().Trailer.Get(exportCompleteTrailer))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		expectCursor()
+		mock.ExpectQuery("FETCH FORWARD 500 FROM album_export").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP").
+			AddRow(2, "Kind of Blue", "Miles Davis", 2450, 1959, "Jazz", ""))
+		mock.ExpectRollback()
+
+		rr := export("json")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var albums []Album
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &albums), rr.Body.String())
+		if assert.Len(t, albums, 2) {
+			assert.Equal(t, "2", albums[1].ID)
+			assert.Equal(t, "Kind of Blue", albums[1].Title)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Empty catalog is an empty array", func(t *testing.T) {
+		expectCursor()
+		mock.ExpectQuery("FETCH FORWARD 500 FROM album_export").WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectRollback()
+
+		rr := export("json")
+		assert.JSONEq(t, "[]", rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failure while streaming is reported in the trailer", func(t *testing.T) {
+		expectCursor()
+		mock.ExpectQuery("FETCH FORWARD 500 FROM album_export").WillReturnError(errors.New("connection reset"))
+		mock.ExpectRollback()
+
+		rr := export("csv")
+		assert.Equal(t, http.StatusOK, rr.Code, "the status is sent before streaming starts")
+		assert.Equal(t, "false", rr.Result().Trailer.Get(exportCompleteTrailer))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failure to open the cursor", func(t *testing.T) {
+		mock.ExpectBegin().WillReturnError(errors.New("too many connections"))
+		assert.Equal(t, http.StatusInternalServerError, export("csv").Code)
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, export("xml").Code)
+	})
+
+	t.Run("Admins only", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/albums/export", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
@@ -0,0 +1,534 @@
+// facets.go - storefront catalog filters and facet counts
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// Facet names double as the query parameters used to filter on them
+const (
+	facetGenre        = "genre"
+	facetPriceBand    = "priceBand"
+	facetDecade       = "decade"
+	facetAvailability = "availability"
+)
+
+// priceBand is a half-open price range [Min, Max); Max == 0 means unbounded
+type priceBand struct {
+	Key string
+	Min Cents
+	Max Cents
+}
+
+var priceBands = []priceBand{
+	{Key: "under_10", Min: 0, Max: 1000},
+	{Key: "10_20", Min: 1000, Max: 2000},
+	{Key: "20_30", Min: 2000, Max: 3000},
+	{Key: "30_plus", Min: 3000},
+}
+
+// Availability is read from inventory-service's inventory table (shared albumdb). Albums without an
+// inventory row yet are "unknown" rather than out of stock.
+var availabilityValues = []string{"in_stock", "out_of_stock", "unknown"}
+
+const (
+	availabilityJoin = "LEFT JOIN inventory i ON i.album_id = a.id::text"
+	availabilityExpr = "CASE WHEN i.album_id IS NULL THEN 'unknown' WHEN i.quantity_available > 0 THEN 'in_stock' ELSE 'out_of_stock' END"
+	decadeExpr       = "(a.release_year / 10) * 10"
+)
+
+// albumFilter is the storefront filter set. Values within a facet are OR'ed, facets are AND'ed.
+// Artists, the price range and release years are plain filters: they narrow every facet count.
+type albumFilter struct {
+	Genres       []string
+	PriceBands   []priceBand
+	Decades      []int
+	Availability []string
+	Attributes   []attributeFilter // ?attr.<name>=, see album_attributes.go
+
+	IDs          []int    // Batch fetches by ID, see parseAlbumIDs
+	Artists      []string // Matched case-insensitively
+	ArtistIDs    []int    // See artists.go
+	MinPrice     *Cents   // Inclusive
+	MaxPrice     *Cents   // Inclusive
+	ReleaseYears []int
+	Statuses     []string // See album_status.go; always set for public callers
+}
+
+// FacetBucket is a single facet value and the number of albums it would match
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// FacetsResponse is returned by GET /api/albums/facets
+type FacetsResponse struct {
+	Total  int                      `json:"total"`
+	Facets map[string][]FacetBucket `json:"facets"`
+}
+
+// parseAlbumFilter reads the filter set from the request's query string
+func parseAlbumFilter(c *gin.Context) (albumFilter, error) {
+	f := albumFilter{Genres: listing.QueryValues(c, facetGenre)}
+
+	for _, key := range listing.QueryValues(c, facetPriceBand) {
+		band, ok := findPriceBand(key)
+		if !ok {
+			return f, fmt.Errorf("unknown price band %q", key)
+		}
+		f.PriceBands = append(f.PriceBands, band)
+	}
+
+	for _, v := range listing.QueryValues(c, facetDecade) {
+		decade, err := strconv.Atoi(v)
+		if err != nil || decade%10 != 0 {
+			return f, fmt.Errorf("invalid decade %q, expected e.g. 1990", v)
+		}
+		f.Decades = append(f.Decades, decade)
+	}
+
+	for _, v := range listing.QueryValues(c, facetAvailability) {
+		if !containsString(availabilityValues, v) {
+			return f, fmt.Errorf("unknown availability %q", v)
+		}
+		f.Availability = append(f.Availability, v)
+	}
+
+	attributes, err := parseAttributeFilters(c)
+	if err != nil {
+		return f, err
+	}
+	f.Attributes = attributes
+
+	ids, err := parseAlbumIDs(c)
+	if err != nil {
+		return f, err
+	}
+	f.IDs = ids
+
+	f.Artists = listing.QueryValues(c, "artist")
+	for _, v := range listing.QueryValues(c, "artistId") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid artistId %q", v)
+		}
+		f.ArtistIDs = append(f.ArtistIDs, id)
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  **Cents
+	}{{"minPrice", &f.MinPrice}, {"maxPrice", &f.MaxPrice}} {
+		v := c.Query(bound.name)
+		if v == "" {
+			continue
+		}
+		price, err := parseCents(v)
+		if err != nil || price < 0 {
+			return f, fmt.Errorf("invalid %s %q, expected a non-negative amount, e.g. 19.99", bound.name, v)
+		}
+		*bound.dst = &price
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return f, fmt.Errorf("minPrice must not be greater than maxPrice")
+	}
+
+	for _, v := range listing.QueryValues(c, "releaseYear") {
+		year, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid releaseYear %q", v)
+		}
+		f.ReleaseYears = append(f.ReleaseYears, year)
+	}
+
+	statuses, err := parseStatusFilter(c)
+	if err != nil {
+		return f, err
+	}
+	f.Statuses = statuses
+	return f, nil
+}
+
+// parseAlbumIDs reads ?ids=, the public IDs of albums to fetch in one request (e.g. to hydrate cart
+// lines), at most one page of them. IDs that aren't album IDs are left out like unknown albums.
+func parseAlbumIDs(c *gin.Context) ([]int, error) {
+	values := listing.QueryValues(c, "ids")
+	if len(values) > listing.MaxLimit {
+		return nil, fmt.Errorf("at most %d ids can be fetched at once", listing.MaxLimit)
+	}
+	var ids []int
+	seen := make(map[int]bool, len(values))
+	for _, v := range values {
+		internal, ok := publicIDs.decode(v)
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(internal)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid album id %q", v)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(values) > 0 && len(ids) == 0 {
+		ids = []int{0} // Nothing to find, but the filter still applies
+	}
+	return ids, nil
+}
+
+// fitPageToIDs widens the default page of a batch fetch to every requested album
+func fitPageToIDs(c *gin.Context, f albumFilter, page *listing.Params) {
+	if len(f.IDs) > 0 && c.Query("limit") == "" {
+		page.Limit = listing.MaxLimit
+	}
+}
+
+func findPriceBand(key string) (priceBand, bool) {
+	for _, b := range priceBands {
+		if b.Key == key {
+			return b, true
+		}
+	}
+	return priceBand{}, false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// needsInventory reports whether applying the filter requires the inventory join
+func (f albumFilter) needsInventory() bool {
+	return len(f.Availability) > 0
+}
+
+// facetNames lists the facets of the filter: the fixed ones, then the attributes it filters on and
+// the attributes counted (see album_attributes.go)
+func (f albumFilter) facetNames(counted ...string) []string {
+	names := []string{facetGenre, facetPriceBand, facetDecade, facetAvailability}
+	for _, attr := range f.Attributes {
+		names = append(names, attributeFacet(attr.Name))
+	}
+	for _, name := range counted {
+		if !containsString(names, attributeFacet(name)) {
+			names = append(names, attributeFacet(name))
+		}
+	}
+	return names
+}
+
+// predicates returns one SQL condition per facet (TRUE when the facet isn't filtered), appending
+// bind values to args. Conditions reference albums as "a" and inventory as "i".
+func (f albumFilter) predicates(args *[]interface{}) map[string]string {
+	bind := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	preds := map[string]string{
+		facetGenre:        "TRUE",
+		facetPriceBand:    "TRUE",
+		facetDecade:       "TRUE",
+		facetAvailability: "TRUE",
+	}
+
+	if len(f.Genres) > 0 {
+		placeholders := make([]string, len(f.Genres))
+		for i, g := range f.Genres {
+			placeholders[i] = bind(g)
+		}
+		preds[facetGenre] = "a.genre IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if len(f.PriceBands) > 0 {
+		conds := make([]string, len(f.PriceBands))
+		for i, b := range f.PriceBands {
+			conds[i] = priceBandCondition(b, bind)
+		}
+		preds[facetPriceBand] = "(" + strings.Join(conds, " OR ") + ")"
+	}
+	if len(f.Decades) > 0 {
+		placeholders := make([]string, len(f.Decades))
+		for i, d := range f.Decades {
+			placeholders[i] = bind(d)
+		}
+		preds[facetDecade] = decadeExpr + " IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if len(f.Availability) > 0 {
+		placeholders := make([]string, len(f.Availability))
+		for i, v := range f.Availability {
+			placeholders[i] = bind(v)
+		}
+		preds[facetAvailability] = availabilityExpr + " IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	for _, attr := range f.Attributes {
+		preds[attributeFacet(attr.Name)] = attr.condition(bind)
+	}
+	return preds
+}
+
+// commonConditions returns the conditions of the plain (non-facet) filters, appending bind values to args
+func (f albumFilter) commonConditions(args *[]interface{}) []string {
+	bind := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	var conds []string
+	if len(f.IDs) > 0 {
+		placeholders := make([]string, len(f.IDs))
+		for i, id := range f.IDs {
+			placeholders[i] = bind(id)
+		}
+		conds = append(conds, "a.id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if len(f.Artists) > 0 {
+		placeholders := make([]string, len(f.Artists))
+		for i, artist := range f.Artists {
+			placeholders[i] = "lower(" + bind(artist) + ")"
+		}
+		conds = append(conds, "lower(a.artist) IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if len(f.ArtistIDs) > 0 {
+		placeholders := make([]string, len(f.ArtistIDs))
+		for i, id := range f.ArtistIDs {
+			placeholders[i] = bind(id)
+		}
+		conds = append(conds, "a.artist_id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if f.MinPrice != nil {
+		conds = append(conds, "a.price_cents >= "+bind(*f.MinPrice))
+	}
+	if f.MaxPrice != nil {
+		conds = append(conds, "a.price_cents <= "+bind(*f.MaxPrice))
+	}
+	if len(f.ReleaseYears) > 0 {
+		placeholders := make([]string, len(f.ReleaseYears))
+		for i, year := range f.ReleaseYears {
+			placeholders[i] = bind(year)
+		}
+		conds = append(conds, "a.release_year IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if len(f.Statuses) > 0 {
+		// Statuses are validated against albumStatuses, so they are inlined as literals
+		conds = append(conds, "a.status IN ('"+strings.Join(f.Statuses, "', '")+"')")
+	}
+	return conds
+}
+
+func priceBandCondition(b priceBand, bind func(interface{}) string) string {
+	if b.Max == 0 {
+		return "a.price_cents >= " + bind(b.Min)
+	}
+	return "(a.price_cents >= " + bind(b.Min) + " AND a.price_cents < " + bind(b.Max) + ")"
+}
+
+// whereClause returns " WHERE ..." applying every facet in the filter, or "" when nothing is filtered
+func (f albumFilter) whereClause(args *[]interface{}) string {
+	preds := f.predicates(args)
+	conds := f.commonConditions(args)
+	for _, name := range f.facetNames() {
+		if preds[name] != "TRUE" {
+			conds = append(conds, preds[name])
+		}
+	}
+	return listing.WhereClause(conds)
+}
+
+// priceBandExpr buckets a.price_cents into the priceBands keys
+func priceBandExpr() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	for _, b := range priceBands {
+		if b.Max == 0 {
+			sb.WriteString(fmt.Sprintf(" ELSE '%s'", b.Key))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(" WHEN a.price_cents < %d THEN '%s'", b.Max, b.Key))
+	}
+	sb.WriteString(" END")
+	return sb.String()
+}
+
+// buildFacetsQuery builds a single query returning (facet, value, count) rows. Each facet is counted
+// with every filter applied except its own, so the sidebar keeps showing alternatives to the current
+// selection; the "total" row applies all filters. attributes names the attribute facets to count.
+func buildFacetsQuery(f albumFilter, attributes ...string) (string, []interface{}) {
+	var args []interface{}
+	preds := f.predicates(&args)
+	where := ""
+	if conds := f.commonConditions(&args); len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	all := f.facetNames(attributes...)
+	others := func(skip string) string {
+		var conds []string
+		for _, name := range all {
+			if name != skip {
+				conds = append(conds, facetMatchColumn(name))
+			}
+		}
+		return strings.Join(conds, " AND ")
+	}
+
+	// Attribute names come from the schemas (see album_attributes.go), so they are inlined
+	var attributeColumns, attributeCounts strings.Builder
+	for _, name := range all {
+		attr := strings.TrimPrefix(name, attributeFilterPrefix)
+		if attr == name {
+			continue
+		}
+		pred, ok := preds[name]
+		if !ok {
+			pred = "TRUE"
+		}
+		column := "attr_" + attr
+		attributeColumns.WriteString(fmt.Sprintf(",\n\t\t\t\ta.attributes->>'%s' AS %s,\n\t\t\t\t%s AS %s", attr, column, pred, facetMatchColumn(name)))
+		if containsString(attributes, attr) {
+			attributeCounts.WriteString(fmt.Sprintf("\n\t\tUNION ALL\n\t\tSELECT '%s', %s, COUNT(*) FROM base WHERE %s IS NOT NULL AND %s GROUP BY %s", name, column, column, others(name), column))
+		}
+	}
+
+	query := fmt.Sprintf(`
+		WITH base AS (
+			SELECT a.genre AS genre,
+				%s AS price_band,
+				%s AS decade,
+				%s AS availability,
+				%s AS m_genre,
+				%s AS m_priceBand,
+				%s AS m_decade,
+				%s AS m_availability%s
+			FROM albums a
+			%s
+			%s
+		)
+		SELECT 'total', '', COUNT(*) FROM base WHERE %s
+		UNION ALL
+		SELECT 'genre', genre, COUNT(*) FROM base WHERE %s GROUP BY genre
+		UNION ALL
+		SELECT 'priceBand', price_band, COUNT(*) FROM base WHERE %s GROUP BY price_band
+		UNION ALL
+		SELECT 'decade', decade::text, COUNT(*) FROM base WHERE %s GROUP BY decade
+		UNION ALL
+		SELECT 'availability', availability, COUNT(*) FROM base WHERE %s GROUP BY availability%s`,
+		priceBandExpr(), decadeExpr, availabilityExpr,
+		preds[facetGenre], preds[facetPriceBand], preds[facetDecade], preds[facetAvailability],
+		attributeColumns.String(),
+		availabilityJoin, where,
+		others(""),
+		others(facetGenre), others(facetPriceBand), others(facetDecade), others(facetAvailability),
+		attributeCounts.String(),
+	)
+	return query, args
+}
+
+// facetMatchColumn names the base column telling whether a row matches the facet's filter
+func facetMatchColumn(name string) string {
+	return "m_" + strings.Replace(name, attributeFilterPrefix, "attr_", 1)
+}
+
+// getAlbumFacets handles GET /api/albums/facets
+func getAlbumFacets(c *gin.Context) {
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attributes, err := parseAttributeFacets(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query, args := buildFacetsQuery(filter, attributes...)
+	rows, err := db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query facets: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	resp := FacetsResponse{Facets: map[string][]FacetBucket{}}
+	counts := map[string]map[string]int{}
+	for rows.Next() {
+		var facet, value string
+		var count int
+		if err := rows.Scan(&facet, &value, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan facet row: " + err.Error()})
+			return
+		}
+		if facet == "total" {
+			resp.Total = count
+			continue
+		}
+		if counts[facet] == nil {
+			counts[facet] = map[string]int{}
+		}
+		counts[facet][value] = count
+	}
+	if err = rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating facet rows: " + err.Error()})
+		return
+	}
+
+	resp.Facets[facetGenre] = bucketsByCount(counts[facetGenre])
+	resp.Facets[facetDecade] = bucketsByDecade(counts[facetDecade])
+
+	// Fixed vocabularies are always listed, with zero counts, so the sidebar layout stays stable
+	for _, b := range priceBands {
+		resp.Facets[facetPriceBand] = append(resp.Facets[facetPriceBand], FacetBucket{Value: b.Key, Count: counts[facetPriceBand][b.Key]})
+	}
+	for _, v := range availabilityValues {
+		resp.Facets[facetAvailability] = append(resp.Facets[facetAvailability], FacetBucket{Value: v, Count: counts[facetAvailability][v]})
+	}
+	for _, name := range attributes {
+		resp.Facets[attributeFacet(name)] = bucketsByCount(counts[attributeFacet(name)])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// bucketsByCount orders buckets by count descending, then value
+func bucketsByCount(counts map[string]int) []FacetBucket {
+	buckets := make([]FacetBucket, 0, len(counts))
+	for v, n := range counts {
+		buckets = append(buckets, FacetBucket{Value: v, Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Value < buckets[j].Value
+	})
+	return buckets
+}
+
+// bucketsByDecade orders decade buckets chronologically
+func bucketsByDecade(counts map[string]int) []FacetBucket {
+	buckets := make([]FacetBucket, 0, len(counts))
+	for v, n := range counts {
+		buckets = append(buckets, FacetBucket{Value: v, Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		a, _ := strconv.Atoi(buckets[i].Value)
+		b, _ := strconv.Atoi(buckets[j].Value)
+		return a < b
+	})
+	return buckets
+}
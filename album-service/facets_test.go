@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFacetsQuery_ExcludesOwnFilter(t *testing.T) {
+	f := albumFilter{
+		Genres:     []string{"Rock", "Jazz"},
+		PriceBands: []priceBand{priceBands[1]},
+		Decades:    []int{1990},
+	}
+	query, args := buildFacetsQuery(f)
+
+	assert.Equal(t, []interface{}{"Rock", "Jazz", Cents(1000), Cents(2000), 1990}, args)
+	assert.Contains(t, query, "a.genre IN ($1, $2) AS m_genre")
+	assert.Contains(t, query, "((a.price_cents >= $3 AND a.price_cents < $4)) AS m_priceBand")
+	assert.Contains(t, query, "SELECT 'genre', genre, COUNT(*) FROM base WHERE m_priceBand AND m_decade AND m_availability GROUP BY genre")
+	assert.Contains(t, query, "SELECT 'decade', decade::text, COUNT(*) FROM base WHERE m_genre AND m_priceBand AND m_availability GROUP BY decade")
+}
+
+func TestGetAlbumFacets(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	t.Run("Returns buckets per facet", func(t *testing.T) {
+		mock.ExpectQuery("WITH base AS").
+			WithArgs("Rock").
+			WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}).
+				AddRow("total", "", 3).
+				AddRow("genre", "Jazz", 2).
+				AddRow("genre", "Rock", 3).
+				AddRow("priceBand", "10_20", 3).
+				AddRow("decade", "2000", 1).
+				AddRow("decade", "1990", 2).
+				AddRow("availability", "in_stock", 3))
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/albums/facets?genre=Rock", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp FacetsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, 3, resp.Total)
+		assert.Equal(t, []FacetBucket{{"Rock", 3}, {"Jazz", 2}}, resp.Facets[facetGenre])
+		assert.Equal(t, []FacetBucket{{"1990", 2}, {"2000", 1}}, resp.Facets[facetDecade])
+		assert.Equal(t, []FacetBucket{{"under_10", 0}, {"10_20", 3}, {"20_30", 0}, {"30_plus", 0}}, resp.Facets[facetPriceBand])
+		assert.Equal(t, []FacetBucket{{"in_stock", 3}, {"out_of_stock", 0}, {"unknown", 0}}, resp.Facets[facetAvailability])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rejects unknown filter values", func(t *testing.T) {
+		for _, q := range []string{"priceBand=cheap", "decade=1995", "availability=maybe", "minPrice=abc", "maxPrice=-1", "minPrice=20&maxPrice=5", "releaseYear=nineties"} {
+			req, _ := http.NewRequest(http.MethodGet, "/api/albums/facets?"+q, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, q)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestParseAlbumFilter_PlainFilters(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/albums?genre=Rock&artist=Foo,Bar&minPrice=5&maxPrice=20&releaseYear=1999", nil)
+
+	f, err := parseAlbumFilter(c)
+	require.NoError(t, err)
+
+	var args []interface{}
+	where := f.whereClause(&args)
+	assert.Equal(t, " WHERE lower(a.artist) IN (lower($2), lower($3)) AND a.price_cents >= $4 AND a.price_cents <= $5 AND a.release_year IN ($6) AND a.status IN ('published') AND a.genre IN ($1)", where)
+	assert.Equal(t, []interface{}{"Rock", "Foo", "Bar", Cents(500), Cents(2000), 1999}, args)
+
+	// Plain filters narrow every facet count, so they go into the base CTE
+	query, facetArgs := buildFacetsQuery(f)
+	assert.Contains(t, query, "WHERE lower(a.artist) IN (lower($2), lower($3)) AND a.price_cents >= $4 AND a.price_cents <= $5 AND a.release_year IN ($6) AND a.status IN ('published')\n")
+	assert.Equal(t, args, facetArgs)
+}
+
+func TestGetAllAlbums_ByIDs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("One page holds every requested album", func(t *testing.T) {
+		mock.ExpectQuery(`FROM albums a WHERE a.id IN \(\$1, \$2, \$3\) AND a.status IN \('published'\) ORDER BY a.id ASC LIMIT \$4 OFFSET \$5`).
+			WithArgs(3, 1, 2, listing.MaxLimit, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "attributes"}).
+				AddRow(1, "Kind of Blue", "Miles Davis", 1999, 1959, "Jazz", "", "", "", 1, 0, 0, nil).
+				AddRow(3, "Blue Train", "John Coltrane", 2499, 1957, "Jazz", "", "", "", 1, 0, 0, nil))
+
+		rr := get("/api/albums?ids=3,1&ids=2,3")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var albums []Album
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &albums))
+		assert.Len(t, albums, 2, "unknown IDs are left out")
+		assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Bad ids", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/albums?ids=1,abc").Code)
+
+		ids := make([]string, listing.MaxLimit+1)
+		for i := range ids {
+			ids[i] = strconv.Itoa(i + 1)
+		}
+		assert.Equal(t, http.StatusBadRequest, get("/api/albums?ids="+strings.Join(ids, ",")).Code)
+	})
+}
@@ -0,0 +1,314 @@
+// feeds.go - sitemap and shopping product feeds generated from the catalog on a schedule
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultFeedInterval = time.Hour
+	defaultFeedBaseURL  = "http://localhost:3000" // Storefront origin album pages are linked under
+	defaultFeedCurrency = "USD"
+	maxSitemapURLs      = 50000 // Limit of a single sitemap file
+)
+
+// feedAlbum is one catalog entry as listed in the feeds
+type feedAlbum struct {
+	ID           string
+	Title        string
+	Artist       string
+	Price        Cents
+	ReleaseYear  int
+	Genre        string
+	Format       string
+	Availability string // availabilityValues from facets.go
+}
+
+// catalogFeed holds the most recently generated feed documents. Requests are served from memory,
+// so crawlers never cause catalog queries.
+type catalogFeed struct {
+	mu          sync.RWMutex
+	sitemap     []byte
+	productsXML []byte
+	productsCSV []byte
+	generatedAt time.Time
+}
+
+var catalogFeeds = &catalogFeed{}
+
+// feedConfig controls links and prices in the generated documents
+type feedConfig struct {
+	BaseURL  string
+	Currency string
+}
+
+func feedConfigFromEnv() feedConfig {
+	cfg := feedConfig{BaseURL: defaultFeedBaseURL, Currency: defaultFeedCurrency}
+	if v := os.Getenv("FEED_BASE_URL"); v != "" {
+		cfg.BaseURL = strings.TrimRight(v, "/")
+	}
+	if v := os.Getenv("FEED_CURRENCY"); v != "" {
+		cfg.Currency = v
+	}
+	return cfg
+}
+
+// albumURL is the storefront page of an album
+func (cfg feedConfig) albumURL(id string) string {
+	return cfg.BaseURL + "/albums/" + id
+}
+
+// loadFeedAlbums reads every album with its availability. Albums without an inventory record are
+// listed as out of stock so ads never promise stock that isn't tracked.
+func loadFeedAlbums(ctx context.Context, db *sql.DB) ([]feedAlbum, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT a.id, a.title, a.artist, a.price_cents, a.release_year, a.genre, COALESCE(a.format, ''), "+availabilityExpr+
+			" FROM albums a "+availabilityJoin+" WHERE a.status = '"+albumPublished+"' ORDER BY a.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var albums []feedAlbum
+	for rows.Next() {
+		var a feedAlbum
+		var id int
+		if err := rows.Scan(&id, &a.Title, &a.Artist, &a.Price, &a.ReleaseYear, &a.Genre, &a.Format, &a.Availability); err != nil {
+			return nil, err
+		}
+		a.ID = strconv.Itoa(id)
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+// merchantAvailability maps catalog availability to the product feed vocabulary
+func merchantAvailability(availability string) string {
+	if availability == "in_stock" {
+		return "in_stock"
+	}
+	return "out_of_stock"
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// renderSitemap lists the album pages, up to the single-file limit
+func renderSitemap(albums []feedAlbum, cfg feedConfig) ([]byte, error) {
+	if len(albums) > maxSitemapURLs {
+		log.Printf("Sitemap truncated to %d of %d albums", maxSitemapURLs, len(albums))
+		albums = albums[:maxSitemapURLs]
+	}
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, a := range albums {
+		set.URLs = append(set.URLs, sitemapURL{Loc: cfg.albumURL(publicIDs.encode(a.ID))})
+	}
+	return marshalXMLDocument(set)
+}
+
+// productFeed is an RSS 2.0 document with Google Merchant Center "g:" product attributes
+type productFeed struct {
+	XMLName xml.Name           `xml:"rss"`
+	Version string             `xml:"version,attr"`
+	XmlnsG  string             `xml:"xmlns:g,attr"`
+	Channel productFeedChannel `xml:"channel"`
+}
+
+type productFeedChannel struct {
+	Title string            `xml:"title"`
+	Link  string            `xml:"link"`
+	Items []productFeedItem `xml:"item"`
+}
+
+type productFeedItem struct {
+	ID           string `xml:"g:id"`
+	Title        string `xml:"g:title"`
+	Description  string `xml:"g:description"`
+	Link         string `xml:"g:link"`
+	Price        string `xml:"g:price"`
+	Availability string `xml:"g:availability"`
+	Condition    string `xml:"g:condition"`
+	Brand        string `xml:"g:brand"`
+}
+
+// productFeedItems maps albums to feed items, shared by the XML and CSV renderings
+func productFeedItems(albums []feedAlbum, cfg feedConfig) []productFeedItem {
+	items := make([]productFeedItem, 0, len(albums))
+	for _, a := range albums {
+		description := fmt.Sprintf("%s by %s (%d), %s", a.Title, a.Artist, a.ReleaseYear, a.Genre)
+		if a.Format != "" {
+			description += ", " + a.Format
+		}
+		id := publicIDs.encode(a.ID)
+		items = append(items, productFeedItem{
+			ID:           id,
+			Title:        a.Title + " - " + a.Artist,
+			Description:  description,
+			Link:         cfg.albumURL(id),
+			Price:        a.Price.String() + " " + cfg.Currency,
+			Availability: merchantAvailability(a.Availability),
+			Condition:    "new",
+			Brand:        a.Artist,
+		})
+	}
+	return items
+}
+
+func renderProductFeedXML(albums []feedAlbum, cfg feedConfig) ([]byte, error) {
+	return marshalXMLDocument(productFeed{
+		Version: "2.0",
+		XmlnsG:  "http://base.google.com/ns/1.0",
+		Channel: productFeedChannel{Title: "Album catalog", Link: cfg.BaseURL, Items: productFeedItems(albums, cfg)},
+	})
+}
+
+func renderProductFeedCSV(albums []feedAlbum, cfg feedConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"id", "title", "description", "link", "price", "availability", "condition", "brand"})
+	for _, item := range productFeedItems(albums, cfg) {
+		w.Write([]string{item.ID, item.Title, item.Description, item.Link, item.Price, item.Availability, item.Condition, item.Brand})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func marshalXMLDocument(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// regenerate rebuilds every feed document from the catalog. On failure the previous documents
+// keep being served.
+func (f *catalogFeed) regenerate(ctx context.Context, db *sql.DB, cfg feedConfig) error {
+	albums, err := loadFeedAlbums(ctx, db)
+	if err != nil {
+		return fmt.Errorf("load catalog: %w", err)
+	}
+	sitemap, err := renderSitemap(albums, cfg)
+	if err != nil {
+		return fmt.Errorf("render sitemap: %w", err)
+	}
+	productsXML, err := renderProductFeedXML(albums, cfg)
+	if err != nil {
+		return fmt.Errorf("render product feed XML: %w", err)
+	}
+	productsCSV, err := renderProductFeedCSV(albums, cfg)
+	if err != nil {
+		return fmt.Errorf("render product feed CSV: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sitemap, f.productsXML, f.productsCSV = sitemap, productsXML, productsCSV
+	f.generatedAt = time.Now()
+	return nil
+}
+
+// startFeedGeneration generates the feeds immediately and then on every FEED_REGENERATE_INTERVAL tick
+func startFeedGeneration() {
+	interval := defaultFeedInterval
+	if v := os.Getenv("FEED_REGENERATE_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid FEED_REGENERATE_INTERVAL %q, using default %s", v, defaultFeedInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	cfg := feedConfigFromEnv()
+	log.Printf("Catalog feeds for %s regenerated every %s", cfg.BaseURL, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runFeedGeneration(cfg)
+			<-ticker.C
+		}
+	}()
+}
+
+// runFeedGeneration runs one traced regeneration pass
+func runFeedGeneration(cfg feedConfig) {
+	ctx, span := tracer.Start(context.Background(), "job.generate_catalog_feeds")
+	defer span.End()
+
+	start := time.Now()
+	if err := catalogFeeds.regenerate(ctx, db, cfg); err != nil {
+		log.Printf("Catalog feed generation failed: %v", err)
+		span.RecordError(err)
+		return
+	}
+	log.Printf("Catalog feeds generated in %s", time.Since(start))
+}
+
+// serveFeed returns a handler serving one of the cached documents; 503 until the first generation
+// has finished
+func serveFeed(contentType string, document func(f *catalogFeed) []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		catalogFeeds.mu.RLock()
+		body, generatedAt := document(catalogFeeds), catalogFeeds.generatedAt
+		catalogFeeds.mu.RUnlock()
+
+		if body == nil {
+			c.Header("Retry-After", "60")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Feed not generated yet"})
+			return
+		}
+		c.Header("Last-Modified", generatedAt.UTC().Format(http.TimeFormat))
+		c.Data(http.StatusOK, contentType, body)
+	}
+}
+
+// registerFeeds registers the feed routes. The product feeds are for shopping partners and accept
+// their API keys (see api_keys.go); the sitemap stays open to crawlers.
+func registerFeeds(router gin.IRoutes, wrap func(gin.HandlerFunc, string) gin.HandlerFunc) {
+	partnerKey := requireAPIKeyScope(scopeFeedsRead, func() bool { return feedRequireAPIKey })
+	router.GET("/sitemap.xml", withCachePolicy(cacheFeed), wrap(getSitemap, "getSitemap"))
+	router.GET("/feeds/products.xml", withProductFeedCachePolicy(), partnerKey, wrap(getProductFeedXML, "getProductFeedXML"))
+	router.GET("/feeds/products.csv", withProductFeedCachePolicy(), partnerKey, wrap(getProductFeedCSV, "getProductFeedCSV"))
+}
+
+// withProductFeedCachePolicy keeps the product feeds out of shared caches while they require an API key
+func withProductFeedCachePolicy() gin.HandlerFunc {
+	public, private := withCachePolicy(cacheFeed), withCachePolicy(cachePartnerFeed)
+	return func(c *gin.Context) {
+		if feedRequireAPIKey {
+			private(c)
+			return
+		}
+		public(c)
+	}
+}
+
+var (
+	getSitemap        = serveFeed("application/xml; charset=utf-8", func(f *catalogFeed) []byte { return f.sitemap })
+	getProductFeedXML = serveFeed("application/xml; charset=utf-8", func(f *catalogFeed) []byte { return f.productsXML })
+	getProductFeedCSV = serveFeed("text/csv; charset=utf-8", func(f *catalogFeed) []byte { return f.productsCSV })
+)
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFeedConfig = feedConfig{BaseURL: "https://shop.example", Currency: "EUR"}
+
+var testFeedAlbums = []feedAlbum{
+	{ID: "1", Title: "Nevermind", Artist: "Nirvana", Price: 1999, ReleaseYear: 1991, Genre: "Rock", Format: "LP", Availability: "in_stock"},
+	{ID: "2", Title: "Kind of Blue", Artist: "Miles Davis & Co", Price: 2450, ReleaseYear: 1959, Genre: "Jazz", Availability: "unknown"},
+}
+
+func TestRenderSitemap(t *testing.T) {
+	out, err := renderSitemap(testFeedAlbums, testFeedConfig)
+	require.NoError(t, err)
+	doc := string(out)
+	assert.True(t, strings.HasPrefix(doc, "<?xml"))
+	assert.Contains(t, doc, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	assert.Contains(t, doc, "<loc>https://shop.example/albums/1</loc>")
+	assert.Contains(t, doc, "<loc>https://shop.example/albums/2</loc>")
+}
+
+func TestRenderProductFeeds(t *testing.T) {
+	xmlOut, err := renderProductFeedXML(testFeedAlbums, testFeedConfig)
+	require.NoError(t, err)
+	doc := string(xmlOut)
+	assert.Contains(t, doc, `<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0">`)
+	assert.Contains(t, doc, "<g:price>19.99 EUR</g:price>")
+	assert.Contains(t, doc, "<g:availability>in_stock</g:availability>")
+	assert.Contains(t, doc, "<g:availability>out_of_stock</g:availability>", "unknown stock is never advertised")
+	assert.Contains(t, doc, "<g:brand>Miles Davis &amp; Co</g:brand>", "text is escaped")
+
+	csvOut, err := renderProductFeedCSV(testFeedAlbums, testFeedConfig)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csvOut)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id,title,description,link,price,availability,condition,brand", lines[0])
+	assert.Equal(t, `1,Nevermind - Nirvana,"Nevermind by Nirvana (1991), Rock, LP",https://shop.example/albums/1,19.99 EUR,in_stock,new,Nirvana`, lines[1])
+}
+
+func TestServeFeeds(t *testing.T) {
+	original := catalogFeeds
+	catalogFeeds = &catalogFeed{}
+	t.Cleanup(func() { catalogFeeds = original })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	rr := get("/sitemap.xml")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "nothing is served before the first generation")
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	mock.ExpectQuery("SELECT a.id, a.title").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "availability"}).
+			AddRow(1, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "in_stock"))
+	require.NoError(t, catalogFeeds.regenerate(context.Background(), mockDB, testFeedConfig))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	for path, contentType := range map[string]string{
+		"/sitemap.xml":        "application/xml; charset=utf-8",
+		"/feeds/products.xml": "application/xml; charset=utf-8",
+		"/feeds/products.csv": "text/csv; charset=utf-8",
+	} {
+		rr := get(path)
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.Equal(t, contentType, rr.Header().Get("Content-Type"), path)
+		assert.Equal(t, "public, max-age=300", rr.Header().Get("Cache-Control"), path)
+		assert.NotEmpty(t, rr.Header().Get("ETag"), path)
+		assert.NotEmpty(t, rr.Header().Get("Last-Modified"), path)
+		assert.Contains(t, rr.Body.String(), "albums/1", path)
+	}
+
+	// A failed regeneration keeps serving the previous documents
+	mock.ExpectQuery("SELECT a.id, a.title").WillReturnError(context.DeadlineExceeded)
+	assert.Error(t, catalogFeeds.regenerate(context.Background(), mockDB, testFeedConfig))
+	assert.Equal(t, http.StatusOK, get("/sitemap.xml").Code)
+}
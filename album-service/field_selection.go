@@ -0,0 +1,141 @@
+// field_selection.go - sparse fieldsets. GET responses written with respondJSON can be narrowed to
+// some of their fields with ?fields=id,title,price, so clients don't download fields they never
+// render. The selection applies to the top-level object, or to each object of a top-level array, and
+// keeps the fields in their usual order. Fields are named as in the response type, in either JSON
+// naming (see album-store/jsonnaming).
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"album-store/jsonnaming"
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// alwaysSelectedFields are kept whatever ?fields= says: the ID, so clients can tell the objects
+// apart, and the meta describing the response (see includes.go)
+var alwaysSelectedFields = []string{"id", "meta"}
+
+// selectedFields returns the fields the request selects from obj, nil when it doesn't select any.
+// Only GET requests select fields, so a mistake in ?fields= never fails a write after it's done.
+// The entities named in ?include= are kept too.
+func selectedFields(c *gin.Context, obj interface{}) (map[string]bool, error) {
+	requested := listing.QueryValues(c, "fields")
+	if len(requested) == 0 || c.Request.Method != http.MethodGet {
+		return nil, nil
+	}
+	known := jsonFieldNames(reflect.TypeOf(obj))
+	keep := make(map[string]bool, len(requested))
+	for _, name := range requested {
+		field := jsonnaming.CamelCaseKey(name)
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %q in fields", name)
+		}
+		keep[field] = true
+	}
+	for _, name := range append(alwaysSelectedFields, listing.QueryValues(c, "include")...) {
+		keep[name] = true
+	}
+	return keep, nil
+}
+
+// jsonFieldNames returns the JSON names of the fields of t, the element type for slices and pointers.
+// Fields of embedded structs count as fields of t, as encoding/json flattens them.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	names := map[string]bool{}
+	if t == nil || t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			for name := range jsonFieldNames(field.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// selectJSONFields returns body, a JSON object or array of objects, with only the keys in keep
+func selectJSONFields(body []byte, keep map[string]bool) ([]byte, error) {
+	var out bytes.Buffer
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		out.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := selectObjectFields(&out, item, keep); err != nil {
+				return nil, err
+			}
+		}
+		out.WriteByte(']')
+		return out.Bytes(), nil
+	}
+	if err := selectObjectFields(&out, body, keep); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// selectObjectFields writes the object in raw with only the keys in keep, in their original order.
+// Values that aren't objects are written unchanged.
+func selectObjectFields(out *bytes.Buffer, raw json.RawMessage, keep map[string]bool) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		out.Write(raw)
+		return err
+	}
+	out.WriteByte('{')
+	first := true
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		key := tok.(string)
+		if !keep[key] {
+			continue
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		out.Write(name)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return nil
+}
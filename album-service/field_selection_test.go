@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectJSONFields(t *testing.T) {
+	keep := map[string]bool{"id": true, "price": true, "tracks": true}
+
+	out, err := selectJSONFields([]byte(`{"id":"4","title":"Nevermind","price":19.99,"tracks":[{"title":"Polly"}]}`), keep)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"4","price":19.99,"tracks":[{"title":"Polly"}]}`, string(out), "nested objects are kept whole")
+
+	out, err = selectJSONFields([]byte(`[{"title":"Bleach","id":"3"},{"id":"4","price":19.990}]`), keep)
+	require.NoError(t, err)
+	assert.Equal(t, `[{"id":"3"},{"id":"4","price":19.990}]`, string(out))
+
+	_, err = selectJSONFields([]byte(`{"id":`), keep)
+	assert.Error(t, err)
+}
+
+func TestJSONFieldNames(t *testing.T) {
+	names := jsonFieldNames(reflect.TypeOf([]AlbumDetail{}))
+	for _, name := range []string{"id", "releaseYear", "catalogNumber", "reviews", "meta"} {
+		assert.True(t, names[name], name)
+	}
+	assert.False(t, names["Album"], "embedded fields are flattened")
+	assert.Empty(t, jsonFieldNames(reflect.TypeOf(map[string]int{})))
+}
+
+func TestFieldSelection(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	expectAlbum := func() {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 4.5, 2, "", "", nil, "published", nil))
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Only the selected fields and the ID", func(t *testing.T) {
+		expectAlbum()
+		rr := get("/api/albums/4?fields=title,price")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"id":"4","title":"Nevermind","price":19.99}`, rr.Body.String())
+		assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("snake_case names", func(t *testing.T) {
+		expectAlbum()
+		rr := get("/api/albums/4?fields=release_year&naming=snake_case")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"id":"4","release_year":1991}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admin-only fields stay hidden", func(t *testing.T) {
+		expectAlbum()
+		rr := get("/api/albums/4?fields=title,status")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"id":"4","title":"Nevermind"}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown fields", func(t *testing.T) {
+		expectAlbum()
+		rr := get("/api/albums/4?fields=title,lyrics")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `unknown field \"lyrics\"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,193 @@
+// genres.go - genre taxonomy. Album genres must be one of the genres in the genres table and are
+// stored with its spelling, so "Rock", "rock" and "ROCK" can't coexist. Admins add genres with
+// POST /api/genres.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultGenreRefreshInterval = time.Minute
+
+// defaultGenres seed an empty taxonomy, so a fresh installation can create albums right away. It
+// includes the genre Discogs imports fall back to.
+var defaultGenres = []string{"Blues", "Classical", "Country", "Electronic", "Folk", "Hip-Hop", "Jazz",
+	"Metal", "Pop", "Punk", "R&B", "Reggae", "Rock", "Soul", defaultImportGenre}
+
+// Genre is one taxonomy entry with the number of albums in it
+type Genre struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	AlbumCount int    `json:"albumCount"`
+}
+
+// GenreInput is the body of POST /api/genres
+type GenreInput struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+// genreTaxonomy caches genre names by lower-case key for validating album writes. It is reloaded
+// periodically so genres added on other instances are picked up.
+type genreTaxonomy struct {
+	mu     sync.RWMutex
+	loaded bool
+	names  map[string]string // lower(name) -> name
+}
+
+var genres = &genreTaxonomy{}
+
+// canonical returns the taxonomy spelling of a genre. Until the taxonomy is loaded every genre is
+// accepted as given.
+func (g *genreTaxonomy) canonical(genre string) (string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if !g.loaded {
+		return genre, true
+	}
+	name, ok := g.names[strings.ToLower(strings.TrimSpace(genre))]
+	return name, ok
+}
+
+func (g *genreTaxonomy) set(names []string) {
+	byKey := make(map[string]string, len(names))
+	for _, name := range names {
+		byKey[strings.ToLower(name)] = name
+	}
+	g.mu.Lock()
+	g.names, g.loaded = byKey, true
+	g.mu.Unlock()
+}
+
+func (g *genreTaxonomy) add(name string) {
+	g.mu.Lock()
+	if g.names == nil {
+		g.names = make(map[string]string)
+	}
+	g.names[strings.ToLower(name)] = name
+	g.mu.Unlock()
+}
+
+// normalizeGenre replaces an album genre with its taxonomy spelling; an error when it isn't in the taxonomy
+func normalizeGenre(genre *string) error {
+	name, ok := genres.canonical(*genre)
+	if !ok {
+		return fmt.Errorf("unknown genre %q; see GET /api/genres, admins can add genres with POST /api/genres", *genre)
+	}
+	*genre = name
+	return nil
+}
+
+// loadGenres reads the taxonomy into the cache
+func loadGenres(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM genres")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	genres.set(names)
+	return nil
+}
+
+// startGenreRefresh loads the taxonomy, then reloads it every GENRE_REFRESH_INTERVAL
+func startGenreRefresh() {
+	if err := loadGenres(context.Background(), db); err != nil {
+		log.Fatalf("Could not load genres: %v", err)
+	}
+
+	interval := defaultGenreRefreshInterval
+	if v := os.Getenv("GENRE_REFRESH_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid GENRE_REFRESH_INTERVAL %q, using default %s", v, defaultGenreRefreshInterval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := loadGenres(context.Background(), db); err != nil {
+				log.Printf("Genre refresh failed: %v", err)
+			}
+		}
+	}()
+}
+
+// getGenres handles GET /api/genres
+func getGenres(c *gin.Context) {
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT g.id, g.name, COUNT(a.id) FROM genres g LEFT JOIN albums a ON a.genre = g.name
+		GROUP BY g.id, g.name ORDER BY lower(g.name)`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query genres: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	list := []Genre{}
+	for rows.Next() {
+		var g Genre
+		var id int
+		if err := rows.Scan(&id, &g.Name, &g.AlbumCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read genres: " + err.Error()})
+			return
+		}
+		g.ID = strconv.Itoa(id)
+		list = append(list, g)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read genres: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// createGenre handles POST /api/genres (admin)
+func createGenre(c *gin.Context) {
+	var in GenreInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	g := Genre{Name: strings.TrimSpace(in.Name)}
+	var id int
+	err := db.QueryRowContext(c.Request.Context(),
+		"INSERT INTO genres (name) VALUES ($1) ON CONFLICT ((lower(name))) DO NOTHING RETURNING id", g.Name).Scan(&id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Genre already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create genre: " + err.Error()})
+		return
+	}
+	g.ID = strconv.Itoa(id)
+	genres.add(g.Name) // Usable on this instance right away, on others after their next refresh
+	c.JSON(http.StatusCreated, g)
+}
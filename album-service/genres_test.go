@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withGenres loads a taxonomy for one test; tests otherwise run with none loaded, which accepts any genre
+func withGenres(t *testing.T, names ...string) {
+	original := genres
+	genres = &genreTaxonomy{}
+	genres.set(names)
+	t.Cleanup(func() { genres = original })
+}
+
+func TestNormalizeGenre(t *testing.T) {
+	withGenres(t, "Rock", "Hip-Hop")
+
+	genre := " rock"
+	require.NoError(t, normalizeGenre(&genre))
+	assert.Equal(t, "Rock", genre)
+
+	genre = "HIP-HOP"
+	require.NoError(t, normalizeGenre(&genre))
+	assert.Equal(t, "Hip-Hop", genre)
+
+	genre = "Rokc"
+	assert.ErrorContains(t, normalizeGenre(&genre), `unknown genre "Rokc"`)
+
+	items, _ := buildImportItems([]discogsRelease{
+		{Title: "Nevermind", Artist: "Nirvana", Released: "1991", Genre: "ROCK"},
+		{Title: "Illmatic", Artist: "Nas", Released: "1994", Genre: "Rap"},
+	}, 1999, "Rock", nil)
+	assert.Equal(t, "Rock", items[0].Genre)
+	assert.Equal(t, importStatusNew, items[0].Status)
+	assert.Equal(t, importStatusInvalid, items[1].Status)
+	assert.Equal(t, "unknown genre", items[1].Reason)
+}
+
+func TestGenreHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+	withGenres(t, "Rock")
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("FROM genres g LEFT JOIN albums").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).AddRow(1, "Jazz", 0).AddRow(2, "Rock", 12))
+
+		rr := send("GET", "/api/genres", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var list []Genre
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		assert.Equal(t, []Genre{{ID: "1", Name: "Jazz"}, {ID: "2", Name: "Rock", AlbumCount: 12}}, list)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Album with an unknown genre is rejected", func(t *testing.T) {
+		rr := send("POST", "/api/albums", `{"title":"Kind of Blue","artist":"Miles Davis","price":19.99,"releaseYear":1959,"genre":"Jazz"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "unknown genre")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO genres").WithArgs("Jazz").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+		rr := send("POST", "/api/genres", `{"name":" Jazz "}`)
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"name":"Jazz"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		genre := "jazz"
+		require.NoError(t, normalizeGenre(&genre), "a new genre is usable right away")
+		assert.Equal(t, "Jazz", genre)
+	})
+
+	t.Run("Create duplicate", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO genres").WithArgs("ROCK").WillReturnError(sql.ErrNoRows)
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/genres", `{"name":"ROCK"}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create needs admin", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/genres", bytes.NewBufferString(`{"name":"Ska"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
@@ -0,0 +1,262 @@
+// graphql.go - POST /graphql, a GraphQL view of the catalog (schema.graphql). An album's inventory
+// field is read from inventory-service's table like the inventory include (see includes.go), so a
+// product page gets the album and its stock in one query. Albums are looked up by their public ID
+// (see public_ids.go):
+//
+//	{ album(id: "JgaEBg") { title price inventory { availability quantityAvailable } } }
+//
+// Visibility and admin-only fields follow the REST API: public callers only see published albums,
+// and inventory quantities are null for them.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+)
+
+//go:embed schema.graphql
+var graphQLSchemaSDL string
+
+var (
+	errGraphQLPage          = fmt.Errorf("limit must be between 1 and %d and offset must not be negative", listing.MaxLimit)
+	errCatalogUnavailable   = errors.New("the catalog is unavailable")
+	errInventoryUnavailable = errors.New("inventory is unavailable")
+)
+
+// maxGraphQLDepth limits the nesting of queries; the schema is 3 levels deep
+const maxGraphQLDepth = 5
+
+var graphQLSchema = graphql.MustParseSchema(graphQLSchemaSDL, &graphQLResolver{},
+	graphql.UseStringDescriptions(), graphql.MaxDepth(maxGraphQLDepth))
+
+// graphQLRequest is the body of POST /graphql
+type graphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLCallerKey is the context key of the request's gin context, which resolvers need for the
+// caller's profile
+type graphQLCallerKey struct{}
+
+// serveGraphQL handles POST /graphql. Query errors are reported in the response's errors, with the
+// data that could be resolved, as GraphQL clients expect.
+func serveGraphQL(c *gin.Context) {
+	var req graphQLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if limit, ok := bodyLimitExceeded(err); ok {
+			respondBodyTooLarge(c, limit)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	ctx := context.WithValue(c.Request.Context(), graphQLCallerKey{}, c)
+	c.JSON(http.StatusOK, graphQLSchema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// graphQLCaller returns the gin context of the request a resolver runs for
+func graphQLCaller(ctx context.Context) *gin.Context {
+	return ctx.Value(graphQLCallerKey{}).(*gin.Context)
+}
+
+// graphQLResolver resolves the Query type
+type graphQLResolver struct{}
+
+// Album resolves Query.album
+func (*graphQLResolver) Album(ctx context.Context, args struct{ ID graphql.ID }) (*albumResolver, error) {
+	id, ok := publicIDs.decode(string(args.ID))
+	if !ok {
+		return nil, nil
+	}
+	a, err := findAlbum(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && !albumVisible(graphQLCaller(ctx), a)) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("GraphQL lookup of album %s failed: %v", id, err)
+		return nil, errCatalogUnavailable
+	}
+	dbID, _ := strconv.Atoi(a.ID)
+	return &albumResolver{a: a, inventory: newInventoryBatch([]int{dbID})}, nil
+}
+
+// albumsArgs are the arguments of Query.albums
+type albumsArgs struct {
+	IDs    *[]graphql.ID
+	Genre  *[]string
+	Artist *[]string
+	Limit  int32
+	Offset int32
+}
+
+// Albums resolves Query.albums with the catalog filters of GET /api/albums
+func (*graphQLResolver) Albums(ctx context.Context, args albumsArgs) ([]*albumResolver, error) {
+	if args.Limit < 1 || args.Limit > listing.MaxLimit || args.Offset < 0 {
+		return nil, errGraphQLPage
+	}
+	filter := albumFilter{Statuses: []string{albumPublished}}
+	if seesAllAlbums(graphQLCaller(ctx)) {
+		filter.Statuses = nil
+	}
+	if args.Genre != nil {
+		filter.Genres = *args.Genre
+	}
+	if args.Artist != nil {
+		filter.Artists = *args.Artist
+	}
+	if args.IDs != nil {
+		for _, public := range *args.IDs {
+			if internal, ok := publicIDs.decode(string(public)); ok {
+				if id, err := strconv.Atoi(internal); err == nil {
+					filter.IDs = append(filter.IDs, id)
+				}
+			}
+		}
+		if len(filter.IDs) == 0 {
+			filter.IDs = []int{0} // As with ?ids=, IDs that aren't album IDs match nothing
+		}
+	}
+
+	page := listing.Params{Limit: int(args.Limit), Offset: int(args.Offset), Sort: albumListSpec.DefaultSort, Unique: albumListSpec.Unique}
+	albums, _, err := queryAlbums(ctx, filter, page)
+	if err != nil {
+		log.Printf("GraphQL album list failed: %v", err)
+		return nil, errCatalogUnavailable
+	}
+	ids := make([]int, len(albums))
+	for i, a := range albums {
+		ids[i], _ = strconv.Atoi(a.ID)
+	}
+	// The inventory of the whole page is read at once, when the query first asks for one
+	batch := newInventoryBatch(ids)
+	resolvers := make([]*albumResolver, len(albums))
+	for i, a := range albums {
+		resolvers[i] = &albumResolver{a: a, inventory: batch}
+	}
+	return resolvers, nil
+}
+
+// inventoryBatch reads the inventory of a set of albums with one lookup, the first time one of them
+// is resolved
+type inventoryBatch struct {
+	ids      []int
+	once     sync.Once
+	statuses map[int]*InventoryStatus
+	err      error
+}
+
+func newInventoryBatch(ids []int) *inventoryBatch {
+	return &inventoryBatch{ids: ids}
+}
+
+// get returns the album's inventory, limited by the include timeout
+func (b *inventoryBatch) get(ctx context.Context, albumID int) (*InventoryStatus, error) {
+	b.once.Do(func() {
+		ctx, cancel := context.WithTimeout(ctx, includeTimeout)
+		defer cancel()
+		if b.statuses, b.err = inventoryStatuses(ctx, b.ids); b.err != nil {
+			log.Printf("GraphQL inventory lookup of %d albums failed: %v", len(b.ids), b.err)
+		}
+	})
+	if b.err != nil {
+		return nil, errInventoryUnavailable
+	}
+	return b.statuses[albumID], nil
+}
+
+// albumResolver resolves the Album type
+type albumResolver struct {
+	a         Album
+	inventory *inventoryBatch
+}
+
+func (r *albumResolver) ID() graphql.ID         { return graphql.ID(publicIDs.encode(r.a.ID)) }
+func (r *albumResolver) Title() string          { return r.a.Title }
+func (r *albumResolver) Artist() string         { return r.a.Artist }
+func (r *albumResolver) Price() float64         { return float64(r.a.Price) / 100 }
+func (r *albumResolver) ReleaseYear() int32     { return int32(r.a.ReleaseYear) }
+func (r *albumResolver) Genre() string          { return r.a.Genre }
+func (r *albumResolver) Format() *string        { return optionalString(r.a.Format) }
+func (r *albumResolver) UPC() *string           { return optionalString(r.a.UPC) }
+func (r *albumResolver) CatalogNumber() *string { return optionalString(r.a.CatalogNumber) }
+func (r *albumResolver) ReleaseDate() *string   { return optionalString(r.a.ReleaseDate) }
+func (r *albumResolver) Label() *string         { return optionalString(r.a.Label) }
+func (r *albumResolver) Version() int32         { return int32(r.a.Version) }
+func (r *albumResolver) AverageRating() float64 { return r.a.AverageRating }
+func (r *albumResolver) ReviewCount() int32     { return int32(r.a.ReviewCount) }
+
+func (r *albumResolver) Tracks() []*trackResolver {
+	tracks := make([]*trackResolver, len(r.a.Tracks))
+	for i := range r.a.Tracks {
+		tracks[i] = &trackResolver{r.a.Tracks[i]}
+	}
+	return tracks
+}
+
+func (r *albumResolver) Inventory(ctx context.Context) (*inventoryResolver, error) {
+	id, _ := strconv.Atoi(r.a.ID)
+	s, err := r.inventory.get(ctx, id)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return &inventoryResolver{s: s, admin: responseProfile(graphQLCaller(ctx)) == profileAdmin}, nil
+}
+
+// trackResolver resolves the Track type
+type trackResolver struct{ t Track }
+
+func (r *trackResolver) Position() int32 { return int32(r.t.Position) }
+func (r *trackResolver) Title() string   { return r.t.Title }
+func (r *trackResolver) DurationSeconds() *int32 {
+	if r.t.DurationSeconds == 0 {
+		return nil
+	}
+	d := int32(r.t.DurationSeconds)
+	return &d
+}
+
+// inventoryResolver resolves the Inventory type; quantities are for admins only
+type inventoryResolver struct {
+	s     *InventoryStatus
+	admin bool
+}
+
+func (r *inventoryResolver) Availability() string { return r.s.Availability }
+
+func (r *inventoryResolver) QuantityAvailable() *int32 {
+	if !r.admin || r.s.QuantityAvailable == nil {
+		return nil
+	}
+	q := int32(*r.s.QuantityAvailable)
+	return &q
+}
+
+func (r *inventoryResolver) LastUpdated() *string {
+	if !r.admin || r.s.LastUpdated == nil {
+		return nil
+	}
+	t := r.s.LastUpdated.Format(time.RFC3339)
+	return &t
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
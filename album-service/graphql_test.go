@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeGraphQL(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	query := func(body string, admin bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if admin {
+			req.Header.Set("Client-Type", "admin")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectAlbum := func(status string) {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 4.5, 2, "1991-09-24", "DGC", []byte(`[{"position":1,"title":"Smells Like Teen Spirit","durationSeconds":301}]`), status, nil))
+	}
+	albumQuery := `{"query":"query($id: ID!) { album(id: $id) { id title price releaseDate tracks { title durationSeconds } inventory { availability quantityAvailable } } }","variables":{"id":"4"}}`
+
+	t.Run("An album with its inventory", func(t *testing.T) {
+		expectAlbum(albumPublished)
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated"}).AddRow("4", 3, time.Now()))
+
+		rr := query(albumQuery, false)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"data":{"album":{"id":"4","title":"Nevermind","price":19.99,"releaseDate":"1991-09-24",
+			"tracks":[{"title":"Smells Like Teen Spirit","durationSeconds":301}],
+			"inventory":{"availability":"in_stock","quantityAvailable":null}}}}`, rr.Body.String(), "quantities are for admins")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admins see the quantity", func(t *testing.T) {
+		expectAlbum(albumDraft)
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated"}).AddRow("4", 0, time.Now()))
+
+		rr := query(albumQuery, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"inventory":{"availability":"out_of_stock","quantityAvailable":0}`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Albums the caller can't see are null", func(t *testing.T) {
+		expectAlbum(albumDraft)
+
+		rr := query(albumQuery, false)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"data":{"album":null}}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A list reads the inventory of its albums at once", func(t *testing.T) {
+		mock.ExpectQuery("SELECT a.id, a.title, .* FROM albums a WHERE a.status IN \\('published'\\) AND a.genre IN \\(\\$1\\)").
+			WithArgs("Rock", 50, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 4.5, 2, nil).
+				AddRow(7, "In Utero", "Nirvana", 1799, 1993, "Rock", "CD", "", "", 1, 0.0, 0, nil))
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1, \\$2\\)").WithArgs("4", "7").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated"}).AddRow("4", 3, time.Now()))
+
+		rr := query(`{"query":"{ albums(genre: [\"Rock\"]) { title inventory { availability } } }"}`, false)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"data":{"albums":[
+			{"title":"Nevermind","inventory":{"availability":"in_stock"}},
+			{"title":"In Utero","inventory":{"availability":"unknown"}}]}}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Errors are reported in the response", func(t *testing.T) {
+		rr := query(`{"query":"{ albums(limit: 500) { title } }"}`, false)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp struct {
+			Errors []struct{ Message string }
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, errGraphQLPage.Error(), resp.Errors[0].Message)
+
+		rr = query(`{"query":"{ album(id: \"4\") { title inventory { quantity } } }"}`, false)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `Cannot query field \"quantity\" on type \"Inventory\"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		rr := query(`{"variables":{}}`, false)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,65 @@
+// health.go - liveness and readiness probes. /healthz only says that the process serves requests;
+// an orchestrator restarts the instance when it fails. /readyz says whether the instance can do its
+// work: the database answers and the album event writers are connected. An instance that isn't ready
+// is taken out of load balancing without being restarted.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"album-store/kafkawriter"
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the database ping of /readyz
+const readinessTimeout = 2 * time.Second
+
+// registerHealthRoutes adds the probes. /health and /health/ready are the names they had before
+// /healthz and /readyz, kept for probes still configured with them.
+func registerHealthRoutes(router gin.IRoutes) {
+	router.GET("/healthz", getLiveness)
+	router.GET("/readyz", getReadiness)
+	router.GET("/health", getLiveness)
+	router.GET("/health/ready", getReadiness)
+}
+
+// getLiveness handles GET /healthz. It checks no dependency: restarting the instance doesn't fix an
+// unreachable database.
+func getLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// getReadiness handles GET /readyz: the database must answer a ping and the album event writers must
+// be connected. Returns 503 with per-dependency details otherwise.
+func getReadiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	dbStatus := "ok"
+	if err := db.PingContext(ctx); err != nil {
+		ready = false
+		dbStatus = "unavailable: " + err.Error()
+	}
+
+	kafkaStatuses := []kafkawriter.Status{}
+	for _, writer := range albumEventWriters() {
+		if w, ok := writer.(*kafkawriter.Writer); ok {
+			kafkaStatuses = append(kafkaStatuses, w.Status())
+			ready = ready && w.Ready()
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready":    ready,
+		"database": dbStatus,
+		"kafka":    kafkaStatuses,
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"album-store/kafkawriter"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthProbes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, kafkaWriter
+	db = mockDB
+	t.Cleanup(func() { db, kafkaWriter = originalDB, originalWriter })
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return rr, body
+	}
+
+	t.Run("Liveness checks no dependency", func(t *testing.T) {
+		for _, path := range []string{"/healthz", "/health"} {
+			rr, body := get(path)
+			assert.Equal(t, http.StatusOK, rr.Code, path)
+			assert.Equal(t, true, body["ok"], path)
+		}
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		kafkaWriter = &recordingWriter{}
+		for _, path := range []string{"/readyz", "/health/ready"} {
+			mock.ExpectPing()
+			rr, body := get(path)
+			assert.Equal(t, http.StatusOK, rr.Code, path)
+			assert.Equal(t, true, body["ready"], path)
+			assert.Equal(t, "ok", body["database"], path)
+		}
+	})
+
+	t.Run("Database unreachable", func(t *testing.T) {
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		rr, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, false, body["ready"])
+		assert.Equal(t, "unavailable: connection refused", body["database"])
+	})
+
+	t.Run("Kafka writer not connected", func(t *testing.T) {
+		kafkaWriter = kafkawriter.New("kafka:9092", "album-created", nil, nil)
+		mock.ExpectPing()
+		rr, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Len(t, body["kafka"], 1)
+		assert.Equal(t, kafkawriter.StateConnecting, body["kafka"].([]interface{})[0].(map[string]interface{})["state"])
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,260 @@
+// includes.go - related entities on the album detail endpoint. GET /api/albums/:id?include=reviews,inventory
+// returns the album with the named entities, so a product page needs one request instead of one per
+// entity. The lookups run concurrently, each with its own timeout. One that fails or times out is
+// left out and reported in meta, and the rest of the response is still returned.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultIncludeTimeout = 500 * time.Millisecond
+	includedReviews       = 3 // Newest reviews in the reviews include
+)
+
+// includeTimeout limits each include lookup, from ALBUM_INCLUDE_TIMEOUT
+var includeTimeout = defaultIncludeTimeout
+
+// AlbumDetail is an album with the entities named in ?include=
+type AlbumDetail struct {
+	Album
+	Reviews   *ReviewSummary   `json:"reviews,omitempty"`
+	Inventory *InventoryStatus `json:"inventory,omitempty"`
+	Meta      IncludeMeta      `json:"meta"`
+}
+
+// IncludeMeta reports which includes are in an AlbumDetail
+type IncludeMeta struct {
+	Included []string         `json:"included"`
+	Failed   []IncludeFailure `json:"failed,omitempty"`
+}
+
+// IncludeFailure is an include left out of the response
+type IncludeFailure struct {
+	Include string `json:"include"`
+	Reason  string `json:"reason"` // "timeout" or "error"
+}
+
+// ReviewSummary is the reviews include: the rating distribution and the newest reviews
+type ReviewSummary struct {
+	AverageRating float64        `json:"averageRating"`
+	Count         int            `json:"count"`
+	Ratings       map[string]int `json:"ratings"` // Reviews per star rating, "1" to "5"
+	Latest        []Review       `json:"latest"`
+}
+
+// InventoryStatus is the inventory include, read from inventory-service's table
+type InventoryStatus struct {
+	Availability      string     `json:"availability"` // in_stock, out_of_stock or unknown (no inventory record)
+	QuantityAvailable *int       `json:"quantityAvailable,omitempty" profile:"admin"`
+	LastUpdated       *time.Time `json:"lastUpdated,omitempty" profile:"admin"`
+}
+
+// albumInclude is one entity ?include= can name. load fills its part of the detail, and must return
+// when ctx is done.
+type albumInclude struct {
+	name string
+	load func(ctx context.Context, albumID int, d *AlbumDetail) error
+}
+
+var albumIncludes = []albumInclude{
+	// Tracks are stored with the album, so they need no lookup; naming them is allowed all the same
+	{"tracks", func(context.Context, int, *AlbumDetail) error { return nil }},
+	{"reviews", loadReviewSummary},
+	{"inventory", loadInventoryStatus},
+}
+
+// loadIncludeTimeout reads ALBUM_INCLUDE_TIMEOUT
+func loadIncludeTimeout() error {
+	v := os.Getenv("ALBUM_INCLUDE_TIMEOUT")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("ALBUM_INCLUDE_TIMEOUT: invalid duration %q", v)
+	}
+	includeTimeout = d
+	return nil
+}
+
+// parseIncludes reads ?include= (repeated or comma-separated), rejecting unknown names
+func parseIncludes(c *gin.Context) ([]albumInclude, error) {
+	var includes []albumInclude
+	for _, name := range listing.QueryValues(c, "include") {
+		found := false
+		for _, inc := range albumIncludes {
+			if inc.name == name {
+				found = true
+				if !containsInclude(includes, name) {
+					includes = append(includes, inc)
+				}
+			}
+		}
+		if !found {
+			names := make([]string, len(albumIncludes))
+			for i, inc := range albumIncludes {
+				names[i] = inc.name
+			}
+			return nil, fmt.Errorf("unknown include %q, expected any of %s", name, strings.Join(names, ", "))
+		}
+	}
+	return includes, nil
+}
+
+func containsInclude(includes []albumInclude, name string) bool {
+	for _, inc := range includes {
+		if inc.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withIncludes loads includes for a concurrently and returns the detail. Failures are logged and
+// reported in its meta.
+func withIncludes(ctx context.Context, a Album, includes []albumInclude) AlbumDetail {
+	d := AlbumDetail{Album: a, Meta: IncludeMeta{Included: []string{}}}
+	albumID, _ := strconv.Atoi(a.ID)
+
+	errs := make([]error, len(includes))
+	timedOut := make([]bool, len(includes))
+	var wg sync.WaitGroup
+	for i, inc := range includes {
+		wg.Add(1)
+		go func(i int, inc albumInclude) {
+			defer wg.Done()
+			incCtx, cancel := context.WithTimeout(ctx, includeTimeout)
+			defer cancel()
+			// Each include sets its own fields of d, and only once it has loaded them
+			errs[i] = inc.load(incCtx, albumID, &d)
+			timedOut[i] = errs[i] != nil && errors.Is(incCtx.Err(), context.DeadlineExceeded)
+		}(i, inc)
+	}
+	wg.Wait()
+
+	for i, inc := range includes {
+		if errs[i] == nil {
+			d.Meta.Included = append(d.Meta.Included, inc.name)
+			continue
+		}
+		reason := "error"
+		if timedOut[i] {
+			reason = "timeout"
+		}
+		log.Printf("Include %s of album %s failed: %v", inc.name, a.ID, errs[i])
+		d.Meta.Failed = append(d.Meta.Failed, IncludeFailure{Include: inc.name, Reason: reason})
+	}
+	return d
+}
+
+// loadReviewSummary is the reviews include
+func loadReviewSummary(ctx context.Context, albumID int, d *AlbumDetail) error {
+	s := &ReviewSummary{Ratings: map[string]int{}, Latest: []Review{}}
+	rows, err := db.QueryContext(ctx, "SELECT rating, COUNT(*) FROM album_reviews WHERE album_id = $1 GROUP BY rating", albumID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var sum int
+	for rows.Next() {
+		var rating, count int
+		if err := rows.Scan(&rating, &count); err != nil {
+			return err
+		}
+		s.Ratings[strconv.Itoa(rating)] = count
+		s.Count += count
+		sum += rating * count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if s.Count > 0 {
+		s.AverageRating = float64(sum) / float64(s.Count)
+	}
+
+	latest, err := db.QueryContext(ctx, `SELECT id, rating, COALESCE(author, ''), COALESCE(comment, ''), created_at
+		FROM album_reviews WHERE album_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, albumID, includedReviews)
+	if err != nil {
+		return err
+	}
+	defer latest.Close()
+	for latest.Next() {
+		r := Review{AlbumID: strconv.Itoa(albumID)}
+		if err := latest.Scan(&r.ID, &r.Rating, &r.Author, &r.Comment, &r.CreatedAt); err != nil {
+			return err
+		}
+		s.Latest = append(s.Latest, r)
+	}
+	if err := latest.Err(); err != nil {
+		return err
+	}
+	d.Reviews = s
+	return nil
+}
+
+// loadInventoryStatus is the inventory include
+func loadInventoryStatus(ctx context.Context, albumID int, d *AlbumDetail) error {
+	statuses, err := inventoryStatuses(ctx, []int{albumID})
+	if err != nil {
+		return err
+	}
+	d.Inventory = statuses[albumID]
+	return nil
+}
+
+// inventoryStatuses reads the inventory of the albums in one query; albums without an inventory
+// record are "unknown"
+func inventoryStatuses(ctx context.Context, albumIDs []int) (map[int]*InventoryStatus, error) {
+	statuses := make(map[int]*InventoryStatus, len(albumIDs))
+	if len(albumIDs) == 0 {
+		return statuses, nil
+	}
+	args := make([]interface{}, len(albumIDs))
+	placeholders := make([]string, len(albumIDs))
+	for i, id := range albumIDs {
+		statuses[id] = &InventoryStatus{Availability: "unknown"}
+		args[i] = strconv.Itoa(id)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT album_id, quantity_available, last_updated FROM inventory WHERE album_id IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var albumID string
+		var quantity int
+		var updated time.Time
+		if err := rows.Scan(&albumID, &quantity, &updated); err != nil {
+			return nil, err
+		}
+		id, _ := strconv.Atoi(albumID)
+		s, ok := statuses[id]
+		if !ok {
+			continue
+		}
+		s.QuantityAvailable, s.LastUpdated = &quantity, &updated
+		s.Availability = "out_of_stock"
+		if quantity > 0 {
+			s.Availability = "in_stock"
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
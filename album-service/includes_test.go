@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAlbum_Includes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	mock.MatchExpectationsInOrder(false) // Includes are loaded concurrently
+	originalDB, originalTimeout := db, includeTimeout
+	db = mockDB
+	t.Cleanup(func() { db, includeTimeout = originalDB, originalTimeout })
+
+	expectAlbum := func() {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 2, 4.5, 2, "", "", nil, "published", nil))
+	}
+	expectReviews := func() {
+		mock.ExpectQuery("GROUP BY rating").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"rating", "count"}).AddRow(4, 1).AddRow(5, 1))
+		mock.ExpectQuery("ORDER BY created_at DESC").WithArgs(4, includedReviews).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "rating", "author", "comment", "created_at"}).
+				AddRow(8, 5, "Kurt", "", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+	}
+	get := func(path string, admin bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", `"2"`)
+		if admin {
+			req.Header.Set("Client-Type", "admin")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Includes are loaded with the album", func(t *testing.T) {
+		expectAlbum()
+		expectReviews()
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated"}).AddRow("4", 3, time.Now()))
+
+		rr := get("/api/albums/4?include=reviews,inventory,tracks", false)
+		require.Equal(t, http.StatusOK, rr.Code, "the version doesn't revalidate includes")
+		assert.NotEqual(t, `"2"`, rr.Header().Get("ETag"))
+		assert.Contains(t, rr.Body.String(), `"title":"Nevermind"`)
+		assert.Contains(t, rr.Body.String(), `"reviews":{"averageRating":4.5,"count":2,"ratings":{"4":1,"5":1},"latest":[{"id":8,"albumId":"4","author":"Kurt"`)
+		assert.Contains(t, rr.Body.String(), `"inventory":{"availability":"in_stock"}`, "quantities are for admins")
+		assert.Contains(t, rr.Body.String(), `"meta":{"included":["reviews","inventory","tracks"]}`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admins see the quantity", func(t *testing.T) {
+		expectAlbum()
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated"}).AddRow("4", 0, time.Now()))
+
+		rr := get("/api/albums/4?include=inventory", true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"inventory":{"availability":"out_of_stock","quantityAvailable":0`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A failed include is reported and the rest returned", func(t *testing.T) {
+		expectAlbum()
+		expectReviews()
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").WillReturnError(errors.New("relation \"inventory\" does not exist"))
+
+		rr := get("/api/albums/4?include=reviews&include=inventory", false)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"reviews":{`)
+		assert.NotContains(t, rr.Body.String(), `"inventory":`)
+		assert.Contains(t, rr.Body.String(), `"meta":{"included":["reviews"],"failed":[{"include":"inventory","reason":"error"}]}`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Slow includes time out", func(t *testing.T) {
+		includeTimeout = 20 * time.Millisecond
+		t.Cleanup(func() { includeTimeout = originalTimeout })
+		expectAlbum()
+		mock.ExpectQuery("FROM inventory WHERE album_id IN \\(\\$1\\)").WithArgs("4").WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available", "last_updated"}))
+
+		start := time.Now()
+		rr := get("/api/albums/4?include=inventory", false)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"failed":[{"include":"inventory","reason":"timeout"}]`)
+	})
+
+	t.Run("Unknown includes", func(t *testing.T) {
+		for _, path := range []string{"/api/albums/4?include=lyrics", "/api/albums/4?include=reviews&asOf=2024-05-01T12:00:00Z"} {
+			assert.Equal(t, http.StatusBadRequest, get(path, false).Code, path)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+// internal_info.go - GET /internal/info: how this instance is wired, so on-call engineers can check
+// a pod's configuration, dependencies and schema during an incident without exec-ing into it.
+// Secrets are redacted; the database is described by host, port, name and user only.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"album-store/jsonnaming"
+	"album-store/topics"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// processStartedAt is reported as the instance's start time
+var processStartedAt = time.Now()
+
+// configVariables lists every environment variable album-service reads
+var configVariables = []string{
+	"ALBUM_INCLUDE_TIMEOUT", "API_UNVERSIONED_SUNSET", "CATALOG_CHANGE_APPROVAL",
+	"CLEARANCE_AUTO_APPROVE", "CLEARANCE_JOB_INTERVAL", "CLEARANCE_RULE", "COLD_STORAGE_AFTER_YEARS",
+	"COLD_STORAGE_JOB_INTERVAL",
+	"DB_CONNECTION", "DB_MAX_CONN_LIFETIME", "DB_MAX_CONNS", "DB_MIGRATE_ON_STARTUP", "DB_MIN_CONNS",
+	"DB_READ_CONNECTION", "DISCOGS_TOKEN", "ENVIRONMENT", "EVENT_SCHEMA_CONSUME_VERSION",
+	"EVENT_SCHEMA_PUBLISH_VERSIONS", "EVENT_SERIALIZATION", "FEED_BASE_URL", "FEED_CURRENCY",
+	"FEED_REGENERATE_INTERVAL", "FEED_REQUIRE_API_KEY", "GENRE_REFRESH_INTERVAL",
+	"JSON_FIELD_NAMING", "KAFKA_BROKER", "KAFKA_PRODUCER_ACKS", "KAFKA_PRODUCER_ASYNC", "KAFKA_PRODUCER_BATCH_SIZE",
+	"KAFKA_PRODUCER_BATCH_TIMEOUT", "KAFKA_PRODUCER_MAX_ATTEMPTS", "KAFKA_TOPIC_PREFIX", "KAFKA_TOPIC_SUFFIX",
+	"MAX_REQUEST_BODY_BYTES", "METADATA_PROVIDER", "METADATA_PROVIDER_TIMEOUT", "METADATA_PROVIDER_URL",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_REDACT_ATTRIBUTES", "OTEL_REDACT_HASH_KEY",
+	"POPULARITY_JOB_INTERVAL", "PRICE_FLOOR", "PRICE_FLOOR_BY_GENRE", "PROMOTION_JOB_INTERVAL",
+	"PUBLIC_ID_ALPHABET", "PUBLIC_ID_ENCODING", "PUBLIC_ID_MIN_LENGTH", "PUBLISH_RETRY_BUFFER_SIZE",
+	"REDIS_CACHE_TTL", "REDIS_URL",
+	"SALES_SUMMARY_TIMEZONE", "SCHEMA_REGISTRY_URL", "SERVICE_PORT", "STOREFRONT_RATE_LIMIT_PER_MINUTE",
+	"VIEW_FLUSH_INTERVAL", "VIEW_RATE_LIMIT_PER_MINUTE", "WEBHOOK_DELIVERY_INTERVAL",
+}
+
+// secretConfigVariables are reported as set or not, never by value. The public ID alphabet is
+// secret because it would let anyone decode public IDs; the schema registry URL may carry credentials.
+var secretConfigVariables = map[string]bool{
+	"DB_CONNECTION":        true,
+	"DB_READ_CONNECTION":   true,
+	"DISCOGS_TOKEN":        true,
+	"OTEL_REDACT_HASH_KEY": true,
+	"PUBLIC_ID_ALPHABET":   true,
+	"REDIS_URL":            true,
+	"SCHEMA_REGISTRY_URL":  true,
+}
+
+const redactedValue = "[redacted]"
+
+// internalInfoTimeout limits the schema lookup of GET /internal/info
+const internalInfoTimeout = 3 * time.Second
+
+// InternalInfo is the response of GET /internal/info
+type InternalInfo struct {
+	Service        string                 `json:"service"`
+	StartedAt      time.Time              `json:"startedAt"`
+	Build          BuildInfo              `json:"build"`
+	Dependencies   DependencyInfo         `json:"dependencies"`
+	Schema         SchemaInfo             `json:"schema"`
+	Features       map[string]interface{} `json:"features"`
+	ConsumerGroups []string               `json:"consumerGroups"`
+	// Config maps each variable to its value, null when unset (the default applies)
+	Config map[string]*string `json:"config"`
+}
+
+// BuildInfo describes the binary: its Go version, VCS revision and module versions
+type BuildInfo struct {
+	GoVersion string            `json:"goVersion"`
+	Revision  string            `json:"revision,omitempty"`
+	Modules   map[string]string `json:"modules"`
+}
+
+// DependencyInfo lists the endpoints the instance connects to
+type DependencyInfo struct {
+	Database     DatabaseEndpoint `json:"database"`
+	KafkaBroker  string           `json:"kafkaBroker"`
+	OTLPEndpoint string           `json:"otlpEndpoint"`
+}
+
+// DatabaseEndpoint is the database of DB_CONNECTION, without its password
+type DatabaseEndpoint struct {
+	Host     string `json:"host,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Database string `json:"database,omitempty"`
+	User     string `json:"user,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SchemaInfo reports the database schema: the migration it is at, the migrations not yet applied
+// (see migrations.go) and the expected tables and columns the database lacks
+type SchemaInfo struct {
+	Version           int64    `json:"version"`
+	PendingMigrations []string `json:"pendingMigrations"`
+	Pending           []string `json:"pending"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// getInternalInfo handles GET /internal/info
+func getInternalInfo(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), internalInfoTimeout)
+	defer cancel()
+
+	info := InternalInfo{
+		Service:   "album-service",
+		StartedAt: processStartedAt,
+		Build:     buildInfo(),
+		Dependencies: DependencyInfo{
+			Database:     databaseEndpoint(dbConnectionFromEnv()),
+			KafkaBroker:  kafkaBrokerAddress(),
+			OTLPEndpoint: otlpEndpointFromEnv(),
+		},
+		Schema:         schemaInfo(ctx, albumSchema),
+		Features:       albumFeatures(),
+		ConsumerGroups: []string{topics.GroupName(salesConsumerGroup)},
+		Config:         redactedConfig(configVariables, secretConfigVariables),
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// albumFeatures reports the optional behaviour in effect
+func albumFeatures() map[string]interface{} {
+	publicIDEncoding := "sqids"
+	if _, ok := publicIDs.(plainIDs); ok {
+		publicIDEncoding = "plain"
+	}
+	metadataProvider := ""
+	if metadataEnricher != nil {
+		metadataProvider = metadataEnricher.provider.name()
+	}
+	var sunset *time.Time
+	if !unversionedAPISunset.IsZero() {
+		sunset = &unversionedAPISunset
+	}
+	return map[string]interface{}{
+		"jsonFieldNaming":       jsonnaming.Deployment,
+		"publicIdEncoding":      publicIDEncoding,
+		"metadataProvider":      metadataProvider,
+		"clearanceRule":         clearance.Days > 0,
+		"clearanceAutoApprove":  clearance.AutoApprove,
+		"catalogApproval":       catalogApproval,
+		"coldStorageAfterYears": coldStorageAfterYears,
+		"albumCache":            albumCache != nil,
+		"readReplica":           replicaDB != nil,
+		"migrateOnStartup":      migrateOnStartup,
+		"feedRequireApiKey":     feedRequireAPIKey,
+		"maxRequestBodyBytes":   maxRequestBodyBytes,
+		"kafkaProducer":         kafkaProducer.String(),
+		"eventSerialization":    eventSerialization,
+		"publishRetryBuffer":    publishRetries.size,
+		"eventPublishVersions":  eventPublishVersions,
+		"eventConsumeVersion":   eventConsumeVersion,
+		"unversionedApiSunset":  sunset,
+	}
+}
+
+// redactedConfig returns the value of each variable, with secrets redacted
+func redactedConfig(names []string, secrets map[string]bool) map[string]*string {
+	config := make(map[string]*string, len(names))
+	for _, name := range names {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			config[name] = nil
+			continue
+		}
+		if secrets[name] {
+			v = redactedValue
+		}
+		config[name] = &v
+	}
+	return config
+}
+
+// databaseEndpoint describes the database of a connection string, URL or key/value form
+func databaseEndpoint(connStr string) DatabaseEndpoint {
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		// The error may quote the connection string; only say that it's invalid
+		return DatabaseEndpoint{Error: "invalid DB_CONNECTION"}
+	}
+	return DatabaseEndpoint{Host: cfg.Host, Port: cfg.Port, Database: cfg.Database, User: cfg.User}
+}
+
+// schemaInfo reports the migration state and the expected tables and columns missing from the database
+func schemaInfo(ctx context.Context, expected map[string][]string) SchemaInfo {
+	info := SchemaInfo{PendingMigrations: []string{}, Pending: []string{}}
+	version, pending, err := migrationState(ctx, db)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Version, info.PendingMigrations = version, pending
+	missing, err := missingSchema(ctx, db, expected)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	if missing != nil {
+		info.Pending = missing
+	}
+	return info
+}
+
+// buildInfo reads the versions compiled into the binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Modules: map[string]string{}}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			info.Revision = s.Value
+		}
+	}
+	for _, dep := range bi.Deps {
+		version := dep.Version
+		if dep.Replace != nil {
+			version = dep.Replace.Version
+		}
+		info.Modules[dep.Path] = version
+	}
+	return info
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"album-store/events"
+	"album-store/jsonnaming"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestJSONFieldNaming(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalNaming := db, jsonnaming.Deployment
+	db = mockDB
+	t.Cleanup(func() { db, jsonnaming.Deployment = originalDB, originalNaming })
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "DGC-24425", 2, 4.5, 2, "1991-09-24", "DGC", []byte(`[{"position":1,"title":"Smells Like Teen Spirit","durationSeconds":301}]`), "published", nil))
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("camelCase by default", func(t *testing.T) {
+		rr := get("/api/albums/4", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"releaseYear":1991`)
+		assert.Contains(t, rr.Body.String(), `"catalogNumber":"DGC-24425"`)
+		assert.Contains(t, rr.Header().Values("Vary"), "Accept")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("snake_case per request", func(t *testing.T) {
+		for _, rr := range []*httptest.ResponseRecorder{
+			get("/api/albums/4?naming=snake_case", ""),
+			get("/api/albums/4", "application/json; naming=snake_case"),
+		} {
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Body.String(), `"release_year":1991`)
+			assert.Contains(t, rr.Body.String(), `"catalog_number":"DGC-24425"`)
+			assert.Contains(t, rr.Body.String(), `"average_rating":4.5`)
+			assert.Contains(t, rr.Body.String(), `"tracks":[{"position":1,"title":"Smells Like Teen Spirit","duration_seconds":301}]`)
+			assert.NotContains(t, rr.Body.String(), "releaseYear")
+			assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+			assert.Contains(t, rr.Header().Values("Vary"), "Accept")
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("snake_case per deployment, camelCase on request", func(t *testing.T) {
+		jsonnaming.Deployment = jsonnaming.SnakeCase
+		t.Cleanup(func() { jsonnaming.Deployment = originalNaming })
+
+		rr := get("/api/albums/4", "")
+		assert.Contains(t, rr.Body.String(), `"release_year":1991`)
+		rr = get("/api/albums/4", "application/json; naming=camelCase")
+		assert.Contains(t, rr.Body.String(), `"releaseYear":1991`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Errors are renamed too", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, title").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 5, nil))
+		mock.ExpectRollback()
+
+		req, _ := http.NewRequest("PATCH", "/api/albums/4?naming=snake_case", bytes.NewBufferString(`{"title":"Bleach"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		req.Header.Set("If-Match", `"3"`)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"current_version":5`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("snake_case request bodies", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").WithArgs("Bleach", "Nirvana", 1499, 1989, "Rock", "", "", "SP 34", "", "", sqlmock.AnyArg(), []byte(nil)).
+			WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: albumUPCIndex})
+		mock.ExpectRollback()
+
+		req, _ := http.NewRequest("POST", "/api/albums", bytes.NewBufferString(
+			`{"title":"Bleach","artist":"Nirvana","price":14.99,"release_year":1989,"genre":"Rock","catalog_number":"SP 34"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json; naming=snake_case")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown naming", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json; naming=kebab-case"} {
+			path := "/api/albums/4"
+			if accept == "" {
+				path += "?naming=PascalCase"
+			}
+			req, _ := http.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, accept)
+		}
+	})
+}
+
+func TestEventNaming(t *testing.T) {
+	// Consumers decode either naming
+	for _, value := range []string{
+		`{"orderId":"42","albumId":"4","quantity":2}`,
+		`{"order_id":"42","album_id":"4","quantity":2}`,
+		`{"schema_version":2,"event_type":"order.succeeded","data":{"order_id":"42","album_id":"4","quantity":2}}`,
+	} {
+		var event events.OrderSucceeded
+		_, err := decodeEvent(kafka.Message{Value: []byte(value)}, orderSucceededTopic, &event)
+		require.NoError(t, err, value)
+		assert.True(t, proto.Equal(&events.OrderSucceeded{OrderId: "42", AlbumId: "4", Quantity: 2}, &event), value)
+	}
+}
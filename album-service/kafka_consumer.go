@@ -0,0 +1,149 @@
+// kafka consumer logic for album-service (sales signals feeding the popularity score)
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"album-store/events"
+	"album-store/topics"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	orderSucceededTopic = "order-succeeded"
+	salesConsumerGroup  = "album-service-sales"
+)
+
+// OrderPrice is the part of an order's price snapshot kept with its sale
+type OrderPrice struct {
+	TotalPrice Cents  `json:"totalPrice"`
+	Currency   string `json:"currency"`
+}
+
+// orderPrice reads the price snapshot of an order-succeeded event; nil on events published before
+// it was passed through
+func orderPrice(snapshot *structpb.Struct) (*OrderPrice, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	data, err := events.MarshalJSON(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var price OrderPrice
+	if err := json.Unmarshal(data, &price); err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// startOrderSucceededConsumer initializes and runs the Kafka consumer loop for order success events.
+func startOrderSucceededConsumer(kafkaBroker string) {
+	topic := topics.Name(versionedTopic(orderSucceededTopic, eventConsumeVersion))
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    topic,
+		GroupID:  topics.GroupName(salesConsumerGroup),
+		MinBytes: 10e3,
+		MaxBytes: 10e6,
+	})
+
+	log.Printf("Kafka consumer started for topic '%s', group '%s', broker '%s'", reader.Config().Topic, reader.Config().GroupID, kafkaBroker)
+
+	defer reader.Close()
+
+	handle := byEventType(orderSucceededTopic, map[string]func(kafka.Message) error{
+		events.TypeOrderSucceeded: func(msg kafka.Message) error { return processOrderSucceeded(db, msg) },
+	})
+	for {
+		msg, err := reader.ReadMessage(context.Background())
+		if err != nil {
+			log.Printf("Error reading message (%s): %v", topic, err)
+			continue
+		}
+		observeConsumeLag(msg)
+
+		if err := handle(msg); err != nil {
+			log.Printf("Failed to process order succeeded message: %v. Offset: %d", err, msg.Offset)
+		} else {
+			if err := reader.CommitMessages(context.Background(), msg); err != nil {
+				log.Printf("Failed to commit message offset %d (%s): %v", msg.Offset, topic, err)
+			}
+		}
+	}
+}
+
+// processOrderSucceeded records the units sold for an album. Each order is recorded at most once,
+// so replayed messages don't inflate sales.
+func processOrderSucceeded(db *sql.DB, msg kafka.Message) error {
+	ctx := ExtractTraceInfoFromKafkaMessage(context.Background(), msg.Headers)
+	ctx, span := tracer.Start(ctx, "processOrderSucceeded")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("kafka.partition", msg.Partition),
+		attribute.Int64("kafka.offset", msg.Offset),
+		attribute.String("kafka.topic", msg.Topic),
+	)
+
+	var event events.OrderSucceeded
+	_, err := decodeEvent(msg, orderSucceededTopic, &event)
+	var price *OrderPrice
+	if err == nil {
+		price, err = orderPrice(event.Price)
+	}
+	if err != nil {
+		log.Printf("Error parsing order-succeeded event JSON: %v. Message: %s", err, string(msg.Value))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to parse order succeeded event")
+		return nil // Sales are a ranking signal only; don't block the partition on bad messages
+	}
+
+	albumID, err := strconv.Atoi(event.AlbumId)
+	if err != nil || event.Quantity <= 0 {
+		log.Printf("Skipping order %s: not attributable to an album (albumId=%q, quantity=%d)", event.OrderId, event.AlbumId, event.Quantity)
+		span.SetStatus(codes.Ok, "Order skipped - no album line")
+		return nil
+	}
+	span.SetAttributes(
+		attribute.String("order.id", event.OrderId),
+		attribute.String("album.id", event.AlbumId),
+		attribute.Int("order.quantity", int(event.Quantity)),
+	)
+
+	soldAt := time.Now()
+	if event.Timestamp != nil {
+		soldAt = event.Timestamp.AsTime()
+	}
+	var revenue sql.NullInt64
+	var currency sql.NullString
+	if price != nil {
+		revenue = sql.NullInt64{Int64: int64(price.TotalPrice), Valid: true}
+		currency = sql.NullString{String: price.Currency, Valid: true}
+	}
+
+	// Selecting through albums turns sales for since-deleted albums into a no-op instead of an FK error
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO album_sales (order_id, album_id, units, sold_at, revenue_cents, currency)
+		SELECT $1::varchar, id, $3::int, $4::timestamp, $5::bigint, $6::varchar FROM albums WHERE id = $2
+		ON CONFLICT (order_id) DO NOTHING`,
+		event.OrderId, albumID, event.Quantity, soldAt, revenue, currency)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Database insert failed")
+		return fmt.Errorf("failed to record album sale: %w", err)
+	}
+
+	span.SetStatus(codes.Ok, "Sale recorded")
+	return nil
+}
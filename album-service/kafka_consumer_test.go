@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestProcessOrderSucceeded tests recording album sales from order-succeeded events.
+func TestProcessOrderSucceeded(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	soldAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	message := func(event *events.OrderSucceeded) kafka.Message {
+		b, err := events.MarshalJSON(event)
+		if err != nil {
+			t.Fatalf("marshal event: %v", err)
+		}
+		return kafka.Message{Value: b}
+	}
+
+	t.Run("Records units sold", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO album_sales").
+			WithArgs("order-1", 42, 3, soldAt, nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := processOrderSucceeded(mockDB, message(&events.OrderSucceeded{OrderId: "order-1", AlbumId: "42", Quantity: 3, Timestamp: timestamppb.New(soldAt)}))
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Records the revenue of priced orders", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO album_sales").
+			WithArgs("order-4", 42, 2, soldAt, int64(3998), "USD").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		msg := kafka.Message{Value: []byte(`{"orderId":"order-4","albumId":"42","quantity":2,"timestamp":"2024-05-01T12:00:00Z",
+			"price":{"unitPrice":19.99,"discountAmount":0,"taxAmount":0,"totalPrice":39.98,"currency":"USD"}}`)}
+		assert.NoError(t, processOrderSucceeded(mockDB, msg))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Skips events without a numeric album", func(t *testing.T) {
+		err := processOrderSucceeded(mockDB, message(&events.OrderSucceeded{OrderId: "order-2", AlbumId: "album-x", Quantity: 1}))
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Skips malformed JSON", func(t *testing.T) {
+		err := processOrderSucceeded(mockDB, kafka.Message{Value: []byte("{not json")})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database error is returned so the offset isn't committed", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO album_sales").
+			WithArgs("order-3", 7, 1, soldAt, nil, nil).
+			WillReturnError(fmt.Errorf("connection reset"))
+
+		err := processOrderSucceeded(mockDB, message(&events.OrderSucceeded{OrderId: "order-3", AlbumId: "7", Quantity: 1, Timestamp: timestamppb.New(soldAt)}))
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
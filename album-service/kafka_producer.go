@@ -0,0 +1,124 @@
+// kafka_producer.go - delivery settings of the Kafka writers. kafka-go's zero-value Writer waits for
+// no broker acknowledgement, so events were lost whenever a partition leader failed over. By default
+// every event is acknowledged by all in-sync replicas (KAFKA_PRODUCER_ACKS=all) and a failed write is
+// retried up to KAFKA_PRODUCER_MAX_ATTEMPTS times.
+//
+// kafka-go has no idempotent producer: a retry after a lost acknowledgement can publish an event twice.
+// Each partition has one batch in flight at a time, so retries don't reorder events, and the
+// consumers of album events skip events they have already handled.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultKafkaMaxAttempts  = 10
+	defaultKafkaBatchSize    = 100
+	defaultKafkaBatchTimeout = 10 * time.Millisecond // kafka-go waits a whole second by default
+)
+
+// kafkaProducerConfig is the writer configuration read from the environment
+type kafkaProducerConfig struct {
+	RequiredAcks kafka.RequiredAcks
+	MaxAttempts  int
+	BatchSize    int
+	BatchTimeout time.Duration
+	Async        bool // WriteMessages returns before the broker acknowledges; failures are only logged
+}
+
+func (c kafkaProducerConfig) String() string {
+	mode := "sync"
+	if c.Async {
+		mode = "async"
+	}
+	return fmt.Sprintf("acks=%s, %d attempts, batches of %d or %s, %s", c.RequiredAcks, c.MaxAttempts, c.BatchSize, c.BatchTimeout, mode)
+}
+
+// kafkaProducer configures the writers started from then on; main sets it before starting them
+var kafkaProducer = defaultKafkaProducerConfig()
+
+func defaultKafkaProducerConfig() kafkaProducerConfig {
+	return kafkaProducerConfig{
+		RequiredAcks: kafka.RequireAll,
+		MaxAttempts:  defaultKafkaMaxAttempts,
+		BatchSize:    defaultKafkaBatchSize,
+		BatchTimeout: defaultKafkaBatchTimeout,
+	}
+}
+
+// loadKafkaProducerConfig reads KAFKA_PRODUCER_ACKS, KAFKA_PRODUCER_MAX_ATTEMPTS,
+// KAFKA_PRODUCER_BATCH_SIZE, KAFKA_PRODUCER_BATCH_TIMEOUT and KAFKA_PRODUCER_ASYNC
+func loadKafkaProducerConfig() (kafkaProducerConfig, error) {
+	cfg := defaultKafkaProducerConfig()
+	if v := os.Getenv("KAFKA_PRODUCER_ACKS"); v != "" {
+		if err := cfg.RequiredAcks.UnmarshalText([]byte(strings.ToLower(v))); err != nil {
+			return cfg, fmt.Errorf("KAFKA_PRODUCER_ACKS %q must be all, one or none", v)
+		}
+	}
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{
+		{"KAFKA_PRODUCER_MAX_ATTEMPTS", &cfg.MaxAttempts},
+		{"KAFKA_PRODUCER_BATCH_SIZE", &cfg.BatchSize},
+	} {
+		if v := os.Getenv(setting.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return cfg, fmt.Errorf("%s %q is not a positive integer", setting.name, v)
+			}
+			*setting.value = n
+		}
+	}
+	if v := os.Getenv("KAFKA_PRODUCER_BATCH_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("KAFKA_PRODUCER_BATCH_TIMEOUT %q is not a positive duration", v)
+		}
+		cfg.BatchTimeout = d
+	}
+	if v := os.Getenv("KAFKA_PRODUCER_ASYNC"); v != "" {
+		async, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("KAFKA_PRODUCER_ASYNC %q must be true or false", v)
+		}
+		cfg.Async = async
+	}
+	return cfg, nil
+}
+
+// newWriter returns a writer for topic on broker. failed is called with the error of every batch an
+// async writer could not deliver.
+func (c kafkaProducerConfig) newWriter(broker, topic string, failed func(error)) *kafka.Writer {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(broker),
+		Topic:        topic,
+		Balancer:     &kafka.LeastBytes{},
+		RequiredAcks: c.RequiredAcks,
+		MaxAttempts:  c.MaxAttempts,
+		BatchSize:    c.BatchSize,
+		BatchTimeout: c.BatchTimeout,
+		Async:        c.Async,
+		WriteTimeout: 10 * time.Second,
+	}
+	if c.Async {
+		w.Completion = func(messages []kafka.Message, err error) {
+			if err == nil {
+				return
+			}
+			log.Printf("Failed to deliver %d messages to topic '%s': %v", len(messages), topic, err)
+			kafkaAsyncWriteFailures.WithLabelValues(topic).Add(float64(len(messages)))
+			failed(err)
+		}
+	}
+	return w
+}
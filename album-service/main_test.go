@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
@@ -12,10 +13,16 @@ import (
 	"testing"
 
 	// Add kafka import for dummy writer
+	"album-store/events"
+	"album-store/kafkawriter"
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/segmentio/kafka-go"
 
 	"github.com/gin-gonic/gin" // Import Gin
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
 
 	// _ "github.com/lib/pq" // Remove lib/pq import
 	_ "github.com/jackc/pgx/v5/stdlib" // Import pgx stdlib driver
@@ -53,8 +60,13 @@ func TestMain(m *testing.M) {
 	// NOTE: This is a simplification. Dependency injection is a better pattern.
 	db = testDB
 
-	// Ensure the table exists in the test DB
-	initDB() // Uses the global 'db' which is now testDB
+	// Bring the test DB's schema up to date
+	if err := runMigrations(context.Background(), testDB); err != nil {
+		log.Fatalf("Could not migrate test database: %v", err)
+	}
+
+	// Handlers start child spans directly, so give them the (no-op) global tracer
+	tracer = otel.Tracer("album-service")
 
 	// Initialize a dummy Kafka writer to prevent nil pointer dereference in tests
 	// This writer won't actually publish messages effectively.
@@ -63,6 +75,26 @@ func TestMain(m *testing.M) {
 		Topic:   albumCreatedTopic,      // Use the constant defined in main.go
 		Async:   true,                   // Use Async to prevent blocking test execution
 	})
+	albumDeletedWriter = kafka.NewWriter(kafka.WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   albumDeletedTopic,
+		Async:   true,
+	})
+	priceProposalWriter = kafka.NewWriter(kafka.WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   priceProposalsTopic,
+		Async:   true,
+	})
+	priceChangedWriter = kafka.NewWriter(kafka.WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   priceChangedTopic,
+		Async:   true,
+	})
+	dailySalesWriter = kafka.NewWriter(kafka.WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   dailySalesTopic,
+		Async:   true,
+	})
 	log.Println("Initialized dummy Kafka writer for tests.")
 
 	// Set up the Gin router for testing
@@ -77,8 +109,10 @@ func TestMain(m *testing.M) {
 	cleanupDB()
 	testDB.Close()
 	// Close the dummy Kafka writer
-	if err := kafkaWriter.Close(); err != nil {
-		log.Printf("Error closing dummy Kafka writer: %v", err)
+	for _, writer := range albumEventWriters() {
+		if err := writer.Close(); err != nil {
+			log.Printf("Error closing dummy Kafka writer: %v", err)
+		}
 	}
 
 	os.Exit(exitCode)
@@ -87,26 +121,16 @@ func TestMain(m *testing.M) {
 // setupRouter configures the Gin router with routes and middleware (mirrors main.go)
 func setupRouter() *gin.Engine {
 	router := gin.New() // Use New instead of Default in tests to avoid default middleware unless needed
-
-	api := router.Group("/api")
-	{
-		albums := api.Group("/albums")
-		{
-			albums.GET("", getAllAlbums)
-			albums.GET("/:id", getAlbum)
-
-			adminRoutes := albums.Group("")
-			adminRoutes.Use(requireAdmin())
-			{
-				adminRoutes.POST("", createAlbum)
-				adminRoutes.PUT("/:id", updateAlbum)
-				adminRoutes.DELETE("/:id", deleteAlbum)
-			}
-		}
-	}
-	router.GET("/health", func(c *gin.Context) { // Add health check route for completeness if needed by tests
-		c.JSON(http.StatusOK, gin.H{"ok": true})
-	})
+	router.Use(limitRequestBody(), compressResponses())
+
+	registerAPI(router, func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+	registerHealthRoutes(router)
+	router.GET("/internal/info", getInternalInfo)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	registerFeeds(router, func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+	registerSurface(router, newStorefrontSurface(defaultStorefrontRateLimit), func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+	router.POST("/graphql", withCachePolicy(cacheNoStore), serveGraphQL)
+	registerAPIDocs(router)
 	return router
 }
 
@@ -132,7 +156,7 @@ func TestCreateAlbumHandler_Success(t *testing.T) {
 	albumPayload := Album{
 		Title:       "Test Album Title",
 		Artist:      "Test Artist Name",
-		Price:       19.99,
+		Price:       1999,
 		ReleaseYear: 2023,
 		Genre:       "Testing",
 	}
@@ -174,7 +198,7 @@ func TestCreateAlbumHandler_Forbidden(t *testing.T) {
 	albumPayload := Album{
 		Title:       "Forbidden Album",
 		Artist:      "Forbidden Artist",
-		Price:       1.00,
+		Price:       100,
 		ReleaseYear: 2024,
 		Genre:       "Forbidden",
 	} // Use a valid payload now as middleware runs first
@@ -227,7 +251,7 @@ func TestCreateAlbumHandler_WithInitialQuantity(t *testing.T) {
 	albumPayload := Album{
 		Title:           "Album With Initial Qty",
 		Artist:          "Test Artist Q",
-		Price:           25.50,
+		Price:           2550,
 		ReleaseYear:     2024,
 		Genre:           "Test Q",
 		InitialQuantity: &initialQty, // Use pointer for optional field
@@ -293,16 +317,16 @@ func TestGetAllAlbumsHandler_WithData(t *testing.T) {
 
 	// Insert test data
 	testAlbums := []Album{
-		{Title: "Test Album 1", Artist: "Test Artist 1", Price: 9.99, ReleaseYear: 2020, Genre: "Test Genre 1"},
-		{Title: "Test Album 2", Artist: "Test Artist 2", Price: 14.99, ReleaseYear: 2021, Genre: "Test Genre 2"},
-		{Title: "Test Album 3", Artist: "Test Artist 3", Price: 19.99, ReleaseYear: 2022, Genre: "Test Genre 3"},
+		{Title: "Test Album 1", Artist: "Test Artist 1", Price: 999, ReleaseYear: 2020, Genre: "Test Genre 1"},
+		{Title: "Test Album 2", Artist: "Test Artist 2", Price: 1499, ReleaseYear: 2021, Genre: "Test Genre 2"},
+		{Title: "Test Album 3", Artist: "Test Artist 3", Price: 1999, ReleaseYear: 2022, Genre: "Test Genre 3"},
 	}
 
 	// Insert the test albums into the database
 	for i, album := range testAlbums {
 		var id int
 		err := testDB.QueryRow(
-			"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 			album.Title, album.Artist, album.Price, album.ReleaseYear, album.Genre,
 		).Scan(&id)
 		assert.NoError(t, err, "Failed to insert test album %d", i+1)
@@ -372,14 +396,14 @@ func TestGetAlbumHandler_Found(t *testing.T) {
 	testAlbum := Album{
 		Title:       "Test Get Album",
 		Artist:      "Test Get Artist",
-		Price:       12.34,
+		Price:       1234,
 		ReleaseYear: 2023,
 		Genre:       "Test Get Genre",
 	}
 
 	var id int
 	err := testDB.QueryRow(
-		"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		testAlbum.Title, testAlbum.Artist, testAlbum.Price, testAlbum.ReleaseYear, testAlbum.Genre,
 	).Scan(&id)
 	assert.NoError(t, err, "Failed to insert test album")
@@ -416,14 +440,14 @@ func TestUpdateAlbumHandler_Success(t *testing.T) {
 	originalAlbum := Album{
 		Title:       "Original Title",
 		Artist:      "Original Artist",
-		Price:       9.99,
+		Price:       999,
 		ReleaseYear: 2020,
 		Genre:       "Original Genre",
 	}
 
 	var id int
 	err := testDB.QueryRow(
-		"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		originalAlbum.Title, originalAlbum.Artist, originalAlbum.Price, originalAlbum.ReleaseYear, originalAlbum.Genre,
 	).Scan(&id)
 	assert.NoError(t, err, "Failed to insert test album")
@@ -434,9 +458,10 @@ func TestUpdateAlbumHandler_Success(t *testing.T) {
 		// ID is set in the URL, not the payload
 		Title:       "Updated Title",
 		Artist:      "Updated Artist",
-		Price:       19.99,
+		Price:       1999,
 		ReleaseYear: 2023,
 		Genre:       "Updated Genre",
+		Version:     1, // The version the edit is based on
 	}
 	payloadBytes, _ := json.Marshal(updatedAlbum)
 
@@ -460,11 +485,12 @@ func TestUpdateAlbumHandler_Success(t *testing.T) {
 	assert.Equal(t, updatedAlbum.Price, responseAlbum.Price, "Album price should be updated")
 	assert.Equal(t, updatedAlbum.ReleaseYear, responseAlbum.ReleaseYear, "Album release year should be updated")
 	assert.Equal(t, updatedAlbum.Genre, responseAlbum.Genre, "Album genre should be updated")
+	assert.Equal(t, 2, responseAlbum.Version, "Album version should be incremented")
 
 	// Verify database was updated
 	var dbAlbum Album
 	var dbID int
-	err = testDB.QueryRow("SELECT id, title, artist, price, release_year, genre FROM albums WHERE id = $1", originalAlbum.ID).
+	err = testDB.QueryRow("SELECT id, title, artist, price_cents, release_year, genre FROM albums WHERE id = $1", originalAlbum.ID).
 		Scan(&dbID, &dbAlbum.Title, &dbAlbum.Artist, &dbAlbum.Price, &dbAlbum.ReleaseYear, &dbAlbum.Genre)
 	assert.NoError(t, err, "Should be able to query updated album")
 	dbAlbum.ID = strconv.Itoa(dbID)
@@ -475,6 +501,46 @@ func TestUpdateAlbumHandler_Success(t *testing.T) {
 	assert.Equal(t, updatedAlbum.Genre, dbAlbum.Genre, "Album genre in DB should be updated")
 }
 
+func TestUpdateAlbumHandler_SavesMetadata(t *testing.T) {
+	cleanupDB()
+	defer cleanupDB()
+
+	var id int
+	err := testDB.QueryRow(
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre, label) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		"Nevermind", "Nirvana", 1999, 1991, "Rock", "Sub Pop",
+	).Scan(&id)
+	require.NoError(t, err, "Failed to insert test album")
+	path := "/api/albums/" + strconv.Itoa(id)
+
+	send := func(method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("PUT", `{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","version":1,`+
+		`"releaseDate":"1991-09-24","label":" DGC ","tracks":[{"position":1,"title":"Smells Like Teen Spirit","durationSeconds":301}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var saved Album
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &saved))
+
+	rr = send("GET", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var fetched Album
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fetched))
+	assert.Equal(t, "1991-09-24", fetched.ReleaseDate)
+	assert.Equal(t, "DGC", fetched.Label, "the label is trimmed as on create")
+	assert.Equal(t, []Track{{Position: 1, Title: "Smells Like Teen Spirit", DurationSeconds: 301}}, fetched.Tracks)
+	assert.Equal(t, fetched, saved, "the PUT response is the saved album")
+
+	rr = send("PUT", `{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","version":2,"releaseDate":"24/09/1991"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "release dates are validated as on create")
+}
+
 func TestUpdateAlbumHandler_NotFound(t *testing.T) {
 	// Ensure the DB is empty
 	cleanupDB()
@@ -484,9 +550,10 @@ func TestUpdateAlbumHandler_NotFound(t *testing.T) {
 	updatedAlbum := Album{
 		Title:       "Updated Title",
 		Artist:      "Updated Artist",
-		Price:       19.99,
+		Price:       1999,
 		ReleaseYear: 2023,
 		Genre:       "Updated Genre",
+		Version:     1,
 	}
 	payloadBytes, _ := json.Marshal(updatedAlbum)
 
@@ -517,14 +584,14 @@ func TestUpdateAlbumHandler_Forbidden(t *testing.T) {
 	originalAlbum := Album{
 		Title:       "Original Title",
 		Artist:      "Original Artist",
-		Price:       9.99,
+		Price:       999,
 		ReleaseYear: 2020,
 		Genre:       "Original Genre",
 	}
 
 	var id int
 	err := testDB.QueryRow(
-		"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		originalAlbum.Title, originalAlbum.Artist, originalAlbum.Price, originalAlbum.ReleaseYear, originalAlbum.Genre,
 	).Scan(&id)
 	assert.NoError(t, err, "Failed to insert test album")
@@ -534,7 +601,7 @@ func TestUpdateAlbumHandler_Forbidden(t *testing.T) {
 	updatedAlbum := Album{
 		Title:       "Updated Title",
 		Artist:      "Updated Artist",
-		Price:       19.99,
+		Price:       1999,
 		ReleaseYear: 2023,
 		Genre:       "Updated Genre",
 	}
@@ -575,14 +642,14 @@ func TestDeleteAlbumHandler_Success(t *testing.T) {
 	testAlbum := Album{
 		Title:       "Album to Delete",
 		Artist:      "Delete Artist",
-		Price:       9.99,
+		Price:       999,
 		ReleaseYear: 2020,
 		Genre:       "Delete Genre",
 	}
 
 	var id int
 	err := testDB.QueryRow(
-		"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		testAlbum.Title, testAlbum.Artist, testAlbum.Price, testAlbum.ReleaseYear, testAlbum.Genre,
 	).Scan(&id)
 	assert.NoError(t, err, "Failed to insert test album")
@@ -636,14 +703,14 @@ func TestDeleteAlbumHandler_Forbidden(t *testing.T) {
 	testAlbum := Album{
 		Title:       "Album to Not Delete",
 		Artist:      "No Delete Artist",
-		Price:       9.99,
+		Price:       999,
 		ReleaseYear: 2020,
 		Genre:       "No Delete Genre",
 	}
 
 	var id int
 	err := testDB.QueryRow(
-		"INSERT INTO albums (title, artist, price, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO albums (title, artist, price_cents, release_year, genre) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		testAlbum.Title, testAlbum.Artist, testAlbum.Price, testAlbum.ReleaseYear, testAlbum.Genre,
 	).Scan(&id)
 	assert.NoError(t, err, "Failed to insert test album")
@@ -670,4 +737,84 @@ func TestDeleteAlbumHandler_Forbidden(t *testing.T) {
 	err = testDB.QueryRow("SELECT COUNT(*) FROM albums WHERE id = $1", testAlbum.ID).Scan(&count)
 	assert.NoError(t, err, "Should be able to query database")
 	assert.Equal(t, 1, count, "Album should still exist in the database")
-}
\ No newline at end of file
+}
+
+// Deletion must publish or queue album-deleted before committing, and keep the album when it can't
+func TestDeleteAlbumHandler_PublishesAlbumDeleted(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, albumDeletedWriter
+	db = mockDB
+	t.Cleanup(func() { db, albumDeletedWriter = originalDB, originalWriter })
+
+	deleteRequest := func(id string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/api/albums/"+id, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Publishes then commits", func(t *testing.T) {
+		writer := &recordingWriter{}
+		albumDeletedWriter = writer
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("DELETE FROM albums").WithArgs("42").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.Equal(t, http.StatusNoContent, deleteRequest("42").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		if assert.Len(t, writer.messages, 1) {
+			var event events.AlbumDeleted
+			assert.NoError(t, events.UnmarshalJSON(writer.messages[0].Value, &event))
+			assert.Equal(t, "42", event.GetAlbumId())
+			assert.Equal(t, "42", string(writer.messages[0].Key))
+		}
+	})
+
+	t.Run("Kafka unavailable queues album-deleted", func(t *testing.T) {
+		prevRetries := publishRetries
+		t.Cleanup(func() { publishRetries = prevRetries })
+		publishRetries = newPublishRetryBuffer(10, publishRetryWriter)
+		albumDeletedWriter = &recordingWriter{err: kafkawriter.ErrUnavailable}
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("DELETE FROM albums").WithArgs("42").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.Equal(t, http.StatusNoContent, deleteRequest("42").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, 1, publishRetries.queued())
+		assert.Equal(t, albumDeletedTopic, publishRetries.pending[0].topic)
+	})
+
+	t.Run("Kafka unavailable and a full retry queue keep the album", func(t *testing.T) {
+		prevRetries := publishRetries
+		t.Cleanup(func() { publishRetries = prevRetries })
+		publishRetries = newPublishRetryBuffer(1, publishRetryWriter)
+		publishRetries.enqueue(albumCreatedTopic, kafka.Message{Key: []byte("41")})
+		albumDeletedWriter = &recordingWriter{err: kafkawriter.ErrUnavailable}
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("DELETE FROM albums").WithArgs("42").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusServiceUnavailable, deleteRequest("42").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown album publishes nothing", func(t *testing.T) {
+		writer := &recordingWriter{}
+		albumDeletedWriter = writer
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("DELETE FROM albums").WithArgs("7").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		assert.Equal(t, http.StatusNotFound, deleteRequest("7").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Empty(t, writer.messages)
+	})
+}
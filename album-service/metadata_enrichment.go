@@ -0,0 +1,378 @@
+// metadata_enrichment.go - optional enrichment of new albums from an external metadata provider.
+// With METADATA_PROVIDER set to musicbrainz or discogs, createAlbum looks the release up by title and
+// artist and fills the release date, label, catalog number and track list the request left empty.
+//
+// Enrichment never fails a create: lookups are bounded by METADATA_PROVIDER_TIMEOUT, and after
+// repeated provider failures a circuit breaker skips lookups for a while, so an outage at the provider
+// doesn't slow down every create.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	defaultMetadataTimeout    = 2 * time.Second
+	metadataBreakerThreshold  = 5                // Consecutive failed lookups that open the circuit
+	metadataBreakerCooldown   = 30 * time.Second // How long an open circuit skips lookups before a trial
+	minMusicBrainzMatchScore  = 90               // MusicBrainz search scores are 0-100
+	metadataProviderUserAgent = "album-store/1.0 (album-service metadata enrichment)"
+	maxAlbumTracks            = 200
+)
+
+// Enrichment outcomes, the result label of album_metadata_enrichments_total
+const (
+	enrichmentEnriched    = "enriched"
+	enrichmentNotFound    = "not_found"
+	enrichmentError       = "error"
+	enrichmentCircuitOpen = "circuit_open"
+)
+
+var errMetadataProviderStatus = errors.New("metadata provider returned an error status")
+
+// releaseDatePattern matches the release dates albums accept: a year, a month or a full date
+var releaseDatePattern = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// Track is one track of an album's track list
+type Track struct {
+	Position        int    `json:"position" binding:"gte=1"`
+	Title           string `json:"title" binding:"required,max=300"`
+	DurationSeconds int    `json:"durationSeconds,omitempty" binding:"gte=0"`
+}
+
+// albumMetadata is what a provider knows about a release. Empty fields are unknown.
+type albumMetadata struct {
+	ReleaseDate   string
+	Label         string
+	CatalogNumber string
+	Tracks        []Track
+}
+
+// metadataProvider looks releases up in an external catalog
+type metadataProvider interface {
+	name() string
+	// lookup returns the metadata of the release best matching title and artist, or nil when the
+	// provider knows no such release
+	lookup(ctx context.Context, title, artist string) (*albumMetadata, error)
+}
+
+// albumEnricher fills missing album fields from a provider, behind a timeout and a circuit breaker
+type albumEnricher struct {
+	provider metadataProvider
+	timeout  time.Duration
+	breaker  *circuitBreaker
+}
+
+// metadataEnricher is nil unless METADATA_PROVIDER is set
+var metadataEnricher *albumEnricher
+
+// loadMetadataEnrichment configures enrichment from METADATA_PROVIDER, METADATA_PROVIDER_URL (to use
+// a mirror), METADATA_PROVIDER_TIMEOUT and, for Discogs, DISCOGS_TOKEN
+func loadMetadataEnrichment() error {
+	providerName := os.Getenv("METADATA_PROVIDER")
+	if providerName == "" {
+		return nil
+	}
+	timeout := defaultMetadataTimeout
+	if v := os.Getenv("METADATA_PROVIDER_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("METADATA_PROVIDER_TIMEOUT: invalid duration %q", v)
+		}
+		timeout = d
+	}
+	baseURL := os.Getenv("METADATA_PROVIDER_URL")
+	client := &http.Client{}
+
+	var provider metadataProvider
+	switch providerName {
+	case "musicbrainz":
+		if baseURL == "" {
+			baseURL = "https://musicbrainz.org"
+		}
+		provider = &musicBrainzProvider{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+	case "discogs":
+		token := os.Getenv("DISCOGS_TOKEN")
+		if token == "" {
+			return errors.New("DISCOGS_TOKEN is required for the discogs metadata provider")
+		}
+		if baseURL == "" {
+			baseURL = "https://api.discogs.com"
+		}
+		provider = &discogsProvider{baseURL: strings.TrimSuffix(baseURL, "/"), token: token, client: client}
+	default:
+		return fmt.Errorf("METADATA_PROVIDER: unknown provider %q (supported: musicbrainz, discogs)", providerName)
+	}
+	metadataEnricher = newAlbumEnricher(provider, timeout)
+	log.Printf("Album metadata enrichment enabled (provider %s, timeout %s)", provider.name(), timeout)
+	return nil
+}
+
+func newAlbumEnricher(provider metadataProvider, timeout time.Duration) *albumEnricher {
+	return &albumEnricher{
+		provider: provider,
+		timeout:  timeout,
+		breaker:  newCircuitBreaker("metadata-provider", metadataBreakerThreshold, metadataBreakerCooldown),
+	}
+}
+
+// enrich fills the release date, label, catalog number and track list of a that are empty with what
+// the provider knows, and returns the outcome ("" when there was nothing to fill). Failures are logged,
+// never returned.
+func (e *albumEnricher) enrich(ctx context.Context, a *Album) string {
+	if a.ReleaseDate != "" && a.Label != "" && a.CatalogNumber != "" && len(a.Tracks) > 0 {
+		return "" // Nothing to fill
+	}
+	ctx, span := tracer.Start(ctx, "metadata.lookup")
+	defer span.End()
+	span.SetAttributes(attribute.String("metadata.provider", e.provider.name()))
+
+	result := e.lookupInto(ctx, a)
+	span.SetAttributes(attribute.String("metadata.result", result))
+	if result == enrichmentError {
+		span.SetStatus(codes.Error, "metadata lookup failed")
+	}
+	metadataEnrichments.WithLabelValues(e.provider.name(), result).Inc()
+	return result
+}
+
+func (e *albumEnricher) lookupInto(ctx context.Context, a *Album) string {
+	if !e.breaker.allow() {
+		return enrichmentCircuitOpen
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	md, err := e.provider.lookup(ctx, a.Title, a.Artist)
+	e.breaker.record(err)
+	if err != nil {
+		log.Printf("Metadata lookup for %q by %q failed: %v", a.Title, a.Artist, err)
+		return enrichmentError
+	}
+	if md == nil {
+		return enrichmentNotFound
+	}
+
+	if a.ReleaseDate == "" && releaseDatePattern.MatchString(md.ReleaseDate) {
+		a.ReleaseDate = md.ReleaseDate
+	}
+	if a.Label == "" {
+		a.Label = truncateMetadata(md.Label, 200)
+	}
+	if a.CatalogNumber == "" {
+		a.CatalogNumber = truncateMetadata(md.CatalogNumber, 50)
+	}
+	if len(a.Tracks) == 0 && len(md.Tracks) <= maxAlbumTracks {
+		a.Tracks = md.Tracks
+	}
+	return enrichmentEnriched
+}
+
+// enrichAlbum enriches a when a provider is configured
+func enrichAlbum(ctx context.Context, a *Album) {
+	if metadataEnricher != nil {
+		metadataEnricher.enrich(ctx, a)
+	}
+}
+
+// validateAlbumMetadata checks the fields a provider can fill, as sent by a client
+func validateAlbumMetadata(a *Album) error {
+	a.Label = strings.TrimSpace(a.Label)
+	return validateReleaseDate(a.ReleaseDate)
+}
+
+// validateReleaseDate checks a release date sent by a client; "" is no date
+func validateReleaseDate(date string) error {
+	if date != "" && !releaseDatePattern.MatchString(date) {
+		return errors.New("releaseDate must be YYYY, YYYY-MM or YYYY-MM-DD")
+	}
+	return nil
+}
+
+// truncateMetadata trims s and cuts it to at most n characters, the size of its column
+func truncateMetadata(s string, n int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return string(runes)
+}
+
+// getProviderJSON fetches url and decodes the JSON response into v
+func getProviderJSON(ctx context.Context, client *http.Client, url string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", metadataProviderUserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", errMetadataProviderStatus, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// musicBrainzProvider uses the MusicBrainz web service (https://musicbrainz.org/doc/MusicBrainz_API)
+type musicBrainzProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (p *musicBrainzProvider) name() string { return "musicbrainz" }
+
+type musicBrainzLabelInfo []struct {
+	CatalogNumber string `json:"catalog-number"`
+	Label         *struct {
+		Name string `json:"name"`
+	} `json:"label"`
+}
+
+func (p *musicBrainzProvider) lookup(ctx context.Context, title, artist string) (*albumMetadata, error) {
+	var search struct {
+		Releases []struct {
+			ID    string `json:"id"`
+			Score int    `json:"score"`
+		} `json:"releases"`
+	}
+	query := fmt.Sprintf(`release:"%s" AND artist:"%s"`, luceneEscape(title), luceneEscape(artist))
+	searchURL := p.baseURL + "/ws/2/release?fmt=json&limit=1&query=" + url.QueryEscape(query)
+	if err := getProviderJSON(ctx, p.client, searchURL, nil, &search); err != nil {
+		return nil, err
+	}
+	if len(search.Releases) == 0 || search.Releases[0].Score < minMusicBrainzMatchScore {
+		return nil, nil
+	}
+
+	var release struct {
+		Date      string               `json:"date"`
+		LabelInfo musicBrainzLabelInfo `json:"label-info"`
+		Media     []struct {
+			Tracks []struct {
+				Title  string `json:"title"`
+				Length int    `json:"length"` // Milliseconds
+			} `json:"tracks"`
+		} `json:"media"`
+	}
+	releaseURL := p.baseURL + "/ws/2/release/" + url.PathEscape(search.Releases[0].ID) + "?fmt=json&inc=labels+recordings"
+	if err := getProviderJSON(ctx, p.client, releaseURL, nil, &release); err != nil {
+		return nil, err
+	}
+
+	md := &albumMetadata{ReleaseDate: release.Date}
+	for _, info := range release.LabelInfo {
+		if info.Label != nil && md.Label == "" {
+			md.Label = info.Label.Name
+		}
+		if md.CatalogNumber == "" {
+			md.CatalogNumber = info.CatalogNumber
+		}
+	}
+	// Tracks of multi-disc releases are numbered through
+	for _, medium := range release.Media {
+		for _, t := range medium.Tracks {
+			md.Tracks = append(md.Tracks, Track{Position: len(md.Tracks) + 1, Title: t.Title, DurationSeconds: (t.Length + 500) / 1000})
+		}
+	}
+	return md, nil
+}
+
+// luceneEscape escapes s for use inside a quoted Lucene phrase
+func luceneEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// discogsProvider uses the Discogs API (https://www.discogs.com/developers), which requires a token
+type discogsProvider struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (p *discogsProvider) name() string { return "discogs" }
+
+func (p *discogsProvider) lookup(ctx context.Context, title, artist string) (*albumMetadata, error) {
+	header := http.Header{"Authorization": {"Discogs token=" + p.token}}
+	var search struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	query := url.Values{"type": {"release"}, "release_title": {title}, "artist": {artist}, "per_page": {"1"}}
+	if err := getProviderJSON(ctx, p.client, p.baseURL+"/database/search?"+query.Encode(), header, &search); err != nil {
+		return nil, err
+	}
+	if len(search.Results) == 0 {
+		return nil, nil
+	}
+
+	var release struct {
+		Released string `json:"released"`
+		Labels   []struct {
+			Name  string `json:"name"`
+			Catno string `json:"catno"`
+		} `json:"labels"`
+		Tracklist []struct {
+			Type     string `json:"type_"`
+			Title    string `json:"title"`
+			Duration string `json:"duration"` // "m:ss"
+		} `json:"tracklist"`
+	}
+	if err := getProviderJSON(ctx, p.client, p.baseURL+"/releases/"+strconv.Itoa(search.Results[0].ID), header, &release); err != nil {
+		return nil, err
+	}
+
+	// Discogs pads unknown date parts with zeros, e.g. "1991-00-00"
+	md := &albumMetadata{ReleaseDate: strings.TrimSuffix(strings.TrimSuffix(release.Released, "-00"), "-00")}
+	if len(release.Labels) > 0 {
+		md.Label = discogsArtistSuffix.ReplaceAllString(release.Labels[0].Name, "")
+		if release.Labels[0].Catno != "none" {
+			md.CatalogNumber = release.Labels[0].Catno
+		}
+	}
+	for _, t := range release.Tracklist {
+		if t.Type != "" && t.Type != "track" {
+			continue // Headings and index tracks
+		}
+		md.Tracks = append(md.Tracks, Track{Position: len(md.Tracks) + 1, Title: t.Title, DurationSeconds: parseTrackDuration(t.Duration)})
+	}
+	return md, nil
+}
+
+// parseTrackDuration parses "m:ss" or "h:mm:ss" durations; anything else is unknown (0)
+func parseTrackDuration(s string) int {
+	seconds := 0
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
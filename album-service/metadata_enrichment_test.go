@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMetadataProvider returns md, or err, for every lookup
+type fakeMetadataProvider struct {
+	md      *albumMetadata
+	err     error
+	delay   time.Duration
+	lookups int
+}
+
+func (p *fakeMetadataProvider) name() string { return "fake" }
+
+func (p *fakeMetadataProvider) lookup(ctx context.Context, title, artist string) (*albumMetadata, error) {
+	p.lookups++
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.md, p.err
+}
+
+func TestMusicBrainzProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, r.Header.Get("User-Agent"))
+		switch r.URL.Path {
+		case "/ws/2/release":
+			assert.Equal(t, `release:"Nevermind" AND artist:"Nirvana"`, r.URL.Query().Get("query"))
+			w.Write([]byte(`{"releases":[{"id":"b52a8f31","score":100}]}`))
+		case "/ws/2/release/b52a8f31":
+			w.Write([]byte(`{"date":"1991-09-24","label-info":[{"catalog-number":"DGC-24425","label":{"name":"DGC"}}],
+				"media":[{"tracks":[{"title":"Smells Like Teen Spirit","length":301920},{"title":"In Bloom","length":254800}]},
+				{"tracks":[{"title":"Endless, Nameless","length":403000}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := &musicBrainzProvider{baseURL: server.URL, client: server.Client()}
+	md, err := p.lookup(context.Background(), "Nevermind", "Nirvana")
+	require.NoError(t, err)
+	assert.Equal(t, &albumMetadata{ReleaseDate: "1991-09-24", Label: "DGC", CatalogNumber: "DGC-24425", Tracks: []Track{
+		{Position: 1, Title: "Smells Like Teen Spirit", DurationSeconds: 302},
+		{Position: 2, Title: "In Bloom", DurationSeconds: 255},
+		{Position: 3, Title: "Endless, Nameless", DurationSeconds: 403},
+	}}, md)
+}
+
+func TestMusicBrainzProvider_WeakMatchIsNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"releases":[{"id":"x","score":61}]}`))
+	}))
+	defer server.Close()
+
+	p := &musicBrainzProvider{baseURL: server.URL, client: server.Client()}
+	md, err := p.lookup(context.Background(), "Nevermind", "Nirvana")
+	require.NoError(t, err)
+	assert.Nil(t, md)
+}
+
+func TestDiscogsProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Discogs token=secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/database/search":
+			assert.Equal(t, "Blue Train", r.URL.Query().Get("release_title"))
+			w.Write([]byte(`{"results":[{"id":1234}]}`))
+		case "/releases/1234":
+			w.Write([]byte(`{"released":"1958-00-00","labels":[{"name":"Blue Note (2)","catno":"BLP 1577"}],
+				"tracklist":[{"type_":"heading","title":"Side A"},{"type_":"track","title":"Blue Train","duration":"10:43"},
+				{"type_":"track","title":"Moment's Notice","duration":""}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := &discogsProvider{baseURL: server.URL, token: "secret", client: server.Client()}
+	md, err := p.lookup(context.Background(), "Blue Train", "John Coltrane")
+	require.NoError(t, err)
+	assert.Equal(t, &albumMetadata{ReleaseDate: "1958", Label: "Blue Note", CatalogNumber: "BLP 1577", Tracks: []Track{
+		{Position: 1, Title: "Blue Train", DurationSeconds: 643},
+		{Position: 2, Title: "Moment's Notice"},
+	}}, md)
+}
+
+func TestAlbumEnricher(t *testing.T) {
+	t.Run("Fills only missing fields", func(t *testing.T) {
+		provider := &fakeMetadataProvider{md: &albumMetadata{ReleaseDate: "1991-09-24", Label: "DGC", CatalogNumber: "DGC-24425",
+			Tracks: []Track{{Position: 1, Title: "Smells Like Teen Spirit"}}}}
+		a := Album{Title: "Nevermind", Artist: "Nirvana", Label: "Geffen"}
+		assert.Equal(t, enrichmentEnriched, newAlbumEnricher(provider, time.Second).enrich(context.Background(), &a))
+		assert.Equal(t, "1991-09-24", a.ReleaseDate)
+		assert.Equal(t, "Geffen", a.Label)
+		assert.Equal(t, "DGC-24425", a.CatalogNumber)
+		assert.Len(t, a.Tracks, 1)
+	})
+
+	t.Run("Complete albums are not looked up", func(t *testing.T) {
+		provider := &fakeMetadataProvider{}
+		a := Album{ReleaseDate: "1991", Label: "DGC", CatalogNumber: "DGC-24425", Tracks: []Track{{Position: 1, Title: "Polly"}}}
+		assert.Equal(t, "", newAlbumEnricher(provider, time.Second).enrich(context.Background(), &a))
+		assert.Zero(t, provider.lookups)
+	})
+
+	t.Run("Lookups time out", func(t *testing.T) {
+		provider := &fakeMetadataProvider{md: &albumMetadata{Label: "DGC"}, delay: time.Second}
+		a := Album{Title: "Nevermind", Artist: "Nirvana"}
+		start := time.Now()
+		assert.Equal(t, enrichmentError, newAlbumEnricher(provider, 10*time.Millisecond).enrich(context.Background(), &a))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Empty(t, a.Label)
+	})
+
+	t.Run("Repeated failures open the circuit", func(t *testing.T) {
+		now := time.Now()
+		provider := &fakeMetadataProvider{err: errors.New("503 Service Unavailable")}
+		enricher := newAlbumEnricher(provider, time.Second)
+		enricher.breaker.now = func() time.Time { return now }
+
+		for i := 0; i < metadataBreakerThreshold; i++ {
+			assert.Equal(t, enrichmentError, enricher.enrich(context.Background(), &Album{}))
+		}
+		assert.Equal(t, enrichmentCircuitOpen, enricher.enrich(context.Background(), &Album{}))
+		assert.Equal(t, metadataBreakerThreshold, provider.lookups)
+
+		// After the cooldown one trial goes through; a failed trial opens the circuit again
+		now = now.Add(metadataBreakerCooldown)
+		assert.Equal(t, enrichmentError, enricher.enrich(context.Background(), &Album{}))
+		assert.Equal(t, enrichmentCircuitOpen, enricher.enrich(context.Background(), &Album{}))
+
+		// A successful trial closes it
+		now = now.Add(metadataBreakerCooldown)
+		provider.err = nil
+		assert.Equal(t, enrichmentNotFound, enricher.enrich(context.Background(), &Album{}))
+		assert.Equal(t, enrichmentNotFound, enricher.enrich(context.Background(), &Album{}))
+	})
+}
+
+func TestCreateAlbum_Enrichment(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalEnricher, originalWriter := db, metadataEnricher, kafkaWriter
+	db, kafkaWriter = mockDB, &recordingWriter{}
+	t.Cleanup(func() { db, metadataEnricher, kafkaWriter = originalDB, originalEnricher, originalWriter })
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/albums", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Missing fields are filled before the insert", func(t *testing.T) {
+		metadataEnricher = newAlbumEnricher(&fakeMetadataProvider{md: &albumMetadata{ReleaseDate: "1991-09-24", Label: "DGC",
+			Tracks: []Track{{Position: 1, Title: "Smells Like Teen Spirit", DurationSeconds: 301}}}}, time.Second)
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").
+			WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", "1991-09-24", "DGC",
+				[]byte(`[{"position":1,"title":"Smells Like Teen Spirit","durationSeconds":301}]`), []byte(nil)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectCommit()
+
+		rr := create(`{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"label":"DGC"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("A failing provider doesn't fail the create", func(t *testing.T) {
+		metadataEnricher = newAlbumEnricher(&fakeMetadataProvider{err: errors.New("connection refused")}, time.Second)
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").
+			WithArgs("Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", "", "", sqlmock.AnyArg(), []byte(nil)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectCommit()
+
+		rr := create(`{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock"}`)
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid release dates are rejected", func(t *testing.T) {
+		metadataEnricher = nil
+		rr := create(`{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock","releaseDate":"24/09/1991"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
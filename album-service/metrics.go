@@ -0,0 +1,106 @@
+// metrics.go - Prometheus metrics for album-service, exposed on /metrics
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// eventSchemaVersionsObserved counts consumed events per schema version, to tell when an old
+	// version is no longer produced and can be retired.
+	eventSchemaVersionsObserved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_event_schema_versions_observed_total",
+		Help: "Consumed events by topic and payload schema version.",
+	}, []string{"topic", "version"})
+
+	// unhandledEventTypes counts consumed events skipped because no handler takes their eventType,
+	// e.g. a type a producer added to a topic before this service handles it.
+	unhandledEventTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_unhandled_event_types_total",
+		Help: "Consumed events skipped because their event type has no handler, by topic.",
+	}, []string{"topic"})
+
+	// metadataEnrichments counts metadata lookups on album creation by outcome (see metadata_enrichment.go)
+	metadataEnrichments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_metadata_enrichments_total",
+		Help: "Metadata provider lookups for new albums by provider and result.",
+	}, []string{"provider", "result"})
+
+	// circuitBreakerOpen and circuitBreakerRejections report the circuit breakers of the database, the
+	// Kafka writers and the metadata provider (see circuit_breaker.go)
+	circuitBreakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "album_circuit_breaker_open",
+		Help: "Whether the circuit breaker of a dependency is open (1) or closed (0).",
+	}, []string{"dependency"})
+	circuitBreakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_circuit_breaker_rejections_total",
+		Help: "Calls and requests refused while the circuit of a dependency was open.",
+	}, []string{"dependency"})
+
+	// coldStorageMoves counts albums moved to cold storage and restored from it (see cold_storage.go)
+	coldStorageMoves = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_cold_storage_moves_total",
+		Help: "Albums moved to cold storage (frozen) or restored from it (restored).",
+	}, []string{"direction"})
+
+	// deprecatedAPIRequests counts requests to deprecated API versions, to tell when one can be removed
+	// (see api_versions.go)
+	deprecatedAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_deprecated_api_requests_total",
+		Help: "Requests to deprecated API versions by version prefix, method and route.",
+	}, []string{"version", "method", "route"})
+
+	// webhookDeliveries counts webhook delivery attempts by the delivery's resulting status:
+	// delivered, pending (to be retried) or failed (see webhooks.go)
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by resulting delivery status.",
+	}, []string{"status"})
+
+	// albumCacheLookups counts lookups through the Redis album cache by result: hit, miss or error
+	// (see redis_cache.go)
+	albumCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_cache_lookups_total",
+		Help: "Album lookups through the Redis cache by result.",
+	}, []string{"result"})
+
+	// apiKeyRequests counts API key checks by the route's scope and result: accepted, missing,
+	// invalid, forbidden or error (see api_keys.go)
+	apiKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_api_key_requests_total",
+		Help: "API key checks by scope and result.",
+	}, []string{"scope", "result"})
+
+	// kafkaAsyncWriteFailures counts the events async Kafka writers failed to deliver, by topic
+	// (KAFKA_PRODUCER_ASYNC=true, see kafka_producer.go). Sync writers return the error to the caller.
+	kafkaAsyncWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_kafka_async_write_failures_total",
+		Help: "Events async Kafka writers failed to deliver, by topic.",
+	}, []string{"topic"})
+
+	// publishRetriesQueued is the number of events waiting in the publish retry buffer (see publish_retry.go)
+	publishRetriesQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "album_publish_retries_queued",
+		Help: "Events whose Kafka publish failed, waiting to be retried.",
+	})
+
+	// publishRetriesDelivered counts queued events a retry delivered, by topic
+	publishRetriesDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_publish_retries_delivered_total",
+		Help: "Events delivered by a publish retry after their first publish failed, by topic.",
+	}, []string{"topic"})
+
+	// publishRetriesDropped counts failed publishes that didn't fit in the retry buffer and are lost
+	publishRetriesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_publish_retries_dropped_total",
+		Help: "Events lost because the publish retry buffer was full, by topic.",
+	}, []string{"topic"})
+
+	// dbReplicaUp is 1 while the read replica answers its health checks (see read_replica.go)
+	dbReplicaUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "album_db_replica_up",
+		Help: "Whether the read replica is up; album reads use the primary while it is 0.",
+	})
+)
@@ -0,0 +1,198 @@
+// migrations.go - versioned schema migrations, embedded in the binary and applied with goose. They run
+// on startup unless DB_MIGRATE_ON_STARTUP=false, in which case `album-service migrate` applies them
+// (for example from a pre-deploy job) and an instance with pending migrations refuses to start.
+//
+// Migrations are the SQL files of migrations/ plus the Go migrations registered below, numbered
+// together. To add a column or table, add the next numbered file rather than editing an applied one:
+// an applied version never runs again. Versions are recorded in album_schema_migrations, separate from
+// inventory-service's table in the same database, and a Postgres advisory lock keeps instances
+// starting together from applying them twice.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const (
+	migrationsTable = "album_schema_migrations"
+	// migrationLockID is the advisory lock held while migrating. It differs from inventory-service's
+	// so that the two services don't wait for each other.
+	migrationLockID int64 = 0x616c62756d // "album"
+)
+
+// migrateOnStartup is false when DB_MIGRATE_ON_STARTUP=false
+var migrateOnStartup = true
+
+// loadMigrationConfig reads DB_MIGRATE_ON_STARTUP
+func loadMigrationConfig() error {
+	v := os.Getenv("DB_MIGRATE_ON_STARTUP")
+	if v == "" {
+		migrateOnStartup = true
+		return nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("DB_MIGRATE_ON_STARTUP %q must be true or false", v)
+	}
+	migrateOnStartup = on
+	return nil
+}
+
+// newMigrationProvider returns the goose provider applying the migrations to db
+func newMigrationProvider(db *sql.DB) (*goose.Provider, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	store, err := database.NewStore(database.DialectPostgres, migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lock.NewPostgresSessionLocker(lock.WithLockID(migrationLockID))
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider("", db, fsys,
+		goose.WithStore(store),
+		goose.WithSessionLocker(locker),
+		goose.WithGoMigrations(
+			goose.NewGoMigration(1, &goose.GoFunc{RunTx: migratePricesToCents}, nil),
+		),
+	)
+}
+
+// runMigrations applies the pending migrations to db
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	provider, err := newMigrationProvider(db)
+	if err != nil {
+		return err
+	}
+	results, err := provider.Up(ctx)
+	for _, r := range results {
+		log.Printf("Migration %s", strings.TrimSpace(r.String()))
+	}
+	if len(results) > 0 {
+		// Migrations may rewrite albums, e.g. normalizing their genres
+		invalidateAllAlbums(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	version, err := provider.GetDBVersion(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Database schema is at migration %d", version)
+	return nil
+}
+
+// migrationState reads the schema version of db and lists the migrations not yet applied to it. It
+// only reads, unlike the provider's Status, which creates the version table and waits for the
+// migration lock.
+func migrationState(ctx context.Context, db *sql.DB) (int64, []string, error) {
+	provider, err := newMigrationProvider(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	applied := map[int64]bool{}
+	var version int64
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", migrationsTable).Scan(&exists); err != nil {
+		return 0, nil, err
+	}
+	if exists {
+		rows, err := db.QueryContext(ctx, "SELECT version_id FROM "+migrationsTable+" WHERE is_applied")
+		if err != nil {
+			return 0, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int64
+			if err := rows.Scan(&v); err != nil {
+				return 0, nil, err
+			}
+			applied[v] = true
+			version = max(version, v)
+		}
+		if err := rows.Err(); err != nil {
+			return 0, nil, err
+		}
+	}
+	pending := []string{}
+	for _, s := range provider.ListSources() {
+		if !applied[s.Version] {
+			pending = append(pending, migrationName(s))
+		}
+	}
+	return version, pending, nil
+}
+
+// migrationName names a migration by its file, or by version for Go migrations
+func migrationName(s *goose.Source) string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return fmt.Sprintf("%05d (go)", s.Version)
+}
+
+// runMigrateCommand implements `album-service migrate [up|status]` and returns the exit code
+func runMigrateCommand(args []string, out io.Writer) int {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if command != "up" && command != "status" {
+		fmt.Fprintf(out, "unknown migrate command %q, expected up or status\n", command)
+		return 2
+	}
+	ctx := context.Background()
+	conn, err := sql.Open("pgx", dbConnectionFromEnv())
+	if err != nil {
+		fmt.Fprintf(out, "could not open database: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	if command == "up" {
+		if err := runMigrations(ctx, conn); err != nil {
+			fmt.Fprintf(out, "migration failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	provider, err := newMigrationProvider(conn)
+	if err != nil {
+		fmt.Fprintf(out, "could not load migrations: %v\n", err)
+		return 1
+	}
+	statuses, err := provider.Status(ctx)
+	if err != nil {
+		fmt.Fprintf(out, "could not read migration status: %v\n", err)
+		return 1
+	}
+	for _, s := range statuses {
+		applied := ""
+		if s.State == goose.StateApplied {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%-8s %-19s %s\n", s.State, applied, migrationName(s.Source))
+	}
+	return 0
+}
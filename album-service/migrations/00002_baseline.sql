@@ -0,0 +1,430 @@
+-- The schema album-service created on startup before it had migrations. Every statement is
+-- idempotent, so databases created that way adopt it without changes.
+
+-- +goose Up
+CREATE TABLE IF NOT EXISTS albums (
+	id SERIAL PRIMARY KEY,
+	title VARCHAR(100) NOT NULL,
+	artist VARCHAR(100) NOT NULL,
+	price_cents BIGINT NOT NULL,
+	release_year INTEGER NOT NULL,
+	genre VARCHAR(50) NOT NULL
+);
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS format VARCHAR(50);
+
+-- Popularity (popularity.go) and reviews (reviews.go)
+ALTER TABLE albums
+	ADD COLUMN IF NOT EXISTS average_rating NUMERIC(3,2) NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS review_count INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS popularity_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS stats_updated_at TIMESTAMP;
+CREATE TABLE IF NOT EXISTS album_reviews (
+	id SERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+	rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_album_reviews_album ON album_reviews (album_id);
+ALTER TABLE album_reviews
+	ADD COLUMN IF NOT EXISTS author VARCHAR(100),
+	ADD COLUMN IF NOT EXISTS comment TEXT;
+CREATE TABLE IF NOT EXISTS album_daily_views (
+	album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+	day DATE NOT NULL,
+	views BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (album_id, day)
+);
+CREATE TABLE IF NOT EXISTS album_sales (
+	order_id VARCHAR(255) PRIMARY KEY,
+	album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+	units INTEGER NOT NULL,
+	sold_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_album_sales_album_sold_at ON album_sales (album_id, sold_at);
+
+-- Discogs imports (discogs_import.go)
+CREATE TABLE IF NOT EXISTS album_imports (
+	id VARCHAR(32) PRIMARY KEY,
+	source VARCHAR(20) NOT NULL,
+	items JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	confirmed_at TIMESTAMP
+);
+
+-- Price floor overrides (price_floor.go)
+CREATE TABLE IF NOT EXISTS price_floor_overrides (
+	id SERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL,
+	operation VARCHAR(20) NOT NULL,
+	price_cents BIGINT NOT NULL,
+	floor_cents BIGINT NOT NULL,
+	reason TEXT NOT NULL,
+	client_ip VARCHAR(64),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Album history (album_history.go)
+CREATE TABLE IF NOT EXISTS albums_history (
+	id BIGSERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL,
+	title VARCHAR(100) NOT NULL,
+	artist VARCHAR(100) NOT NULL,
+	price_cents BIGINT NOT NULL,
+	release_year INTEGER NOT NULL,
+	genre VARCHAR(50) NOT NULL,
+	format VARCHAR(50),
+	valid_from TIMESTAMPTZ NOT NULL,
+	valid_to TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS albums_history_album_idx ON albums_history (album_id, valid_from);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_album_history() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE albums_history SET valid_to = now() WHERE album_id = OLD.id AND valid_to IS NULL;
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		INSERT INTO albums_history (album_id, title, artist, price_cents, release_year, genre, format, valid_from)
+		VALUES (NEW.id, NEW.title, NEW.artist, NEW.price_cents, NEW.release_year, NEW.genre, NEW.format, now());
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Existing albums get their first version along with the trigger. Postgres 13 has no CREATE OR
+-- REPLACE TRIGGER.
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE albums IN SHARE ROW EXCLUSIVE MODE;
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'albums_history_trigger') THEN
+		CREATE TRIGGER albums_history_trigger
+			AFTER INSERT OR DELETE OR UPDATE OF title, artist, price_cents, release_year, genre, format ON albums
+			FOR EACH ROW EXECUTE FUNCTION record_album_history();
+		INSERT INTO albums_history (album_id, title, artist, price_cents, release_year, genre, format, valid_from)
+		SELECT a.id, a.title, a.artist, a.price_cents, a.release_year, a.genre, a.format, now() FROM albums a
+		WHERE NOT EXISTS (SELECT 1 FROM albums_history h WHERE h.album_id = a.id);
+	END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- Barcodes and catalog numbers (album_identifiers.go), metadata (metadata_enrichment.go),
+-- attributes (album_attributes.go) and status (album_status.go)
+ALTER TABLE albums
+	ADD COLUMN IF NOT EXISTS upc VARCHAR(13),
+	ADD COLUMN IF NOT EXISTS catalog_number VARCHAR(50);
+CREATE UNIQUE INDEX IF NOT EXISTS albums_upc_key ON albums (upc);
+ALTER TABLE albums
+	ADD COLUMN IF NOT EXISTS label VARCHAR(200),
+	ADD COLUMN IF NOT EXISTS release_date VARCHAR(10),
+	ADD COLUMN IF NOT EXISTS tracks JSONB;
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS attributes JSONB;
+CREATE INDEX IF NOT EXISTS albums_attributes_idx ON albums USING GIN (attributes jsonb_path_ops);
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'published'
+	CHECK (status IN ('draft', 'published', 'archived'));
+
+-- Versions for optimistic concurrency (album_version.go)
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION bump_album_version() RETURNS trigger AS $$
+BEGIN
+	NEW.version := OLD.version + 1;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE albums IN SHARE ROW EXCLUSIVE MODE;
+	DROP TRIGGER IF EXISTS albums_version_trigger ON albums;
+	CREATE TRIGGER albums_version_trigger
+		BEFORE UPDATE OF title, artist, price_cents, release_year, genre, format, upc, catalog_number, attributes, status ON albums
+		FOR EACH ROW EXECUTE FUNCTION bump_album_version();
+END
+$$;
+-- +goose StatementEnd
+
+-- Price history (price_history.go). The source, reason and client come from transaction-local
+-- settings (see setPriceChangeContext).
+CREATE TABLE IF NOT EXISTS price_history (
+	id BIGSERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL,
+	old_price_cents BIGINT,
+	new_price_cents BIGINT NOT NULL,
+	source VARCHAR(20) NOT NULL,
+	reason TEXT,
+	changed_by VARCHAR(64),
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS price_history_album_idx ON price_history (album_id, changed_at);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_price_change() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' OR NEW.price_cents IS DISTINCT FROM OLD.price_cents THEN
+		INSERT INTO price_history (album_id, old_price_cents, new_price_cents, source, reason, changed_by)
+		VALUES (
+			NEW.id,
+			CASE WHEN TG_OP = 'UPDATE' THEN OLD.price_cents END,
+			NEW.price_cents,
+			COALESCE(NULLIF(current_setting('album_store.price_source', true), ''),
+				CASE WHEN TG_OP = 'INSERT' THEN 'create' ELSE 'unknown' END),
+			NULLIF(current_setting('album_store.price_reason', true), ''),
+			NULLIF(current_setting('album_store.price_changed_by', true), ''));
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE albums IN SHARE ROW EXCLUSIVE MODE;
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'albums_price_history_trigger') THEN
+		CREATE TRIGGER albums_price_history_trigger
+			AFTER INSERT OR UPDATE OF price_cents ON albums
+			FOR EACH ROW EXECUTE FUNCTION record_price_change();
+		INSERT INTO price_history (album_id, new_price_cents, source)
+		SELECT a.id, a.price_cents, 'initial' FROM albums a
+		WHERE NOT EXISTS (SELECT 1 FROM price_history h WHERE h.album_id = a.id);
+	END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- Audit log (album_audit.go). The client comes from a transaction-local setting (see setAuditActor).
+CREATE TABLE IF NOT EXISTS album_audit (
+	id BIGSERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL,
+	action VARCHAR(10) NOT NULL,
+	changes JSONB NOT NULL,
+	changed_by VARCHAR(64),
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS album_audit_album_idx ON album_audit (album_id, changed_at);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_album_audit() RETURNS trigger AS $$
+DECLARE
+	ignored TEXT[] := ARRAY['id', 'version', 'average_rating', 'review_count', 'popularity_score', 'stats_updated_at', 'artist_id'];
+	old_row JSONB := CASE WHEN TG_OP <> 'INSERT' THEN to_jsonb(OLD) - ignored END;
+	new_row JSONB := CASE WHEN TG_OP <> 'DELETE' THEN to_jsonb(NEW) - ignored END;
+	changes JSONB;
+BEGIN
+	SELECT jsonb_object_agg(k.key, jsonb_build_object('old', old_row->k.key, 'new', new_row->k.key))
+	INTO changes
+	FROM jsonb_object_keys(COALESCE(old_row, new_row)) AS k(key)
+	WHERE COALESCE(old_row->k.key, 'null') IS DISTINCT FROM COALESCE(new_row->k.key, 'null');
+	IF changes IS NULL THEN
+		RETURN NULL;
+	END IF;
+	INSERT INTO album_audit (album_id, action, changes, changed_by)
+	VALUES (
+		CASE WHEN TG_OP = 'DELETE' THEN OLD.id ELSE NEW.id END,
+		CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+		changes,
+		NULLIF(current_setting('album_store.changed_by', true), ''));
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE albums IN SHARE ROW EXCLUSIVE MODE;
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'albums_audit_trigger') THEN
+		CREATE TRIGGER albums_audit_trigger
+			AFTER INSERT OR UPDATE OR DELETE ON albums
+			FOR EACH ROW EXECUTE FUNCTION record_album_audit();
+	END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- Webhooks (webhooks.go): every audit log entry queues a delivery to the subscribed webhooks
+CREATE TABLE IF NOT EXISTS webhooks (
+	id SERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret VARCHAR(64) NOT NULL,
+	actions TEXT[] NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	created_by VARCHAR(64)
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	audit_id BIGINT NOT NULL,
+	action VARCHAR(10) NOT NULL,
+	album_id INTEGER NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_status_code INTEGER,
+	last_error TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	delivered_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION queue_webhook_deliveries() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO webhook_deliveries (webhook_id, audit_id, action, album_id)
+	SELECT id, NEW.id, NEW.action, NEW.album_id FROM webhooks WHERE NEW.action = ANY(actions);
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE album_audit IN SHARE ROW EXCLUSIVE MODE;
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'album_audit_webhook_trigger') THEN
+		CREATE TRIGGER album_audit_webhook_trigger
+			AFTER INSERT ON album_audit
+			FOR EACH ROW EXECUTE FUNCTION queue_webhook_deliveries();
+	END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- Artists (artists.go): every album insert or artist name change links the album to the artist of
+-- that name, creating it when it's new
+CREATE TABLE IF NOT EXISTS artists (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS artists_name_idx ON artists (lower(name));
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS artist_id INTEGER REFERENCES artists(id);
+CREATE INDEX IF NOT EXISTS albums_artist_id_idx ON albums (artist_id);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION link_album_artist() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO artists (name) VALUES (NEW.artist) ON CONFLICT ((lower(name))) DO NOTHING;
+	SELECT id INTO NEW.artist_id FROM artists WHERE lower(name) = lower(NEW.artist);
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+BEGIN
+	LOCK TABLE albums IN SHARE ROW EXCLUSIVE MODE;
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'albums_artist_trigger') THEN
+		CREATE TRIGGER albums_artist_trigger
+			BEFORE INSERT OR UPDATE OF artist ON albums
+			FOR EACH ROW EXECUTE FUNCTION link_album_artist();
+	END IF;
+	INSERT INTO artists (name)
+	SELECT DISTINCT ON (lower(artist)) artist FROM albums WHERE artist_id IS NULL ORDER BY lower(artist), id
+	ON CONFLICT ((lower(name))) DO NOTHING;
+	UPDATE albums a SET artist_id = ar.id FROM artists ar
+	WHERE a.artist_id IS NULL AND lower(ar.name) = lower(a.artist);
+END
+$$;
+-- +goose StatementEnd
+
+-- Genre taxonomy (genres.go), seeded so a fresh installation can create albums right away, then
+-- backfilled with the genres in use, each spelled as most of its albums spell it
+CREATE TABLE IF NOT EXISTS genres (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS genres_name_idx ON genres (lower(name));
+LOCK TABLE genres IN SHARE ROW EXCLUSIVE MODE;
+INSERT INTO genres (name)
+SELECT unnest(ARRAY['Blues', 'Classical', 'Country', 'Electronic', 'Folk', 'Hip-Hop', 'Jazz',
+	'Metal', 'Pop', 'Punk', 'R&B', 'Reggae', 'Rock', 'Soul', 'Unknown'])
+WHERE NOT EXISTS (SELECT 1 FROM genres);
+INSERT INTO genres (name)
+SELECT DISTINCT ON (lower(genre)) genre FROM albums
+GROUP BY genre ORDER BY lower(genre), COUNT(*) DESC, genre
+ON CONFLICT ((lower(name))) DO NOTHING;
+UPDATE albums a SET genre = g.name FROM genres g WHERE lower(a.genre) = lower(g.name) AND a.genre <> g.name;
+
+-- Clearance proposals (clearance.go). decided_by is the client IP of the manager, or "auto".
+CREATE TABLE IF NOT EXISTS price_proposals (
+	id SERIAL PRIMARY KEY,
+	album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+	current_price_cents BIGINT NOT NULL,
+	proposed_price_cents BIGINT NOT NULL,
+	quantity INTEGER NOT NULL,
+	idle_days INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	decided_at TIMESTAMP,
+	decided_by VARCHAR(64)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS price_proposals_pending_idx ON price_proposals (album_id) WHERE status = 'pending';
+
+-- Catalog change approval (pending_changes.go)
+CREATE TABLE IF NOT EXISTS pending_changes (
+	id SERIAL PRIMARY KEY,
+	action VARCHAR(10) NOT NULL,
+	album_id INTEGER REFERENCES albums(id) ON DELETE CASCADE,
+	base_version INTEGER,
+	payload JSONB NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	submitted_by VARCHAR(64) NOT NULL,
+	submitted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	decided_by VARCHAR(64),
+	decided_at TIMESTAMP,
+	reason TEXT
+);
+CREATE INDEX IF NOT EXISTS pending_changes_status_idx ON pending_changes (status, submitted_at);
+
+-- Promotions (promotions.go)
+CREATE TABLE IF NOT EXISTS promotions (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	code VARCHAR(32) UNIQUE,
+	percent_off NUMERIC(5,2),
+	amount_off_cents BIGINT,
+	album_id INTEGER REFERENCES albums(id) ON DELETE CASCADE,
+	genre VARCHAR(50),
+	starts_at TIMESTAMPTZ NOT NULL,
+	ends_at TIMESTAMPTZ NOT NULL,
+	start_published_at TIMESTAMPTZ,
+	end_published_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	created_by VARCHAR(64),
+	CHECK ((percent_off IS NULL) <> (amount_off_cents IS NULL)),
+	CHECK (ends_at > starts_at)
+);
+CREATE INDEX IF NOT EXISTS promotions_window_idx ON promotions (ends_at, starts_at);
+
+-- Daily sales summaries (daily_sales.go)
+ALTER TABLE album_sales
+	ADD COLUMN IF NOT EXISTS revenue_cents BIGINT,
+	ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+CREATE INDEX IF NOT EXISTS idx_album_sales_sold_at ON album_sales (sold_at);
+CREATE TABLE IF NOT EXISTS daily_sales_days (
+	day DATE PRIMARY KEY,
+	time_zone VARCHAR(64) NOT NULL,
+	summarized_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	published_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS daily_sales (
+	day DATE NOT NULL REFERENCES daily_sales_days(day) ON DELETE CASCADE,
+	album_id INTEGER NOT NULL,
+	currency VARCHAR(3) NOT NULL DEFAULT '',
+	units INTEGER NOT NULL,
+	orders INTEGER NOT NULL,
+	revenue_cents BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, album_id, currency)
+);
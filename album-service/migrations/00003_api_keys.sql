@@ -0,0 +1,18 @@
+-- API keys for machine clients (api_keys.go). Only the SHA-256 of a key is stored.
+
+-- +goose Up
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	key_prefix VARCHAR(16) NOT NULL,
+	key_hash CHAR(64) NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	created_by VARCHAR(45),
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	revoked_by VARCHAR(45)
+);
+
+-- +goose Down
+DROP TABLE api_keys;
@@ -0,0 +1,15 @@
+-- Events still waiting for a Kafka publish retry when an instance shut down (publish_retry.go)
+
+-- +goose Up
+CREATE TABLE album_publish_retries (
+	id BIGSERIAL PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	message_key BYTEA,
+	message_value BYTEA NOT NULL,
+	headers JSONB NOT NULL DEFAULT '[]',
+	attempts INT NOT NULL,
+	saved_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE album_publish_retries;
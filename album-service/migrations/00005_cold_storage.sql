@@ -0,0 +1,15 @@
+-- Albums the cold storage job moved out of the albums table (cold_storage.go). album is the albums
+-- row and related the rows of the tables referencing it, by table, both as to_jsonb made them.
+
+-- +goose Up
+CREATE TABLE albums_cold (
+	album_id INTEGER PRIMARY KEY,
+	title VARCHAR(100) NOT NULL,
+	artist VARCHAR(100) NOT NULL,
+	album JSONB NOT NULL,
+	related JSONB NOT NULL,
+	frozen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE albums_cold;
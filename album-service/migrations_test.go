@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationSources(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	provider, err := newMigrationProvider(mockDB)
+	require.NoError(t, err)
+	var names []string
+	for _, s := range provider.ListSources() {
+		names = append(names, migrationName(s))
+	}
+	assert.Equal(t, []string{"00001 (go)", "00002_baseline.sql", "00003_api_keys.sql", "00004_publish_retries.sql", "00005_cold_storage.sql"}, names)
+}
+
+func TestMigrationState(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	t.Run("New database", func(t *testing.T) {
+		mock.ExpectQuery("SELECT to_regclass").WithArgs(migrationsTable).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		version, pending, err := migrationState(context.Background(), mockDB)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), version)
+		assert.Equal(t, []string{"00001 (go)", "00002_baseline.sql", "00003_api_keys.sql", "00004_publish_retries.sql", "00005_cold_storage.sql"}, pending)
+	})
+
+	t.Run("Migrated database", func(t *testing.T) {
+		mock.ExpectQuery("SELECT to_regclass").WithArgs(migrationsTable).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT version_id FROM album_schema_migrations WHERE is_applied").
+			WillReturnRows(sqlmock.NewRows([]string{"version_id"}).AddRow(0).AddRow(1).AddRow(2).AddRow(3).AddRow(4).AddRow(5))
+		version, pending, err := migrationState(context.Background(), mockDB)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), version)
+		assert.Empty(t, pending)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadMigrationConfig(t *testing.T) {
+	t.Cleanup(func() { migrateOnStartup = true })
+
+	t.Setenv("DB_MIGRATE_ON_STARTUP", "false")
+	require.NoError(t, loadMigrationConfig())
+	assert.False(t, migrateOnStartup)
+
+	t.Setenv("DB_MIGRATE_ON_STARTUP", "")
+	require.NoError(t, loadMigrationConfig())
+	assert.True(t, migrateOnStartup)
+
+	t.Setenv("DB_MIGRATE_ON_STARTUP", "sometimes")
+	assert.EqualError(t, loadMigrationConfig(), `DB_MIGRATE_ON_STARTUP "sometimes" must be true or false`)
+}
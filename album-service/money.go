@@ -0,0 +1,203 @@
+// money.go - prices as integer cents. Prices are stored, compared and computed in cents, so no amount
+// is ever rounded through float64. JSON keeps the decimal numbers clients always got (1999 cents is
+// 19.99), so order-service and event consumers read the same payloads as before.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Cents is an amount of money in minor units
+type Cents int64
+
+var errInvalidAmount = errors.New("expected a decimal amount with at most two decimal places, e.g. 19.99")
+
+// parseCents reads a decimal amount such as "19.99", "20" or "7.5". More than two decimal places
+// are rejected rather than rounded, unless they are zeros.
+func parseCents(s string) (Cents, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" || len(frac) > 2 || !isDigits(whole) || !isDigits(frac) {
+		return 0, errInvalidAmount
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100-1 {
+		return 0, errInvalidAmount
+	}
+	cents := units * 100
+	if frac != "" {
+		f, _ := strconv.Atoi((frac + "0")[:2])
+		cents += int64(f)
+	}
+	if negative {
+		cents = -cents
+	}
+	return Cents(cents), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount with two decimal places, e.g. "19.90"
+func (c Cents) String() string {
+	sign, abs := "", int64(c)
+	if abs < 0 {
+		sign, abs = "-", -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+// MarshalJSON writes the amount as a decimal number without trailing zeros (19.9, 20), as float
+// prices were written before
+func (c Cents) MarshalJSON() ([]byte, error) {
+	s := strings.TrimSuffix(strings.TrimRight(c.String(), "0"), ".")
+	return []byte(s), nil
+}
+
+// UnmarshalJSON reads a decimal number. Anything else, and numbers with more than two decimal
+// places, fail like a JSON type mismatch, so binding errors name the field.
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	cents, err := parseCents(s)
+	if err != nil {
+		value := "number " + s
+		switch {
+		case strings.HasPrefix(s, `"`):
+			value = "string"
+		case s == "true" || s == "false":
+			value = "bool"
+		case strings.HasPrefix(s, "{"):
+			value = "object"
+		case strings.HasPrefix(s, "["):
+			value = "array"
+		}
+		return &json.UnmarshalTypeError{Value: value, Type: reflect.TypeOf(*c)}
+	}
+	*c = cents
+	return nil
+}
+
+// Scan reads integer cents. Amounts computed in SQL must be cast to BIGINT cents too, so a decimal
+// is never mistaken for cents or the other way round.
+func (c *Cents) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*c = Cents(v)
+	case []byte:
+		return c.Scan(string(v))
+	case string:
+		cents, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("scan cents %q: not an integer", v)
+		}
+		*c = Cents(cents)
+	default:
+		return fmt.Errorf("cannot scan %T into Cents", src)
+	}
+	return nil
+}
+
+// Value stores the amount as BIGINT cents
+func (c Cents) Value() (driver.Value, error) {
+	return int64(c), nil
+}
+
+// percentOff returns the amount reduced by percent, rounded to the nearest cent
+func (c Cents) percentOff(percent float64) Cents {
+	return Cents(math.Round(float64(c) * (100 - percent) / 100))
+}
+
+// centsColumns are the price columns that used to be NUMERIC(10,2) amounts and are now BIGINT cents
+// under a new name
+var centsColumns = []struct{ Table, From, To string }{
+	{"albums", "price", "price_cents"},
+	{"albums_history", "price", "price_cents"},
+	{"price_history", "old_price", "old_price_cents"},
+	{"price_history", "new_price", "new_price_cents"},
+	{"price_floor_overrides", "price", "price_cents"},
+	{"price_floor_overrides", "floor", "floor_cents"},
+	{"price_proposals", "current_price", "current_price_cents"},
+	{"price_proposals", "proposed_price", "proposed_price_cents"},
+}
+
+// albumPriceTriggers depend on albums.price and are dropped before it changes type. The baseline
+// migration that follows creates them again.
+var albumPriceTriggers = []string{"albums_history_trigger", "albums_version_trigger", "albums_price_history_trigger"}
+
+// migratePricesToCents is migration 1 (see migrations.go). It converts the decimal price columns of a
+// database created before prices were cents, holding an exclusive lock on albums so nothing reads
+// half-migrated prices. On a new database there are no decimal columns and it does nothing.
+func migratePricesToCents(ctx context.Context, tx *sql.Tx) error {
+	pairs := make([]string, len(centsColumns))
+	for i, col := range centsColumns {
+		pairs[i] = fmt.Sprintf("('%s', '%s')", col.Table, col.From)
+	}
+	var pending int
+	err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND (table_name, column_name) IN ("+strings.Join(pairs, ", ")+")").
+		Scan(&pending)
+	if err != nil {
+		return fmt.Errorf("check for decimal prices: %w", err)
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE albums IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("lock albums: %w", err)
+	}
+	migrated := 0
+	for _, col := range centsColumns {
+		var exists bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)",
+			col.Table, col.From).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("check %s.%s: %w", col.Table, col.From, err)
+		}
+		if !exists {
+			continue
+		}
+		if col.Table == "albums" {
+			for _, trigger := range albumPriceTriggers {
+				if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger+" ON albums"); err != nil {
+					return fmt.Errorf("drop %s: %w", trigger, err)
+				}
+			}
+		}
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", col.Table, col.From, col.To),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE BIGINT USING round(%s * 100)", col.Table, col.To, col.To),
+		}
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migrate %s.%s to cents: %w", col.Table, col.From, err)
+			}
+		}
+		migrated++
+	}
+	log.Printf("Migrated %d price columns to cents", migrated)
+	return nil
+}
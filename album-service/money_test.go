@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCents(t *testing.T) {
+	for s, want := range map[string]Cents{"19.99": 1999, "20": 2000, "7.5": 750, "0.05": 5, "4.990": 499, " 12 ": 1200, "-1.25": -125} {
+		got, err := parseCents(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"", "19.999", "1e3", "abc", ".5", "1.2.3", "+1", "99999999999999999999"} {
+		_, err := parseCents(s)
+		assert.ErrorIs(t, err, errInvalidAmount, s)
+	}
+}
+
+func TestCentsJSON(t *testing.T) {
+	out, err := json.Marshal([]Cents{1999, 1990, 2000, 5})
+	require.NoError(t, err)
+	assert.Equal(t, "[19.99,19.9,20,0.05]", string(out))
+	assert.Equal(t, "19.90", Cents(1990).String())
+
+	var a Album
+	require.NoError(t, json.Unmarshal([]byte(`{"price":24.5}`), &a))
+	assert.Equal(t, Cents(2450), a.Price)
+
+	var typeErr *json.UnmarshalTypeError
+	assert.ErrorAs(t, json.Unmarshal([]byte(`{"price":19.999}`), &a), &typeErr, "no rounding")
+	assert.ErrorAs(t, json.Unmarshal([]byte(`{"price":"19.99"}`), &a), &typeErr)
+}
+
+func TestCentsScan(t *testing.T) {
+	var c Cents
+	require.NoError(t, c.Scan(int64(1999)))
+	assert.Equal(t, Cents(1999), c)
+	require.NoError(t, c.Scan([]byte("1649")))
+	assert.Equal(t, Cents(1649), c)
+	assert.Error(t, c.Scan("16.49"), "decimals are not cents")
+	assert.Error(t, c.Scan(16.49))
+}
+
+func TestCentsPercentOff(t *testing.T) {
+	assert.Equal(t, Cents(1499), Cents(1999).percentOff(25))
+	assert.Equal(t, Cents(1600), Cents(2000).percentOff(20))
+	assert.Equal(t, Cents(333), Cents(999).percentOff(66.66))
+}
@@ -0,0 +1,402 @@
+// pending_changes.go - optional editorial approval of catalog changes. With
+// CATALOG_CHANGE_APPROVAL=true, catalog editors (Client-Type: editor) propose album creates and
+// updates, which are validated and stored in pending_changes until an admin approves or rejects
+// them. Approving applies the change as the admin, through the same writes as POST and PUT, and
+// publishes the same events.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientTypeEditor is the Client-Type of catalog editors
+const clientTypeEditor = "editor"
+
+const maxPendingChangesListed = 500
+
+// Pending change actions
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+)
+
+// catalogApproval enables the proposal endpoints for editors
+var catalogApproval bool
+
+// PendingChange is a proposed album create or update awaiting, or past, an admin's decision. Its
+// statuses are those of price proposals; an update goes stale when the album changes before it is
+// approved.
+type PendingChange struct {
+	ID          int        `json:"id"`
+	Action      string     `json:"action"`
+	AlbumID     string     `json:"albumId,omitempty" id:"public"` // Set on creates once approved
+	BaseVersion int        `json:"baseVersion,omitempty"`         // The album version an update was based on
+	Album       Album      `json:"album"`
+	Status      string     `json:"status"`
+	SubmittedBy string     `json:"submittedBy"` // Client IP of the editor
+	SubmittedAt time.Time  `json:"submittedAt"`
+	DecidedBy   string     `json:"decidedBy,omitempty"` // Client IP of the admin
+	DecidedAt   *time.Time `json:"decidedAt,omitempty"`
+	Reason      string     `json:"reason,omitempty"` // Why the change was rejected
+}
+
+// loadCatalogApproval reads CATALOG_CHANGE_APPROVAL (default false)
+func loadCatalogApproval() error {
+	catalogApproval = false
+	if v := os.Getenv("CATALOG_CHANGE_APPROVAL"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CATALOG_CHANGE_APPROVAL %q is not a boolean", v)
+		}
+		catalogApproval = enabled
+	}
+	return nil
+}
+
+// requireCatalogEditor lets editors and admins through when catalog approval is enabled
+func requireCatalogEditor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !catalogApproval {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Catalog change approval is not enabled"})
+			return
+		}
+		switch c.GetHeader("Client-Type") {
+		case clientTypeEditor, profileAdmin:
+			c.Next()
+		default:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden: Editor privileges required"})
+		}
+	}
+}
+
+// proposeAlbumCreate handles POST /api/albums/changes: the body is the album to create, validated
+// and enriched as by POST /api/albums
+func proposeAlbumCreate(c *gin.Context) {
+	var a Album
+	if err := c.ShouldBindJSON(&a); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if err := validateProposedAlbum(&a, changeCreate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enrichAlbum(c.Request.Context(), &a)
+	submitPendingChange(c, PendingChange{Action: changeCreate, Album: a})
+}
+
+// proposeAlbumUpdate handles POST /api/albums/:id/changes: the body is the album as by
+// PUT /api/albums/:id, which must name the version it edits
+func proposeAlbumUpdate(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	var a Album
+	if err := c.ShouldBindJSON(&a); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if err := validateProposedAlbum(&a, changeUpdate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	expected, ok := expectedAlbumVersion(c, a.Version)
+	if !ok {
+		return
+	}
+
+	var current int
+	err := db.QueryRowContext(ctx, "SELECT version FROM albums WHERE id = $1", id).Scan(&current)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if current != expected {
+		respondVersionConflict(c, current)
+		return
+	}
+
+	a.ID, a.Version = id, 0
+	submitPendingChange(c, PendingChange{Action: changeUpdate, AlbumID: id, BaseVersion: expected, Album: a})
+}
+
+// validateProposedAlbum checks a proposal as POST or PUT would check the album. The price floor is
+// checked again on approval, since the floors may change in between.
+func validateProposedAlbum(a *Album, action string) error {
+	if err := normalizeGenre(&a.Genre); err != nil {
+		return err
+	}
+	if err := normalizeAlbumIdentifiers(a); err != nil {
+		return err
+	}
+	if err := validateAlbumMetadata(a); err != nil {
+		return err
+	}
+	if action == changeCreate || a.Attributes != nil {
+		if err := validateAlbumAttributes(a); err != nil {
+			return err
+		}
+	}
+	_, err := checkPriceFloor(*a)
+	return err
+}
+
+// submitPendingChange stores a validated proposal and answers 202
+func submitPendingChange(c *gin.Context, p PendingChange) {
+	payload, err := json.Marshal(p.Album)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode album: " + err.Error()})
+		return
+	}
+	var albumID, baseVersion interface{}
+	if p.Action == changeUpdate {
+		albumID, baseVersion = p.AlbumID, p.BaseVersion
+	}
+	p.Status = proposalPending
+	p.SubmittedBy = c.ClientIP()
+	err = db.QueryRowContext(c.Request.Context(),
+		"INSERT INTO pending_changes (action, album_id, base_version, payload, submitted_by) VALUES ($1, $2, $3, $4, $5) RETURNING id, submitted_at",
+		p.Action, albumID, baseVersion, payload, p.SubmittedBy).Scan(&p.ID, &p.SubmittedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store pending change: " + err.Error()})
+		return
+	}
+	log.Printf("Pending change %d (%s) submitted by %s", p.ID, p.Action, p.SubmittedBy)
+	respondJSON(c, http.StatusAccepted, p)
+}
+
+// getPendingChanges handles GET /api/albums/changes, listing changes with ?status= (default
+// pending), oldest first
+func getPendingChanges(c *gin.Context) {
+	status := c.DefaultQuery("status", proposalPending)
+	switch status {
+	case proposalPending, proposalApplied, proposalRejected, proposalStale:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, applied, rejected or stale"})
+		return
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT id, action, album_id, base_version, payload, status, submitted_by, submitted_at, COALESCE(decided_by, ''), decided_at, COALESCE(reason, '')
+		FROM pending_changes
+		WHERE status = $1
+		ORDER BY submitted_at, id
+		LIMIT $2`, status, maxPendingChangesListed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pending changes: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	changes := []PendingChange{}
+	for rows.Next() {
+		p, err := scanPendingChange(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read pending changes: " + err.Error()})
+			return
+		}
+		changes = append(changes, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read pending changes: " + err.Error()})
+		return
+	}
+	respondJSON(c, http.StatusOK, changes)
+}
+
+// scanPendingChange reads a row of the columns listed by getPendingChanges
+func scanPendingChange(row interface{ Scan(...interface{}) error }) (PendingChange, error) {
+	var p PendingChange
+	var albumID, baseVersion sql.NullInt64
+	var payload []byte
+	if err := row.Scan(&p.ID, &p.Action, &albumID, &baseVersion, &payload, &p.Status, &p.SubmittedBy, &p.SubmittedAt, &p.DecidedBy, &p.DecidedAt, &p.Reason); err != nil {
+		return PendingChange{}, err
+	}
+	if err := json.Unmarshal(payload, &p.Album); err != nil {
+		return PendingChange{}, fmt.Errorf("pending change %d has an invalid album: %w", p.ID, err)
+	}
+	if albumID.Valid {
+		p.AlbumID = strconv.FormatInt(albumID.Int64, 10)
+	}
+	p.BaseVersion = int(baseVersion.Int64)
+	return p, nil
+}
+
+// approvePendingChange handles POST /api/albums/changes/:changeId/approve. Approving an update of an
+// album that changed since it was proposed marks it stale and returns 409.
+func approvePendingChange(c *gin.Context) {
+	decidePendingChange(c, true, "")
+}
+
+// rejectPendingChange handles POST /api/albums/changes/:changeId/reject, with an optional
+// {"reason": ...} for the editor
+func rejectPendingChange(c *gin.Context) {
+	var body struct {
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+	}
+	decidePendingChange(c, false, body.Reason)
+}
+
+// decidePendingChange applies or rejects a pending change
+func decidePendingChange(c *gin.Context, approve bool, reason string) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("changeId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending change not found"})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	p, err := scanPendingChange(tx.QueryRowContext(ctx, `
+		SELECT id, action, album_id, base_version, payload, status, submitted_by, submitted_at, COALESCE(decided_by, ''), decided_at, COALESCE(reason, '')
+		FROM pending_changes WHERE id = $1 FOR UPDATE`, id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending change not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if p.Status != proposalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Pending change is already " + p.Status})
+		return
+	}
+
+	p.DecidedBy = c.ClientIP()
+	if approve {
+		if !applyPendingChange(ctx, c, tx, &p) {
+			return
+		}
+	} else {
+		p.Status, p.Reason = proposalRejected, reason
+	}
+	err = tx.QueryRowContext(ctx,
+		"UPDATE pending_changes SET status = $2, album_id = $3, decided_by = $4, decided_at = NOW(), reason = NULLIF($5, '') WHERE id = $1 RETURNING decided_at",
+		p.ID, p.Status, nullableAlbumID(p.AlbumID), p.DecidedBy, p.Reason).Scan(&p.DecidedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide pending change: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit decision: " + err.Error()})
+		return
+	}
+	if p.Status == proposalApplied && p.Action == changeUpdate {
+		invalidateAlbums(ctx, p.AlbumID)
+	}
+	log.Printf("Pending change %d (%s) %s by %s", p.ID, p.Action, p.Status, p.DecidedBy)
+
+	if p.Status == proposalStale {
+		c.JSON(http.StatusConflict, gin.H{"error": "Album changed since the change was proposed; it was marked stale"})
+		return
+	}
+	if p.Status == proposalApplied && p.Action == changeCreate {
+		// As for POST /api/albums, a lost event doesn't undo the create
+		publishAlbumCreated(ctx, p.Album)
+	}
+	respondJSON(c, http.StatusOK, p)
+}
+
+// nullableAlbumID is the album_id column value of a pending change
+func nullableAlbumID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// applyPendingChange writes an approved change in tx as the admin and sets its status, or answers
+// the request and returns false when it can't be applied as proposed
+func applyPendingChange(ctx context.Context, c *gin.Context, tx *sql.Tx, p *PendingChange) bool {
+	a := &p.Album
+	floor, err := checkPriceFloor(*a)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if p.Action == changeCreate {
+		id, err := insertAlbumTx(ctx, tx, *a, floor, p.DecidedBy)
+		if isUPCConflict(err) {
+			respondUPCConflict(c, a.UPC)
+			return false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create album in DB: " + err.Error()})
+			return false
+		}
+		p.AlbumID = strconv.Itoa(id)
+		a.ID, a.Version, a.Status = p.AlbumID, initialAlbumVersion, albumDraft
+		p.Status = proposalApplied
+		return true
+	}
+
+	if err := setPriceChangeContext(ctx, tx, priceSourceUpdate, priceChangeReason(a.PriceChangeReason, a.PriceFloorOverride), p.DecidedBy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+		return false
+	}
+	if err := setAuditActor(ctx, tx, p.DecidedBy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+		return false
+	}
+	var attributes []byte
+	if a.Attributes != nil {
+		if attributes, err = json.Marshal(a.Attributes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode attributes: " + err.Error()})
+			return false
+		}
+	}
+	err = updateAlbumRow(ctx, tx, p.AlbumID, a, p.BaseVersion, attributes)
+	if err == sql.ErrNoRows {
+		p.Status = proposalStale
+		return true
+	}
+	if isUPCConflict(err) {
+		respondUPCConflict(c, a.UPC)
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album: " + err.Error()})
+		return false
+	}
+	if err := validateAlbumAttributes(a); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return false
+	}
+	if floor > 0 {
+		if err := recordPriceFloorOverride(ctx, tx, p.AlbumID, changeUpdate, *a, floor, p.DecidedBy); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to audit price floor override: " + err.Error()})
+			return false
+		}
+	}
+	p.Status = proposalApplied
+	return true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCatalogApproval(t *testing.T) {
+	t.Cleanup(func() { catalogApproval = false })
+
+	require.NoError(t, loadCatalogApproval())
+	assert.False(t, catalogApproval, "disabled unless configured")
+
+	t.Setenv("CATALOG_CHANGE_APPROVAL", "true")
+	require.NoError(t, loadCatalogApproval())
+	assert.True(t, catalogApproval)
+
+	t.Setenv("CATALOG_CHANGE_APPROVAL", "sometimes")
+	assert.ErrorContains(t, loadCatalogApproval(), "not a boolean")
+}
+
+func TestPendingChangeHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, kafkaWriter
+	writer := &recordingWriter{}
+	db, kafkaWriter, catalogApproval = mockDB, writer, true
+	t.Cleanup(func() { db, kafkaWriter, catalogApproval = originalDB, originalWriter, false })
+
+	send := func(method, path, clientType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if clientType != "" {
+			req.Header.Set("Client-Type", clientType)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"id", "action", "album_id", "base_version", "payload", "status", "submitted_by", "submitted_at", "decided_by", "decided_at", "reason"}
+	submitted := time.Now().Add(-time.Hour)
+	expectPending := func(action string, albumID, baseVersion interface{}) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM pending_changes WHERE id = \\$1 FOR UPDATE").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, action, albumID, baseVersion,
+				[]byte(`{"title":"Kind of Blue","artist":"Miles Davis","price":24.99,"releaseYear":1959,"genre":"Jazz"}`),
+				proposalPending, "10.0.0.8", submitted, "", nil, ""))
+	}
+
+	t.Run("Editors propose a new album", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO pending_changes").WithArgs(changeCreate, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "submitted_at"}).AddRow(3, submitted))
+
+		rr := send("POST", "/api/albums/changes", clientTypeEditor, `{"title":"Kind of Blue","artist":"Miles Davis","price":24.99,"releaseYear":1959,"genre":"Jazz"}`)
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var change PendingChange
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &change))
+		assert.Equal(t, 3, change.ID)
+		assert.Equal(t, proposalPending, change.Status)
+		assert.Equal(t, "Kind of Blue", change.Album.Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Proposals are validated", func(t *testing.T) {
+		rr := send("POST", "/api/albums/changes", clientTypeEditor, `{"title":"Kind of Blue","artist":"Miles Davis","price":24.99,"releaseYear":1959,"genre":"Jazz","upc":"123"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Updates must be based on the current version", func(t *testing.T) {
+		mock.ExpectQuery("SELECT version FROM albums WHERE id = \\$1").WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+
+		rr := send("POST", "/api/albums/7/changes", clientTypeEditor, `{"title":"Kind of Blue","artist":"Miles Davis","price":19.99,"releaseYear":1959,"genre":"Jazz","version":3}`)
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Approving a new album creates it", func(t *testing.T) {
+		expectPending(changeCreate, nil, nil)
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectQuery("UPDATE pending_changes SET status").WithArgs(3, proposalApplied, "12", sqlmock.AnyArg(), "").
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/albums/changes/3/approve", "admin", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var change PendingChange
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &change))
+		assert.Equal(t, proposalApplied, change.Status)
+		assert.Equal(t, "12", change.AlbumID)
+		assert.Equal(t, albumDraft, change.Album.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		require.NotEmpty(t, writer.messages, "album-created is published")
+		assert.Contains(t, string(writer.messages[len(writer.messages)-1].Value), `"albumId":"12"`)
+	})
+
+	t.Run("Approving an update of a changed album marks it stale", func(t *testing.T) {
+		expectPending(changeUpdate, 7, 3)
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET title").WillReturnRows(sqlmock.NewRows([]string{"version", "attributes"}))
+		mock.ExpectQuery("UPDATE pending_changes SET status").WithArgs(3, proposalStale, "7", sqlmock.AnyArg(), "").
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/albums/changes/3/approve", "admin", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reject with a reason", func(t *testing.T) {
+		expectPending(changeUpdate, 7, 3)
+		mock.ExpectQuery("UPDATE pending_changes SET status").WithArgs(3, proposalRejected, "7", sqlmock.AnyArg(), "Wrong pressing").
+			WillReturnRows(sqlmock.NewRows([]string{"decided_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/albums/changes/3/reject", "admin", `{"reason":"Wrong pressing"}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"reason":"Wrong pressing"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Only admins decide", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("POST", "/api/albums/changes/3/approve", clientTypeEditor, "").Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", "/api/albums/changes", "", "").Code)
+	})
+
+	t.Run("Not found unless enabled", func(t *testing.T) {
+		catalogApproval = false
+		defer func() { catalogApproval = true }()
+		assert.Equal(t, http.StatusNotFound, send("GET", "/api/albums/changes", clientTypeEditor, "").Code)
+	})
+}
@@ -0,0 +1,95 @@
+// popularity.go - periodic recomputation of album rating aggregates and popularity score
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultPopularityJobInterval = 15 * time.Minute
+	popularityWindowDays         = 30 // Sales and views older than this no longer count towards popularity
+
+	// Blend weights. Sales and views are log-scaled so a handful of best sellers don't flatten the
+	// rest of the catalog; the rating term is damped by review count (see recomputeAlbumStats).
+	popularitySalesWeight  = 1.0
+	popularityViewsWeight  = 0.3
+	popularityRatingWeight = 0.5
+	popularityRatingPrior  = 5 // Reviews needed before an album's average counts at half weight
+)
+
+// recomputeAlbumStats refreshes average_rating, review_count and popularity_score for every album in
+// a single statement, so read endpoints can sort on precomputed columns. Returns the number of albums updated.
+func recomputeAlbumStats(ctx context.Context, db *sql.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE albums a SET
+			average_rating = COALESCE(r.avg_rating, 0),
+			review_count = COALESCE(r.review_count, 0),
+			popularity_score =
+				$1 * LN(1 + COALESCE(s.units, 0)) +
+				$2 * LN(1 + COALESCE(v.views, 0)) +
+				$3 * COALESCE(r.avg_rating, 0) * COALESCE(r.review_count, 0) / (COALESCE(r.review_count, 0) + $4),
+			stats_updated_at = NOW()
+		FROM albums base
+		LEFT JOIN (
+			SELECT album_id, AVG(rating) AS avg_rating, COUNT(*) AS review_count
+			FROM album_reviews GROUP BY album_id
+		) r ON r.album_id = base.id
+		LEFT JOIN (
+			SELECT album_id, SUM(units) AS units
+			FROM album_sales WHERE sold_at > NOW() - make_interval(days => $5) GROUP BY album_id
+		) s ON s.album_id = base.id
+		LEFT JOIN (
+			SELECT album_id, SUM(views) AS views
+			FROM album_daily_views WHERE day > CURRENT_DATE - $5::int GROUP BY album_id
+		) v ON v.album_id = base.id
+		WHERE a.id = base.id`,
+		popularitySalesWeight, popularityViewsWeight, popularityRatingWeight, popularityRatingPrior, popularityWindowDays,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// startPopularityJob recomputes album stats immediately and then on every POPULARITY_JOB_INTERVAL tick
+func startPopularityJob() {
+	interval := defaultPopularityJobInterval
+	if v := os.Getenv("POPULARITY_JOB_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid POPULARITY_JOB_INTERVAL %q, using default %s", v, defaultPopularityJobInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Printf("Popularity job scheduled every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runPopularityJob()
+			<-ticker.C
+		}
+	}()
+}
+
+// runPopularityJob runs one traced recomputation pass
+func runPopularityJob() {
+	ctx, span := tracer.Start(context.Background(), "job.recompute_album_stats")
+	defer span.End()
+
+	start := time.Now()
+	updated, err := recomputeAlbumStats(ctx, db)
+	if err != nil {
+		log.Printf("Popularity job failed: %v", err)
+		span.RecordError(err)
+		return
+	}
+	log.Printf("Popularity job recomputed stats for %d albums in %s", updated, time.Since(start))
+}
@@ -0,0 +1,92 @@
+// price_floor.go - minimum album prices, so a mistyped price can't put an album on sale for pennies.
+// Prices below the floor need an explicit override with a reason, which is audited.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// priceFloorConfig holds the global floor and per-genre overrides (genre keys are lower case).
+// A floor of 0 disables the check.
+type priceFloorConfig struct {
+	Default Cents
+	ByGenre map[string]Cents
+}
+
+var priceFloors = priceFloorConfig{}
+
+// PriceFloorOverride lets an admin set a price below the floor on purpose
+type PriceFloorOverride struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// loadPriceFloors reads PRICE_FLOOR (e.g. "4.99") and PRICE_FLOOR_BY_GENRE (e.g. "Jazz=9.99,Classical=7.50")
+func loadPriceFloors() error {
+	cfg := priceFloorConfig{ByGenre: make(map[string]Cents)}
+	if v := os.Getenv("PRICE_FLOOR"); v != "" {
+		floor, err := parseCents(v)
+		if err != nil || floor < 0 {
+			return fmt.Errorf("PRICE_FLOOR %q is not a non-negative amount", v)
+		}
+		cfg.Default = floor
+	}
+	if v := os.Getenv("PRICE_FLOOR_BY_GENRE"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			genre, value, ok := strings.Cut(entry, "=")
+			genre = strings.TrimSpace(genre)
+			floor, err := parseCents(value)
+			if !ok || genre == "" || err != nil || floor < 0 {
+				return fmt.Errorf("PRICE_FLOOR_BY_GENRE entry %q, expected Genre=price", entry)
+			}
+			cfg.ByGenre[strings.ToLower(genre)] = floor
+		}
+	}
+	priceFloors = cfg
+	return nil
+}
+
+// floorFor returns the floor for a genre, falling back to the global floor
+func (cfg priceFloorConfig) floorFor(genre string) Cents {
+	if floor, ok := cfg.ByGenre[strings.ToLower(genre)]; ok {
+		return floor
+	}
+	return cfg.Default
+}
+
+// check reports whether price is below the floor for the genre. The returned floor is only
+// meaningful when below is true.
+func (cfg priceFloorConfig) check(price Cents, genre string) (floor Cents, below bool) {
+	floor = cfg.floorFor(genre)
+	return floor, floor > 0 && price < floor
+}
+
+// checkPriceFloor validates an album's price against its floor. It returns the floor when the price
+// is below it but an override was supplied, so the caller can audit it; an error when there is no
+// override.
+func checkPriceFloor(a Album) (overriddenFloor Cents, err error) {
+	floor, below := priceFloors.check(a.Price, a.Genre)
+	if !below {
+		return 0, nil
+	}
+	if a.PriceFloorOverride == nil {
+		return 0, fmt.Errorf("price %s is below the %s floor for genre %q; set priceFloorOverride.reason to confirm it", a.Price, floor, a.Genre)
+	}
+	return floor, nil
+}
+
+// recordPriceFloorOverride audits a price set below the floor, in the transaction that sets it
+func recordPriceFloorOverride(ctx context.Context, tx *sql.Tx, albumID, operation string, a Album, floor Cents, clientIP string) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO price_floor_overrides (album_id, operation, price_cents, floor_cents, reason, client_ip) VALUES ($1, $2, $3, $4, $5, $6)",
+		albumID, operation, a.Price, floor, a.PriceFloorOverride.Reason, clientIP)
+	if err == nil {
+		log.Printf("Price floor overridden on %s of album %s: %s below %s (%s)", operation, albumID, a.Price, floor, a.PriceFloorOverride.Reason)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPriceFloors(t *testing.T) {
+	original := priceFloors
+	t.Cleanup(func() { priceFloors = original })
+
+	t.Setenv("PRICE_FLOOR", "4.99")
+	t.Setenv("PRICE_FLOOR_BY_GENRE", "Jazz=9.99, Classical = 0")
+	require.NoError(t, loadPriceFloors())
+	assert.Equal(t, Cents(999), priceFloors.floorFor("jazz"), "genres match case-insensitively")
+	assert.Equal(t, Cents(499), priceFloors.floorFor("Rock"))
+
+	_, below := priceFloors.check(50, "Classical")
+	assert.False(t, below, "a zero genre floor disables the check for that genre")
+
+	t.Setenv("PRICE_FLOOR_BY_GENRE", "Jazz")
+	assert.ErrorContains(t, loadPriceFloors(), "expected Genre=price")
+}
+
+func TestPriceFloorOnWrites(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter, originalFloors := db, kafkaWriter, priceFloors
+	db, kafkaWriter = mockDB, &recordingWriter{}
+	priceFloors = priceFloorConfig{Default: 500, ByGenre: map[string]Cents{"jazz": 1000}}
+	t.Cleanup(func() { db, kafkaWriter, priceFloors = originalDB, originalWriter, originalFloors })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	const cheapJazz = `{"title":"Blue Train","artist":"John Coltrane","price":7.5,"releaseYear":1957,"genre":"Jazz"`
+
+	t.Run("Price below the genre floor is rejected", func(t *testing.T) {
+		rr := send("POST", "/api/albums", cheapJazz+`}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "below the 10.00 floor")
+	})
+
+	t.Run("Override needs a reason", func(t *testing.T) {
+		rr := send("POST", "/api/albums", cheapJazz+`,"priceFloorOverride":{}}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Override on create is audited", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectQuery("INSERT INTO albums").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec("INSERT INTO price_floor_overrides").
+			WithArgs("3", "create", 750, 1000, "Clearance", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/albums", cheapJazz+`,"priceFloorOverride":{"reason":"Clearance"}}`)
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Override on update is audited", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WithArgs(priceSourceUpdate, "Clearance", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET").WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+			AddRow(3, "Blue Train", "John Coltrane", 750, 1957, "Jazz", "", "", "", 2, 0, 0, "", "", nil, "published", nil))
+		mock.ExpectExec("INSERT INTO price_floor_overrides").
+			WithArgs("3", "update", 750, 1000, "Clearance", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		rr := send("PUT", "/api/albums/3", cheapJazz+`,"version":1,"priceFloorOverride":{"reason":"Clearance"}}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Prices at the floor need no override", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAuditActor(mock)
+		mock.ExpectQuery("UPDATE albums SET").WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+			AddRow(3, "Nevermind", "Nirvana", 500, 1991, "Rock", "", "", "", 2, 0, 0, "", "", nil, "published", nil))
+		mock.ExpectCommit()
+
+		rr := send("PUT", "/api/albums/3", `{"title":"Nevermind","artist":"Nirvana","price":5,"releaseYear":1991,"genre":"Rock","version":1}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Imports mark rows below the floor invalid", func(t *testing.T) {
+		items, summary := buildImportItems([]discogsRelease{{Title: "Blue Train", Artist: "John Coltrane", Released: "1957"}}, 750, "Jazz", nil)
+		assert.Equal(t, ImportSummary{Invalid: 1}, summary)
+		assert.Equal(t, "price below the 10.00 floor for Jazz", items[0].Reason)
+	})
+}
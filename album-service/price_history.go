@@ -0,0 +1,122 @@
+// price_history.go - every album price change with its source and reason, so marketing can audit
+// when and why prices changed. A database trigger records the changes, so no write path can skip
+// it; writers that know why describe the change with setPriceChangeContext in the same transaction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// Price change sources. The trigger records "create" for new albums and "unknown" for changes no
+// writer described; "initial" marks the prices albums had when history was enabled.
+const (
+	priceSourceUpdate    = "update"
+	priceSourcePatch     = "patch"
+	priceSourceClearance = "clearance"
+	priceSourceRestore   = "restore"
+)
+
+// priceHistoryListSpec pages price history newest first (see album-store/listing)
+var priceHistoryListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "changedAt", Expr: "changed_at"}, {Name: "newPrice", Expr: "new_price_cents"}},
+	Filterable:  []listing.Field{{Name: "source", Expr: "source"}},
+	DefaultSort: []listing.SortKey{{Expr: "changed_at", Desc: true}},
+	Unique:      "id",
+}
+
+// PriceChange is one entry of an album's price history
+type PriceChange struct {
+	OldPrice  *Cents    `json:"oldPrice,omitempty"` // Absent for the first price
+	NewPrice  Cents     `json:"newPrice"`
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy string    `json:"changedBy,omitempty"` // Client IP, or "auto" for automatic clearance
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// setPriceChangeContext describes the price changes the transaction makes, for the history trigger
+func setPriceChangeContext(ctx context.Context, tx *sql.Tx, source, reason, changedBy string) error {
+	_, err := tx.ExecContext(ctx,
+		`SELECT set_config('album_store.price_source', $1, true), set_config('album_store.price_reason', $2, true), set_config('album_store.price_changed_by', $3, true)`,
+		source, reason, changedBy)
+	return err
+}
+
+// priceChangeReason is the reason given for an edit's price: its own, or the price floor override's
+func priceChangeReason(reason string, override *PriceFloorOverride) string {
+	if reason == "" && override != nil {
+		return override.Reason
+	}
+	return reason
+}
+
+// getPriceHistory handles GET /api/albums/:id/price-history (admin), newest change first unless
+// sorted otherwise. The history of deleted albums is kept.
+func getPriceHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	page, err := listing.ParseParams(c, priceHistoryListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := []interface{}{id}
+	where := listing.WhereClause(append([]string{"album_id = $1"}, page.Conditions(&args)...))
+	countArgs := args
+	query := "SELECT old_price_cents, new_price_cents, source, COALESCE(reason, ''), COALESCE(changed_by, ''), changed_at FROM price_history" +
+		where + page.OrderByClause() + page.PageClause(&args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query price history: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	changes := []PriceChange{}
+	for rows.Next() {
+		var change PriceChange
+		var oldPrice sql.Null[Cents]
+		if err := rows.Scan(&oldPrice, &change.NewPrice, &change.Source, &change.Reason, &change.ChangedBy, &change.ChangedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read price history: " + err.Error()})
+			return
+		}
+		if oldPrice.Valid {
+			change.OldPrice = &oldPrice.V
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read price history: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(changes), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM price_history"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count price history: " + err.Error()})
+		return
+	}
+	if meta.Total == 0 && len(page.Filters) == 0 {
+		// Every album has at least its first price, so no history means no such album
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	meta.SetHeaders(c)
+	c.JSON(http.StatusOK, changes)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPriceHistory(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"old_price_cents", "new_price_cents", "source", "reason", "changed_by", "changed_at"}
+
+	t.Run("Newest change first", func(t *testing.T) {
+		changed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("FROM price_history WHERE album_id = \\$1").WithArgs(4, listing.DefaultLimit, 0).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1999, 1499, priceSourceClearance, "No sales in 120 days", "auto", changed).
+				AddRow(nil, 1999, "create", "", "", changed.AddDate(0, -6, 0)))
+
+		rr := get("/api/albums/4/price-history")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var changes []PriceChange
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &changes))
+		require.Len(t, changes, 2)
+		oldPrice := Cents(1999)
+		assert.Equal(t, PriceChange{OldPrice: &oldPrice, NewPrice: 1499, Source: priceSourceClearance, Reason: "No sales in 120 days", ChangedBy: "auto", ChangedAt: changed}, changes[0])
+		assert.Nil(t, changes[1].OldPrice)
+		assert.NotContains(t, rr.Body.String(), `"reason":""`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Paged and filtered", func(t *testing.T) {
+		mock.ExpectQuery(`FROM price_history WHERE album_id = \$1 AND source IN \(\$2\) ORDER BY changed_at DESC, id ASC LIMIT \$3 OFFSET \$4`).
+			WithArgs(4, priceSourceClearance, 1, 0).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1999, 1499, priceSourceClearance, "", "auto", time.Now()))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM price_history WHERE album_id = \$1 AND source IN \(\$2\)`).
+			WithArgs(4, priceSourceClearance).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+		rr := get("/api/albums/4/price-history?source=clearance&limit=1")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "3", rr.Header().Get("X-Total-Count"))
+		// Next to the successor-version link of the deprecated /api (see api_versions.go)
+		assert.Contains(t, rr.Header().Values("Link"), `</api/albums/4/price-history?limit=1&offset=1&source=clearance>; rel="next"`)
+		assert.Contains(t, rr.Header().Values("Link"), `</api/v1/albums/4/price-history>; rel="successor-version"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, http.StatusBadRequest, get("/api/albums/4/price-history?sort=reason").Code)
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectQuery("FROM price_history").WillReturnRows(sqlmock.NewRows(columns))
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/99/price-history").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/abc/price-history").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/albums/4/price-history", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
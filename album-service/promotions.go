@@ -0,0 +1,435 @@
+// promotions.go - time-boxed discounts. A promotion takes a percentage or a fixed amount off every
+// album, one genre or one album between its start and end. Promotions without a code apply to
+// everyone; promotions with a code only when the customer enters it. The best promotion wins,
+// discounts never stack and never go below the price floor. When automatic promotions start and end,
+// the new effective price of every album they cover is published to the price-changed topic.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultPromotionJobInterval = time.Minute
+	priceChangedTopic           = "price-changed"
+	priceChangedBatchSize       = 500 // Messages per WriteMessages call
+)
+
+// Reasons of price-changed events
+const (
+	priceChangePromotionStarted = "promotion_started"
+	priceChangePromotionEnded   = "promotion_ended"
+)
+
+var promotionCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// Promotion is a discount on a scope of albums for a time window. Exactly one of PercentOff and
+// AmountOff is set. Without AlbumID and Genre it covers the whole catalog.
+type Promotion struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name" binding:"required,max=100"`
+	Code       string    `json:"code,omitempty"`
+	PercentOff float64   `json:"percentOff,omitempty" binding:"gte=0,lt=100"`
+	AmountOff  Cents     `json:"amountOff,omitempty" binding:"gte=0"`
+	AlbumID    string    `json:"albumId,omitempty" id:"public"`
+	Genre      string    `json:"genre,omitempty"`
+	StartsAt   time.Time `json:"startsAt" binding:"required"`
+	EndsAt     time.Time `json:"endsAt" binding:"required"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// appliesTo reports whether the promotion's scope covers the album
+func (p Promotion) appliesTo(albumID, genre string) bool {
+	return (p.AlbumID == "" || p.AlbumID == albumID) && (p.Genre == "" || strings.EqualFold(p.Genre, genre))
+}
+
+// discounted returns price less the promotion's discount, never below zero
+func (p Promotion) discounted(price Cents) Cents {
+	if p.PercentOff > 0 {
+		return price.percentOff(p.PercentOff)
+	}
+	return max(price-p.AmountOff, 0)
+}
+
+// effectivePrice returns the lowest price any of the promotions gives the album, and the promotion
+// giving it (nil when none applies). Discounts are raised to the genre's price floor, but never
+// above the list price.
+func effectivePrice(promotions []Promotion, albumID, genre string, price Cents) (Cents, *Promotion) {
+	best, bestPrice := -1, price
+	floor := priceFloors.floorFor(genre)
+	for i, p := range promotions {
+		if !p.appliesTo(albumID, genre) {
+			continue
+		}
+		discounted := p.discounted(price)
+		if discounted < floor {
+			discounted = min(floor, price)
+		}
+		if discounted < bestPrice {
+			best, bestPrice = i, discounted
+		}
+	}
+	if best < 0 {
+		return price, nil
+	}
+	return bestPrice, &promotions[best]
+}
+
+// EffectivePrice is the response of GET /api/albums/:id/effective-price
+type EffectivePrice struct {
+	AlbumID        string     `json:"albumId" id:"public"`
+	Price          Cents      `json:"price"`
+	EffectivePrice Cents      `json:"effectivePrice"`
+	Promotion      *Promotion `json:"promotion,omitempty"`
+}
+
+// PriceChangedEvent is published when a promotion changes an album's effective price
+type PriceChangedEvent struct {
+	AlbumID        string    `json:"albumId"`
+	Price          Cents     `json:"price"`
+	EffectivePrice Cents     `json:"effectivePrice"`
+	PromotionID    int       `json:"promotionId"`
+	Reason         string    `json:"reason"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+var priceChangedWriter messageWriter
+
+const promotionColumns = "id, name, COALESCE(code, ''), COALESCE(percent_off, 0), COALESCE(amount_off_cents, 0), COALESCE(album_id::text, ''), COALESCE(genre, ''), starts_at, ends_at, created_at"
+
+func scanPromotion(row interface{ Scan(...interface{}) error }) (Promotion, error) {
+	var p Promotion
+	err := row.Scan(&p.ID, &p.Name, &p.Code, &p.PercentOff, &p.AmountOff, &p.AlbumID, &p.Genre, &p.StartsAt, &p.EndsAt, &p.CreatedAt)
+	return p, err
+}
+
+func queryPromotions(ctx context.Context, query string, args ...interface{}) ([]Promotion, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	promotions := []Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+// activePromotions returns the automatic promotions running at now, and those with the code
+func activePromotions(ctx context.Context, now time.Time, code string) ([]Promotion, error) {
+	return queryPromotions(ctx, "SELECT "+promotionColumns+` FROM promotions
+		WHERE starts_at <= $1 AND ends_at > $1 AND (code IS NULL OR code = $2)
+		ORDER BY id`, now, strings.ToUpper(code))
+}
+
+// createPromotion handles POST /api/albums/promotions
+func createPromotion(c *gin.Context) {
+	var p Promotion
+	if err := c.ShouldBindJSON(&p); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if (p.PercentOff > 0) == (p.AmountOff > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set exactly one of percentOff and amountOff"})
+		return
+	}
+	if !p.EndsAt.After(p.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endsAt must be after startsAt"})
+		return
+	}
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	if p.Code != "" && !promotionCodePattern.MatchString(p.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code must be 3 to 32 letters, digits, - or _"})
+		return
+	}
+	if p.Genre != "" {
+		if err := normalizeGenre(&p.Genre); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var albumID sql.NullString
+	if p.AlbumID != "" {
+		internal, ok := publicIDs.decode(p.AlbumID)
+		if _, err := strconv.Atoi(internal); !ok || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Album not found"})
+			return
+		}
+		if _, err := findAlbum(c.Request.Context(), internal); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Album not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		p.AlbumID = internal
+		albumID = sql.NullString{String: internal, Valid: true}
+	}
+
+	err := db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO promotions (name, code, percent_off, amount_off_cents, album_id, genre, starts_at, ends_at, created_by)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, 0), NULLIF($4, 0), $5, NULLIF($6, ''), $7, $8, $9)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING id, created_at`,
+		p.Name, p.Code, p.PercentOff, p.AmountOff, albumID, p.Genre, p.StartsAt, p.EndsAt, c.ClientIP()).
+		Scan(&p.ID, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Discount code " + p.Code + " is already used by another promotion"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion: " + err.Error()})
+		return
+	}
+	log.Printf("Promotion %d %q created by %s: %s to %s", p.ID, p.Name, c.ClientIP(), p.StartsAt.Format(time.RFC3339), p.EndsAt.Format(time.RFC3339))
+	respondJSON(c, http.StatusCreated, p)
+}
+
+var promotionListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "name", Expr: "lower(name)"}, {Name: "startsAt", Expr: "starts_at"}, {Name: "endsAt", Expr: "ends_at"}},
+	DefaultSort: []listing.SortKey{{Expr: "starts_at", Desc: true}},
+	Unique:      "id",
+}
+
+// getPromotions handles GET /api/albums/promotions, listing every promotion, newest start first
+func getPromotions(c *gin.Context) {
+	page, err := listing.ParseParams(c, promotionListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	var args []interface{}
+	query := "SELECT " + promotionColumns + " FROM promotions" + page.OrderByClause() + page.PageClause(&args)
+	promotions, err := queryPromotions(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query promotions: " + err.Error()})
+		return
+	}
+	meta, err := page.Meta(len(promotions), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM promotions").Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count promotions: " + err.Error()})
+		return
+	}
+	meta.SetHeaders(c)
+	respondJSON(c, http.StatusOK, promotions)
+}
+
+// endPromotion handles POST /api/albums/promotions/:promotionId/end, ending a running or scheduled
+// promotion now. The price-changed events follow with the next promotion job run.
+func endPromotion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("promotionId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	// A scheduled promotion keeps a one-microsecond window, as ends_at must be after starts_at
+	p, err := scanPromotion(db.QueryRowContext(c.Request.Context(), `
+		UPDATE promotions SET ends_at = GREATEST(NOW(), starts_at + interval '1 microsecond')
+		WHERE id = $1 AND ends_at > NOW()
+		RETURNING `+promotionColumns, id))
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRowContext(c.Request.Context(), "SELECT EXISTS (SELECT 1 FROM promotions WHERE id = $1)", id).Scan(&exists); err == nil && exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Promotion has already ended"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end promotion: " + err.Error()})
+		return
+	}
+	log.Printf("Promotion %d ended early by %s", p.ID, c.ClientIP())
+	respondJSON(c, http.StatusOK, p)
+}
+
+// getEffectivePrice handles GET /api/albums/:id/effective-price?code=, the price after the best
+// running promotion. An unknown, expired or non-matching code returns 400 so checkouts can say so.
+func getEffectivePrice(c *gin.Context) {
+	ctx := c.Request.Context()
+	a, err := findAlbum(ctx, c.Param("id"))
+	if err == sql.ErrNoRows || (err == nil && !albumVisible(c, a)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	code := strings.TrimSpace(c.Query("code"))
+	promotions, err := activePromotions(ctx, time.Now(), code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query promotions: " + err.Error()})
+		return
+	}
+	if code != "" {
+		// The code has to be valid for this album, even when an automatic promotion is better
+		var coded []Promotion
+		for _, p := range promotions {
+			if p.Code != "" {
+				coded = append(coded, p)
+			}
+		}
+		if _, p := effectivePrice(coded, a.ID, a.Genre, a.Price); p == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Discount code " + strings.ToUpper(code) + " is not valid for this album"})
+			return
+		}
+	}
+	effective, promotion := effectivePrice(promotions, a.ID, a.Genre, a.Price)
+	respondJSON(c, http.StatusOK, EffectivePrice{AlbumID: a.ID, Price: a.Price, EffectivePrice: effective, Promotion: promotion})
+}
+
+// startPromotionJob publishes price changes of starting and ending promotions immediately and then
+// on every PROMOTION_JOB_INTERVAL tick (default 1m), which bounds how late the events are
+func startPromotionJob() {
+	interval := defaultPromotionJobInterval
+	if v := os.Getenv("PROMOTION_JOB_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid PROMOTION_JOB_INTERVAL %q, using default %s", v, defaultPromotionJobInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Printf("Promotion job scheduled every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runPromotionJob()
+			<-ticker.C
+		}
+	}()
+}
+
+// runPromotionJob runs one traced pass of publishPromotionChanges
+func runPromotionJob() {
+	ctx, span := tracer.Start(context.Background(), "job.promotions")
+	defer span.End()
+
+	published, err := publishPromotionChanges(ctx, time.Now())
+	if err != nil {
+		log.Printf("Promotion job failed after %d price changes: %v", published, err)
+		span.RecordError(err)
+		return
+	}
+	if published > 0 {
+		log.Printf("Promotion job published %d price changes", published)
+	}
+}
+
+// publishPromotionChanges claims the automatic promotions that started or ended by now and
+// publishes the effective price of every album they cover. Claiming marks them published first, so
+// with several instances each change is published once; events lost to a Kafka outage are logged
+// and not retried. Returns the number of events published.
+func publishPromotionChanges(ctx context.Context, now time.Time) (int, error) {
+	claims := []struct{ column, condition, reason string }{
+		{"start_published_at", "starts_at <= $1", priceChangePromotionStarted},
+		{"end_published_at", "ends_at <= $1 AND start_published_at IS NOT NULL", priceChangePromotionEnded},
+	}
+	published := 0
+	for _, claim := range claims {
+		claimed, err := queryPromotions(ctx, "UPDATE promotions SET "+claim.column+" = $1 WHERE code IS NULL AND "+
+			claim.column+" IS NULL AND "+claim.condition+" RETURNING "+promotionColumns, now)
+		if err != nil {
+			return published, fmt.Errorf("claim promotions for %s: %w", claim.reason, err)
+		}
+		for _, p := range claimed {
+			n, err := publishPromotionPrices(ctx, p, claim.reason, now)
+			published += n
+			if err != nil {
+				return published, fmt.Errorf("publish promotion %d: %w", p.ID, err)
+			}
+		}
+	}
+	return published, nil
+}
+
+// publishPromotionPrices publishes one price-changed event per album in the promotion's scope, with
+// the album's effective price at now
+func publishPromotionPrices(ctx context.Context, p Promotion, reason string, now time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "kafka.publish_price_changes")
+	defer span.End()
+
+	active, err := activePromotions(ctx, now, "")
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, genre, price_cents FROM albums
+		WHERE ($1 = '' OR id::text = $1) AND ($2 = '' OR lower(genre) = lower($2))
+		ORDER BY id`, p.AlbumID, p.Genre)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	published := 0
+	var batch []kafka.Message
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := priceChangedWriter.WriteMessages(ctx, batch...); err != nil {
+			log.Printf("Error publishing %d price changes of promotion %d: %v", len(batch), p.ID, err)
+			span.RecordError(err)
+		} else {
+			published += len(batch)
+		}
+		batch = batch[:0]
+	}
+	for rows.Next() {
+		var albumID int
+		var genre string
+		var price Cents
+		if err := rows.Scan(&albumID, &genre, &price); err != nil {
+			return published, err
+		}
+		id := strconv.Itoa(albumID)
+		effective, _ := effectivePrice(active, id, genre, price)
+		event, err := json.Marshal(PriceChangedEvent{
+			AlbumID: id, Price: price, EffectivePrice: effective, PromotionID: p.ID, Reason: reason, Timestamp: now,
+		})
+		if err != nil {
+			return published, err
+		}
+		batch = append(batch, kafka.Message{Key: []byte(id), Value: event, Headers: InjectTraceInfoToKafkaMessage(ctx)})
+		if len(batch) == priceChangedBatchSize {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return published, err
+	}
+	flush()
+	return published, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var promotionColumnNames = []string{"id", "name", "code", "percent_off", "amount_off_cents", "album_id", "genre", "starts_at", "ends_at", "created_at"}
+
+func TestEffectivePrice(t *testing.T) {
+	original := priceFloors
+	priceFloors = priceFloorConfig{ByGenre: map[string]Cents{"jazz": 1000}}
+	t.Cleanup(func() { priceFloors = original })
+
+	promotions := []Promotion{
+		{ID: 1, PercentOff: 10},
+		{ID: 2, AmountOff: 500, Genre: "Rock"},
+		{ID: 3, PercentOff: 50, AlbumID: "9"},
+	}
+
+	price, p := effectivePrice(promotions, "4", "Rock", 1999)
+	assert.Equal(t, Cents(1499), price, "the best promotion wins")
+	assert.Equal(t, 2, p.ID)
+
+	price, p = effectivePrice(promotions, "5", "Pop", 1999)
+	assert.Equal(t, Cents(1799), price)
+	assert.Equal(t, 1, p.ID)
+
+	price, p = effectivePrice(promotions, "9", "Jazz", 1800)
+	assert.Equal(t, Cents(1000), price, "raised to the genre floor")
+	assert.Equal(t, 3, p.ID)
+
+	price, p = effectivePrice(promotions[1:], "5", "Pop", 1999)
+	assert.Equal(t, Cents(1999), price)
+	assert.Nil(t, p)
+
+	assert.Equal(t, Cents(0), Promotion{AmountOff: 2500}.discounted(1999), "never below zero")
+}
+
+func TestPromotionHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	starts := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	ends := starts.AddDate(0, 1, 0)
+	const window = `"startsAt":"2024-07-01T00:00:00Z","endsAt":"2024-08-01T00:00:00Z"`
+
+	t.Run("Create validates the discount and window", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name":"Sale",` + window + `}`,
+			`{"name":"Sale","percentOff":10,"amountOff":5,` + window + `}`,
+			`{"name":"Sale","percentOff":100,` + window + `}`,
+			`{"name":"Sale","percentOff":10,"startsAt":"2024-08-01T00:00:00Z","endsAt":"2024-07-01T00:00:00Z"}`,
+			`{"name":"Sale","percentOff":10,"code":"no spaces",` + window + `}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, send("POST", "/api/albums/promotions", body).Code, body)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO promotions").
+			WithArgs("Summer", "SUMMER24", float64(0), 500, nil, "", starts, ends, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+		rr := send("POST", "/api/albums/promotions", `{"name":"Summer","code":" summer24 ","amountOff":5,`+window+`}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var p Promotion
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+		assert.Equal(t, "SUMMER24", p.Code)
+		assert.Equal(t, Cents(500), p.AmountOff)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Codes are unique", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO promotions").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/albums/promotions", `{"name":"Again","code":"SUMMER24","percentOff":5,`+window+`}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	expectAlbum := func() {
+		mock.ExpectQuery("SELECT id, title, artist, price_cents").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+				AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 1, 0, 0, "", "", nil, "published", nil))
+	}
+	now := time.Now()
+
+	t.Run("Effective price", func(t *testing.T) {
+		expectAlbum()
+		mock.ExpectQuery("FROM promotions WHERE starts_at <= \\$1 AND ends_at > \\$1").WithArgs(sqlmock.AnyArg(), "SUMMER24").
+			WillReturnRows(sqlmock.NewRows(promotionColumnNames).
+				AddRow(1, "Everything", "", 10, 0, "", "", now.Add(-time.Hour), now.Add(time.Hour), now).
+				AddRow(3, "Summer", "SUMMER24", 0, 500, "", "Rock", now.Add(-time.Hour), now.Add(time.Hour), now))
+
+		req, _ := http.NewRequest("GET", "/api/albums/4/effective-price?code=summer24", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var price EffectivePrice
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &price))
+		assert.Equal(t, Cents(1999), price.Price)
+		assert.Equal(t, Cents(1499), price.EffectivePrice)
+		if assert.NotNil(t, price.Promotion) {
+			assert.Equal(t, 3, price.Promotion.ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Code not valid for the album", func(t *testing.T) {
+		expectAlbum()
+		mock.ExpectQuery("FROM promotions").WithArgs(sqlmock.AnyArg(), "JAZZ10").
+			WillReturnRows(sqlmock.NewRows(promotionColumnNames).
+				AddRow(5, "Jazz", "JAZZ10", 10, 0, "", "Jazz", now.Add(-time.Hour), now.Add(time.Hour), now))
+
+		rr := send("GET", "/api/albums/4/effective-price?code=jazz10", "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "JAZZ10 is not valid")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("End", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE promotions SET ends_at").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(promotionColumnNames).AddRow(3, "Summer", "SUMMER24", 0, 500, "", "", starts, now, now))
+		assert.Equal(t, http.StatusOK, send("POST", "/api/albums/promotions/3/end", "").Code)
+
+		mock.ExpectQuery("UPDATE promotions SET ends_at").WithArgs(3).WillReturnRows(sqlmock.NewRows(promotionColumnNames))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/albums/promotions/3/end", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPublishPromotionChanges(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalWriter := db, priceChangedWriter
+	writer := &recordingWriter{}
+	db, priceChangedWriter = mockDB, writer
+	t.Cleanup(func() { db, priceChangedWriter = originalDB, originalWriter })
+
+	now := time.Now()
+	started := sqlmock.NewRows(promotionColumnNames).AddRow(1, "Rock week", "", 20, 0, "", "Rock", now.Add(-time.Minute), now.Add(time.Hour), now)
+	mock.ExpectQuery("UPDATE promotions SET start_published_at = \\$1 WHERE code IS NULL").WithArgs(now).WillReturnRows(started)
+	mock.ExpectQuery("FROM promotions WHERE starts_at <= \\$1").WithArgs(now, "").
+		WillReturnRows(sqlmock.NewRows(promotionColumnNames).AddRow(1, "Rock week", "", 20, 0, "", "Rock", now.Add(-time.Minute), now.Add(time.Hour), now))
+	mock.ExpectQuery("SELECT id, genre, price_cents FROM albums").WithArgs("", "Rock").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "genre", "price_cents"}).AddRow(4, "Rock", 2000).AddRow(7, "Rock", 1500))
+	mock.ExpectQuery("UPDATE promotions SET end_published_at = \\$1").WithArgs(now).WillReturnRows(sqlmock.NewRows(promotionColumnNames))
+
+	published, err := publishPromotionChanges(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, writer.messages, 2)
+	var event PriceChangedEvent
+	require.NoError(t, json.Unmarshal(writer.messages[0].Value, &event))
+	assert.Equal(t, "4", string(writer.messages[0].Key))
+	assert.Equal(t, PriceChangedEvent{AlbumID: "4", Price: 2000, EffectivePrice: 1600, PromotionID: 1, Reason: priceChangePromotionStarted, Timestamp: event.Timestamp}, event)
+}
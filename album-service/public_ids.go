@@ -0,0 +1,232 @@
+// public_ids.go - optional obfuscation of album IDs in the API. With PUBLIC_ID_ENCODING=sqids, albums
+// are exposed under short strings derived from their internal ID (e.g. "JgaEBg" instead of "42"), so
+// the catalog can't be scraped by counting up. The database, events and handlers keep using internal
+// IDs: album path parameters are decoded by decodeAlbumIDParam before handlers run, and response
+// fields tagged `id:"public"` are encoded by respondJSON.
+//
+// Services calling with Client-Type: internal (order-service) may also use internal IDs, and get
+// internal IDs back, so they can resolve the public ID a customer sent to the album's database ID
+// that events and inventory-service use. The header alone proves nothing, so such calls must carry
+// an API key with the albums:internal scope (see api_keys.go); without one they are refused. Only
+// the /api routes accept internal callers: public surfaces such as /storefront never do.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSqidsAlphabet  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	defaultSqidsMinLength = 6
+)
+
+// publicIDTag marks response fields holding an internal album ID
+const publicIDTag = "id"
+
+// clientTypeInternal is the Client-Type of other services of the store
+const clientTypeInternal = "internal"
+
+// internalClientKey marks requests authenticated by authenticateInternalClient
+const internalClientKey = "internalClient"
+
+// idCodec maps internal album IDs to the IDs clients see and back
+type idCodec interface {
+	encode(internal string) string
+	// decode returns the internal ID; false when public isn't an ID this codec produces
+	decode(public string) (string, bool)
+}
+
+// plainIDs exposes internal IDs unchanged (the default)
+type plainIDs struct{}
+
+func (plainIDs) encode(internal string) string { return internal }
+
+func (plainIDs) decode(public string) (string, bool) { return public, true }
+
+var publicIDs idCodec = plainIDs{}
+
+// loadPublicIDCodec reads PUBLIC_ID_ENCODING ("plain" or "sqids"), and for sqids PUBLIC_ID_ALPHABET
+// and PUBLIC_ID_MIN_LENGTH. Public IDs only stay stable while the alphabet does.
+func loadPublicIDCodec() error {
+	switch encoding := os.Getenv("PUBLIC_ID_ENCODING"); encoding {
+	case "", "plain":
+		publicIDs = plainIDs{}
+	case "sqids":
+		alphabet := os.Getenv("PUBLIC_ID_ALPHABET")
+		if alphabet == "" {
+			alphabet = defaultSqidsAlphabet
+		}
+		minLength := defaultSqidsMinLength
+		if v := os.Getenv("PUBLIC_ID_MIN_LENGTH"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 255 {
+				return fmt.Errorf("PUBLIC_ID_MIN_LENGTH %q is not a number from 0 to 255", v)
+			}
+			minLength = n
+		}
+		codec, err := newSqidsCodec(alphabet, minLength)
+		if err != nil {
+			return err
+		}
+		publicIDs = codec
+	default:
+		return fmt.Errorf("PUBLIC_ID_ENCODING %q, expected plain or sqids", encoding)
+	}
+	return nil
+}
+
+// authenticateInternalClient checks the API key of requests sent with Client-Type: internal and
+// marks them as internal callers. Other requests pass unchanged.
+func authenticateInternalClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Client-Type") != clientTypeInternal {
+			c.Next()
+			return
+		}
+		if checkAPIKeyScope(c, scopeAlbumsInternal, true) {
+			c.Set(internalClientKey, true)
+			c.Next()
+		}
+	}
+}
+
+// internalClient reports whether the request comes from an authenticated store service
+func internalClient(c *gin.Context) bool {
+	return c.GetBool(internalClientKey)
+}
+
+// decodeAlbumIDParam replaces a public :id path parameter with the internal ID. IDs the codec didn't
+// produce are reported as not found, like unknown albums, except that internal callers may pass
+// internal IDs.
+func decodeAlbumIDParam() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for i, p := range c.Params {
+			if p.Key != "id" {
+				continue
+			}
+			internal, ok := publicIDs.decode(p.Value)
+			if !ok && internalClient(c) {
+				_, err := strconv.ParseUint(p.Value, 10, 64)
+				internal, ok = p.Value, err == nil
+			}
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+				return
+			}
+			c.Params[i].Value = internal
+		}
+		c.Next()
+	}
+}
+
+// sqidsCodec encodes IDs with the Sqids algorithm (https://sqids.org), so any Sqids library
+// configured with the same alphabet and minimum length decodes them. The blocklist of words Sqids
+// avoids is not applied.
+type sqidsCodec struct {
+	alphabet  []byte // Shuffled once at construction, as Sqids does
+	minLength int
+}
+
+func newSqidsCodec(alphabet string, minLength int) (*sqidsCodec, error) {
+	if len(alphabet) < 3 {
+		return nil, fmt.Errorf("PUBLIC_ID_ALPHABET must have at least 3 characters")
+	}
+	seen := make(map[byte]bool, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		ch := alphabet[i]
+		if ch >= 0x80 || seen[ch] {
+			return nil, fmt.Errorf("PUBLIC_ID_ALPHABET must be unique ASCII characters")
+		}
+		seen[ch] = true
+	}
+	return &sqidsCodec{alphabet: sqidsShuffle([]byte(alphabet)), minLength: minLength}, nil
+}
+
+func (s *sqidsCodec) encode(internal string) string {
+	id, err := strconv.ParseUint(internal, 10, 64)
+	if err != nil {
+		return internal
+	}
+	return s.encodeNumber(id)
+}
+
+func (s *sqidsCodec) decode(public string) (string, bool) {
+	id, ok := s.decodeNumber(public)
+	// Several strings decode to the same number (e.g. with other padding); only the one encode
+	// produces is accepted, so each album has exactly one public ID
+	if !ok || s.encodeNumber(id) != public {
+		return "", false
+	}
+	return strconv.FormatUint(id, 10), true
+}
+
+func (s *sqidsCodec) encodeNumber(n uint64) string {
+	size := uint64(len(s.alphabet))
+	offset := (uint64(s.alphabet[n%size]) + 1) % size
+	alphabet := append(append([]byte{}, s.alphabet[offset:]...), s.alphabet[:offset]...)
+	prefix := alphabet[0]
+	slices.Reverse(alphabet)
+
+	id := append([]byte{prefix}, sqidsToID(n, alphabet[1:])...)
+	if len(id) < s.minLength {
+		id = append(id, alphabet[0])
+		for len(id) < s.minLength {
+			alphabet = sqidsShuffle(alphabet)
+			id = append(id, alphabet[:min(s.minLength-len(id), len(alphabet))]...)
+		}
+	}
+	return string(id)
+}
+
+func (s *sqidsCodec) decodeNumber(public string) (uint64, bool) {
+	if public == "" {
+		return 0, false
+	}
+	offset := bytes.IndexByte(s.alphabet, public[0])
+	if offset < 0 {
+		return 0, false
+	}
+	alphabet := append(append([]byte{}, s.alphabet[offset:]...), s.alphabet[:offset]...)
+	slices.Reverse(alphabet)
+
+	// The number runs up to the separator, alphabet[0]; anything after it is padding
+	separator, digits := alphabet[0], alphabet[1:]
+	var n uint64
+	for i := 1; i < len(public) && public[i] != separator; i++ {
+		d := bytes.IndexByte(digits, public[i])
+		if d < 0 || n > (^uint64(0)-uint64(d))/uint64(len(digits)) {
+			return 0, false
+		}
+		n = n*uint64(len(digits)) + uint64(d)
+	}
+	return n, true
+}
+
+func sqidsToID(n uint64, alphabet []byte) []byte {
+	var id []byte
+	size := uint64(len(alphabet))
+	for {
+		id = append([]byte{alphabet[n%size]}, id...)
+		n /= size
+		if n == 0 {
+			return id
+		}
+	}
+}
+
+func sqidsShuffle(alphabet []byte) []byte {
+	chars := append([]byte{}, alphabet...)
+	for i, j := 0, len(chars)-1; j > 0; i, j = i+1, j-1 {
+		r := (i*j + int(chars[i]) + int(chars[j])) % len(chars)
+		chars[i], chars[r] = chars[r], chars[i]
+	}
+	return chars
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSqidsIDs switches the API to sqids public IDs for one test
+func withSqidsIDs(t *testing.T) *sqidsCodec {
+	codec, err := newSqidsCodec(defaultSqidsAlphabet, defaultSqidsMinLength)
+	require.NoError(t, err)
+	original := publicIDs
+	publicIDs = codec
+	t.Cleanup(func() { publicIDs = original })
+	return codec
+}
+
+func TestSqidsCodec(t *testing.T) {
+	// Reference values of the Sqids spec for the default alphabet without a minimum length
+	codec, err := newSqidsCodec(defaultSqidsAlphabet, 0)
+	require.NoError(t, err)
+	for internal, public := range map[string]string{"0": "bM", "1": "Uk", "2": "gb", "3": "Ef", "9": "nJ"} {
+		assert.Equal(t, public, codec.encode(internal))
+		decoded, ok := codec.decode(public)
+		assert.True(t, ok)
+		assert.Equal(t, internal, decoded)
+	}
+
+	padded, err := newSqidsCodec(defaultSqidsAlphabet, 6)
+	require.NoError(t, err)
+	for _, internal := range []string{"1", "42", "1000000", "18446744073709551615"} {
+		public := padded.encode(internal)
+		assert.GreaterOrEqual(t, len(public), 6)
+		decoded, ok := padded.decode(public)
+		assert.True(t, ok, public)
+		assert.Equal(t, internal, decoded)
+	}
+	assert.NotEqual(t, padded.encode("42"), padded.encode("43"))
+
+	for _, public := range []string{"", "42", "Uk", "Uk-LWZ", "zzzzzzzzzzzzzzzzzzzz"} {
+		_, ok := padded.decode(public)
+		assert.False(t, ok, "%q is not an ID the codec produces", public)
+	}
+}
+
+func TestLoadPublicIDCodec(t *testing.T) {
+	t.Cleanup(func() { publicIDs = plainIDs{} })
+
+	t.Setenv("PUBLIC_ID_ENCODING", "sqids")
+	t.Setenv("PUBLIC_ID_ALPHABET", "k3G7QAe51FCsPW92uEOyq4Bg6Sp8YzVTmnU0liwDdHXLajZrfxNhobJIRcMvKt")
+	t.Setenv("PUBLIC_ID_MIN_LENGTH", "10")
+	require.NoError(t, loadPublicIDCodec())
+	assert.Len(t, publicIDs.encode("42"), 10)
+	defaults, err := newSqidsCodec(defaultSqidsAlphabet, 10)
+	require.NoError(t, err)
+	assert.NotEqual(t, defaults.encode("42"), publicIDs.encode("42"), "the alphabet changes the IDs")
+
+	t.Setenv("PUBLIC_ID_MIN_LENGTH", "-1")
+	assert.ErrorContains(t, loadPublicIDCodec(), "PUBLIC_ID_MIN_LENGTH")
+	t.Setenv("PUBLIC_ID_MIN_LENGTH", "")
+	t.Setenv("PUBLIC_ID_ALPHABET", "aab")
+	assert.ErrorContains(t, loadPublicIDCodec(), "unique")
+	t.Setenv("PUBLIC_ID_ENCODING", "uuid")
+	assert.ErrorContains(t, loadPublicIDCodec(), "expected plain or sqids")
+}
+
+func TestPublicAlbumIDs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+	codec := withSqidsIDs(t)
+	public := codec.encode("42")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	albumRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}).
+			AddRow(42, "Nevermind", "Nirvana", 1999, 1991, "Rock", "", "", "", 1, 0, 0, "", "", nil, "published", nil)
+	}
+
+	t.Run("Path IDs are decoded and response IDs encoded", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("42").WillReturnRows(albumRow())
+		rr := get("/api/albums/" + public)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"id":"`+public+`"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Storefront", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("42").WillReturnRows(albumRow())
+		rr := get("/storefront/albums/" + public)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"id":"`+public+`"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Internal IDs are not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/42").Code)
+		assert.Equal(t, http.StatusNotFound, get("/storefront/albums/43").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	const key = "ask_0123456789abcdef"
+	getInternal := func(path, apiKey string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", clientTypeInternal)
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectKey := func(scopes string) {
+		mock.ExpectQuery("FROM api_keys WHERE key_hash = \\$1").WithArgs(hashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).AddRow(7, "order-service", scopes, "ask_01234567", time.Now(), time.Now(), nil))
+	}
+
+	t.Run("order-service resolves the public ID of an order to the internal ID", func(t *testing.T) {
+		// order-service prices the order with the album ID the customer sent, which is the public one
+		expectKey(scopeAlbumsInternal)
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("42").WillReturnRows(albumRow())
+		mock.ExpectQuery("FROM promotions").WillReturnRows(sqlmock.NewRows(promotionColumnNames))
+		rr := getInternal("/api/v1/albums/"+public+"/effective-price?code=", key)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"albumId":"42"`, "the order is placed with the internal ID")
+		assert.Contains(t, rr.Body.String(), `"effectivePrice":19.99`)
+
+		// Internal IDs, as stored on orders, work too
+		expectKey(scopeAlbumsInternal)
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("42").WillReturnRows(albumRow())
+		assert.Equal(t, http.StatusOK, getInternal("/api/v1/albums/42", key).Code)
+		expectKey(scopeAlbumsInternal)
+		assert.Equal(t, http.StatusNotFound, getInternal("/api/v1/albums/not-an-id", key).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Client-Type internal alone doesn't expose internal IDs", func(t *testing.T) {
+		rr := getInternal("/api/v1/albums/42", "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "internal callers need an API key")
+
+		expectKey(scopeFeedsRead)
+		rr = getInternal("/api/v1/albums/42", key)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "the key needs the albums:internal scope")
+
+		assert.Equal(t, http.StatusNotFound, getInternal("/storefront/albums/42", "").Code, "storefronts never take internal IDs")
+		mock.ExpectQuery("FROM albums WHERE id").WithArgs("42").WillReturnRows(albumRow())
+		rr = getInternal("/storefront/albums/"+public, key)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"id":"`+public+`"`, "storefronts always answer with public IDs")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,299 @@
+// publish_retry.go - local retry of failed Kafka publishes. An album-created event, single or batched,
+// or an album-deleted event whose publish fails is queued in memory and retried with exponential backoff (1s, doubling up to 5m) until it is
+// delivered. The queue holds at most PUBLISH_RETRY_BUFFER_SIZE events (default 1000); a failed publish
+// that doesn't fit is dropped and counted. On shutdown the queued events are saved to
+// album_publish_retries, and the next instance to start replays them, so an outage spanning a deploy
+// doesn't lose them either. A crash still loses the queue.
+//
+// A write can fail after the broker stored the event, so a retry may publish it twice; consumers skip
+// events they have already handled.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultPublishRetryBufferSize = 1000
+	publishRetryBaseDelay         = time.Second
+	publishRetryMaxDelay          = 5 * time.Minute
+	publishRetryPollInterval      = time.Second
+	publishRetryWriteTimeout      = 10 * time.Second
+)
+
+// errPublishQueued marks a failed publish whose event was queued for retry
+var errPublishQueued = errors.New("queued for retry")
+
+// pendingPublish is a queued event and its retry state
+type pendingPublish struct {
+	topic       string // Base topic, resolved to its writer by publishRetryWriter
+	msg         kafka.Message
+	attempts    int // Failed publishes so far, including the original one
+	nextAttempt time.Time
+}
+
+// publishRetryBuffer is the bounded queue of failed publishes. One goroutine, started by start,
+// retries the events that are due.
+type publishRetryBuffer struct {
+	size      int
+	writerFor func(topic string) messageWriter
+
+	mu      sync.Mutex
+	pending []*pendingPublish
+	closed  bool // Set once the queue was saved; later failures are dropped
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// publishRetries holds the failed publishes of this instance, sized by loadPublishRetryConfig
+var publishRetries = newPublishRetryBuffer(defaultPublishRetryBufferSize, publishRetryWriter)
+
+func newPublishRetryBuffer(size int, writerFor func(topic string) messageWriter) *publishRetryBuffer {
+	return &publishRetryBuffer{size: size, writerFor: writerFor, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// loadPublishRetryConfig reads PUBLISH_RETRY_BUFFER_SIZE
+func loadPublishRetryConfig() error {
+	size := defaultPublishRetryBufferSize
+	if v := os.Getenv("PUBLISH_RETRY_BUFFER_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("PUBLISH_RETRY_BUFFER_SIZE %q is not a positive integer", v)
+		}
+		size = n
+	}
+	publishRetries = newPublishRetryBuffer(size, publishRetryWriter)
+	return nil
+}
+
+// publishRetryWriter returns the writer of an album event topic
+func publishRetryWriter(topic string) messageWriter {
+	switch topic {
+	case albumCreatedTopic:
+		return kafkaWriter
+	case albumDeletedTopic:
+		return albumDeletedWriter
+	case priceProposalsTopic:
+		return priceProposalWriter
+	case priceChangedTopic:
+		return priceChangedWriter
+	case dailySalesTopic:
+		return dailySalesWriter
+	}
+	return nil
+}
+
+// publishRetryDelay is the wait before the next retry of an event that failed attempts times
+func publishRetryDelay(attempts int) time.Duration {
+	delay := publishRetryBaseDelay
+	for i := 1; i < attempts && delay < publishRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, publishRetryMaxDelay)
+}
+
+// enqueue queues msgs of a base topic after their first publish failed. It reports whether all
+// of them were queued.
+func (b *publishRetryBuffer) enqueue(topic string, msgs ...kafka.Message) bool {
+	return b.add(topic, 1, time.Now().Add(publishRetryDelay(1)), msgs...)
+}
+
+func (b *publishRetryBuffer) add(topic string, attempts int, nextAttempt time.Time, msgs ...kafka.Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queued := true
+	for _, msg := range msgs {
+		if b.closed || len(b.pending) >= b.size {
+			log.Printf("Publish retry buffer full or closed, dropping %s event with key %q", topic, msg.Key)
+			publishRetriesDropped.WithLabelValues(topic).Inc()
+			queued = false
+			continue
+		}
+		b.pending = append(b.pending, &pendingPublish{topic: topic, msg: msg, attempts: attempts, nextAttempt: nextAttempt})
+	}
+	publishRetriesQueued.Set(float64(len(b.pending)))
+	return queued
+}
+
+// queued returns the number of queued events
+func (b *publishRetryBuffer) queued() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// retryDue publishes the queued events that are due at now, oldest first. An event that isn't due
+// or fails holds back the later events of its topic, so events of one topic keep their order.
+func (b *publishRetryBuffer) retryDue(ctx context.Context, now time.Time) {
+	b.mu.Lock()
+	pending := slices.Clone(b.pending)
+	b.mu.Unlock()
+
+	blocked := make(map[string]bool)
+	delivered := make(map[*pendingPublish]bool)
+	for _, p := range pending {
+		if blocked[p.topic] || p.nextAttempt.After(now) {
+			blocked[p.topic] = true
+			continue
+		}
+		err := errors.New("no writer")
+		if writer := b.writerFor(p.topic); writer != nil {
+			writeCtx, cancel := context.WithTimeout(ctx, publishRetryWriteTimeout)
+			err = writer.WriteMessages(writeCtx, p.msg)
+			cancel()
+		}
+		b.mu.Lock()
+		if err != nil {
+			blocked[p.topic] = true
+			p.attempts++
+			p.nextAttempt = now.Add(publishRetryDelay(p.attempts))
+			log.Printf("Retry %d of %s event with key %q failed, next in %s: %v", p.attempts-1, p.topic, p.msg.Key, publishRetryDelay(p.attempts), err)
+		} else {
+			delivered[p] = true
+			publishRetriesDelivered.WithLabelValues(p.topic).Inc()
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kept := b.pending[:0]
+	for _, p := range b.pending {
+		if !delivered[p] {
+			kept = append(kept, p)
+		}
+	}
+	b.pending = kept
+	publishRetriesQueued.Set(float64(len(b.pending)))
+}
+
+// start retries due events in the background until stopAndSave
+func (b *publishRetryBuffer) start() {
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(publishRetryPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case now := <-ticker.C:
+				b.retryDue(context.Background(), now)
+			}
+		}
+	}()
+}
+
+// stopAndSave stops retrying and saves the queued events to album_publish_retries. Failures
+// queued afterwards are dropped.
+func (b *publishRetryBuffer) stopAndSave(ctx context.Context, db *sql.DB) error {
+	close(b.stop)
+	<-b.done
+
+	b.mu.Lock()
+	pending := b.pending
+	b.pending, b.closed = nil, true
+	publishRetriesQueued.Set(0)
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range pending {
+		headers, err := json.Marshal(p.msg.Headers)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO album_publish_retries (topic, message_key, message_value, headers, attempts) VALUES ($1, $2, $3, $4, $5)",
+			p.topic, p.msg.Key, p.msg.Value, headers, p.attempts)
+		if err != nil {
+			return fmt.Errorf("failed to save %s event: %w", p.topic, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Saved %d unsent events for the next instance to publish", len(pending))
+	return nil
+}
+
+// replayPublishRetries queues the events saved by instances that shut down, to be published right
+// away, oldest first. The rows are locked as they are read, so several starting instances don't replay
+// the same events, and each row is deleted once its event is queued: a row that can't be decoded, or
+// doesn't fit in the queue, stays for the next start.
+func replayPublishRetries(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type savedPublish struct {
+		id       int64
+		topic    string
+		msg      kafka.Message
+		headers  []byte
+		attempts int
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, topic, message_key, message_value, headers, attempts FROM album_publish_retries ORDER BY id FOR UPDATE SKIP LOCKED")
+	if err != nil {
+		return err
+	}
+	var saved []savedPublish
+	for rows.Next() {
+		var s savedPublish
+		if err := rows.Scan(&s.id, &s.topic, &s.msg.Key, &s.msg.Value, &s.headers, &s.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		saved = append(saved, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	replayed := 0
+	for _, s := range saved {
+		if err := json.Unmarshal(s.headers, &s.msg.Headers); err != nil {
+			log.Printf("Keeping saved %s event %d, its headers can't be read: %v", s.topic, s.id, err)
+			continue
+		}
+		if !publishRetries.add(s.topic, s.attempts, now, s.msg) {
+			break // The queue is full; the rest wait for the next start
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM album_publish_retries WHERE id = $1", s.id); err != nil {
+			return fmt.Errorf("failed to delete saved %s event %d: %w", s.topic, s.id, err)
+		}
+		replayed++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if replayed > 0 {
+		log.Printf("Replaying %d events saved by an earlier instance", replayed)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"album-store/kafkawriter"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPublishRetryConfig(t *testing.T) {
+	t.Cleanup(func() { publishRetries = newPublishRetryBuffer(defaultPublishRetryBufferSize, publishRetryWriter) })
+
+	require.NoError(t, loadPublishRetryConfig())
+	assert.Equal(t, defaultPublishRetryBufferSize, publishRetries.size)
+
+	t.Setenv("PUBLISH_RETRY_BUFFER_SIZE", "50")
+	require.NoError(t, loadPublishRetryConfig())
+	assert.Equal(t, 50, publishRetries.size)
+
+	t.Setenv("PUBLISH_RETRY_BUFFER_SIZE", "0")
+	assert.ErrorContains(t, loadPublishRetryConfig(), "not a positive integer")
+}
+
+func TestPublishRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, publishRetryDelay(1))
+	assert.Equal(t, 2*time.Second, publishRetryDelay(2))
+	assert.Equal(t, 8*time.Second, publishRetryDelay(4))
+	assert.Equal(t, publishRetryMaxDelay, publishRetryDelay(100))
+}
+
+func TestPublishRetryBuffer(t *testing.T) {
+	created, deleted := &recordingWriter{err: kafkawriter.ErrUnavailable}, &recordingWriter{}
+	writers := map[string]messageWriter{albumCreatedTopic: created, albumDeletedTopic: deleted}
+	buffer := newPublishRetryBuffer(3, func(topic string) messageWriter { return writers[topic] })
+	ctx := context.Background()
+
+	dropped := testutil.ToFloat64(publishRetriesDropped.WithLabelValues(albumCreatedTopic))
+	buffer.enqueue(albumCreatedTopic, kafka.Message{Key: []byte("1")}, kafka.Message{Key: []byte("2")})
+	buffer.enqueue(albumDeletedTopic, kafka.Message{Key: []byte("3")})
+	buffer.enqueue(albumCreatedTopic, kafka.Message{Key: []byte("4")})
+	assert.Equal(t, 3, buffer.queued())
+	assert.Equal(t, dropped+1, testutil.ToFloat64(publishRetriesDropped.WithLabelValues(albumCreatedTopic)), "the buffer is bounded")
+
+	buffer.retryDue(ctx, time.Now())
+	assert.Zero(t, created.calls+deleted.calls, "nothing is due before the first backoff")
+
+	// album-created still fails: its second event waits behind the first, album-deleted goes through
+	now := time.Now().Add(publishRetryBaseDelay)
+	buffer.retryDue(ctx, now)
+	assert.Equal(t, 1, created.calls)
+	assert.Equal(t, []string{"3"}, messageKeys(deleted.messages))
+	assert.Equal(t, 2, buffer.queued())
+
+	// The failed retry doubled the backoff, and the second event waits for the first
+	created.err = nil
+	buffer.retryDue(ctx, now.Add(time.Second))
+	assert.Empty(t, created.messages)
+	buffer.retryDue(ctx, now.Add(2*time.Second))
+	assert.Equal(t, []string{"1", "2"}, messageKeys(created.messages))
+	assert.Zero(t, buffer.queued())
+}
+
+func TestPublishRetryBuffer_SaveAndReplay(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	prev := publishRetries
+	t.Cleanup(func() { publishRetries = prev })
+	ctx := context.Background()
+
+	publishRetries = newPublishRetryBuffer(10, publishRetryWriter)
+	publishRetries.start()
+	publishRetries.enqueue(albumCreatedTopic, kafka.Message{Key: []byte("42"), Value: []byte(`{"albumId":"42"}`),
+		Headers: []kafka.Header{{Key: "traceparent", Value: []byte("00-abc-def-01")}}})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO album_publish_retries").
+		WithArgs(albumCreatedTopic, []byte("42"), []byte(`{"albumId":"42"}`), []byte(`[{"Key":"traceparent","Value":"MDAtYWJjLWRlZi0wMQ=="}]`), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, publishRetries.stopAndSave(ctx, mockDB))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	publishRetries.enqueue(albumCreatedTopic, kafka.Message{Key: []byte("43")})
+	assert.Zero(t, publishRetries.queued(), "failures after the save are dropped")
+
+	publishRetries = newPublishRetryBuffer(10, publishRetryWriter)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM album_publish_retries ORDER BY id FOR UPDATE SKIP LOCKED").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "topic", "message_key", "message_value", "headers", "attempts"}).
+			AddRow(7, albumCreatedTopic, []byte("42"), []byte(`{"albumId":"42"}`), []byte(`[{"Key":"traceparent","Value":"MDAtYWJjLWRlZi0wMQ=="}]`), 1).
+			AddRow(8, albumCreatedTopic, []byte("43"), []byte(`{}`), []byte(`not json`), 1).
+			AddRow(9, albumCreatedTopic, []byte("44"), []byte(`{}`), []byte(`[]`), 3))
+	mock.ExpectExec("DELETE FROM album_publish_retries WHERE id = \\$1").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM album_publish_retries WHERE id = \\$1").WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, replayPublishRetries(ctx, mockDB))
+	assert.NoError(t, mock.ExpectationsWereMet(), "an unreadable row is kept and doesn't stop the others")
+
+	require.Equal(t, 2, publishRetries.queued())
+	first := publishRetries.pending[0]
+	assert.Equal(t, "42", string(first.msg.Key), "replayed oldest first")
+	assert.Equal(t, "44", string(publishRetries.pending[1].msg.Key))
+	assert.Equal(t, []kafka.Header{{Key: "traceparent", Value: []byte("00-abc-def-01")}}, first.msg.Headers)
+	assert.Equal(t, 3, publishRetries.pending[1].attempts)
+	assert.False(t, first.nextAttempt.After(time.Now()), "replayed events are due right away")
+}
+
+func TestPublishAlbumCreated_QueuesFailures(t *testing.T) {
+	prevWriter, prevRetries := kafkaWriter, publishRetries
+	t.Cleanup(func() { kafkaWriter, publishRetries = prevWriter, prevRetries })
+	kafkaWriter = &recordingWriter{err: kafkawriter.ErrUnavailable}
+	publishRetries = newPublishRetryBuffer(10, publishRetryWriter)
+
+	assert.ErrorIs(t, publishAlbumCreated(context.Background(), Album{ID: "42", Title: "Blue Train"}), kafkawriter.ErrUnavailable)
+	require.Equal(t, 1, publishRetries.queued())
+	assert.Equal(t, albumCreatedTopic, publishRetries.pending[0].topic)
+	assert.Equal(t, "42", string(publishRetries.pending[0].msg.Key))
+
+	writer := &recordingWriter{}
+	kafkaWriter = writer
+	publishRetries.retryDue(context.Background(), time.Now().Add(publishRetryBaseDelay))
+	assert.Equal(t, []string{"42"}, messageKeys(writer.messages))
+}
+
+func messageKeys(msgs []kafka.Message) []string {
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = string(msg.Key)
+	}
+	return keys
+}
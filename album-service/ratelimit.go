@@ -0,0 +1,58 @@
+// ratelimit.go - per-client fixed-window rate limiting
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// clientRateLimiter allows each client (usually the client IP) limit requests per window.
+// Expired windows are pruned once per window, so memory follows the number of active clients.
+type clientRateLimiter struct {
+	mu         sync.Mutex
+	clients    map[string]*clientWindow
+	limit      int
+	window     time.Duration
+	lastPruned time.Time
+}
+
+// clientWindow is a fixed-window request counter for one client
+type clientWindow struct {
+	start time.Time
+	count int
+}
+
+func newClientRateLimiter(limit int, window time.Duration) *clientRateLimiter {
+	return &clientRateLimiter{
+		clients: make(map[string]*clientWindow),
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// allowAt reports whether the client is still within its budget for the window containing now
+func (l *clientRateLimiter) allowAt(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPruned) >= l.window {
+		for c, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, c)
+			}
+		}
+		l.lastPruned = now
+	}
+
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.clients[client] = &clientWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReplicaRouting(t *testing.T) {
+	primaryDB, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	defer primaryDB.Close()
+	replica, replicaMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer replica.Close()
+	originalDB := db
+	db, replicaDB = primaryDB, replica
+	t.Cleanup(func() {
+		db, replicaDB = originalDB, nil
+		replicaUp.Store(false)
+	})
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+	expectAlbum := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", 1, 0, 0, "", "", nil, albumPublished, nil))
+	}
+	get := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Reads use the replica while it is up", func(t *testing.T) {
+		replicaMock.ExpectPing()
+		checkReadReplica()
+		assert.Equal(t, 1.0, testutil.ToFloat64(dbReplicaUp))
+
+		expectAlbum(replicaMock)
+		assert.Equal(t, http.StatusOK, get("/api/albums/4"))
+		replicaMock.ExpectQuery("FROM albums a").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		assert.Equal(t, http.StatusOK, get("/api/albums?limit=10"))
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+	})
+
+	t.Run("Writes use the primary", func(t *testing.T) {
+		primary.ExpectBegin()
+		expectAuditActor(primary)
+		primary.ExpectExec("UPDATE albums SET status").WithArgs(albumArchived, "4").WillReturnResult(sqlmock.NewResult(0, 1))
+		primary.ExpectCommit()
+		expectAlbum(primary)
+		req, _ := http.NewRequest("POST", "/api/albums/4/archive", nil)
+		req.Header.Set("Client-Type", "admin")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+	})
+
+	t.Run("Reads fall back to the primary while the replica is down", func(t *testing.T) {
+		replicaMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		checkReadReplica()
+		assert.Equal(t, 0.0, testutil.ToFloat64(dbReplicaUp))
+
+		expectAlbum(primary)
+		assert.Equal(t, http.StatusOK, get("/api/albums/4"))
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,150 @@
+// redis_cache.go - optional Redis read-through cache of single-album lookups (GET /api/albums/:id and
+// GET /storefront/albums/:id), so hot product pages don't query the database on every request. It is
+// enabled by REDIS_URL. Entries hold the album as stored, before visibility, response profiles and
+// computed fields are applied, so one entry serves every caller. Every write to an album deletes its
+// entry after the commit. REDIS_CACHE_TTL (default 10m) bounds how long an entry can outlive a write
+// that raced with its read. Redis errors are logged and the lookup falls back to the database.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisCacheTTL = 10 * time.Minute
+	redisTimeout         = 2 * time.Second
+	albumCacheKeyPrefix  = "album-service:album:"
+	redisScanBatch       = 500
+)
+
+// Cache lookup results, the values of the result label of album_cache_lookups_total
+const (
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheError = "error" // Redis failed; the album was read from the database
+)
+
+// albumCache is nil unless REDIS_URL is set
+var (
+	albumCache    *redis.Client
+	albumCacheTTL = defaultRedisCacheTTL
+)
+
+// loadRedisCache connects the album cache when REDIS_URL is set. An unreachable Redis isn't an error:
+// lookups use the database until it is back.
+func loadRedisCache() error {
+	v := os.Getenv("REDIS_URL")
+	if v == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(v)
+	if err != nil {
+		return fmt.Errorf("REDIS_URL: %w", err)
+	}
+	ttl := defaultRedisCacheTTL
+	if v := os.Getenv("REDIS_CACHE_TTL"); v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return fmt.Errorf("REDIS_CACHE_TTL %q must be a positive duration such as 10m", v)
+		}
+	}
+	opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout = redisTimeout, redisTimeout, redisTimeout
+	albumCache, albumCacheTTL = redis.NewClient(opts), ttl
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := albumCache.Ping(ctx).Err(); err != nil {
+		log.Printf("Redis at %s is unreachable, album lookups use the database until it is back: %v", opts.Addr, err)
+	} else {
+		log.Printf("Caching album lookups in Redis at %s for %s", opts.Addr, ttl)
+	}
+	return nil
+}
+
+func albumCacheKey(id string) string {
+	return albumCacheKeyPrefix + id
+}
+
+// findAlbumCached is findAlbum through the cache. Only albums that exist are cached.
+func findAlbumCached(ctx context.Context, id string) (Album, error) {
+	if albumCache == nil {
+		return findAlbum(ctx, id)
+	}
+	data, err := albumCache.Get(ctx, albumCacheKey(id)).Bytes()
+	if err == nil {
+		var a Album
+		if err = json.Unmarshal(data, &a); err == nil {
+			albumCacheLookups.WithLabelValues(cacheHit).Inc()
+			return a, nil
+		}
+	}
+	if errors.Is(err, redis.Nil) {
+		albumCacheLookups.WithLabelValues(cacheMiss).Inc()
+	} else {
+		albumCacheLookups.WithLabelValues(cacheError).Inc()
+		log.Printf("Album cache lookup of %s failed: %v", id, err)
+	}
+
+	a, err := findAlbum(ctx, id)
+	if err != nil {
+		return a, err
+	}
+	if data, err := json.Marshal(a); err == nil {
+		if err := albumCache.Set(ctx, albumCacheKey(id), data, albumCacheTTL).Err(); err != nil {
+			log.Printf("Album cache store of %s failed: %v", id, err)
+		}
+	}
+	return a, nil
+}
+
+// invalidateAlbums deletes the cache entries of the albums with these database IDs. Call it after the
+// transaction changing them commits, or a lookup in between caches the old album again.
+func invalidateAlbums(ctx context.Context, ids ...string) {
+	if albumCache == nil || len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = albumCacheKey(id)
+	}
+	if err := albumCache.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Album cache invalidation of %v failed: %v", ids, err)
+	}
+}
+
+// invalidateAllAlbums deletes every album entry, after writes to many albums such as the popularity
+// job or an artist rename
+func invalidateAllAlbums(ctx context.Context) {
+	if albumCache == nil {
+		return
+	}
+	iter := albumCache.Scan(ctx, 0, albumCacheKeyPrefix+"*", redisScanBatch).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == redisScanBatch {
+			if err := albumCache.Del(ctx, keys...).Err(); err != nil {
+				log.Printf("Album cache flush failed: %v", err)
+				return
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Album cache flush failed: %v", err)
+		return
+	}
+	if len(keys) > 0 {
+		if err := albumCache.Del(ctx, keys...).Err(); err != nil {
+			log.Printf("Album cache flush failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRedisCache(t *testing.T) {
+	t.Cleanup(func() { albumCache, albumCacheTTL = nil, defaultRedisCacheTTL })
+
+	require.NoError(t, loadRedisCache())
+	assert.Nil(t, albumCache, "disabled unless configured")
+
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+server.Addr()+"/0")
+	t.Setenv("REDIS_CACHE_TTL", "1m")
+	require.NoError(t, loadRedisCache())
+	require.NotNil(t, albumCache)
+	assert.Equal(t, time.Minute, albumCacheTTL)
+
+	t.Setenv("REDIS_CACHE_TTL", "forever")
+	assert.ErrorContains(t, loadRedisCache(), "REDIS_CACHE_TTL")
+	t.Setenv("REDIS_URL", "localhost:6379")
+	assert.ErrorContains(t, loadRedisCache(), "REDIS_URL")
+}
+
+func TestAlbumCache(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	server := miniredis.RunT(t)
+	originalDB := db
+	db, albumCache = mockDB, redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { db, albumCache = originalDB, nil })
+
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+	expectAlbum := func(version int, status string) {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("4").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(4, "Nevermind", "Nirvana", 1999, 1991, "Rock", "LP", "", "", version, 4.5, 2, "", "",
+				[]byte(`[{"position":1,"title":"Smells Like Teen Spirit"}]`), status, []byte(`{"speed":"33"}`)))
+	}
+	get := func(path, clientType string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", clientType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	hits := albumCacheLookups.WithLabelValues(cacheHit)
+
+	t.Run("The second lookup is served from Redis", func(t *testing.T) {
+		expectAlbum(1, albumPublished)
+		first := get("/api/albums/4", "admin")
+		require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+		assert.True(t, server.Exists(albumCacheKey("4")))
+		ttl := server.TTL(albumCacheKey("4"))
+		assert.Equal(t, albumCacheTTL, ttl)
+
+		before := testutil.ToFloat64(hits)
+		second := get("/api/albums/4", "admin")
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, `"1"`, second.Header().Get("ETag"))
+		assert.Equal(t, before+1, testutil.ToFloat64(hits))
+
+		// One entry serves every caller: the storefront, and public callers without admin fields
+		public := get("/storefront/albums/4", "")
+		require.Equal(t, http.StatusOK, public.Code)
+		assert.NotContains(t, public.Body.String(), `"status"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Writes invalidate the entry", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectAuditActor(mock)
+		mock.ExpectExec("UPDATE albums SET status").WithArgs(albumArchived, "4").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAlbum(2, albumArchived)
+		req, _ := http.NewRequest("POST", "/api/albums/4/archive", nil)
+		req.Header.Set("Client-Type", "admin")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		assert.False(t, server.Exists(albumCacheKey("4")))
+
+		expectAlbum(2, albumArchived)
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/4", "").Code, "the archived album is read again")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing albums aren't cached", func(t *testing.T) {
+		mock.ExpectQuery("FROM albums WHERE id = \\$1").WithArgs("99").WillReturnRows(sqlmock.NewRows(albumColumns))
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/99", "admin").Code)
+		assert.False(t, server.Exists(albumCacheKey("99")))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		for _, id := range []string{"1", "2", "3"} {
+			server.Set(albumCacheKey(id), "{}")
+		}
+		server.Set("inventory-service:inventory:1", "{}")
+		invalidateAllAlbums(context.Background())
+		assert.Equal(t, []string{"inventory-service:inventory:1"}, server.Keys())
+	})
+
+	t.Run("Lookups fall back to the database when Redis is down", func(t *testing.T) {
+		server.Close()
+		expectAlbum(2, albumPublished)
+		assert.Equal(t, http.StatusOK, get("/api/albums/4", "admin").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
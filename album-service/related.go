@@ -0,0 +1,136 @@
+// related.go - "you may also like" albums. Until there is a recommendation service, related albums are
+// scored by what they share with the album: the artist, the genre and the release decade.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRelatedAlbums = 10
+	maxRelatedAlbums     = 50
+
+	// Score weights. An album by the same artist outranks one sharing both genre and era.
+	relatedArtistWeight = 4
+	relatedGenreWeight  = 2
+	relatedEraWeight    = 1
+)
+
+// relatedAlbumsQuery scores every other published album against album $1 and returns the best $2,
+// most popular first among equal scores. Albums sharing nothing are left out. %s limits the album
+// itself to those the caller may see.
+var relatedAlbumsQuery = fmt.Sprintf(`SELECT r.id, r.title, r.artist, r.price_cents, r.release_year, r.genre, COALESCE(r.format, ''),
+		COALESCE(r.upc, ''), COALESCE(r.catalog_number, ''), r.version, r.average_rating, r.review_count
+	FROM albums a
+	JOIN albums r ON r.id <> a.id
+	CROSS JOIN LATERAL (SELECT
+		CASE WHEN r.artist_id = a.artist_id OR lower(r.artist) = lower(a.artist) THEN %d ELSE 0 END +
+		CASE WHEN r.genre = a.genre THEN %d ELSE 0 END +
+		CASE WHEN r.release_year / 10 = a.release_year / 10 THEN %d ELSE 0 END AS score) s
+	WHERE a.id = $1 AND r.status = '%s' AND s.score > 0%%s
+	ORDER BY s.score DESC, r.popularity_score DESC, r.id
+	LIMIT $2`, relatedArtistWeight, relatedGenreWeight, relatedEraWeight, albumPublished)
+
+// parseRelatedLimit reads ?limit= of the related albums endpoints
+func parseRelatedLimit(c *gin.Context) (int, error) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultRelatedAlbums, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxRelatedAlbums {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxRelatedAlbums)
+	}
+	return limit, nil
+}
+
+// findRelatedAlbums returns up to limit albums related to album id, best first; sql.ErrNoRows when
+// the album doesn't exist. visible is the caller's visibleAlbumCondition for the album.
+func findRelatedAlbums(ctx context.Context, id string, limit int, visible string) ([]Album, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(relatedAlbumsQuery, visible), id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []Album{}
+	for rows.Next() {
+		var a Album
+		var dbID int
+		if err := rows.Scan(&dbID, &a.Title, &a.Artist, &a.Price, &a.ReleaseYear, &a.Genre, &a.Format, &a.UPC, &a.CatalogNumber, &a.Version, &a.AverageRating, &a.ReviewCount); err != nil {
+			return nil, fmt.Errorf("scan album row: %w", err)
+		}
+		a.ID = strconv.Itoa(dbID)
+		albums = append(albums, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(albums) == 0 {
+		// Nothing related, or no such album
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM albums a WHERE id = $1"+visible+")", id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return albums, nil
+}
+
+// relatedAlbums runs the shared part of the related albums handlers; false when it has responded
+func relatedAlbums(c *gin.Context, id string) ([]Album, bool) {
+	limit, err := parseRelatedLimit(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	albums, err := findRelatedAlbums(c.Request.Context(), id, limit, visibleAlbumCondition(c, "a."))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query related albums: " + err.Error()})
+		return nil, false
+	}
+	for i := range albums {
+		withComputedFields(c, &albums[i])
+	}
+	return albums, true
+}
+
+// getRelatedAlbums handles GET /api/albums/:id/related
+func getRelatedAlbums(c *gin.Context) {
+	if albums, ok := relatedAlbums(c, c.Param("id")); ok {
+		respondJSON(c, http.StatusOK, albums)
+	}
+}
+
+// getStorefrontRelatedAlbums handles GET /storefront/albums/:id/related. Malformed IDs are reported
+// as not found, like getStorefrontAlbum.
+func getStorefrontRelatedAlbums(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	albums, ok := relatedAlbums(c, strconv.Itoa(id))
+	if !ok {
+		return
+	}
+	public := make([]storefrontAlbum, 0, len(albums))
+	for _, a := range albums {
+		public = append(public, toStorefrontAlbum(a))
+	}
+	c.JSON(http.StatusOK, public)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRelatedAlbums(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	columns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count"}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Best scores first", func(t *testing.T) {
+		mock.ExpectQuery(`JOIN albums r ON r.id <> a.id.*WHERE a.id = \$1 AND r.status = 'published' AND s.score > 0 AND a.status = 'published' ORDER BY s.score DESC`).WithArgs("4", 3).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(5, "In Utero", "Nirvana", 1899, 1993, "Rock", "LP", "", "", 1, 4.0, 3).
+				AddRow(6, "Ten", "Pearl Jam", 1499, 1991, "Rock", "CD", "", "", 1, 0.0, 0))
+
+		rr := get("/api/albums/4/related?limit=3")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Regexp(t, `^\[\{"id":"5","title":"In Utero".*\{"id":"6","title":"Ten"`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing related", func(t *testing.T) {
+		mock.ExpectQuery("JOIN albums r").WithArgs("4", defaultRelatedAlbums).WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("4").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		rr := get("/api/albums/4/related")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "[]", rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectQuery("JOIN albums r").WithArgs("99", defaultRelatedAlbums).WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("99").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		assert.Equal(t, http.StatusNotFound, get("/api/albums/99/related").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Bad limit", func(t *testing.T) {
+		for _, limit := range []string{"0", "51", "ten"} {
+			assert.Equal(t, http.StatusBadRequest, get("/api/albums/4/related?limit="+limit).Code, limit)
+		}
+	})
+
+	t.Run("Storefront projection", func(t *testing.T) {
+		mock.ExpectQuery("JOIN albums r").WithArgs("4", defaultRelatedAlbums).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(5, "In Utero", "Nirvana", 1899, 1993, "Rock", "LP", "0720642453622", "", 1, 4.0, 3))
+
+		rr := get("/storefront/albums/4/related")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"title":"In Utero"`)
+		assert.NotContains(t, rr.Body.String(), "0720642453622")
+		assert.Equal(t, cacheStorefront.CacheControl, rr.Header().Get("Cache-Control"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, http.StatusNotFound, get("/storefront/albums/abc/related").Code)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	original := maxRequestBodyBytes
+	maxRequestBodyBytes = 64
+	t.Cleanup(func() { maxRequestBodyBytes = original })
+
+	body := `{"title": "` + strings.Repeat("x", 100) + `"}`
+	send := func(path string, r io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, r)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Announced by Content-Length", func(t *testing.T) {
+		rr := send("/api/v1/albums", strings.NewReader(body))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Contains(t, rr.Body.String(), "at most 64 bytes")
+	})
+
+	t.Run("Found while reading", func(t *testing.T) {
+		// Without a known length the body is sent chunked
+		rr := send("/api/v1/albums", io.MultiReader(strings.NewReader(body)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		rr = send("/graphql", io.MultiReader(strings.NewReader(`{"query": "`+strings.Repeat(" ", 100)+`{ albums { id } }"}`)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("Uploads have their own limit", func(t *testing.T) {
+		rr := send("/api/v1/albums/import/discogs", bytes.NewReader([]byte("Catalog#,Artist,Title\n"+strings.Repeat(" ", 100))))
+		assert.NotEqual(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+}
+
+func TestLoadRequestBodyLimit(t *testing.T) {
+	t.Cleanup(func() { maxRequestBodyBytes = defaultMaxRequestBodyBytes })
+
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "4096")
+	require.NoError(t, loadRequestBodyLimit())
+	assert.Equal(t, int64(4096), maxRequestBodyBytes)
+
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "")
+	require.NoError(t, loadRequestBodyLimit())
+	assert.Equal(t, int64(defaultMaxRequestBodyBytes), maxRequestBodyBytes)
+
+	for _, invalid := range []string{"0", "-1", "1MB"} {
+		t.Setenv("MAX_REQUEST_BODY_BYTES", invalid)
+		assert.Error(t, loadRequestBodyLimit(), invalid)
+	}
+}
@@ -0,0 +1,145 @@
+// response_profile.go - response serialization profiles, so admin-only fields never reach public callers.
+// Fields are marked in the response types with a `profile:"admin"` tag and removed in one place,
+// respondJSON, instead of by each handler. respondJSON also encodes album IDs (see public_ids.go).
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response profiles, selected by the caller's Client-Type. Internal callers, once authenticated (see
+// public_ids.go), see what public ones do, with internal album IDs.
+const (
+	profilePublic   = "public"
+	profileAdmin    = "admin"
+	profileInternal = "internal"
+)
+
+// profileTag marks a field as visible only to the named profile. Such fields must be omitempty: they
+// are zeroed for other callers, which leaves them out of the JSON.
+const profileTag = "profile"
+
+// responseProfile returns the profile of the caller
+func responseProfile(c *gin.Context) string {
+	switch c.GetHeader("Client-Type") {
+	case "admin":
+		return profileAdmin
+	case clientTypeInternal:
+		if internalClient(c) {
+			return profileInternal
+		}
+	}
+	return profilePublic
+}
+
+// respondJSON writes obj as JSON with the fields the caller's profile may not see removed, and only
+// the fields the request selects (see field_selection.go). Responses differ by Client-Type, so shared
+// caches are told to key on it.
+func respondJSON(c *gin.Context, status int, obj interface{}) {
+	c.Header("Vary", "Client-Type")
+	keep, err := selectedFields(c, obj)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	obj = forProfile(obj, responseProfile(c))
+	if keep == nil {
+		c.JSON(status, obj)
+		return
+	}
+	body, err := json.Marshal(obj)
+	if err == nil {
+		body, err = selectJSONFields(body, keep)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode response: " + err.Error()})
+		return
+	}
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// forProfile returns obj, or a copy of it with the fields hidden from profile zeroed and album IDs
+// encoded
+func forProfile(obj interface{}, profile string) interface{} {
+	_, plain := publicIDs.(plainIDs)
+	if obj == nil || (profile == profileAdmin && plain) {
+		return obj
+	}
+	return responseCopy(reflect.ValueOf(obj), profile).Interface()
+}
+
+// responseCopy copies v, zeroing the fields hidden from profile and encoding the album ID fields of
+// every struct it reaches, except for internal callers. The original is never modified, since handlers may still use it (e.g. to
+// publish events).
+func responseCopy(v reflect.Value, profile string) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type().Elem())
+		cp.Elem().Set(responseCopy(v.Elem(), profile))
+		return cp
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(responseCopy(v.Elem(), profile))
+		return cp
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			switch {
+			case profile != profileAdmin && adminOnly(field):
+				cp.Field(i).Set(reflect.Zero(field.Type))
+			case field.Tag.Get(publicIDTag) == "public" && field.Type.Kind() == reflect.String && profile != profileInternal:
+				cp.Field(i).SetString(publicIDs.encode(v.Field(i).String()))
+			default:
+				cp.Field(i).Set(responseCopy(v.Field(i), profile))
+			}
+		}
+		return cp
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(responseCopy(v.Index(i), profile))
+		}
+		return cp
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), responseCopy(iter.Value(), profile))
+		}
+		return cp
+	}
+	return v
+}
+
+// adminOnly reports whether a struct field is tagged for the admin profile only
+func adminOnly(field reflect.StructField) bool {
+	for _, p := range strings.Split(field.Tag.Get(profileTag), ",") {
+		if p == profileAdmin {
+			return true
+		}
+	}
+	return false
+}
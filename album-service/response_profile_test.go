@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForProfile(t *testing.T) {
+	qty := 7
+	album := Album{ID: "1", Title: "Kind of Blue", InitialQuantity: &qty, PriceFloorOverride: &PriceFloorOverride{Reason: "Promo"}}
+
+	public := forProfile(album, profilePublic).(Album)
+	assert.Nil(t, public.InitialQuantity)
+	assert.Nil(t, public.PriceFloorOverride)
+	assert.Equal(t, "Kind of Blue", public.Title)
+	assert.Equal(t, &qty, album.InitialQuantity, "the original must not be modified")
+
+	assert.Equal(t, album, forProfile(album, profileAdmin))
+
+	t.Run("Nested responses", func(t *testing.T) {
+		result := forProfile(BatchCreateResult{Created: []Album{album}}, profilePublic).(BatchCreateResult)
+		assert.Nil(t, result.Created[0].InitialQuantity)
+
+		body, err := json.Marshal(forProfile(gin.H{"album": &album}, profilePublic))
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "initialQuantity")
+	})
+}
+
+func TestRespondJSON(t *testing.T) {
+	qty := 3
+	for _, tc := range []struct {
+		clientType   string
+		wantQuantity bool
+	}{
+		{"admin", true},
+		{"user", false},
+		{"", false},
+	} {
+		rr := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rr)
+		c.Request, _ = http.NewRequest("GET", "/api/albums/1", nil)
+		c.Request.Header.Set("Client-Type", tc.clientType)
+
+		respondJSON(c, http.StatusOK, Album{ID: "1", InitialQuantity: &qty})
+		assert.Equal(t, tc.wantQuantity, strings.Contains(rr.Body.String(), "initialQuantity"), "Client-Type %q", tc.clientType)
+		assert.Equal(t, "Client-Type", rr.Header().Get("Vary"))
+	}
+}
+
+// Admin-only fields are zeroed for public callers, which only hides them when they are omitempty
+func TestAdminOnlyFieldsAreOmitEmpty(t *testing.T) {
+	for _, typ := range []reflect.Type{reflect.TypeOf(Album{})} {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if adminOnly(field) {
+				assert.Contains(t, field.Tag.Get("json"), ",omitempty", "%s.%s", typ.Name(), field.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// reviews.go - customer reviews. Anyone can rate an album from one to five stars with an optional
+// comment; admins moderate by deleting reviews. Every change refreshes the album's average_rating and
+// review_count (the columns the popularity job recomputes too) in the same transaction, and bumps
+// the album version, so album reads and their ETags reflect the new rating immediately.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const reviewsPerClientPerHour = 10
+
+// reviewLimiter caps reviews per client IP, so one client can't flood an album's rating
+var reviewLimiter = newClientRateLimiter(reviewsPerClientPerHour, time.Hour)
+
+// Review is one customer review of an album
+type Review struct {
+	ID        int       `json:"id"`
+	AlbumID   string    `json:"albumId" id:"public"`
+	Author    string    `json:"author" binding:"required,max=100"`
+	Rating    int       `json:"rating" binding:"required,min=1,max=5"`
+	Comment   string    `json:"comment,omitempty" binding:"max=2000"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// lockAlbumForReview locks the album row, so concurrent review changes refresh its rating one after
+// the other and each sees the reviews committed before it. Returns sql.ErrNoRows for unknown albums.
+func lockAlbumForReview(ctx context.Context, tx *sql.Tx, albumID int) error {
+	var id int
+	return tx.QueryRowContext(ctx, "SELECT id FROM albums WHERE id = $1 FOR UPDATE", albumID).Scan(&id)
+}
+
+// refreshAlbumRating recomputes the album's rating aggregates from its reviews and bumps its version
+func refreshAlbumRating(ctx context.Context, tx *sql.Tx, albumID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE albums SET
+			average_rating = COALESCE((SELECT AVG(rating) FROM album_reviews WHERE album_id = $1), 0),
+			review_count = (SELECT COUNT(*) FROM album_reviews WHERE album_id = $1),
+			version = version + 1
+		WHERE id = $1`, albumID)
+	return err
+}
+
+// createReview handles POST /api/albums/:id/reviews
+func createReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || albumID <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if !reviewLimiter.allowAt(c.ClientIP(), time.Now()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many reviews, try again later"})
+		return
+	}
+	var r Review
+	if err := c.ShouldBindJSON(&r); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	r.Author = strings.TrimSpace(r.Author)
+	r.Comment = strings.TrimSpace(r.Comment)
+	if r.Author == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "author must not be blank"})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockAlbumForReview(ctx, tx, albumID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO album_reviews (album_id, rating, author, comment) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at",
+		albumID, r.Rating, r.Author, r.Comment).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review: " + err.Error()})
+		return
+	}
+	if err := refreshAlbumRating(ctx, tx, albumID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album rating: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review: " + err.Error()})
+		return
+	}
+	invalidateAlbums(ctx, strconv.Itoa(albumID)) // Its rating changed
+
+	r.AlbumID = strconv.Itoa(albumID)
+	respondJSON(c, http.StatusCreated, r)
+}
+
+var reviewListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "createdAt", Expr: "created_at"}, {Name: "rating", Expr: "rating"}},
+	Filterable:  []listing.Field{{Name: "rating", Expr: "rating::text"}},
+	DefaultSort: []listing.SortKey{{Expr: "created_at", Desc: true}},
+	Unique:      "id",
+}
+
+// getReviews handles GET /api/albums/:id/reviews, newest first. ?rating=4,5 narrows the list.
+func getReviews(c *gin.Context) {
+	ctx := c.Request.Context()
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	page, err := listing.ParseParams(c, reviewListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := []interface{}{albumID}
+	where := listing.WhereClause(append([]string{"album_id = $1"}, page.Conditions(&args)...))
+	countArgs := args
+	// Reviews from before the review text was kept have a rating only
+	query := "SELECT id, rating, COALESCE(author, ''), COALESCE(comment, ''), created_at FROM album_reviews" +
+		where + page.OrderByClause() + page.PageClause(&args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query reviews: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		r := Review{AlbumID: strconv.Itoa(albumID)}
+		if err := rows.Scan(&r.ID, &r.Rating, &r.Author, &r.Comment, &r.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read reviews: " + err.Error()})
+			return
+		}
+		reviews = append(reviews, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read reviews: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(reviews), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM album_reviews"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count reviews: " + err.Error()})
+		return
+	}
+	meta.SetHeaders(c)
+	respondJSON(c, http.StatusOK, reviews)
+}
+
+// deleteReview handles DELETE /api/albums/:id/reviews/:reviewId, the moderation endpoint
+func deleteReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	reviewID, err := strconv.Atoi(c.Param("reviewId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockAlbumForReview(ctx, tx, albumID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM album_reviews WHERE id = $1 AND album_id = $2", reviewID, albumID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review: " + err.Error()})
+		return
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get affected rows: " + err.Error()})
+		return
+	}
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+	if err := refreshAlbumRating(ctx, tx, albumID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update album rating: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review: " + err.Error()})
+		return
+	}
+	invalidateAlbums(ctx, strconv.Itoa(albumID))
+
+	log.Printf("Review %d of album %d removed by %s", reviewID, albumID, c.ClientIP())
+	c.Status(http.StatusNoContent)
+}
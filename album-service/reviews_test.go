@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB, originalLimiter := db, reviewLimiter
+	db, reviewLimiter = mockDB, newClientRateLimiter(100, time.Hour)
+	t.Cleanup(func() { db, reviewLimiter = originalDB, originalLimiter })
+
+	send := func(method, path, body string, admin bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if admin {
+			req.Header.Set("Client-Type", "admin")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectLock := func(found bool) {
+		rows := sqlmock.NewRows([]string{"id"})
+		if found {
+			rows.AddRow(4)
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM albums WHERE id = \\$1 FOR UPDATE").WithArgs(4).WillReturnRows(rows)
+	}
+	expectRefresh := func() {
+		mock.ExpectExec("UPDATE albums SET\\s+average_rating").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	t.Run("Create validates the review", func(t *testing.T) {
+		for _, body := range []string{
+			`{"author":"Ann","rating":0}`,
+			`{"author":"Ann","rating":6}`,
+			`{"rating":5}`,
+			`{"author":"   ","rating":5}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, send("POST", "/api/albums/4/reviews", body, false).Code, body)
+		}
+	})
+
+	t.Run("Create refreshes the album rating", func(t *testing.T) {
+		expectLock(true)
+		mock.ExpectQuery("INSERT INTO album_reviews").WithArgs(4, 5, "Ann", "A classic").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(9, time.Now()))
+		expectRefresh()
+
+		rr := send("POST", "/api/albums/4/reviews", `{"author":" Ann ","rating":5,"comment":"A classic "}`, false)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var r Review
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &r))
+		assert.Equal(t, 9, r.ID)
+		assert.Equal(t, "Ann", r.Author)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown albums are not found", func(t *testing.T) {
+		expectLock(false)
+		mock.ExpectRollback()
+		assert.Equal(t, http.StatusNotFound, send("POST", "/api/albums/4/reviews", `{"author":"Ann","rating":5}`, false).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("FROM album_reviews WHERE album_id = \\$1 AND rating::text IN \\(\\$2\\) ORDER BY created_at DESC, id ASC LIMIT").
+			WithArgs(4, "5", listing.DefaultLimit, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "rating", "author", "comment", "created_at"}).AddRow(9, 5, "Ann", "A classic", time.Now()))
+
+		rr := send("GET", "/api/albums/4/reviews?rating=5", "", false)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var reviews []Review
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &reviews))
+		require.Len(t, reviews, 1)
+		assert.Equal(t, "A classic", reviews[0].Comment)
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Moderation needs admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("DELETE", "/api/albums/4/reviews/9", "", false).Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		expectLock(true)
+		mock.ExpectExec("DELETE FROM album_reviews WHERE id = \\$1 AND album_id = \\$2").WithArgs(9, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRefresh()
+		assert.Equal(t, http.StatusNoContent, send("DELETE", "/api/albums/4/reviews/9", "", true).Code)
+
+		expectLock(true)
+		mock.ExpectExec("DELETE FROM album_reviews").WithArgs(9, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		assert.Equal(t, http.StatusNotFound, send("DELETE", "/api/albums/4/reviews/9", "", true).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reviews are rate limited", func(t *testing.T) {
+		reviewLimiter = newClientRateLimiter(1, time.Hour)
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/albums/4/reviews", `{}`, false).Code)
+		assert.Equal(t, http.StatusTooManyRequests, send("POST", "/api/albums/4/reviews", `{"author":"Ann","rating":5}`, false).Code)
+	})
+}
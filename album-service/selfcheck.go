@@ -0,0 +1,292 @@
+// selfcheck.go - --check mode: validate configuration and dependencies, print a report and exit
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/kafkawriter"
+	"album-store/redaction"
+	"album-store/topics"
+)
+
+// albumSchema lists the tables and columns album-service relies on
+var albumSchema = map[string][]string{
+	"albums":                {"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "release_date", "label", "tracks", "attributes", "average_rating", "review_count", "popularity_score", "stats_updated_at", "artist_id", "version", "status"},
+	"album_reviews":         {"album_id", "rating", "created_at", "author", "comment"},
+	"album_daily_views":     {"album_id", "day", "views"},
+	"album_imports":         {"id", "source", "items", "created_at", "confirmed_at"},
+	"album_sales":           {"order_id", "album_id", "units", "sold_at", "revenue_cents", "currency"},
+	"daily_sales_days":      {"day", "time_zone", "summarized_at", "published_at"},
+	"daily_sales":           {"day", "album_id", "currency", "units", "orders", "revenue_cents"},
+	"price_floor_overrides": {"id", "album_id", "operation", "price_cents", "floor_cents", "reason", "client_ip", "created_at"},
+	"artists":               {"id", "name", "created_at"},
+	"albums_history":        {"id", "album_id", "title", "artist", "price_cents", "release_year", "genre", "format", "valid_from", "valid_to"},
+	"genres":                {"id", "name", "created_at"},
+	"price_proposals":       {"id", "album_id", "current_price_cents", "proposed_price_cents", "quantity", "idle_days", "reason", "status", "created_at", "decided_at", "decided_by"},
+	"promotions":            {"id", "name", "code", "percent_off", "amount_off_cents", "album_id", "genre", "starts_at", "ends_at", "start_published_at", "end_published_at", "created_at", "created_by"},
+	"price_history":         {"id", "album_id", "old_price_cents", "new_price_cents", "source", "reason", "changed_by", "changed_at"},
+	"album_audit":           {"id", "album_id", "action", "changes", "changed_by", "changed_at"},
+	"webhooks":              {"id", "url", "secret", "actions", "created_at", "created_by"},
+	"webhook_deliveries":    {"id", "webhook_id", "audit_id", "action", "album_id", "status", "attempts", "last_status_code", "last_error", "next_attempt_at", "created_at", "delivered_at"},
+	"pending_changes":       {"id", "action", "album_id", "base_version", "payload", "status", "submitted_by", "submitted_at", "decided_by", "decided_at", "reason"},
+	"api_keys":              {"id", "name", "key_prefix", "key_hash", "scopes", "created_at", "last_used_at", "revoked_at"},
+	"album_publish_retries": {"id", "topic", "message_key", "message_value", "headers", "attempts", "saved_at"},
+	"albums_cold":           {"album_id", "title", "artist", "album", "related", "frozen_at"},
+}
+
+// runSelfCheck runs every check, prints the report to out and returns the process exit code
+func runSelfCheck(out io.Writer) int {
+	report := &selfCheckReport{}
+
+	// Configuration
+	report.check("config: kafka topic naming", topics.LoadNaming(),
+		fmt.Sprintf("prefix=%q suffix=%q", topics.Prefix(), topics.Suffix()))
+	producerConfig, err := loadKafkaProducerConfig()
+	report.check("config: kafka producer", err, producerConfig.String())
+	_, err = redaction.NewRedactorFromEnv()
+	report.check("config: span redaction", err, "")
+	report.check("config: event schema versions", loadEventSchemaConfig(),
+		fmt.Sprintf("publish %v, consume v%d", eventPublishVersions, eventConsumeVersion))
+	report.check("config: price floors", loadPriceFloors(), fmt.Sprintf("default %s, %d genre overrides", priceFloors.Default, len(priceFloors.ByGenre)))
+	report.check("config: public IDs", loadPublicIDCodec(), fmt.Sprintf("encoding=%q", os.Getenv("PUBLIC_ID_ENCODING")))
+	report.check("config: clearance rule", loadClearanceRule(), fmt.Sprintf("%+v", clearance))
+	report.check("config: cold storage", loadColdStorageConfig(), fmt.Sprintf("after %d years", coldStorageAfterYears))
+	report.check("config: catalog approval", loadCatalogApproval(), fmt.Sprintf("enabled=%t", catalogApproval))
+	report.check("config: sales summary time zone", loadSalesSummaryTimezone(), salesSummaryLocation.String())
+	poolConfig, err := loadDBPoolConfig()
+	report.check("config: database pool", err, poolConfig.String())
+	report.check("config: migrations", loadMigrationConfig(), fmt.Sprintf("on startup=%t", migrateOnStartup))
+	report.check("config: API keys", loadAPIKeyConfig(), fmt.Sprintf("required on product feeds=%t", feedRequireAPIKey))
+	for _, name := range []string{"POPULARITY_JOB_INTERVAL", "VIEW_FLUSH_INTERVAL", "GENRE_REFRESH_INTERVAL", "CLEARANCE_JOB_INTERVAL",
+		"COLD_STORAGE_JOB_INTERVAL"} {
+		report.check("config: "+name, checkDurationEnv(name), "")
+	}
+	report.check("config: request body limit", loadRequestBodyLimit(), fmt.Sprintf("%d bytes", maxRequestBodyBytes))
+	report.check("config: event serialization", loadEventSerialization(), eventSerialization)
+	report.check("config: publish retries", loadPublishRetryConfig(), fmt.Sprintf("buffer of %d events", publishRetries.size))
+	for _, name := range []string{"VIEW_RATE_LIMIT_PER_MINUTE", "STOREFRONT_RATE_LIMIT_PER_MINUTE"} {
+		report.check("config: "+name, checkPositiveIntEnv(name), "")
+	}
+
+	// Database and schema
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	database, err := sql.Open("pgx", dbConnectionFromEnv())
+	if err == nil {
+		defer database.Close()
+		err = database.PingContext(ctx)
+	}
+	report.check("database", err, "connected")
+	if connStr := os.Getenv("DB_READ_CONNECTION"); connStr != "" {
+		// Reads fall back to the primary, so an unreachable replica is only a warning
+		replica, err := sql.Open("pgx", connStr)
+		if err == nil {
+			defer replica.Close()
+			err = replica.PingContext(ctx)
+		}
+		if err != nil {
+			report.record("database replica", selfCheckWarn, "unreachable, reads use the primary: "+err.Error())
+		} else {
+			report.record("database replica", selfCheckOK, "connected")
+		}
+	}
+	if err != nil {
+		report.record("schema migrations", selfCheckSkip, "database unavailable")
+		report.record("schema", selfCheckSkip, "database unavailable")
+	} else {
+		if version, pending, err := migrationState(ctx, database); err != nil {
+			report.check("schema migrations", err, "")
+		} else if len(pending) > 0 {
+			report.record("schema migrations", selfCheckWarn, fmt.Sprintf("at %d, pending: %s", version, strings.Join(pending, ", ")))
+		} else {
+			report.record("schema migrations", selfCheckOK, fmt.Sprintf("at %d, up to date", version))
+		}
+		if missing, err := missingSchema(ctx, database, albumSchema); err != nil {
+			report.check("schema", err, "")
+		} else if len(missing) > 0 {
+			report.record("schema", selfCheckWarn, "missing, added by a pending migration or dropped by hand: "+strings.Join(missing, ", "))
+		} else {
+			report.record("schema", selfCheckOK, "up to date")
+		}
+	}
+
+	// Kafka topics this service produces to and consumes from
+	broker := kafkaBrokerFromEnv()
+	var bases []string
+	for _, version := range eventPublishVersions {
+		for _, base := range []string{albumCreatedTopic, albumDeletedTopic, priceProposalsTopic, priceChangedTopic, dailySalesTopic} {
+			bases = append(bases, versionedTopic(base, version))
+		}
+	}
+	for _, base := range append(bases, versionedTopic(orderSucceededTopic, eventConsumeVersion)) {
+		topic := topics.Name(base)
+		topicCtx, topicCancel := context.WithTimeout(context.Background(), kafkawriter.HealthCheckTimeout)
+		report.check(fmt.Sprintf("kafka topic '%s'", topic), kafkawriter.CheckTopic(topicCtx, broker, topic), "exists on "+broker)
+		topicCancel()
+	}
+
+	// Schemas of Avro-encoded album events against the versions in the registry
+	if eventSerialization == eventSerializationAvro {
+		client, clientErr := newSchemaRegistryClient()
+		for _, base := range []string{albumCreatedTopic, albumDeletedTopic} {
+			name := fmt.Sprintf("schema registry subject '%s'", eventSchemaSubject(base))
+			version, err := 0, clientErr
+			if err == nil {
+				registryCtx, registryCancel := context.WithTimeout(context.Background(), schemaRegistryTimeout)
+				version, err = checkEventSchemaCompatible(registryCtx, client, base)
+				registryCancel()
+			}
+			detail := "not registered yet"
+			if version > 0 {
+				detail = fmt.Sprintf("compatible with version %d", version)
+			}
+			report.check(name, err, detail)
+		}
+	}
+
+	report.print(out, "album-service")
+	if report.failed() {
+		return 1
+	}
+	return 0
+}
+
+// --- Report ---
+
+const selfCheckTimeout = 5 * time.Second
+
+type selfCheckStatus string
+
+const (
+	selfCheckOK   selfCheckStatus = "OK"
+	selfCheckWarn selfCheckStatus = "WARN" // Reported, but doesn't fail the check
+	selfCheckFail selfCheckStatus = "FAIL"
+	selfCheckSkip selfCheckStatus = "SKIP" // Not run because a prerequisite failed
+)
+
+type selfCheckResult struct {
+	Name   string
+	Status selfCheckStatus
+	Detail string
+}
+
+type selfCheckReport struct {
+	results []selfCheckResult
+}
+
+// check records OK with okDetail when err is nil, FAIL with the error otherwise
+func (r *selfCheckReport) check(name string, err error, okDetail string) {
+	if err != nil {
+		r.results = append(r.results, selfCheckResult{Name: name, Status: selfCheckFail, Detail: err.Error()})
+		return
+	}
+	r.results = append(r.results, selfCheckResult{Name: name, Status: selfCheckOK, Detail: okDetail})
+}
+
+func (r *selfCheckReport) record(name string, status selfCheckStatus, detail string) {
+	r.results = append(r.results, selfCheckResult{Name: name, Status: status, Detail: detail})
+}
+
+func (r *selfCheckReport) failed() bool {
+	for _, res := range r.results {
+		if res.Status == selfCheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *selfCheckReport) print(w io.Writer, service string) {
+	fmt.Fprintf(w, "%s self-check\n", service)
+	for _, res := range r.results {
+		line := fmt.Sprintf("  [%-4s] %s", res.Status, res.Name)
+		if res.Detail != "" {
+			line += ": " + res.Detail
+		}
+		fmt.Fprintln(w, line)
+	}
+	if r.failed() {
+		fmt.Fprintln(w, "Result: FAILED")
+	} else {
+		fmt.Fprintln(w, "Result: OK")
+	}
+}
+
+// checkDurationEnv validates an optional positive duration variable
+func checkDurationEnv(name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%s %q is not a positive duration", name, v)
+	}
+	return nil
+}
+
+// checkPositiveIntEnv validates an optional positive integer variable
+func checkPositiveIntEnv(name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%s %q is not a positive integer", name, v)
+	}
+	return nil
+}
+
+// missingSchema lists the expected tables/columns that don't exist yet in the current schema.
+// With every migration applied nothing should be missing; anything that is was dropped by hand.
+func missingSchema(ctx context.Context, db *sql.DB, expected map[string][]string) ([]string, error) {
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var missing []string
+	for _, table := range tables {
+		rows, err := db.QueryContext(ctx,
+			"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]bool)
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			columns[column] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(columns) == 0 {
+			missing = append(missing, table)
+			continue
+		}
+		for _, column := range expected[table] {
+			if !columns[column] {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	return missing, nil
+}
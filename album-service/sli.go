@@ -0,0 +1,66 @@
+// sli.go - service level indicator metrics that SLO alerts are defined on (see README "Service Level Indicators")
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	// httpRequests counts finished requests; availability is the share of non-5xx responses
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "album_http_requests_total",
+		Help: "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// httpRequestDuration is the latency distribution p99 objectives are computed from
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "album_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// eventConsumeLag measures produce-to-consume latency from the Kafka record timestamp, which the
+	// producer sets when the event is written
+	eventConsumeLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "album_event_consume_lag_seconds",
+		Help:    "Time from producing an event to consuming it, by topic.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2.5, 12), // 10ms to ~4min
+	}, []string{"topic"})
+)
+
+// sliMiddleware records request count and latency per route template. Requests that match no route
+// share the "unmatched" label so scanners can't grow the label set.
+func sliMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeConsumeLag records how long msg took from its producer to this consumer. Clock skew between
+// hosts can make the lag negative; that is reported as zero.
+func observeConsumeLag(msg kafka.Message) {
+	if msg.Time.IsZero() {
+		return
+	}
+	lag := time.Since(msg.Time)
+	if lag < 0 {
+		lag = 0
+	}
+	eventConsumeLag.WithLabelValues(msg.Topic).Observe(lag.Seconds())
+}
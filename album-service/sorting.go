@@ -0,0 +1,19 @@
+// sorting.go - ?sort= ordering for album listings
+
+package main
+
+import "album-store/listing"
+
+// albumListSpec allow-lists the sort keys of album listings (see album-store/listing). Filtering uses the
+// facet filters of facets.go instead.
+var albumListSpec = listing.Spec{
+	Sortable: []listing.Field{
+		{Name: "title", Expr: "lower(a.title)"},
+		{Name: "artist", Expr: "lower(a.artist)"},
+		{Name: "price", Expr: "a.price_cents"},
+		{Name: "releaseYear", Expr: "a.release_year"},
+		{Name: "genre", Expr: "lower(a.genre)"},
+		{Name: "popularity", Expr: "a.popularity_score"},
+	},
+	Unique: "a.id",
+}
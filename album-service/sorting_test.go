@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"album-store/listing"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlbumSort(t *testing.T) {
+	parse := func(query string) ([]listing.SortKey, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/albums?"+query, nil)
+		p, err := listing.ParseParams(c, albumListSpec)
+		return p.Sort, err
+	}
+
+	keys, err := parse("sort=price,-releaseYear")
+	require.NoError(t, err)
+	assert.Equal(t, " ORDER BY a.price_cents ASC, a.release_year DESC, a.id ASC", listing.OrderByClause(keys, "a.id"))
+
+	keys, err = parse("")
+	require.NoError(t, err)
+	assert.Equal(t, " ORDER BY a.id ASC", listing.OrderByClause(keys, "a.id"), "unsorted listings are ordered by ID")
+
+	_, err = parse("sort=price%3BDROP%20TABLE%20albums")
+	assert.ErrorContains(t, err, "cannot sort by")
+	_, err = parse("sort=id")
+	assert.ErrorContains(t, err, "expected one of title, artist, price, releaseYear, genre, popularity")
+	_, err = parse("sort=price,-price")
+	assert.ErrorContains(t, err, "more than once")
+}
+
+func TestGetAllAlbums_Sorted(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	mock.ExpectQuery(`FROM albums a WHERE a.status IN \('published'\) AND a.genre IN \(\$1\) ORDER BY lower\(a.artist\) ASC, a.popularity_score DESC, a.id ASC$`).
+		WithArgs("Jazz").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist", "price", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "attributes"}))
+
+	req, _ := http.NewRequest("GET", "/api/albums?genre=Jazz&sort=artist,-popularity", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	req, _ = http.NewRequest("GET", "/api/albums?sort=stock", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,216 @@
+// storefront.go - public read-only storefront API, declared as a separate surface from the internal API
+
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultStorefrontRateLimit = 120 // Requests per client IP per minute
+
+// routeSpec declares one route of an API surface
+type routeSpec struct {
+	Method  string
+	Path    string
+	Name    string // Span name of the handler
+	Handler gin.HandlerFunc
+}
+
+// apiSurface is a group of routes sharing one exposure policy. Every route of the surface gets the
+// same caching, rate limiting and error handling, so a route can't be exposed with weaker settings
+// by accident.
+type apiSurface struct {
+	Prefix     string
+	Cache      cachePolicy
+	RateLimit  *clientRateLimiter // Per client IP; nil disables rate limiting
+	TrimErrors bool               // Replace 5xx bodies with a generic message
+	Routes     []routeSpec
+}
+
+// newStorefrontSurface declares the public storefront API: catalog reads only, projected to
+// storefrontAlbum, cached aggressively and rate limited per client IP
+func newStorefrontSurface(ratePerMinute int) apiSurface {
+	return apiSurface{
+		Prefix:     "/storefront",
+		Cache:      cacheStorefront,
+		RateLimit:  newClientRateLimiter(ratePerMinute, time.Minute),
+		TrimErrors: true,
+		Routes: []routeSpec{
+			{http.MethodGet, "/albums", "getStorefrontAlbums", getStorefrontAlbums},
+			{http.MethodGet, "/albums/facets", "getStorefrontFacets", getAlbumFacets},
+			{http.MethodGet, "/albums/:id", "getStorefrontAlbum", getStorefrontAlbum},
+			{http.MethodGet, "/albums/:id/related", "getStorefrontRelatedAlbums", getStorefrontRelatedAlbums},
+		},
+	}
+}
+
+// storefrontRateLimitFromEnv reads STOREFRONT_RATE_LIMIT_PER_MINUTE
+func storefrontRateLimitFromEnv() int {
+	v := os.Getenv("STOREFRONT_RATE_LIMIT_PER_MINUTE")
+	if v == "" {
+		return defaultStorefrontRateLimit
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		log.Printf("Invalid STOREFRONT_RATE_LIMIT_PER_MINUTE %q, using default %d", v, defaultStorefrontRateLimit)
+		return defaultStorefrontRateLimit
+	}
+	return limit
+}
+
+// registerSurface adds the surface's routes to the router; wrap decorates each handler (tracing in main)
+func registerSurface(router gin.IRouter, s apiSurface, wrap func(gin.HandlerFunc, string) gin.HandlerFunc) {
+	group := router.Group(s.Prefix)
+	// The cache policy goes first so rate-limit rejections are sent with no-store
+	group.Use(withCachePolicy(s.Cache))
+	if s.RateLimit != nil {
+		group.Use(rateLimitByClient(s.RateLimit))
+	}
+	group.Use(failFastWhileDBDown()) // See circuit_breaker.go
+	if s.TrimErrors {
+		group.Use(trimServerErrors())
+	}
+	// Surfaces only expose albums, so every :id is an album ID
+	group.Use(decodeAlbumIDParam())
+	// and only published ones (see album_status.go)
+	group.Use(func(c *gin.Context) { c.Set(publicSurfaceKey, true) })
+	for _, r := range s.Routes {
+		group.Handle(r.Method, r.Path, wrap(r.Handler, r.Name))
+	}
+}
+
+// rateLimitByClient rejects clients over their budget with 429 and a Retry-After of one window
+func rateLimitByClient(l *clientRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.allowAt(c.ClientIP(), time.Now()) {
+			c.Header("Retry-After", strconv.Itoa(int(l.window.Seconds())))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, slow down"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// trimServerErrors replaces the body of 5xx responses with a generic message, so database and
+// internal errors never reach public clients. The original body is logged.
+func trimServerErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		original := c.Writer
+		buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		if buffered.status >= http.StatusInternalServerError {
+			log.Printf("%s %s failed with %d: %s", c.Request.Method, c.FullPath(), buffered.status, buffered.body.String())
+			c.JSON(buffered.status, gin.H{"error": "Internal server error"})
+			return
+		}
+		original.WriteHeader(buffered.status)
+		original.Write(buffered.body.Bytes())
+	}
+}
+
+// storefrontAlbum is the public view of an album. Fields are copied explicitly so fields added to
+// Album for the admin API never show up in the storefront.
+type storefrontAlbum struct {
+	ID            string                 `json:"id"`
+	Title         string                 `json:"title"`
+	Artist        string                 `json:"artist"`
+	Price         Cents                  `json:"price"`
+	ReleaseYear   int                    `json:"releaseYear"`
+	Genre         string                 `json:"genre"`
+	Format        string                 `json:"format,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"` // See album_attributes.go
+	AverageRating float64                `json:"averageRating,omitempty"`
+	ReviewCount   int                    `json:"reviewCount,omitempty"`
+	Decade        int                    `json:"decade,omitempty"` // Computed fields, on request (see decades.go)
+	Age           *int                   `json:"age,omitempty"`
+	IsNewRelease  bool                   `json:"isNewRelease,omitempty"`
+}
+
+func toStorefrontAlbum(a Album) storefrontAlbum {
+	return storefrontAlbum{
+		ID:            publicIDs.encode(a.ID),
+		Title:         a.Title,
+		Artist:        a.Artist,
+		Price:         a.Price,
+		ReleaseYear:   a.ReleaseYear,
+		Genre:         a.Genre,
+		Format:        a.Format,
+		Attributes:    a.Attributes,
+		AverageRating: a.AverageRating,
+		ReviewCount:   a.ReviewCount,
+		Decade:        a.Decade,
+		Age:           a.Age,
+		IsNewRelease:  a.IsNewRelease,
+	}
+}
+
+// getStorefrontAlbums handles GET /storefront/albums with the same filters, sorting and paging as /api/albums
+func getStorefrontAlbums(c *gin.Context) {
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := listing.ParseParams(c, albumListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fitPageToIDs(c, filter, &page)
+
+	albums, meta, err := queryAlbums(withReplicaReads(c.Request.Context()), filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query albums: " + err.Error()})
+		return
+	}
+	meta.SetHeaders(c)
+	public := make([]storefrontAlbum, 0, len(albums))
+	for _, a := range albums {
+		withComputedFields(c, &a)
+		public = append(public, toStorefrontAlbum(a))
+	}
+	c.JSON(http.StatusOK, public)
+}
+
+// getStorefrontAlbum handles GET /storefront/albums/:id. Malformed IDs are reported as not found.
+// Like the internal API, the album's version is its ETag, unless computed fields are asked for.
+func getStorefrontAlbum(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	computed := wantsComputedFields(c)
+	if !computed && albumNotModified(c, strconv.Itoa(id)) {
+		return
+	}
+
+	a, err := findAlbumCached(withReplicaReads(c.Request.Context()), strconv.Itoa(id))
+	if err == nil && !albumVisible(c, a) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if !computed {
+		c.Header("ETag", versionETag(a.Version))
+	}
+	withComputedFields(c, &a)
+	c.JSON(http.StatusOK, toStorefrontAlbum(a))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorefrontSurface(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	albumColumns := []string{"id", "title", "artist", "price_cents", "release_year", "genre", "format", "upc", "catalog_number", "version", "average_rating", "review_count", "release_date", "label", "tracks", "status", "attributes"}
+
+	t.Run("Detail is projected and cached", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, title, artist, price_cents, release_year, genre").WithArgs("7").
+			WillReturnRows(sqlmock.NewRows(albumColumns).AddRow(7, "Blue Train", "John Coltrane", 2499, 1957, "Jazz", "LP", "", "", 1, "4.50", 2, "", "", nil, "published", nil))
+
+		rr := get("/storefront/albums/7")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, cacheStorefront.CacheControl, rr.Header().Get("Cache-Control"))
+		assert.NotEmpty(t, rr.Header().Get("ETag"))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.Equal(t, "Blue Train", body["title"])
+		assert.Equal(t, 4.5, body["averageRating"])
+		assert.NotContains(t, body, "initialQuantity")
+	})
+
+	t.Run("Malformed IDs are not found", func(t *testing.T) {
+		rr := get("/storefront/albums/abc")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	})
+
+	t.Run("Server errors are trimmed", func(t *testing.T) {
+		mock.ExpectQuery("SELECT a.id, a.title").WillReturnError(sql.ErrConnDone)
+
+		rr := get("/storefront/albums")
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.JSONEq(t, `{"error":"Internal server error"}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Filter errors are kept", func(t *testing.T) {
+		rr := get("/storefront/albums?priceBand=cheap")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "unknown price band")
+	})
+
+	t.Run("Admin routes are not exposed", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/storefront/albums", nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestStorefrontRateLimit(t *testing.T) {
+	r := gin.New()
+	registerSurface(r, newStorefrontSurface(2), func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+
+	codes := make([]int, 0, 3)
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/storefront/albums/abc", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		last = httptest.NewRecorder()
+		r.ServeHTTP(last, req)
+		codes = append(codes, last.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, "60", last.Header().Get("Retry-After"))
+	assert.Equal(t, "no-store", last.Header().Get("Cache-Control"))
+}
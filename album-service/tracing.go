@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"album-store/redaction"
 	"github.com/gin-gonic/gin"
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
@@ -34,9 +35,14 @@ func setupTracing() (func(context.Context) error, error) {
 	ctx := context.Background()
 
 	// Get OTLP endpoint address from environment variable
-	otlpEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
-	if otlpEndpoint == "" {
-		otlpEndpoint = "jaeger:4317" // Default to local Jaeger GRPC endpoint
+	otlpEndpoint := otlpEndpointFromEnv()
+
+	// Optional attribute redaction (PII, SQL text) applied before spans leave the process.
+	// Invalid rules disable tracing rather than risk exporting unredacted data.
+	redactor, err := redaction.NewRedactorFromEnv()
+	if err != nil {
+		log.Printf("Invalid span redaction config: %v", err)
+		return nil, err
 	}
 
 	// Create OTLP exporter
@@ -72,7 +78,7 @@ func setupTracing() (func(context.Context) error, error) {
 	// Create tracer provider
 	tracerProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithBatcher(redaction.NewExporter(traceExporter, redactor)),
 		sdktrace.WithResource(serviceResource),
 	)
 	otel.SetTracerProvider(tracerProvider)
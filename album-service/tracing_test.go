@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingWriter captures published messages instead of sending them to a broker
+type recordingWriter struct {
+	messages []kafka.Message
+	err      error // Returned instead of recording, to simulate a broker outage
+	calls    int
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.calls++
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *recordingWriter) Close() error { return nil }
+
+// setupTestTracing installs an in-memory span recorder as the global tracer provider,
+// mirroring the propagator setup in setupTracing. Globals are restored when the test ends.
+func setupTestTracing(t *testing.T) (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prevProvider, prevPropagator, prevTracer := otel.GetTracerProvider(), otel.GetTextMapPropagator(), tracer
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	tracer = tp.Tracer("album-service")
+
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+		tracer = prevTracer
+	})
+	return recorder, tp
+}
+
+// endedSpansByName indexes the finished spans by name (span names are unique per request here)
+func endedSpansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+// TestCreateAlbum_TracePropagation guards the tracing plumbing of createAlbum: the HTTP span,
+// DB span and Kafka produce span must share one trace, and that trace must travel with the
+// published album-created message so inventory-service can continue it (see the matching
+// consumer-side test in inventory-service/tracing_test.go).
+func TestCreateAlbum_TracePropagation(t *testing.T) {
+	cleanupDB()
+	defer cleanupDB()
+
+	recorder, tp := setupTestTracing(t)
+
+	writer := &recordingWriter{}
+	prevWriter := kafkaWriter
+	kafkaWriter = writer
+	defer func() { kafkaWriter = prevWriter }()
+
+	// Wire the route the same way main does, including the otelgin middleware
+	r := gin.New()
+	r.Use(otelgin.Middleware("album-service", otelgin.WithTracerProvider(tp)))
+	r.POST("/api/albums", requireAdmin(), wrapHandlerWithTracing(createAlbum, "createAlbum"))
+
+	payloadBytes, _ := json.Marshal(Album{
+		Title:       "Traced Album",
+		Artist:      "Traced Artist",
+		Price:       1111,
+		ReleaseYear: 2024,
+		Genre:       "Tracing",
+	})
+	req, _ := http.NewRequest("POST", "/api/albums", bytes.NewBuffer(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Client-Type", "admin")
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, "Expected status code 201 Created")
+
+	spans := endedSpansByName(recorder)
+	httpSpan, ok := spans["/api/albums"]
+	require.True(t, ok, "otelgin HTTP span should be recorded")
+	handlerSpan, ok := spans["createAlbum"]
+	require.True(t, ok, "handler span should be recorded")
+	dbSpan, ok := spans["db.insert_album"]
+	require.True(t, ok, "DB span should be recorded")
+	kafkaSpan, ok := spans["kafka.publish_album_created"]
+	require.True(t, ok, "Kafka produce span should be recorded")
+
+	traceID := httpSpan.SpanContext().TraceID()
+	assert.True(t, traceID.IsValid(), "HTTP span should have a valid trace ID")
+	for _, s := range []sdktrace.ReadOnlySpan{handlerSpan, dbSpan, kafkaSpan} {
+		assert.Equal(t, traceID, s.SpanContext().TraceID(), "Span %q should share the request trace ID", s.Name())
+	}
+
+	// Parent linkage: HTTP -> handler -> {DB, Kafka produce}
+	assert.Equal(t, httpSpan.SpanContext().SpanID(), handlerSpan.Parent().SpanID(), "Handler span should be a child of the HTTP span")
+	assert.Equal(t, handlerSpan.SpanContext().SpanID(), dbSpan.Parent().SpanID(), "DB span should be a child of the handler span")
+	assert.Equal(t, handlerSpan.SpanContext().SpanID(), kafkaSpan.Parent().SpanID(), "Kafka span should be a child of the handler span")
+
+	// The published message must carry the produce span as the remote parent
+	require.Len(t, writer.messages, 1, "Exactly one album-created message should be published")
+	msg := writer.messages[0]
+
+	var traceparent string
+	for _, h := range msg.Headers {
+		if h.Key == "traceparent" {
+			traceparent = string(h.Value)
+		}
+	}
+	assert.NotEmpty(t, traceparent, "Message should carry a W3C traceparent header")
+
+	consumerSC := trace.SpanContextFromContext(ExtractTraceInfoFromKafkaMessage(context.Background(), msg.Headers))
+	assert.True(t, consumerSC.IsRemote(), "Extracted span context should be marked remote")
+	assert.Equal(t, traceID, consumerSC.TraceID(), "Extracted trace ID should match the request trace")
+	assert.Equal(t, kafkaSpan.SpanContext().SpanID(), consumerSC.SpanID(), "Extracted parent should be the Kafka produce span")
+}
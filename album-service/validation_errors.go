@@ -0,0 +1,221 @@
+// validation_errors.go - request body validation rules beyond the validator's built-in tags, and the
+// field-level errors returned when binding fails, so clients can show each error next to its field:
+//
+//	{"error": "Invalid request body: title must be at most 100 characters",
+//	 "errors": [{"field": "title", "rule": "max", "param": "100", "message": "must be at most 100 characters"}]}
+//
+// Errors of an array body carry the index of the element. Field names follow the request's JSON
+// naming (see album-store/jsonnaming).
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"album-store/jsonnaming"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// minReleaseYear is the earliest release year the releaseyear rule accepts. The latest is next year,
+// for announced releases.
+const minReleaseYear = 1900
+
+// FieldError is one failed rule of a request body
+type FieldError struct {
+	Index   *int   `json:"index,omitempty"` // Element of an array body
+	Field   string `json:"field"`           // Nested fields are paths, e.g. tracks[2].title
+	Rule    string `json:"rule"`            // The binding tag, or type or syntax
+	Param   string `json:"param,omitempty"` // The rule's parameter, e.g. the maximum length
+	Message string `json:"message"`
+}
+
+// elementError is the binding error of one element of an array body
+type elementError struct {
+	index int
+	err   error
+}
+
+// batchBindingError holds the binding errors of the invalid elements of an array body. gin's
+// binding.SliceValidationError loses the elements' positions, so batch handlers validate each
+// element again to build one (see albumBatchBindingError).
+type batchBindingError []elementError
+
+func (e batchBindingError) Error() string {
+	messages := make([]string, len(e))
+	for i, elem := range e {
+		messages[i] = fmt.Sprintf("[%d]: %v", elem.index, elem.err)
+	}
+	return strings.Join(messages, "\n")
+}
+
+var registerValidationRulesOnce sync.Once
+
+// registerValidationRules makes validation errors name fields by their JSON names and adds the
+// releaseyear rule. It must run before the first request is bound.
+func registerValidationRules() {
+	registerValidationRulesOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+		v.RegisterValidation("releaseyear", func(fl validator.FieldLevel) bool {
+			year := fl.Field().Int()
+			return year >= minReleaseYear && year <= int64(time.Now().Year()+1)
+		})
+	})
+}
+
+// respondBindingError counts the failures of a binding error and responds 400 with one FieldError
+// per failure, or 413 when the body was over its size limit (see request_body.go)
+func respondBindingError(c *gin.Context, err error) {
+	if limit, ok := bodyLimitExceeded(err); ok {
+		respondBodyTooLarge(c, limit)
+		return
+	}
+	recordValidationFailures(c, err)
+	fieldErrs := bindingFieldErrors(err, jsonnaming.Of(c))
+	summary := make([]string, len(fieldErrs))
+	for i, fe := range fieldErrs {
+		field := fe.Field
+		if fe.Index != nil {
+			field = fmt.Sprintf("[%d].%s", *fe.Index, field)
+		}
+		summary[i] = field + " " + fe.Message
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + strings.Join(summary, "; "), "errors": fieldErrs})
+}
+
+// bindingFieldErrors flattens a binding error into field errors, naming fields in naming
+func bindingFieldErrors(err error, naming string) []FieldError {
+	var batchErrs batchBindingError
+	var sliceErrs binding.SliceValidationError
+	var fieldErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &batchErrs):
+		var out []FieldError
+		for _, elem := range batchErrs {
+			index := elem.index
+			for _, fe := range bindingFieldErrors(elem.err, naming) {
+				fe.Index = &index
+				out = append(out, fe)
+			}
+		}
+		return out
+	case errors.As(err, &sliceErrs):
+		var out []FieldError
+		for _, elemErr := range sliceErrs {
+			out = append(out, bindingFieldErrors(elemErr, naming)...)
+		}
+		return out
+	case errors.As(err, &fieldErrs):
+		out := make([]FieldError, len(fieldErrs))
+		for i, fe := range fieldErrs {
+			// The namespace starts with the struct's type name: Album.tracks[2].title
+			_, path, _ := strings.Cut(fe.Namespace(), ".")
+			out[i] = FieldError{Field: fieldPath(path, naming), Rule: fe.Tag(), Param: fe.Param(), Message: ruleMessage(fe)}
+		}
+		return out
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" && typeErr.Type == reflect.TypeOf(Cents(0)) {
+			field = "price" // See recordValidationFailures
+		}
+		return []FieldError{{Field: fieldPath(field, naming), Rule: validationRuleType, Message: "must be " + jsonTypeName(typeErr.Type)}}
+	}
+	return []FieldError{{Field: "body", Rule: validationRuleSyntax, Message: "must be valid JSON: " + err.Error()}}
+}
+
+// fieldPath renames the identifiers of a field path (tracks[2].durationSeconds) to naming
+func fieldPath(path, naming string) string {
+	if naming != jsonnaming.SnakeCase {
+		return path
+	}
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		name, index, _ := strings.Cut(part, "[")
+		parts[i] = jsonnaming.SnakeCaseKey(name)
+		if index != "" {
+			parts[i] += "[" + index
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// ruleMessage describes a failed rule for clients
+func ruleMessage(fe validator.FieldError) string {
+	param := fe.Param()
+	if fe.Type() == reflect.TypeOf(Cents(0)) {
+		// Amounts are compared in cents but written as decimal numbers
+		if n, err := strconv.ParseInt(param, 10, 64); err == nil {
+			param = Cents(n).String()
+		}
+	}
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + param + unit
+	case "max", "lte":
+		return "must be at most " + param + unit
+	case "len":
+		return "must be exactly " + param + unit
+	case "gt":
+		return "must be greater than " + param + unit
+	case "lt":
+		return "must be less than " + param + unit
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "releaseyear":
+		return fmt.Sprintf("must be between %d and %d", minReleaseYear, time.Now().Year()+1)
+	}
+	return "failed the " + fe.Tag() + " rule"
+}
+
+// jsonTypeName names the JSON type a Go type is decoded from
+func jsonTypeName(t reflect.Type) string {
+	if t == reflect.TypeOf(Cents(0)) {
+		return "a number with at most two decimal places"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindingFieldErrors(t *testing.T) {
+	send := func(method, path, body string) (int, []FieldError, string) {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp struct {
+			Error  string       `json:"error"`
+			Errors []FieldError `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), rr.Body.String())
+		return rr.Code, resp.Errors, resp.Error
+	}
+	nextYear := time.Now().Year() + 1
+	yearRange := fmt.Sprintf("must be between 1900 and %d", nextYear)
+
+	t.Run("One error per field", func(t *testing.T) {
+		code, errs, summary := send("POST", "/api/albums", `{"title":"`+strings.Repeat("x", 101)+`","artist":"Nirvana",
+			"price":20000,"releaseYear":1850,"genre":"Rock","tracks":[{"position":1}]}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.ElementsMatch(t, []FieldError{
+			{Field: "title", Rule: "max", Param: "100", Message: "must be at most 100 characters"},
+			{Field: "price", Rule: "lte", Param: "1000000", Message: "must be at most 10000.00"},
+			{Field: "releaseYear", Rule: "releaseyear", Message: yearRange},
+			{Field: "tracks[0].title", Rule: "required", Message: "is required"},
+		}, errs)
+		assert.Contains(t, summary, "Invalid request body: ")
+		assert.Contains(t, summary, "releaseYear "+yearRange)
+	})
+
+	t.Run("Wrong types", func(t *testing.T) {
+		code, errs, _ := send("POST", "/api/albums", `{"title":"Nevermind","artist":"Nirvana","price":"cheap","releaseYear":1991,"genre":"Rock"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []FieldError{{Field: "price", Rule: validationRuleType, Message: "must be a number with at most two decimal places"}}, errs)
+
+		_, errs, _ = send("PATCH", "/api/albums/1", `{"title":`)
+		require.Len(t, errs, 1)
+		assert.Equal(t, "body", errs[0].Field)
+		assert.Equal(t, validationRuleSyntax, errs[0].Rule)
+	})
+
+	t.Run("Patches", func(t *testing.T) {
+		code, errs, _ := send("PATCH", "/api/albums/1", fmt.Sprintf(`{"releaseYear":%d}`, nextYear+1))
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, []FieldError{{Field: "releaseYear", Rule: "releaseyear", Message: yearRange}}, errs)
+	})
+
+	t.Run("Batch errors name the album", func(t *testing.T) {
+		code, errs, summary := send("POST", "/api/albums/batch", `[
+			{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991,"genre":"Rock"},
+			{"title":"Bleach","artist":"Nirvana","price":14.05,"releaseYear":1989},
+			{"title":"In Utero","artist":"Nirvana","price":19.99,"releaseYear":1993,"genre":"Rock"},
+			{"title":"Incesticide","price":14.05,"releaseYear":1992,"genre":"Rock"}]`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		one, three := 1, 3
+		assert.Equal(t, []FieldError{
+			{Index: &one, Field: "genre", Rule: "required", Message: "is required"},
+			{Index: &three, Field: "artist", Rule: "required", Message: "is required"},
+		}, errs)
+		assert.Equal(t, "Invalid request body: [1].genre is required; [3].artist is required", summary)
+	})
+
+	t.Run("Field names follow the request's naming", func(t *testing.T) {
+		_, errs, _ := send("POST", "/api/albums?naming=snake_case", `{"title":"Nevermind","artist":"Nirvana","price":19.99,"release_year":1850,"genre":"Rock",
+			"tracks":[{"position":1,"title":"Smells Like Teen Spirit","duration_seconds":-1}]}`)
+		assert.ElementsMatch(t, []FieldError{
+			{Field: "release_year", Rule: "releaseyear", Message: yearRange},
+			{Field: "tracks[0].duration_seconds", Rule: "gte", Param: "0", Message: "must be at least 0"},
+		}, errs)
+	})
+}
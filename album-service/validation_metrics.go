@@ -0,0 +1,82 @@
+// validation_metrics.go - counts request body validation failures by field, rule and client type, to
+// show which parts of the API trip clients up and to spot misbehaving integrations
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Rule label values for failures that aren't validation tags
+const (
+	validationRuleType   = "type"   // Value of the wrong JSON type, e.g. a string price
+	validationRuleSyntax = "syntax" // Body isn't valid JSON; field is "body"
+)
+
+var validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "album_validation_failures_total",
+	Help: "Request body validation failures by field, failed rule and client type.",
+}, []string{"field", "rule", "client_type"})
+
+// recordValidationFailures counts every field that failed in a binding error. Labels only take
+// values from the request types and the known client types, so their cardinality stays bounded.
+func recordValidationFailures(c *gin.Context, err error) {
+	clientType := metricClientType(c.GetHeader("Client-Type"))
+
+	var batchErrs batchBindingError
+	var sliceErrs binding.SliceValidationError
+	var fieldErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &batchErrs):
+		for _, elem := range batchErrs {
+			recordValidationFailures(c, elem.err)
+		}
+	case errors.As(err, &sliceErrs):
+		// A batch body: one error per invalid element
+		for _, elemErr := range sliceErrs {
+			recordValidationFailures(c, elemErr)
+		}
+	case errors.As(err, &fieldErrs):
+		for _, fe := range fieldErrs {
+			validationFailures.WithLabelValues(jsonFieldName(fe.Field()), fe.Tag(), clientType).Inc()
+		}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" && typeErr.Type == reflect.TypeOf(Cents(0)) {
+			// Newer encoding/json releases return errors from UnmarshalJSON methods without the
+			// field path. Amounts only appear in request bodies as price.
+			field = "price"
+		}
+		validationFailures.WithLabelValues(field, validationRuleType, clientType).Inc()
+	default:
+		validationFailures.WithLabelValues("body", validationRuleSyntax, clientType).Inc()
+	}
+}
+
+// jsonFieldName turns a struct field name into the JSON name the API uses (ReleaseYear -> releaseYear)
+func jsonFieldName(field string) string {
+	r, size := utf8.DecodeRuneInString(field)
+	return string(unicode.ToLower(r)) + field[size:]
+}
+
+// metricClientType maps the Client-Type header to a bounded label value
+func metricClientType(clientType string) string {
+	switch clientType {
+	case "admin", "user", clientTypeInternal:
+		return clientType
+	case "":
+		return "none"
+	}
+	return "other"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationFailureMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, path, body string
+		field, rule              string
+	}{
+		{"Missing field", "POST", "/api/albums", `{"title":"Nevermind","artist":"Nirvana","price":19.99,"genre":"Rock"}`, "releaseYear", "required"},
+		{"Failed rule", "POST", "/api/albums", `{"title":"Nevermind","artist":"Nirvana","price":-1,"releaseYear":1991,"genre":"Rock"}`, "price", "gt"},
+		{"Wrong type", "PUT", "/api/albums/1", `{"title":"Nevermind","artist":"Nirvana","price":"cheap","releaseYear":1991,"genre":"Rock"}`, "price", validationRuleType},
+		{"Batch element", "POST", "/api/albums/batch", `[{"title":"Nevermind","artist":"Nirvana","price":19.99,"releaseYear":1991}]`, "genre", "required"},
+		{"Malformed JSON", "PATCH", "/api/albums/1", `{"title":`, "body", validationRuleSyntax},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			counter := validationFailures.WithLabelValues(tc.field, tc.rule, "admin")
+			before := testutil.ToFloat64(counter)
+
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Client-Type", "admin")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func TestMetricClientType(t *testing.T) {
+	assert.Equal(t, "admin", metricClientType("admin"))
+	assert.Equal(t, "user", metricClientType("user"))
+	assert.Equal(t, "none", metricClientType(""))
+	assert.Equal(t, "other", metricClientType("partner-42"), "arbitrary header values must not become label values")
+}
@@ -0,0 +1,147 @@
+// views.go - storefront view/impression tracking feeding the popularity score
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultViewRateLimit  = 60 // Views accepted per client per viewRateWindow
+	viewRateWindow        = time.Minute
+	defaultViewFlushEvery = 10 * time.Second
+)
+
+// viewTracker buffers album views in memory and periodically flushes them into album_daily_views,
+// so recording a view never waits on the database. Views are best effort: anything still buffered
+// when the process exits is lost.
+type viewTracker struct {
+	mu      sync.Mutex
+	pending map[int]int64 // album id -> views since the last flush
+	limiter *clientRateLimiter
+	now     func() time.Time
+}
+
+var albumViews = newViewTracker(defaultViewRateLimit, viewRateWindow)
+
+func newViewTracker(limit int, window time.Duration) *viewTracker {
+	return &viewTracker{
+		pending: make(map[int]int64),
+		limiter: newClientRateLimiter(limit, window),
+		now:     time.Now,
+	}
+}
+
+// allow reports whether the client is still within its view budget for the current window
+func (v *viewTracker) allow(client string) bool {
+	return v.limiter.allowAt(client, v.now())
+}
+
+// record buffers a single view of an album
+func (v *viewTracker) record(albumID int) {
+	v.mu.Lock()
+	v.pending[albumID]++
+	v.mu.Unlock()
+}
+
+// flush writes buffered views to album_daily_views for today. On failure the views are put back
+// so the next flush retries them.
+func (v *viewTracker) flush(ctx context.Context, db *sql.DB) error {
+	v.mu.Lock()
+	batch := v.pending
+	v.pending = make(map[int]int64)
+	v.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := upsertDailyViews(ctx, db, batch)
+	if err != nil {
+		v.mu.Lock()
+		for albumID, n := range batch {
+			v.pending[albumID] += n
+		}
+		v.mu.Unlock()
+	}
+	return err
+}
+
+// upsertDailyViews adds the batch to today's counters. Views for albums that no longer exist are dropped.
+func upsertDailyViews(ctx context.Context, db *sql.DB, batch map[int]int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Rollback if commit isn't reached
+
+	for albumID, n := range batch {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO album_daily_views (album_id, day, views)
+			SELECT id, CURRENT_DATE, $2::bigint FROM albums WHERE id = $1
+			ON CONFLICT (album_id, day) DO UPDATE SET views = album_daily_views.views + EXCLUDED.views`,
+			albumID, n)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// startViewTracking applies the view env overrides and flushes buffered views every VIEW_FLUSH_INTERVAL
+func startViewTracking() {
+	interval := defaultViewFlushEvery
+	if v := os.Getenv("VIEW_FLUSH_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid VIEW_FLUSH_INTERVAL %q, using default %s", v, defaultViewFlushEvery)
+		} else {
+			interval = parsed
+		}
+	}
+	if v := os.Getenv("VIEW_RATE_LIMIT_PER_MINUTE"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			log.Printf("Invalid VIEW_RATE_LIMIT_PER_MINUTE %q, using default %d", v, defaultViewRateLimit)
+		} else {
+			albumViews.limiter.limit = limit
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := albumViews.flush(context.Background(), db); err != nil {
+				log.Printf("Failed to flush album views: %v", err)
+			}
+		}
+	}()
+}
+
+// recordAlbumView handles POST /api/albums/:id/view. The view is buffered and the call returns
+// 202 immediately; album existence is only checked when the buffer is flushed.
+func recordAlbumView(c *gin.Context) {
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || albumID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return
+	}
+
+	if !albumViews.allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many view events, slow down"})
+		return
+	}
+
+	albumViews.record(albumID)
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewTracker_RateLimitPerClient(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := newViewTracker(2, time.Minute)
+	v.now = func() time.Time { return now }
+
+	assert.True(t, v.allow("10.0.0.1"))
+	assert.True(t, v.allow("10.0.0.1"))
+	assert.False(t, v.allow("10.0.0.1"), "third view inside the window should be rejected")
+	assert.True(t, v.allow("10.0.0.2"), "other clients have their own budget")
+
+	now = now.Add(time.Minute)
+	assert.True(t, v.allow("10.0.0.1"), "budget resets with the next window")
+}
+
+func TestViewTracker_Flush(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	v := newViewTracker(defaultViewRateLimit, viewRateWindow)
+	v.record(7)
+	v.record(7)
+
+	t.Run("Failed flush keeps views for the next attempt", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO album_daily_views").WithArgs(7, int64(2)).WillReturnError(fmt.Errorf("connection reset"))
+		mock.ExpectRollback()
+
+		assert.Error(t, v.flush(context.Background(), mockDB))
+		assert.Equal(t, int64(2), v.pending[7])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Successful flush drains the buffer", func(t *testing.T) {
+		v.record(7)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO album_daily_views").WithArgs(7, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, v.flush(context.Background(), mockDB))
+		assert.Empty(t, v.pending)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Empty buffer skips the database", func(t *testing.T) {
+		assert.NoError(t, v.flush(context.Background(), mockDB))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRecordAlbumView(t *testing.T) {
+	original := albumViews
+	albumViews = newViewTracker(1, time.Minute)
+	t.Cleanup(func() { albumViews = original })
+
+	post := func(path string) int {
+		req, _ := http.NewRequest(http.MethodPost, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("/api/albums/abc/view"))
+	assert.Equal(t, http.StatusAccepted, post("/api/albums/5/view"))
+	assert.Equal(t, http.StatusTooManyRequests, post("/api/albums/5/view"))
+	assert.Equal(t, int64(1), albumViews.pending[5])
+}
@@ -0,0 +1,477 @@
+// webhooks.go - webhook subscriptions to catalog changes, for partners who can't consume Kafka.
+// Admins register callback URLs; every album create, update and delete is POSTed to them, signed
+// with the webhook's secret. Deliveries are queued by a trigger on the audit log (see
+// album_audit.go), in the transaction of the change, so no write path can skip them, and a
+// background job sends them with exponential backoff until they succeed or run out of attempts.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultWebhookDeliveryInterval = 5 * time.Second
+	webhookTimeout                 = 10 * time.Second
+	webhookRetryBase               = 30 * time.Second
+	webhookRetryMax                = 6 * time.Hour
+	maxWebhookAttempts             = 10
+	webhookBatchSize               = 50
+	maxWebhookErrorLength          = 500
+)
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed" // Gave up after maxWebhookAttempts
+)
+
+// Request headers of a delivery. The signature is the hex HMAC-SHA256, keyed with the webhook's
+// secret, of the timestamp, a ".", and the body.
+const (
+	webhookDeliveryHeader  = "X-Album-Store-Delivery"
+	webhookEventHeader     = "X-Album-Store-Event"
+	webhookTimestampHeader = "X-Album-Store-Timestamp"
+	webhookSignatureHeader = "X-Album-Store-Signature"
+)
+
+// webhookActions are the changes a webhook can subscribe to, the actions of the audit log
+var webhookActions = []string{"create", "update", "delete"}
+
+// webhookDeliveryListSpec pages a webhook's deliveries newest first (see album-store/listing)
+var webhookDeliveryListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "createdAt", Expr: "created_at"}},
+	Filterable:  []listing.Field{{Name: "status", Expr: "status"}, {Name: "action", Expr: "action"}},
+	DefaultSort: []listing.SortKey{{Expr: "created_at", Desc: true}},
+	Unique:      "id",
+}
+
+// webhookClient sends deliveries; tests replace it
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Webhook is a registered callback URL
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url" binding:"required,max=2000"`
+	Actions   []string  `json:"actions"`          // Defaults to every action
+	Secret    string    `json:"secret,omitempty"` // Only returned when the webhook is created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is one change queued for, or sent to, a webhook
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	Action         string     `json:"action"`
+	AlbumID        string     `json:"albumId" id:"public"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"` // While pending
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// WebhookEvent is the body POSTed to a webhook. ID identifies the change, the same for every
+// webhook and every retry, so receivers can drop duplicates.
+type WebhookEvent struct {
+	ID        int64                  `json:"id"`
+	Action    string                 `json:"action"`
+	AlbumID   string                 `json:"albumId"`
+	Changes   map[string]AuditChange `json:"changes"`
+	ChangedAt time.Time              `json:"changedAt"`
+}
+
+// createWebhook handles POST /api/webhooks (admin). The response is the only one carrying the
+// secret.
+func createWebhook(c *gin.Context) {
+	var w Webhook
+	if err := c.ShouldBindJSON(&w); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if err := validateWebhook(&w); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret: " + err.Error()})
+		return
+	}
+	w.Secret = hex.EncodeToString(secret)
+
+	err := db.QueryRowContext(c.Request.Context(),
+		"INSERT INTO webhooks (url, secret, actions, created_by) VALUES ($1, $2, string_to_array($3, ','), $4) RETURNING id, created_at",
+		w.URL, w.Secret, strings.Join(w.Actions, ","), c.ClientIP()).Scan(&w.ID, &w.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+	log.Printf("Webhook %d registered for %v by %s", w.ID, w.Actions, c.ClientIP())
+	c.JSON(http.StatusCreated, w)
+}
+
+// validateWebhook checks the URL and defaults the actions to all of them
+func validateWebhook(w *Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if len(w.Actions) == 0 {
+		w.Actions = webhookActions
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, action := range w.Actions {
+		if !containsString(webhookActions, action) {
+			return fmt.Errorf("unknown action %q, expected create, update or delete", action)
+		}
+		if seen[action] {
+			return fmt.Errorf("action %q is listed twice", action)
+		}
+		seen[action] = true
+	}
+	return nil
+}
+
+// getWebhooks handles GET /api/webhooks (admin), oldest first, without the secrets
+func getWebhooks(c *gin.Context) {
+	rows, err := db.QueryContext(c.Request.Context(), "SELECT id, url, array_to_string(actions, ','), created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query webhooks: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var actions string
+		if err := rows.Scan(&w.ID, &w.URL, &actions, &w.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read webhooks: " + err.Error()})
+			return
+		}
+		w.Actions = strings.Split(actions, ",")
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read webhooks: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// deleteWebhook handles DELETE /api/webhooks/:webhookId (admin). Its pending deliveries are dropped.
+func deleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	result, err := db.ExecContext(c.Request.Context(), "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook: " + err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	log.Printf("Webhook %d deleted by %s", id, c.ClientIP())
+	c.Status(http.StatusNoContent)
+}
+
+// getWebhookDeliveries handles GET /api/webhooks/:webhookId/deliveries (admin), newest first
+// unless sorted otherwise, filtered with ?status= and ?action=
+func getWebhookDeliveries(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	page, err := listing.ParseParams(c, webhookDeliveryListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := []interface{}{id}
+	where := listing.WhereClause(append([]string{"webhook_id = $1"}, page.Conditions(&args)...))
+	countArgs := args
+	query := "SELECT id, action, album_id, status, attempts, COALESCE(last_status_code, 0), COALESCE(last_error, ''), next_attempt_at, created_at, delivered_at FROM webhook_deliveries" +
+		where + page.OrderByClause() + page.PageClause(&args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query deliveries: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var albumID int
+		var next time.Time
+		if err := rows.Scan(&d.ID, &d.Action, &albumID, &d.Status, &d.Attempts, &d.LastStatusCode, &d.LastError, &next, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read deliveries: " + err.Error()})
+			return
+		}
+		d.AlbumID = strconv.Itoa(albumID)
+		if d.Status == deliveryPending {
+			d.NextAttemptAt = &next
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read deliveries: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(deliveries), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM webhook_deliveries"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count deliveries: " + err.Error()})
+		return
+	}
+	if meta.Total == 0 {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)", id).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+	}
+	meta.SetHeaders(c)
+	respondJSON(c, http.StatusOK, deliveries)
+}
+
+// startWebhookDelivery sends due deliveries immediately and then on every WEBHOOK_DELIVERY_INTERVAL
+// tick (default 5s)
+func startWebhookDelivery() {
+	interval := defaultWebhookDeliveryInterval
+	if v := os.Getenv("WEBHOOK_DELIVERY_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid WEBHOOK_DELIVERY_INTERVAL %q, using default %s", v, defaultWebhookDeliveryInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	log.Printf("Webhook deliveries sent every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runWebhookDelivery()
+			<-ticker.C
+		}
+	}()
+}
+
+// runWebhookDelivery runs one traced pass of deliverWebhooks
+func runWebhookDelivery() {
+	ctx, span := tracer.Start(context.Background(), "job.webhooks")
+	defer span.End()
+
+	sent, err := deliverWebhooks(ctx, time.Now())
+	if err != nil {
+		log.Printf("Webhook delivery failed after %d attempts: %v", sent, err)
+		span.RecordError(err)
+		return
+	}
+	if sent > 0 {
+		log.Printf("Webhook delivery made %d attempts", sent)
+	}
+}
+
+// dueDelivery is a claimed delivery with what is needed to send it
+type dueDelivery struct {
+	id       int64
+	attempts int
+	url      string
+	secret   string
+	event    WebhookEvent
+}
+
+// deliverWebhooks sends due deliveries in batches until none is left, and returns the number of
+// attempts made. Claiming a delivery counts the attempt and pushes its next attempt past the
+// timeout, so with several instances each is sent by one, and one lost with its instance is
+// retried.
+func deliverWebhooks(ctx context.Context, now time.Time) (int, error) {
+	attempts := 0
+	for {
+		batch, err := claimDueDeliveries(ctx, now)
+		if err != nil {
+			return attempts, err
+		}
+		for _, d := range batch {
+			status, sendErr := sendWebhook(ctx, d, now)
+			if err := recordDeliveryAttempt(ctx, d, status, sendErr, now); err != nil {
+				return attempts, err
+			}
+			attempts++
+		}
+		if len(batch) < webhookBatchSize {
+			return attempts, nil
+		}
+	}
+}
+
+// claimDueDeliveries claims up to webhookBatchSize pending deliveries due by now
+func claimDueDeliveries(ctx context.Context, now time.Time) ([]dueDelivery, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = $2
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= $1
+				ORDER BY next_attempt_at, id
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED)
+			RETURNING id, webhook_id, audit_id, attempts)
+		SELECT c.id, c.attempts, w.url, w.secret, a.id, a.action, a.album_id, a.changes, a.changed_at
+		FROM claimed c
+		JOIN webhooks w ON w.id = c.webhook_id
+		JOIN album_audit a ON a.id = c.audit_id
+		ORDER BY c.id`, now, now.Add(2*webhookTimeout), webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []dueDelivery
+	for rows.Next() {
+		var d dueDelivery
+		var albumID int
+		var changes []byte
+		if err := rows.Scan(&d.id, &d.attempts, &d.url, &d.secret, &d.event.ID, &d.event.Action, &albumID, &changes, &d.event.ChangedAt); err != nil {
+			return nil, err
+		}
+		var columns map[string]AuditChange
+		if err := json.Unmarshal(changes, &columns); err != nil {
+			return nil, fmt.Errorf("audit entry %d has invalid changes: %w", d.event.ID, err)
+		}
+		d.event.Changes = make(map[string]AuditChange, len(columns))
+		for column, change := range columns {
+			var field string
+			field, change.Old = auditField(column, change.Old)
+			_, change.New = auditField(column, change.New)
+			d.event.Changes[field] = change
+		}
+		// Partners see the album IDs the API shows them (see public_ids.go)
+		d.event.AlbumID = publicIDs.encode(strconv.Itoa(albumID))
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+// sendWebhook POSTs the signed event and returns the response status. Any status but 2xx is an
+// error.
+func sendWebhook(ctx context.Context, d dueDelivery, now time.Time) (int, error) {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "album-service-webhooks")
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(d.id, 10))
+	req.Header.Set(webhookEventHeader, d.event.Action)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(d.secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookSignature signs a delivery body sent at timestamp
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordDeliveryAttempt marks a delivery delivered, or schedules its retry, or gives up on it
+func recordDeliveryAttempt(ctx context.Context, d dueDelivery, status int, sendErr error, now time.Time) error {
+	var code interface{}
+	if status > 0 {
+		code = status
+	}
+	if sendErr == nil {
+		webhookDeliveries.WithLabelValues(deliveryDelivered).Inc()
+		_, err := db.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET status = 'delivered', delivered_at = $2, last_status_code = $3, last_error = NULL WHERE id = $1",
+			d.id, now, code)
+		return err
+	}
+
+	message := sendErr.Error()
+	if len(message) > maxWebhookErrorLength {
+		message = message[:maxWebhookErrorLength]
+	}
+	result := deliveryPending
+	if d.attempts >= maxWebhookAttempts {
+		result = deliveryFailed
+		log.Printf("Webhook delivery %d failed after %d attempts: %v", d.id, d.attempts, sendErr)
+	}
+	webhookDeliveries.WithLabelValues(result).Inc()
+	_, err := db.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET status = $2, next_attempt_at = $3, last_status_code = $4, last_error = $5 WHERE id = $1",
+		d.id, result, now.Add(webhookBackoff(d.attempts)), code, message)
+	return err
+}
+
+// webhookBackoff is the wait after a delivery's attempts-th failed attempt: webhookRetryBase,
+// doubled on every attempt, up to webhookRetryMax
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookRetryBase
+	for i := 1; i < attempts && wait < webhookRetryMax; i++ {
+		wait *= 2
+	}
+	if wait > webhookRetryMax {
+		wait = webhookRetryMax
+	}
+	return wait
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	w := Webhook{URL: "https://partner.example.com/hooks/albums"}
+	require.NoError(t, validateWebhook(&w))
+	assert.Equal(t, webhookActions, w.Actions, "every action by default")
+
+	w = Webhook{URL: "https://partner.example.com/hooks", Actions: []string{"delete"}}
+	require.NoError(t, validateWebhook(&w))
+	assert.Equal(t, []string{"delete"}, w.Actions)
+
+	for _, invalid := range []Webhook{
+		{URL: "partner.example.com/hooks"},
+		{URL: "ftp://partner.example.com/hooks"},
+		{URL: "https://partner.example.com/hooks", Actions: []string{"publish"}},
+		{URL: "https://partner.example.com/hooks", Actions: []string{"create", "create"}},
+	} {
+		assert.Error(t, validateWebhook(&invalid), "%+v", invalid)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookBackoff(1))
+	assert.Equal(t, time.Minute, webhookBackoff(2))
+	assert.Equal(t, 4*time.Minute, webhookBackoff(4))
+	assert.Equal(t, webhookRetryMax, webhookBackoff(maxWebhookAttempts+5))
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Create returns the secret", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO webhooks").
+			WithArgs("https://partner.example.com/hooks", sqlmock.AnyArg(), "create,delete", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, created))
+
+		rr := send("POST", "/api/webhooks", `{"url":"https://partner.example.com/hooks","actions":["create","delete"]}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var w Webhook
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &w))
+		assert.Equal(t, 2, w.ID)
+		assert.Len(t, w.Secret, 64)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/webhooks", `{"url":"not a url"}`).Code)
+	})
+
+	t.Run("List leaves the secrets out", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, url, array_to_string\\(actions, ','\\), created_at FROM webhooks").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "actions", "created_at"}).AddRow(2, "https://partner.example.com/hooks", "create,delete", created))
+
+		rr := send("GET", "/api/webhooks", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `[{"id":2,"url":"https://partner.example.com/hooks","actions":["create","delete"],"createdAt":"2024-05-01T12:00:00Z"}]`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Deliveries", func(t *testing.T) {
+		next := created.Add(time.Minute)
+		mock.ExpectQuery("FROM webhook_deliveries WHERE webhook_id = \\$1 AND status IN \\(\\$2\\)").WithArgs(2, deliveryPending, 50, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "action", "album_id", "status", "attempts", "last_status_code", "last_error", "next_attempt_at", "created_at", "delivered_at"}).
+				AddRow(9, "update", 4, deliveryPending, 2, 503, "webhook answered 503 Service Unavailable", next, created, nil))
+
+		rr := send("GET", "/api/webhooks/2/deliveries?status=pending", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `[{"id":9,"action":"update","albumId":"4","status":"pending","attempts":2,"lastStatusCode":503,
+			"lastError":"webhook answered 503 Service Unavailable","nextAttemptAt":"2024-05-01T12:01:00Z","createdAt":"2024-05-01T12:00:00Z"}]`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM webhooks WHERE id = \\$1").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.Equal(t, http.StatusNoContent, send("DELETE", "/api/webhooks/2", "").Code)
+		mock.ExpectExec("DELETE FROM webhooks WHERE id = \\$1").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.Equal(t, http.StatusNotFound, send("DELETE", "/api/webhooks/3", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	status := http.StatusOK
+	var received []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received, bodies = append(received, r), append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	expectClaim := func(attempts int) {
+		mock.ExpectQuery("WITH claimed AS \\(\\s*UPDATE webhook_deliveries").WithArgs(now, now.Add(2*webhookTimeout), webhookBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "attempts", "url", "secret", "audit_id", "action", "album_id", "changes", "changed_at"}).
+				AddRow(9, attempts, server.URL, "s3cret", 41, "update", 4, []byte(`{"price_cents":{"old":1999,"new":1499}}`), now.Add(-time.Minute)))
+	}
+
+	t.Run("Signed and delivered", func(t *testing.T) {
+		expectClaim(1)
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = 'delivered'").WithArgs(9, now, http.StatusOK).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		sent, err := deliverWebhooks(context.Background(), now)
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		require.Len(t, received, 1)
+		r, body := received[0], bodies[0]
+		assert.JSONEq(t, `{"id":41,"action":"update","albumId":"4","changes":{"price":{"old":19.99,"new":14.99}},"changedAt":"2024-05-01T11:59:00Z"}`, string(body))
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		assert.Equal(t, timestamp, r.Header.Get(webhookTimestampHeader))
+		assert.Equal(t, "sha256="+webhookSignature("s3cret", timestamp, body), r.Header.Get(webhookSignatureHeader))
+		assert.Equal(t, "9", r.Header.Get(webhookDeliveryHeader))
+		assert.Equal(t, "update", r.Header.Get(webhookEventHeader))
+	})
+
+	t.Run("Failures are retried with backoff", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		expectClaim(3)
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2").
+			WithArgs(9, deliveryPending, now.Add(webhookBackoff(3)), http.StatusServiceUnavailable, "webhook answered 503 Service Unavailable").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := deliverWebhooks(context.Background(), now)
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Gives up after the last attempt", func(t *testing.T) {
+		expectClaim(maxWebhookAttempts)
+		mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2").
+			WithArgs(9, deliveryFailed, sqlmock.AnyArg(), http.StatusServiceUnavailable, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := deliverWebhooks(context.Background(), now)
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
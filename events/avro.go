@@ -0,0 +1,128 @@
+// avro.go - conversion of events to and from generic Avro records, for Avro libraries that encode
+// maps. A record is keyed by the JSON names of the event's fields, holds timestamps as time.Time and
+// unset optional fields as nil. Only scalar and timestamp fields are supported.
+
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var timestampName = (&timestamppb.Timestamp{}).ProtoReflect().Descriptor().FullName()
+
+// ToAvroRecord returns the fields of an event as a generic Avro record
+func ToAvroRecord(m proto.Message) (map[string]interface{}, error) {
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	record := make(map[string]interface{}, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasPresence() && !msg.Has(fd) {
+			record[fd.JSONName()] = nil
+			continue
+		}
+		value, err := avroValue(fd, msg.Get(fd))
+		if err != nil {
+			return nil, err
+		}
+		record[fd.JSONName()] = value
+	}
+	return record, nil
+}
+
+// FromAvroRecord sets the fields of m from a generic Avro record. Fields missing from the record
+// or nil are left unset.
+func FromAvroRecord(record map[string]interface{}, m proto.Message) error {
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		v, ok := record[fd.JSONName()]
+		if !ok || v == nil {
+			continue
+		}
+		value, err := protoValue(fd, v)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, value)
+	}
+	return nil
+}
+
+func avroValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, error) {
+	if fd.IsList() || fd.IsMap() {
+		return nil, fmt.Errorf("%s: repeated and map fields aren't supported in Avro records", fd.Name())
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return v.String(), nil
+	case protoreflect.BoolKind:
+		return v.Bool(), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return int32(v.Int()), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int(), nil
+	case protoreflect.DoubleKind:
+		return v.Float(), nil
+	case protoreflect.MessageKind:
+		if fd.Message().FullName() == timestampName {
+			return v.Message().Interface().(*timestamppb.Timestamp).AsTime(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %s fields aren't supported in Avro records", fd.Name(), fd.Kind())
+}
+
+func protoValue(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	if fd.IsList() || fd.IsMap() {
+		return protoreflect.Value{}, fmt.Errorf("%s: repeated and map fields aren't supported in Avro records", fd.Name())
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BoolKind:
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if n, ok := toInt64(v); ok {
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n, ok := toInt64(v); ok {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := v.(float64); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.MessageKind:
+		if fd.Message().FullName() != timestampName {
+			return protoreflect.Value{}, fmt.Errorf("%s: %s fields aren't supported in Avro records", fd.Name(), fd.Message().FullName())
+		}
+		if t, ok := v.(time.Time); ok {
+			return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s: unexpected %T for a %s field", fd.Name(), v, fd.Kind())
+}
+
+// toInt64 converts the integer types Avro libraries decode int and long into
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAvroRecord(t *testing.T) {
+	timestamp := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	quantity := int32(5)
+	event := &AlbumCreated{AlbumId: "42", Title: "Blue Train", Timestamp: timestamppb.New(timestamp), InitialQuantity: &quantity}
+
+	record, err := ToAvroRecord(event)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"albumId": "42", "title": "Blue Train", "artist": "", "timestamp": timestamp, "initialQuantity": int32(5),
+	}, record)
+
+	var decoded AlbumCreated
+	require.NoError(t, FromAvroRecord(record, &decoded))
+	assert.True(t, proto.Equal(event, &decoded))
+
+	t.Run("Unset optional fields are nil", func(t *testing.T) {
+		record, err := ToAvroRecord(&AlbumCreated{AlbumId: "43"})
+		require.NoError(t, err)
+		assert.Nil(t, record["initialQuantity"])
+
+		var decoded AlbumCreated
+		require.NoError(t, FromAvroRecord(map[string]interface{}{"albumId": "43", "initialQuantity": nil}, &decoded))
+		assert.Nil(t, decoded.InitialQuantity)
+	})
+
+	t.Run("Unsupported fields and types", func(t *testing.T) {
+		_, err := ToAvroRecord(&OrderSucceeded{OrderId: "1"})
+		assert.ErrorContains(t, err, "metadata")
+		assert.ErrorContains(t, FromAvroRecord(map[string]interface{}{"albumId": 42}, &AlbumCreated{}), "album_id")
+	})
+}
@@ -0,0 +1,63 @@
+// envelope.go - the envelope events are published in from schema v2 on. It carries what consumers
+// need before decoding the payload: the event type to dispatch on, an ID to deduplicate by, the
+// producing service and the time the event occurred, with the v1 payload under data.
+
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types, one per topic. A v1 event carries no type: its topic implies it.
+const (
+	TypeAlbumCreated         = "album.created"
+	TypeAlbumDeleted         = "album.deleted"
+	TypePriceProposalUpdated = "price_proposal.updated"
+	TypePriceChanged         = "price.changed"
+	TypeDailySalesSummarized = "daily_sales.summarized"
+	TypeOrderCreated         = "order.created"
+	TypeOrderSucceeded       = "order.succeeded"
+	TypeOrderFailed          = "order.failed"
+	TypeOrderGifted          = "order.gifted"
+	TypeInventoryLowStock    = "inventory.low_stock"
+)
+
+// eventIDNamespace scopes the name-based UUIDs of StableEventID
+var eventIDNamespace = uuid.MustParse("5b0f3c1e-8d1a-4f6e-9a57-2f4c1d7e8b90")
+
+// Envelope is the schema v2 wire format of an event
+type Envelope struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	EventType     string          `json:"eventType"`
+	EventID       string          `json:"eventId"`  // UUID, the same for every version of one event
+	Producer      string          `json:"producer"` // Service that published the event
+	OccurredAt    time.Time       `json:"occurredAt"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// NewEventID returns a random event ID
+func NewEventID() string {
+	return uuid.NewString()
+}
+
+// StableEventID returns the ID of the event identified by key, e.g. "order.failed:42". An event
+// published again, after a restart or in another schema version, keeps its ID, so consumers that
+// deduplicate by ID handle it once.
+func StableEventID(key string) string {
+	return uuid.NewSHA1(eventIDNamespace, []byte(key)).String()
+}
+
+// Wrap returns an event's JSON payload in an envelope of the given schema version
+func Wrap(version int, eventType, eventID, producer string, data []byte) ([]byte, error) {
+	return json.Marshal(Envelope{
+		SchemaVersion: version,
+		EventType:     eventType,
+		EventID:       eventID,
+		Producer:      producer,
+		OccurredAt:    time.Now().UTC(),
+		Data:          data,
+	})
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventIDs(t *testing.T) {
+	id := StableEventID("order.failed:42")
+	_, err := uuid.Parse(id)
+	require.NoError(t, err)
+	assert.Equal(t, id, StableEventID("order.failed:42"), "the same event keeps its ID")
+	assert.NotEqual(t, id, StableEventID("order.failed:43"))
+
+	_, err = uuid.Parse(NewEventID())
+	require.NoError(t, err)
+	assert.NotEqual(t, NewEventID(), NewEventID())
+}
+
+func TestWrap(t *testing.T) {
+	value, err := Wrap(2, TypeOrderFailed, "3f1c", "inventory-service", []byte(`{"orderId":"42"}`))
+	require.NoError(t, err)
+
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(value, &envelope))
+	assert.Equal(t, 2, envelope.SchemaVersion)
+	assert.Equal(t, TypeOrderFailed, envelope.EventType)
+	assert.Equal(t, "3f1c", envelope.EventID)
+	assert.Equal(t, "inventory-service", envelope.Producer)
+	assert.WithinDuration(t, time.Now(), envelope.OccurredAt, time.Minute)
+	assert.JSONEq(t, `{"orderId":"42"}`, string(envelope.Data))
+}
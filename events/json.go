@@ -0,0 +1,34 @@
+// json.go - JSON encoding of events. Events are written with the camelCase JSON names of
+// events.proto. Reading also accepts the snake_case field names and skips fields it doesn't know,
+// so a consumer keeps working when a producer adds a field.
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// MarshalJSON encodes an event as compact JSON
+func MarshalJSON(m proto.Message) ([]byte, error) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	// protojson varies its whitespace between builds; compact output is the same everywhere
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+// UnmarshalJSON decodes a JSON event into m
+func UnmarshalJSON(data []byte, m proto.Message) error {
+	return unmarshalOptions.Unmarshal(data, m)
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	price, err := structpb.NewStruct(map[string]interface{}{"totalPrice": 19.99, "currency": "USD"})
+	require.NoError(t, err)
+	event := &OrderSucceeded{
+		OrderId:   "101",
+		AlbumId:   "42",
+		Quantity:  2,
+		Timestamp: timestamppb.New(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)),
+		Metadata:  map[string]string{"giftNote": "Happy birthday"},
+		Price:     price,
+	}
+
+	data, err := MarshalJSON(event)
+	require.NoError(t, err)
+	assert.Equal(t, `{"orderId":"101","albumId":"42","quantity":2,"timestamp":"2026-03-01T12:00:00Z",`+
+		`"metadata":{"giftNote":"Happy birthday"},"price":{"currency":"USD","totalPrice":19.99}}`, string(data))
+
+	var decoded OrderSucceeded
+	require.NoError(t, UnmarshalJSON(data, &decoded))
+	assert.True(t, proto.Equal(event, &decoded))
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	t.Run("Either naming, unknown fields skipped", func(t *testing.T) {
+		var event AlbumCreated
+		require.NoError(t, UnmarshalJSON([]byte(`{"album_id":"42","title":"Blue Train","initialQuantity":0,"label":"Blue Note"}`), &event))
+		assert.Equal(t, "42", event.GetAlbumId())
+		assert.Equal(t, "Blue Train", event.GetTitle())
+		require.NotNil(t, event.InitialQuantity, "an explicit 0 is kept apart from no initial quantity")
+		assert.Zero(t, event.GetInitialQuantity())
+	})
+
+	t.Run("Order-service payload", func(t *testing.T) {
+		var event OrderCreated
+		require.NoError(t, UnmarshalJSON([]byte(`{"orderId":"7","userId":"u1","albumId":"42","quantity":1,`+
+			`"price":{"unitPrice":10.00,"totalPrice":10.00,"currency":"EUR"},"timestamp":"2026-03-01T12:00:00.123Z"}`), &event))
+		assert.Equal(t, int32(1), event.GetQuantity())
+		assert.Equal(t, "EUR", event.GetPrice().GetFields()["currency"].GetStringValue())
+		assert.Equal(t, "2026-03-01T12:00:00.123Z", event.GetTimestamp())
+	})
+
+	t.Run("Wrong types", func(t *testing.T) {
+		var event OrderCreated
+		assert.Error(t, UnmarshalJSON([]byte(`{"orderId":"7","quantity":"many"}`), &event))
+	})
+}
@@ -0,0 +1,101 @@
+// album_cleanup.go - archives an album's inventory when album-service deletes the album
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"album-store/events"
+	"album-store/topics"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// startAlbumDeletedConsumer runs the consumer for album deletion events.
+func startAlbumDeletedConsumer(kafkaBroker string) {
+	topic := topics.Name(versionedTopic(albumDeletedTopic, eventConsumeVersion))
+	consumer := newEventConsumer(kafkaBroker, albumDeletedTopic, topic, topics.GroupName(albumCleanupGroupID), byEventType(albumDeletedTopic, map[string]func(kafka.Message) error{
+		events.TypeAlbumDeleted: func(msg kafka.Message) error { return processAlbumDeletedEvent(db, msg) },
+	}))
+	consumer.run(kafkaBroker, nil)
+}
+
+// processAlbumDeletedEvent moves the album's inventory record into inventory_archive. Stock is only
+// deducted when an order is processed, so nothing is held for orders still in flight; those fail with
+// ALBUM_REMOVED once processOrderCreated finds the archived record. Redelivery is a no-op.
+func processAlbumDeletedEvent(db *sql.DB, msg kafka.Message) error {
+	ctx := ExtractTraceInfoFromKafkaMessage(context.Background(), msg.Headers)
+	ctx, span := tracer.Start(ctx, "processAlbumDeletedEvent")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("kafka.partition", msg.Partition),
+		attribute.Int64("kafka.offset", msg.Offset),
+		attribute.String("kafka.topic", msg.Topic),
+	)
+
+	var event events.AlbumDeleted
+	err := decodeAlbumEvent(ctx, msg, albumDeletedTopic, &event)
+	if err == nil && event.AlbumId == "" {
+		err = fmt.Errorf("%w: missing albumId", errMalformedEvent)
+	}
+	if err != nil {
+		log.Printf("Error parsing AlbumDeletedEvent: %v. Message: %q", err, msg.Value)
+		span.SetStatus(codes.Error, "Failed to parse album deleted event")
+		return err
+	}
+	span.SetAttributes(attribute.String("album.id", event.AlbumId))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Database transaction error")
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Albums deleted before their inventory was initialized still get a tombstone
+	quantity := 0
+	var threshold sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM inventory WHERE album_id = $1 RETURNING quantity_available, low_stock_threshold",
+		event.AlbumId).Scan(&quantity, &threshold)
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to remove inventory record")
+		return fmt.Errorf("failed to remove inventory for album %s: %w", event.AlbumId, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO inventory_archive (album_id, quantity_available, low_stock_threshold, archived_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (album_id) DO NOTHING`,
+		event.AlbumId, quantity, threshold)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to archive inventory record")
+		return fmt.Errorf("failed to archive inventory for album %s: %w", event.AlbumId, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Transaction commit failed")
+		return fmt.Errorf("transaction commit error: %w", err)
+	}
+	invalidateInventory(ctx, event.AlbumId)
+
+	log.Printf("Archived inventory for deleted AlbumID %s (quantity %d)", event.AlbumId, quantity)
+	span.SetStatus(codes.Ok, "Inventory archived")
+	return nil
+}
+
+// albumRemoved reports whether the album's inventory has been archived because the album was deleted
+func albumRemoved(ctx context.Context, db *sql.DB, albumID string) (bool, error) {
+	var removed bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM inventory_archive WHERE album_id = $1)", albumID).Scan(&removed)
+	return removed, err
+}
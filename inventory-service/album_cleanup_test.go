@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"album-store/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessAlbumDeletedEvent(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	msg := kafka.Message{Value: []byte(`{"albumId":"42","timestamp":"2024-01-01T00:00:00Z"}`)}
+
+	t.Run("Moves the inventory record into the archive", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM inventory WHERE album_id").WithArgs("42").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available", "low_stock_threshold"}).AddRow(7, 2))
+		mock.ExpectExec("INSERT INTO inventory_archive").
+			WithArgs("42", 7, sql.NullInt64{Int64: 2, Valid: true}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, processAlbumDeletedEvent(mockDB, msg))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Album without inventory still gets a tombstone", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM inventory WHERE album_id").WithArgs("42").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available", "low_stock_threshold"}))
+		mock.ExpectExec("INSERT INTO inventory_archive").
+			WithArgs("42", 0, sql.NullInt64{}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, processAlbumDeletedEvent(mockDB, msg))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database errors are retried", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM inventory WHERE album_id").WithArgs("42").WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		assert.Error(t, processAlbumDeletedEvent(mockDB, msg))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Malformed events are reported as such", func(t *testing.T) {
+		assert.ErrorIs(t, processAlbumDeletedEvent(mockDB, kafka.Message{Value: []byte(`{"title":"x"}`)}), errMalformedEvent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestProcessOrderCreated_AlbumRemoved(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	for _, tc := range []struct {
+		name    string
+		removed bool
+		reason  string
+	}{
+		{"Archived album", true, failureReasonAlbumRemoved},
+		{"Never initialized album", false, failureReasonInsufficientInventory},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failed, succeeded := useRecordingWriters(t)
+			msg := orderMessage(t, &events.OrderCreated{OrderId: "201", AlbumId: "42", Quantity: 1})
+			outcome := ordersProcessed.WithLabelValues(orderOutcomeFailed, tc.reason)
+			outcomeBefore := testutil.ToFloat64(outcome)
+
+			expectNoSaga(mock, "201")
+			mock.ExpectBegin()
+			expectNoVelocityLimit(mock, "42")
+			mock.ExpectQuery("UPDATE inventory").WithArgs(1, "42").WillReturnRows(sqlmock.NewRows(deductionColumns))
+			mock.ExpectRollback()
+			mock.ExpectQuery("SELECT quantity_available FROM inventory").WithArgs("42").WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM inventory_archive").WithArgs("42").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.removed))
+			expectSagaStep(mock, "201", sagaStepFailed)
+			expectSagaStep(mock, "201", sagaStepFailedPublished)
+
+			assert.NoError(t, processOrderCreated(mockDB, msg))
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.Len(t, succeeded.messages, 0)
+			if assert.Len(t, failed.messages, 1) {
+				assert.Equal(t, tc.reason, failedReason(t, failed.messages[0]))
+			}
+			assert.Equal(t, outcomeBefore+1, testutil.ToFloat64(outcome))
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// availability.go - batch availability check for cart and checkout pre-validation
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAvailabilityCheckItems = 100
+
+// Per-item availability statuses
+const (
+	availabilityEnough       = "enough"
+	availabilityInsufficient = "insufficient"
+	availabilityUnknown      = "unknown" // No inventory record for the album
+)
+
+// AvailabilityCheckItem is one cart line to check
+type AvailabilityCheckItem struct {
+	AlbumID  string `json:"albumId" binding:"required"`
+	Quantity int    `json:"quantity" binding:"required,gt=0"`
+}
+
+// AvailabilityCheckRequest represents a request to POST /api/inventory/check
+type AvailabilityCheckRequest struct {
+	Items []AvailabilityCheckItem `json:"items" binding:"required,min=1,dive"`
+}
+
+// AvailabilityCheckResult is the availability of one requested item
+type AvailabilityCheckResult struct {
+	AlbumID           string `json:"albumId"`
+	Quantity          int    `json:"quantity"`
+	QuantityAvailable int    `json:"quantityAvailable"`
+	Status            string `json:"status"`
+}
+
+// AvailabilityCheckResponse lists results in request order
+type AvailabilityCheckResponse struct {
+	AllAvailable bool                      `json:"allAvailable"`
+	Items        []AvailabilityCheckResult `json:"items"`
+}
+
+// checkAvailability handles POST /api/inventory/check. It's advisory only: stock is reserved when the
+// order is processed, so a positive answer doesn't guarantee the order will succeed.
+func checkAvailability(c *gin.Context) {
+	var req AvailabilityCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	if len(req.Items) > maxAvailabilityCheckItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many items, at most %d per check", maxAvailabilityCheckItems)})
+		return
+	}
+
+	// Lines for the same album draw on the same stock, so compare their combined quantity
+	requested := make(map[string]int)
+	var albumIDs []interface{}
+	for _, item := range req.Items {
+		if _, seen := requested[item.AlbumID]; !seen {
+			albumIDs = append(albumIDs, item.AlbumID)
+		}
+		requested[item.AlbumID] += item.Quantity
+	}
+
+	placeholders := make([]string, len(albumIDs))
+	for i := range albumIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := db.QueryContext(c.Request.Context(),
+		"SELECT album_id, quantity_available FROM inventory WHERE album_id IN ("+strings.Join(placeholders, ", ")+")",
+		albumIDs...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query inventory: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	available := make(map[string]int)
+	for rows.Next() {
+		var albumID string
+		var quantity int
+		if err := rows.Scan(&albumID, &quantity); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan inventory row: " + err.Error()})
+			return
+		}
+		available[albumID] = quantity
+	}
+	if err = rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating inventory rows: " + err.Error()})
+		return
+	}
+
+	resp := AvailabilityCheckResponse{AllAvailable: true, Items: make([]AvailabilityCheckResult, 0, len(req.Items))}
+	for _, item := range req.Items {
+		result := AvailabilityCheckResult{AlbumID: item.AlbumID, Quantity: item.Quantity}
+		quantity, ok := available[item.AlbumID]
+		switch {
+		case !ok:
+			result.Status = availabilityUnknown
+		case quantity >= requested[item.AlbumID]:
+			result.Status = availabilityEnough
+		default:
+			result.Status = availabilityInsufficient
+		}
+		result.QuantityAvailable = quantity
+		if result.Status != availabilityEnough {
+			resp.AllAvailable = false
+		}
+		resp.Items = append(resp.Items, result)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
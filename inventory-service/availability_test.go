@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAvailability(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/inventory/check", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Per-item statuses", func(t *testing.T) {
+		mock.ExpectQuery("SELECT album_id, quantity_available FROM inventory WHERE album_id IN").
+			WithArgs("a1", "a2", "a3").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available"}).
+				AddRow("a1", 5).
+				AddRow("a2", 3))
+
+		// a2 is requested on two lines whose combined quantity exceeds stock
+		rr := post(`{"items":[{"albumId":"a1","quantity":2},{"albumId":"a2","quantity":2},{"albumId":"a3","quantity":1},{"albumId":"a2","quantity":2}]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp AvailabilityCheckResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.False(t, resp.AllAvailable)
+		require.Len(t, resp.Items, 4)
+		assert.Equal(t, AvailabilityCheckResult{AlbumID: "a1", Quantity: 2, QuantityAvailable: 5, Status: availabilityEnough}, resp.Items[0])
+		assert.Equal(t, availabilityInsufficient, resp.Items[1].Status)
+		assert.Equal(t, AvailabilityCheckResult{AlbumID: "a3", Quantity: 1, Status: availabilityUnknown}, resp.Items[2])
+		assert.Equal(t, availabilityInsufficient, resp.Items[3].Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("All available", func(t *testing.T) {
+		mock.ExpectQuery("SELECT album_id, quantity_available FROM inventory WHERE album_id IN").
+			WithArgs("a1").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available"}).AddRow("a1", 1))
+
+		rr := post(`{"items":[{"albumId":"a1","quantity":1}]}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"allAvailable":true`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		tooMany := `{"items":[` + strings.TrimSuffix(strings.Repeat(`{"albumId":"a1","quantity":1},`, maxAvailabilityCheckItems+1), ",") + `]}`
+		for _, body := range []string{
+			`{"items":[]}`,
+			`{"items":[{"albumId":"a1","quantity":0}]}`,
+			`{"items":[{"quantity":1}]}`,
+			tooMany,
+		} {
+			assert.Equal(t, http.StatusBadRequest, post(body).Code)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
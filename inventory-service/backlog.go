@@ -0,0 +1,127 @@
+// backlog.go - how far the album-created consumer is behind its topic, with a warning while it lags.
+// Albums have no stock until their album-created event is consumed, so a backlog is otherwise silent.
+
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultBacklogWarnMessages = 500
+	defaultBacklogWarnAge      = 2 * time.Minute
+	backlogWarnRepeat          = time.Minute // Repeat the warning at most this often while over the threshold
+)
+
+var (
+	consumerBacklogMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "inventory_consumer_backlog_messages",
+		Help: "Messages not yet consumed, summed over partitions, as of the last consumed message.",
+	}, []string{"topic"})
+
+	consumerBacklogSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "inventory_consumer_backlog_seconds",
+		Help: "Age of the last consumed message when it was consumed.",
+	}, []string{"topic"})
+
+	consumerBacklogWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_consumer_backlog_warnings_total",
+		Help: "Backlog warnings logged because a consumer was over its message or age threshold.",
+	}, []string{"topic"})
+)
+
+// consumerBacklog tracks the backlog of one consumer from the messages it reads. Each message carries
+// its partition's high watermark, so the messages behind are known per partition without extra
+// broker requests.
+type consumerBacklog struct {
+	topic       string
+	maxMessages int64
+	maxAge      time.Duration
+
+	mu         sync.Mutex
+	partitions map[int]int64 // partition -> messages behind
+	over       bool
+	lastWarned time.Time
+	now        func() time.Time
+}
+
+func newConsumerBacklog(topic string, maxMessages int64, maxAge time.Duration) *consumerBacklog {
+	return &consumerBacklog{
+		topic:       topic,
+		maxMessages: maxMessages,
+		maxAge:      maxAge,
+		partitions:  make(map[int]int64),
+		now:         time.Now,
+	}
+}
+
+// newAlbumCreatedBacklogFromEnv reads ALBUM_CREATED_BACKLOG_WARN_MESSAGES and ALBUM_CREATED_BACKLOG_WARN_AGE
+func newAlbumCreatedBacklogFromEnv(topic string) *consumerBacklog {
+	maxMessages := int64(defaultBacklogWarnMessages)
+	if v := os.Getenv("ALBUM_CREATED_BACKLOG_WARN_MESSAGES"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid ALBUM_CREATED_BACKLOG_WARN_MESSAGES %q, using default %d", v, defaultBacklogWarnMessages)
+		} else {
+			maxMessages = parsed
+		}
+	}
+	maxAge := defaultBacklogWarnAge
+	if v := os.Getenv("ALBUM_CREATED_BACKLOG_WARN_AGE"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid ALBUM_CREATED_BACKLOG_WARN_AGE %q, using default %s", v, defaultBacklogWarnAge)
+		} else {
+			maxAge = parsed
+		}
+	}
+	return newConsumerBacklog(topic, maxMessages, maxAge)
+}
+
+// observe updates the backlog from a consumed message and logs a warning while a threshold is exceeded
+func (b *consumerBacklog) observe(msg kafka.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	behind := msg.HighWaterMark - msg.Offset - 1
+	if behind < 0 {
+		behind = 0
+	}
+	b.partitions[msg.Partition] = behind
+	var total int64
+	for _, n := range b.partitions {
+		total += n
+	}
+
+	now := b.now()
+	var age time.Duration
+	if !msg.Time.IsZero() && now.After(msg.Time) {
+		age = now.Sub(msg.Time)
+	}
+	consumerBacklogMessages.WithLabelValues(b.topic).Set(float64(total))
+	consumerBacklogSeconds.WithLabelValues(b.topic).Set(age.Seconds())
+
+	if total <= b.maxMessages && age <= b.maxAge {
+		if b.over {
+			log.Printf("Consumer for %s caught up: %d messages, %s behind", b.topic, total, age.Round(time.Second))
+			b.over = false
+		}
+		return
+	}
+	if b.over && now.Sub(b.lastWarned) < backlogWarnRepeat {
+		return
+	}
+	b.over = true
+	b.lastWarned = now
+	consumerBacklogWarnings.WithLabelValues(b.topic).Inc()
+	log.Printf("WARNING: consumer for %s is %d messages, %s behind (thresholds %d messages, %s); new albums have no stock until it catches up",
+		b.topic, total, age.Round(time.Second), b.maxMessages, b.maxAge)
+}
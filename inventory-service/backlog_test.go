@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerBacklog(t *testing.T) {
+	const topic = "backlog-test"
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b := newConsumerBacklog(topic, 100, time.Minute)
+	b.now = func() time.Time { return now }
+
+	messages := consumerBacklogMessages.WithLabelValues(topic)
+	seconds := consumerBacklogSeconds.WithLabelValues(topic)
+	warnings := consumerBacklogWarnings.WithLabelValues(topic)
+
+	t.Run("Backlog is summed over partitions", func(t *testing.T) {
+		b.observe(kafka.Message{Partition: 0, Offset: 9, HighWaterMark: 50, Time: now.Add(-5 * time.Second)})
+		b.observe(kafka.Message{Partition: 1, Offset: 19, HighWaterMark: 50, Time: now.Add(-10 * time.Second)})
+
+		assert.Equal(t, float64(40+30), testutil.ToFloat64(messages))
+		assert.Equal(t, float64(10), testutil.ToFloat64(seconds))
+		assert.Equal(t, float64(0), testutil.ToFloat64(warnings))
+	})
+
+	t.Run("Warns once per repeat interval while over a threshold", func(t *testing.T) {
+		old := kafka.Message{Partition: 0, Offset: 10, HighWaterMark: 50, Time: now.Add(-2 * time.Minute)}
+		b.observe(old)
+		assert.Equal(t, float64(1), testutil.ToFloat64(warnings), "message age is over the threshold")
+
+		now = now.Add(10 * time.Second)
+		b.observe(old)
+		assert.Equal(t, float64(1), testutil.ToFloat64(warnings))
+
+		now = now.Add(backlogWarnRepeat)
+		b.observe(kafka.Message{Partition: 2, Offset: 0, HighWaterMark: 200, Time: now})
+		assert.Equal(t, float64(2), testutil.ToFloat64(warnings), "message count is over the threshold")
+	})
+
+	t.Run("Catching up clears the warning state", func(t *testing.T) {
+		b.observe(kafka.Message{Partition: 2, Offset: 199, HighWaterMark: 200, Time: now})
+		assert.Equal(t, float64(39+30), testutil.ToFloat64(messages))
+		assert.False(t, b.over)
+
+		b.observe(kafka.Message{Partition: 2, Offset: 0, HighWaterMark: 200, Time: now})
+		assert.Equal(t, float64(3), testutil.ToFloat64(warnings), "a new backlog warns immediately")
+	})
+}
@@ -0,0 +1,176 @@
+// circuit_breaker.go - circuit breakers around the database and the Kafka writers. After consecutive
+// failed calls a breaker opens and refuses calls for a cooldown, so while Postgres or the broker is
+// down requests and order events fail fast, requests with 503 and a Retry-After, instead of each one
+// waiting out the full timeout.
+//
+// The database breaker sits in the connector of the primary pool (db_pool.go): every query and
+// transaction takes its connection from there, so a refused call never reaches Postgres. API routes
+// also check it before their handler runs (failFastWhileDBDown). Each managed Kafka writer has its own
+// breaker on top of its health checks (album-store/kafkawriter), which only notice an outage every few
+// seconds.
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dbBreakerThreshold    = 5                // Consecutive failed connection attempts that open the circuit
+	dbBreakerCooldown     = 10 * time.Second // How long an open circuit refuses connections before a trial
+	kafkaBreakerThreshold = 5                // Consecutive failed writes to a topic that open its circuit
+	kafkaBreakerCooldown  = 10 * time.Second
+)
+
+// dbBreaker guards the connections of the primary database
+var dbBreaker = newCircuitBreaker("database", dbBreakerThreshold, dbBreakerCooldown)
+
+// circuitOpenError is returned for a call an open circuit refused
+type circuitOpenError struct {
+	dependency string
+	retryAfter time.Duration // Until the circuit lets a trial call through
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s circuit open, retry in %s", e.dependency, e.retryAfter.Round(time.Second))
+}
+
+// circuitBreaker opens after threshold consecutive failures. While open, calls are refused until the
+// cooldown has passed; then one trial call is let through, and its outcome closes the circuit or opens
+// it for another cooldown.
+type circuitBreaker struct {
+	name      string // The dependency label of the inventory_circuit_breaker_* metrics
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	failures  int
+	openUntil time.Time
+	trial     bool // A trial call is in flight
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	circuitBreakerOpen.WithLabelValues(name).Set(0)
+	return &circuitBreaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may be made now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		circuitBreakerRejections.WithLabelValues(b.name).Inc()
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record records the outcome of an allowed call. A call its caller canceled says nothing about the
+// dependency and isn't counted.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Printf("Circuit for %s closed", b.name)
+			circuitBreakerOpen.WithLabelValues(b.name).Set(0)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("Circuit for %s opened after %d consecutive failures: %v", b.name, b.failures, err)
+		}
+		b.openUntil = b.now().Add(b.cooldown)
+		circuitBreakerOpen.WithLabelValues(b.name).Set(1)
+	}
+}
+
+// retryAfter is how long calls are still refused for, 0 while the circuit lets them through. During a
+// trial call it is a second, the time the trial is given to close the circuit.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return 0
+	}
+	if wait := b.openUntil.Sub(b.now()); wait > 0 {
+		return wait
+	}
+	if b.trial {
+		return time.Second
+	}
+	return 0
+}
+
+// openError is the error of a call allow refused
+func (b *circuitBreaker) openError() error {
+	return &circuitOpenError{dependency: b.name, retryAfter: max(b.retryAfter(), time.Second)}
+}
+
+// kafkaWriterBreaker is the circuit breaker of a managed Kafka writer (kafkawriter.Breaker)
+type kafkaWriterBreaker struct{ *circuitBreaker }
+
+func newKafkaWriterBreaker(topic string) kafkaWriterBreaker {
+	return kafkaWriterBreaker{newCircuitBreaker("kafka:"+topic, kafkaBreakerThreshold, kafkaBreakerCooldown)}
+}
+
+func (b kafkaWriterBreaker) Allow() bool      { return b.allow() }
+func (b kafkaWriterBreaker) Record(err error) { b.record(err) }
+func (b kafkaWriterBreaker) OpenError() error { return b.openError() }
+
+// breakerConnector hands out database connections through a circuit breaker
+type breakerConnector struct {
+	driver.Connector
+	breaker *circuitBreaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if !c.breaker.allow() {
+		return nil, c.breaker.openError()
+	}
+	conn, err := c.Connector.Connect(ctx)
+	c.breaker.record(err)
+	return conn, err
+}
+
+// failFastWhileDBDown answers 503 while the database circuit is open, rather than letting the handler
+// fail on a connection it won't get
+func failFastWhileDBDown() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if wait := dbBreaker.retryAfter(); wait > 0 {
+			circuitBreakerRejections.WithLabelValues(dbBreaker.name).Inc()
+			setRetryAfter(c, wait)
+			c.Header("Cache-Control", "no-store")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable, retry later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setRetryAfter sets the Retry-After header to wait, in whole seconds rounded up
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("test-dependency", 2, 10*time.Second)
+	b.now = func() time.Time { return now }
+	refused := testutil.ToFloat64(circuitBreakerRejections.WithLabelValues("test-dependency"))
+
+	// A canceled call says nothing about the dependency
+	require.True(t, b.allow())
+	b.record(context.Canceled)
+	for i := 0; i < 2; i++ {
+		require.True(t, b.allow())
+		b.record(context.DeadlineExceeded)
+	}
+	assert.False(t, b.allow())
+	assert.Equal(t, 10*time.Second, b.retryAfter())
+	assert.Equal(t, float64(1), testutil.ToFloat64(circuitBreakerOpen.WithLabelValues("test-dependency")))
+	assert.Equal(t, refused+1, testutil.ToFloat64(circuitBreakerRejections.WithLabelValues("test-dependency")))
+
+	var open *circuitOpenError
+	require.ErrorAs(t, b.openError(), &open)
+	assert.Equal(t, "test-dependency circuit open, retry in 10s", open.Error())
+
+	// After the cooldown a single trial goes through, and its success closes the circuit
+	now = now.Add(10 * time.Second)
+	assert.Zero(t, b.retryAfter())
+	require.True(t, b.allow())
+	assert.False(t, b.allow(), "only one trial at a time")
+	assert.Equal(t, time.Second, b.retryAfter())
+	b.record(nil)
+	assert.True(t, b.allow())
+	assert.Zero(t, b.retryAfter())
+	assert.Zero(t, testutil.ToFloat64(circuitBreakerOpen.WithLabelValues("test-dependency")))
+}
+
+// fakeConnector fails every connection with err
+type fakeConnector struct {
+	err   error
+	calls int
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	c.calls++
+	return nil, c.err
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+func TestBreakerConnector(t *testing.T) {
+	inner := &fakeConnector{err: errors.New("connection refused")}
+	connector := breakerConnector{Connector: inner, breaker: newCircuitBreaker("test-database", 3, time.Minute)}
+
+	for i := 0; i < 3; i++ {
+		_, err := connector.Connect(context.Background())
+		assert.ErrorIs(t, err, inner.err)
+	}
+	_, err := connector.Connect(context.Background())
+	var open *circuitOpenError
+	assert.ErrorAs(t, err, &open)
+	assert.Equal(t, 3, inner.calls, "an open circuit doesn't reach the database")
+}
+
+func TestFailFastWhileDBDown(t *testing.T) {
+	prev := dbBreaker
+	t.Cleanup(func() { dbBreaker = prev })
+	now := time.Now()
+	dbBreaker = newCircuitBreaker("test-database", 1, 5*time.Second)
+	dbBreaker.now = func() time.Time { return now }
+	dbBreaker.allow()
+	dbBreaker.record(errors.New("connection refused"))
+	now = now.Add(1500 * time.Millisecond)
+
+	req, _ := http.NewRequest("GET", "/api/inventory/1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "4", rr.Header().Get("Retry-After"))
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "probes don't need the database")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressResponses(t *testing.T) {
+	large := strings.Repeat(`{"albumId":"kind-of-blue","quantityAvailable":12},`, 100)
+	r := gin.New()
+	r.Use(compressResponses())
+	r.GET("/large", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", []byte(large)) })
+	r.GET("/small", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"albumId": "kind-of-blue"}) })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(large)) })
+	r.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "br")
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Large JSON is compressed", func(t *testing.T) {
+		rr := get("/large", "br, gzip;q=0.8")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Contains(t, rr.Header().Values("Vary"), "Accept-Encoding")
+		assert.Less(t, rr.Body.Len(), len(large))
+		gz, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("Uncompressed for clients without gzip", func(t *testing.T) {
+		rr := get("/large", "")
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Contains(t, rr.Header().Values("Vary"), "Accept-Encoding")
+		assert.Equal(t, large, rr.Body.String())
+	})
+
+	t.Run("Left alone", func(t *testing.T) {
+		for _, tc := range []struct{ path, acceptEncoding string }{
+			{"/small", "gzip"},
+			{"/image", "gzip"},
+			{"/encoded", "gzip"},
+			{"/large", "gzip;q=0"},
+			{"/large", "deflate"},
+		} {
+			rr := get(tc.path, tc.acceptEncoding)
+			assert.NotEqual(t, "gzip", rr.Header().Get("Content-Encoding"), tc)
+		}
+	})
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	assert.True(t, acceptsGzip("gzip"))
+	assert.True(t, acceptsGzip("gzip, deflate, br"))
+	assert.True(t, acceptsGzip("br;q=1.0, GZIP;q=0.5"))
+	assert.False(t, acceptsGzip(""))
+	assert.False(t, acceptsGzip("identity"))
+	assert.False(t, acceptsGzip("gzip;q=0"))
+}
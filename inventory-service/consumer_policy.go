@@ -0,0 +1,315 @@
+// consumer_policy.go - what a consumer does with an event it fails to process. Each consumed topic has
+// an error policy, set with CONSUMER_ERROR_POLICIES (e.g. "order-created=dlq:5,album-deleted=skip"):
+//
+//	retry   retry with backoff until the event is processed; the partition waits meanwhile
+//	dlq:N   retry up to N attempts, then publish the event to <topic>.dlq and move on
+//	skip    log the error and move on
+//
+// Events that can't be parsed (errMalformedEvent) are moved to the dead-letter topic or skipped without
+// retrying, as retrying can't fix them; under retry they block the partition like any other error.
+// The policies and what each consumer has done with failed events are served on /internal/consumers.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"album-store/kafkawriter"
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+// Error policy actions
+const (
+	errorPolicyRetry = "retry"
+	errorPolicyDLQ   = "dlq"
+	errorPolicySkip  = "skip"
+)
+
+const (
+	defaultConsumerErrorPolicy = "dlq:5"
+	deadLetterTopicSuffix      = ".dlq"
+	consumerRetryBaseBackoff   = 500 * time.Millisecond
+	consumerRetryMaxBackoff    = 30 * time.Second
+)
+
+// errMalformedEvent wraps parse and validation errors of consumed events
+var errMalformedEvent = errors.New("malformed event")
+
+// consumedTopics are the base topics the service consumes, the keys of CONSUMER_ERROR_POLICIES
+var consumedTopics = []string{orderCreatedTopic, albumCreatedTopic, albumDeletedTopic}
+
+// consumerErrorPolicies holds the policy of each consumed base topic, from loadConsumerErrorPolicies
+var consumerErrorPolicies = map[string]consumerErrorPolicy{}
+
+// consumerErrorPolicy is the error policy of one consumed topic
+type consumerErrorPolicy struct {
+	Action      string
+	MaxAttempts int // dlq only: attempts before the event is dead-lettered
+}
+
+func (p consumerErrorPolicy) String() string {
+	if p.Action == errorPolicyDLQ {
+		return fmt.Sprintf("%s:%d", p.Action, p.MaxAttempts)
+	}
+	return p.Action
+}
+
+// parseConsumerErrorPolicy parses "retry", "dlq:N" or "skip"
+func parseConsumerErrorPolicy(s string) (consumerErrorPolicy, error) {
+	action, attempts, hasAttempts := strings.Cut(strings.TrimSpace(s), ":")
+	switch action {
+	case errorPolicyRetry, errorPolicySkip:
+		if hasAttempts {
+			return consumerErrorPolicy{}, fmt.Errorf("policy %q takes no attempt count", action)
+		}
+		return consumerErrorPolicy{Action: action}, nil
+	case errorPolicyDLQ:
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 1 {
+			return consumerErrorPolicy{}, fmt.Errorf("policy %q needs a positive attempt count, e.g. dlq:5", s)
+		}
+		return consumerErrorPolicy{Action: action, MaxAttempts: n}, nil
+	}
+	return consumerErrorPolicy{}, fmt.Errorf("unknown policy %q, expected retry, dlq:N or skip", s)
+}
+
+// loadConsumerErrorPolicies reads CONSUMER_ERROR_POLICIES. Topics it doesn't name get the default policy.
+func loadConsumerErrorPolicies() error {
+	def, _ := parseConsumerErrorPolicy(defaultConsumerErrorPolicy)
+	policies := make(map[string]consumerErrorPolicy, len(consumedTopics))
+	for _, topic := range consumedTopics {
+		policies[topic] = def
+	}
+	if v := strings.TrimSpace(os.Getenv("CONSUMER_ERROR_POLICIES")); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			topic, policy, ok := strings.Cut(entry, "=")
+			topic = strings.TrimSpace(topic)
+			if !ok {
+				return fmt.Errorf("CONSUMER_ERROR_POLICIES: %q is not topic=policy", entry)
+			}
+			if _, known := policies[topic]; !known {
+				return fmt.Errorf("CONSUMER_ERROR_POLICIES: unknown topic %q, expected any of %s", topic, strings.Join(consumedTopics, ", "))
+			}
+			p, err := parseConsumerErrorPolicy(policy)
+			if err != nil {
+				return fmt.Errorf("CONSUMER_ERROR_POLICIES: %s: %w", topic, err)
+			}
+			policies[topic] = p
+		}
+	}
+	consumerErrorPolicies = policies
+	return nil
+}
+
+// consumerRetryBackoff is the wait before retry attempt+1, doubling up to consumerRetryMaxBackoff
+func consumerRetryBackoff(attempt int) time.Duration {
+	backoff := consumerRetryBaseBackoff
+	for i := 1; i < attempt && backoff < consumerRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > consumerRetryMaxBackoff {
+		backoff = consumerRetryMaxBackoff
+	}
+	return backoff
+}
+
+// consumerStats counts what a consumer has done with the events it read
+type consumerStats struct {
+	Processed    int64      `json:"processed"`
+	Retries      int64      `json:"retries"`
+	DeadLettered int64      `json:"deadLettered"`
+	Skipped      int64      `json:"skipped"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// consumerStatus is a consumer as listed on /internal/consumers
+type consumerStatus struct {
+	Topic           string `json:"topic"`
+	Group           string `json:"group"`
+	Policy          string `json:"policy"`
+	DeadLetterTopic string `json:"deadLetterTopic,omitempty"`
+	consumerStats
+}
+
+// eventConsumer reads one topic and applies its error policy to the events handle fails on
+type eventConsumer struct {
+	topic  string
+	group  string
+	policy consumerErrorPolicy
+	handle func(msg kafka.Message) error
+
+	deadLetterTopic string
+	deadLetters     messageWriter // Only set for the dlq policy
+	sleep           func(time.Duration)
+
+	mu    sync.Mutex
+	stats consumerStats
+}
+
+var (
+	eventConsumersMu sync.Mutex
+	eventConsumers   []*eventConsumer
+)
+
+// newEventConsumer creates the consumer of base topic (e.g. "order-created") under its
+// environment-scoped name topic, with the base topic's error policy, and lists it on /internal/consumers.
+// The dlq policy starts a writer for the dead-letter topic.
+func newEventConsumer(kafkaBroker, base, topic, group string, handle func(msg kafka.Message) error) *eventConsumer {
+	c := &eventConsumer{
+		topic:  topic,
+		group:  group,
+		policy: consumerErrorPolicies[base],
+		handle: handle,
+		sleep:  time.Sleep,
+	}
+	if c.policy.Action == "" {
+		c.policy, _ = parseConsumerErrorPolicy(defaultConsumerErrorPolicy)
+	}
+	if c.policy.Action == errorPolicyDLQ {
+		c.deadLetterTopic = topic + deadLetterTopicSuffix
+		if kafkaBroker != "" {
+			c.deadLetters = startEventWriter(kafkaBroker, c.deadLetterTopic)
+		}
+	}
+	eventConsumersMu.Lock()
+	eventConsumers = append(eventConsumers, c)
+	eventConsumersMu.Unlock()
+	log.Printf("Consumer for '%s' handles failed events with policy %s", topic, c.policy)
+	return c
+}
+
+// run reads and processes messages until the process exits. The offset of each message is committed
+// once its error policy is done with it, so a restart resumes at the event the consumer was on.
+func (c *eventConsumer) run(kafkaBroker string, observe func(kafka.Message)) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    c.topic,
+		GroupID:  c.group,
+		MinBytes: 10e3,
+		MaxBytes: 10e6,
+	})
+	defer reader.Close()
+	log.Printf("Kafka consumer started for topic '%s', group '%s', broker '%s'", c.topic, c.group, kafkaBroker)
+
+	for {
+		msg, err := reader.FetchMessage(context.Background())
+		if err != nil {
+			log.Printf("Error reading message (%s): %v", c.topic, err)
+			continue
+		}
+		observeConsumeLag(msg)
+		if observe != nil {
+			observe(msg)
+		}
+
+		c.process(msg)
+		if err := reader.CommitMessages(context.Background(), msg); err != nil {
+			log.Printf("Failed to commit message offset %d (%s): %v", msg.Offset, c.topic, err)
+		}
+	}
+}
+
+// process handles msg, applying the error policy until the consumer can move past it
+func (c *eventConsumer) process(msg kafka.Message) {
+	for attempt := 1; ; attempt++ {
+		err := c.handle(msg)
+		if err == nil {
+			c.record(func(s *consumerStats) { s.Processed++ })
+			return
+		}
+		c.record(func(s *consumerStats) {
+			now := time.Now()
+			s.LastError, s.LastErrorAt = err.Error(), &now
+		})
+		malformed := errors.Is(err, errMalformedEvent)
+
+		switch {
+		case c.policy.Action == errorPolicySkip:
+			log.Printf("Skipping message at offset %d (%s) after attempt %d: %v", msg.Offset, c.topic, attempt, err)
+			c.record(func(s *consumerStats) { s.Skipped++ })
+			consumerErrors.WithLabelValues(c.topic, "skipped").Inc()
+			return
+		case c.policy.Action == errorPolicyDLQ && (malformed || attempt >= c.policy.MaxAttempts):
+			c.deadLetter(msg, err, attempt)
+			return
+		}
+
+		backoff := consumerRetryBackoff(attempt)
+		log.Printf("Failed to process message at offset %d (%s), attempt %d, retrying in %s: %v", msg.Offset, c.topic, attempt, backoff, err)
+		c.record(func(s *consumerStats) { s.Retries++ })
+		consumerErrors.WithLabelValues(c.topic, "retried").Inc()
+		c.sleep(backoff)
+	}
+}
+
+// deadLetter publishes msg to the dead-letter topic with the error that made it fail. The consumer
+// can't move past msg without losing it, so publishing is retried until it succeeds.
+func (c *eventConsumer) deadLetter(msg kafka.Message, cause error, attempts int) {
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "dlq-error", Value: []byte(cause.Error())},
+		kafka.Header{Key: "dlq-attempts", Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: "dlq-source-topic", Value: []byte(c.topic)},
+		kafka.Header{Key: "dlq-source-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dlq-source-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: "dlq-failed-at", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+	dead := kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+
+	for attempt := 1; ; attempt++ {
+		err := kafkawriter.ErrUnavailable
+		if c.deadLetters != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = c.deadLetters.WriteMessages(ctx, dead)
+			cancel()
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to dead-letter message at offset %d (%s) to %s, retrying: %v", msg.Offset, c.topic, c.deadLetterTopic, err)
+		c.sleep(consumerRetryBackoff(attempt))
+	}
+	log.Printf("Dead-lettered message at offset %d (%s) to %s after %d attempts: %v", msg.Offset, c.topic, c.deadLetterTopic, attempts, cause)
+	c.record(func(s *consumerStats) { s.DeadLettered++ })
+	consumerErrors.WithLabelValues(c.topic, "dead_lettered").Inc()
+}
+
+func (c *eventConsumer) record(update func(s *consumerStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.stats)
+}
+
+func (c *eventConsumer) status() consumerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return consumerStatus{
+		Topic:           c.topic,
+		Group:           c.group,
+		Policy:          c.policy.String(),
+		DeadLetterTopic: c.deadLetterTopic,
+		consumerStats:   c.stats,
+	}
+}
+
+// getConsumers handles GET /internal/consumers
+func getConsumers(c *gin.Context) {
+	eventConsumersMu.Lock()
+	defer eventConsumersMu.Unlock()
+	statuses := make([]consumerStatus, 0, len(eventConsumers))
+	for _, consumer := range eventConsumers {
+		statuses = append(statuses, consumer.status())
+	}
+	c.JSON(http.StatusOK, gin.H{"consumers": statuses})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/kafkawriter"
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConsumerErrorPolicies(t *testing.T) {
+	original := consumerErrorPolicies
+	t.Cleanup(func() { consumerErrorPolicies = original })
+
+	t.Setenv("CONSUMER_ERROR_POLICIES", "")
+	require.NoError(t, loadConsumerErrorPolicies())
+	for _, topic := range consumedTopics {
+		assert.Equal(t, consumerErrorPolicy{Action: errorPolicyDLQ, MaxAttempts: 5}, consumerErrorPolicies[topic], topic)
+	}
+
+	t.Setenv("CONSUMER_ERROR_POLICIES", "order-created=dlq:3, album-created=retry,album-deleted=skip")
+	require.NoError(t, loadConsumerErrorPolicies())
+	assert.Equal(t, "dlq:3", consumerErrorPolicies[orderCreatedTopic].String())
+	assert.Equal(t, "retry", consumerErrorPolicies[albumCreatedTopic].String())
+	assert.Equal(t, "skip", consumerErrorPolicies[albumDeletedTopic].String())
+
+	for _, invalid := range []string{"order-created", "order-failed=skip", "order-created=ignore", "order-created=dlq", "order-created=dlq:0", "order-created=retry:3"} {
+		t.Setenv("CONSUMER_ERROR_POLICIES", invalid)
+		assert.Error(t, loadConsumerErrorPolicies(), invalid)
+	}
+}
+
+func TestConsumerRetryBackoff(t *testing.T) {
+	assert.Equal(t, 500*time.Millisecond, consumerRetryBackoff(1))
+	assert.Equal(t, 2*time.Second, consumerRetryBackoff(3))
+	assert.Equal(t, consumerRetryMaxBackoff, consumerRetryBackoff(100))
+}
+
+// failingWriter fails the first n writes, then records messages
+type failingWriter struct {
+	recordingWriter
+	failures int
+}
+
+func (w *failingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.failures > 0 {
+		w.failures--
+		return kafkawriter.ErrUnavailable
+	}
+	return w.recordingWriter.WriteMessages(ctx, msgs...)
+}
+
+// testConsumer returns a consumer with policy whose handler fails the first failures calls with err
+func testConsumer(t *testing.T, policy string, failures int, err error) (*eventConsumer, *int, *[]time.Duration) {
+	originalPolicies, originalConsumers := consumerErrorPolicies, eventConsumers
+	t.Cleanup(func() { consumerErrorPolicies, eventConsumers = originalPolicies, originalConsumers })
+	p, perr := parseConsumerErrorPolicy(policy)
+	require.NoError(t, perr)
+	consumerErrorPolicies = map[string]consumerErrorPolicy{"test-events": p}
+
+	calls := 0
+	c := newEventConsumer("", "test-events", "staging.test-events", "test-group", func(kafka.Message) error {
+		calls++
+		if calls <= failures {
+			return err
+		}
+		return nil
+	})
+	var slept []time.Duration
+	c.sleep = func(d time.Duration) { slept = append(slept, d) }
+	return c, &calls, &slept
+}
+
+func TestEventConsumer_Process(t *testing.T) {
+	msg := kafka.Message{Key: []byte("42"), Value: []byte(`{"albumId":"42"}`), Partition: 2, Offset: 17,
+		Headers: []kafka.Header{{Key: "traceparent", Value: []byte("00-abc-def-01")}}}
+	dbErr := errors.New("connection refused")
+
+	t.Run("retry retries until the event is processed", func(t *testing.T) {
+		c, calls, slept := testConsumer(t, "retry", 3, dbErr)
+		c.process(msg)
+		assert.Equal(t, 4, *calls)
+		assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}, *slept)
+		assert.Equal(t, int64(1), c.status().Processed)
+		assert.Equal(t, int64(3), c.status().Retries)
+		assert.Equal(t, "connection refused", c.status().LastError)
+	})
+
+	t.Run("dlq dead-letters after its attempts", func(t *testing.T) {
+		c, calls, _ := testConsumer(t, "dlq:3", 10, dbErr)
+		dlq := &failingWriter{failures: 1}
+		c.deadLetters = dlq
+		c.process(msg)
+		assert.Equal(t, 3, *calls)
+		require.Len(t, dlq.messages, 1, "the failed write is retried")
+		dead := dlq.messages[0]
+		assert.Equal(t, msg.Key, dead.Key)
+		assert.Equal(t, msg.Value, dead.Value)
+		headers := map[string]string{}
+		for _, h := range dead.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+		assert.Equal(t, "00-abc-def-01", headers["traceparent"])
+		assert.Equal(t, "connection refused", headers["dlq-error"])
+		assert.Equal(t, "3", headers["dlq-attempts"])
+		assert.Equal(t, "staging.test-events", headers["dlq-source-topic"])
+		assert.Equal(t, "2", headers["dlq-source-partition"])
+		assert.Equal(t, "17", headers["dlq-source-offset"])
+		assert.Equal(t, "staging.test-events.dlq", c.status().DeadLetterTopic)
+		assert.Equal(t, int64(1), c.status().DeadLettered)
+		assert.Equal(t, int64(0), c.status().Processed)
+	})
+
+	t.Run("dlq doesn't retry malformed events", func(t *testing.T) {
+		c, calls, _ := testConsumer(t, "dlq:5", 1, fmt.Errorf("%w: missing albumId", errMalformedEvent))
+		dlq := &recordingWriter{}
+		c.deadLetters = dlq
+		c.process(msg)
+		assert.Equal(t, 1, *calls)
+		assert.Len(t, dlq.messages, 1)
+	})
+
+	t.Run("skip moves on", func(t *testing.T) {
+		c, calls, slept := testConsumer(t, "skip", 1, dbErr)
+		c.process(msg)
+		assert.Equal(t, 1, *calls)
+		assert.Empty(t, *slept)
+		assert.Equal(t, int64(1), c.status().Skipped)
+		assert.Empty(t, c.status().DeadLetterTopic)
+	})
+}
+
+func TestGetConsumers(t *testing.T) {
+	c, _, _ := testConsumer(t, "skip", 1, errors.New("boom"))
+	c.process(kafka.Message{})
+
+	router := gin.New()
+	router.GET("/internal/consumers", getConsumers)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/internal/consumers", nil)
+	router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		Consumers []map[string]interface{} `json:"consumers"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	require.Len(t, body.Consumers, 1)
+	assert.Equal(t, "staging.test-events", body.Consumers[0]["topic"])
+	assert.Equal(t, "skip", body.Consumers[0]["policy"])
+	assert.Equal(t, float64(1), body.Consumers[0]["skipped"])
+	assert.Equal(t, "boom", body.Consumers[0]["lastError"])
+}
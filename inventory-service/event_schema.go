@@ -0,0 +1,179 @@
+// event_schema.go - versioned event payloads for zero-downtime schema migrations
+//
+// Each schema version has its own topic (v1 keeps the original name, vN uses "<topic>.vN"), so a
+// producer can dual-publish while consumers move over one at a time. Consumers decode either
+// version regardless of the topic they read and count what they observe, so it's visible when the
+// old version can be retired. From v2 on events are wrapped in the standard envelope (events.Envelope)
+// and consumers dispatch them on its eventType.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"album-store/events"
+	"album-store/jsonnaming"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	eventSchemaV1            = 1 // Flat JSON payload (the original format)
+	eventSchemaV2            = 2 // events.Envelope wrapping the v1 payload in "data"
+	latestEventSchemaVersion = eventSchemaV2
+)
+
+// eventProducer names this service in the envelope of the events it publishes
+const eventProducer = "inventory-service"
+
+// topicEventTypes maps the base topics consumed here to the type of their v1 events, which carry none
+var topicEventTypes = map[string]string{
+	orderCreatedTopic: events.TypeOrderCreated,
+	albumCreatedTopic: events.TypeAlbumCreated,
+	albumDeletedTopic: events.TypeAlbumDeleted,
+}
+
+var (
+	// eventPublishVersions lists the versions every order event is published in (EVENT_SCHEMA_PUBLISH_VERSIONS). Both
+	// by default, so v1 consumers keep working while v2 consumers get the envelope's eventId.
+	eventPublishVersions = []int{eventSchemaV1, eventSchemaV2}
+	// eventConsumeVersion selects which version's topic order-created is read from (EVENT_SCHEMA_CONSUME_VERSION)
+	eventConsumeVersion = eventSchemaV2
+)
+
+// loadEventSchemaConfig reads the publish/consume versions from the environment
+func loadEventSchemaConfig() error {
+	if v := os.Getenv("EVENT_SCHEMA_PUBLISH_VERSIONS"); v != "" {
+		var versions []int
+		seen := make(map[int]bool)
+		for _, part := range strings.Split(v, ",") {
+			version, err := parseEventSchemaVersion(strings.TrimSpace(part))
+			if err != nil {
+				return fmt.Errorf("EVENT_SCHEMA_PUBLISH_VERSIONS: %w", err)
+			}
+			if !seen[version] {
+				seen[version] = true
+				versions = append(versions, version)
+			}
+		}
+		eventPublishVersions = versions
+	}
+	if v := os.Getenv("EVENT_SCHEMA_CONSUME_VERSION"); v != "" {
+		version, err := parseEventSchemaVersion(v)
+		if err != nil {
+			return fmt.Errorf("EVENT_SCHEMA_CONSUME_VERSION: %w", err)
+		}
+		eventConsumeVersion = version
+	}
+	return nil
+}
+
+func parseEventSchemaVersion(s string) (int, error) {
+	version, err := strconv.Atoi(s)
+	if err != nil || version < eventSchemaV1 || version > latestEventSchemaVersion {
+		return 0, fmt.Errorf("unsupported schema version %q (supported: %d-%d)", s, eventSchemaV1, latestEventSchemaVersion)
+	}
+	return version, nil
+}
+
+// publishesEventSchema reports whether order events are published in the given version
+func publishesEventSchema(version int) bool {
+	for _, v := range eventPublishVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// versionedTopic returns the base topic name carrying the given schema version (before environment scoping)
+func versionedTopic(base string, version int) string {
+	if version == eventSchemaV1 {
+		return base
+	}
+	return fmt.Sprintf("%s.v%d", base, version)
+}
+
+// encodeEvent serializes payload in the given schema version. eventID is the same for every
+// version of one event, so consumers can dedupe across topics.
+func encodeEvent(version int, eventType, eventID string, payload proto.Message) ([]byte, error) {
+	data, err := events.MarshalJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+	if version == eventSchemaV1 {
+		return data, nil
+	}
+	return events.Wrap(version, eventType, eventID, eventProducer, data)
+}
+
+// decodeEvent unmarshals a v1 or v2 payload in either JSON field naming into v and records the
+// observed version for topic. Payloads without a schemaVersion field are v1.
+func decodeEvent(msg kafka.Message, topic string, v proto.Message) (int, error) {
+	value := jsonnaming.CamelCaseEvent(msg.Value)
+	var probe struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		Data          json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &probe); err != nil {
+		return 0, err
+	}
+
+	version := probe.SchemaVersion
+	if version == 0 {
+		version = eventSchemaV1
+	}
+	label := strconv.Itoa(version)
+	if version < eventSchemaV1 || version > latestEventSchemaVersion {
+		label = "unsupported" // Keep the label set bounded
+	}
+	eventSchemaVersionsObserved.WithLabelValues(topic, label).Inc()
+
+	switch {
+	case version == eventSchemaV1:
+		return version, events.UnmarshalJSON(value, v)
+	case version >= eventSchemaV2 && version <= latestEventSchemaVersion:
+		if len(probe.Data) == 0 {
+			return version, errors.New("schema v2 event without data")
+		}
+		return version, events.UnmarshalJSON(probe.Data, v)
+	default:
+		return version, fmt.Errorf("unsupported event schema version %d", version)
+	}
+}
+
+// eventTypeOf returns the type of an event consumed from base topic: the eventType of its envelope,
+// or the topic's own type for v1 and Avro events
+func eventTypeOf(msg kafka.Message, base string) string {
+	if len(msg.Value) > 0 && msg.Value[0] != 0 {
+		var probe struct {
+			EventType string `json:"eventType"`
+		}
+		if json.Unmarshal(jsonnaming.CamelCaseEvent(msg.Value), &probe) == nil && probe.EventType != "" {
+			return probe.EventType
+		}
+	}
+	return topicEventTypes[base]
+}
+
+// byEventType returns a handler of base topic's events that dispatches each event to the handler of
+// its type. Events of other types are skipped, so a producer can add a type to a topic before every
+// consumer handles it.
+func byEventType(base string, handlers map[string]func(kafka.Message) error) func(kafka.Message) error {
+	return func(msg kafka.Message) error {
+		eventType := eventTypeOf(msg, base)
+		handle, ok := handlers[eventType]
+		if !ok {
+			log.Printf("Skipping %s event of unhandled type %q at offset %d", base, eventType, msg.Offset)
+			unhandledEventTypes.WithLabelValues(base).Inc()
+			return nil
+		}
+		return handle(msg)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"album-store/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecodeEvent_AcceptsBothVersions(t *testing.T) {
+	payload := &events.OrderCreated{OrderId: "order-1", AlbumId: "album-1", Quantity: 2}
+
+	for _, version := range []int{eventSchemaV1, eventSchemaV2} {
+		value, err := encodeEvent(version, events.TypeOrderCreated, events.NewEventID(), payload)
+		require.NoError(t, err)
+
+		before := testutil.ToFloat64(eventSchemaVersionsObserved.WithLabelValues(orderCreatedTopic, strconv.Itoa(version)))
+		var decoded events.OrderCreated
+		got, err := decodeEvent(kafka.Message{Value: value}, orderCreatedTopic, &decoded)
+		require.NoError(t, err)
+		assert.Equal(t, version, got)
+		assert.True(t, proto.Equal(payload, &decoded), "decoded %v", &decoded)
+		assert.Equal(t, before+1, testutil.ToFloat64(eventSchemaVersionsObserved.WithLabelValues(orderCreatedTopic, strconv.Itoa(version))))
+	}
+
+	var decoded events.OrderCreated
+	_, err := decodeEvent(kafka.Message{Value: []byte(`{"schemaVersion":7,"data":{}}`)}, orderCreatedTopic, &decoded)
+	assert.Error(t, err)
+}
+
+func TestDecodeEvent_AcceptsSnakeCase(t *testing.T) {
+	// As published by a deployment with JSON_FIELD_NAMING=snake_case
+	value := `{"schema_version":2,"event_type":"order.created","event_id":"order.created:order-1",` +
+		`"data":{"order_id":"order-1","album_id":"album-1","quantity":2,"pickup_warehouse_id":"wh-1","metadata":{"gift_note":"Happy birthday"}}}`
+
+	var decoded events.OrderCreated
+	version, err := decodeEvent(kafka.Message{Value: []byte(value)}, orderCreatedTopic, &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, eventSchemaV2, version)
+	assert.True(t, proto.Equal(&events.OrderCreated{OrderId: "order-1", AlbumId: "album-1", Quantity: 2, PickupWarehouseId: "wh-1",
+		Metadata: map[string]string{"giftNote": "Happy birthday"}}, &decoded), "decoded %v", &decoded)
+}
+
+func TestSendOrderEvent_DualPublish(t *testing.T) {
+	failedV1, _ := useRecordingWriters(t)
+	failedV2 := &recordingWriter{}
+	prevWriter, prevVersions := kafkaFailedEventWriterV2, eventPublishVersions
+	kafkaFailedEventWriterV2, eventPublishVersions = failedV2, []int{eventSchemaV1, eventSchemaV2}
+	t.Cleanup(func() { kafkaFailedEventWriterV2, eventPublishVersions = prevWriter, prevVersions })
+
+	require.NoError(t, sendOrderFailedEvent(context.Background(), &events.OrderCreated{OrderId: "order-9"}, failureReasonInsufficientInventory))
+
+	require.Len(t, failedV1.messages, 1)
+	assert.Equal(t, failureReasonInsufficientInventory, failedReason(t, failedV1.messages[0]))
+
+	require.Len(t, failedV2.messages, 1)
+	var envelope events.Envelope
+	require.NoError(t, json.Unmarshal(failedV2.messages[0].Value, &envelope))
+	assert.Equal(t, eventSchemaV2, envelope.SchemaVersion)
+	assert.Equal(t, events.TypeOrderFailed, envelope.EventType)
+	assert.Equal(t, events.StableEventID("order.failed:order-9"), envelope.EventID, "republishing the event keeps its ID")
+	assert.Equal(t, "inventory-service", envelope.Producer)
+
+	var event events.OrderFailed
+	require.NoError(t, events.UnmarshalJSON(envelope.Data, &event))
+	assert.Equal(t, "order-9", event.OrderId)
+	assert.Equal(t, failureReasonInsufficientInventory, event.Reason)
+}
+
+func TestByEventType(t *testing.T) {
+	var handled []string
+	handle := byEventType(orderCreatedTopic, map[string]func(kafka.Message) error{
+		events.TypeOrderCreated: func(msg kafka.Message) error {
+			handled = append(handled, string(msg.Key))
+			return nil
+		},
+	})
+	skipped := unhandledEventTypes.WithLabelValues(orderCreatedTopic)
+	before := testutil.ToFloat64(skipped)
+
+	for key, value := range map[string]string{
+		"v1":         `{"orderId":"order-1"}`,
+		"v2":         `{"schemaVersion":2,"eventType":"order.created","data":{"orderId":"order-2"}}`,
+		"snake_case": `{"schema_version":2,"event_type":"order.created","data":{"order_id":"order-3"}}`,
+		"cancelled":  `{"schemaVersion":2,"eventType":"order.cancelled","data":{"orderId":"order-4"}}`,
+	} {
+		require.NoError(t, handle(kafka.Message{Key: []byte(key), Value: []byte(value)}), key)
+	}
+	assert.ElementsMatch(t, []string{"v1", "v2", "snake_case"}, handled)
+	assert.Equal(t, before+1, testutil.ToFloat64(skipped), "an unhandled type is skipped")
+}
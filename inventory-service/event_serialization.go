@@ -0,0 +1,141 @@
+// event_serialization.go - decoding of album events, published as JSON or, with EVENT_SERIALIZATION=avro
+// on album-service, as Avro in the Confluent wire format (see album-service/event_serialization.go).
+//
+// An Avro value starts with a zero byte, which JSON never does, so both formats can share a topic while
+// album-service switches over. The writer schema of an Avro event is fetched from the registry at
+// SCHEMA_REGISTRY_URL by the ID in the message and resolved against the reader schema in schemas/:
+// fields album-service added are skipped, fields it dropped take the reader's default. An event whose
+// schema can't be resolved is malformed and handled by the topic's error policy.
+
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"album-store/events"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// Formats of consumed events, see eventFormatsObserved
+const (
+	eventFormatJSON = "json"
+	eventFormatAvro = "avro"
+)
+
+// schemaRegistryTimeout bounds each request to the schema registry
+const schemaRegistryTimeout = 5 * time.Second
+
+//go:embed schemas/*.avsc
+var eventSchemaFiles embed.FS
+
+// avroReaderSchemas names the schema file album events of each base topic are read with
+var avroReaderSchemas = map[string]string{
+	albumCreatedTopic: "schemas/album_created.avsc",
+	albumDeletedTopic: "schemas/album_deleted.avsc",
+}
+
+var (
+	// schemaRegistry fetches writer schemas (SCHEMA_REGISTRY_URL); nil when unset
+	schemaRegistry *registry.Client
+	// resolvedSchemas caches the resolved schema by "<base topic>/<schema ID>"
+	resolvedSchemas sync.Map
+)
+
+// loadSchemaRegistry reads SCHEMA_REGISTRY_URL
+func loadSchemaRegistry() error {
+	v := os.Getenv("SCHEMA_REGISTRY_URL")
+	if v == "" {
+		schemaRegistry = nil
+		return nil
+	}
+	if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("SCHEMA_REGISTRY_URL %q is not an absolute URL", v)
+	}
+	client, err := registry.NewClient(v, registry.WithHTTPClient(&http.Client{Timeout: schemaRegistryTimeout}))
+	if err != nil {
+		return err
+	}
+	schemaRegistry = client
+	return nil
+}
+
+// decodeAlbumEvent unmarshals an album event consumed from a base topic into v. Errors of malformed
+// events wrap errMalformedEvent; failing to fetch a schema doesn't, so the event is retried.
+func decodeAlbumEvent(ctx context.Context, msg kafka.Message, base string, v proto.Message) error {
+	value := msg.Value
+	if len(value) == 0 || value[0] != 0 {
+		eventFormatsObserved.WithLabelValues(base, eventFormatJSON).Inc()
+		if _, err := decodeEvent(msg, base, v); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedEvent, err)
+		}
+		return nil
+	}
+
+	eventFormatsObserved.WithLabelValues(base, eventFormatAvro).Inc()
+	if len(value) < 5 {
+		return fmt.Errorf("%w: Avro event without a schema ID", errMalformedEvent)
+	}
+	schema, err := resolvedEventSchema(ctx, base, int(binary.BigEndian.Uint32(value[1:5])))
+	if err != nil {
+		return err
+	}
+	var record map[string]interface{}
+	if err := avro.Unmarshal(schema, value[5:], &record); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	if err := events.FromAvroRecord(record, v); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	return nil
+}
+
+// resolvedEventSchema returns the schema that reads events written with the registry's schema id as
+// the base topic's reader schema
+func resolvedEventSchema(ctx context.Context, base string, id int) (avro.Schema, error) {
+	key := fmt.Sprintf("%s/%d", base, id)
+	if schema, ok := resolvedSchemas.Load(key); ok {
+		return schema.(avro.Schema), nil
+	}
+	file, ok := avroReaderSchemas[base]
+	if !ok {
+		return nil, fmt.Errorf("%w: no Avro schema for %s", errMalformedEvent, base)
+	}
+	if schemaRegistry == nil {
+		return nil, fmt.Errorf("schema %d of an Avro event: SCHEMA_REGISTRY_URL is not set", id)
+	}
+
+	writer, err := schemaRegistry.GetSchema(ctx, id)
+	var regErr registry.Error
+	if errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: unknown schema %d", errMalformedEvent, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching schema %d: %w", id, err)
+	}
+	text, err := eventSchemaFiles.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := avro.Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	resolved, err := avro.NewSchemaCompatibility().Resolve(reader, writer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: schema %d can't be read with %s: %v", errMalformedEvent, id, file, err)
+	}
+	resolvedSchemas.Store(key, resolved)
+	return resolved, nil
+}
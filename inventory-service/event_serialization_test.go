@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// albumCreatedV2 is a later album-created schema: title lost its default and label was added
+const albumCreatedV2 = `{"type":"record","name":"AlbumCreated","namespace":"albumstore.events","fields":[
+	{"name":"albumId","type":"string"},
+	{"name":"artist","type":"string"},
+	{"name":"label","type":"string"},
+	{"name":"timestamp","type":{"type":"long","logicalType":"timestamp-millis"}},
+	{"name":"initialQuantity","type":["null","int"],"default":null}]}`
+
+// useSchemaRegistry points schemaRegistry at a fake serving schemas by ID; other IDs answer status
+func useSchemaRegistry(t *testing.T, schemas map[int]string, status int) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for id, schema := range schemas {
+			if r.URL.Path == "/schemas/ids/"+strconv.Itoa(id) {
+				_ = json.NewEncoder(w).Encode(map[string]string{"schema": schema})
+				return
+			}
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error_code": status, "message": http.StatusText(status)})
+	}))
+	client, err := registry.NewClient(srv.URL)
+	require.NoError(t, err)
+	schemaRegistry = client
+	resolvedSchemas.Clear()
+	t.Cleanup(func() {
+		srv.Close()
+		schemaRegistry = nil
+		resolvedSchemas.Clear()
+	})
+}
+
+// avroMessage encodes record with schema in the Confluent wire format under the given ID
+func avroMessage(t *testing.T, id int, schema string, record map[string]interface{}) kafka.Message {
+	body, err := avro.Marshal(avro.MustParse(schema), record)
+	require.NoError(t, err)
+	value := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(value[1:], uint32(id))
+	return kafka.Message{Value: append(value, body...)}
+}
+
+func TestDecodeAlbumEvent(t *testing.T) {
+	ctx := context.Background()
+	timestamp := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("JSON", func(t *testing.T) {
+		before := testutil.ToFloat64(eventFormatsObserved.WithLabelValues(albumCreatedTopic, eventFormatJSON))
+		var event events.AlbumCreated
+		require.NoError(t, decodeAlbumEvent(ctx, kafka.Message{Value: []byte(`{"album_id":"42","title":"Blue Train"}`)}, albumCreatedTopic, &event))
+		assert.True(t, proto.Equal(&events.AlbumCreated{AlbumId: "42", Title: "Blue Train"}, &event), "decoded %v", &event)
+		assert.Equal(t, before+1, testutil.ToFloat64(eventFormatsObserved.WithLabelValues(albumCreatedTopic, eventFormatJSON)))
+
+		event.Reset()
+		envelope := `{"schemaVersion":2,"eventType":"album.created","producer":"album-service","data":{"albumId":"43"}}`
+		require.NoError(t, decodeAlbumEvent(ctx, kafka.Message{Value: []byte(envelope)}, albumCreatedTopic, &event))
+		assert.Equal(t, "43", event.GetAlbumId())
+
+		assert.ErrorIs(t, decodeAlbumEvent(ctx, kafka.Message{Value: []byte(`{"albumId":`)}, albumCreatedTopic, &event), errMalformedEvent)
+	})
+
+	t.Run("Avro with the reader schema", func(t *testing.T) {
+		text, err := eventSchemaFiles.ReadFile("schemas/album_created.avsc")
+		require.NoError(t, err)
+		useSchemaRegistry(t, map[int]string{1: string(text)}, http.StatusNotFound)
+
+		before := testutil.ToFloat64(eventFormatsObserved.WithLabelValues(albumCreatedTopic, eventFormatAvro))
+		msg := avroMessage(t, 1, string(text), map[string]interface{}{
+			"albumId": "42", "title": "Blue Train", "artist": "John Coltrane", "timestamp": timestamp, "initialQuantity": 5,
+		})
+		var event events.AlbumCreated
+		require.NoError(t, decodeAlbumEvent(ctx, msg, albumCreatedTopic, &event))
+		quantity := int32(5)
+		assert.True(t, proto.Equal(&events.AlbumCreated{AlbumId: "42", Title: "Blue Train", Artist: "John Coltrane",
+			Timestamp: timestamppb.New(timestamp), InitialQuantity: &quantity}, &event), "decoded %v", &event)
+		assert.Equal(t, before+1, testutil.ToFloat64(eventFormatsObserved.WithLabelValues(albumCreatedTopic, eventFormatAvro)))
+	})
+
+	t.Run("Avro with a later writer schema", func(t *testing.T) {
+		useSchemaRegistry(t, map[int]string{2: albumCreatedV2}, http.StatusNotFound)
+		msg := avroMessage(t, 2, albumCreatedV2, map[string]interface{}{
+			"albumId": "43", "artist": "Nina Simone", "label": "Bethlehem", "timestamp": timestamp, "initialQuantity": nil,
+		})
+		var event events.AlbumCreated
+		require.NoError(t, decodeAlbumEvent(ctx, msg, albumCreatedTopic, &event))
+		assert.True(t, proto.Equal(&events.AlbumCreated{AlbumId: "43", Artist: "Nina Simone", Timestamp: timestamppb.New(timestamp)}, &event),
+			"label is skipped, title takes the reader's default: %v", &event)
+	})
+
+	t.Run("Unreadable schemas are malformed", func(t *testing.T) {
+		useSchemaRegistry(t, map[int]string{3: `{"type":"record","name":"AlbumDeleted","namespace":"albumstore.events","fields":[{"name":"albumId","type":"long"}]}`}, http.StatusNotFound)
+		var event events.AlbumDeleted
+		err := decodeAlbumEvent(ctx, avroMessage(t, 3, `{"type":"record","name":"AlbumDeleted","namespace":"albumstore.events","fields":[{"name":"albumId","type":"long"}]}`, map[string]interface{}{"albumId": int64(42)}), albumDeletedTopic, &event)
+		assert.ErrorIs(t, err, errMalformedEvent)
+
+		err = decodeAlbumEvent(ctx, kafka.Message{Value: []byte{0, 0, 0, 0, 9, 2}}, albumDeletedTopic, &event)
+		assert.ErrorIs(t, err, errMalformedEvent, "unknown schema ID")
+		assert.ErrorIs(t, decodeAlbumEvent(ctx, kafka.Message{Value: []byte{0, 1}}, albumDeletedTopic, &event), errMalformedEvent)
+	})
+
+	t.Run("Registry failures are retried", func(t *testing.T) {
+		useSchemaRegistry(t, nil, http.StatusInternalServerError)
+		var event events.AlbumDeleted
+		err := decodeAlbumEvent(ctx, kafka.Message{Value: []byte{0, 0, 0, 0, 1, 2}}, albumDeletedTopic, &event)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, errMalformedEvent)
+
+		schemaRegistry = nil
+		err = decodeAlbumEvent(ctx, kafka.Message{Value: []byte{0, 0, 0, 0, 1, 2}}, albumDeletedTopic, &event)
+		assert.ErrorContains(t, err, "SCHEMA_REGISTRY_URL is not set")
+		assert.NotErrorIs(t, err, errMalformedEvent)
+	})
+}
+
+func TestLoadSchemaRegistry(t *testing.T) {
+	t.Cleanup(func() { schemaRegistry = nil })
+
+	require.NoError(t, loadSchemaRegistry())
+	assert.Nil(t, schemaRegistry)
+
+	t.Setenv("SCHEMA_REGISTRY_URL", "http://schema-registry:8081")
+	require.NoError(t, loadSchemaRegistry())
+	assert.NotNil(t, schemaRegistry)
+
+	t.Setenv("SCHEMA_REGISTRY_URL", "schema-registry:8081")
+	assert.Error(t, loadSchemaRegistry())
+}
+
+// TestAlbumEventSchemasReadAlbumService checks that the reader schemas can read the events
+// album-service writes, so the copies in both services can't drift apart unnoticed
+func TestAlbumEventSchemasReadAlbumService(t *testing.T) {
+	for base, file := range avroReaderSchemas {
+		writerText, err := os.ReadFile("../album-service/" + file)
+		if os.IsNotExist(err) {
+			t.Skip("album-service isn't checked out next to inventory-service")
+		}
+		require.NoError(t, err)
+		readerText, err := eventSchemaFiles.ReadFile(file)
+		require.NoError(t, err)
+
+		err = avro.NewSchemaCompatibility().Compatible(avro.MustParse(string(readerText)), avro.MustParse(string(writerText)))
+		assert.NoError(t, err, base)
+	}
+}
@@ -0,0 +1,124 @@
+// health.go - liveness and readiness probes. /healthz only says that the process serves requests;
+// an orchestrator restarts the instance when it fails. /readyz says whether the instance can do its
+// work: the database answers, the order event writers are connected and every Kafka consumer
+// goroutine is running. An instance that isn't ready is taken out of load balancing without being
+// restarted.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"album-store/kafkawriter"
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the database ping of /readyz
+const readinessTimeout = 2 * time.Second
+
+// Consumer goroutine states reported by /readyz
+const (
+	consumerRunning = "running"
+	consumerStopped = "stopped" // The goroutine returned; the consumer reads nothing until a restart
+)
+
+// consumerGoroutineStatus is a consumer goroutine as reported by /readyz
+type consumerGoroutineStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+var (
+	consumerGoroutinesMu sync.Mutex
+	consumerGoroutines   = map[string]string{} // State by consumer name
+)
+
+// goConsumer runs the consumer started by start in a goroutine watched by /readyz. The consumer is
+// registered before the goroutine starts, so the instance is never reported ready without it.
+func goConsumer(name string, start func(kafkaBroker string), kafkaBroker string) {
+	setConsumerGoroutineState(name, consumerRunning)
+	go func() {
+		defer func() {
+			log.Printf("Consumer goroutine '%s' stopped", name)
+			setConsumerGoroutineState(name, consumerStopped)
+		}()
+		start(kafkaBroker)
+	}()
+}
+
+func setConsumerGoroutineState(name, state string) {
+	consumerGoroutinesMu.Lock()
+	defer consumerGoroutinesMu.Unlock()
+	consumerGoroutines[name] = state
+}
+
+// consumerGoroutineStatuses lists the consumer goroutines by name
+func consumerGoroutineStatuses() []consumerGoroutineStatus {
+	consumerGoroutinesMu.Lock()
+	defer consumerGoroutinesMu.Unlock()
+	statuses := make([]consumerGoroutineStatus, 0, len(consumerGoroutines))
+	for name, state := range consumerGoroutines {
+		statuses = append(statuses, consumerGoroutineStatus{Name: name, State: state})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// registerHealthRoutes adds the probes. /health and /health/ready are the names they had before
+// /healthz and /readyz, kept for probes still configured with them.
+func registerHealthRoutes(router gin.IRoutes) {
+	router.GET("/healthz", getLiveness)
+	router.GET("/readyz", getReadiness)
+	router.GET("/health", getLiveness)
+	router.GET("/health/ready", getReadiness)
+}
+
+// getLiveness handles GET /healthz. It checks no dependency: restarting the instance doesn't fix an
+// unreachable database.
+func getLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// getReadiness handles GET /readyz: the database must answer a ping, both order event writers must
+// be connected and every consumer goroutine must be running. Returns 503 with per-dependency details
+// otherwise.
+func getReadiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	dbStatus := "ok"
+	if err := db.PingContext(ctx); err != nil {
+		ready = false
+		dbStatus = "unavailable: " + err.Error()
+	}
+
+	kafkaStatuses := []kafkawriter.Status{}
+	for _, writer := range eventWriters() {
+		if w, ok := writer.(*kafkawriter.Writer); ok {
+			kafkaStatuses = append(kafkaStatuses, w.Status())
+			ready = ready && w.Ready()
+		}
+	}
+
+	consumers := consumerGoroutineStatuses()
+	for _, consumer := range consumers {
+		ready = ready && consumer.State == consumerRunning
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready":     ready,
+		"database":  dbStatus,
+		"kafka":     kafkaStatuses,
+		"consumers": consumers,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthProbes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	useRecordingWriters(t)
+	t.Cleanup(func() {
+		db = originalDB
+		consumerGoroutinesMu.Lock()
+		consumerGoroutines = map[string]string{}
+		consumerGoroutinesMu.Unlock()
+	})
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return rr, body
+	}
+
+	t.Run("Liveness checks no dependency", func(t *testing.T) {
+		for _, path := range []string{"/healthz", "/health"} {
+			rr, body := get(path)
+			assert.Equal(t, http.StatusOK, rr.Code, path)
+			assert.Equal(t, true, body["ok"], path)
+		}
+	})
+
+	stop := make(chan struct{})
+	goConsumer(orderCreatedTopic, func(string) { <-stop }, "kafka:9092")
+
+	t.Run("Ready", func(t *testing.T) {
+		for _, path := range []string{"/readyz", "/health/ready"} {
+			mock.ExpectPing()
+			rr, body := get(path)
+			assert.Equal(t, http.StatusOK, rr.Code, path)
+			assert.Equal(t, true, body["ready"], path)
+			assert.Equal(t, []interface{}{map[string]interface{}{"name": orderCreatedTopic, "state": consumerRunning}}, body["consumers"], path)
+		}
+	})
+
+	t.Run("Database unreachable", func(t *testing.T) {
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		rr, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "unavailable: connection refused", body["database"])
+	})
+
+	t.Run("Consumer goroutine stopped", func(t *testing.T) {
+		close(stop)
+		require.Eventually(t, func() bool {
+			return consumerGoroutineStatuses()[0].State == consumerStopped
+		}, time.Second, 10*time.Millisecond)
+		mock.ExpectPing()
+		rr, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, false, body["ready"])
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
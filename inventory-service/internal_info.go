@@ -0,0 +1,221 @@
+// internal_info.go - GET /internal/info: how this instance is wired, so on-call engineers can check
+// a pod's configuration, dependencies and schema during an incident without exec-ing into it.
+// Secrets are redacted; the database is described by host, port, name and user only.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"album-store/jsonnaming"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// processStartedAt is reported as the instance's start time
+var processStartedAt = time.Now()
+
+// configVariables lists every environment variable inventory-service reads
+var configVariables = []string{
+	"ALBUM_CREATED_BACKLOG_WARN_AGE", "ALBUM_CREATED_BACKLOG_WARN_MESSAGES", "CONSUMER_ERROR_POLICIES",
+	"DB_CONNECTION", "DB_MAX_CONN_LIFETIME", "DB_MAX_CONNS", "DB_MIGRATE_ON_STARTUP", "DB_MIN_CONNS",
+	"DB_READ_CONNECTION", "ENVIRONMENT", "EVENT_SCHEMA_CONSUME_VERSION", "EVENT_SCHEMA_PUBLISH_VERSIONS",
+	"INVENTORY_IMPORT_COLUMNS", "INVENTORY_STRICT_LOOKUPS", "INVENTORY_WAREHOUSE_ID", "JSON_FIELD_NAMING",
+	"KAFKA_BROKER", "KAFKA_PRODUCER_ACKS", "KAFKA_PRODUCER_ASYNC", "KAFKA_PRODUCER_BATCH_SIZE",
+	"KAFKA_PRODUCER_BATCH_TIMEOUT", "KAFKA_PRODUCER_MAX_ATTEMPTS", "KAFKA_TOPIC_PREFIX", "KAFKA_TOPIC_SUFFIX",
+	"LOW_STOCK_WEBHOOK_SECRET", "LOW_STOCK_WEBHOOK_URL", "MAX_REQUEST_BODY_BYTES",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_REDACT_ATTRIBUTES", "OTEL_REDACT_HASH_KEY", "PUBLISH_RETRY_BUFFER_SIZE",
+	"REDIS_CACHE_TTL", "REDIS_URL", "SAGA_RECOVERY_INTERVAL", "SCHEMA_REGISTRY_URL", "SERVICE_PORT",
+}
+
+// secretConfigVariables are reported as set or not, never by value. The schema registry and webhook
+// URLs may carry credentials.
+var secretConfigVariables = map[string]bool{
+	"DB_CONNECTION":            true,
+	"DB_READ_CONNECTION":       true,
+	"LOW_STOCK_WEBHOOK_SECRET": true,
+	"LOW_STOCK_WEBHOOK_URL":    true,
+	"OTEL_REDACT_HASH_KEY":     true,
+	"REDIS_URL":                true,
+	"SCHEMA_REGISTRY_URL":      true,
+}
+
+const redactedValue = "[redacted]"
+
+// internalInfoTimeout limits the schema lookup of GET /internal/info
+const internalInfoTimeout = 3 * time.Second
+
+// InternalInfo is the response of GET /internal/info
+type InternalInfo struct {
+	Service        string                 `json:"service"`
+	StartedAt      time.Time              `json:"startedAt"`
+	Build          BuildInfo              `json:"build"`
+	Dependencies   DependencyInfo         `json:"dependencies"`
+	Schema         SchemaInfo             `json:"schema"`
+	Features       map[string]interface{} `json:"features"`
+	ConsumerGroups []string               `json:"consumerGroups"`
+	// Config maps each variable to its value, null when unset (the default applies)
+	Config map[string]*string `json:"config"`
+}
+
+// BuildInfo describes the binary: its Go version, VCS revision and module versions
+type BuildInfo struct {
+	GoVersion string            `json:"goVersion"`
+	Revision  string            `json:"revision,omitempty"`
+	Modules   map[string]string `json:"modules"`
+}
+
+// DependencyInfo lists the endpoints the instance connects to
+type DependencyInfo struct {
+	Database     DatabaseEndpoint `json:"database"`
+	KafkaBroker  string           `json:"kafkaBroker"`
+	OTLPEndpoint string           `json:"otlpEndpoint"`
+}
+
+// DatabaseEndpoint is the database of DB_CONNECTION, without its password
+type DatabaseEndpoint struct {
+	Host     string `json:"host,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Database string `json:"database,omitempty"`
+	User     string `json:"user,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SchemaInfo reports the database schema: the migration it is at, the migrations not yet applied
+// (see migrations.go) and the expected tables and columns the database lacks
+type SchemaInfo struct {
+	Version           int64    `json:"version"`
+	PendingMigrations []string `json:"pendingMigrations"`
+	Pending           []string `json:"pending"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// getInternalInfo handles GET /internal/info
+func getInternalInfo(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), internalInfoTimeout)
+	defer cancel()
+
+	info := InternalInfo{
+		Service:   "inventory-service",
+		StartedAt: processStartedAt,
+		Build:     buildInfo(),
+		Dependencies: DependencyInfo{
+			Database:     databaseEndpoint(dbConnectionFromEnv()),
+			KafkaBroker:  kafkaBrokerAddress(),
+			OTLPEndpoint: otlpEndpointFromEnv(),
+		},
+		Schema:         schemaInfo(ctx, inventorySchema),
+		Features:       inventoryFeatures(),
+		ConsumerGroups: consumerGroups(),
+		Config:         redactedConfig(configVariables, secretConfigVariables),
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// inventoryFeatures reports the optional behaviour in effect
+func inventoryFeatures() map[string]interface{} {
+	return map[string]interface{}{
+		"jsonFieldNaming":        jsonnaming.Deployment,
+		"strictInventoryLookups": strictInventoryLookups,
+		"warehouseId":            localWarehouseID,
+		"eventPublishVersions":   eventPublishVersions,
+		"eventConsumeVersion":    eventConsumeVersion,
+		"publishRetryBuffer":     publishRetries.size,
+		"inventoryCache":         inventoryCache != nil,
+		"readReplica":            replicaDB != nil,
+		"migrateOnStartup":       migrateOnStartup,
+		"maxRequestBodyBytes":    maxRequestBodyBytes,
+		"kafkaProducer":          kafkaProducer.String(),
+		"schemaRegistry":         schemaRegistry != nil,
+		"lowStockWebhook":        lowStockWebhookURL != "",
+	}
+}
+
+// consumerGroups lists the groups of the running event consumers; their policies and stats are on
+// /internal/consumers
+func consumerGroups() []string {
+	eventConsumersMu.Lock()
+	defer eventConsumersMu.Unlock()
+	groups := []string{}
+	for _, consumer := range eventConsumers {
+		if !slices.Contains(groups, consumer.group) {
+			groups = append(groups, consumer.group)
+		}
+	}
+	return groups
+}
+
+// redactedConfig returns the value of each variable, with secrets redacted
+func redactedConfig(names []string, secrets map[string]bool) map[string]*string {
+	config := make(map[string]*string, len(names))
+	for _, name := range names {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			config[name] = nil
+			continue
+		}
+		if secrets[name] {
+			v = redactedValue
+		}
+		config[name] = &v
+	}
+	return config
+}
+
+// databaseEndpoint describes the database of a connection string, URL or key/value form
+func databaseEndpoint(connStr string) DatabaseEndpoint {
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		// The error may quote the connection string; only say that it's invalid
+		return DatabaseEndpoint{Error: "invalid DB_CONNECTION"}
+	}
+	return DatabaseEndpoint{Host: cfg.Host, Port: cfg.Port, Database: cfg.Database, User: cfg.User}
+}
+
+// schemaInfo reports the migration state and the expected tables and columns missing from the database
+func schemaInfo(ctx context.Context, expected map[string][]string) SchemaInfo {
+	info := SchemaInfo{PendingMigrations: []string{}, Pending: []string{}}
+	version, pending, err := migrationState(ctx, db)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Version, info.PendingMigrations = version, pending
+	missing, err := missingSchema(ctx, db, expected)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	if missing != nil {
+		info.Pending = missing
+	}
+	return info
+}
+
+// buildInfo reads the versions compiled into the binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Modules: map[string]string{}}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			info.Revision = s.Value
+		}
+	}
+	for _, dep := range bi.Deps {
+		version := dep.Version
+		if dep.Replace != nil {
+			version = dep.Replace.Version
+		}
+		info.Modules[dep.Path] = version
+	}
+	return info
+}
@@ -0,0 +1,690 @@
+// inventory_import.go - bulk stock updates from supplier stock files (CSV or X12 846 EDI). Rows are
+// matched to albums by UPC or catalog number, previewed, and applied on confirm as audited adjustments.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxInventoryImportBytes = 5 << 20
+	maxInventoryImportRows  = 10000
+	inventoryImportTTL      = time.Hour // Previews not confirmed within this window have to be re-uploaded
+	x12ContentType          = "application/edi-x12"
+)
+
+// Import modes: supplier stock reports carry the stock level, delivery notes the units received
+const (
+	importModeSet = "set"
+	importModeAdd = "add"
+)
+
+// Statuses of a previewed stock row; only "update" rows are applied on confirm
+const (
+	importStatusUpdate    = "update"
+	importStatusUnchanged = "unchanged"
+	importStatusUnmatched = "unmatched"
+	importStatusInvalid   = "invalid"
+)
+
+// AlbumIdentifiers are the supplier-facing identifiers of an album, used to match stock file rows
+type AlbumIdentifiers struct {
+	AlbumID       string    `json:"albumId"`
+	UPC           string    `json:"upc,omitempty"`
+	CatalogNumber string    `json:"catalogNumber,omitempty"`
+	LastUpdated   time.Time `json:"lastUpdated"`
+}
+
+// UpdateAlbumIdentifiersRequest sets an album's identifiers; omitted identifiers are cleared
+type UpdateAlbumIdentifiersRequest struct {
+	UPC           string `json:"upc" binding:"omitempty,max=20"`
+	CatalogNumber string `json:"catalogNumber" binding:"omitempty,max=50"`
+}
+
+// stockColumns maps the fields of a CSV stock row to the supplier's header names
+type stockColumns struct {
+	UPC           string
+	CatalogNumber string
+	Quantity      string
+}
+
+var defaultStockColumns = stockColumns{UPC: "upc", CatalogNumber: "catalog_number", Quantity: "quantity"}
+
+// stockRow is one row read from a stock file, before validation
+type stockRow struct {
+	UPC           string
+	CatalogNumber string
+	Quantity      string
+}
+
+// InventoryImportItem is one row of an import preview. Quantity is the file's value; QuantityBefore
+// and QuantityAfter are the stock level at preview time and what confirming would set it to.
+type InventoryImportItem struct {
+	Row            int    `json:"row"` // 1-based position in the file, excluding the CSV header
+	UPC            string `json:"upc,omitempty"`
+	CatalogNumber  string `json:"catalogNumber,omitempty"`
+	Quantity       int    `json:"quantity"`
+	AlbumID        string `json:"albumId,omitempty"`
+	QuantityBefore int    `json:"quantityBefore"`
+	QuantityAfter  int    `json:"quantityAfter"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// InventoryImportSummary counts preview rows by status
+type InventoryImportSummary struct {
+	Update    int `json:"update"`
+	Unchanged int `json:"unchanged"`
+	Unmatched int `json:"unmatched"`
+	Invalid   int `json:"invalid"`
+}
+
+// InventoryImportPreview is returned by the preview step; confirm it with its ImportID before ExpiresAt
+type InventoryImportPreview struct {
+	ImportID  string                 `json:"importId"`
+	Mode      string                 `json:"mode"`
+	ExpiresAt time.Time              `json:"expiresAt"`
+	Summary   InventoryImportSummary `json:"summary"`
+	Items     []InventoryImportItem  `json:"items"`
+}
+
+// InventoryAdjustment is one audited stock change
+type InventoryAdjustment struct {
+	AlbumID        string `json:"albumId"`
+	QuantityBefore int    `json:"quantityBefore"`
+	QuantityAfter  int    `json:"quantityAfter"`
+}
+
+// InventoryImportReport is returned by the confirm step
+type InventoryImportReport struct {
+	ImportID     string                `json:"importId"`
+	Applied      int                   `json:"applied"`
+	Skipped      int                   `json:"skipped"` // Rows whose stock changed since the preview so that they no longer apply
+	UnitsAdded   int                   `json:"unitsAdded"`
+	UnitsRemoved int                   `json:"unitsRemoved"`
+	Adjustments  []InventoryAdjustment `json:"adjustments"`
+}
+
+// normalizeUPC strips separators and left-pads the digits to a 14-digit GTIN, so a 12-digit UPC-A
+// and the same code as a 13-digit EAN match
+func normalizeUPC(upc string) (string, error) {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(upc))
+	if len(digits) < 8 || len(digits) > 14 {
+		return "", fmt.Errorf("UPC %q must have 8 to 14 digits", upc)
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("UPC %q must have 8 to 14 digits", upc)
+		}
+	}
+	return strings.Repeat("0", 14-len(digits)) + digits, nil
+}
+
+// loadStockColumns reads INVENTORY_IMPORT_COLUMNS, the default CSV header names as
+// field=header pairs, e.g. "upc=EAN,catalogNumber=Cat No,quantity=Stock"
+func loadStockColumns() (stockColumns, error) {
+	columns := defaultStockColumns
+	v := os.Getenv("INVENTORY_IMPORT_COLUMNS")
+	if v == "" {
+		return columns, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		field, header, ok := strings.Cut(entry, "=")
+		header = strings.TrimSpace(header)
+		if !ok || header == "" {
+			return columns, fmt.Errorf("INVENTORY_IMPORT_COLUMNS entry %q, expected field=header", entry)
+		}
+		switch strings.TrimSpace(field) {
+		case "upc":
+			columns.UPC = header
+		case "catalogNumber":
+			columns.CatalogNumber = header
+		case "quantity":
+			columns.Quantity = header
+		default:
+			return columns, fmt.Errorf("INVENTORY_IMPORT_COLUMNS field %q, expected upc, catalogNumber or quantity", field)
+		}
+	}
+	return columns, nil
+}
+
+// parseStockCSV reads a delimited stock file. Columns are matched by header name, case-insensitively;
+// the quantity column and at least one identifier column are required.
+func parseStockCSV(r io.Reader, columns stockColumns, delimiter rune) ([]stockRow, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	column := func(name string) int {
+		if i, ok := index[strings.ToLower(name)]; ok {
+			return i
+		}
+		return -1
+	}
+	upcCol, catalogCol, quantityCol := column(columns.UPC), column(columns.CatalogNumber), column(columns.Quantity)
+	if quantityCol < 0 {
+		return nil, fmt.Errorf("CSV is missing the quantity column %q", columns.Quantity)
+	}
+	if upcCol < 0 && catalogCol < 0 {
+		return nil, fmt.Errorf("CSV has neither the UPC column %q nor the catalog number column %q", columns.UPC, columns.CatalogNumber)
+	}
+	field := func(record []string, i int) string {
+		if i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []stockRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, stockRow{
+			UPC:           field(record, upcCol),
+			CatalogNumber: field(record, catalogCol),
+			Quantity:      field(record, quantityCol),
+		})
+	}
+}
+
+// parseX12Inventory reads an X12 846 inventory advice. Each LIN segment starts an item, identified by
+// a UPC/EAN/GTIN (qualifiers UP, EN, UK) and/or a vendor catalog number (VP, VN); the QTY segment that
+// follows carries the quantity available (qualifier 33) or on hand (17).
+func parseX12Inventory(body []byte) ([]stockRow, error) {
+	elementSep, segmentSep := byte('*'), byte('~')
+	// The ISA segment has a fixed width and declares both separators
+	if trimmed := bytes.TrimLeft(body, " \r\n\t"); bytes.HasPrefix(trimmed, []byte("ISA")) && len(trimmed) >= 106 {
+		elementSep, segmentSep = trimmed[3], trimmed[105]
+	}
+
+	var rows []stockRow
+	var current *stockRow
+	for _, segment := range bytes.Split(body, []byte{segmentSep}) {
+		elements := strings.Split(strings.TrimSpace(string(segment)), string(elementSep))
+		switch elements[0] {
+		case "LIN":
+			rows = append(rows, stockRow{})
+			current = &rows[len(rows)-1]
+			for i := 2; i+1 < len(elements); i += 2 {
+				switch elements[i] {
+				case "UP", "EN", "UK":
+					current.UPC = strings.TrimSpace(elements[i+1])
+				case "VP", "VN":
+					current.CatalogNumber = strings.TrimSpace(elements[i+1])
+				}
+			}
+		case "QTY":
+			if current != nil && len(elements) > 2 && (elements[1] == "33" || elements[1] == "17") {
+				current.Quantity = strings.TrimSpace(elements[2])
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no LIN segments found")
+	}
+	return rows, nil
+}
+
+// stockMatches holds what the database knows about the identifiers and albums of a stock file
+type stockMatches struct {
+	byUPC     map[string]string   // Normalized UPC -> album ID
+	byCatalog map[string][]string // lower(catalog number) -> album IDs; more than one is ambiguous
+	quantity  map[string]int      // Album ID -> current stock; albums without a record are absent
+}
+
+// lookupStockMatches loads the albums the rows' identifiers point to and their current stock
+func lookupStockMatches(ctx context.Context, rows []stockRow) (stockMatches, error) {
+	m := stockMatches{byUPC: map[string]string{}, byCatalog: map[string][]string{}, quantity: map[string]int{}}
+	upcs, catalogNumbers := []string{}, []string{}
+	for _, r := range rows {
+		if upc, err := normalizeUPC(r.UPC); err == nil {
+			upcs = append(upcs, upc)
+		}
+		if r.CatalogNumber != "" {
+			catalogNumbers = append(catalogNumbers, strings.ToLower(r.CatalogNumber))
+		}
+	}
+
+	// pgx encodes a []string as text[]
+	idRows, err := db.QueryContext(ctx, `
+		SELECT album_id, COALESCE(upc, ''), COALESCE(lower(catalog_number), '') FROM album_identifiers
+		WHERE upc = ANY($1::text[]) OR lower(catalog_number) = ANY($2::text[])`, upcs, catalogNumbers)
+	if err != nil {
+		return m, err
+	}
+	defer idRows.Close()
+	albumIDs := []string{}
+	for idRows.Next() {
+		var albumID, upc, catalogNumber string
+		if err := idRows.Scan(&albumID, &upc, &catalogNumber); err != nil {
+			return m, err
+		}
+		if upc != "" {
+			m.byUPC[upc] = albumID
+		}
+		if catalogNumber != "" {
+			m.byCatalog[catalogNumber] = append(m.byCatalog[catalogNumber], albumID)
+		}
+		albumIDs = append(albumIDs, albumID)
+	}
+	if err := idRows.Err(); err != nil {
+		return m, err
+	}
+
+	stockRows, err := db.QueryContext(ctx, "SELECT album_id, quantity_available FROM inventory WHERE album_id = ANY($1::text[])", albumIDs)
+	if err != nil {
+		return m, err
+	}
+	defer stockRows.Close()
+	for stockRows.Next() {
+		var albumID string
+		var quantity int
+		if err := stockRows.Scan(&albumID, &quantity); err != nil {
+			return m, err
+		}
+		m.quantity[albumID] = quantity
+	}
+	return m, stockRows.Err()
+}
+
+// buildInventoryImportItems validates and matches the rows of a stock file. A UPC match wins over a
+// catalog number match; an album listed twice in one file only takes its first row.
+func buildInventoryImportItems(rows []stockRow, mode string, m stockMatches) ([]InventoryImportItem, InventoryImportSummary) {
+	var summary InventoryImportSummary
+	seen := make(map[string]int)
+	items := make([]InventoryImportItem, 0, len(rows))
+	for i, r := range rows {
+		item := InventoryImportItem{Row: i + 1, UPC: r.UPC, CatalogNumber: r.CatalogNumber, Status: importStatusUpdate}
+		quantity, quantityErr := strconv.Atoi(r.Quantity)
+		item.Quantity = quantity
+		upc, upcErr := normalizeUPC(r.UPC)
+
+		switch {
+		case r.UPC == "" && r.CatalogNumber == "":
+			item.Status, item.Reason = importStatusInvalid, "missing UPC and catalog number"
+		case r.UPC != "" && upcErr != nil:
+			item.Status, item.Reason = importStatusInvalid, upcErr.Error()
+		case quantityErr != nil:
+			item.Status, item.Reason = importStatusInvalid, fmt.Sprintf("quantity %q is not a whole number", r.Quantity)
+		case mode == importModeSet && quantity < 0:
+			item.Status, item.Reason = importStatusInvalid, "stock level can't be negative"
+		case r.UPC != "" && m.byUPC[upc] != "":
+			item.AlbumID = m.byUPC[upc]
+		case len(m.byCatalog[strings.ToLower(r.CatalogNumber)]) > 1:
+			item.Status, item.Reason = importStatusUnmatched, "catalog number matches several albums"
+		case len(m.byCatalog[strings.ToLower(r.CatalogNumber)]) == 1:
+			item.AlbumID = m.byCatalog[strings.ToLower(r.CatalogNumber)][0]
+		default:
+			item.Status, item.Reason = importStatusUnmatched, "no album with this UPC or catalog number"
+		}
+
+		if item.Status == importStatusUpdate {
+			item.QuantityBefore = m.quantity[item.AlbumID]
+			item.QuantityAfter = quantity
+			if mode == importModeAdd {
+				item.QuantityAfter = item.QuantityBefore + quantity
+			}
+			switch {
+			case seen[item.AlbumID] > 0:
+				item.Status, item.Reason = importStatusInvalid, fmt.Sprintf("same album as row %d", seen[item.AlbumID])
+			case item.QuantityAfter < 0:
+				item.Status, item.Reason = importStatusInvalid, "stock would become negative"
+			case item.QuantityAfter == item.QuantityBefore:
+				item.Status = importStatusUnchanged
+			}
+			if seen[item.AlbumID] == 0 {
+				seen[item.AlbumID] = item.Row
+			}
+		}
+
+		switch item.Status {
+		case importStatusUpdate:
+			summary.Update++
+		case importStatusUnchanged:
+			summary.Unchanged++
+		case importStatusUnmatched:
+			summary.Unmatched++
+		default:
+			summary.Invalid++
+		}
+		items = append(items, item)
+	}
+	return items, summary
+}
+
+// newImportID returns a random, unguessable import ID
+func newImportID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// previewInventoryImport handles POST /api/inventory/import. The body is the stock file: X12 846 with
+// Content-Type application/edi-x12, anything else is read as CSV. ?mode=set (default) treats
+// quantities as stock levels, ?mode=add as units received. For CSV, ?delimiter= sets the separator
+// ("tab" for tabs) and ?upcColumn=, ?catalogNumberColumn= and ?quantityColumn= override the header
+// names of INVENTORY_IMPORT_COLUMNS. Nothing changes until the preview is confirmed.
+func previewInventoryImport(c *gin.Context) {
+	mode := c.DefaultQuery("mode", importModeSet)
+	if mode != importModeSet && mode != importModeAdd {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be set or add"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxInventoryImportBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Stock file too large, at most %d bytes", maxInventoryImportBytes)})
+		return
+	}
+
+	var rows []stockRow
+	if c.ContentType() == x12ContentType {
+		rows, err = parseX12Inventory(body)
+	} else {
+		rows, err = parseStockRequestCSV(c, body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stock file: " + err.Error()})
+		return
+	}
+	if len(rows) > maxInventoryImportRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Stock file has %d rows, at most %d are allowed", len(rows), maxInventoryImportRows)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	matches, err := lookupStockMatches(ctx, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match stock rows: " + err.Error()})
+		return
+	}
+	items, summary := buildInventoryImportItems(rows, mode, matches)
+
+	importID, err := newImportID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import: " + err.Error()})
+		return
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import: " + err.Error()})
+		return
+	}
+	var createdAt time.Time
+	err = db.QueryRowContext(ctx,
+		"INSERT INTO inventory_imports (id, mode, items) VALUES ($1, $2, $3) RETURNING created_at",
+		importID, mode, itemsJSON).Scan(&createdAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store import preview: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, InventoryImportPreview{
+		ImportID:  importID,
+		Mode:      mode,
+		ExpiresAt: createdAt.Add(inventoryImportTTL),
+		Summary:   summary,
+		Items:     items,
+	})
+}
+
+// parseStockRequestCSV parses a CSV stock file with the delimiter and column mapping of the request
+func parseStockRequestCSV(c *gin.Context, body []byte) ([]stockRow, error) {
+	columns, err := loadStockColumns()
+	if err != nil {
+		return nil, err
+	}
+	columns.UPC = c.DefaultQuery("upcColumn", columns.UPC)
+	columns.CatalogNumber = c.DefaultQuery("catalogNumberColumn", columns.CatalogNumber)
+	columns.Quantity = c.DefaultQuery("quantityColumn", columns.Quantity)
+
+	delimiter := ','
+	switch d := c.Query("delimiter"); {
+	case d == "tab":
+		delimiter = '\t'
+	case len(d) == 1:
+		delimiter = rune(d[0])
+	case d != "":
+		return nil, fmt.Errorf("delimiter must be a single character or \"tab\"")
+	}
+	return parseStockCSV(bytes.NewReader(body), columns, delimiter)
+}
+
+var (
+	errImportNotFound  = errors.New("import not found")
+	errImportConfirmed = errors.New("import already confirmed")
+	errImportExpired   = errors.New("import preview expired")
+)
+
+// confirmInventoryImport handles POST /api/inventory/import/:importId/confirm. The preview's update
+// rows are applied in one transaction, each recorded in inventory_adjustments. Stock levels are read
+// again when applying, so orders placed since the preview are not undone in add mode.
+func confirmInventoryImport(c *gin.Context) {
+	importID := c.Param("importId")
+
+	report, err := applyInventoryImport(c.Request.Context(), importID, c.ClientIP())
+	switch {
+	case errors.Is(err, errImportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	case errors.Is(err, errImportConfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Import already confirmed"})
+		return
+	case errors.Is(err, errImportExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Import preview expired, upload the stock file again"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply import: " + err.Error()})
+		return
+	}
+
+	log.Printf("Inventory import %s confirmed: %d applied, %d skipped, +%d/-%d units",
+		importID, report.Applied, report.Skipped, report.UnitsAdded, report.UnitsRemoved)
+	c.JSON(http.StatusOK, report)
+}
+
+// applyInventoryImport applies the update rows of a stored preview and marks it confirmed
+func applyInventoryImport(ctx context.Context, importID, clientIP string) (InventoryImportReport, error) {
+	report := InventoryImportReport{ImportID: importID, Adjustments: []InventoryAdjustment{}}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	var mode string
+	var itemsJSON []byte
+	var createdAt time.Time
+	var confirmedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT mode, items, created_at, confirmed_at FROM inventory_imports WHERE id = $1 FOR UPDATE", importID).
+		Scan(&mode, &itemsJSON, &createdAt, &confirmedAt)
+	if err == sql.ErrNoRows {
+		return report, errImportNotFound
+	}
+	if err != nil {
+		return report, err
+	}
+	if confirmedAt.Valid {
+		return report, errImportConfirmed
+	}
+	if time.Since(createdAt) > inventoryImportTTL {
+		return report, errImportExpired
+	}
+
+	var items []InventoryImportItem
+	if err := json.Unmarshal(itemsJSON, &items); err != nil {
+		return report, fmt.Errorf("decode stored preview: %w", err)
+	}
+
+	for _, item := range items {
+		if item.Status != importStatusUpdate {
+			continue
+		}
+		var before int
+		err := tx.QueryRowContext(ctx, "SELECT quantity_available FROM inventory WHERE album_id = $1 FOR UPDATE", item.AlbumID).Scan(&before)
+		if err != nil && err != sql.ErrNoRows {
+			return report, fmt.Errorf("read stock of row %d: %w", item.Row, err)
+		}
+		after := item.Quantity
+		if mode == importModeAdd {
+			after = before + item.Quantity
+		}
+		if after < 0 || after == before {
+			report.Skipped++
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+			 VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
+			 ON CONFLICT (album_id) DO UPDATE SET quantity_available = $2, last_updated = NOW(),
+			   last_received_at = CASE WHEN $2 > inventory.quantity_available THEN NOW() ELSE inventory.last_received_at END`,
+			item.AlbumID, after)
+		if err != nil {
+			return report, fmt.Errorf("update stock of row %d: %w", item.Row, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO inventory_adjustments (album_id, quantity_before, quantity_after, source, reference, client_ip)
+			 VALUES ($1, $2, $3, 'import', $4, $5)`,
+			item.AlbumID, before, after, importID, clientIP)
+		if err != nil {
+			return report, fmt.Errorf("record adjustment of row %d: %w", item.Row, err)
+		}
+
+		report.Applied++
+		if after > before {
+			report.UnitsAdded += after - before
+		} else {
+			report.UnitsRemoved += before - after
+		}
+		report.Adjustments = append(report.Adjustments, InventoryAdjustment{AlbumID: item.AlbumID, QuantityBefore: before, QuantityAfter: after})
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE inventory_imports SET confirmed_at = NOW() WHERE id = $1", importID); err != nil {
+		return report, err
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	albumIDs := make([]string, len(report.Adjustments))
+	for i, adj := range report.Adjustments {
+		albumIDs[i] = adj.AlbumID
+	}
+	invalidateInventory(ctx, albumIDs...)
+	return report, nil
+}
+
+// getAlbumIdentifiers handles GET /api/inventory/:albumId/identifiers
+func getAlbumIdentifiers(c *gin.Context) {
+	ids := AlbumIdentifiers{AlbumID: c.Param("albumId")}
+	var upc, catalogNumber sql.NullString
+	err := db.QueryRowContext(c.Request.Context(),
+		"SELECT upc, catalog_number, last_updated FROM album_identifiers WHERE album_id = $1", ids.AlbumID).
+		Scan(&upc, &catalogNumber, &ids.LastUpdated)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No identifiers set for album"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	ids.UPC, ids.CatalogNumber = upc.String, catalogNumber.String
+	c.JSON(http.StatusOK, ids)
+}
+
+// updateAlbumIdentifiers handles PUT /api/inventory/:albumId/identifiers. UPCs are unique; catalog
+// numbers may repeat across labels, but rows matching several albums by catalog number are not imported.
+func updateAlbumIdentifiers(c *gin.Context) {
+	ctx := c.Request.Context()
+	albumID := c.Param("albumId")
+
+	var req UpdateAlbumIdentifiersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	ids := AlbumIdentifiers{AlbumID: albumID, CatalogNumber: strings.TrimSpace(req.CatalogNumber), LastUpdated: time.Now()}
+	if req.UPC == "" && ids.CatalogNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set a upc, a catalogNumber or both"})
+		return
+	}
+	if req.UPC != "" {
+		upc, err := normalizeUPC(req.UPC)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ids.UPC = upc
+	}
+
+	// albums is owned by album-service but lives in the shared albumdb
+	var albumExists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM albums WHERE id::text = $1)", albumID).Scan(&albumExists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if !albumExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+
+	if ids.UPC != "" {
+		var owner string
+		err := db.QueryRowContext(ctx, "SELECT album_id FROM album_identifiers WHERE upc = $1 AND album_id <> $2", ids.UPC, albumID).Scan(&owner)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "UPC already belongs to album " + owner})
+			return
+		}
+		if err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+	}
+
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO album_identifiers (album_id, upc, catalog_number, last_updated)
+		 VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4)
+		 ON CONFLICT (album_id) DO UPDATE SET upc = EXCLUDED.upc, catalog_number = EXCLUDED.catalog_number, last_updated = EXCLUDED.last_updated`,
+		albumID, ids.UPC, ids.CatalogNumber, ids.LastUpdated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update identifiers: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ids)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStockCSV(t *testing.T) {
+	file := "\ufeffEAN;Cat No;Stock;Title\n0724384260927;CDP 7 46092 2;12;Kind of Blue\n;SUBPOP 121;3\n"
+	rows, err := parseStockCSV(strings.NewReader(file), stockColumns{UPC: "ean", CatalogNumber: "cat no", Quantity: "STOCK"}, ';')
+	require.NoError(t, err)
+	assert.Equal(t, []stockRow{
+		{UPC: "0724384260927", CatalogNumber: "CDP 7 46092 2", Quantity: "12"},
+		{CatalogNumber: "SUBPOP 121", Quantity: "3"},
+	}, rows)
+
+	_, err = parseStockCSV(strings.NewReader("upc,qty\n123,1\n"), defaultStockColumns, ',')
+	assert.ErrorContains(t, err, `missing the quantity column "quantity"`)
+	_, err = parseStockCSV(strings.NewReader("sku,quantity\nA1,1\n"), defaultStockColumns, ',')
+	assert.ErrorContains(t, err, "neither the UPC column")
+}
+
+func TestLoadStockColumns(t *testing.T) {
+	t.Setenv("INVENTORY_IMPORT_COLUMNS", "upc=EAN, quantity=Stock")
+	columns, err := loadStockColumns()
+	require.NoError(t, err)
+	assert.Equal(t, stockColumns{UPC: "EAN", CatalogNumber: "catalog_number", Quantity: "Stock"}, columns)
+
+	t.Setenv("INVENTORY_IMPORT_COLUMNS", "sku=Item")
+	_, err = loadStockColumns()
+	assert.ErrorContains(t, err, `field "sku"`)
+}
+
+func TestParseX12Inventory(t *testing.T) {
+	isa := "ISA*00*          *00*          *ZZ*SUPPLIER       *ZZ*ALBUMSTORE     *240102*0304*U*00401*000000001*0*P*>~"
+	require.Len(t, isa, 106)
+	file := isa + "\nGS*IB*SUPPLIER*ALBUMSTORE*20240102*0304*1*X*004010~\nST*846*0001~BIA*00*MM*REF1*20240102~" +
+		"LIN*1*UP*074646938720*VP*CK 40587~QTY*33*25~" +
+		"LIN*2*VP*SUBPOP 121~QTY*17*4~" +
+		"LIN*3*EN*5099902894225~" +
+		"SE*9*0001~GE*1*1~IEA*1*000000001~"
+
+	rows, err := parseX12Inventory([]byte(file))
+	require.NoError(t, err)
+	assert.Equal(t, []stockRow{
+		{UPC: "074646938720", CatalogNumber: "CK 40587", Quantity: "25"},
+		{CatalogNumber: "SUBPOP 121", Quantity: "4"},
+		{UPC: "5099902894225"},
+	}, rows)
+
+	_, err = parseX12Inventory([]byte("ST*846*0001~SE*2*0001~"))
+	assert.Error(t, err)
+}
+
+func TestBuildInventoryImportItems(t *testing.T) {
+	matches := stockMatches{
+		byUPC:     map[string]string{"00074646938720": "1", "00724384260927": "2"},
+		byCatalog: map[string][]string{"subpop 121": {"3"}, "abc 1": {"4", "5"}},
+		quantity:  map[string]int{"1": 10, "2": 5, "3": 0},
+	}
+	rows := []stockRow{
+		{UPC: "074646938720", Quantity: "25"}, // UPC-A matching the stored GTIN
+		{UPC: "724384260927", Quantity: "5"},  // Same stock as now
+		{CatalogNumber: "SUBPOP 121", Quantity: "4"},
+		{CatalogNumber: "ABC 1", Quantity: "1"}, // Catalog number of two albums
+		{UPC: "123456789012", Quantity: "1"},
+		{UPC: "12-AB", Quantity: "1"},
+		{CatalogNumber: "SUBPOP 121", Quantity: "x"},
+		{UPC: "074646938720", Quantity: "3"}, // Album listed twice
+		{Quantity: "1"},
+	}
+
+	items, summary := buildInventoryImportItems(rows, importModeSet, matches)
+	assert.Equal(t, InventoryImportSummary{Update: 2, Unchanged: 1, Unmatched: 2, Invalid: 4}, summary)
+	assert.Equal(t, InventoryImportItem{Row: 1, UPC: "074646938720", Quantity: 25, AlbumID: "1", QuantityBefore: 10, QuantityAfter: 25, Status: importStatusUpdate}, items[0])
+	assert.Equal(t, importStatusUnchanged, items[1].Status)
+	assert.Equal(t, "3", items[2].AlbumID)
+	assert.Equal(t, "catalog number matches several albums", items[3].Reason)
+	assert.Equal(t, importStatusUnmatched, items[4].Status)
+	assert.Equal(t, importStatusInvalid, items[5].Status)
+	assert.Equal(t, importStatusInvalid, items[6].Status)
+	assert.Equal(t, "same album as row 1", items[7].Reason)
+	assert.Equal(t, "missing UPC and catalog number", items[8].Reason)
+
+	items, summary = buildInventoryImportItems([]stockRow{{UPC: "074646938720", Quantity: "3"}, {UPC: "724384260927", Quantity: "-6"}}, importModeAdd, matches)
+	assert.Equal(t, 13, items[0].QuantityAfter, "add mode adds to the current stock")
+	assert.Equal(t, "stock would become negative", items[1].Reason)
+	assert.Equal(t, InventoryImportSummary{Update: 1, Invalid: 1}, summary)
+}
+
+// textArrayConverter passes []string arguments through, as pgx encodes them as text[]
+type textArrayConverter struct{}
+
+func (textArrayConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if _, ok := v.([]string); ok {
+		return v, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestInventoryImportHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.ValueConverterOption(textArrayConverter{}))
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Preview", func(t *testing.T) {
+		mock.ExpectQuery("FROM album_identifiers").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "upc", "catalog_number"}).AddRow("1", "00074646938720", ""))
+		mock.ExpectQuery("SELECT album_id, quantity_available FROM inventory").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available"}).AddRow("1", 10))
+		mock.ExpectQuery("INSERT INTO inventory_imports").WithArgs(sqlmock.AnyArg(), importModeAdd, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		rr := send("POST", "/api/inventory/import?mode=add&delimiter=tab&quantityColumn=Received", "text/csv",
+			"upc\tReceived\n074646938720\t6\n999999999999\t1\n")
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var preview InventoryImportPreview
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &preview))
+		assert.Len(t, preview.ImportID, 32)
+		assert.Equal(t, InventoryImportSummary{Update: 1, Unmatched: 1}, preview.Summary)
+		assert.Equal(t, 16, preview.Items[0].QuantityAfter)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Confirm applies audited adjustments", func(t *testing.T) {
+		items, _ := json.Marshal([]InventoryImportItem{
+			{Row: 1, AlbumID: "1", Quantity: 6, QuantityBefore: 10, QuantityAfter: 16, Status: importStatusUpdate},
+			{Row: 2, Status: importStatusUnmatched},
+		})
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM inventory_imports WHERE id").WithArgs("imp1").
+			WillReturnRows(sqlmock.NewRows([]string{"mode", "items", "created_at", "confirmed_at"}).AddRow(importModeAdd, items, time.Now(), nil))
+		// Two units were sold since the preview; they stay sold
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id").WithArgs("1").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(8))
+		mock.ExpectExec("INSERT INTO inventory ").WithArgs("1", 14).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO inventory_adjustments").WithArgs("1", 8, 14, "imp1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE inventory_imports SET confirmed_at").WithArgs("imp1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rr := send("POST", "/api/inventory/import/imp1/confirm", "application/json", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var report InventoryImportReport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		assert.Equal(t, InventoryImportReport{
+			ImportID: "imp1", Applied: 1, UnitsAdded: 6,
+			Adjustments: []InventoryAdjustment{{AlbumID: "1", QuantityBefore: 8, QuantityAfter: 14}},
+		}, report)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Confirm twice", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM inventory_imports WHERE id").WithArgs("imp1").
+			WillReturnRows(sqlmock.NewRows([]string{"mode", "items", "created_at", "confirmed_at"}).AddRow(importModeAdd, []byte("[]"), time.Now(), time.Now()))
+		mock.ExpectRollback()
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/inventory/import/imp1/confirm", "application/json", "").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Set identifiers", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT album_id FROM album_identifiers WHERE upc").WithArgs("00074646938720", "1").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id"}))
+		mock.ExpectExec("INSERT INTO album_identifiers").WithArgs("1", "00074646938720", "CK 40587", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rr := send("PUT", "/api/inventory/1/identifiers", "application/json", `{"upc":"0 74646 93872 0","catalogNumber":"CK 40587"}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"upc":"00074646938720"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UPC of another album", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT album_id FROM album_identifiers WHERE upc").WithArgs("00074646938720", "2").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id"}).AddRow("1"))
+
+		assert.Equal(t, http.StatusConflict, send("PUT", "/api/inventory/2/identifiers", "application/json", `{"upc":"074646938720"}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -5,157 +5,81 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"album-store/events"
+	"album-store/topics"
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// OrderCreatedEvent represents an order creation event from Kafka
-type OrderCreatedEvent struct {
-	OrderID    string    `json:"orderId"`
-	UserID     string    `json:"userId"`
-	AlbumID    string    `json:"albumId"`
-	Quantity   int       `json:"quantity"`
-	Timestamp  time.Time `json:"timestamp"`
-}
-
-// PaymentProcessedEvent represents a payment processed event from Kafka
-type PaymentProcessedEvent struct {
-	OrderID    string    `json:"orderId"`
-	Status     string    `json:"status"`
-	Timestamp  time.Time `json:"timestamp"`
-}
-
-// Order represents an order from the order service
-type Order struct {
-	ID          int64     `json:"id"`
-	UserID      string    `json:"userId"`
-	AlbumID     string    `json:"albumId"`
-	Quantity    int       `json:"quantity"`
-	TotalPrice  float64   `json:"totalPrice"`
-	Status      string    `json:"status"`
-	CreatedAt   string    `json:"createdAt"`
-}
-
-// InventoryUpdatedEvent represents an inventory update event for Kafka
-type InventoryUpdatedEvent struct {
-	AlbumID            string    `json:"albumId"`
-	QuantityAvailable  int       `json:"quantityAvailable"`
-	Timestamp          time.Time `json:"timestamp"`
-}
-
 // Error definitions
 var (
 	errNoInventory           = fmt.Errorf("no inventory record found")
 	errInsufficientInventory = fmt.Errorf("insufficient inventory")
 )
 
-// OrderMessage defines the structure for messages consumed from Kafka
-type OrderMessage struct {
-	OrderID   string `json:"orderId"`
-	AlbumID   string `json:"albumId"`
-	Quantity  int    `json:"quantity"`
-	UserID    string `json:"userId"`
-	Timestamp string `json:"timestamp"`
-}
-
-// AlbumCreatedEvent represents the event consumed when an album is created
-// Ensure this matches the structure produced by album-service
-type AlbumCreatedEvent struct {
-	AlbumID         string    `json:"albumId"`
-	Title           string    `json:"title"` // Optional, but good for logging
-	Artist          string    `json:"artist"` // Optional, but good for logging
-	Timestamp       time.Time `json:"timestamp"`
-	InitialQuantity *int      `json:"initialQuantity,omitempty"` // Mirror definition from album-service
-}
-
-// OrderFailedEvent represents the event published when an order fails due to inventory
-type OrderFailedEvent struct {
-	OrderID   string    `json:"orderId"`
-	Reason    string    `json:"reason"` // e.g., "INSUFFICIENT_STOCK"
-	Timestamp time.Time `json:"timestamp"`
-}
+// Failure reasons published on order-failed events
+const (
+	failureReasonInsufficientInventory = "INSUFFICIENT_INVENTORY"
+	failureReasonPickupOutOfStock      = "PICKUP_LOCATION_OUT_OF_STOCK"
+	failureReasonVelocityLimitExceeded = "VELOCITY_LIMIT_EXCEEDED"
+	failureReasonAlbumRemoved          = "ALBUM_REMOVED"
+)
 
-// OrderSucceededEvent represents the event published when inventory is successfully deducted
-type OrderSucceededEvent struct {
-	OrderID   string    `json:"orderId"`
-	Timestamp time.Time `json:"timestamp"`
-}
+// Outcomes of processing an order-created event, see ordersProcessed
+const (
+	orderOutcomeSucceeded = "succeeded"
+	orderOutcomeFailed    = "failed"  // Rejected with an order-failed event
+	orderOutcomeInvalid   = "invalid" // Unparseable payload, handled by the topic's error policy
+	orderOutcomeError     = "error"   // Failed processing attempt, handled by the topic's error policy
+)
 
-var consumerGroupID = "inventory-service-consumers"
+// localWarehouseID identifies the single stock location tracked by this service (INVENTORY_WAREHOUSE_ID).
+// Stock is not split per warehouse yet, so pickup orders for any other warehouse cannot be served
+// and must fail rather than draw from this location.
+var localWarehouseID = "main"
+
+// Base topic and consumer group names; see topicName / consumerGroupName for the environment-scoped names
+const (
+	orderCreatedTopic = "order-created"
+	albumCreatedTopic   = "album-created"
+	albumDeletedTopic   = "album-deleted"
+	consumerGroupID     = "inventory-service-consumers"
+	albumInitGroupID    = "inventory-service-album-init"
+	albumCleanupGroupID = "inventory-service-album-cleanup"
+)
 
-// startOrderConsumer initializes and runs the Kafka consumer loop for order creation events.
+// startOrderConsumer runs the consumer for order creation events.
 func startOrderConsumer(kafkaBroker string) {
-	orderCreatedTopic := "order-created"
-
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{kafkaBroker},
-		Topic:   orderCreatedTopic,
-		GroupID: consumerGroupID,
-		MinBytes: 10e3,
-		MaxBytes: 10e6,
-	})
-
-	log.Printf("Kafka consumer started for topic '%s', group '%s', broker '%s'", 
-			   reader.Config().Topic, reader.Config().GroupID, kafkaBroker)
-
-	defer reader.Close()
-
-	for {
-		msg, err := reader.ReadMessage(context.Background())
-		if err != nil {
-			log.Printf("Error reading message (%s): %v", orderCreatedTopic, err)
-			continue
-		}
-		
-		if err := processOrderCreated(db, msg); err != nil {
-			log.Printf("Failed to process order created message: %v. Offset: %d", err, msg.Offset)
-		} else {
-			if err := reader.CommitMessages(context.Background(), msg); err != nil {
-				log.Printf("Failed to commit message offset %d (%s): %v", msg.Offset, orderCreatedTopic, err)
-			} else {
-				log.Printf("Successfully committed message for offset %d (%s)", msg.Offset, orderCreatedTopic)
+	topic := topics.Name(versionedTopic(orderCreatedTopic, eventConsumeVersion))
+	consumer := newEventConsumer(kafkaBroker, orderCreatedTopic, topic, topics.GroupName(consumerGroupID), byEventType(orderCreatedTopic, map[string]func(kafka.Message) error{
+		events.TypeOrderCreated: func(msg kafka.Message) error {
+			err := processOrderCreated(db, msg)
+			if err != nil && !errors.Is(err, errMalformedEvent) {
+				ordersProcessed.WithLabelValues(orderOutcomeError, "").Inc()
 			}
-		}
-	}
+			return err
+		},
+	}))
+	consumer.run(kafkaBroker, nil)
 }
 
-// startAlbumCreatedConsumer initializes and runs the Kafka consumer loop for album creation events.
+// startAlbumCreatedConsumer runs the consumer for album creation events.
 func startAlbumCreatedConsumer(kafkaBroker string) {
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{kafkaBroker},
-		Topic:   "album-created",
-		GroupID: "inventory-service-album-init",
-		MinBytes: 10e3,
-		MaxBytes: 10e6,
-	})
-
-	log.Printf("Kafka consumer started for topic '%s', group '%s', broker '%s'", reader.Config().Topic, reader.Config().GroupID, kafkaBroker)
-
-	defer reader.Close()
-
-	for {
-		msg, err := reader.ReadMessage(context.Background())
-		if err != nil {
-			log.Printf("Error reading message (album-created): %v", err)
-			continue
-		}
-		
-		if err := processAlbumCreatedEvent(db, msg); err != nil {
-			log.Printf("Failed to process album created message: %v. Offset: %d", err, msg.Offset)
-		} else {
-			if err := reader.CommitMessages(context.Background(), msg); err != nil {
-				log.Printf("Failed to commit message offset %d (album-created): %v", msg.Offset, err)
-			} else {
-				log.Printf("Successfully committed message for offset %d (album-created)", msg.Offset)
-			}
-		}
-	}
+	topic := topics.Name(versionedTopic(albumCreatedTopic, eventConsumeVersion))
+	consumer := newEventConsumer(kafkaBroker, albumCreatedTopic, topic, topics.GroupName(albumInitGroupID), byEventType(albumCreatedTopic, map[string]func(kafka.Message) error{
+		events.TypeAlbumCreated: func(msg kafka.Message) error { return processAlbumCreatedEvent(db, msg) },
+	}))
+	consumer.run(kafkaBroker, newAlbumCreatedBacklogFromEnv(topic).observe)
 }
 
 // processAlbumCreatedEvent handles initializing inventory for a newly created album.
@@ -171,13 +95,13 @@ func processAlbumCreatedEvent(db *sql.DB, msg kafka.Message) error {
 	span.SetAttributes(
 		attribute.Int("kafka.partition", msg.Partition),
 		attribute.Int64("kafka.offset", msg.Offset),
-		attribute.String("kafka.topic", "album-created"),
+		attribute.String("kafka.topic", msg.Topic),
 	)
 
 	// Parse album creation message
-	var event AlbumCreatedEvent
-	if err := json.Unmarshal(msg.Value, &event); err != nil {
-		log.Printf("Error parsing AlbumCreatedEvent JSON: %v. Message: %s", err, string(msg.Value))
+	var event events.AlbumCreated
+	if err := decodeAlbumEvent(ctx, msg, albumCreatedTopic, &event); err != nil {
+		log.Printf("Error parsing AlbumCreatedEvent: %v. Message: %q", err, msg.Value)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to parse album created event")
 		return fmt.Errorf("failed to parse AlbumCreatedEvent: %w", err)
@@ -185,19 +109,19 @@ func processAlbumCreatedEvent(db *sql.DB, msg kafka.Message) error {
 
 	// Log album details
 	log.Printf("Processing album: AlbumID=%s, Title='%s', InitialQty=%v", 
-		event.AlbumID, event.Title, event.InitialQuantity)
+		event.AlbumId, event.Title, event.InitialQuantity)
 	span.SetAttributes(
-		attribute.String("album.id", event.AlbumID),
+		attribute.String("album.id", event.AlbumId),
 		attribute.String("album.title", event.Title),
 	)
 	if event.InitialQuantity != nil {
-		span.SetAttributes(attribute.Int("album.initial_quantity", *event.InitialQuantity))
+		span.SetAttributes(attribute.Int("album.initial_quantity", int(event.GetInitialQuantity())))
 	}
 
 	// Determine initial inventory quantity
 	quantityToInsert := 0 // default quantity
-	if event.InitialQuantity != nil && *event.InitialQuantity >= 0 {
-		quantityToInsert = *event.InitialQuantity
+	if event.InitialQuantity != nil && event.GetInitialQuantity() >= 0 {
+		quantityToInsert = int(event.GetInitialQuantity())
 		log.Printf("Using initial quantity from event: %d", quantityToInsert)
 	} else {
 		log.Printf("Initial quantity not provided or invalid, defaulting to 0")
@@ -208,10 +132,10 @@ func processAlbumCreatedEvent(db *sql.DB, msg kafka.Message) error {
 	
 	// Insert initial inventory record
 	_, err := db.ExecContext(ctx, `
-		INSERT INTO inventory (album_id, quantity_available, last_updated)
-		VALUES ($1, $2, NOW())
+		INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+		VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
 		ON CONFLICT (album_id) DO NOTHING`,
-		event.AlbumID, quantityToInsert)
+		event.AlbumId, quantityToInsert)
 	
 	if err != nil {
 		log.Printf("Error inserting inventory: %v", err)
@@ -223,7 +147,7 @@ func processAlbumCreatedEvent(db *sql.DB, msg kafka.Message) error {
 	}
 	
 	dbSpan.End()
-	log.Printf("Initialized inventory for AlbumID %s with quantity %d", event.AlbumID, quantityToInsert)
+	log.Printf("Initialized inventory for AlbumID %s with quantity %d", event.AlbumId, quantityToInsert)
 	span.SetStatus(codes.Ok, "Inventory initialized successfully")
 	return nil
 }
@@ -235,6 +159,7 @@ func processOrderCreated(db *sql.DB, msg kafka.Message) error {
 
 	// Extract trace context and start a new span
 	ctx := ExtractTraceInfoFromKafkaMessage(context.Background(), msg.Headers)
+	ctx = withOrderOrigin(ctx, ctx) // Outcome events link back to the order's trace
 	ctx, span := tracer.Start(ctx, "processOrderCreated")
 	defer span.End()
 	
@@ -242,111 +167,206 @@ func processOrderCreated(db *sql.DB, msg kafka.Message) error {
 	span.SetAttributes(
 		attribute.Int("kafka.partition", msg.Partition),
 		attribute.Int64("kafka.offset", msg.Offset),
-		attribute.String("kafka.topic", "order-created"),
+		attribute.String("kafka.topic", msg.Topic),
 	)
 	
-	// Parse order message
-	var event OrderMessage
-	if err := json.Unmarshal(msg.Value, &event); err != nil {
+	// Parse order message (v1 or v2 envelope)
+	event := &events.OrderCreated{}
+	if _, err := decodeEvent(msg, orderCreatedTopic, event); err != nil {
 		log.Printf("Error parsing OrderCreatedEvent JSON: %v. Message: %s", err, string(msg.Value))
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to parse order message")
-		return nil // For unparseable messages, still commit the offset
+		ordersProcessed.WithLabelValues(orderOutcomeInvalid, "").Inc()
+		return fmt.Errorf("%w: failed to parse order message: %v", errMalformedEvent, err)
 	}
 
 	// Log order details
 	log.Printf("Processing order: OrderID=%s, AlbumID=%s, Quantity=%d", 
-		event.OrderID, event.AlbumID, event.Quantity)
+		event.OrderId, event.AlbumId, event.Quantity)
 	span.SetAttributes(
-		attribute.String("order.id", event.OrderID),
-		attribute.String("album.id", event.AlbumID),
-		attribute.Int("order.quantity", event.Quantity),
-		attribute.String("user.id", event.UserID),
+		attribute.String("order.id", event.OrderId),
+		attribute.String("album.id", event.AlbumId),
+		attribute.Int("order.quantity", int(event.Quantity)),
+		attribute.String("user.id", event.UserId),
 	)
 
+	// A redelivered order resumes from its saga log instead of being processed again
+	steps, err := loadSagaSteps(ctx, db, event.OrderId)
+	if err != nil {
+		log.Printf("Error loading saga log: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Saga log lookup failed")
+		return fmt.Errorf("failed to load saga log: %w", err)
+	}
+	if len(steps) > 0 {
+		span.SetAttributes(attribute.String("order.saga_status", sagaStatus(steps)))
+		return resumeOrderSaga(ctx, db, event.OrderId, steps)
+	}
+
+	// Pickup orders may only draw from the requested warehouse
+	if event.PickupWarehouseId != "" {
+		span.SetAttributes(attribute.String("order.pickup_warehouse_id", event.PickupWarehouseId))
+		if event.PickupWarehouseId != localWarehouseID {
+			log.Printf("Pickup warehouse %s holds no stock tracked here (local warehouse: %s)", event.PickupWarehouseId, localWarehouseID)
+			if err := failOrder(ctx, db, event, failureReasonPickupOutOfStock); err != nil {
+				log.Printf("Failed to send failure event: %v", err)
+				span.RecordError(err)
+			}
+			span.SetStatus(codes.Ok, "Order processed - pickup location out of stock")
+			return nil
+		}
+	}
+
 	// Try deducting inventory
 	// Use transaction to ensure atomic operation
 	ctx, dbSpan := tracer.Start(ctx, "db.update_inventory")
+	deductionStart := time.Now()
+	endDeduction := func(result string) {
+		dbSpan.End()
+		observeDeduction(ctx, result, event.AlbumId, time.Since(deductionStart))
+	}
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
 		dbSpan.RecordError(err)
 		span.RecordError(err)
-		dbSpan.End()
+		endDeduction(deductionResultError)
 		span.SetStatus(codes.Error, "Database transaction error")
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback() // Ensure rollback of uncommitted transaction on function exit
 
-	// Perform atomic update; only succeeds if sufficient inventory exists
-	result, err := tx.ExecContext(ctx,
-		`UPDATE inventory
-		 SET quantity_available = quantity_available - $1
-		 WHERE album_id = $2 AND quantity_available >= $1`,
-		event.Quantity, event.AlbumID)
-
+	// Enforce per-album / per-user velocity caps before touching stock
+	velocityLimit, violatedScope, err := checkVelocityLimit(ctx, tx, event)
 	if err != nil {
-		log.Printf("Error updating inventory: %v", err)
+		log.Printf("Error checking velocity limit: %v", err)
 		dbSpan.RecordError(err)
-		dbSpan.End()
+		endDeduction(deductionResultError)
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "Database update failed")
-		return fmt.Errorf("database update error: %w", err)
+		span.SetStatus(codes.Error, "Velocity limit check failed")
+		return err
+	}
+	if violatedScope != "" {
+		endDeduction(deductionResultRejected)
+		log.Printf("Velocity limit (%s) exceeded: AlbumID=%s, UserID=%s, Quantity=%d",
+			violatedScope, event.AlbumId, event.UserId, event.Quantity)
+		velocityLimitViolations.WithLabelValues(violatedScope).Inc()
+		span.SetAttributes(attribute.String("order.velocity_limit_scope", violatedScope))
+		tx.Rollback()
+		if err := failOrder(ctx, db, event, failureReasonVelocityLimitExceeded); err != nil {
+			log.Printf("Failed to send failure event: %v", err)
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Ok, "Order processed - velocity limit exceeded")
+		return nil
 	}
 
-	// Check if any rows were updated
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
+	// Perform atomic update; only succeeds if sufficient inventory exists. The remaining stock and
+	// the threshold tell whether the order took the album low on stock (see low_stock.go).
+	var remaining int
+	var lowStockThreshold *int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE inventory
+		 SET quantity_available = quantity_available - $1, last_sold_at = NOW()
+		 WHERE album_id = $2 AND quantity_available >= $1
+		 RETURNING quantity_available, low_stock_threshold`,
+		event.Quantity, event.AlbumId).Scan(&remaining, &lowStockThreshold)
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error updating inventory: %v", err)
 		dbSpan.RecordError(err)
-		dbSpan.End()
+		endDeduction(deductionResultError)
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to get result info")
-		return fmt.Errorf("database result error: %w", err)
+		span.SetStatus(codes.Error, "Database update failed")
+		return fmt.Errorf("database update error: %w", err)
 	}
 	
-	// If rows were updated, inventory deduction succeeded
-	if rowsAffected == 1 {
+	// If a row was updated, inventory deduction succeeded
+	if err == nil {
+		// Count the order against the album's velocity limit in the same transaction
+		if velocityLimit != nil {
+			if err := recordOrderVelocity(ctx, tx, event, velocityLimit); err != nil {
+				log.Printf("Error recording order velocity: %v", err)
+				dbSpan.RecordError(err)
+				endDeduction(deductionResultError)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Velocity tracking failed")
+				return err
+			}
+		}
+
+		// The saga step commits with the deduction, so a redelivered order can never deduct twice
+		orderJSON, err := events.MarshalJSON(event)
+		if err == nil {
+			var recorded bool
+			if recorded, err = recordSagaStep(ctx, tx, event.OrderId, sagaStepDeducted, string(orderJSON)); err == nil && !recorded {
+				err = fmt.Errorf("order %s was deducted concurrently", event.OrderId)
+			}
+		}
+		if err != nil {
+			log.Printf("Error recording saga step: %v", err)
+			dbSpan.RecordError(err)
+			endDeduction(deductionResultError)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Saga log write failed")
+			return fmt.Errorf("saga log error: %w", err)
+		}
+
 		// Commit transaction
 		if err := tx.Commit(); err != nil {
 			log.Printf("Error committing transaction: %v", err)
 			dbSpan.RecordError(err)
-			dbSpan.End()
+			endDeduction(deductionResultError)
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "Transaction commit failed")
 			return fmt.Errorf("transaction commit error: %w", err)
 		}
+		invalidateInventory(ctx, event.AlbumId)
 		
 		dbSpan.SetStatus(codes.Ok, "Inventory updated successfully")
-		dbSpan.End()
+		endDeduction(deductionResultDeducted)
 		
 		// Send order success event
 		log.Printf("Inventory deducted successfully, sending success event")
-		_, pubSpan := tracer.Start(ctx, "send_success_event")
-		err = sendOrderSucceededEvent(event.OrderID)
+		pubCtx, pubSpan := tracer.Start(ctx, "send_success_event")
+		err = sendOrderSucceededEvent(pubCtx, event)
+		if err == nil {
+			_, err = recordSagaStep(ctx, db, event.OrderId, sagaStepSucceededPublished, "")
+		}
 		if err != nil {
+			// The saga stays at "deducted" and saga recovery publishes the event later
 			log.Printf("Failed to send success event: %v", err)
 			pubSpan.RecordError(err)
 		}
 		pubSpan.End()
+
+		if crossedLowStock(remaining+int(event.Quantity), remaining, lowStockThreshold) {
+			alertLowStock(ctx, event, remaining, *lowStockThreshold)
+		}
 		
 		span.SetStatus(codes.Ok, "Order processed successfully")
 		return nil
 	}
 	
 	// Insufficient inventory, order failed
-	dbSpan.End()
+	endDeduction(deductionResultRejected)
+	tx.Rollback()
 	
 	// Query current inventory for more detailed error information
 	var currentQty int
+	removed := false
 	err = db.QueryRowContext(ctx, 
 		"SELECT quantity_available FROM inventory WHERE album_id = $1", 
-		event.AlbumID).Scan(&currentQty)
+		event.AlbumId).Scan(&currentQty)
 	
 				if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("No inventory record found for AlbumID: %s", event.AlbumID)
+			log.Printf("No inventory record found for AlbumID: %s", event.AlbumId)
 			span.SetAttributes(attribute.Bool("inventory.exists", false))
+			if removed, err = albumRemoved(ctx, db, event.AlbumId); err != nil {
+				log.Printf("Error checking archived inventory: %v", err)
+				span.RecordError(err)
+			}
 				} else {
 			log.Printf("Error querying inventory: %v", err)
 			span.RecordError(err)
@@ -361,7 +381,13 @@ func processOrderCreated(db *sql.DB, msg kafka.Message) error {
 	}
 	
 	// Send order failure event and record tracking information
-	err = sendOrderFailedEvent(event.OrderID, "INSUFFICIENT_INVENTORY")
+	reason := failureReasonInsufficientInventory
+	if removed {
+		reason = failureReasonAlbumRemoved
+	} else if event.PickupWarehouseId != "" {
+		reason = failureReasonPickupOutOfStock
+	}
+	err = failOrder(ctx, db, event, reason)
 	if err != nil {
 		log.Printf("Failed to send failure event: %v", err)
 		span.RecordError(err)
@@ -372,62 +398,102 @@ func processOrderCreated(db *sql.DB, msg kafka.Message) error {
 }
 
 // sendOrderFailedEvent publishes an event to the order-failed topic
-func sendOrderFailedEvent(orderID string, reason string) error {
-	return sendOrderEvent(orderID, reason, orderFailedTopic, kafkaFailedEventWriter)
+func sendOrderFailedEvent(ctx context.Context, order *events.OrderCreated, reason string) error {
+	countOrder(ctx, orderOutcomeFailed, reason, order.AlbumId)
+	return sendOrderEvent(ctx, &events.OrderCreated{OrderId: order.OrderId}, reason, orderFailedTopic)
 }
 
-// sendOrderSucceededEvent publishes an event to the order-succeeded topic
-func sendOrderSucceededEvent(orderID string) error {
-	return sendOrderEvent(orderID, "", orderSucceededTopic, kafkaSucceededEventWriter)
+// sendOrderSucceededEvent publishes an event to the order-succeeded topic, carrying the deducted
+// line and the order metadata for fulfillment and sales reporting
+func sendOrderSucceededEvent(ctx context.Context, order *events.OrderCreated) error {
+	countOrder(ctx, orderOutcomeSucceeded, "", order.AlbumId)
+	return sendOrderEvent(ctx, order, "", orderSucceededTopic)
 }
 
-// sendOrderEvent handles sending events to Kafka with unified tracing logic
-func sendOrderEvent(orderID string, reason string, topic string, writer *kafka.Writer) error {
-	// Create a new context, not using tracing
-	ctx := context.Background()
-	
-	var event []byte
-	var err error
-	
+// sendOrderEvent publishes the event in every configured schema version, each to its own topic. The
+// publish span is linked to the order-created trace (see orderOriginLinks), and its context travels
+// in the message headers so consumers continue the trace.
+func sendOrderEvent(ctx context.Context, order *events.OrderCreated, reason string, topic string) (err error) {
+	orderID := order.OrderId
+
+	ctx, span := tracer.Start(ctx, "kafka.publish_"+strings.ReplaceAll(topic, "-", "_"),
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithLinks(orderOriginLinks(ctx)...))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to publish "+topic)
+		}
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("messaging.destination.name", topics.Name(topic)),
+		attribute.String("order.id", orderID),
+	)
+	headers := InjectTraceInfoToKafkaMessage(ctx)
+
 	// Build event based on topic type
+	var payload proto.Message
+	var eventType string
 	if topic == orderFailedTopic {
-		failEvent := OrderFailedEvent{
-			OrderID:   orderID,
+		payload = &events.OrderFailed{
+			OrderId:   orderID,
 			Reason:    reason,
-			Timestamp: time.Now(),
+			Timestamp: timestamppb.Now(),
 		}
-		event, err = json.Marshal(failEvent)
+		eventType = events.TypeOrderFailed
 	} else if topic == orderSucceededTopic {
-		succEvent := OrderSucceededEvent{
-			OrderID:   orderID,
-			Timestamp: time.Now(),
+		payload = &events.OrderSucceeded{
+			OrderId:         orderID,
+			AlbumId:         order.AlbumId,
+			Quantity:        order.Quantity,
+			Timestamp:       timestamppb.Now(),
+			Metadata:        order.Metadata,
+			Price:           order.Price,
+			RecipientUserId: order.RecipientUserId,
 		}
-		event, err = json.Marshal(succEvent)
+		eventType = events.TypeOrderSucceeded
 	} else {
 		return fmt.Errorf("unknown topic: %s", topic)
 	}
-	
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
+
+	// Derived from the order, so an event published again when a saga resumes keeps its ID
+	eventID := events.StableEventID(eventType + ":" + orderID)
+
+	// Attempt every version even if one fails, so one missing topic doesn't starve the others
+	var errs []error
+	for _, version := range eventPublishVersions {
+		writer := orderEventWriter(topic, version)
+		if writer == nil {
+			errs = append(errs, fmt.Errorf("no writer for %s schema v%d", topic, version))
+			continue
+		}
+		value, err := encodeEvent(version, eventType, eventID, payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		// Send message to Kafka, queueing it for retry if that fails (see publish_retry.go)
+		msg := kafka.Message{Key: []byte(orderID), Value: value, Headers: headers}
+		if err := writer.WriteMessages(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("schema v%d: %w", version, err))
+			publishRetries.enqueue(versionedTopic(topic, version), msg)
+		}
 	}
-	
-	// Send message to Kafka
-	return writer.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(orderID),
-		Value: event,
-	})
+	return errors.Join(errs...)
 }
 
-// initProcessedOrdersTable creates the table to track processed orders if it doesn't exist.
-func initProcessedOrdersTable() {
-	_, err := db.Exec(`
-	CREATE TABLE IF NOT EXISTS processed_orders (
-		order_id VARCHAR(255) PRIMARY KEY,
-		processed_at TIMESTAMP NOT NULL DEFAULT NOW()
-	)`)
-	if err != nil {
-		log.Fatalf("Could not create processed_orders table: %v", err)
+// orderEventWriter returns the writer for a topic's schema version, or nil if it isn't published
+func orderEventWriter(topic string, version int) messageWriter {
+	switch {
+	case topic == orderFailedTopic && version == eventSchemaV1:
+		return kafkaFailedEventWriter
+	case topic == orderFailedTopic && version == eventSchemaV2:
+		return kafkaFailedEventWriterV2
+	case topic == orderSucceededTopic && version == eventSchemaV1:
+		return kafkaSucceededEventWriter
+	case topic == orderSucceededTopic && version == eventSchemaV2:
+		return kafkaSucceededEventWriterV2
 	}
+	return nil
 }
 
 // reserveInventory reserves inventory for an order
@@ -446,12 +512,13 @@ func reserveInventory(albumID string, quantity int) error {
 	}
 
 	_, err = db.Exec(
-		"UPDATE inventory SET quantity_available = quantity_available - $1, last_updated = $2 WHERE album_id = $3",
+		"UPDATE inventory SET quantity_available = quantity_available - $1, last_updated = $2, last_sold_at = $2 WHERE album_id = $3",
 		quantity, time.Now(), albumID,
 	)
 	if err != nil {
 		return err
 	}
+	invalidateInventory(context.Background(), albumID)
 
 	var newQuantity int
 	err = db.QueryRow("SELECT quantity_available FROM inventory WHERE album_id = $1", albumID).Scan(&newQuantity)
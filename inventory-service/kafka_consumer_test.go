@@ -1,14 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"testing"
-	"time"
 
+	"album-store/events"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // TestProcessAlbumCreatedEvent tests the logic for handling AlbumCreatedEvents.
@@ -21,23 +26,23 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 
 	// Test case 1: Success - New album, inventory initialized with quantity
 	t.Run("Success - New album, inventory initialized with quantity", func(t *testing.T) {
-		initialQty := 10
-		event := AlbumCreatedEvent{
-			AlbumID:         "album-123",
+		initialQty := int32(10)
+		event := &events.AlbumCreated{
+			AlbumId:         "album-123",
 			Title:           "Test Album",
 			Artist:          "Test Artist",
-			Timestamp:       time.Now(),
+			Timestamp:       timestamppb.Now(),
 			InitialQuantity: &initialQty,
 		}
-		eventBytes, _ := json.Marshal(event)
+		eventBytes, _ := events.MarshalJSON(event)
 		testMsg := kafka.Message{Value: eventBytes}
 
 		expectedSQL := `
-        INSERT INTO inventory (album_id, quantity_available, last_updated)
-        VALUES ($1, $2, NOW())
+        INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+        VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
         ON CONFLICT (album_id) DO NOTHING`
 		mock.ExpectExec(expectedSQL).
-			WithArgs(event.AlbumID, initialQty).
+			WithArgs(event.AlbumId, initialQty).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err := processAlbumCreatedEvent(mockDB, testMsg)
@@ -47,22 +52,22 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 
 	// Test case 2: Success - Album already exists, no action taken (ON CONFLICT DO NOTHING)
 	t.Run("Success - Album already exists, no action taken", func(t *testing.T) {
-		event := AlbumCreatedEvent{
-			AlbumID:         "album-456",
+		event := &events.AlbumCreated{
+			AlbumId:         "album-456",
 			Title:           "Existing Album",
 			Artist:          "Existing Artist",
-			Timestamp:       time.Now(),
+			Timestamp:       timestamppb.Now(),
 			InitialQuantity: nil,
 		}
-		eventBytes, _ := json.Marshal(event)
+		eventBytes, _ := events.MarshalJSON(event)
 		testMsg := kafka.Message{Value: eventBytes}
 
 		expectedSQL := `
-        INSERT INTO inventory (album_id, quantity_available, last_updated)
-        VALUES ($1, $2, NOW())
+        INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+        VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
         ON CONFLICT (album_id) DO NOTHING`
 		mock.ExpectExec(expectedSQL).
-			WithArgs(event.AlbumID, 0).
+			WithArgs(event.AlbumId, 0).
 			WillReturnResult(sqlmock.NewResult(0, 0))
 
 		err := processAlbumCreatedEvent(mockDB, testMsg)
@@ -72,24 +77,24 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 
 	// Test case 3: Error - Database execution error
 	t.Run("Error - Database execution error", func(t *testing.T) {
-		initialQty := 5
-		event := AlbumCreatedEvent{
-			AlbumID:         "album-789",
+		initialQty := int32(5)
+		event := &events.AlbumCreated{
+			AlbumId:         "album-789",
 			Title:           "DB Error Album",
 			Artist:          "DB Error Artist",
-			Timestamp:       time.Now(),
+			Timestamp:       timestamppb.Now(),
 			InitialQuantity: &initialQty,
 		}
-		eventBytes, _ := json.Marshal(event)
+		eventBytes, _ := events.MarshalJSON(event)
 		testMsg := kafka.Message{Value: eventBytes}
 
 		expectedSQL := `
-        INSERT INTO inventory (album_id, quantity_available, last_updated)
-        VALUES ($1, $2, NOW())
+        INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+        VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
         ON CONFLICT (album_id) DO NOTHING`
 		dbError := fmt.Errorf("mock db connection error")
 		mock.ExpectExec(expectedSQL).
-			WithArgs(event.AlbumID, initialQty).
+			WithArgs(event.AlbumId, initialQty).
 			WillReturnError(dbError)
 
 		err := processAlbumCreatedEvent(mockDB, testMsg)
@@ -106,27 +111,28 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 		err := processAlbumCreatedEvent(mockDB, badMsg)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to parse AlbumCreatedEvent")
+		assert.ErrorIs(t, err, errMalformedEvent)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("Success - Initial quantity is zero", func(t *testing.T) {
-		initialQty := 0
-		event := AlbumCreatedEvent{
-			AlbumID:         "album-zero",
+		initialQty := int32(0)
+		event := &events.AlbumCreated{
+			AlbumId:         "album-zero",
 			Title:           "Zero Qty Album",
 			Artist:          "Zero Artist",
-			Timestamp:       time.Now(),
+			Timestamp:       timestamppb.Now(),
 			InitialQuantity: &initialQty,
 		}
-		eventBytes, _ := json.Marshal(event)
+		eventBytes, _ := events.MarshalJSON(event)
 		testMsg := kafka.Message{Value: eventBytes}
 
 		expectedSQL := `
-        INSERT INTO inventory (album_id, quantity_available, last_updated)
-        VALUES ($1, $2, NOW())
+        INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+        VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
         ON CONFLICT (album_id) DO NOTHING`
 		mock.ExpectExec(expectedSQL).
-			WithArgs(event.AlbumID, 0).
+			WithArgs(event.AlbumId, 0).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err := processAlbumCreatedEvent(mockDB, testMsg)
@@ -135,23 +141,23 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 	})
 
 	t.Run("Success - Initial quantity is negative defaults to zero", func(t *testing.T) {
-		initialQty := -10
-		event := AlbumCreatedEvent{
-			AlbumID:         "album-negative",
+		initialQty := int32(-10)
+		event := &events.AlbumCreated{
+			AlbumId:         "album-negative",
 			Title:           "Negative Qty Album",
 			Artist:          "Negative Artist",
-			Timestamp:       time.Now(),
+			Timestamp:       timestamppb.Now(),
 			InitialQuantity: &initialQty,
 		}
-		eventBytes, _ := json.Marshal(event)
+		eventBytes, _ := events.MarshalJSON(event)
 		testMsg := kafka.Message{Value: eventBytes}
 
 		expectedSQL := `
-        INSERT INTO inventory (album_id, quantity_available, last_updated)
-        VALUES ($1, $2, NOW())
+        INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+        VALUES ($1, $2, NOW(), CASE WHEN $2 > 0 THEN NOW() END)
         ON CONFLICT (album_id) DO NOTHING`
 		mock.ExpectExec(expectedSQL).
-			WithArgs(event.AlbumID, 0).
+			WithArgs(event.AlbumId, 0).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err := processAlbumCreatedEvent(mockDB, testMsg)
@@ -160,5 +166,283 @@ func TestProcessAlbumCreatedEvent(t *testing.T) {
 	})
 }
 
+// recordingWriter captures published messages instead of sending them to a broker
+type recordingWriter struct {
+	messages []kafka.Message
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *recordingWriter) Close() error { return nil }
+
+// useRecordingWriters swaps the order event writers for recorders until the test ends
+func useRecordingWriters(t *testing.T) (failed, succeeded *recordingWriter) {
+	failed, succeeded = &recordingWriter{}, &recordingWriter{}
+	prevFailed, prevSucceeded, prevVersions := kafkaFailedEventWriter, kafkaSucceededEventWriter, eventPublishVersions
+	// Only v1 is published, so the recorded messages are the flat payloads
+	kafkaFailedEventWriter, kafkaSucceededEventWriter, eventPublishVersions = failed, succeeded, []int{eventSchemaV1}
+	t.Cleanup(func() {
+		kafkaFailedEventWriter, kafkaSucceededEventWriter, eventPublishVersions = prevFailed, prevSucceeded, prevVersions
+	})
+	return failed, succeeded
+}
+
+// orderMessage builds an order-created Kafka message for tests
+func orderMessage(t *testing.T, event *events.OrderCreated) kafka.Message {
+	value, err := events.MarshalJSON(event)
+	if err != nil {
+		t.Fatalf("failed to marshal order message: %v", err)
+	}
+	return kafka.Message{Value: value}
+}
+
+// failedReason decodes the reason from a published order-failed message
+func failedReason(t *testing.T, msg kafka.Message) string {
+	var event events.OrderFailed
+	if err := events.UnmarshalJSON(msg.Value, &event); err != nil {
+		t.Fatalf("failed to unmarshal order-failed event: %v", err)
+	}
+	return event.Reason
+}
+
+// deductionColumns are returned by the stock deduction of an order
+var deductionColumns = []string{"quantity_available", "low_stock_threshold"}
+
+// expectNoVelocityLimit expects the velocity limit lookup for albumID to find nothing
+func expectNoVelocityLimit(mock sqlmock.Sqlmock, albumID string) {
+	mock.ExpectQuery("SELECT max_units_per_user, max_units_total, window_seconds FROM album_velocity_limits").
+		WithArgs(albumID).
+		WillReturnRows(sqlmock.NewRows([]string{"max_units_per_user", "max_units_total", "window_seconds"}))
+}
+
+// expectNoSaga expects the saga log lookup for orderID to find no recorded steps
+func expectNoSaga(mock sqlmock.Sqlmock, orderID string) {
+	mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").
+		WithArgs(orderID).
+		WillReturnRows(sqlmock.NewRows([]string{"step", "detail", "recorded_at"}))
+}
+
+// expectSagaStep expects step to be recorded for orderID
+func expectSagaStep(mock sqlmock.Sqlmock, orderID, step string) {
+	mock.ExpectExec("INSERT INTO saga_log").
+		WithArgs(orderID, step, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// TestProcessOrderCreated_PickupWarehouse tests that pickup orders only draw from the requested warehouse.
+func TestProcessOrderCreated_PickupWarehouse(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	t.Run("Pickup at another warehouse fails without touching stock", func(t *testing.T) {
+		failed, succeeded := useRecordingWriters(t)
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "101", AlbumId: "album-1", Quantity: 1, PickupWarehouseId: "north"})
+
+		expectNoSaga(mock, "101")
+		expectSagaStep(mock, "101", sagaStepFailed)
+		expectSagaStep(mock, "101", sagaStepFailedPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet(), "no stock should be read or deducted")
+		assert.Len(t, succeeded.messages, 0)
+		if assert.Len(t, failed.messages, 1) {
+			assert.Equal(t, failureReasonPickupOutOfStock, failedReason(t, failed.messages[0]))
+		}
+	})
+
+	t.Run("Pickup at local warehouse with insufficient stock uses pickup reason", func(t *testing.T) {
+		failed, _ := useRecordingWriters(t)
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "102", AlbumId: "album-1", Quantity: 5, PickupWarehouseId: localWarehouseID})
+
+		expectNoSaga(mock, "102")
+		mock.ExpectBegin()
+		expectNoVelocityLimit(mock, "album-1")
+		mock.ExpectQuery("UPDATE inventory").WithArgs(5, "album-1").WillReturnRows(sqlmock.NewRows(deductionColumns))
+		mock.ExpectRollback()
+		mock.ExpectQuery("SELECT quantity_available FROM inventory").WithArgs("album-1").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(2))
+		expectSagaStep(mock, "102", sagaStepFailed)
+		expectSagaStep(mock, "102", sagaStepFailedPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		if assert.Len(t, failed.messages, 1) {
+			assert.Equal(t, failureReasonPickupOutOfStock, failedReason(t, failed.messages[0]))
+		}
+	})
+
+	t.Run("Order without pickup keeps the generic reason", func(t *testing.T) {
+		failed, _ := useRecordingWriters(t)
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "103", AlbumId: "album-1", Quantity: 5})
+
+		expectNoSaga(mock, "103")
+		mock.ExpectBegin()
+		expectNoVelocityLimit(mock, "album-1")
+		mock.ExpectQuery("UPDATE inventory").WithArgs(5, "album-1").WillReturnRows(sqlmock.NewRows(deductionColumns))
+		mock.ExpectRollback()
+		mock.ExpectQuery("SELECT quantity_available FROM inventory").WithArgs("album-1").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(2))
+		expectSagaStep(mock, "103", sagaStepFailed)
+		expectSagaStep(mock, "103", sagaStepFailedPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		if assert.Len(t, failed.messages, 1) {
+			assert.Equal(t, failureReasonInsufficientInventory, failedReason(t, failed.messages[0]))
+		}
+	})
+}
+
+// TestProcessOrderCreated_MetadataPassthrough tests that order metadata and the gift recipient reach
+// the order-succeeded event.
+func TestProcessOrderCreated_MetadataPassthrough(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	_, succeeded := useRecordingWriters(t)
+	metadata := map[string]string{"giftNote": "Happy birthday!", "giftWrap": "red"}
+	price, err := structpb.NewStruct(map[string]interface{}{"unitPrice": 19.99, "discountAmount": 0, "taxAmount": 0, "totalPrice": 19.99, "currency": "USD"})
+	require.NoError(t, err)
+	msg := orderMessage(t, &events.OrderCreated{OrderId: "201", AlbumId: "album-2", Quantity: 1, Metadata: metadata, Price: price, RecipientUserId: "user-7"})
+
+	expectNoSaga(mock, "201")
+	mock.ExpectBegin()
+	expectNoVelocityLimit(mock, "album-2")
+	mock.ExpectQuery("UPDATE inventory").WithArgs(1, "album-2").WillReturnRows(sqlmock.NewRows(deductionColumns).AddRow(9, nil))
+	expectSagaStep(mock, "201", sagaStepDeducted)
+	mock.ExpectCommit()
+	expectSagaStep(mock, "201", sagaStepSucceededPublished)
+
+	err = processOrderCreated(mockDB, msg)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	if assert.Len(t, succeeded.messages, 1) {
+		var event events.OrderSucceeded
+		assert.NoError(t, events.UnmarshalJSON(succeeded.messages[0].Value, &event))
+		assert.Equal(t, "201", event.OrderId)
+		assert.Equal(t, "album-2", event.AlbumId)
+		assert.Equal(t, int32(1), event.Quantity)
+		assert.Equal(t, metadata, event.Metadata)
+		assert.True(t, proto.Equal(price, event.Price), "price %v", event.Price)
+		assert.Equal(t, "user-7", event.RecipientUserId)
+	}
+}
+
+// TestProcessOrderCreated_VelocityLimits tests per-user and per-album velocity caps.
+func TestProcessOrderCreated_VelocityLimits(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	limitColumns := []string{"max_units_per_user", "max_units_total", "window_seconds"}
+
+	t.Run("Per-user cap exceeded fails the order without deducting", func(t *testing.T) {
+		failed, succeeded := useRecordingWriters(t)
+		before := testutil.ToFloat64(velocityLimitViolations.WithLabelValues(velocityScopeUser))
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "301", AlbumId: "flash-1", UserId: "bot", Quantity: 2})
+
+		expectNoSaga(mock, "301")
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT max_units_per_user, max_units_total, window_seconds FROM album_velocity_limits").
+			WithArgs("flash-1").
+			WillReturnRows(sqlmock.NewRows(limitColumns).AddRow(3, nil, 3600))
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id = \\$1 FOR UPDATE").WithArgs("flash-1").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(10))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM order_velocity WHERE album_id = \\$1 AND user_id = \\$2").
+			WithArgs("flash-1", "bot", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(2))
+		mock.ExpectRollback()
+		expectSagaStep(mock, "301", sagaStepFailed)
+		expectSagaStep(mock, "301", sagaStepFailedPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, succeeded.messages, 0)
+		if assert.Len(t, failed.messages, 1) {
+			assert.Equal(t, failureReasonVelocityLimitExceeded, failedReason(t, failed.messages[0]))
+		}
+		assert.Equal(t, before+1, testutil.ToFloat64(velocityLimitViolations.WithLabelValues(velocityScopeUser)))
+	})
+
+	t.Run("Order within caps is deducted and recorded", func(t *testing.T) {
+		failed, succeeded := useRecordingWriters(t)
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "302", AlbumId: "flash-1", UserId: "fan", Quantity: 1})
+
+		expectNoSaga(mock, "302")
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT max_units_per_user, max_units_total, window_seconds FROM album_velocity_limits").
+			WithArgs("flash-1").
+			WillReturnRows(sqlmock.NewRows(limitColumns).AddRow(3, 100, 3600))
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id = \\$1 FOR UPDATE").WithArgs("flash-1").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(10))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM order_velocity WHERE album_id = \\$1 AND user_id = \\$2").
+			WithArgs("flash-1", "fan", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM order_velocity WHERE album_id = \\$1 AND created_at").
+			WithArgs("flash-1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(40))
+		mock.ExpectQuery("UPDATE inventory").WithArgs(1, "flash-1").WillReturnRows(sqlmock.NewRows(deductionColumns).AddRow(9, nil))
+		mock.ExpectExec("DELETE FROM order_velocity WHERE album_id = \\$1 AND created_at <= \\$2").
+			WithArgs("flash-1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectExec("INSERT INTO order_velocity").
+			WithArgs("302", "flash-1", "fan", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSagaStep(mock, "302", sagaStepDeducted)
+		mock.ExpectCommit()
+		expectSagaStep(mock, "302", sagaStepSucceededPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, failed.messages, 0)
+		assert.Len(t, succeeded.messages, 1)
+	})
+
+	t.Run("Album-wide cap exceeded uses the album scope", func(t *testing.T) {
+		failed, _ := useRecordingWriters(t)
+		before := testutil.ToFloat64(velocityLimitViolations.WithLabelValues(velocityScopeAlbum))
+		msg := orderMessage(t, &events.OrderCreated{OrderId: "303", AlbumId: "flash-2", UserId: "fan", Quantity: 5})
+
+		expectNoSaga(mock, "303")
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT max_units_per_user, max_units_total, window_seconds FROM album_velocity_limits").
+			WithArgs("flash-2").
+			WillReturnRows(sqlmock.NewRows(limitColumns).AddRow(nil, 100, 3600))
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id = \\$1 FOR UPDATE").WithArgs("flash-2").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(10))
+		mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM order_velocity WHERE album_id = \\$1 AND created_at").
+			WithArgs("flash-2", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(98))
+		mock.ExpectRollback()
+		expectSagaStep(mock, "303", sagaStepFailed)
+		expectSagaStep(mock, "303", sagaStepFailedPublished)
+
+		err := processOrderCreated(mockDB, msg)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		if assert.Len(t, failed.messages, 1) {
+			assert.Equal(t, failureReasonVelocityLimitExceeded, failedReason(t, failed.messages[0]))
+		}
+		assert.Equal(t, before+1, testutil.ToFloat64(velocityLimitViolations.WithLabelValues(velocityScopeAlbum)))
+	})
+}
+
 // Note: Add tests for processConfirmedOrder separately using a similar pattern,
 // mocking BeginTx, ExecContext within the transaction, Commit/Rollback etc. 
\ No newline at end of file
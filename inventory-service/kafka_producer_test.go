@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKafkaProducerConfig(t *testing.T) {
+	cfg, err := loadKafkaProducerConfig()
+	require.NoError(t, err)
+	assert.Equal(t, defaultKafkaProducerConfig(), cfg)
+	assert.Equal(t, kafka.RequireAll, cfg.RequiredAcks)
+
+	t.Setenv("KAFKA_PRODUCER_ACKS", "One")
+	t.Setenv("KAFKA_PRODUCER_MAX_ATTEMPTS", "3")
+	t.Setenv("KAFKA_PRODUCER_BATCH_SIZE", "500")
+	t.Setenv("KAFKA_PRODUCER_BATCH_TIMEOUT", "50ms")
+	t.Setenv("KAFKA_PRODUCER_ASYNC", "true")
+	cfg, err = loadKafkaProducerConfig()
+	require.NoError(t, err)
+	assert.Equal(t, kafkaProducerConfig{
+		RequiredAcks: kafka.RequireOne,
+		MaxAttempts:  3,
+		BatchSize:    500,
+		BatchTimeout: 50 * time.Millisecond,
+		Async:        true,
+	}, cfg)
+	assert.Equal(t, "acks=one, 3 attempts, batches of 500 or 50ms, async", cfg.String())
+
+	for name, invalid := range map[string][]string{
+		"KAFKA_PRODUCER_ACKS":          {"2", "leader"},
+		"KAFKA_PRODUCER_MAX_ATTEMPTS":  {"0", "many"},
+		"KAFKA_PRODUCER_BATCH_SIZE":    {"-1"},
+		"KAFKA_PRODUCER_BATCH_TIMEOUT": {"10", "0s"},
+		"KAFKA_PRODUCER_ASYNC":         {"sometimes"},
+	} {
+		for _, v := range invalid {
+			t.Run(name+"="+v, func(t *testing.T) {
+				t.Setenv(name, v)
+				_, err := loadKafkaProducerConfig()
+				assert.ErrorContains(t, err, name)
+			})
+		}
+	}
+}
+
+func TestKafkaProducerNewWriter(t *testing.T) {
+	cfg := defaultKafkaProducerConfig()
+	w := cfg.newWriter("kafka:9092", "order-result", func(error) { t.Fatal("sync writers report failures to the caller") })
+	assert.Equal(t, "order-result", w.Topic)
+	assert.Equal(t, kafka.RequireAll, w.RequiredAcks)
+	assert.Equal(t, defaultKafkaMaxAttempts, w.MaxAttempts)
+	assert.Equal(t, defaultKafkaBatchSize, w.BatchSize)
+	assert.Equal(t, defaultKafkaBatchTimeout, w.BatchTimeout)
+	assert.False(t, w.Async)
+	assert.Nil(t, w.Completion)
+
+	cfg.Async = true
+	var failures []error
+	w = cfg.newWriter("kafka:9092", "order-result-async-test", func(err error) { failures = append(failures, err) })
+	assert.True(t, w.Async)
+	require.NotNil(t, w.Completion)
+	w.Completion([]kafka.Message{{}}, nil)
+	w.Completion([]kafka.Message{{}, {}}, errors.New("leader not available"))
+	assert.Len(t, failures, 1)
+	assert.Equal(t, 2.0, testutil.ToFloat64(kafkaAsyncWriteFailures.WithLabelValues("order-result-async-test")))
+}
@@ -0,0 +1,248 @@
+// low_stock.go - low-stock alerts. An album's low_stock_threshold is set when its inventory is
+// initialized or with PUT /api/inventory/:albumId/low-stock-threshold. When an order takes the stock
+// from above the threshold to the threshold or below, an inventory-low-stock event is published and,
+// with LOW_STOCK_WEBHOOK_URL set, the event is POSTed there too, so operations hear about an album
+// running out before orders start failing. Restocking above the threshold re-arms the alert.
+//
+// Alerts are best effort: a failed publish or webhook call is logged and counted, not retried, and
+// the order is processed either way.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"album-store/events"
+	"album-store/topics"
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	lowStockTopic          = "inventory-low-stock"
+	lowStockWebhookTimeout = 5 * time.Second
+
+	// Headers of webhook calls, named like album-service's catalog webhooks
+	lowStockEventHeader     = "X-Album-Store-Event"
+	lowStockTimestampHeader = "X-Album-Store-Timestamp"
+	lowStockSignatureHeader = "X-Album-Store-Signature"
+)
+
+var (
+	kafkaLowStockWriter   messageWriter
+	kafkaLowStockWriterV2 messageWriter
+
+	// lowStockWebhookURL receives low-stock events when set; lowStockWebhookSecret signs them
+	lowStockWebhookURL    string
+	lowStockWebhookSecret string
+
+	// lowStockWebhookClient calls the webhook; tests replace it
+	lowStockWebhookClient = &http.Client{Timeout: lowStockWebhookTimeout}
+)
+
+// UpdateLowStockThresholdRequest represents a request to set an album's low-stock threshold
+type UpdateLowStockThresholdRequest struct {
+	LowStockThreshold *int `json:"lowStockThreshold" binding:"required,gte=0"`
+}
+
+// loadLowStockWebhookConfig reads LOW_STOCK_WEBHOOK_URL and LOW_STOCK_WEBHOOK_SECRET
+func loadLowStockWebhookConfig() error {
+	lowStockWebhookURL = os.Getenv("LOW_STOCK_WEBHOOK_URL")
+	lowStockWebhookSecret = os.Getenv("LOW_STOCK_WEBHOOK_SECRET")
+	if lowStockWebhookURL == "" {
+		return nil
+	}
+	u, err := url.Parse(lowStockWebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		lowStockWebhookURL = ""
+		return fmt.Errorf("LOW_STOCK_WEBHOOK_URL must be an http or https URL")
+	}
+	return nil
+}
+
+// crossedLowStock reports whether deducting an order took the stock from above threshold to it or
+// below. Albums without a threshold never cross it.
+func crossedLowStock(before, after int, threshold *int) bool {
+	return threshold != nil && before > *threshold && after <= *threshold
+}
+
+// alertLowStock publishes the low-stock event of an order's deduction and calls the webhook
+func alertLowStock(ctx context.Context, order *events.OrderCreated, quantityAvailable, threshold int) {
+	log.Printf("Album %s is low on stock after order %s: %d left, threshold %d", order.AlbumId, order.OrderId, quantityAvailable, threshold)
+	lowStockAlerts.Inc()
+	event := &events.InventoryLowStock{
+		AlbumId:           order.AlbumId,
+		QuantityAvailable: int32(quantityAvailable),
+		LowStockThreshold: int32(threshold),
+		OrderId:           order.OrderId,
+		Timestamp:         timestamppb.Now(),
+	}
+	if err := publishLowStock(ctx, event); err != nil {
+		log.Printf("Failed to publish low-stock event for album %s: %v", order.AlbumId, err)
+	}
+	if lowStockWebhookURL != "" {
+		go func() {
+			if err := callLowStockWebhook(context.WithoutCancel(ctx), event); err != nil {
+				log.Printf("Low-stock webhook for album %s failed: %v", order.AlbumId, err)
+				lowStockWebhookFailures.Inc()
+			}
+		}()
+	}
+}
+
+// publishLowStock publishes the event in every configured schema version, each to its own topic
+func publishLowStock(ctx context.Context, event *events.InventoryLowStock) (err error) {
+	ctx, span := tracer.Start(ctx, "kafka.publish_inventory_low_stock",
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithLinks(orderOriginLinks(ctx)...))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to publish "+lowStockTopic)
+		}
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("messaging.destination.name", topics.Name(lowStockTopic)),
+		attribute.String("album.id", event.AlbumId),
+	)
+	headers := InjectTraceInfoToKafkaMessage(ctx)
+
+	// One crossing per order, so the order identifies the event across versions
+	eventID := events.StableEventID(events.TypeInventoryLowStock + ":" + event.OrderId)
+	var errs []error
+	for _, version := range eventPublishVersions {
+		writer := lowStockWriter(version)
+		if writer == nil {
+			errs = append(errs, fmt.Errorf("no writer for %s schema v%d", lowStockTopic, version))
+			continue
+		}
+		value, err := encodeEvent(version, events.TypeInventoryLowStock, eventID, event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		msg := kafka.Message{Key: []byte(event.AlbumId), Value: value, Headers: headers}
+		if err := writer.WriteMessages(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("schema v%d: %w", version, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lowStockWriter returns the writer of a schema version's low-stock topic, or nil if it isn't published
+func lowStockWriter(version int) messageWriter {
+	switch version {
+	case eventSchemaV1:
+		return kafkaLowStockWriter
+	case eventSchemaV2:
+		return kafkaLowStockWriterV2
+	}
+	return nil
+}
+
+// callLowStockWebhook POSTs the event, as its v1 JSON payload, to LOW_STOCK_WEBHOOK_URL. With a
+// secret, X-Album-Store-Signature is "sha256=" and the hex HMAC-SHA256 of the timestamp header, a
+// "." and the body, as on album-service's webhooks. Any status but 2xx is an error.
+func callLowStockWebhook(ctx context.Context, event *events.InventoryLowStock) error {
+	ctx, span := tracer.Start(ctx, "webhook.inventory_low_stock", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	body, err := events.MarshalJSON(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lowStockWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "inventory-service-webhooks")
+	req.Header.Set(lowStockEventHeader, events.TypeInventoryLowStock)
+	req.Header.Set(lowStockTimestampHeader, timestamp)
+	if lowStockWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(lowStockWebhookSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set(lowStockSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := lowStockWebhookClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("webhook answered %s", resp.Status)
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// updateLowStockThreshold handles PUT /api/inventory/:albumId/low-stock-threshold
+func updateLowStockThreshold(c *gin.Context) {
+	albumID := c.Param("albumId")
+
+	var req UpdateLowStockThresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	setLowStockThreshold(c, albumID, req.LowStockThreshold)
+}
+
+// deleteLowStockThreshold handles DELETE /api/inventory/:albumId/low-stock-threshold, turning the
+// album's alerts off
+func deleteLowStockThreshold(c *gin.Context) {
+	setLowStockThreshold(c, c.Param("albumId"), nil)
+}
+
+// setLowStockThreshold stores the album's threshold, nil for none, and responds with its inventory
+func setLowStockThreshold(c *gin.Context, albumID string, threshold *int) {
+	ctx := c.Request.Context()
+	i := Inventory{AlbumID: albumID, Initialized: true}
+	err := db.QueryRowContext(ctx,
+		"UPDATE inventory SET low_stock_threshold = $2 WHERE album_id = $1 RETURNING quantity_available, last_updated, low_stock_threshold",
+		albumID, threshold).Scan(&i.QuantityAvailable, &i.LastUpdated, &i.LowStockThreshold)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not initialized for album"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update low-stock threshold: " + err.Error()})
+		return
+	}
+	invalidateInventory(ctx, albumID)
+	log.Printf("Low-stock threshold for albumId %s set to %s", albumID, formatThreshold(threshold))
+
+	c.JSON(http.StatusOK, i)
+}
+
+// formatThreshold renders a threshold for the log
+func formatThreshold(threshold *int) string {
+	if threshold == nil {
+		return "none"
+	}
+	return strconv.Itoa(*threshold)
+}
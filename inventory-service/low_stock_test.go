@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrossedLowStock(t *testing.T) {
+	threshold := 3
+	assert.True(t, crossedLowStock(5, 3, &threshold), "reaching the threshold")
+	assert.True(t, crossedLowStock(4, 0, &threshold), "selling out")
+	assert.False(t, crossedLowStock(9, 4, &threshold), "still above")
+	assert.False(t, crossedLowStock(3, 1, &threshold), "already low")
+	assert.False(t, crossedLowStock(5, 0, nil), "no threshold")
+}
+
+func TestLoadLowStockWebhookConfig(t *testing.T) {
+	t.Cleanup(func() { lowStockWebhookURL, lowStockWebhookSecret = "", "" })
+
+	t.Setenv("LOW_STOCK_WEBHOOK_URL", "")
+	require.NoError(t, loadLowStockWebhookConfig())
+	assert.Empty(t, lowStockWebhookURL)
+
+	t.Setenv("LOW_STOCK_WEBHOOK_URL", "https://ops.example.com/hooks/stock")
+	require.NoError(t, loadLowStockWebhookConfig())
+	assert.Equal(t, "https://ops.example.com/hooks/stock", lowStockWebhookURL)
+
+	for _, v := range []string{"ops.example.com/hooks", "ftp://ops.example.com", "https://"} {
+		t.Setenv("LOW_STOCK_WEBHOOK_URL", v)
+		assert.Error(t, loadLowStockWebhookConfig(), v)
+		assert.Empty(t, lowStockWebhookURL, v)
+	}
+}
+
+func TestProcessOrderCreated_LowStockAlert(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	useRecordingWriters(t)
+	lowStock := &recordingWriter{}
+	prevWriter := kafkaLowStockWriter
+	kafkaLowStockWriter = lowStock
+	t.Cleanup(func() { kafkaLowStockWriter = prevWriter })
+
+	calls := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- r
+		bodies <- body
+	}))
+	defer webhook.Close()
+	lowStockWebhookURL, lowStockWebhookSecret = webhook.URL, "s3cret"
+	t.Cleanup(func() { lowStockWebhookURL, lowStockWebhookSecret = "", "" })
+
+	order := func(orderID string, remaining int) {
+		expectNoSaga(mock, orderID)
+		mock.ExpectBegin()
+		expectNoVelocityLimit(mock, "album-5")
+		mock.ExpectQuery("UPDATE inventory .* RETURNING quantity_available, low_stock_threshold").WithArgs(2, "album-5").
+			WillReturnRows(sqlmock.NewRows(deductionColumns).AddRow(remaining, 3))
+		expectSagaStep(mock, orderID, sagaStepDeducted)
+		mock.ExpectCommit()
+		expectSagaStep(mock, orderID, sagaStepSucceededPublished)
+		require.NoError(t, processOrderCreated(mockDB, orderMessage(t, &events.OrderCreated{OrderId: orderID, AlbumId: "album-5", Quantity: 2})))
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	alerts := testutil.ToFloat64(lowStockAlerts)
+	order("501", 3) // 5 -> 3 reaches the threshold
+	order("502", 1) // Already low, no second alert
+	assert.Equal(t, alerts+1, testutil.ToFloat64(lowStockAlerts))
+
+	require.Len(t, lowStock.messages, 1)
+	assert.Equal(t, "album-5", string(lowStock.messages[0].Key))
+	var event events.InventoryLowStock
+	require.NoError(t, events.UnmarshalJSON(lowStock.messages[0].Value, &event))
+	assert.Equal(t, "album-5", event.AlbumId)
+	assert.Equal(t, int32(3), event.QuantityAvailable)
+	assert.Equal(t, int32(3), event.LowStockThreshold)
+	assert.Equal(t, "501", event.OrderId)
+
+	select {
+	case req := <-calls:
+		body := <-bodies
+		assert.Equal(t, events.TypeInventoryLowStock, req.Header.Get("X-Album-Store-Event"))
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(req.Header.Get("X-Album-Store-Timestamp") + "."))
+		mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Album-Store-Signature"))
+		assert.True(t, bytes.Equal(lowStock.messages[0].Value, body), "the webhook gets the v1 payload")
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestLowStockThresholdHandlers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"quantity_available", "last_updated", "low_stock_threshold"}
+	updated := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("Set", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE inventory SET low_stock_threshold = \\$2 WHERE album_id = \\$1").WithArgs("42", 5).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(12, updated, 5))
+
+		rr := send("PUT", "/api/inventory/42/low-stock-threshold", `{"lowStockThreshold": 5}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"albumId":"42","quantityAvailable":12,"lastUpdated":"2026-03-01T09:00:00Z","initialized":true,"lowStockThreshold":5}`, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE inventory SET low_stock_threshold").WithArgs("42", nil).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(12, updated, nil))
+
+		rr := send("DELETE", "/api/inventory/42/low-stock-threshold", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NotContains(t, rr.Body.String(), "lowStockThreshold")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid or unknown", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/api/inventory/42/low-stock-threshold", `{"lowStockThreshold": -1}`).Code)
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/api/inventory/42/low-stock-threshold", `{}`).Code)
+
+		mock.ExpectQuery("UPDATE inventory SET low_stock_threshold").WithArgs("99", 5).WillReturnRows(sqlmock.NewRows(columns))
+		assert.Equal(t, http.StatusNotFound, send("PUT", "/api/inventory/99/low-stock-threshold", `{"lowStockThreshold": 5}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
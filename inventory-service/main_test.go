@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
@@ -11,9 +12,12 @@ import (
 	"testing"
 	"time"
 
+	"album-store/jsonnaming"
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v5/stdlib" // Import pgx stdlib driver
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
 )
 
 var testDB *sql.DB
@@ -43,9 +47,13 @@ func TestMain(m *testing.M) {
 	// Assign the test DB to the global var used by handlers
 	db = testDB
 
-	// Ensure the necessary tables exist in the test DB
-	initDB()                   // Create inventory table
-	initProcessedOrdersTable() // Create processed_orders table
+	// Bring the test DB's schema up to date
+	if err := runMigrations(context.Background(), testDB); err != nil {
+		log.Fatalf("Could not migrate test database: %v", err)
+	}
+
+	// Consumers and handlers start spans directly, so give them the (no-op) global tracer
+	tracer = otel.Tracer("inventory-service")
 
 	// Set up the Gin router for testing
 	gin.SetMode(gin.TestMode)
@@ -65,24 +73,16 @@ func TestMain(m *testing.M) {
 // setupRouter configures the Gin router with routes and middleware (mirrors main.go)
 func setupRouter() *gin.Engine {
 	router := gin.New() // Use New for tests
+	router.Use(limitRequestBody(), compressResponses())
 
 	api := router.Group("/api")
-	{
-		inventory := api.Group("/inventory")
-		{
-			inventory.GET("/:albumId", getInventory)
-
-			adminRoutes := inventory.Group("")
-			adminRoutes.Use(requireAdmin())
-			{
-				adminRoutes.GET("", getAllInventory)
-				adminRoutes.PUT("/:albumId", updateInventory)
-			}
-		}
-	}
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"ok": true})
-	})
+	api.Use(jsonnaming.Middleware())
+	registerAPIRoutes(api, func(h gin.HandlerFunc, _ string) gin.HandlerFunc { return h })
+	registerHealthRoutes(router)
+	router.GET("/internal/consumers", getConsumers)
+	router.GET("/internal/info", getInternalInfo)
+	router.GET("/metrics", gin.WrapH(metricsHandler()))
+	registerAPIDocs(router)
 	return router
 }
 
@@ -123,6 +123,7 @@ func TestGetInventoryHandler_Found(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testAlbumID, inv.AlbumID)
 	assert.Equal(t, expectedQuantity, inv.QuantityAvailable)
+	assert.True(t, inv.Initialized)
 }
 
 func TestGetInventoryHandler_NotFound(t *testing.T) {
@@ -142,9 +143,22 @@ func TestGetInventoryHandler_NotFound(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testAlbumID, inv.AlbumID)
 	assert.Equal(t, 0, inv.QuantityAvailable) // Expect 0 quantity
+	assert.False(t, inv.Initialized)          // ...but flagged as never initialized
 	assert.WithinDuration(t, time.Now(), inv.LastUpdated, 5*time.Second) // Check timestamp is recent
 }
 
+func TestGetInventoryHandler_NotFound_Strict(t *testing.T) {
+	cleanupInventoryDB()
+	defer cleanupInventoryDB()
+
+	req, _ := http.NewRequest("GET", "/api/inventory/nonexistent-album?strict=true", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "not initialized")
+}
+
 // Test GET /api/inventory
 func TestGetAllInventoryHandler_Empty(t *testing.T) {
 	cleanupInventoryDB()
@@ -196,6 +210,21 @@ func TestGetAllInventoryHandler_WithData(t *testing.T) {
 	}
 	assert.Equal(t, data[0].qty, invMap[data[0].id])
 	assert.Equal(t, data[1].qty, invMap[data[1].id])
+	assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+
+	// Paged, largest stock first
+	req, _ = http.NewRequest("GET", "/api/inventory?sort=-quantity&limit=1", nil)
+	req.Header.Set("Client-Type", "admin")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &invList))
+	if assert.Len(t, invList, 1) {
+		assert.Equal(t, "albumB", invList[0].AlbumID)
+	}
+	assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</api/inventory?limit=1&offset=1&sort=-quantity>; rel="next"`, rr.Header().Get("Link"))
 }
 
 func TestGetAllInventoryHandler_Forbidden(t *testing.T) {
@@ -324,4 +353,56 @@ func TestUpdateInventoryHandler_Forbidden(t *testing.T) {
 	var queriedAlbumID string
 	err := testDB.QueryRow("SELECT album_id FROM inventory WHERE album_id = $1", albumID).Scan(&queriedAlbumID)
 	assert.ErrorIs(t, err, sql.ErrNoRows, "Querying the forbidden album ID should return sql.ErrNoRows")
-} 
\ No newline at end of file
+} 
+
+// Test POST /api/inventory (DB mocked: the albums table belongs to album-service)
+func TestInitializeInventoryHandler(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	defer func() { db = originalDB }()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/inventory", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Creates record with threshold", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("42").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec("INSERT INTO inventory").WithArgs("42", 7, sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rr := post(`{"albumId":"42","quantityAvailable":7,"lowStockThreshold":2}`)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var inv Inventory
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &inv))
+		assert.Equal(t, 7, inv.QuantityAvailable)
+		assert.True(t, inv.Initialized)
+		if assert.NotNil(t, inv.LowStockThreshold) {
+			assert.Equal(t, 2, *inv.LowStockThreshold)
+		}
+	})
+
+	t.Run("Unknown album", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("404").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		assert.Equal(t, http.StatusNotFound, post(`{"albumId":"404","quantityAvailable":1}`).Code)
+	})
+
+	t.Run("Already initialized", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("42").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec("INSERT INTO inventory").WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.Equal(t, http.StatusConflict, post(`{"albumId":"42","quantityAvailable":1}`).Code)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(`{"albumId":"42"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post(`{"albumId":"42","quantityAvailable":-1}`).Code)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,178 @@
+// metrics.go - Prometheus metrics for inventory-service, exposed on /metrics
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// velocityLimitViolations counts orders rejected by a velocity cap, by scope (user or album)
+	velocityLimitViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_velocity_limit_violations_total",
+		Help: "Orders rejected because they exceeded a per-album or per-user velocity cap.",
+	}, []string{"scope"})
+
+	// circuitBreakerOpen and circuitBreakerRejections report the circuit breakers of the database and
+	// the Kafka writers (see circuit_breaker.go)
+	circuitBreakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "inventory_circuit_breaker_open",
+		Help: "Whether the circuit breaker of a dependency is open (1) or closed (0).",
+	}, []string{"dependency"})
+	circuitBreakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_circuit_breaker_rejections_total",
+		Help: "Calls and requests refused while the circuit of a dependency was open.",
+	}, []string{"dependency"})
+
+	// lowStockAlerts counts orders that took an album's stock to its low-stock threshold or below, and
+	// lowStockWebhookFailures the webhook calls of those alerts that failed (see low_stock.go)
+	lowStockAlerts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "inventory_low_stock_alerts_total",
+		Help: "Orders that took an album's stock to its low-stock threshold or below.",
+	})
+	lowStockWebhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "inventory_low_stock_webhook_failures_total",
+		Help: "Low-stock webhook calls that failed or were answered with a non-2xx status.",
+	})
+
+	// eventFormatsObserved counts consumed album events per wire format (see event_serialization.go),
+	// to tell when album-service no longer publishes JSON.
+	eventFormatsObserved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_event_formats_observed_total",
+		Help: "Consumed album events by topic and wire format.",
+	}, []string{"topic", "format"})
+
+	// eventSchemaVersionsObserved counts consumed events per schema version, to tell when an old
+	// version is no longer produced and can be retired.
+	eventSchemaVersionsObserved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_event_schema_versions_observed_total",
+		Help: "Consumed events by topic and payload schema version.",
+	}, []string{"topic", "version"})
+
+	// unhandledEventTypes counts consumed events skipped because no handler takes their eventType,
+	// e.g. a type a producer added to a topic before this service handles it.
+	unhandledEventTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_unhandled_event_types_total",
+		Help: "Consumed events skipped because their event type has no handler, by topic.",
+	}, []string{"topic"})
+
+	// uninitializedInventoryLookups counts lookups for albums without an inventory record, which
+	// usually means their album-created event was lost or not yet consumed.
+	uninitializedInventoryLookups = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "inventory_uninitialized_lookups_total",
+		Help: "Inventory lookups for albums that have no inventory record.",
+	})
+
+	// inventoryCacheLookups counts lookups through the Redis inventory cache by result: hit, miss or
+	// error (see redis_cache.go)
+	inventoryCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_cache_lookups_total",
+		Help: "Inventory lookups through the Redis cache by result.",
+	}, []string{"result"})
+
+	// publishRetriesQueued is the number of events waiting in the publish retry buffer (see publish_retry.go)
+	publishRetriesQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "inventory_publish_retries_queued",
+		Help: "Events whose Kafka publish failed, waiting to be retried.",
+	})
+
+	// publishRetriesDelivered counts queued events a retry delivered, by topic
+	publishRetriesDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_publish_retries_delivered_total",
+		Help: "Events delivered by a publish retry after their first publish failed, by topic.",
+	}, []string{"topic"})
+
+	// publishRetriesDropped counts failed publishes that didn't fit in the retry buffer
+	publishRetriesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_publish_retries_dropped_total",
+		Help: "Events left to saga recovery because the publish retry buffer was full, by topic.",
+	}, []string{"topic"})
+
+	// kafkaAsyncWriteFailures counts the events async Kafka writers failed to deliver, by topic
+	// (KAFKA_PRODUCER_ASYNC=true, see kafka_producer.go). Sync writers return the error to the caller.
+	kafkaAsyncWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_kafka_async_write_failures_total",
+		Help: "Events async Kafka writers failed to deliver, by topic.",
+	}, []string{"topic"})
+
+	// dbReplicaUp is 1 while the read replica answers its health checks (see read_replica.go)
+	dbReplicaUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "inventory_db_replica_up",
+		Help: "Whether the read replica is up; inventory lookups use the primary while it is 0.",
+	})
+
+	// ordersProcessed counts order-created events by result; the order processing success rate SLI
+	// is succeeded over all outcomes. reason is the order-failed reason, empty otherwise. Succeeded
+	// and failed orders carry an exemplar with the album (see countOrder).
+	ordersProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_orders_processed_total",
+		Help: "Processed order-created events by outcome (succeeded, failed, invalid, error) and failure reason.",
+	}, []string{"outcome", "reason"})
+
+	// consumerErrors counts what consumers did with events they failed to process, per their topic's
+	// error policy (see consumer_policy.go): retried, dead_lettered or skipped
+	consumerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_consumer_errors_total",
+		Help: "Failed event processing attempts by topic and the action taken (retried, dead_lettered, skipped).",
+	}, []string{"topic", "action"})
+
+	// deductionDuration times the stock deduction transaction of an order, from BEGIN to commit or
+	// rollback, by result: deducted, rejected (insufficient stock or a velocity cap) or error
+	deductionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "inventory_deduction_duration_seconds",
+		Help:    "Duration of the stock deduction transaction of an order by result (deducted, rejected, error).",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms to ~2s
+	}, []string{"result"})
+)
+
+// Results of a stock deduction, see deductionDuration
+const (
+	deductionResultDeducted = "deducted"
+	deductionResultRejected = "rejected"
+	deductionResultError    = "error"
+)
+
+// maxExemplarAlbumIDLength bounds the album ID of an exemplar; Prometheus rejects exemplars whose
+// labels exceed 128 characters in total
+const maxExemplarAlbumIDLength = 64
+
+// orderExemplar labels an order sample with its album and trace. An album_id label would create a
+// series per album, so the album travels in the exemplar instead: dashboards list the albums behind
+// failures from the exemplars of a sale's time range and link to example traces.
+func orderExemplar(ctx context.Context, albumID string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if albumID != "" && len(albumID) <= maxExemplarAlbumIDLength && utf8.ValidString(albumID) {
+		labels["album_id"] = albumID
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		labels["trace_id"] = sc.TraceID().String()
+	}
+	return labels
+}
+
+// countOrder counts a processed order with an exemplar naming its album
+func countOrder(ctx context.Context, outcome, reason, albumID string) {
+	ordersProcessed.WithLabelValues(outcome, reason).(prometheus.ExemplarAdder).
+		AddWithExemplar(1, orderExemplar(ctx, albumID))
+}
+
+// observeDeduction records how long an order's deduction transaction took
+func observeDeduction(ctx context.Context, result, albumID string, d time.Duration) {
+	deductionDuration.WithLabelValues(result).(prometheus.ExemplarObserver).
+		ObserveWithExemplar(d.Seconds(), orderExemplar(ctx, albumID))
+}
+
+// metricsHandler serves /metrics, in the OpenMetrics format to scrapers that ask for it since only
+// that format carries exemplars
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOrderExemplar(t *testing.T) {
+	assert.Equal(t, prometheus.Labels{"album_id": "42"}, orderExemplar(context.Background(), "42"))
+	assert.Empty(t, orderExemplar(context.Background(), ""))
+	assert.Empty(t, orderExemplar(context.Background(), strings.Repeat("9", maxExemplarAlbumIDLength+1)),
+		"album IDs that would exceed the exemplar size limit are left out")
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	assert.Equal(t, prometheus.Labels{"album_id": "42", "trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}, orderExemplar(ctx, "42"))
+}
+
+func TestMetricsHandler_Exemplars(t *testing.T) {
+	countOrder(context.Background(), orderOutcomeFailed, "METRICS_TEST", "album-7")
+	observeDeduction(context.Background(), deductionResultRejected, "album-7", 3*time.Millisecond)
+
+	scrape := func(accept string) string {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		metricsHandler().ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	body := scrape("application/openmetrics-text; version=1.0.0")
+	assert.Contains(t, body, `inventory_orders_processed_total{outcome="failed",reason="METRICS_TEST"} 1.0 # {album_id="album-7"} 1.0`)
+	assert.Contains(t, body, `inventory_deduction_duration_seconds_bucket{result="rejected",le="0.004"}`)
+	assert.Contains(t, body, `# {album_id="album-7"} 0.003`)
+
+	// The Prometheus text format has no exemplars
+	body = scrape("text/plain")
+	assert.Contains(t, body, `inventory_orders_processed_total{outcome="failed",reason="METRICS_TEST"} 1`)
+	assert.NotContains(t, body, `album_id="album-7"`)
+}
@@ -0,0 +1,117 @@
+-- The schema inventory-service created on startup before it had migrations. Every statement is
+-- idempotent, so databases created that way adopt it without changes.
+
+-- +goose Up
+CREATE TABLE IF NOT EXISTS inventory (
+	album_id VARCHAR(50) PRIMARY KEY,
+	quantity_available INTEGER NOT NULL DEFAULT 0,
+	last_updated TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE inventory ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER;
+
+-- Stock aging (stock_aging.go). Both start out empty; until an album's first recorded sale its idle
+-- time counts from the last receipt, or from the last stock change when no receipt was recorded either.
+ALTER TABLE inventory
+	ADD COLUMN IF NOT EXISTS last_received_at TIMESTAMP,
+	ADD COLUMN IF NOT EXISTS last_sold_at TIMESTAMP;
+
+-- Orders already deducted (kafka_consumer.go)
+CREATE TABLE IF NOT EXISTS processed_orders (
+	order_id VARCHAR(255) PRIMARY KEY,
+	processed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Velocity limits (velocity.go)
+CREATE TABLE IF NOT EXISTS album_velocity_limits (
+	album_id VARCHAR(50) PRIMARY KEY,
+	max_units_per_user INTEGER,
+	max_units_total INTEGER,
+	window_seconds INTEGER NOT NULL,
+	last_updated TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS order_velocity (
+	order_id VARCHAR(255) PRIMARY KEY,
+	album_id VARCHAR(50) NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	quantity INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_order_velocity_album_user ON order_velocity (album_id, user_id, created_at);
+
+-- Inventory of deleted albums (album_cleanup.go). A row is also the tombstone that makes later orders
+-- for the album fail with ALBUM_REMOVED.
+CREATE TABLE IF NOT EXISTS inventory_archive (
+	album_id VARCHAR(50) PRIMARY KEY,
+	quantity_available INTEGER NOT NULL,
+	low_stock_threshold INTEGER,
+	archived_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Order saga steps (saga.go)
+CREATE TABLE IF NOT EXISTS saga_log (
+	order_id VARCHAR(255) NOT NULL,
+	step VARCHAR(32) NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	recorded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (order_id, step)
+);
+
+-- Supplier stock imports (inventory_import.go): identifiers, previews and the adjustment audit
+CREATE TABLE IF NOT EXISTS album_identifiers (
+	album_id VARCHAR(50) PRIMARY KEY,
+	upc VARCHAR(14) UNIQUE,
+	catalog_number VARCHAR(50),
+	last_updated TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_album_identifiers_catalog_number ON album_identifiers (lower(catalog_number));
+CREATE TABLE IF NOT EXISTS inventory_imports (
+	id VARCHAR(32) PRIMARY KEY,
+	mode VARCHAR(10) NOT NULL,
+	items JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	confirmed_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS inventory_adjustments (
+	id SERIAL PRIMARY KEY,
+	album_id VARCHAR(50) NOT NULL,
+	quantity_before INTEGER NOT NULL,
+	quantity_after INTEGER NOT NULL,
+	source VARCHAR(20) NOT NULL,
+	reference VARCHAR(64),
+	client_ip VARCHAR(45),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_inventory_adjustments_album ON inventory_adjustments (album_id, created_at);
+
+-- Receiving (receiving.go)
+CREATE TABLE IF NOT EXISTS inventory_receipts (
+	id SERIAL PRIMARY KEY,
+	purchase_order VARCHAR(64) NOT NULL,
+	client_ip VARCHAR(45),
+	received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_inventory_receipts_purchase_order ON inventory_receipts (purchase_order);
+CREATE TABLE IF NOT EXISTS inventory_receipt_lines (
+	receipt_id INTEGER NOT NULL REFERENCES inventory_receipts(id),
+	album_id VARCHAR(50) NOT NULL,
+	expected INTEGER NOT NULL,
+	received INTEGER NOT NULL,
+	note TEXT,
+	PRIMARY KEY (receipt_id, album_id)
+);
+CREATE TABLE IF NOT EXISTS receiving_discrepancies (
+	id SERIAL PRIMARY KEY,
+	receipt_id INTEGER NOT NULL REFERENCES inventory_receipts(id),
+	purchase_order VARCHAR(64) NOT NULL,
+	album_id VARCHAR(50) NOT NULL,
+	expected INTEGER NOT NULL,
+	received INTEGER NOT NULL,
+	difference INTEGER NOT NULL,
+	note TEXT,
+	status VARCHAR(10) NOT NULL DEFAULT 'open',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	resolved_at TIMESTAMP,
+	resolved_by VARCHAR(45),
+	resolution TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_receiving_discrepancies_open ON receiving_discrepancies (created_at) WHERE status = 'open';
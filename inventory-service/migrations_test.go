@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationState(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	t.Run("New database", func(t *testing.T) {
+		mock.ExpectQuery("SELECT to_regclass").WithArgs(migrationsTable).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		version, pending, err := migrationState(context.Background(), mockDB)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), version)
+		assert.Equal(t, []string{"00001_baseline.sql", "00002_publish_retries.sql"}, pending)
+	})
+
+	t.Run("Migrated database", func(t *testing.T) {
+		mock.ExpectQuery("SELECT to_regclass").WithArgs(migrationsTable).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT version_id FROM inventory_schema_migrations WHERE is_applied").
+			WillReturnRows(sqlmock.NewRows([]string{"version_id"}).AddRow(0).AddRow(1).AddRow(2))
+		version, pending, err := migrationState(context.Background(), mockDB)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), version)
+		assert.Empty(t, pending)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadMigrationConfig(t *testing.T) {
+	t.Cleanup(func() { migrateOnStartup = true })
+
+	t.Setenv("DB_MIGRATE_ON_STARTUP", "false")
+	require.NoError(t, loadMigrationConfig())
+	assert.False(t, migrateOnStartup)
+
+	t.Setenv("DB_MIGRATE_ON_STARTUP", "sometimes")
+	assert.EqualError(t, loadMigrationConfig(), `DB_MIGRATE_ON_STARTUP "sometimes" must be true or false`)
+}
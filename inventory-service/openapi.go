@@ -0,0 +1,46 @@
+// openapi.go - the OpenAPI 3 description of the service (openapi.yaml, maintained by hand next to the
+// handlers) and Swagger UI to browse it on /swagger/. openapi_test.go fails when a route is added or
+// removed without updating the spec.
+
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the spec served next to it
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>inventory-service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.yaml", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// registerAPIDocs serves Swagger UI on /swagger/ and the spec on /swagger/openapi.yaml
+func registerAPIDocs(router gin.IRouter) {
+	docs := router.Group("/swagger")
+	{
+		docs.GET("/", func(c *gin.Context) {
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+		})
+		docs.GET("/openapi.yaml", func(c *gin.Context) {
+			c.Data(http.StatusOK, "application/yaml", openAPISpec)
+		})
+	}
+}
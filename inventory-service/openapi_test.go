@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIDocument is the part of openapi.yaml the tests check
+type openAPIDocument struct {
+	OpenAPI    string                          `yaml:"openapi"`
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components map[string]map[string]yaml.Node `yaml:"components"`
+}
+
+var openAPIPathParam = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// documentedRoutes returns "METHOD /path" for every operation in the spec, with gin-style parameters
+func documentedRoutes(spec openAPIDocument) []string {
+	var routes []string
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			routes = append(routes, strings.ToUpper(method)+" "+openAPIPathParam.ReplaceAllString(path, ":$1"))
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var spec openAPIDocument
+	require.NoError(t, yaml.Unmarshal(openAPISpec, &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+
+	var registered []string
+	for _, r := range setupRouter().Routes() {
+		if strings.HasPrefix(r.Path, "/swagger/") {
+			continue
+		}
+		registered = append(registered, r.Method+" "+r.Path)
+	}
+	sort.Strings(registered)
+	assert.Equal(t, registered, documentedRoutes(spec), "openapi.yaml is out of date")
+}
+
+func TestOpenAPISpecReferencesResolve(t *testing.T) {
+	var spec openAPIDocument
+	require.NoError(t, yaml.Unmarshal(openAPISpec, &spec))
+	for _, ref := range regexp.MustCompile(`\$ref: '#/components/([A-Za-z]+)/([A-Za-z]+)'`).FindAllStringSubmatch(string(openAPISpec), -1) {
+		_, ok := spec.Components[ref[1]][ref[2]]
+		assert.True(t, ok, "unresolved %s", ref[0])
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	for path, contentType := range map[string]string{
+		"/swagger/":             "text/html; charset=utf-8",
+		"/swagger/openapi.yaml": "application/yaml",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, path)
+		assert.Equal(t, contentType, rr.Header().Get("Content-Type"), path)
+	}
+
+	req, _ := http.NewRequest("GET", "/swagger", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/swagger/", rr.Header().Get("Location"))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"album-store/kafkawriter"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPublishRetryConfig(t *testing.T) {
+	t.Cleanup(func() { publishRetries = newPublishRetryBuffer(defaultPublishRetryBufferSize) })
+
+	require.NoError(t, loadPublishRetryConfig())
+	assert.Equal(t, defaultPublishRetryBufferSize, publishRetries.size)
+
+	t.Setenv("PUBLISH_RETRY_BUFFER_SIZE", "50")
+	require.NoError(t, loadPublishRetryConfig())
+	assert.Equal(t, 50, publishRetries.size)
+
+	t.Setenv("PUBLISH_RETRY_BUFFER_SIZE", "lots")
+	assert.ErrorContains(t, loadPublishRetryConfig(), "not a positive integer")
+}
+
+func TestOrderEventTopic(t *testing.T) {
+	base, version, ok := orderEventTopic(versionedTopic(orderFailedTopic, eventSchemaV2))
+	assert.True(t, ok)
+	assert.Equal(t, orderFailedTopic, base)
+	assert.Equal(t, eventSchemaV2, version)
+
+	_, _, ok = orderEventTopic("order-created")
+	assert.False(t, ok)
+}
+
+func TestPublishRetryBuffer(t *testing.T) {
+	v1Topic, v2Topic := versionedTopic(orderSucceededTopic, eventSchemaV1), versionedTopic(orderSucceededTopic, eventSchemaV2)
+	v1, v2 := &failingWriter{failures: 1}, &recordingWriter{}
+	writers := map[string]messageWriter{v1Topic: v1, v2Topic: v2}
+	buffer := newPublishRetryBuffer(3)
+	buffer.writerFor = func(topic string) messageWriter { return writers[topic] }
+	var completed []string
+	buffer.delivered = func(ctx context.Context, topic string, msg kafka.Message) {
+		completed = append(completed, string(msg.Key))
+	}
+	ctx := context.Background()
+
+	dropped := testutil.ToFloat64(publishRetriesDropped.WithLabelValues(v1Topic))
+	buffer.enqueue(v1Topic, kafka.Message{Key: []byte("501")}, kafka.Message{Key: []byte("502")})
+	buffer.enqueue(v2Topic, kafka.Message{Key: []byte("501")})
+	buffer.enqueue(v1Topic, kafka.Message{Key: []byte("501")})
+	assert.Equal(t, 3, buffer.queued(), "an event queued again keeps its place")
+	buffer.enqueue(v1Topic, kafka.Message{Key: []byte("503")})
+	assert.Equal(t, dropped+1, testutil.ToFloat64(publishRetriesDropped.WithLabelValues(v1Topic)), "the buffer is bounded")
+
+	// v1 still fails, so order 501 isn't complete although its v2 event went through
+	now := time.Now().Add(publishRetryBaseDelay)
+	buffer.retryDue(ctx, now)
+	assert.Empty(t, v1.messages)
+	assert.Equal(t, []string{"501"}, messageKeys(v2.messages))
+	assert.Empty(t, completed)
+
+	buffer.retryDue(ctx, now.Add(2*time.Second))
+	assert.Equal(t, []string{"501", "502"}, messageKeys(v1.messages), "events of a topic keep their order")
+	assert.Equal(t, []string{"501", "502"}, completed)
+	assert.Zero(t, buffer.queued())
+}
+
+func TestRecordRetriedSagaStep(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	expectSagaStep(mock, "501", sagaStepFailedPublished)
+	recordRetriedSagaStep(context.Background(), versionedTopic(orderFailedTopic, eventSchemaV2), kafka.Message{Key: []byte("501")})
+	expectSagaStep(mock, "502", sagaStepSucceededPublished)
+	recordRetriedSagaStep(context.Background(), versionedTopic(orderSucceededTopic, eventSchemaV1), kafka.Message{Key: []byte("502")})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublishRetryBuffer_SaveAndReplay(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	prev := publishRetries
+	t.Cleanup(func() { publishRetries = prev })
+	ctx := context.Background()
+	topic := versionedTopic(orderFailedTopic, eventSchemaV1)
+
+	publishRetries = newPublishRetryBuffer(10)
+	publishRetries.start()
+	publishRetries.enqueue(topic, kafka.Message{Key: []byte("501"), Value: []byte(`{"orderId":"501"}`)})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO inventory_publish_retries").
+		WithArgs(topic, []byte("501"), []byte(`{"orderId":"501"}`), []byte(`null`), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, publishRetries.stopAndSave(ctx, mockDB))
+
+	publishRetries = newPublishRetryBuffer(10)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM inventory_publish_retries ORDER BY id FOR UPDATE SKIP LOCKED").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "topic", "message_key", "message_value", "headers", "attempts"}).
+			AddRow(1, topic, []byte("501"), []byte(`{"orderId":"501"}`), []byte(`null`), 1))
+	mock.ExpectExec("DELETE FROM inventory_publish_retries WHERE id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, replayPublishRetries(ctx, mockDB))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.Equal(t, 1, publishRetries.queued())
+	assert.Equal(t, topic, publishRetries.pending[0].topic)
+	assert.False(t, publishRetries.pending[0].nextAttempt.After(time.Now()), "replayed events are due right away")
+}
+
+func TestSendOrderEvent_QueuesFailures(t *testing.T) {
+	prevWriter, prevRetries, prevVersions := kafkaFailedEventWriter, publishRetries, eventPublishVersions
+	t.Cleanup(func() {
+		kafkaFailedEventWriter, publishRetries, eventPublishVersions = prevWriter, prevRetries, prevVersions
+	})
+	kafkaFailedEventWriter, eventPublishVersions = &failingWriter{failures: 1}, []int{eventSchemaV1}
+	publishRetries = newPublishRetryBuffer(10)
+
+	order := &events.OrderCreated{OrderId: "501", AlbumId: "1", Quantity: 1}
+	assert.ErrorIs(t, sendOrderEvent(context.Background(), order, failureReasonInsufficientInventory, orderFailedTopic), kafkawriter.ErrUnavailable)
+	require.Equal(t, 1, publishRetries.queued())
+	assert.Equal(t, versionedTopic(orderFailedTopic, eventSchemaV1), publishRetries.pending[0].topic)
+	assert.Equal(t, "501", string(publishRetries.pending[0].msg.Key))
+}
+
+func messageKeys(msgs []kafka.Message) []string {
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = string(msg.Key)
+	}
+	return keys
+}
@@ -0,0 +1,94 @@
+// read_replica.go - optional read replica for GET /api/inventory/:albumId, the busiest read. It is
+// enabled by DB_READ_CONNECTION and sized like the primary pool (see db_pool.go). Handlers opt in by
+// reading with a context from withReplicaReads; everything else, including the stock checks of order
+// processing, stays on the primary. The replica is pinged every few seconds and reads fall back to
+// the primary while it is down.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	replicaCheckInterval = 5 * time.Second
+	replicaCheckTimeout  = 2 * time.Second
+)
+
+// replicaDB is nil unless DB_READ_CONNECTION is set. replicaUp is false until its first ping succeeds.
+var (
+	replicaDB *sql.DB
+	replicaUp atomic.Bool
+)
+
+var errInvalidReadConnection = errors.New("DB_READ_CONNECTION is not a valid connection string")
+
+type replicaReadsKey struct{}
+
+// withReplicaReads marks ctx so that readerDB serves its reads from the replica. Only use it for
+// reads that can be slightly stale: the replica lags the primary.
+func withReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, true)
+}
+
+// readerDB is the database to read from with ctx: the replica when ctx allows it and the replica is
+// up, the primary otherwise
+func readerDB(ctx context.Context) *sql.DB {
+	if replicaDB != nil && replicaUp.Load() && ctx.Value(replicaReadsKey{}) != nil {
+		return replicaDB
+	}
+	return db
+}
+
+// loadReadReplica opens the replica pool when DB_READ_CONNECTION is set and starts its health checks
+func loadReadReplica(cfg dbPoolConfig) error {
+	connStr := os.Getenv("DB_READ_CONNECTION")
+	if connStr == "" {
+		return nil
+	}
+	// No circuit breaker: reads fall back to the primary while the replica is down
+	_, database, err := openDBPool(context.Background(), connStr, cfg, nil)
+	if err != nil {
+		// The error may quote the connection string
+		return errInvalidReadConnection
+	}
+	replicaDB = database
+	checkReadReplica()
+	if !replicaUp.Load() {
+		log.Println("Read replica is unreachable, reads use the primary until it is back")
+	}
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkReadReplica()
+		}
+	}()
+	return nil
+}
+
+// checkReadReplica pings the replica and records whether it is up, logging changes
+func checkReadReplica() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+	err := replicaDB.PingContext(ctx)
+	up := err == nil
+	if was := replicaUp.Swap(up); was != up {
+		if up {
+			log.Println("Read replica is up, serving inventory lookups from it")
+		} else {
+			log.Printf("Read replica is down, serving inventory lookups from the primary: %v", err)
+		}
+	}
+	if up {
+		dbReplicaUp.Set(1)
+	} else {
+		dbReplicaUp.Set(0)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReplicaRouting(t *testing.T) {
+	primaryDB, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	defer primaryDB.Close()
+	replica, replicaMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer replica.Close()
+	originalDB := db
+	db, replicaDB = primaryDB, replica
+	t.Cleanup(func() {
+		db, replicaDB = originalDB, nil
+		replicaUp.Store(false)
+	})
+
+	expectRecord := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("FROM inventory WHERE album_id = \\$1").WithArgs("42").
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "quantity_available", "last_updated", "low_stock_threshold"}).AddRow("42", 5, time.Now(), nil))
+	}
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/api/inventory/42", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	replicaMock.ExpectPing()
+	checkReadReplica()
+	assert.Equal(t, 1.0, testutil.ToFloat64(dbReplicaUp))
+	expectRecord(replicaMock)
+	assert.Equal(t, http.StatusOK, get())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+
+	// Order processing reads the stock it deducts from the primary
+	primary.ExpectQuery("SELECT quantity_available FROM inventory").WithArgs("42").WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(5))
+	primary.ExpectExec("UPDATE inventory SET quantity_available").WillReturnResult(sqlmock.NewResult(0, 1))
+	primary.ExpectQuery("SELECT quantity_available FROM inventory").WithArgs("42").WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(4))
+	require.NoError(t, reserveInventory("42", 1))
+	assert.NoError(t, primary.ExpectationsWereMet())
+
+	replicaMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	checkReadReplica()
+	assert.Equal(t, 0.0, testutil.ToFloat64(dbReplicaUp))
+	expectRecord(primary)
+	assert.Equal(t, http.StatusOK, get(), "lookups fall back to the primary")
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+}
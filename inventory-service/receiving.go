@@ -0,0 +1,299 @@
+// receiving.go - warehouse receiving. A shipment is received against a purchase order: each line
+// records the quantity expected and the quantity actually received, the received quantity is added
+// to stock as an audited adjustment, and lines that don't match are queued as discrepancies for
+// someone to follow up with the supplier.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"album-store/listing"
+	"github.com/gin-gonic/gin"
+)
+
+const maxReceiptLines = 500
+
+// Discrepancy statuses
+const (
+	discrepancyOpen     = "open"
+	discrepancyResolved = "resolved"
+)
+
+// discrepancyListSpec pages the discrepancy queue oldest first (see album-store/listing)
+var discrepancyListSpec = listing.Spec{
+	Sortable:    []listing.Field{{Name: "createdAt", Expr: "created_at"}, {Name: "difference", Expr: "difference"}},
+	Filterable:  []listing.Field{{Name: "status", Expr: "status"}, {Name: "purchaseOrder", Expr: "purchase_order"}, {Name: "albumId", Expr: "album_id"}},
+	DefaultSort: []listing.SortKey{{Expr: "created_at"}},
+	Unique:      "id",
+}
+
+// ReceiveRequest is the body of POST /api/inventory/receive
+type ReceiveRequest struct {
+	PurchaseOrder string        `json:"purchaseOrder" binding:"required,max=64"`
+	Lines         []ReceiptLine `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReceiptLine is one album of a shipment. The stock fields are filled in by the response.
+type ReceiptLine struct {
+	AlbumID        string `json:"albumId" binding:"required,max=50"`
+	Expected       *int   `json:"expected" binding:"required,gte=0"`
+	Received       *int   `json:"received" binding:"required,gte=0"`
+	Note           string `json:"note,omitempty" binding:"max=500"`
+	QuantityBefore int    `json:"quantityBefore"`
+	QuantityAfter  int    `json:"quantityAfter"`
+	Discrepancy    bool   `json:"discrepancy"`
+}
+
+// Receipt is a received shipment
+type Receipt struct {
+	ID            int           `json:"id"`
+	PurchaseOrder string        `json:"purchaseOrder"`
+	ReceivedAt    time.Time     `json:"receivedAt"`
+	UnitsReceived int           `json:"unitsReceived"`
+	Discrepancies int           `json:"discrepancies"`
+	Lines         []ReceiptLine `json:"lines"`
+}
+
+// ReceivingDiscrepancy is a receipt line whose received quantity differs from the expected one
+type ReceivingDiscrepancy struct {
+	ID            int        `json:"id"`
+	ReceiptID     int        `json:"receiptId"`
+	PurchaseOrder string     `json:"purchaseOrder"`
+	AlbumID       string     `json:"albumId"`
+	Expected      int        `json:"expected"`
+	Received      int        `json:"received"`
+	Difference    int        `json:"difference"` // Received minus expected: negative for a shortage
+	Note          string     `json:"note,omitempty"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ResolvedAt    *time.Time `json:"resolvedAt,omitempty"`
+	Resolution    string     `json:"resolution,omitempty"`
+}
+
+var errAlbumRemoved = errors.New("album was removed from the catalog")
+
+// receiveShipment handles POST /api/inventory/receive
+func receiveShipment(c *gin.Context) {
+	var req ReceiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	if len(req.Lines) > maxReceiptLines {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A receipt has at most %d lines", maxReceiptLines)})
+		return
+	}
+	seen := make(map[string]bool, len(req.Lines))
+	for _, line := range req.Lines {
+		if seen[line.AlbumID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Album " + line.AlbumID + " is listed twice"})
+			return
+		}
+		seen[line.AlbumID] = true
+	}
+
+	receipt, err := recordReceipt(c.Request.Context(), req, c.ClientIP())
+	if errors.Is(err, errAlbumRemoved) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record receipt: " + err.Error()})
+		return
+	}
+
+	log.Printf("Receipt %d for purchase order %s: %d units in %d lines, %d discrepancies",
+		receipt.ID, receipt.PurchaseOrder, receipt.UnitsReceived, len(receipt.Lines), receipt.Discrepancies)
+	c.JSON(http.StatusCreated, receipt)
+}
+
+// recordReceipt adds the received quantities to stock and records the receipt, its lines, the
+// stock adjustments and the discrepancies, all in one transaction
+func recordReceipt(ctx context.Context, req ReceiveRequest, clientIP string) (Receipt, error) {
+	receipt := Receipt{PurchaseOrder: req.PurchaseOrder, Lines: req.Lines}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return receipt, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO inventory_receipts (purchase_order, client_ip) VALUES ($1, $2) RETURNING id, received_at",
+		req.PurchaseOrder, clientIP).Scan(&receipt.ID, &receipt.ReceivedAt)
+	if err != nil {
+		return receipt, err
+	}
+
+	for i := range receipt.Lines {
+		line := &receipt.Lines[i]
+		var removed bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM inventory_archive WHERE album_id = $1)", line.AlbumID).Scan(&removed)
+		if err != nil {
+			return receipt, fmt.Errorf("check album %s: %w", line.AlbumID, err)
+		}
+		if removed {
+			return receipt, fmt.Errorf("album %s: %w", line.AlbumID, errAlbumRemoved)
+		}
+
+		err = tx.QueryRowContext(ctx, "SELECT quantity_available FROM inventory WHERE album_id = $1 FOR UPDATE", line.AlbumID).Scan(&line.QuantityBefore)
+		if err != nil && err != sql.ErrNoRows {
+			return receipt, fmt.Errorf("read stock of album %s: %w", line.AlbumID, err)
+		}
+		line.QuantityAfter = line.QuantityBefore + *line.Received
+
+		if *line.Received > 0 {
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO inventory (album_id, quantity_available, last_updated, last_received_at)
+				 VALUES ($1, $2, NOW(), NOW())
+				 ON CONFLICT (album_id) DO UPDATE SET quantity_available = $2, last_updated = NOW(), last_received_at = NOW()`,
+				line.AlbumID, line.QuantityAfter)
+			if err != nil {
+				return receipt, fmt.Errorf("update stock of album %s: %w", line.AlbumID, err)
+			}
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO inventory_adjustments (album_id, quantity_before, quantity_after, source, reference, client_ip)
+				 VALUES ($1, $2, $3, 'receiving', $4, $5)`,
+				line.AlbumID, line.QuantityBefore, line.QuantityAfter, req.PurchaseOrder, clientIP)
+			if err != nil {
+				return receipt, fmt.Errorf("record adjustment of album %s: %w", line.AlbumID, err)
+			}
+			receipt.UnitsReceived += *line.Received
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO inventory_receipt_lines (receipt_id, album_id, expected, received, note) VALUES ($1, $2, $3, $4, NULLIF($5, ''))",
+			receipt.ID, line.AlbumID, *line.Expected, *line.Received, line.Note)
+		if err != nil {
+			return receipt, fmt.Errorf("record line of album %s: %w", line.AlbumID, err)
+		}
+
+		if *line.Received != *line.Expected {
+			line.Discrepancy = true
+			receipt.Discrepancies++
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO receiving_discrepancies (receipt_id, purchase_order, album_id, expected, received, difference, note)
+				 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+				receipt.ID, req.PurchaseOrder, line.AlbumID, *line.Expected, *line.Received, *line.Received-*line.Expected, line.Note)
+			if err != nil {
+				return receipt, fmt.Errorf("record discrepancy of album %s: %w", line.AlbumID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return receipt, err
+	}
+	var received []string
+	for _, line := range receipt.Lines {
+		if *line.Received > 0 {
+			received = append(received, line.AlbumID)
+		}
+	}
+	invalidateInventory(ctx, received...)
+	return receipt, nil
+}
+
+// getReceivingDiscrepancies handles GET /api/admin/inventory/discrepancies: the open discrepancies
+// oldest first by default; ?status=resolved lists the resolved ones
+func getReceivingDiscrepancies(c *gin.Context) {
+	ctx := c.Request.Context()
+	page, err := listing.ParseParams(c, discrepancyListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("status") == "" {
+		page.Filters = append(page.Filters, listing.Filter{Expr: "status", Values: []string{discrepancyOpen}})
+	}
+
+	var args []interface{}
+	where := listing.WhereClause(page.Conditions(&args))
+	countArgs := args
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, receipt_id, purchase_order, album_id, expected, received, difference, COALESCE(note, ''), status, created_at, resolved_at, COALESCE(resolution, '') FROM receiving_discrepancies"+
+			where+page.OrderByClause()+page.PageClause(&args),
+		args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query discrepancies: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	discrepancies := []ReceivingDiscrepancy{}
+	for rows.Next() {
+		var d ReceivingDiscrepancy
+		if err := rows.Scan(&d.ID, &d.ReceiptID, &d.PurchaseOrder, &d.AlbumID, &d.Expected, &d.Received, &d.Difference, &d.Note, &d.Status, &d.CreatedAt, &d.ResolvedAt, &d.Resolution); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read discrepancies: " + err.Error()})
+			return
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read discrepancies: " + err.Error()})
+		return
+	}
+
+	meta, err := page.Meta(len(discrepancies), func() (int, error) {
+		var total int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM receiving_discrepancies"+where, countArgs...).Scan(&total)
+		return total, err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count discrepancies: " + err.Error()})
+		return
+	}
+	meta.SetHeaders(c)
+	c.JSON(http.StatusOK, discrepancies)
+}
+
+// resolveReceivingDiscrepancy handles POST /api/admin/inventory/discrepancies/:discrepancyId/resolve
+// with {"resolution": ...}, e.g. what the supplier agreed to. Stock isn't changed: corrections go
+// through PUT /api/inventory/:albumId or a new receipt.
+func resolveReceivingDiscrepancy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("discrepancyId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Discrepancy not found"})
+		return
+	}
+	var req struct {
+		Resolution string `json:"resolution" binding:"required,max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+
+	var d ReceivingDiscrepancy
+	err = db.QueryRowContext(c.Request.Context(), `
+		UPDATE receiving_discrepancies SET status = 'resolved', resolved_at = NOW(), resolved_by = $2, resolution = $3
+		WHERE id = $1 AND status = 'open'
+		RETURNING id, receipt_id, purchase_order, album_id, expected, received, difference, COALESCE(note, ''), status, created_at, resolved_at, resolution`,
+		id, c.ClientIP(), req.Resolution).
+		Scan(&d.ID, &d.ReceiptID, &d.PurchaseOrder, &d.AlbumID, &d.Expected, &d.Received, &d.Difference, &d.Note, &d.Status, &d.CreatedAt, &d.ResolvedAt, &d.Resolution)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRowContext(c.Request.Context(), "SELECT EXISTS (SELECT 1 FROM receiving_discrepancies WHERE id = $1)", id).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Discrepancy is already resolved"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Discrepancy not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve discrepancy: " + err.Error()})
+		return
+	}
+	log.Printf("Receiving discrepancy %d resolved by %s", d.ID, c.ClientIP())
+	c.JSON(http.StatusOK, d)
+}
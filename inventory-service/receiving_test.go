@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveShipment(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/inventory/receive", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectAlbum := func(albumID string, removed bool) {
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM inventory_archive WHERE album_id = \\$1\\)").WithArgs(albumID).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(removed))
+	}
+
+	t.Run("Applies the received quantities and flags discrepancies", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO inventory_receipts").WithArgs("PO-2024-0042", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "received_at"}).AddRow(5, time.Now()))
+
+		expectAlbum("7", false)
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id = \\$1 FOR UPDATE").WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}).AddRow(3))
+		mock.ExpectExec("INSERT INTO inventory \\(album_id").WithArgs("7", 13).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO inventory_adjustments").WithArgs("7", 3, 13, "PO-2024-0042", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO inventory_receipt_lines").WithArgs(5, "7", 10, 10, "").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		expectAlbum("9", false)
+		mock.ExpectQuery("SELECT quantity_available FROM inventory WHERE album_id = \\$1 FOR UPDATE").WithArgs("9").
+			WillReturnRows(sqlmock.NewRows([]string{"quantity_available"}))
+		mock.ExpectExec("INSERT INTO inventory \\(album_id").WithArgs("9", 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO inventory_adjustments").WithArgs("9", 0, 4, "PO-2024-0042", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec("INSERT INTO inventory_receipt_lines").WithArgs(5, "9", 6, 4, "2 sleeves crushed").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO receiving_discrepancies").WithArgs(5, "PO-2024-0042", "9", 6, 4, -2, "2 sleeves crushed").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		rr := post(`{"purchaseOrder":"PO-2024-0042","lines":[
+			{"albumId":"7","expected":10,"received":10},
+			{"albumId":"9","expected":6,"received":4,"note":"2 sleeves crushed"}]}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var receipt Receipt
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &receipt))
+		assert.Equal(t, 5, receipt.ID)
+		assert.Equal(t, 14, receipt.UnitsReceived)
+		assert.Equal(t, 1, receipt.Discrepancies)
+		require.Len(t, receipt.Lines, 2)
+		assert.Equal(t, 13, receipt.Lines[0].QuantityAfter)
+		assert.False(t, receipt.Lines[0].Discrepancy)
+		assert.True(t, receipt.Lines[1].Discrepancy)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing is received for a deleted album", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO inventory_receipts").WillReturnRows(sqlmock.NewRows([]string{"id", "received_at"}).AddRow(6, time.Now()))
+		expectAlbum("8", true)
+		mock.ExpectRollback()
+
+		rr := post(`{"purchaseOrder":"PO-2024-0043","lines":[{"albumId":"8","expected":2,"received":2}]}`)
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid receipts", func(t *testing.T) {
+		for _, body := range []string{
+			`{"lines":[{"albumId":"7","expected":1,"received":1}]}`,
+			`{"purchaseOrder":"PO-1","lines":[]}`,
+			`{"purchaseOrder":"PO-1","lines":[{"albumId":"7","expected":1}]}`,
+			`{"purchaseOrder":"PO-1","lines":[{"albumId":"7","expected":1,"received":-1}]}`,
+			`{"purchaseOrder":"PO-1","lines":[{"albumId":"7","expected":1,"received":1},{"albumId":"7","expected":2,"received":2}]}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, post(body).Code, body)
+		}
+	})
+}
+
+func TestReceivingDiscrepancies(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	columns := []string{"id", "receipt_id", "purchase_order", "album_id", "expected", "received", "difference", "note", "status", "created_at", "resolved_at", "resolution"}
+	created := time.Now().Add(-time.Hour)
+
+	t.Run("Lists the open discrepancies by default", func(t *testing.T) {
+		mock.ExpectQuery("FROM receiving_discrepancies WHERE status IN \\(\\$1\\) ORDER BY created_at ASC, id ASC").WithArgs(discrepancyOpen, 50, 0).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, 5, "PO-2024-0042", "9", 6, 4, -2, "2 sleeves crushed", discrepancyOpen, created, nil, ""))
+
+		rr := send("GET", "/api/admin/inventory/discrepancies?limit=50", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var discrepancies []ReceivingDiscrepancy
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &discrepancies))
+		require.Len(t, discrepancies, 1)
+		assert.Equal(t, -2, discrepancies[0].Difference)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Resolve", func(t *testing.T) {
+		resolved := time.Now()
+		mock.ExpectQuery("UPDATE receiving_discrepancies SET status = 'resolved'").WithArgs(3, sqlmock.AnyArg(), "Supplier credited 2 units").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, 5, "PO-2024-0042", "9", 6, 4, -2, "", discrepancyResolved, created, resolved, "Supplier credited 2 units"))
+
+		rr := send("POST", "/api/admin/inventory/discrepancies/3/resolve", `{"resolution":"Supplier credited 2 units"}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"status":"resolved"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Resolving twice", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE receiving_discrepancies SET status = 'resolved'").WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM receiving_discrepancies WHERE id = \\$1\\)").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		assert.Equal(t, http.StatusConflict, send("POST", "/api/admin/inventory/discrepancies/3/resolve", `{"resolution":"Again"}`).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
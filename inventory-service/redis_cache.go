@@ -0,0 +1,129 @@
+// redis_cache.go - optional Redis read-through cache of GET /api/inventory/:albumId, which product
+// pages call for every view. It is enabled by REDIS_URL. Only initialized records are cached, so an
+// album whose album-created event is processed later is seen at once, and creating a record never has
+// an entry to invalidate. Every other write to a record deletes its entry after the commit.
+// REDIS_CACHE_TTL (default 10m) bounds how long an entry can outlive a write that raced with its read.
+// Redis errors are logged and the lookup falls back to the database.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisCacheTTL    = 10 * time.Minute
+	redisTimeout            = 2 * time.Second
+	inventoryCacheKeyPrefix = "inventory-service:inventory:"
+)
+
+// Cache lookup results, the values of the result label of inventory_cache_lookups_total
+const (
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheError = "error" // Redis failed; the record was read from the database
+)
+
+// inventoryCache is nil unless REDIS_URL is set
+var (
+	inventoryCache    *redis.Client
+	inventoryCacheTTL = defaultRedisCacheTTL
+)
+
+// loadRedisCache connects the inventory cache when REDIS_URL is set. An unreachable Redis isn't an
+// error: lookups use the database until it is back.
+func loadRedisCache() error {
+	v := os.Getenv("REDIS_URL")
+	if v == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(v)
+	if err != nil {
+		return fmt.Errorf("REDIS_URL: %w", err)
+	}
+	ttl := defaultRedisCacheTTL
+	if v := os.Getenv("REDIS_CACHE_TTL"); v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return fmt.Errorf("REDIS_CACHE_TTL %q must be a positive duration such as 10m", v)
+		}
+	}
+	opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout = redisTimeout, redisTimeout, redisTimeout
+	inventoryCache, inventoryCacheTTL = redis.NewClient(opts), ttl
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := inventoryCache.Ping(ctx).Err(); err != nil {
+		log.Printf("Redis at %s is unreachable, inventory lookups use the database until it is back: %v", opts.Addr, err)
+	} else {
+		log.Printf("Caching inventory lookups in Redis at %s for %s", opts.Addr, ttl)
+	}
+	return nil
+}
+
+func inventoryCacheKey(albumID string) string {
+	return inventoryCacheKeyPrefix + albumID
+}
+
+// findInventory reads the inventory record of an album, returning sql.ErrNoRows when it has none
+func findInventory(ctx context.Context, albumID string) (Inventory, error) {
+	var i Inventory
+	err := readerDB(ctx).QueryRowContext(ctx, "SELECT album_id, quantity_available, last_updated, low_stock_threshold FROM inventory WHERE album_id = $1", albumID).
+		Scan(&i.AlbumID, &i.QuantityAvailable, &i.LastUpdated, &i.LowStockThreshold)
+	i.Initialized = err == nil
+	return i, err
+}
+
+// findInventoryCached is findInventory through the cache
+func findInventoryCached(ctx context.Context, albumID string) (Inventory, error) {
+	if inventoryCache == nil {
+		return findInventory(ctx, albumID)
+	}
+	data, err := inventoryCache.Get(ctx, inventoryCacheKey(albumID)).Bytes()
+	if err == nil {
+		var i Inventory
+		if err = json.Unmarshal(data, &i); err == nil {
+			inventoryCacheLookups.WithLabelValues(cacheHit).Inc()
+			return i, nil
+		}
+	}
+	if errors.Is(err, redis.Nil) {
+		inventoryCacheLookups.WithLabelValues(cacheMiss).Inc()
+	} else {
+		inventoryCacheLookups.WithLabelValues(cacheError).Inc()
+		log.Printf("Inventory cache lookup of album %s failed: %v", albumID, err)
+	}
+
+	i, err := findInventory(ctx, albumID)
+	if err != nil {
+		return i, err
+	}
+	if data, err := json.Marshal(i); err == nil {
+		if err := inventoryCache.Set(ctx, inventoryCacheKey(albumID), data, inventoryCacheTTL).Err(); err != nil {
+			log.Printf("Inventory cache store of album %s failed: %v", albumID, err)
+		}
+	}
+	return i, nil
+}
+
+// invalidateInventory deletes the cache entries of these albums. Call it after the transaction
+// changing their records commits, or a lookup in between caches the old record again.
+func invalidateInventory(ctx context.Context, albumIDs ...string) {
+	if inventoryCache == nil || len(albumIDs) == 0 {
+		return
+	}
+	keys := make([]string, len(albumIDs))
+	for i, id := range albumIDs {
+		keys[i] = inventoryCacheKey(id)
+	}
+	if err := inventoryCache.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Inventory cache invalidation of %v failed: %v", albumIDs, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRedisCache(t *testing.T) {
+	t.Cleanup(func() { inventoryCache, inventoryCacheTTL = nil, defaultRedisCacheTTL })
+
+	require.NoError(t, loadRedisCache())
+	assert.Nil(t, inventoryCache, "disabled unless configured")
+
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+server.Addr()+"/0")
+	t.Setenv("REDIS_CACHE_TTL", "30s")
+	require.NoError(t, loadRedisCache())
+	require.NotNil(t, inventoryCache)
+	assert.Equal(t, 30*time.Second, inventoryCacheTTL)
+
+	t.Setenv("REDIS_CACHE_TTL", "0s")
+	assert.ErrorContains(t, loadRedisCache(), "REDIS_CACHE_TTL")
+	t.Setenv("REDIS_URL", "localhost:6379")
+	assert.ErrorContains(t, loadRedisCache(), "REDIS_URL")
+}
+
+func TestInventoryCache(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	server := miniredis.RunT(t)
+	originalDB := db
+	db, inventoryCache = mockDB, redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { db, inventoryCache = originalDB, nil })
+
+	columns := []string{"album_id", "quantity_available", "last_updated", "low_stock_threshold"}
+	expectRecord := func(albumID string, quantity int) {
+		mock.ExpectQuery("FROM inventory WHERE album_id = \\$1").WithArgs(albumID).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(albumID, quantity, time.Now(), nil))
+	}
+	get := func(albumID string) Inventory {
+		req, _ := http.NewRequest("GET", "/api/inventory/"+albumID, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var inv Inventory
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &inv))
+		return inv
+	}
+	hits := inventoryCacheLookups.WithLabelValues(cacheHit)
+
+	t.Run("The second lookup is served from Redis", func(t *testing.T) {
+		expectRecord("42", 10)
+		assert.Equal(t, 10, get("42").QuantityAvailable)
+		assert.Equal(t, inventoryCacheTTL, server.TTL(inventoryCacheKey("42")))
+
+		before := testutil.ToFloat64(hits)
+		inv := get("42")
+		assert.Equal(t, 10, inv.QuantityAvailable)
+		assert.True(t, inv.Initialized)
+		assert.Equal(t, before+1, testutil.ToFloat64(hits))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Writes invalidate the entry", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO inventory").WithArgs("42", 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		req, _ := http.NewRequest("PUT", "/api/inventory/42", bytes.NewBufferString(`{"quantityAvailable": 3}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.False(t, server.Exists(inventoryCacheKey("42")))
+
+		expectRecord("42", 3)
+		assert.Equal(t, 3, get("42").QuantityAvailable)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Uninitialized albums aren't cached", func(t *testing.T) {
+		mock.ExpectQuery("FROM inventory WHERE album_id = \\$1").WithArgs("7").WillReturnRows(sqlmock.NewRows(columns))
+		assert.False(t, get("7").Initialized)
+		assert.False(t, server.Exists(inventoryCacheKey("7")))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Lookups fall back to the database when Redis is down", func(t *testing.T) {
+		server.Close()
+		expectRecord("42", 3)
+		assert.Equal(t, 3, get("42").QuantityAvailable)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
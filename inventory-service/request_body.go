@@ -0,0 +1,75 @@
+// request_body.go - request body size limit. Bodies over MAX_REQUEST_BODY_BYTES (default 1 MiB) are
+// rejected with 413: up front when Content-Length announces them, otherwise when reading passes the
+// limit. Uploads have their own, larger limits (uploadBodyLimits).
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxRequestBodyBytes = 1 << 20
+
+// maxRequestBodyBytes is the largest body accepted, from MAX_REQUEST_BODY_BYTES
+var maxRequestBodyBytes int64 = defaultMaxRequestBodyBytes
+
+// uploadBodyLimits are the limits of the routes taking file uploads. Their handlers enforce the limit
+// themselves.
+var uploadBodyLimits = map[string]int64{
+	"/api/inventory/import": maxInventoryImportBytes,
+}
+
+// loadRequestBodyLimit reads MAX_REQUEST_BODY_BYTES
+func loadRequestBodyLimit() error {
+	v := os.Getenv("MAX_REQUEST_BODY_BYTES")
+	if v == "" {
+		maxRequestBodyBytes = defaultMaxRequestBodyBytes
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("MAX_REQUEST_BODY_BYTES %q is not a positive number of bytes", v)
+	}
+	maxRequestBodyBytes = n
+	return nil
+}
+
+// limitRequestBody rejects bodies larger than the route's limit
+func limitRequestBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := uploadBodyLimits[c.FullPath()]
+		if !ok {
+			limit = maxRequestBodyBytes
+		}
+		if c.Request.ContentLength > limit {
+			respondBodyTooLarge(c, limit)
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// respondBodyTooLarge answers 413
+func respondBodyTooLarge(c *gin.Context, limit int64) {
+	c.Header("Connection", "close")
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body too large, at most %d bytes", limit)})
+}
+
+// respondInvalidBody answers a request whose body could not be bound: 413 when it was over its size
+// limit, 400 otherwise
+func respondInvalidBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondBodyTooLarge(c, tooLarge.Limit)
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+}
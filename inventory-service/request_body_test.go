@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	original := maxRequestBodyBytes
+	maxRequestBodyBytes = 64
+	t.Cleanup(func() { maxRequestBodyBytes = original })
+
+	body := `{"items": [` + strings.Repeat(`{"albumId": "1", "quantity": 1},`, 5) + `{"albumId": "1", "quantity": 1}]}`
+	send := func(path string, r io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, r)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Announced by Content-Length", func(t *testing.T) {
+		rr := send("/api/inventory/check", strings.NewReader(body))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Contains(t, rr.Body.String(), "at most 64 bytes")
+	})
+
+	t.Run("Found while reading", func(t *testing.T) {
+		// Without a known length the body is sent chunked
+		rr := send("/api/inventory/check", io.MultiReader(strings.NewReader(body)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("Uploads have their own limit", func(t *testing.T) {
+		rr := send("/api/inventory/import?mode=set", strings.NewReader("album_id,quantity\n"+strings.Repeat(" ", 100)))
+		assert.NotEqual(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+}
+
+func TestLoadRequestBodyLimit(t *testing.T) {
+	t.Cleanup(func() { maxRequestBodyBytes = defaultMaxRequestBodyBytes })
+
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "4096")
+	require.NoError(t, loadRequestBodyLimit())
+	assert.Equal(t, int64(4096), maxRequestBodyBytes)
+
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "")
+	require.NoError(t, loadRequestBodyLimit())
+	assert.Equal(t, int64(defaultMaxRequestBodyBytes), maxRequestBodyBytes)
+
+	for _, invalid := range []string{"0", "-1", "1MB"} {
+		t.Setenv("MAX_REQUEST_BODY_BYTES", invalid)
+		assert.Error(t, loadRequestBodyLimit(), invalid)
+	}
+}
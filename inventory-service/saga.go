@@ -0,0 +1,237 @@
+// saga.go - per-order saga log, so order processing resumes where it stopped after a crash instead of
+// re-running the deduction
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"album-store/events"
+	"github.com/gin-gonic/gin"
+)
+
+// Saga steps of an order. Stock is deducted directly, there is no separate reservation step.
+const (
+	sagaStepDeducted           = "deducted"            // Stock deducted; detail holds the order for the success event
+	sagaStepSucceededPublished = "succeeded_published" // order-succeeded published; the saga is complete
+	sagaStepFailed             = "failed"              // Order rejected; detail holds the failure reason
+	sagaStepFailedPublished    = "failed_published"    // order-failed published; the saga is complete
+)
+
+// Saga statuses reported by GET /api/orders/:orderId/saga
+const (
+	sagaStatusSucceeded            = "succeeded"
+	sagaStatusFailed               = "failed"
+	sagaStatusAwaitingSuccessEvent = "awaiting_success_event"
+	sagaStatusAwaitingFailureEvent = "awaiting_failure_event"
+)
+
+const (
+	defaultSagaRecoveryInterval = time.Minute
+	sagaRecoveryGrace           = 30 * time.Second // Leave sagas this young to the consumer processing them
+	sagaRecoveryBatch           = 100
+)
+
+// SagaStep is one recorded step of an order's saga
+type SagaStep struct {
+	Step       string    `json:"step"`
+	Detail     string    `json:"detail,omitempty"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// OrderSaga is the saga state of one order
+type OrderSaga struct {
+	OrderID string     `json:"orderId"`
+	Status  string     `json:"status"`
+	Steps   []SagaStep `json:"steps"`
+}
+
+// sagaExecer is satisfied by *sql.DB and *sql.Tx, so steps can be recorded inside the deduction
+// transaction
+type sagaExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordSagaStep records a step; it reports false when the step was already recorded
+func recordSagaStep(ctx context.Context, ex sagaExecer, orderID, step, detail string) (bool, error) {
+	res, err := ex.ExecContext(ctx,
+		"INSERT INTO saga_log (order_id, step, detail) VALUES ($1, $2, $3) ON CONFLICT (order_id, step) DO NOTHING",
+		orderID, step, detail)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// loadSagaSteps returns the recorded steps of an order in the order they were recorded
+func loadSagaSteps(ctx context.Context, db *sql.DB, orderID string) ([]SagaStep, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT step, detail, recorded_at FROM saga_log WHERE order_id = $1 ORDER BY recorded_at, step", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []SagaStep
+	for rows.Next() {
+		var s SagaStep
+		if err := rows.Scan(&s.Step, &s.Detail, &s.RecordedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+	return steps, rows.Err()
+}
+
+// findSagaStep returns the step if it was recorded
+func findSagaStep(steps []SagaStep, step string) (SagaStep, bool) {
+	for _, s := range steps {
+		if s.Step == step {
+			return s, true
+		}
+	}
+	return SagaStep{}, false
+}
+
+// sagaStatus summarizes recorded steps; empty when nothing was recorded
+func sagaStatus(steps []SagaStep) string {
+	has := func(step string) bool { _, ok := findSagaStep(steps, step); return ok }
+	switch {
+	case has(sagaStepSucceededPublished):
+		return sagaStatusSucceeded
+	case has(sagaStepFailedPublished):
+		return sagaStatusFailed
+	case has(sagaStepDeducted):
+		return sagaStatusAwaitingSuccessEvent
+	case has(sagaStepFailed):
+		return sagaStatusAwaitingFailureEvent
+	}
+	return ""
+}
+
+// resumeOrderSaga continues an order whose saga has steps: it publishes the outcome event that is
+// still missing, using the outcome recorded in the log. Stock is never deducted again.
+func resumeOrderSaga(ctx context.Context, db *sql.DB, orderID string, steps []SagaStep) error {
+	switch sagaStatus(steps) {
+	case sagaStatusAwaitingSuccessEvent:
+		deducted, _ := findSagaStep(steps, sagaStepDeducted)
+		order := &events.OrderCreated{}
+		if err := events.UnmarshalJSON([]byte(deducted.Detail), order); err != nil {
+			return fmt.Errorf("decode saga order %s: %w", orderID, err)
+		}
+		log.Printf("Resuming order %s: stock already deducted, publishing order-succeeded", orderID)
+		if err := sendOrderEvent(ctx, order, "", orderSucceededTopic); err != nil {
+			return err
+		}
+		_, err := recordSagaStep(ctx, db, orderID, sagaStepSucceededPublished, "")
+		return err
+	case sagaStatusAwaitingFailureEvent:
+		failed, _ := findSagaStep(steps, sagaStepFailed)
+		log.Printf("Resuming order %s: already rejected (%s), publishing order-failed", orderID, failed.Detail)
+		if err := sendOrderEvent(ctx, &events.OrderCreated{OrderId: orderID}, failed.Detail, orderFailedTopic); err != nil {
+			return err
+		}
+		_, err := recordSagaStep(ctx, db, orderID, sagaStepFailedPublished, "")
+		return err
+	default:
+		log.Printf("Order %s already processed (%s), skipping", orderID, sagaStatus(steps))
+		return nil
+	}
+}
+
+// failOrder records the rejection in the saga log, then publishes order-failed. If the log can't be
+// written the event is still published, so the order service always learns the outcome.
+func failOrder(ctx context.Context, db *sql.DB, order *events.OrderCreated, reason string) error {
+	if _, err := recordSagaStep(ctx, db, order.OrderId, sagaStepFailed, reason); err != nil {
+		log.Printf("Failed to record saga step %s for order %s: %v", sagaStepFailed, order.OrderId, err)
+	}
+	if err := sendOrderFailedEvent(ctx, order, reason); err != nil {
+		return err
+	}
+	_, err := recordSagaStep(ctx, db, order.OrderId, sagaStepFailedPublished, "")
+	return err
+}
+
+// startSagaRecovery periodically resumes sagas whose outcome event was never published, for example
+// because the service crashed between committing the deduction and publishing
+func startSagaRecovery() {
+	interval := defaultSagaRecoveryInterval
+	if v := os.Getenv("SAGA_RECOVERY_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid SAGA_RECOVERY_INTERVAL %q, using default %s", v, defaultSagaRecoveryInterval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := recoverIncompleteSagas(context.Background(), db, time.Now().Add(-sagaRecoveryGrace)); err != nil {
+				log.Printf("Saga recovery failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// recoverIncompleteSagas resumes sagas with an outcome recorded before olderThan but not published
+func recoverIncompleteSagas(ctx context.Context, db *sql.DB, olderThan time.Time) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.order_id FROM saga_log s
+		WHERE s.step IN ($1, $2) AND s.recorded_at < $3
+		  AND NOT EXISTS (SELECT 1 FROM saga_log p WHERE p.order_id = s.order_id AND p.step IN ($4, $5))
+		ORDER BY s.recorded_at LIMIT $6`,
+		sagaStepDeducted, sagaStepFailed, olderThan, sagaStepSucceededPublished, sagaStepFailedPublished, sagaRecoveryBatch)
+	if err != nil {
+		return err
+	}
+	var orderIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range orderIDs {
+		steps, err := loadSagaSteps(ctx, db, id)
+		if err != nil {
+			return err
+		}
+		if err := resumeOrderSaga(ctx, db, id, steps); err != nil {
+			log.Printf("Failed to resume saga of order %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// getOrderSaga handles GET /api/orders/:orderId/saga (admin)
+func getOrderSaga(c *gin.Context) {
+	orderID := c.Param("orderId")
+	steps, err := loadSagaSteps(c.Request.Context(), db, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if len(steps) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No saga recorded for order"})
+		return
+	}
+	c.JSON(http.StatusOK, OrderSaga{OrderID: orderID, Status: sagaStatus(steps), Steps: steps})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"album-store/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sagaColumns = []string{"step", "detail", "recorded_at"}
+
+func TestProcessOrderCreated_ResumesFromSagaLog(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	t.Run("Redelivered deducted order publishes success without deducting again", func(t *testing.T) {
+		failed, succeeded := useRecordingWriters(t)
+		order := &events.OrderCreated{OrderId: "401", AlbumId: "album-4", Quantity: 2}
+		detail, _ := events.MarshalJSON(order)
+
+		mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").WithArgs("401").
+			WillReturnRows(sqlmock.NewRows(sagaColumns).AddRow(sagaStepDeducted, string(detail), time.Now()))
+		expectSagaStep(mock, "401", sagaStepSucceededPublished)
+
+		assert.NoError(t, processOrderCreated(mockDB, orderMessage(t, order)))
+		assert.NoError(t, mock.ExpectationsWereMet(), "stock must not be touched")
+		assert.Len(t, failed.messages, 0)
+		if assert.Len(t, succeeded.messages, 1) {
+			var event events.OrderSucceeded
+			require.NoError(t, events.UnmarshalJSON(succeeded.messages[0].Value, &event))
+			assert.Equal(t, "album-4", event.AlbumId)
+			assert.Equal(t, int32(2), event.Quantity)
+		}
+	})
+
+	t.Run("Completed saga is skipped", func(t *testing.T) {
+		failed, succeeded := useRecordingWriters(t)
+		mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").WithArgs("402").
+			WillReturnRows(sqlmock.NewRows(sagaColumns).
+				AddRow(sagaStepFailed, failureReasonInsufficientInventory, time.Now()).
+				AddRow(sagaStepFailedPublished, "", time.Now()))
+
+		assert.NoError(t, processOrderCreated(mockDB, orderMessage(t, &events.OrderCreated{OrderId: "402", AlbumId: "album-4", Quantity: 1})))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, failed.messages, 0)
+		assert.Len(t, succeeded.messages, 0)
+	})
+}
+
+func TestRecoverIncompleteSagas(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	failed, _ := useRecordingWriters(t)
+
+	mock.ExpectQuery("SELECT s.order_id FROM saga_log s").
+		WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow("501"))
+	mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").WithArgs("501").
+		WillReturnRows(sqlmock.NewRows(sagaColumns).AddRow(sagaStepFailed, failureReasonVelocityLimitExceeded, time.Now()))
+	expectSagaStep(mock, "501", sagaStepFailedPublished)
+
+	require.NoError(t, recoverIncompleteSagas(context.Background(), mockDB, time.Now()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, failed.messages, 1) {
+		assert.Equal(t, failureReasonVelocityLimitExceeded, failedReason(t, failed.messages[0]))
+	}
+}
+
+func TestGetOrderSaga(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(orderID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/orders/"+orderID+"/saga", nil)
+		req.Header.Set("Client-Type", "admin")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Unknown order", func(t *testing.T) {
+		mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").WithArgs("601").
+			WillReturnRows(sqlmock.NewRows(sagaColumns))
+		assert.Equal(t, http.StatusNotFound, get("601").Code)
+	})
+
+	t.Run("Order awaiting its success event", func(t *testing.T) {
+		mock.ExpectQuery("SELECT step, detail, recorded_at FROM saga_log").WithArgs("602").
+			WillReturnRows(sqlmock.NewRows(sagaColumns).AddRow(sagaStepDeducted, `{"orderId":"602"}`, time.Now()))
+
+		rr := get("602")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var saga OrderSaga
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &saga))
+		assert.Equal(t, sagaStatusAwaitingSuccessEvent, saga.Status)
+		if assert.Len(t, saga.Steps, 1) {
+			assert.Equal(t, sagaStepDeducted, saga.Steps[0].Step)
+		}
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,263 @@
+// selfcheck.go - --check mode: validate configuration and dependencies, print a report and exit
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"album-store/kafkawriter"
+	"album-store/redaction"
+	"album-store/topics"
+)
+
+// inventorySchema lists the tables and columns inventory-service relies on
+var inventorySchema = map[string][]string{
+	"inventory":                 {"album_id", "quantity_available", "last_updated", "low_stock_threshold", "last_received_at", "last_sold_at"},
+	"processed_orders":          {"order_id", "processed_at"},
+	"album_velocity_limits":     {"album_id", "max_units_per_user", "max_units_total", "window_seconds"},
+	"order_velocity":            {"order_id", "album_id", "user_id", "quantity", "created_at"},
+	"inventory_archive":         {"album_id", "quantity_available", "low_stock_threshold", "archived_at"},
+	"saga_log":                  {"order_id", "step", "detail", "recorded_at"},
+	"album_identifiers":         {"album_id", "upc", "catalog_number", "last_updated"},
+	"inventory_imports":         {"id", "mode", "items", "created_at", "confirmed_at"},
+	"inventory_adjustments":     {"id", "album_id", "quantity_before", "quantity_after", "source", "reference", "client_ip", "created_at"},
+	"inventory_receipts":        {"id", "purchase_order", "client_ip", "received_at"},
+	"inventory_receipt_lines":   {"receipt_id", "album_id", "expected", "received", "note"},
+	"receiving_discrepancies":   {"id", "receipt_id", "purchase_order", "album_id", "expected", "received", "difference", "note", "status", "created_at", "resolved_at", "resolved_by", "resolution"},
+	"inventory_publish_retries": {"id", "topic", "message_key", "message_value", "headers", "attempts", "saved_at"},
+}
+
+// runSelfCheck runs every check, prints the report to out and returns the process exit code
+func runSelfCheck(out io.Writer) int {
+	report := &selfCheckReport{}
+
+	// Configuration
+	report.check("config: kafka topic naming", topics.LoadNaming(),
+		fmt.Sprintf("prefix=%q suffix=%q", topics.Prefix(), topics.Suffix()))
+	producerConfig, err := loadKafkaProducerConfig()
+	report.check("config: kafka producer", err, producerConfig.String())
+	_, err = redaction.NewRedactorFromEnv()
+	report.check("config: span redaction", err, "")
+	report.check("config: event schema versions", loadEventSchemaConfig(),
+		fmt.Sprintf("publish %v, consume v%d", eventPublishVersions, eventConsumeVersion))
+	warehouseID := localWarehouseID
+	if v := os.Getenv("INVENTORY_WAREHOUSE_ID"); v != "" {
+		warehouseID = v
+	}
+	report.record("config: warehouse", selfCheckOK, warehouseID)
+	report.check("config: ALBUM_CREATED_BACKLOG_WARN_MESSAGES", checkPositiveIntEnv("ALBUM_CREATED_BACKLOG_WARN_MESSAGES"), "")
+	report.check("config: ALBUM_CREATED_BACKLOG_WARN_AGE", checkDurationEnv("ALBUM_CREATED_BACKLOG_WARN_AGE"), "")
+	report.check("config: SAGA_RECOVERY_INTERVAL", checkDurationEnv("SAGA_RECOVERY_INTERVAL"), "")
+	poolConfig, err := loadDBPoolConfig()
+	report.check("config: database pool", err, poolConfig.String())
+	report.check("config: migrations", loadMigrationConfig(), fmt.Sprintf("on startup=%t", migrateOnStartup))
+	report.check("config: request body limit", loadRequestBodyLimit(), fmt.Sprintf("%d bytes", maxRequestBodyBytes))
+	report.check("config: schema registry", loadSchemaRegistry(), fmt.Sprintf("set=%t", schemaRegistry != nil))
+	report.check("config: publish retries", loadPublishRetryConfig(), fmt.Sprintf("buffer of %d events", publishRetries.size))
+	report.check("config: low-stock webhook", loadLowStockWebhookConfig(), fmt.Sprintf("set=%t", lowStockWebhookURL != ""))
+	columns, err := loadStockColumns()
+	report.check("config: INVENTORY_IMPORT_COLUMNS", err,
+		fmt.Sprintf("upc=%q catalogNumber=%q quantity=%q", columns.UPC, columns.CatalogNumber, columns.Quantity))
+
+	// Database and schema
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	database, err := sql.Open("pgx", dbConnectionFromEnv())
+	if err == nil {
+		defer database.Close()
+		err = database.PingContext(ctx)
+	}
+	report.check("database", err, "connected")
+	if connStr := os.Getenv("DB_READ_CONNECTION"); connStr != "" {
+		// Lookups fall back to the primary, so an unreachable replica is only a warning
+		replica, err := sql.Open("pgx", connStr)
+		if err == nil {
+			defer replica.Close()
+			err = replica.PingContext(ctx)
+		}
+		if err != nil {
+			report.record("database replica", selfCheckWarn, "unreachable, lookups use the primary: "+err.Error())
+		} else {
+			report.record("database replica", selfCheckOK, "connected")
+		}
+	}
+	if err != nil {
+		report.record("schema migrations", selfCheckSkip, "database unavailable")
+		report.record("schema", selfCheckSkip, "database unavailable")
+	} else {
+		if version, pending, err := migrationState(ctx, database); err != nil {
+			report.check("schema migrations", err, "")
+		} else if len(pending) > 0 {
+			report.record("schema migrations", selfCheckWarn, fmt.Sprintf("at %d, pending: %s", version, strings.Join(pending, ", ")))
+		} else {
+			report.record("schema migrations", selfCheckOK, fmt.Sprintf("at %d, up to date", version))
+		}
+		if missing, err := missingSchema(ctx, database, inventorySchema); err != nil {
+			report.check("schema", err, "")
+		} else if len(missing) > 0 {
+			report.record("schema", selfCheckWarn, "missing, added by a pending migration or dropped by hand: "+strings.Join(missing, ", "))
+		} else {
+			report.record("schema", selfCheckOK, "up to date")
+		}
+	}
+
+	// Kafka topics this service consumes from and produces to
+	broker := kafkaBrokerFromEnv()
+	bases := []string{versionedTopic(orderCreatedTopic, eventConsumeVersion), versionedTopic(albumCreatedTopic, eventConsumeVersion),
+		versionedTopic(albumDeletedTopic, eventConsumeVersion)}
+	for _, version := range eventPublishVersions {
+		bases = append(bases, versionedTopic(orderFailedTopic, version), versionedTopic(orderSucceededTopic, version),
+			versionedTopic(lowStockTopic, version))
+	}
+	for _, base := range bases {
+		topic := topics.Name(base)
+		topicCtx, topicCancel := context.WithTimeout(context.Background(), kafkawriter.HealthCheckTimeout)
+		report.check(fmt.Sprintf("kafka topic '%s'", topic), kafkawriter.CheckTopic(topicCtx, broker, topic), "exists on "+broker)
+		topicCancel()
+	}
+
+	report.print(out, "inventory-service")
+	if report.failed() {
+		return 1
+	}
+	return 0
+}
+
+// --- Report ---
+
+const selfCheckTimeout = 5 * time.Second
+
+type selfCheckStatus string
+
+const (
+	selfCheckOK   selfCheckStatus = "OK"
+	selfCheckWarn selfCheckStatus = "WARN" // Reported, but doesn't fail the check
+	selfCheckFail selfCheckStatus = "FAIL"
+	selfCheckSkip selfCheckStatus = "SKIP" // Not run because a prerequisite failed
+)
+
+type selfCheckResult struct {
+	Name   string
+	Status selfCheckStatus
+	Detail string
+}
+
+type selfCheckReport struct {
+	results []selfCheckResult
+}
+
+// check records OK with okDetail when err is nil, FAIL with the error otherwise
+func (r *selfCheckReport) check(name string, err error, okDetail string) {
+	if err != nil {
+		r.results = append(r.results, selfCheckResult{Name: name, Status: selfCheckFail, Detail: err.Error()})
+		return
+	}
+	r.results = append(r.results, selfCheckResult{Name: name, Status: selfCheckOK, Detail: okDetail})
+}
+
+func (r *selfCheckReport) record(name string, status selfCheckStatus, detail string) {
+	r.results = append(r.results, selfCheckResult{Name: name, Status: status, Detail: detail})
+}
+
+func (r *selfCheckReport) failed() bool {
+	for _, res := range r.results {
+		if res.Status == selfCheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *selfCheckReport) print(w io.Writer, service string) {
+	fmt.Fprintf(w, "%s self-check\n", service)
+	for _, res := range r.results {
+		line := fmt.Sprintf("  [%-4s] %s", res.Status, res.Name)
+		if res.Detail != "" {
+			line += ": " + res.Detail
+		}
+		fmt.Fprintln(w, line)
+	}
+	if r.failed() {
+		fmt.Fprintln(w, "Result: FAILED")
+	} else {
+		fmt.Fprintln(w, "Result: OK")
+	}
+}
+
+// checkDurationEnv validates an optional positive duration variable
+func checkDurationEnv(name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%s %q is not a positive duration", name, v)
+	}
+	return nil
+}
+
+// checkPositiveIntEnv validates an optional positive integer variable
+func checkPositiveIntEnv(name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%s %q is not a positive integer", name, v)
+	}
+	return nil
+}
+
+// missingSchema lists the expected tables/columns that don't exist yet in the current schema.
+// With every migration applied nothing should be missing; anything that is was dropped by hand.
+func missingSchema(ctx context.Context, db *sql.DB, expected map[string][]string) ([]string, error) {
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var missing []string
+	for _, table := range tables {
+		rows, err := db.QueryContext(ctx,
+			"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]bool)
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			columns[column] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(columns) == 0 {
+			missing = append(missing, table)
+			continue
+		}
+		for _, column := range expected[table] {
+			if !columns[column] {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	return missing, nil
+}
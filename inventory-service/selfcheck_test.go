@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingSchema(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	query := "SELECT column_name FROM information_schema.columns"
+	mock.ExpectQuery(query).WithArgs("inventory").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("album_id").AddRow("quantity_available"))
+	mock.ExpectQuery(query).WithArgs("processed_orders").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}))
+
+	missing, err := missingSchema(context.Background(), mockDB, map[string][]string{
+		"inventory":        {"album_id", "quantity_available", "last_updated"},
+		"processed_orders": {"order_id"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"inventory.last_updated", "processed_orders"}, missing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSelfCheckReport(t *testing.T) {
+	report := &selfCheckReport{}
+	report.check("database", nil, "connected")
+	report.record("schema", selfCheckWarn, "pending, created on next startup: order_velocity")
+	assert.False(t, report.failed(), "warnings don't fail the check")
+
+	report.check("kafka topic 'order-created'", errors.New("connection refused"), "exists")
+	assert.True(t, report.failed())
+
+	var out bytes.Buffer
+	report.print(&out, "inventory-service")
+	assert.Contains(t, out.String(), "[OK  ] database: connected")
+	assert.Contains(t, out.String(), "[FAIL] kafka topic 'order-created': connection refused")
+	assert.Contains(t, out.String(), "Result: FAILED")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLIMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(sliMiddleware())
+	r.GET("/sli-test/:id", func(c *gin.Context) { c.Status(http.StatusServiceUnavailable) })
+
+	served := httpRequests.WithLabelValues("/sli-test/:id", "GET", "503")
+	unmatched := httpRequests.WithLabelValues("unmatched", "GET", "404")
+	servedBefore, unmatchedBefore := testutil.ToFloat64(served), testutil.ToFloat64(unmatched)
+
+	for _, path := range []string{"/sli-test/1", "/sli-test/2", "/no-such-route"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, servedBefore+2, testutil.ToFloat64(served), "requests are labeled by route template, not path")
+	assert.Equal(t, unmatchedBefore+1, testutil.ToFloat64(unmatched))
+}
+
+func TestObserveConsumeLag(t *testing.T) {
+	count := func() int { return testutil.CollectAndCount(eventConsumeLag, "inventory_event_consume_lag_seconds") }
+	before := count()
+
+	observeConsumeLag(kafka.Message{Topic: "sli-test-new-topic", Time: time.Now().Add(-2 * time.Second)})
+	assert.Equal(t, before+1, count(), "a series is created per topic")
+
+	// Messages without a record timestamp are ignored
+	observeConsumeLag(kafka.Message{Topic: "sli-test-untimed"})
+	assert.Equal(t, before+1, count())
+}
@@ -0,0 +1,93 @@
+// stock_aging.go - when stock was last received and last sold per album, and a report of slow-moving
+// stock to drive clearance pricing
+
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAgingDays    = 90
+	maxAgingReportItems = 500
+)
+
+// AgingItem is one album with stock that hasn't sold recently
+type AgingItem struct {
+	AlbumID           string     `json:"albumId"`
+	Title             string     `json:"title,omitempty"`
+	Artist            string     `json:"artist,omitempty"`
+	Price             *float64   `json:"price,omitempty"`
+	QuantityAvailable int        `json:"quantityAvailable"`
+	LastReceivedAt    *time.Time `json:"lastReceivedAt,omitempty"`
+	LastSoldAt        *time.Time `json:"lastSoldAt,omitempty"` // Absent when no sale was recorded
+	IdleSince         time.Time  `json:"idleSince"`
+	IdleDays          int        `json:"idleDays"`
+}
+
+// idleSinceSQL is when an album's stock last moved: its last sale, or its arrival if it never sold
+const idleSinceSQL = `COALESCE(i.last_sold_at, i.last_received_at, i.last_updated)`
+
+// getStockAging handles GET /api/admin/inventory/aging. Lists albums with more than ?quantityAbove=
+// units (default 0) and no sale in ?days= days (default 90), the longest idle first.
+func getStockAging(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(defaultAgingDays)))
+	if err != nil || days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive number"})
+		return
+	}
+	quantityAbove, err := strconv.Atoi(c.DefaultQuery("quantityAbove", "0"))
+	if err != nil || quantityAbove < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantityAbove must be a non-negative number"})
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+	// albums is owned by album-service but lives in the shared albumdb; it stores prices in cents
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT i.album_id, a.title, a.artist, round(a.price_cents / 100.0, 2), i.quantity_available, i.last_received_at, i.last_sold_at, `+idleSinceSQL+`
+		FROM inventory i LEFT JOIN albums a ON a.id::text = i.album_id
+		WHERE i.quantity_available > $1 AND `+idleSinceSQL+` < $2
+		ORDER BY `+idleSinceSQL+`, i.album_id
+		LIMIT $3`, quantityAbove, cutoff, maxAgingReportItems)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query stock aging: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	items := []AgingItem{}
+	for rows.Next() {
+		var item AgingItem
+		var title, artist sql.NullString
+		var price sql.NullFloat64
+		var received, sold sql.NullTime
+		if err := rows.Scan(&item.AlbumID, &title, &artist, &price, &item.QuantityAvailable, &received, &sold, &item.IdleSince); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read stock aging: " + err.Error()})
+			return
+		}
+		item.Title, item.Artist = title.String, artist.String
+		if price.Valid {
+			item.Price = &price.Float64
+		}
+		if received.Valid {
+			item.LastReceivedAt = &received.Time
+		}
+		if sold.Valid {
+			item.LastSoldAt = &sold.Time
+		}
+		item.IdleDays = int(now.Sub(item.IdleSince).Hours() / 24)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read stock aging: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
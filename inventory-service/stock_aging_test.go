@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStockAging(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+	originalDB := db
+	db = mockDB
+	t.Cleanup(func() { db = originalDB })
+
+	get := func(path, clientType string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Client-Type", clientType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Lists idle stock", func(t *testing.T) {
+		received := time.Now().AddDate(0, 0, -200)
+		sold := time.Now().AddDate(0, 0, -120)
+		mock.ExpectQuery("FROM inventory i LEFT JOIN albums").
+			WithArgs(5, sqlmock.AnyArg(), maxAgingReportItems).
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "title", "artist", "price", "quantity_available", "last_received_at", "last_sold_at", "idle_since"}).
+				AddRow("7", "Kind of Blue", "Miles Davis", 24.99, 12, received, sold, sold).
+				AddRow("9", nil, nil, nil, 8, received, nil, received))
+
+		rr := get("/api/admin/inventory/aging?days=100&quantityAbove=5", "admin")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var items []AgingItem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &items))
+		require.Len(t, items, 2)
+		assert.Equal(t, "Kind of Blue", items[0].Title)
+		assert.Equal(t, 120, items[0].IdleDays)
+		assert.NotNil(t, items[0].LastSoldAt)
+		assert.Nil(t, items[1].LastSoldAt)
+		assert.Nil(t, items[1].Price)
+		assert.Equal(t, 200, items[1].IdleDays)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/admin/inventory/aging?days=0", "admin").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/admin/inventory/aging?quantityAbove=-1", "admin").Code)
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/api/admin/inventory/aging", "web").Code)
+	})
+}
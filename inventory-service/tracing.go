@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"album-store/redaction"
 	"github.com/gin-gonic/gin"
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
@@ -34,9 +35,14 @@ func setupTracing() (func(context.Context) error, error) {
 	ctx := context.Background()
 
 	// Get OTLP endpoint address from environment variable
-	otlpEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
-	if otlpEndpoint == "" {
-		otlpEndpoint = "jaeger:4317" // default to local Jaeger gRPC endpoint
+	otlpEndpoint := otlpEndpointFromEnv()
+
+	// Optional attribute redaction (PII, SQL text) applied before spans leave the process.
+	// Invalid rules disable tracing rather than risk exporting unredacted data.
+	redactor, err := redaction.NewRedactorFromEnv()
+	if err != nil {
+		log.Printf("Invalid span redaction config: %v", err)
+		return nil, err
 	}
 
 	// Create OTLP exporter context
@@ -72,7 +78,7 @@ func setupTracing() (func(context.Context) error, error) {
 	// Create tracer provider
 	tracerProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithBatcher(redaction.NewExporter(traceExporter, redactor)),
 		sdktrace.WithResource(serviceResource),
 	)
 	otel.SetTracerProvider(tracerProvider)
@@ -135,6 +141,30 @@ func InjectTraceInfoToKafkaMessage(ctx context.Context) []kafka.Header {
 	return headers
 }
 
+// orderOriginKey is the context key of the span context that produced the order being processed
+type orderOriginKey struct{}
+
+// withOrderOrigin records the span context extracted from an order-created message (see
+// ExtractTraceInfoFromKafkaMessage), so the order's outcome events can be linked to it
+func withOrderOrigin(ctx context.Context, msgCtx context.Context) context.Context {
+	sc := trace.SpanContextFromContext(msgCtx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, orderOriginKey{}, sc)
+}
+
+// orderOriginLinks returns the span link to the order-created trace recorded by withOrderOrigin, if any.
+// Outcome events are published from inventory-service's own spans (and by saga recovery long after
+// the order arrived); the link keeps them connected to the trace of the order in trace UIs.
+func orderOriginLinks(ctx context.Context) []trace.Link {
+	sc, ok := ctx.Value(orderOriginKey{}).(trace.SpanContext)
+	if !ok {
+		return nil
+	}
+	return []trace.Link{{SpanContext: sc, Attributes: []attribute.KeyValue{attribute.String("link.reason", "order-created")}}}
+}
+
 // wrapHandlerWithTracing wraps a Gin handler to provide detailed tracing for each request
 func wrapHandlerWithTracing(handler gin.HandlerFunc, spanName string) gin.HandlerFunc {
 	return func(c *gin.Context) {
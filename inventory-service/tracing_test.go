@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"album-store/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// setupTestTracing installs an in-memory span recorder as the global tracer provider,
+// mirroring the propagator setup in setupTracing. Globals are restored when the test ends.
+func setupTestTracing(t *testing.T) (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prevProvider, prevPropagator, prevTracer := otel.GetTracerProvider(), otel.GetTextMapPropagator(), tracer
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	tracer = tp.Tracer("inventory-service")
+
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+		tracer = prevTracer
+	})
+	return recorder, tp
+}
+
+// endedSpansByName indexes the finished spans by name (span names are unique per message here)
+func endedSpansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+// TestProcessAlbumCreatedEvent_ContinuesProducerTrace is the consumer half of the cross-service
+// tracing test (see album-service/tracing_test.go): an album-created message carrying the
+// producer's trace headers must be processed inside that same trace.
+func TestProcessAlbumCreatedEvent_ContinuesProducerTrace(t *testing.T) {
+	recorder, tp := setupTestTracing(t)
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	// Simulate album-service's kafka.publish_album_created span injecting its context
+	producerCtx, producerSpan := tp.Tracer("album-service").Start(context.Background(), "kafka.publish_album_created")
+	headers := InjectTraceInfoToKafkaMessage(producerCtx)
+	producerSpan.End()
+
+	initialQty := int32(3)
+	eventBytes, _ := events.MarshalJSON(&events.AlbumCreated{
+		AlbumId:         "album-traced",
+		Title:           "Traced Album",
+		Artist:          "Traced Artist",
+		Timestamp:       timestamppb.Now(),
+		InitialQuantity: &initialQty,
+	})
+	mock.ExpectExec("INSERT INTO inventory").
+		WithArgs("album-traced", initialQty).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = processAlbumCreatedEvent(mockDB, kafka.Message{Value: eventBytes, Headers: headers})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := endedSpansByName(recorder)
+	consumerSpan, ok := spans["processAlbumCreatedEvent"]
+	require.True(t, ok, "consumer processing span should be recorded")
+	dbSpan, ok := spans["db.insert_inventory"]
+	require.True(t, ok, "consumer DB span should be recorded")
+
+	traceID := producerSpan.SpanContext().TraceID()
+	assert.Equal(t, traceID, consumerSpan.SpanContext().TraceID(), "Consumer span should continue the producer trace")
+	assert.Equal(t, traceID, dbSpan.SpanContext().TraceID(), "Consumer DB span should continue the producer trace")
+	assert.Equal(t, producerSpan.SpanContext().SpanID(), consumerSpan.Parent().SpanID(), "Consumer span should be parented by the produce span")
+	assert.True(t, consumerSpan.Parent().IsRemote(), "Consumer span parent should be a remote span context")
+	assert.Equal(t, consumerSpan.SpanContext().SpanID(), dbSpan.Parent().SpanID(), "DB span should be a child of the consumer span")
+}
+
+// TestProcessOrderCreated_LinksOutcomeToOrderTrace checks that order outcome events are published from
+// a producer span linked to the order-created trace, and carry that span's context to consumers
+func TestProcessOrderCreated_LinksOutcomeToOrderTrace(t *testing.T) {
+	recorder, tp := setupTestTracing(t)
+
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	// Simulate order-service's span publishing order-created
+	producerCtx, producerSpan := tp.Tracer("order-service").Start(context.Background(), "kafka.publish_order_created")
+	msg := orderMessage(t, &events.OrderCreated{OrderId: "301", AlbumId: "album-1", Quantity: 1, PickupWarehouseId: "north"})
+	msg.Headers = InjectTraceInfoToKafkaMessage(producerCtx)
+	producerSpan.End()
+
+	t.Run("order-failed", func(t *testing.T) {
+		failed, _ := useRecordingWriters(t)
+		expectNoSaga(mock, "301")
+		expectSagaStep(mock, "301", sagaStepFailed)
+		expectSagaStep(mock, "301", sagaStepFailedPublished)
+
+		require.NoError(t, processOrderCreated(mockDB, msg))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		spans := endedSpansByName(recorder)
+		consumerSpan, publishSpan := spans["processOrderCreated"], spans["kafka.publish_order_failed"]
+		require.NotNil(t, publishSpan, "publish span should be recorded")
+		assert.Equal(t, trace.SpanKindProducer, publishSpan.SpanKind())
+		assert.Equal(t, consumerSpan.SpanContext().SpanID(), publishSpan.Parent().SpanID())
+		if assert.Len(t, publishSpan.Links(), 1) {
+			assert.True(t, publishSpan.Links()[0].SpanContext.Equal(producerSpan.SpanContext().WithRemote(true)),
+				"publish span should link to the order-created span")
+		}
+
+		// Consumers of order-failed continue from the publish span
+		require.Len(t, failed.messages, 1)
+		consumerCtx := ExtractTraceInfoFromKafkaMessage(context.Background(), failed.messages[0].Headers)
+		assert.Equal(t, publishSpan.SpanContext().SpanID(), trace.SpanContextFromContext(consumerCtx).SpanID())
+	})
+
+	t.Run("Saga recovery has no order trace to link", func(t *testing.T) {
+		recorder.Reset()
+		_, succeeded := useRecordingWriters(t)
+		expectSagaStep(mock, "302", sagaStepSucceededPublished)
+
+		steps := []SagaStep{{Step: sagaStepDeducted, Detail: `{"orderId":"302","albumId":"album-1","quantity":1}`}}
+		require.NoError(t, resumeOrderSaga(context.Background(), mockDB, "302", steps))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		publishSpan := endedSpansByName(recorder)["kafka.publish_order_succeeded"]
+		require.NotNil(t, publishSpan)
+		assert.Empty(t, publishSpan.Links())
+		assert.Len(t, succeeded.messages, 1)
+	})
+}
@@ -0,0 +1,209 @@
+// velocity.go - per-album and per-user order velocity caps (bot protection for flash sales)
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"album-store/events"
+	"github.com/gin-gonic/gin"
+)
+
+// Velocity limit scopes, used as the failure detail and the metric label
+const (
+	velocityScopeUser  = "user"  // Units one user ordered for the album within the window
+	velocityScopeAlbum = "album" // Units all users ordered for the album within the window
+)
+
+// VelocityLimit caps how many units of an album can be ordered within a rolling window
+type VelocityLimit struct {
+	AlbumID         string    `json:"albumId"`
+	MaxUnitsPerUser *int      `json:"maxUnitsPerUser,omitempty"`
+	MaxUnitsTotal   *int      `json:"maxUnitsTotal,omitempty"`
+	WindowSeconds   int       `json:"windowSeconds"`
+	LastUpdated     time.Time `json:"lastUpdated"`
+}
+
+// UpdateVelocityLimitRequest represents a request to set an album's velocity limit
+type UpdateVelocityLimitRequest struct {
+	MaxUnitsPerUser *int `json:"maxUnitsPerUser" binding:"omitempty,gt=0"`
+	MaxUnitsTotal   *int `json:"maxUnitsTotal" binding:"omitempty,gt=0"`
+	WindowSeconds   int  `json:"windowSeconds" binding:"required,gt=0"`
+}
+
+// checkVelocityLimit evaluates the album's velocity limit for an order inside the deduction transaction.
+// It returns the limit (nil when none is configured) and the violated scope ("" when the order is allowed).
+// The album's inventory row is locked before the sums, so concurrent orders for the album are counted
+// one after the other and can't both pass a cap only one of them fits under.
+func checkVelocityLimit(ctx context.Context, tx *sql.Tx, event *events.OrderCreated) (*VelocityLimit, string, error) {
+	var limit VelocityLimit
+	var maxPerUser, maxTotal sql.NullInt64
+	err := tx.QueryRowContext(ctx,
+		"SELECT max_units_per_user, max_units_total, window_seconds FROM album_velocity_limits WHERE album_id = $1",
+		event.AlbumId).Scan(&maxPerUser, &maxTotal, &limit.WindowSeconds)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load velocity limit: %w", err)
+	}
+	limit.AlbumID = event.AlbumId
+	since := limit.windowStart()
+
+	var available int
+	err = tx.QueryRowContext(ctx, "SELECT quantity_available FROM inventory WHERE album_id = $1 FOR UPDATE", event.AlbumId).Scan(&available)
+	if err != nil && err != sql.ErrNoRows { // Without an inventory row the deduction fails anyway
+		return nil, "", fmt.Errorf("failed to lock inventory: %w", err)
+	}
+
+	if maxPerUser.Valid {
+		var ordered int
+		err = tx.QueryRowContext(ctx,
+			"SELECT COALESCE(SUM(quantity), 0) FROM order_velocity WHERE album_id = $1 AND user_id = $2 AND created_at > $3",
+			event.AlbumId, event.UserId, since).Scan(&ordered)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to sum user velocity: %w", err)
+		}
+		if ordered+int(event.Quantity) > int(maxPerUser.Int64) {
+			return &limit, velocityScopeUser, nil
+		}
+	}
+
+	if maxTotal.Valid {
+		var ordered int
+		err = tx.QueryRowContext(ctx,
+			"SELECT COALESCE(SUM(quantity), 0) FROM order_velocity WHERE album_id = $1 AND created_at > $2",
+			event.AlbumId, since).Scan(&ordered)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to sum album velocity: %w", err)
+		}
+		if ordered+int(event.Quantity) > int(maxTotal.Int64) {
+			return &limit, velocityScopeAlbum, nil
+		}
+	}
+
+	return &limit, "", nil
+}
+
+// windowStart is the start of the limit's rolling window
+func (l *VelocityLimit) windowStart() time.Time {
+	return time.Now().Add(-time.Duration(l.WindowSeconds) * time.Second)
+}
+
+// recordOrderVelocity counts a successful order against the album's velocity limit, and forgets
+// the album's orders that have left the window. Orders are only tracked for albums that have a
+// limit configured.
+func recordOrderVelocity(ctx context.Context, tx *sql.Tx, event *events.OrderCreated, limit *VelocityLimit) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM order_velocity WHERE album_id = $1 AND created_at <= $2",
+		event.AlbumId, limit.windowStart())
+	if err != nil {
+		return fmt.Errorf("failed to prune order velocity: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO order_velocity (order_id, album_id, user_id, quantity, created_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 ON CONFLICT (order_id) DO NOTHING`,
+		event.OrderId, event.AlbumId, event.UserId, event.Quantity)
+	if err != nil {
+		return fmt.Errorf("failed to record order velocity: %w", err)
+	}
+	return nil
+}
+
+// --- Handler Functions (using gin.Context) ---
+
+func getVelocityLimit(c *gin.Context) {
+	albumID := c.Param("albumId")
+
+	var limit VelocityLimit
+	var maxPerUser, maxTotal sql.NullInt64
+	err := db.QueryRow(
+		"SELECT album_id, max_units_per_user, max_units_total, window_seconds, last_updated FROM album_velocity_limits WHERE album_id = $1",
+		albumID).Scan(&limit.AlbumID, &maxPerUser, &maxTotal, &limit.WindowSeconds, &limit.LastUpdated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No velocity limit configured for album"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if maxPerUser.Valid {
+		v := int(maxPerUser.Int64)
+		limit.MaxUnitsPerUser = &v
+	}
+	if maxTotal.Valid {
+		v := int(maxTotal.Int64)
+		limit.MaxUnitsTotal = &v
+	}
+
+	c.JSON(http.StatusOK, limit)
+}
+
+func updateVelocityLimit(c *gin.Context) {
+	albumID := c.Param("albumId")
+
+	var req UpdateVelocityLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	if req.MaxUnitsPerUser == nil && req.MaxUnitsTotal == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of maxUnitsPerUser or maxUnitsTotal is required"})
+		return
+	}
+
+	currentTime := time.Now()
+	_, err := db.Exec(
+		`INSERT INTO album_velocity_limits (album_id, max_units_per_user, max_units_total, window_seconds, last_updated)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (album_id)
+		 DO UPDATE SET max_units_per_user = $2, max_units_total = $3, window_seconds = $4, last_updated = $5`,
+		albumID, req.MaxUnitsPerUser, req.MaxUnitsTotal, req.WindowSeconds, currentTime,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update velocity limit: " + err.Error()})
+		return
+	}
+
+	log.Printf("Velocity limit updated for albumId: %s (perUser=%v, total=%v, window=%ds)",
+		albumID, req.MaxUnitsPerUser, req.MaxUnitsTotal, req.WindowSeconds)
+
+	c.JSON(http.StatusOK, VelocityLimit{
+		AlbumID:         albumID,
+		MaxUnitsPerUser: req.MaxUnitsPerUser,
+		MaxUnitsTotal:   req.MaxUnitsTotal,
+		WindowSeconds:   req.WindowSeconds,
+		LastUpdated:     currentTime,
+	})
+}
+
+func deleteVelocityLimit(c *gin.Context) {
+	albumID := c.Param("albumId")
+
+	res, err := db.Exec("DELETE FROM album_velocity_limits WHERE album_id = $1", albumID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete velocity limit: " + err.Error()})
+		return
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get affected rows: " + err.Error()})
+		return
+	}
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No velocity limit configured for album"})
+		return
+	}
+	// Orders are no longer counted for the album
+	if _, err := db.Exec("DELETE FROM order_velocity WHERE album_id = $1", albumID); err != nil {
+		log.Printf("Failed to clear order velocity for albumId %s: %v", albumID, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
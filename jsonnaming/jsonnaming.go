@@ -0,0 +1,421 @@
+// Package jsonnaming implements the JSON field naming of the Go services. Response bodies and events
+// use camelCase field names unless the deployment (JSON_FIELD_NAMING=snake_case) or the request asks
+// for snake_case. A request selects its naming with ?naming=snake_case or a parameter on the accepted
+// media type:
+//
+//	Accept: application/json; naming=snake_case
+//
+// Handlers and event types keep working with camelCase: bodies are renamed on the way out (and a
+// snake_case request's body on the way in), and consumers accept events in either naming. Only keys
+// that are identifiers in the other naming are renamed, so data used as keys, like the genre names of
+// facet counts, passes through unchanged.
+package jsonnaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+// JSON field namings
+const (
+	CamelCase = "camelCase"
+	SnakeCase = "snake_case"
+)
+
+// contextKey is the context key of the naming selected for a request
+const contextKey = "jsonNaming"
+
+// ErrUnknown is returned for a naming other than camelCase and snake_case
+var ErrUnknown = errors.New("naming must be camelCase or snake_case")
+
+// Deployment is the naming of responses that don't ask for one, and of published events
+// (JSON_FIELD_NAMING)
+var Deployment = CamelCase
+
+// LoadConfig reads the deployment naming from the environment
+func LoadConfig() error {
+	v := os.Getenv("JSON_FIELD_NAMING")
+	if v == "" {
+		return nil
+	}
+	if v != CamelCase && v != SnakeCase {
+		return fmt.Errorf("JSON_FIELD_NAMING %q: %w", v, ErrUnknown)
+	}
+	Deployment = v
+	return nil
+}
+
+// requestNaming returns the naming the request asks for, or the deployment naming
+func requestNaming(c *gin.Context) (string, error) {
+	if v, ok := c.GetQuery("naming"); ok {
+		if v != CamelCase && v != SnakeCase {
+			return "", ErrUnknown
+		}
+		return v, nil
+	}
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		v, ok := params["naming"]
+		if err != nil || !ok || (mediaType != "application/json" && mediaType != "*/*") {
+			continue
+		}
+		if v != CamelCase && v != SnakeCase {
+			return "", ErrUnknown
+		}
+		return v, nil
+	}
+	return Deployment, nil
+}
+
+// Of returns the naming selected for the request by Middleware
+func Of(c *gin.Context) string {
+	if naming := c.GetString(contextKey); naming != "" {
+		return naming
+	}
+	return Deployment
+}
+
+// Middleware selects the naming of each request and renames the keys of snake_case requests' JSON
+// bodies. Responses of the streamed routes can't be buffered for renaming, so their handlers name the
+// fields themselves (see Of). The naming can come from the Accept header, so every response
+// varies by it.
+func Middleware(streamed ...string) gin.HandlerFunc {
+	streamedRoutes := make(map[string]bool, len(streamed))
+	for _, route := range streamed {
+		streamedRoutes[route] = true
+	}
+	return func(c *gin.Context) {
+		naming, err := requestNaming(c)
+		if err != nil {
+			c.Header("Vary", "Accept")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(contextKey, naming)
+
+		original := c.Writer
+		w := &responseWriter{ResponseWriter: original}
+		if naming == SnakeCase {
+			renameRequestBody(c.Request, CamelCaseKey)
+			w.buffered = !streamedRoutes[c.FullPath()]
+		}
+		c.Writer = w
+		c.Next()
+		c.Writer = original
+
+		if !w.buffered {
+			return
+		}
+		body := w.body.Bytes()
+		if len(body) > 0 && isJSONContent(original.Header().Get("Content-Type")) {
+			if renamed, err := RenameKeys(body, SnakeCaseKey); err == nil {
+				body = renamed
+			}
+		}
+		w.vary()
+		original.WriteHeader(w.Status())
+		if len(body) == 0 {
+			original.WriteHeaderNow()
+			return
+		}
+		original.Write(body)
+	}
+}
+
+// renameRequestBody renames the keys of a JSON request body in place. Bodies that aren't valid JSON
+// are left for the handler to reject.
+func renameRequestBody(r *http.Request, rename func(string) string) {
+	if r.Body == nil || !isJSONContent(r.Header.Get("Content-Type")) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if renamed, renameErr := RenameKeys(body, rename); err == nil && renameErr == nil {
+		body = renamed
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+}
+
+// isJSONContent reports whether a Content-Type header value is JSON
+func isJSONContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// responseWriter adds Vary: Accept to the response and, when buffered, holds the status and body
+// until Middleware has renamed it
+type responseWriter struct {
+	gin.ResponseWriter
+	buffered bool
+	body     bytes.Buffer
+	status   int
+	varied   bool
+}
+
+func (w *responseWriter) vary() {
+	if !w.varied {
+		w.varied = true
+		w.ResponseWriter.Header().Add("Vary", "Accept")
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.buffered {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	if w.buffered {
+		return
+	}
+	w.vary()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.buffered {
+		return w.body.Write(b)
+	}
+	w.vary()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	if w.buffered {
+		return w.body.WriteString(s)
+	}
+	w.vary()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseWriter) Flush() {
+	if w.buffered {
+		return
+	}
+	w.vary()
+	w.ResponseWriter.Flush()
+}
+
+func (w *responseWriter) Status() int {
+	if !w.buffered {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responseWriter) Size() int {
+	if !w.buffered {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *responseWriter) Written() bool {
+	if !w.buffered {
+		return w.ResponseWriter.Written()
+	}
+	return w.body.Len() > 0
+}
+
+// RenameKeys returns data with every object key passed through rename. Keys and values keep their
+// order, and numbers their exact text.
+func RenameKeys(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out bytes.Buffer
+	// One level per open object or array: whether an object expects a key next, and whether no
+	// element has been written yet
+	type level struct{ object, key, first bool }
+	var levels []level
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(levels) == 0 {
+			return out.Bytes(), nil
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			out.WriteByte(byte(d))
+			levels = levels[:len(levels)-1]
+			continue
+		}
+
+		if n := len(levels); n > 0 {
+			l := &levels[n-1]
+			switch {
+			case l.object && l.key:
+				if !l.first {
+					out.WriteByte(',')
+				}
+				l.first, l.key = false, false
+				key, _ := json.Marshal(rename(tok.(string)))
+				out.Write(key)
+				continue
+			case l.object:
+				out.WriteByte(':')
+				l.key = true
+			default:
+				if !l.first {
+					out.WriteByte(',')
+				}
+				l.first = false
+			}
+		} else if out.Len() > 0 {
+			out.WriteByte('\n') // Stream of top-level values
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteByte(byte(v))
+			levels = append(levels, level{object: v == '{', key: v == '{', first: true})
+		case json.Number:
+			out.WriteString(v.String())
+		case nil:
+			out.WriteString("null")
+		default:
+			value, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+	}
+}
+
+// SnakeCaseKey renames a camelCase identifier ("releaseYear", "albumID") to snake_case
+// ("release_year", "album_id"). Other keys are returned unchanged.
+func SnakeCaseKey(key string) string {
+	if !isCamelCaseIdentifier(key) {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		ch := key[i]
+		if isUpper(ch) {
+			// A new word starts at an upper-case letter, except inside an acronym ("ID" in "albumIDs")
+			prevUpper := isUpper(key[i-1])
+			nextLower := i+1 < len(key) && key[i+1] >= 'a' && key[i+1] <= 'z'
+			if !prevUpper || nextLower && i+2 < len(key) {
+				b.WriteByte('_')
+			}
+			ch += 'a' - 'A'
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// CamelCaseKey renames a snake_case identifier ("release_year") to camelCase ("releaseYear"). Other
+// keys are returned unchanged.
+func CamelCaseKey(key string) string {
+	if !isSnakeCaseIdentifier(key) {
+		return key
+	}
+	var b strings.Builder
+	upper := false
+	for i := 0; i < len(key); i++ {
+		ch := key[i]
+		switch {
+		case ch == '_':
+			upper = true
+			continue
+		case upper && ch >= 'a' && ch <= 'z':
+			ch -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// isCamelCaseIdentifier reports whether key is a lower camelCase identifier with more than one word
+func isCamelCaseIdentifier(key string) bool {
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		return false
+	}
+	words := 1
+	for i := 1; i < len(key); i++ {
+		ch := key[i]
+		switch {
+		case isUpper(ch):
+			words++
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		default:
+			return false
+		}
+	}
+	return words > 1
+}
+
+// isSnakeCaseIdentifier reports whether key is a lower snake_case identifier with more than one word
+func isSnakeCaseIdentifier(key string) bool {
+	if key == "" || key[0] < 'a' || key[0] > 'z' || key[len(key)-1] == '_' {
+		return false
+	}
+	words := 1
+	for i := 1; i < len(key); i++ {
+		ch := key[i]
+		switch {
+		case ch == '_':
+			if key[i-1] == '_' {
+				return false
+			}
+			words++
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		default:
+			return false
+		}
+	}
+	return words > 1
+}
+
+func isUpper(ch byte) bool { return ch >= 'A' && ch <= 'Z' }
+
+// Events returns msgs with their JSON values renamed to the deployment naming
+func Events(msgs []kafka.Message) []kafka.Message {
+	if Deployment != SnakeCase {
+		return msgs
+	}
+	renamed := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		renamed[i] = msg
+		if value, err := RenameKeys(msg.Value, SnakeCaseKey); err == nil {
+			renamed[i].Value = value
+		}
+	}
+	return renamed
+}
+
+// CamelCaseEvent returns an event value with snake_case keys renamed to camelCase, so consumers
+// decode events of either naming. Values that aren't valid JSON are returned as they are.
+func CamelCaseEvent(value []byte) []byte {
+	if !bytes.Contains(value, []byte("_")) {
+		return value
+	}
+	renamed, err := RenameKeys(value, CamelCaseKey)
+	if err != nil {
+		return value
+	}
+	return renamed
+}
@@ -0,0 +1,61 @@
+package jsonnaming
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyNaming(t *testing.T) {
+	for camel, snake := range map[string]string{
+		"releaseYear":        "release_year",
+		"albumID":            "album_id",
+		"albumIDs":           "album_ids",
+		"publicIDMap":        "public_id_map",
+		"price":              "price",
+		"line2Total":         "line2_total",
+		"priceFloorOverride": "price_floor_override",
+	} {
+		assert.Equal(t, snake, SnakeCaseKey(camel), camel)
+	}
+	for snake, camel := range map[string]string{
+		"release_year": "releaseYear",
+		"album_id":     "albumId",
+		"price":        "price",
+		"_private":     "_private",
+		"trailing_":    "trailing_",
+	} {
+		assert.Equal(t, camel, CamelCaseKey(snake), snake)
+	}
+
+	t.Run("Only identifiers are renamed, in place", func(t *testing.T) {
+		in := `{"releaseYear":1991,"price":19.990,"counts":{"Hip Hop":2,"Rock":3},"items":[{"albumId":"4"},null,true],"note":"Nevermind"}`
+		out, err := RenameKeys([]byte(in), SnakeCaseKey)
+		require.NoError(t, err)
+		assert.Equal(t, `{"release_year":1991,"price":19.990,"counts":{"Hip Hop":2,"Rock":3},"items":[{"album_id":"4"},null,true],"note":"Nevermind"}`, string(out))
+
+		back, err := RenameKeys(out, CamelCaseKey)
+		require.NoError(t, err)
+		assert.Equal(t, in, string(back))
+	})
+
+	t.Run("Invalid JSON is an error", func(t *testing.T) {
+		_, err := RenameKeys([]byte(`{"releaseYear":`), SnakeCaseKey)
+		assert.Error(t, err)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	originalNaming := Deployment
+	t.Cleanup(func() { Deployment = originalNaming })
+
+	msgs := []kafka.Message{{Key: []byte("4"), Value: []byte(`{"albumId":"4","initialQuantity":10}`)}}
+	assert.Equal(t, msgs, Events(msgs))
+
+	Deployment = SnakeCase
+	renamed := Events(msgs)
+	assert.Equal(t, `{"album_id":"4","initial_quantity":10}`, string(renamed[0].Value))
+	assert.Equal(t, `{"albumId":"4","initialQuantity":10}`, string(msgs[0].Value), "the caller's messages are not modified")
+}
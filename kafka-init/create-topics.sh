@@ -16,13 +16,43 @@ sleep 2 # Add a small delay just in case
 # List of topics to create
 TOPICS=(
   "album-created"
+  "album-deleted"      # Album deletions, inventory archives the album's stock record
+  "price-proposals"    # Clearance discount proposals and their approval, for catalog managers
+  "price-changed"      # Effective album prices when promotions start and end
+  "daily-sales-summary" # Units and revenue per album for each ended day, from album-service
   "order-created"      # Renamed from order-confirmations
   "order-succeeded"    # Added for successful orders
   "order-failed"       # Added for failed orders
+  "order-gifted"       # Gift orders that succeeded, for recipient notifications
+  "inventory-low-stock" # Orders that took an album's stock to its low-stock threshold, from inventory-service
+  # Schema v2 order event topics, used while dual-publishing during event schema migrations
+  "order-created.v2"
+  "order-succeeded.v2"
+  "order-failed.v2"
+  "order-gifted.v2"
+  # Schema v2 album event topics, in the standard envelope
+  "album-created.v2"
+  "album-deleted.v2"
+  "price-proposals.v2"
+  "price-changed.v2"
+  "daily-sales-summary.v2"
+  "inventory-low-stock.v2"
+  # Dead-letter topics for inventory-service consumers using the dlq error policy
+  "order-created.dlq"
+  "order-created.v2.dlq"
+  "album-created.dlq"
+  "album-deleted.dlq"
+  "album-created.v2.dlq"
+  "album-deleted.v2.dlq"
   # Add other topics if needed
 )
 
-for TOPIC in "${TOPICS[@]}"; do
+# Optional environment scoping, must match the services' KAFKA_TOPIC_PREFIX / KAFKA_TOPIC_SUFFIX
+TOPIC_PREFIX="${KAFKA_TOPIC_PREFIX:-}"
+TOPIC_SUFFIX="${KAFKA_TOPIC_SUFFIX:-}"
+
+for BASE_TOPIC in "${TOPICS[@]}"; do
+  TOPIC="${TOPIC_PREFIX}${BASE_TOPIC}${TOPIC_SUFFIX}"
   echo "Checking if topic '$TOPIC' exists..."
   # Check exit code of describe directly in the if condition.
   # Redirect stderr (2) to /dev/null to suppress the expected error when topic doesn't exist.
@@ -0,0 +1,288 @@
+// Package kafkawriter provides the Kafka writers of the Go services: lazily validated, with
+// background health checks and reconnect. Until a writer has checked its broker and topic, and
+// while the broker is unreachable, writes fail fast instead of waiting out the write timeout.
+package kafkawriter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"album-store/jsonnaming"
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrUnavailable is returned by writers while the broker is known to be unreachable,
+// so callers fail fast instead of waiting out the write timeout on every request
+var ErrUnavailable = errors.New("kafka broker unavailable")
+
+const (
+	HealthCheckInterval = 15 * time.Second // Interval between checks while healthy
+	HealthCheckTimeout  = 3 * time.Second
+	reconnectBackoffMin = 1 * time.Second // First retry delay after a failed check
+	reconnectBackoffMax = 30 * time.Second
+)
+
+// Writer states reported by /health/ready
+const (
+	StateConnecting  = "connecting"  // No check has completed yet
+	StateReady       = "ready"       // Last check succeeded
+	StateUnavailable = "unavailable" // Last check failed; retrying with backoff
+)
+
+// Status is the health snapshot of a writer
+type Status struct {
+	Topic     string     `json:"topic"`
+	State     string     `json:"state"`
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// Breaker guards a writer between health checks, which only notice an outage every few seconds.
+// Allow reports whether a write may be made now and Record the outcome of an allowed write;
+// OpenError is returned for a write Allow refused.
+type Breaker interface {
+	Allow() bool
+	Record(err error)
+	OpenError() error
+}
+
+// Writer wraps a kafka.Writer that is only created once the broker and topic have been
+// validated. A background loop re-checks the broker periodically, backs off exponentially while it
+// is unreachable, and recreates the underlying writer when it comes back.
+type Writer struct {
+	topic     string
+	newWriter func() *kafka.Writer
+	check     func(ctx context.Context) error
+	breaker   Breaker // Optional
+
+	mu        sync.RWMutex
+	writer    *kafka.Writer
+	checked   bool
+	healthy   bool
+	lastErr   error
+	lastCheck time.Time
+	failures  int
+
+	recheck  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+// New returns a writer for topic on broker, guarded by breaker if it isn't nil; call Start to begin
+// health checking
+func New(broker, topic string, newWriter func() *kafka.Writer, breaker Breaker) *Writer {
+	return &Writer{
+		topic:     topic,
+		newWriter: newWriter,
+		check: func(ctx context.Context) error {
+			return CheckTopic(ctx, broker, topic)
+		},
+		breaker: breaker,
+		recheck: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// CheckTopic verifies the broker is reachable and serves metadata for topic
+func CheckTopic(ctx context.Context, broker, topic string) error {
+	dialer := &kafka.Dialer{Timeout: HealthCheckTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", broker, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return fmt.Errorf("read partitions for topic '%s': %w", topic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic '%s' has no partitions", topic)
+	}
+	return nil
+}
+
+// Start launches the background health-check loop; the first check runs immediately
+func (w *Writer) Start() {
+	w.mu.Lock()
+	w.started = true
+	w.mu.Unlock()
+	go w.loop()
+}
+
+func (w *Writer) loop() {
+	defer close(w.done)
+
+	delay := time.Duration(0)
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-w.recheck:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if w.runCheck() {
+			delay = HealthCheckInterval
+		} else {
+			delay = w.backoff()
+		}
+	}
+}
+
+// runCheck performs one health check, (re)creating the writer on recovery. It reports whether the broker is healthy.
+func (w *Writer) runCheck() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+	err := w.check(ctx)
+
+	w.mu.Lock()
+	w.checked = true
+	w.lastCheck = time.Now()
+	w.lastErr = err
+	if err != nil {
+		if w.healthy || w.failures == 0 {
+			log.Printf("Kafka writer for topic '%s' unavailable: %v", w.topic, err)
+		}
+		w.healthy = false
+		w.failures++
+		w.mu.Unlock()
+		return false
+	}
+
+	var stale *kafka.Writer
+	if !w.healthy {
+		stale = w.writer
+		w.writer = w.newWriter()
+		log.Printf("Kafka writer for topic '%s' connected", w.topic)
+	}
+	w.healthy = true
+	w.failures = 0
+	w.mu.Unlock()
+
+	// Close the writer from before the outage outside the lock; it may flush
+	if stale != nil {
+		if err := stale.Close(); err != nil {
+			log.Printf("Failed to close stale Kafka writer for topic '%s': %v", w.topic, err)
+		}
+	}
+	return true
+}
+
+// backoff returns the delay before the next reconnect attempt, doubling per consecutive failure
+func (w *Writer) backoff() time.Duration {
+	w.mu.RLock()
+	failures := w.failures
+	w.mu.RUnlock()
+
+	delay := reconnectBackoffMin
+	for i := 1; i < failures && delay < reconnectBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > reconnectBackoffMax {
+		delay = reconnectBackoffMax
+	}
+	return delay
+}
+
+// RequestRecheck schedules an immediate health check without blocking
+func (w *Writer) RequestRecheck() {
+	select {
+	case w.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// WriteMessages publishes msgs in the deployment's JSON field naming (see album-store/jsonnaming),
+// failing fast with ErrUnavailable while the broker is unhealthy or the writer's circuit is open. A
+// failed write triggers an immediate health check.
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.RLock()
+	writer, healthy := w.writer, w.healthy
+	w.mu.RUnlock()
+
+	if !healthy || writer == nil {
+		return fmt.Errorf("%w: topic '%s'", ErrUnavailable, w.topic)
+	}
+	if w.breaker != nil && !w.breaker.Allow() {
+		return fmt.Errorf("%w: topic '%s': %w", ErrUnavailable, w.topic, w.breaker.OpenError())
+	}
+	err := writer.WriteMessages(ctx, jsonnaming.Events(msgs)...)
+	if w.breaker != nil {
+		w.breaker.Record(err)
+	}
+	if err != nil {
+		w.RequestRecheck()
+		return err
+	}
+	return nil
+}
+
+// Ready reports whether the last health check succeeded
+func (w *Writer) Ready() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.healthy
+}
+
+// Status returns a snapshot of the writer's health for readiness reporting
+func (w *Writer) Status() Status {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	status := Status{Topic: w.topic, State: StateConnecting}
+	if !w.checked {
+		return status
+	}
+	lastCheck := w.lastCheck
+	status.LastCheck = &lastCheck
+	if w.healthy {
+		status.State = StateReady
+	} else {
+		status.State = StateUnavailable
+		if w.lastErr != nil {
+			status.LastError = w.lastErr.Error()
+		}
+	}
+	return status
+}
+
+// Close stops the health-check loop and closes the underlying writer
+func (w *Writer) Close() error {
+	w.stopOnce.Do(func() { close(w.stop) })
+
+	w.mu.RLock()
+	started := w.started
+	w.mu.RUnlock()
+	if started {
+		select {
+		case <-w.done:
+		case <-time.After(HealthCheckTimeout):
+			// A check is still in flight; don't block shutdown on it
+		}
+	}
+
+	w.mu.Lock()
+	writer := w.writer
+	w.writer = nil
+	w.healthy = false
+	w.mu.Unlock()
+
+	if writer != nil {
+		return writer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package kafkawriter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWriter returns a writer whose health check result is controlled by the test
+func newTestWriter(checkErr *error, breaker Breaker) *Writer {
+	w := New("127.0.0.1:1", "album-created", func() *kafka.Writer {
+		return &kafka.Writer{Addr: kafka.TCP("127.0.0.1:1"), Topic: "album-created"}
+	}, breaker)
+	w.check = func(ctx context.Context) error { return *checkErr }
+	return w
+}
+
+func TestWriter_FailsFastBeforeFirstCheck(t *testing.T) {
+	var checkErr error
+	w := newTestWriter(&checkErr, nil)
+	defer w.Close()
+
+	start := time.Now()
+	err := w.WriteMessages(context.Background(), kafka.Message{Value: []byte("x")})
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Less(t, time.Since(start), time.Second, "unvalidated writer should not wait for the write timeout")
+	assert.Equal(t, StateConnecting, w.Status().State)
+	assert.False(t, w.Ready())
+}
+
+func TestWriter_StateTransitions(t *testing.T) {
+	checkErr := errors.New("connection refused")
+	w := newTestWriter(&checkErr, nil)
+	defer w.Close()
+
+	// Broker down: unavailable, exponential backoff capped at the maximum
+	assert.False(t, w.runCheck())
+	status := w.Status()
+	assert.Equal(t, StateUnavailable, status.State)
+	assert.Contains(t, status.LastError, "connection refused")
+	assert.NotNil(t, status.LastCheck)
+	assert.Equal(t, reconnectBackoffMin, w.backoff())
+	assert.False(t, w.runCheck())
+	assert.Equal(t, 2*reconnectBackoffMin, w.backoff())
+	for i := 0; i < 10; i++ {
+		w.runCheck()
+	}
+	assert.Equal(t, reconnectBackoffMax, w.backoff())
+
+	err := w.WriteMessages(context.Background(), kafka.Message{Value: []byte("x")})
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	// Broker back: writer is created and state is ready
+	checkErr = nil
+	assert.True(t, w.runCheck())
+	assert.True(t, w.Ready())
+	assert.Equal(t, StateReady, w.Status().State)
+	assert.Empty(t, w.Status().LastError)
+	require.NotNil(t, w.writer, "writer should be created once the broker is validated")
+	first := w.writer
+
+	// Healthy re-check keeps the same writer; recovery after an outage replaces it
+	assert.True(t, w.runCheck())
+	assert.Same(t, first, w.writer)
+	checkErr = errors.New("broker restarting")
+	assert.False(t, w.runCheck())
+	checkErr = nil
+	assert.True(t, w.runCheck())
+	assert.NotSame(t, first, w.writer, "writer should be recreated after reconnecting")
+}
+
+// openBreaker refuses every write
+type openBreaker struct{ recorded int }
+
+func (b *openBreaker) Allow() bool      { return false }
+func (b *openBreaker) Record(err error) { b.recorded++ }
+func (b *openBreaker) OpenError() error { return errors.New("circuit open") }
+
+func TestWriter_FailsFastWhileTheBreakerIsOpen(t *testing.T) {
+	var checkErr error
+	breaker := &openBreaker{}
+	w := newTestWriter(&checkErr, breaker)
+	defer w.Close()
+	require.True(t, w.runCheck())
+
+	err := w.WriteMessages(context.Background(), kafka.Message{Value: []byte("x")})
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorContains(t, err, "circuit open")
+	assert.Zero(t, breaker.recorded, "a refused write never reaches the broker")
+}
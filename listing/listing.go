@@ -0,0 +1,250 @@
+// Package listing implements paging, filtering and sorting for the list endpoints of the Go
+// services, so every list API takes the same parameters and answers the same way:
+//
+//	?limit=50&offset=100   page size (1-200, default 50) and the number of rows to skip; routes
+//	                       that predate paging return every row without them (ParseLegacyParams)
+//	?sort=price,-title     allow-listed fields, each optionally prefixed with "-" for descending order
+//	?source=a,b            equality filters on allow-listed fields: values are OR'ed, fields AND'ed
+//
+// The body stays a plain array. X-Total-Count carries the number of matching rows and Link the
+// next and previous pages. order-service implements the same contract for GET /api/orders.
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes, in rows
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Field maps a public field name to a SQL expression. Only these expressions ever reach the
+// query, so list parameters can't inject SQL.
+type Field struct {
+	Name string
+	Expr string
+}
+
+// Spec is what a list endpoint allows
+type Spec struct {
+	Sortable    []Field
+	Filterable  []Field   // Text expressions, compared for equality
+	DefaultSort []SortKey // Used when the request has no ?sort=
+	Unique      string    // Orders rows with equal sort keys, so pages neither overlap nor skip rows
+}
+
+// SortKey is one ORDER BY term
+type SortKey struct {
+	Expr string
+	Desc bool
+}
+
+// Filter is one equality filter; the row matches when the expression equals any of the values
+type Filter struct {
+	Expr   string
+	Values []string
+}
+
+// Params is a parsed list request
+type Params struct {
+	Limit   int // 0 for every matching row (see ParseLegacyParams)
+	Offset  int
+	Sort    []SortKey
+	Filters []Filter
+	Unique  string // The spec's Unique
+}
+
+// ParseParams reads the list parameters from the query string, rejecting unknown sort fields
+// and out-of-range pages
+func ParseParams(c *gin.Context, spec Spec) (Params, error) {
+	p := Params{Limit: DefaultLimit, Unique: spec.Unique}
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if p.Limit, err = strconv.Atoi(v); err != nil || p.Limit < 1 || p.Limit > MaxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil || p.Offset < 0 {
+			return p, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	if p.Sort, err = ParseSort(c, spec.Sortable); err != nil {
+		return p, err
+	}
+	if len(p.Sort) == 0 {
+		p.Sort = spec.DefaultSort
+	}
+
+	for _, f := range spec.Filterable {
+		if values := QueryValues(c, f.Name); len(values) > 0 {
+			p.Filters = append(p.Filters, Filter{Expr: f.Expr, Values: values})
+		}
+	}
+	return p, nil
+}
+
+// ParseLegacyParams is ParseParams for the routes that returned every row before list endpoints
+// were paged. Requests without ?limit and ?offset still get every matching row, so existing clients
+// aren't cut off at the first page; clients that want pages ask for them.
+func ParseLegacyParams(c *gin.Context, spec Spec) (Params, error) {
+	p, err := ParseParams(c, spec)
+	if err == nil && c.Query("limit") == "" && c.Query("offset") == "" {
+		p.Limit = 0
+	}
+	return p, err
+}
+
+// ParseSort reads ?sort=price,-releaseYear: a comma-separated list of sortable fields, each
+// optionally prefixed with "-" for descending order. Keys apply in the given order.
+func ParseSort(c *gin.Context, fields []Field) ([]SortKey, error) {
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, v := range QueryValues(c, "sort") {
+		name, desc := strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		expr := ""
+		for _, f := range fields {
+			if f.Name == name {
+				expr = f.Expr
+			}
+		}
+		if expr == "" {
+			return nil, fmt.Errorf("cannot sort by %q, expected one of %s", name, FieldNames(fields))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sort field %q given more than once", name)
+		}
+		seen[name] = true
+		keys = append(keys, SortKey{Expr: expr, Desc: desc})
+	}
+	return keys, nil
+}
+
+func FieldNames(fields []Field) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// QueryValues collects a repeated and/or comma-separated query parameter
+func QueryValues(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(name) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// Conditions returns one condition per filter, appending bind values to args
+func (p Params) Conditions(args *[]interface{}) []string {
+	conds := make([]string, 0, len(p.Filters))
+	for _, f := range p.Filters {
+		placeholders := make([]string, len(f.Values))
+		for i, v := range f.Values {
+			*args = append(*args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(*args))
+		}
+		conds = append(conds, f.Expr+" IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	return conds
+}
+
+// WhereClause returns " WHERE ..." joining the conditions, or "" when there are none
+func WhereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// OrderByClause renders the sort keys as an ORDER BY clause, ending with the spec's unique expression
+func (p Params) OrderByClause() string {
+	return OrderByClause(p.Sort, p.Unique)
+}
+
+// OrderByClause renders the keys as an ORDER BY clause. The unique expression always comes last,
+// so rows with equal keys keep a stable order across pages and requests.
+func OrderByClause(keys []SortKey, unique string) string {
+	terms := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		if k.Desc {
+			terms = append(terms, k.Expr+" DESC")
+		} else {
+			terms = append(terms, k.Expr+" ASC")
+		}
+	}
+	terms = append(terms, unique+" ASC")
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// PageClause returns the LIMIT and OFFSET clause, appending their values to args. Unpaged
+// requests have none.
+func (p Params) PageClause(args *[]interface{}) string {
+	if p.Limit == 0 {
+		return ""
+	}
+	*args = append(*args, p.Limit, p.Offset)
+	return fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(*args)-1, len(*args))
+}
+
+// Meta describes the page a list endpoint returned
+type Meta struct {
+	Total  int
+	Limit  int
+	Offset int
+}
+
+// meta describes a page of returned rows. A page shorter than the limit is the last one, so the
+// total follows from it; count is only called for full pages and pages past the end. Unpaged
+// requests are never counted.
+func (p Params) Meta(returned int, count func() (int, error)) (Meta, error) {
+	m := Meta{Total: p.Offset + returned, Limit: p.Limit, Offset: p.Offset}
+	if p.Limit > 0 && (returned == p.Limit || (returned == 0 && p.Offset > 0)) {
+		total, err := count()
+		if err != nil {
+			return m, err
+		}
+		m.Total = total
+	}
+	return m, nil
+}
+
+// SetHeaders reports the page as X-Total-Count and a Link header with the next and previous pages
+func (m Meta) SetHeaders(c *gin.Context) {
+	c.Header("X-Total-Count", strconv.Itoa(m.Total))
+	var links []string
+	if m.Limit > 0 && m.Offset+m.Limit < m.Total {
+		links = append(links, pageLink(c, m.Offset+m.Limit, m.Limit, "next"))
+	}
+	if m.Offset > 0 {
+		links = append(links, pageLink(c, max(m.Offset-m.Limit, 0), m.Limit, "prev"))
+	}
+	if len(links) > 0 {
+		// Added, not set: middleware may have added links of its own (e.g. album-service's successor-version)
+		c.Writer.Header().Add("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageLink is a Link header entry for the request's URL at another offset
+func pageLink(c *gin.Context, offset, limit int, rel string) string {
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+}
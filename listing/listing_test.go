@@ -0,0 +1,109 @@
+package listing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseParams(t *testing.T) {
+	spec := Spec{
+		Sortable:    []Field{{"price", "a.price"}, {"title", "lower(a.title)"}},
+		Filterable:  []Field{{"status", "p.status"}},
+		DefaultSort: []SortKey{{Expr: "a.price", Desc: true}},
+		Unique:      "a.id",
+	}
+	parse := func(query string) (Params, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/list?"+query, nil)
+		return ParseParams(c, spec)
+	}
+
+	p, err := parse("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLimit, p.Limit)
+	assert.Equal(t, " ORDER BY a.price DESC, a.id ASC", p.OrderByClause())
+
+	p, err = parse("limit=10&offset=20&sort=-title&status=open,held&status=new")
+	require.NoError(t, err)
+	var args []interface{}
+	where := WhereClause(p.Conditions(&args))
+	assert.Equal(t, " WHERE p.status IN ($1, $2, $3)", where)
+	assert.Equal(t, " ORDER BY lower(a.title) DESC, a.id ASC", p.OrderByClause())
+	assert.Equal(t, " LIMIT $4 OFFSET $5", p.PageClause(&args))
+	assert.Equal(t, []interface{}{"open", "held", "new", 10, 20}, args)
+
+	for _, query := range []string{"limit=0", "limit=201", "limit=ten", "offset=-1"} {
+		_, err := parse(query)
+		assert.Error(t, err, query)
+	}
+	_, err = parse("sort=status")
+	assert.ErrorContains(t, err, "expected one of price, title")
+}
+
+func TestParseLegacyParams(t *testing.T) {
+	spec := Spec{Unique: "a.id"}
+	parse := func(query string) Params {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/list?"+query, nil)
+		p, err := ParseLegacyParams(c, spec)
+		require.NoError(t, err)
+		return p
+	}
+
+	p := parse("")
+	assert.Equal(t, 0, p.Limit, "no page asked for")
+	args := []interface{}{"Jazz"}
+	assert.Empty(t, p.PageClause(&args))
+	assert.Equal(t, []interface{}{"Jazz"}, args)
+	m, err := p.Meta(120, func() (int, error) { t.Fatal("unpaged lists aren't counted"); return 0, nil })
+	require.NoError(t, err)
+	assert.Equal(t, Meta{Total: 120}, m)
+
+	rr := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rr)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/albums", nil)
+	m.SetHeaders(c)
+	assert.Equal(t, "120", rr.Header().Get("X-Total-Count"))
+	assert.Empty(t, rr.Header().Get("Link"))
+
+	assert.Equal(t, DefaultLimit, parse("offset=50").Limit)
+	assert.Equal(t, 10, parse("limit=10").Limit)
+}
+
+func TestMeta(t *testing.T) {
+	counted := 0
+	count := func() (int, error) { counted++; return 95, nil }
+	page := Params{Limit: 20, Offset: 40}
+
+	m, err := page.Meta(20, count)
+	require.NoError(t, err)
+	assert.Equal(t, Meta{Total: 95, Limit: 20, Offset: 40}, m)
+	assert.Equal(t, 1, counted, "full pages are counted")
+
+	m, err = Params{Limit: 20, Offset: 80}.Meta(15, count)
+	require.NoError(t, err)
+	assert.Equal(t, 95, m.Total)
+	assert.Equal(t, 1, counted, "the last page needs no count")
+
+	_, err = Params{Limit: 20, Offset: 200}.Meta(0, func() (int, error) { return 0, errors.New("down") })
+	assert.Error(t, err, "pages past the end are counted")
+
+	rr := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rr)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/albums?genre=Jazz&offset=40&limit=20", nil)
+	Meta{Total: 95, Limit: 20, Offset: 40}.SetHeaders(c)
+	assert.Equal(t, "95", rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</api/albums?genre=Jazz&limit=20&offset=60>; rel="next", </api/albums?genre=Jazz&limit=20&offset=20>; rel="prev"`, rr.Header().Get("Link"))
+
+	rr = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rr)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/albums", nil)
+	Meta{Total: 3, Limit: 50}.SetHeaders(c)
+	assert.Empty(t, rr.Header().Get("Link"), "a single page has no links")
+}
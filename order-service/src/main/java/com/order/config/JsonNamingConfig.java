@@ -0,0 +1,28 @@
+package com.order.config;
+
+import com.fasterxml.jackson.databind.ObjectMapper;
+import com.fasterxml.jackson.databind.PropertyNamingStrategies;
+import com.order.kafka.JsonFieldNaming;
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.context.annotation.Bean;
+import org.springframework.context.annotation.Configuration;
+import org.springframework.http.converter.json.MappingJackson2HttpMessageConverter;
+
+/**
+ * Field naming of request and response bodies (JSON_FIELD_NAMING). Only the HTTP converter is
+ * renamed: the shared ObjectMapper keeps camelCase for the event payload classes, which
+ * OrderEventSchema renames itself.
+ */
+@Configuration
+public class JsonNamingConfig {
+
+    @Bean
+    public MappingJackson2HttpMessageConverter mappingJackson2HttpMessageConverter(
+            ObjectMapper objectMapper, @Value("${json.field-naming:camelCase}") String fieldNaming) {
+        ObjectMapper httpMapper = objectMapper;
+        if (JsonFieldNaming.SNAKE_CASE.equals(JsonFieldNaming.checkSupported(fieldNaming))) {
+            httpMapper = objectMapper.copy().setPropertyNamingStrategy(PropertyNamingStrategies.SNAKE_CASE);
+        }
+        return new MappingJackson2HttpMessageConverter(httpMapper);
+    }
+}
@@ -0,0 +1,29 @@
+package com.order.controller;
+
+import com.order.model.LibraryItem;
+import com.order.service.OrderGifting;
+import lombok.RequiredArgsConstructor;
+import org.springframework.http.HttpStatus;
+import org.springframework.http.ResponseEntity;
+import org.springframework.web.bind.annotation.*;
+
+import java.util.List;
+
+@RestController
+@RequestMapping("/api/library")
+@RequiredArgsConstructor
+public class LibraryController {
+
+    private final OrderGifting orderGifting;
+
+    // Albums given to the user, newest first; for the user themselves or an admin
+    @GetMapping
+    public ResponseEntity<List<LibraryItem>> getLibrary(
+            @RequestHeader("Client-Type") String clientType,
+            @RequestParam String userId) {
+        if (!"admin".equals(clientType) && !"user".equals(clientType)) {
+            return ResponseEntity.status(HttpStatus.FORBIDDEN).build();
+        }
+        return ResponseEntity.ok(orderGifting.library(userId));
+    }
+}
@@ -1,15 +1,25 @@
 package com.order.controller;
 
 import com.order.model.Order;
+import com.order.model.OrderListQuery;
+import com.order.model.OrderPage;
+import com.order.model.OrderProgress;
 import com.order.service.OrderService;
 import lombok.RequiredArgsConstructor;
 import lombok.extern.slf4j.Slf4j;
+import org.springframework.http.HttpHeaders;
 import org.springframework.http.HttpStatus;
 import org.springframework.http.ResponseEntity;
+import org.springframework.util.MultiValueMap;
 import org.springframework.web.bind.annotation.*;
+import org.springframework.web.util.UriComponentsBuilder;
 
 import javax.persistence.EntityNotFoundException;
+import javax.servlet.http.HttpServletRequest;
+import java.util.ArrayList;
 import java.util.List;
+import java.util.Map;
+import java.util.TreeMap;
 
 @RestController
 @RequestMapping("/api/orders")
@@ -19,14 +29,49 @@ public class OrderController {
 
     private final OrderService orderService;
 
+    // Paged like the Go services' list endpoints (see OrderListQuery): the body is a plain array,
+    // X-Total-Count the number of matching orders and Link the next and previous pages
     @GetMapping
-    public ResponseEntity<List<Order>> getAllOrders(@RequestHeader("Client-Type") String clientType) {
+    public ResponseEntity<?> getAllOrders(
+            @RequestHeader("Client-Type") String clientType,
+            @RequestParam MultiValueMap<String, String> params,
+            HttpServletRequest request) {
         // Only admin can get all orders
         if (!"admin".equals(clientType)) {
             return ResponseEntity.status(HttpStatus.FORBIDDEN).build();
         }
-        
-        return ResponseEntity.ok(orderService.getAllOrders());
+
+        OrderListQuery query;
+        try {
+            query = OrderListQuery.parse(params);
+        } catch (IllegalArgumentException e) {
+            return ResponseEntity.badRequest().body(Map.of("error", e.getMessage()));
+        }
+        OrderPage page = orderService.getOrders(query);
+
+        HttpHeaders headers = new HttpHeaders();
+        headers.set("X-Total-Count", String.valueOf(page.getTotal()));
+        List<String> links = new ArrayList<>();
+        if (page.getOffset() + page.getLimit() < page.getTotal()) {
+            links.add(pageLink(request, page.getOffset() + page.getLimit(), page.getLimit(), "next"));
+        }
+        if (page.getOffset() > 0) {
+            links.add(pageLink(request, Math.max(page.getOffset() - page.getLimit(), 0), page.getLimit(), "prev"));
+        }
+        if (!links.isEmpty()) {
+            headers.set(HttpHeaders.LINK, String.join(", ", links));
+        }
+        return ResponseEntity.ok().headers(headers).body(page.getOrders());
+    }
+
+    // A Link header entry for the request's URL at another offset, with the parameters sorted by name
+    private static String pageLink(HttpServletRequest request, int offset, int limit, String rel) {
+        Map<String, String[]> params = new TreeMap<>(request.getParameterMap());
+        params.put("limit", new String[]{String.valueOf(limit)});
+        params.put("offset", new String[]{String.valueOf(offset)});
+        UriComponentsBuilder uri = UriComponentsBuilder.fromPath(request.getRequestURI());
+        params.forEach((name, values) -> uri.queryParam(name, (Object[]) values));
+        return "<" + uri.encode().build().toUriString() + ">; rel=\"" + rel + "\"";
     }
 
     @GetMapping("/{id}")
@@ -49,8 +94,18 @@ public class OrderController {
         }
     }
 
+    // Public order status page: no Client-Type needed, the signed token is the credential
+    @GetMapping("/status/{token}")
+    public ResponseEntity<OrderProgress> getOrderStatus(@PathVariable String token) {
+        try {
+            return ResponseEntity.ok(orderService.getOrderProgress(token));
+        } catch (EntityNotFoundException e) {
+            return ResponseEntity.notFound().build();
+        }
+    }
+
     @PostMapping
-    public ResponseEntity<Order> createOrder(
+    public ResponseEntity<?> createOrder(
             @RequestBody Order order,
             @RequestHeader("Client-Type") String clientType) {
         
@@ -60,7 +115,14 @@ public class OrderController {
         }
         
         // Logic inside createOrder in ServiceImpl handles Kafka event
-        Order createdOrder = orderService.createOrder(order);
-        return ResponseEntity.status(HttpStatus.CREATED).body(createdOrder);
+        try {
+            Order createdOrder = orderService.createOrder(order);
+            return ResponseEntity.status(HttpStatus.CREATED).body(createdOrder);
+        } catch (IllegalArgumentException e) {
+            return ResponseEntity.badRequest().body(Map.of("error", e.getMessage()));
+        } catch (IllegalStateException e) {
+            // The album price couldn't be read, so the order can't be priced
+            return ResponseEntity.status(HttpStatus.SERVICE_UNAVAILABLE).body(Map.of("error", e.getMessage()));
+        }
     }
 } 
\ No newline at end of file
@@ -0,0 +1,88 @@
+package com.order.kafka;
+
+import com.fasterxml.jackson.databind.JsonNode;
+import com.fasterxml.jackson.databind.node.ArrayNode;
+import com.fasterxml.jackson.databind.node.JsonNodeFactory;
+import com.fasterxml.jackson.databind.node.ObjectNode;
+
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.function.UnaryOperator;
+import java.util.regex.Pattern;
+import java.util.stream.Collectors;
+
+/**
+ * JSON field naming of events and responses, selected per deployment with JSON_FIELD_NAMING
+ * (camelCase or snake_case), mirroring json_naming.go in the Go services. Only keys that are
+ * identifiers in the other naming are renamed, so data used as keys passes through unchanged.
+ */
+public final class JsonFieldNaming {
+
+    public static final String CAMEL_CASE = "camelCase";
+    public static final String SNAKE_CASE = "snake_case";
+
+    private static final Pattern CAMEL_CASE_IDENTIFIER = Pattern.compile("[a-z][a-z0-9]*[A-Z][A-Za-z0-9]*");
+    private static final Pattern SNAKE_CASE_IDENTIFIER = Pattern.compile("[a-z][a-z0-9]*(_[a-z0-9]+)+");
+
+    private JsonFieldNaming() {
+    }
+
+    public static String checkSupported(String naming) {
+        if (!CAMEL_CASE.equals(naming) && !SNAKE_CASE.equals(naming)) {
+            throw new IllegalArgumentException("JSON field naming must be camelCase or snake_case: " + naming);
+        }
+        return naming;
+    }
+
+    /** "releaseYear" to "release_year"; acronyms are one word ("albumID" to "album_id"). */
+    public static String snakeCase(String key) {
+        if (!CAMEL_CASE_IDENTIFIER.matcher(key).matches()) {
+            return key;
+        }
+        return key.replaceAll("(?<=[a-z0-9])(?=[A-Z])", "_")
+                .replaceAll("(?<=[A-Z])(?=[A-Z][a-z].)", "_")
+                .toLowerCase();
+    }
+
+    /** "release_year" to "releaseYear". */
+    public static String camelCase(String key) {
+        if (!SNAKE_CASE_IDENTIFIER.matcher(key).matches()) {
+            return key;
+        }
+        String[] words = key.split("_");
+        StringBuilder camel = new StringBuilder(words[0]);
+        for (int i = 1; i < words.length; i++) {
+            camel.append(Character.toUpperCase(words[i].charAt(0))).append(words[i].substring(1));
+        }
+        return camel.toString();
+    }
+
+    /** Copy of a payload of maps, lists and values with every map key renamed. */
+    public static Object renameKeys(Object value, UnaryOperator<String> rename) {
+        if (value instanceof Map) {
+            Map<String, Object> renamed = new LinkedHashMap<>();
+            ((Map<?, ?>) value).forEach((key, v) -> renamed.put(rename.apply(String.valueOf(key)), renameKeys(v, rename)));
+            return renamed;
+        }
+        if (value instanceof List) {
+            return ((List<?>) value).stream().map(v -> renameKeys(v, rename)).collect(Collectors.toList());
+        }
+        return value;
+    }
+
+    /** Copy of a JSON tree with every object key renamed. */
+    public static JsonNode renameKeys(JsonNode node, UnaryOperator<String> rename) {
+        if (node.isObject()) {
+            ObjectNode renamed = JsonNodeFactory.instance.objectNode();
+            node.fields().forEachRemaining(field -> renamed.set(rename.apply(field.getKey()), renameKeys(field.getValue(), rename)));
+            return renamed;
+        }
+        if (node.isArray()) {
+            ArrayNode renamed = JsonNodeFactory.instance.arrayNode();
+            node.forEach(element -> renamed.add(renameKeys(element, rename)));
+            return renamed;
+        }
+        return node;
+    }
+}
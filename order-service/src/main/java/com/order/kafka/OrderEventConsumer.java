@@ -5,10 +5,13 @@ import com.fasterxml.jackson.core.JsonProcessingException;
 import com.fasterxml.jackson.databind.ObjectMapper;
 import com.order.model.Order;
 import com.order.repository.OrderRepository;
+import com.order.service.OrderGifting;
+import com.order.service.OrderStatusHistory;
 import lombok.Data;
 import lombok.RequiredArgsConstructor;
 import lombok.extern.slf4j.Slf4j;
 import org.springframework.kafka.annotation.KafkaListener;
+import org.springframework.kafka.listener.adapter.ConsumerRecordMetadata;
 import org.springframework.stereotype.Component;
 import org.springframework.transaction.annotation.Transactional;
 
@@ -22,10 +25,15 @@ public class OrderEventConsumer {
 
     private final OrderRepository orderRepository;
     private final ObjectMapper objectMapper; // For parsing JSON
+    private final OrderEventSchema eventSchema;
+    private final OrderStatusHistory statusHistory;
+    private final OrderGifting orderGifting;
 
     // Define constants for status
     private static final String STATUS_SUCCEEDED = "SUCCEEDED";
     private static final String STATUS_FAILED = "FAILED";
+    private static final String ORDER_SUCCEEDED_EVENT_TYPE = "order.succeeded";
+    private static final String ORDER_FAILED_EVENT_TYPE = "order.failed";
 
     // DTO for order-succeeded event payload (matching Go struct)
     @Data // Lombok annotation for getters, setters, etc.
@@ -43,13 +51,20 @@ public class OrderEventConsumer {
         private String reason; // Match Go's reason field
     }
 
-    @KafkaListener(topics = "order-succeeded", groupId = "order-service-status-updater")
+    // Topics follow the consumed schema version and environment scoping (see OrderEventSchema);
+    // group names follow the kafka.topic-prefix / kafka.topic-suffix environment scoping
+    @KafkaListener(topics = "#{@orderEventSchema.consumeTopic('order-succeeded')}",
+            groupId = "${kafka.topic-prefix:}order-service-status-updater${kafka.topic-suffix:}")
     @Transactional // Ensure database update is transactional
-    public void handleOrderSucceeded(String message) {
+    public void handleOrderSucceeded(String message, ConsumerRecordMetadata record) {
         log.info("Received order-succeeded event: {}", message);
         try {
-            OrderSucceededPayload payload = objectMapper.readValue(message, OrderSucceededPayload.class);
-            updateOrderStatus(payload.getOrderId(), STATUS_SUCCEEDED, null);
+            if (!eventSchema.isEventType(objectMapper, message, ORDER_SUCCEEDED_EVENT_TYPE)) {
+                log.warn("Skipping event of unhandled type on order-succeeded: {}", message);
+                return;
+            }
+            OrderSucceededPayload payload = objectMapper.treeToValue(eventSchema.decode(objectMapper, message), OrderSucceededPayload.class);
+            updateOrderStatus(payload.getOrderId(), STATUS_SUCCEEDED, null, sourceEventId(message, record));
         } catch (JsonProcessingException e) {
             log.error("Error parsing order-succeeded event JSON: {}", message, e);
             // Decide how to handle parsing errors (e.g., DLQ)
@@ -59,13 +74,18 @@ public class OrderEventConsumer {
         }
     }
 
-    @KafkaListener(topics = "order-failed", groupId = "order-service-status-updater")
+    @KafkaListener(topics = "#{@orderEventSchema.consumeTopic('order-failed')}",
+            groupId = "${kafka.topic-prefix:}order-service-status-updater${kafka.topic-suffix:}")
     @Transactional
-    public void handleOrderFailed(String message) {
+    public void handleOrderFailed(String message, ConsumerRecordMetadata record) {
         log.info("Received order-failed event: {}", message);
         try {
-            OrderFailedPayload payload = objectMapper.readValue(message, OrderFailedPayload.class);
-            updateOrderStatus(payload.getOrderId(), STATUS_FAILED, payload.getReason());
+            if (!eventSchema.isEventType(objectMapper, message, ORDER_FAILED_EVENT_TYPE)) {
+                log.warn("Skipping event of unhandled type on order-failed: {}", message);
+                return;
+            }
+            OrderFailedPayload payload = objectMapper.treeToValue(eventSchema.decode(objectMapper, message), OrderFailedPayload.class);
+            updateOrderStatus(payload.getOrderId(), STATUS_FAILED, payload.getReason(), sourceEventId(message, record));
         } catch (JsonProcessingException e) {
             log.error("Error parsing order-failed event JSON: {}", message, e);
             // Decide how to handle parsing errors (e.g., DLQ)
@@ -75,19 +95,31 @@ public class OrderEventConsumer {
         }
     }
 
-    private void updateOrderStatus(String orderIdStr, String newStatus, String reason) {
+    // v1 events have no eventId; their position in the topic identifies them just as well
+    private String sourceEventId(String message, ConsumerRecordMetadata record) throws JsonProcessingException {
+        return eventSchema.eventId(objectMapper, message)
+                .orElseGet(() -> record.topic() + "-" + record.partition() + "@" + record.offset());
+    }
+
+    private void updateOrderStatus(String orderIdStr, String newStatus, String reason, String sourceEventId) {
         try {
             Long orderId = Long.parseLong(orderIdStr);
             Optional<Order> orderOptional = orderRepository.findById(orderId);
 
-            if (orderOptional.isPresent()) {
+            if (orderOptional.isPresent() && statusHistory.isRecorded(orderId, sourceEventId)) {
+                log.info("Skipping redelivered event {} for Order ID {}", sourceEventId, orderId);
+            } else if (orderOptional.isPresent()) {
                 Order order = orderOptional.get();
                 // Optional: Add check to prevent updating already finalized status?
                 // if (!order.getStatus().equals("PENDING")) { ... }
                 order.setStatus(newStatus);
-                // Optionally store the failure reason if needed in the Order entity
+                // The failure reason is kept in the status history
                 orderRepository.save(order);
+                statusHistory.record(orderId, newStatus, reason, sourceEventId);
                 log.info("Successfully updated status for Order ID {} to {}", orderId, newStatus);
+                if (STATUS_SUCCEEDED.equals(newStatus)) {
+                    orderGifting.deliver(order); // Gift orders reach the recipient's library
+                }
             } else {
                 log.warn("Order with ID {} not found when trying to update status to {}. Event might be stale or order deleted.", orderId, newStatus);
                 // Consider logging this to an alert system or specific log file
@@ -0,0 +1,135 @@
+package com.order.kafka;
+
+import com.fasterxml.jackson.core.JsonProcessingException;
+import com.fasterxml.jackson.databind.JsonNode;
+import com.fasterxml.jackson.databind.ObjectMapper;
+import lombok.extern.slf4j.Slf4j;
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.stereotype.Component;
+
+import java.nio.charset.StandardCharsets;
+import java.time.Instant;
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.Optional;
+import java.util.UUID;
+
+/**
+ * Versioned order event payloads for zero-downtime schema migrations, mirroring the Go services.
+ * v1 is the original flat payload; v2 wraps it in the standard event envelope (eventType, eventId,
+ * producer, occurredAt) under "data". Each version has its own topic ("order-created" for v1,
+ * "order-created.v2" for v2) so producers can dual-publish while consumers move over one at a time.
+ * Consumers accept either version on any topic and skip event types they don't handle.
+ * Events are published in the deployment's JSON field naming and read in either (see JsonFieldNaming).
+ */
+@Component
+@Slf4j
+public class OrderEventSchema {
+
+    public static final int V1 = 1;
+    public static final int V2 = 2;
+
+    /** Names this service in the envelope of the events it publishes. */
+    public static final String PRODUCER = "order-service";
+
+    private final List<Integer> publishVersions;
+    private final int consumeVersion;
+    private final String topicPrefix;
+    private final String topicSuffix;
+    private final String fieldNaming;
+
+    public OrderEventSchema(
+            @Value("${order.events.publish-versions:1,2}") List<Integer> publishVersions,
+            @Value("${order.events.consume-version:2}") int consumeVersion,
+            @Value("${kafka.topic-prefix:}") String topicPrefix,
+            @Value("${kafka.topic-suffix:}") String topicSuffix,
+            @Value("${json.field-naming:camelCase}") String fieldNaming) {
+        for (int version : publishVersions) {
+            checkSupported(version);
+        }
+        checkSupported(consumeVersion);
+        this.publishVersions = List.copyOf(publishVersions);
+        this.consumeVersion = consumeVersion;
+        this.topicPrefix = topicPrefix;
+        this.topicSuffix = topicSuffix;
+        this.fieldNaming = JsonFieldNaming.checkSupported(fieldNaming);
+    }
+
+    private static void checkSupported(int version) {
+        if (version < V1 || version > V2) {
+            throw new IllegalArgumentException("Unsupported order event schema version: " + version);
+        }
+    }
+
+    public List<Integer> getPublishVersions() {
+        return publishVersions;
+    }
+
+    /** Environment-scoped topic name for a base topic in the given schema version. */
+    public String topic(String baseTopic, int version) {
+        String versioned = version == V1 ? baseTopic : baseTopic + ".v" + version;
+        return topicPrefix + versioned + topicSuffix;
+    }
+
+    /** Topic a listener for the base topic reads from; referenced from @KafkaListener via SpEL. */
+    public String consumeTopic(String baseTopic) {
+        return topic(baseTopic, consumeVersion);
+    }
+
+    /** Builds the payload to publish in the given version; eventId is shared by every version of one event. */
+    public Object encode(int version, String eventType, String eventId, Map<String, Object> payload) {
+        Object event = payload;
+        if (version != V1) {
+            Map<String, Object> envelope = new LinkedHashMap<>();
+            envelope.put("schemaVersion", version);
+            envelope.put("eventType", eventType);
+            envelope.put("eventId", eventId);
+            envelope.put("producer", PRODUCER);
+            envelope.put("occurredAt", Instant.now().toString());
+            envelope.put("data", payload);
+            event = envelope;
+        }
+        return JsonFieldNaming.SNAKE_CASE.equals(fieldNaming) ? JsonFieldNaming.renameKeys(event, JsonFieldNaming::snakeCase) : event;
+    }
+
+    /**
+     * Event ID derived from key, e.g. "order.created:42", so an event published again keeps its ID
+     * and consumers deduplicating by ID handle it once.
+     */
+    public static String stableEventId(String key) {
+        return UUID.nameUUIDFromBytes(key.getBytes(StandardCharsets.UTF_8)).toString();
+    }
+
+    /** Whether a message is of the expected type; v1 payloads carry no type, their topic implies it. */
+    public boolean isEventType(ObjectMapper objectMapper, String message, String expectedType) throws JsonProcessingException {
+        JsonNode eventType = readTree(objectMapper, message).path("eventType");
+        return !eventType.isTextual() || eventType.asText().isEmpty() || eventType.asText().equals(expectedType);
+    }
+
+    /** Returns the eventId of a v2 message; v1 payloads carry none. */
+    public Optional<String> eventId(ObjectMapper objectMapper, String message) throws JsonProcessingException {
+        JsonNode eventId = readTree(objectMapper, message).path("eventId");
+        return eventId.isTextual() && !eventId.asText().isEmpty() ? Optional.of(eventId.asText()) : Optional.empty();
+    }
+
+    /** Returns the v1-shaped, camelCase payload of a v1 or v2 message in either field naming. */
+    public JsonNode decode(ObjectMapper objectMapper, String message) throws JsonProcessingException {
+        JsonNode root = readTree(objectMapper, message);
+        int version = root.path("schemaVersion").asInt(V1);
+        log.debug("Observed order event schema v{}", version);
+        if (version == V1) {
+            return root;
+        }
+        checkSupported(version);
+        JsonNode data = root.get("data");
+        if (data == null || data.isNull()) {
+            throw new IllegalArgumentException("Order event schema v" + version + " without data");
+        }
+        return data;
+    }
+
+    private static JsonNode readTree(ObjectMapper objectMapper, String message) throws JsonProcessingException {
+        return JsonFieldNaming.renameKeys(objectMapper.readTree(message), JsonFieldNaming::camelCase);
+    }
+}
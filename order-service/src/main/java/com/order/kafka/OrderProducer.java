@@ -1,6 +1,7 @@
 package com.order.kafka;
 
 import com.order.model.Order;
+import com.order.model.PriceSnapshot;
 import lombok.RequiredArgsConstructor;
 import lombok.extern.slf4j.Slf4j;
 import org.springframework.kafka.core.KafkaTemplate;
@@ -19,9 +20,13 @@ import java.util.Map;
 public class OrderProducer {
 
     private final KafkaTemplate<String, Object> kafkaTemplate;
+    private final OrderEventSchema eventSchema;
     
-    // Topic names
+    // Base topic name; OrderEventSchema adds the schema version and environment scoping
     private static final String ORDER_CREATED_TOPIC = "order-created";
+    private static final String ORDER_CREATED_EVENT_TYPE = "order.created";
+    private static final String ORDER_GIFTED_TOPIC = "order-gifted";
+    private static final String ORDER_GIFTED_EVENT_TYPE = "order.gifted";
     // Removed unused topics:
     // private static final String PAYMENT_PROCESSED_TOPIC = "payment-processed";
     // private static final String ORDER_CONFIRMATIONS_TOPIC = "order-confirmations";
@@ -42,11 +47,67 @@ public class OrderProducer {
         message.put("userId", order.getUserId());
         message.put("albumId", order.getAlbumId());
         message.put("quantity", order.getQuantity());
+        if (order.getPickupWarehouseId() != null) {
+            message.put("pickupWarehouseId", order.getPickupWarehouseId());
+        }
+        if (order.getRecipientUserId() != null) {
+            message.put("recipientUserId", order.getRecipientUserId());
+        }
+        if (order.getPrice() != null) {
+            PriceSnapshot price = order.getPrice();
+            Map<String, Object> priceMessage = new HashMap<>();
+            priceMessage.put("unitPrice", price.getUnitPrice());
+            priceMessage.put("discountAmount", price.getDiscountAmount());
+            priceMessage.put("taxAmount", price.getTaxAmount());
+            priceMessage.put("totalPrice", price.getTotalPrice());
+            priceMessage.put("currency", price.getCurrency());
+            message.put("price", priceMessage);
+        }
+        if (order.getMetadata() != null && !order.getMetadata().isEmpty()) {
+            message.put("metadata", order.getMetadata());
+        }
         // Use default Instant toString() which is ISO-8601 UTC
         message.put("timestamp", LocalDateTime.now().toInstant(ZoneOffset.UTC).toString()); 
         
-        log.info("Sending order created event to topic '{}': {}", ORDER_CREATED_TOPIC, message);
-        kafkaTemplate.send(ORDER_CREATED_TOPIC, orderId, message);
+        // Published once per configured schema version (dual-publish during migrations)
+        for (int version : eventSchema.getPublishVersions()) {
+            String topic = eventSchema.topic(ORDER_CREATED_TOPIC, version);
+            log.info("Sending order created event (schema v{}) to topic '{}': {}", version, topic, message);
+            kafkaTemplate.send(topic, orderId,
+                    eventSchema.encode(version, ORDER_CREATED_EVENT_TYPE, orderCreatedEventId(order.getId()), message));
+        }
+    }
+
+    /**
+     * Sends an order gifted event once a gift order succeeded, so the recipient can be notified. The
+     * price stays with the purchaser's order and isn't part of the event.
+     */
+    public void sendOrderGiftedEvent(Order order) {
+        String orderId = order.getId().toString();
+
+        Map<String, Object> message = new HashMap<>();
+        message.put("orderId", orderId);
+        message.put("purchaserUserId", order.getUserId());
+        message.put("recipientUserId", order.getRecipientUserId());
+        message.put("albumId", order.getAlbumId());
+        message.put("quantity", order.getQuantity());
+        if (order.getMetadata() != null && order.getMetadata().containsKey("giftNote")) {
+            message.put("giftNote", order.getMetadata().get("giftNote"));
+        }
+        message.put("timestamp", LocalDateTime.now().toInstant(ZoneOffset.UTC).toString());
+
+        for (int version : eventSchema.getPublishVersions()) {
+            String topic = eventSchema.topic(ORDER_GIFTED_TOPIC, version);
+            log.info("Sending order gifted event (schema v{}) to topic '{}': {}", version, topic, message);
+            kafkaTemplate.send(topic, orderId,
+                    eventSchema.encode(version, ORDER_GIFTED_EVENT_TYPE,
+                            OrderEventSchema.stableEventId(ORDER_GIFTED_EVENT_TYPE + ":" + order.getId()), message));
+        }
+    }
+
+    /** eventId of the order-created event of an order, the same in every schema version. */
+    public static String orderCreatedEventId(Long orderId) {
+        return OrderEventSchema.stableEventId(ORDER_CREATED_EVENT_TYPE + ":" + orderId);
     }
     
     // Removed sendPaymentProcessedEvent method
@@ -0,0 +1,58 @@
+package com.order.model;
+
+import com.fasterxml.jackson.annotation.JsonIgnore;
+import com.fasterxml.jackson.annotation.JsonInclude;
+import lombok.AllArgsConstructor;
+import lombok.Builder;
+import lombok.Data;
+import lombok.NoArgsConstructor;
+
+import javax.persistence.*;
+import java.time.LocalDateTime;
+
+/**
+ * An album in a user's library. Items are granted by gift orders when they succeed (see OrderGifting);
+ * the order ID is unique, so a redelivered order-succeeded event grants nothing twice.
+ */
+@Entity
+@Table(name = "user_library", indexes = @Index(columnList = "userId, grantedAt"),
+        uniqueConstraints = @UniqueConstraint(columnNames = {"orderId"}))
+@Data
+@NoArgsConstructor
+@AllArgsConstructor
+@Builder
+public class LibraryItem {
+
+    @Id
+    @GeneratedValue(strategy = GenerationType.IDENTITY)
+    @JsonIgnore
+    private Long id;
+
+    @Column(nullable = false)
+    @JsonIgnore
+    private String userId;
+
+    @Column(nullable = false)
+    private String albumId;
+
+    @Column(nullable = false)
+    private Integer quantity;
+
+    @Column(nullable = false)
+    @JsonIgnore
+    private Long orderId;
+
+    // User who gave the album
+    @JsonInclude(JsonInclude.Include.NON_NULL)
+    private String giftedBy;
+
+    @Column(nullable = false)
+    private LocalDateTime grantedAt;
+
+    @PrePersist
+    protected void onCreate() {
+        if (grantedAt == null) {
+            grantedAt = LocalDateTime.now();
+        }
+    }
+}
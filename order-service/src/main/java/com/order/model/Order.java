@@ -1,5 +1,6 @@
 package com.order.model;
 
+import com.fasterxml.jackson.annotation.JsonInclude;
 import lombok.AllArgsConstructor;
 import lombok.Builder;
 import lombok.Data;
@@ -8,6 +9,9 @@ import lombok.NoArgsConstructor;
 import javax.persistence.*;
 import java.math.BigDecimal;
 import java.time.LocalDateTime;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
 
 @Entity
 @Table(name = "orders")
@@ -25,8 +29,40 @@ public class Order {
     private String albumId;
     private Integer quantity;
 
+    // Optional: warehouse the customer picks the order up from; stock must come from that warehouse only
+    private String pickupWarehouseId;
+
+    // Optional: user the order is a gift for; the album is added to their library when the order
+    // succeeds (see OrderGifting). The purchaser (userId) keeps the order and its payment record.
+    private String recipientUserId;
+
+    // Optional: discount code of an album-service promotion; promotions without a code apply anyway
+    private String discountCode;
+
     private String status;
 
+    // Prices at creation; set by the service, never taken from the request
+    @Embedded
+    private PriceSnapshot price;
+
+    // Free-form order extras (gift note, wrapping option, ...); keys are whitelisted by OrderMetadataValidator
+    @ElementCollection(fetch = FetchType.EAGER)
+    @CollectionTable(name = "order_metadata", joinColumns = @JoinColumn(name = "order_id"))
+    @MapKeyColumn(name = "meta_key", length = 64)
+    @Column(name = "meta_value", length = 1000)
+    @Builder.Default
+    private Map<String, String> metadata = new HashMap<>();
+
+    // Signed token for GET /api/orders/status/{token}; derived from the ID, so it isn't stored
+    @Transient
+    @JsonInclude(JsonInclude.Include.NON_NULL)
+    private String statusToken;
+
+    // Status changes, oldest first; only the order detail (GET /api/orders/{id}) loads them
+    @Transient
+    @JsonInclude(JsonInclude.Include.NON_NULL)
+    private List<OrderStatusChange> timeline;
+
     private LocalDateTime createdAt;
     private LocalDateTime updatedAt;
 
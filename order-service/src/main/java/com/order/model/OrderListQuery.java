@@ -0,0 +1,135 @@
+package com.order.model;
+
+import lombok.Getter;
+import org.springframework.util.MultiValueMap;
+
+import java.util.ArrayList;
+import java.util.HashSet;
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.Set;
+
+/**
+ * A parsed GET /api/orders request. It takes the same parameters as the list endpoints of the Go
+ * services (album-store/listing): {@code limit} (1-200, default 50) and {@code offset},
+ * {@code sort} with allow-listed fields prefixed with "-" for descending order, and equality filters
+ * whose values are OR'ed while different filters are AND'ed. Values can be repeated or comma-separated.
+ */
+@Getter
+public class OrderListQuery {
+
+    public static final int DEFAULT_LIMIT = 50;
+    public static final int MAX_LIMIT = 200;
+
+    // Public field names and the Order attributes they map to; nothing else reaches the query
+    private static final Map<String, String> SORTABLE = new LinkedHashMap<>();
+    private static final Map<String, String> FILTERABLE = new LinkedHashMap<>();
+
+    static {
+        SORTABLE.put("createdAt", "createdAt");
+        SORTABLE.put("totalPrice", "price.totalPrice");
+        FILTERABLE.put("status", "status");
+        FILTERABLE.put("userId", "userId");
+        FILTERABLE.put("albumId", "albumId");
+    }
+
+    private static final List<SortKey> DEFAULT_SORT = List.of(new SortKey("createdAt", true));
+
+    private final int limit;
+    private final int offset;
+    private final List<SortKey> sort;
+    private final Map<String, List<String>> filters;
+
+    public OrderListQuery(int limit, int offset, List<SortKey> sort, Map<String, List<String>> filters) {
+        this.limit = limit;
+        this.offset = offset;
+        this.sort = sort;
+        this.filters = filters;
+    }
+
+    /**
+     * One ORDER BY term; the attribute path may go through embedded objects, such as price.totalPrice.
+     */
+    @Getter
+    public static class SortKey {
+        private final String attribute;
+        private final boolean desc;
+
+        public SortKey(String attribute, boolean desc) {
+            this.attribute = attribute;
+            this.desc = desc;
+        }
+    }
+
+    /**
+     * @throws IllegalArgumentException for unknown sort fields and out-of-range pages
+     */
+    public static OrderListQuery parse(MultiValueMap<String, String> params) {
+        int limit = DEFAULT_LIMIT;
+        String v = params.getFirst("limit");
+        if (v != null && !v.isEmpty()) {
+            limit = parseInt(v, -1);
+            if (limit < 1 || limit > MAX_LIMIT) {
+                throw new IllegalArgumentException("limit must be between 1 and " + MAX_LIMIT);
+            }
+        }
+        int offset = 0;
+        v = params.getFirst("offset");
+        if (v != null && !v.isEmpty()) {
+            offset = parseInt(v, -1);
+            if (offset < 0) {
+                throw new IllegalArgumentException("offset must be a non-negative integer");
+            }
+        }
+
+        List<SortKey> sort = new ArrayList<>();
+        Set<String> seen = new HashSet<>();
+        for (String key : values(params, "sort")) {
+            boolean desc = key.startsWith("-");
+            String name = desc ? key.substring(1) : key;
+            String attribute = SORTABLE.get(name);
+            if (attribute == null) {
+                throw new IllegalArgumentException("cannot sort by \"" + name + "\", expected one of "
+                        + String.join(", ", SORTABLE.keySet()));
+            }
+            if (!seen.add(name)) {
+                throw new IllegalArgumentException("sort field \"" + name + "\" given more than once");
+            }
+            sort.add(new SortKey(attribute, desc));
+        }
+        if (sort.isEmpty()) {
+            sort = DEFAULT_SORT;
+        }
+
+        Map<String, List<String>> filters = new LinkedHashMap<>();
+        FILTERABLE.forEach((name, attribute) -> {
+            List<String> filterValues = values(params, name);
+            if (!filterValues.isEmpty()) {
+                filters.put(attribute, filterValues);
+            }
+        });
+        return new OrderListQuery(limit, offset, sort, filters);
+    }
+
+    // Collects a repeated and/or comma-separated query parameter
+    private static List<String> values(MultiValueMap<String, String> params, String name) {
+        List<String> values = new ArrayList<>();
+        for (String raw : params.getOrDefault(name, List.of())) {
+            for (String value : raw.split(",")) {
+                if (!value.trim().isEmpty()) {
+                    values.add(value.trim());
+                }
+            }
+        }
+        return values;
+    }
+
+    private static int parseInt(String value, int invalid) {
+        try {
+            return Integer.parseInt(value);
+        } catch (NumberFormatException e) {
+            return invalid;
+        }
+    }
+}
@@ -0,0 +1,18 @@
+package com.order.model;
+
+import lombok.AllArgsConstructor;
+import lombok.Data;
+
+import java.util.List;
+
+/**
+ * A page of orders, with the number of orders matching the query for X-Total-Count
+ */
+@Data
+@AllArgsConstructor
+public class OrderPage {
+    private List<Order> orders;
+    private long total;
+    private int limit;
+    private int offset;
+}
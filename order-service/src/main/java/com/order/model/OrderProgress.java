@@ -0,0 +1,44 @@
+package com.order.model;
+
+import com.fasterxml.jackson.annotation.JsonInclude;
+import lombok.AllArgsConstructor;
+import lombok.Builder;
+import lombok.Data;
+import lombok.NoArgsConstructor;
+
+import java.time.LocalDateTime;
+import java.util.List;
+
+/**
+ * What the public order status page shows: the steps of the order and when each was reached.
+ * It leaves out the user ID, metadata and prices, since anyone holding the link can read it.
+ */
+@Data
+@NoArgsConstructor
+@AllArgsConstructor
+@Builder
+@JsonInclude(JsonInclude.Include.NON_NULL)
+public class OrderProgress {
+
+    public static final String STEP_CREATED = "created";
+    public static final String STEP_STOCK_CONFIRMED = "stock_confirmed";
+
+    private String albumId;
+    private Integer quantity;
+    private String status;
+    private List<Step> steps;
+
+    // Set when the order failed, e.g. OUT_OF_STOCK
+    private String failureReason;
+    private LocalDateTime failedAt;
+
+    @Data
+    @NoArgsConstructor
+    @AllArgsConstructor
+    public static class Step {
+        private String name;
+        private boolean reached;
+        @JsonInclude(JsonInclude.Include.NON_NULL)
+        private LocalDateTime at;
+    }
+}
@@ -0,0 +1,54 @@
+package com.order.model;
+
+import com.fasterxml.jackson.annotation.JsonIgnore;
+import lombok.AllArgsConstructor;
+import lombok.Builder;
+import lombok.Data;
+import lombok.NoArgsConstructor;
+
+import javax.persistence.*;
+import java.time.LocalDateTime;
+
+/**
+ * One status an order went through, appended when the order is created and for every order outcome
+ * event. Rows are never updated, so the history shows when each step was reached. It is the timeline
+ * of the order detail response. A redelivered event has the same sourceEventId and isn't appended again.
+ */
+@Entity
+@Table(name = "order_status_history", indexes = @Index(columnList = "orderId, changedAt"),
+        uniqueConstraints = @UniqueConstraint(columnNames = {"orderId", "sourceEventId"}))
+@Data
+@NoArgsConstructor
+@AllArgsConstructor
+@Builder
+public class OrderStatusChange {
+
+    @Id
+    @GeneratedValue(strategy = GenerationType.IDENTITY)
+    @JsonIgnore
+    private Long id;
+
+    @Column(nullable = false)
+    @JsonIgnore
+    private Long orderId;
+
+    @Column(nullable = false)
+    private String status;
+
+    // Failure reason from order-failed, e.g. OUT_OF_STOCK
+    private String reason;
+
+    // Event the change came from: the eventId of v2 events, topic-partition@offset for v1 events.
+    // Histories from before it was kept have none.
+    private String sourceEventId;
+
+    @Column(nullable = false)
+    private LocalDateTime changedAt;
+
+    @PrePersist
+    protected void onCreate() {
+        if (changedAt == null) {
+            changedAt = LocalDateTime.now();
+        }
+    }
+}
@@ -0,0 +1,40 @@
+package com.order.model;
+
+import lombok.AllArgsConstructor;
+import lombok.Builder;
+import lombok.Data;
+import lombok.NoArgsConstructor;
+
+import javax.persistence.Column;
+import javax.persistence.Embeddable;
+import java.math.BigDecimal;
+
+/**
+ * Prices of an order as they were when it was created. Later album price changes don't affect it.
+ * unitPrice is the album's list price and discountAmount what its best promotion saves on the order
+ * (see OrderPricing). There are no tax rules yet, so taxAmount is zero; it is part of the snapshot so
+ * consumers don't need to change when rules are added.
+ */
+@Embeddable
+@Data
+@NoArgsConstructor
+@AllArgsConstructor
+@Builder
+public class PriceSnapshot {
+
+    @Column(precision = 10, scale = 2)
+    private BigDecimal unitPrice;
+
+    @Column(precision = 10, scale = 2)
+    private BigDecimal discountAmount;
+
+    @Column(precision = 10, scale = 2)
+    private BigDecimal taxAmount;
+
+    // unitPrice * quantity - discountAmount + taxAmount
+    @Column(precision = 12, scale = 2)
+    private BigDecimal totalPrice;
+
+    @Column(length = 3)
+    private String currency;
+}
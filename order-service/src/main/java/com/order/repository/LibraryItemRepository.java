@@ -0,0 +1,15 @@
+package com.order.repository;
+
+import com.order.model.LibraryItem;
+import org.springframework.data.jpa.repository.JpaRepository;
+import org.springframework.stereotype.Repository;
+
+import java.util.List;
+
+@Repository
+public interface LibraryItemRepository extends JpaRepository<LibraryItem, Long> {
+
+    List<LibraryItem> findByUserIdOrderByGrantedAtDescIdDesc(String userId);
+
+    boolean existsByOrderId(Long orderId);
+}
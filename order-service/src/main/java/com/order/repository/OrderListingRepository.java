@@ -0,0 +1,16 @@
+package com.order.repository;
+
+import com.order.model.Order;
+import com.order.model.OrderListQuery;
+
+import java.util.List;
+
+/**
+ * Paged, filtered and sorted order queries for GET /api/orders
+ */
+public interface OrderListingRepository {
+
+    List<Order> findPage(OrderListQuery query);
+
+    long countMatching(OrderListQuery query);
+}
@@ -0,0 +1,65 @@
+package com.order.repository;
+
+import com.order.model.Order;
+import com.order.model.OrderListQuery;
+
+import javax.persistence.EntityManager;
+import javax.persistence.PersistenceContext;
+import javax.persistence.criteria.CriteriaBuilder;
+import javax.persistence.criteria.CriteriaQuery;
+import javax.persistence.criteria.Path;
+import javax.persistence.criteria.Predicate;
+import javax.persistence.criteria.Root;
+import java.util.ArrayList;
+import java.util.List;
+
+public class OrderListingRepositoryImpl implements OrderListingRepository {
+
+    @PersistenceContext
+    private EntityManager entityManager;
+
+    @Override
+    public List<Order> findPage(OrderListQuery query) {
+        CriteriaBuilder cb = entityManager.getCriteriaBuilder();
+        CriteriaQuery<Order> cq = cb.createQuery(Order.class);
+        Root<Order> root = cq.from(Order.class);
+        cq.where(conditions(cb, root, query));
+
+        // The ID comes last, so orders with equal keys keep a stable order and pages don't overlap
+        List<javax.persistence.criteria.Order> orderBy = new ArrayList<>();
+        for (OrderListQuery.SortKey key : query.getSort()) {
+            Path<?> path = path(root, key.getAttribute());
+            orderBy.add(key.isDesc() ? cb.desc(path) : cb.asc(path));
+        }
+        orderBy.add(cb.asc(root.get("id")));
+        cq.orderBy(orderBy);
+
+        return entityManager.createQuery(cq)
+                .setFirstResult(query.getOffset())
+                .setMaxResults(query.getLimit())
+                .getResultList();
+    }
+
+    @Override
+    public long countMatching(OrderListQuery query) {
+        CriteriaBuilder cb = entityManager.getCriteriaBuilder();
+        CriteriaQuery<Long> cq = cb.createQuery(Long.class);
+        Root<Order> root = cq.from(Order.class);
+        cq.select(cb.count(root)).where(conditions(cb, root, query));
+        return entityManager.createQuery(cq).getSingleResult();
+    }
+
+    private static Predicate conditions(CriteriaBuilder cb, Root<Order> root, OrderListQuery query) {
+        List<Predicate> conditions = new ArrayList<>();
+        query.getFilters().forEach((attribute, values) -> conditions.add(path(root, attribute).in(values)));
+        return cb.and(conditions.toArray(new Predicate[0]));
+    }
+
+    private static Path<?> path(Root<Order> root, String attribute) {
+        Path<?> path = root;
+        for (String part : attribute.split("\\.")) {
+            path = path.get(part);
+        }
+        return path;
+    }
+}
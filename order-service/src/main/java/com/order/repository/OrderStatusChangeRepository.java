@@ -0,0 +1,15 @@
+package com.order.repository;
+
+import com.order.model.OrderStatusChange;
+import org.springframework.data.jpa.repository.JpaRepository;
+import org.springframework.stereotype.Repository;
+
+import java.util.List;
+
+@Repository
+public interface OrderStatusChangeRepository extends JpaRepository<OrderStatusChange, Long> {
+
+    List<OrderStatusChange> findByOrderIdOrderByChangedAtAscIdAsc(Long orderId);
+
+    boolean existsByOrderIdAndSourceEventId(Long orderId, String sourceEventId);
+}
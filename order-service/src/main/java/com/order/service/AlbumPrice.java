@@ -0,0 +1,17 @@
+package com.order.service;
+
+import lombok.Value;
+
+import java.math.BigDecimal;
+
+/**
+ * An album's price as album-service returned it. {@code albumId} is the album's database ID, whatever
+ * ID the order was placed with (see AlbumPriceClient). {@code price} is the list price and
+ * {@code effectivePrice} the price after the best active promotion, the same when none applies.
+ */
+@Value
+public class AlbumPrice {
+    String albumId;
+    BigDecimal price;
+    BigDecimal effectivePrice;
+}
@@ -0,0 +1,80 @@
+package com.order.service;
+
+import com.fasterxml.jackson.databind.JsonNode;
+import lombok.extern.slf4j.Slf4j;
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.boot.web.client.RestTemplateBuilder;
+import org.springframework.http.HttpEntity;
+import org.springframework.http.HttpHeaders;
+import org.springframework.http.HttpMethod;
+import org.springframework.stereotype.Component;
+import org.springframework.web.client.HttpClientErrorException;
+import org.springframework.web.client.RestClientException;
+import org.springframework.web.client.RestTemplate;
+
+import java.time.Duration;
+
+/**
+ * Reads the current price of an album from album-service, with the discount of its best active
+ * promotion (GET /api/v1/albums/{id}/effective-price). With an API key configured (one with the
+ * albums:internal scope), it calls as an internal client, so albums can be looked up by the public
+ * ID customers see (with PUBLIC_ID_ENCODING) or by database ID, and album-service answers with the
+ * database ID. Without a key it calls as a public client, which only works while album-service
+ * exposes database IDs. It asks for camelCase field names, as album-service may be deployed with
+ * JSON_FIELD_NAMING=snake_case.
+ */
+@Component
+@Slf4j
+public class AlbumPriceClient {
+
+    static final String CLIENT_TYPE = "internal";
+    static final String API_KEY_HEADER = "X-API-Key";
+    static final String FIELD_NAMING = "camelCase";
+
+    private final RestTemplate restTemplate;
+    private final String apiKey;
+
+    public AlbumPriceClient(
+            RestTemplateBuilder builder,
+            @Value("${album-service.url:http://localhost:8080}") String albumServiceUrl,
+            @Value("${album-service.api-key:}") String apiKey) {
+        this.restTemplate = builder
+                .rootUri(albumServiceUrl)
+                .setConnectTimeout(Duration.ofSeconds(2))
+                .setReadTimeout(Duration.ofSeconds(5))
+                .build();
+        this.apiKey = apiKey;
+    }
+
+    /**
+     * @param discountCode the order's discount code, or null; promotions without a code apply anyway
+     * @throws IllegalArgumentException if the album doesn't exist or the discount code isn't valid for it
+     * @throws IllegalStateException if album-service can't be reached or returns no price
+     */
+    public AlbumPrice currentPrice(String albumId, String discountCode) {
+        HttpHeaders headers = new HttpHeaders();
+        if (!apiKey.isBlank()) {
+            headers.set("Client-Type", CLIENT_TYPE);
+            headers.set(API_KEY_HEADER, apiKey);
+        }
+        JsonNode album;
+        try {
+            album = restTemplate.exchange("/api/v1/albums/{id}/effective-price?code={code}&naming={naming}", HttpMethod.GET,
+                    new HttpEntity<>(headers), JsonNode.class, albumId, discountCode == null ? "" : discountCode,
+                    FIELD_NAMING).getBody();
+        } catch (HttpClientErrorException.NotFound e) {
+            throw new IllegalArgumentException("Album not found: " + albumId);
+        } catch (HttpClientErrorException.BadRequest e) {
+            throw new IllegalArgumentException("Discount code " + discountCode + " is not valid for album " + albumId);
+        } catch (RestClientException e) {
+            log.warn("Could not read the price of album {}: {}", albumId, e.getMessage());
+            throw new IllegalStateException("Album prices are currently unavailable", e);
+        }
+        if (album == null || !album.path("price").isNumber() || !album.path("effectivePrice").isNumber()
+                || !album.path("albumId").isTextual()) {
+            throw new IllegalStateException("album-service returned no price for album " + albumId);
+        }
+        return new AlbumPrice(album.get("albumId").asText(), album.get("price").decimalValue(),
+                album.get("effectivePrice").decimalValue());
+    }
+}
@@ -0,0 +1,69 @@
+package com.order.service;
+
+import com.order.kafka.OrderProducer;
+import com.order.model.LibraryItem;
+import com.order.model.Order;
+import com.order.repository.LibraryItemRepository;
+import lombok.RequiredArgsConstructor;
+import lombok.extern.slf4j.Slf4j;
+import org.springframework.stereotype.Component;
+
+import java.util.List;
+
+/**
+ * Orders bought for another user. The purchaser places the order and keeps it, with its price, in
+ * their own orders; once the stock is confirmed the album is added to the recipient's library and an
+ * order-gifted event tells notification and fulfillment about it.
+ */
+@Component
+@RequiredArgsConstructor
+@Slf4j
+public class OrderGifting {
+
+    static final int MAX_RECIPIENT_ID_LENGTH = 64;
+
+    private final LibraryItemRepository libraryRepository;
+    private final OrderProducer orderProducer;
+
+    /**
+     * @throws IllegalArgumentException if the order names an invalid recipient
+     */
+    public void validate(Order order) {
+        String recipient = order.getRecipientUserId();
+        if (recipient == null) {
+            return;
+        }
+        if (recipient.isBlank()) {
+            throw new IllegalArgumentException("recipientUserId must not be empty");
+        }
+        if (recipient.length() > MAX_RECIPIENT_ID_LENGTH) {
+            throw new IllegalArgumentException("recipientUserId exceeds " + MAX_RECIPIENT_ID_LENGTH + " characters");
+        }
+        if (recipient.equals(order.getUserId())) {
+            throw new IllegalArgumentException("An order can't be a gift to its purchaser");
+        }
+    }
+
+    /**
+     * Grants a succeeded gift order's album to the recipient and publishes order-gifted. Orders that
+     * aren't gifts, and gifts already granted, are left alone.
+     */
+    public void deliver(Order order) {
+        if (order.getRecipientUserId() == null || libraryRepository.existsByOrderId(order.getId())) {
+            return;
+        }
+        libraryRepository.save(LibraryItem.builder()
+                .userId(order.getRecipientUserId())
+                .albumId(order.getAlbumId())
+                .quantity(order.getQuantity())
+                .orderId(order.getId())
+                .giftedBy(order.getUserId())
+                .build());
+        orderProducer.sendOrderGiftedEvent(order);
+        log.info("Granted album {} of order {} to {}", order.getAlbumId(), order.getId(), order.getRecipientUserId());
+    }
+
+    public List<LibraryItem> library(String userId) {
+        return libraryRepository.findByUserIdOrderByGrantedAtDescIdDesc(userId);
+    }
+}
@@ -0,0 +1,48 @@
+package com.order.service;
+
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.stereotype.Component;
+
+import java.util.List;
+import java.util.Map;
+
+/**
+ * Validates the free-form metadata map on incoming orders against a configurable key whitelist,
+ * so partners can attach extras (gift note, wrapping option) without arbitrary data being stored.
+ */
+@Component
+public class OrderMetadataValidator {
+
+    private final List<String> allowedKeys;
+    private final int maxValueLength;
+
+    public OrderMetadataValidator(
+            @Value("${order.metadata.allowed-keys:giftNote,giftWrap,orderNote}") List<String> allowedKeys,
+            @Value("${order.metadata.max-value-length:500}") int maxValueLength) {
+        this.allowedKeys = allowedKeys;
+        this.maxValueLength = maxValueLength;
+    }
+
+    /**
+     * @throws IllegalArgumentException if a key is not whitelisted or a value is missing or too long
+     */
+    public void validate(Map<String, String> metadata) {
+        if (metadata == null) {
+            return;
+        }
+        for (Map.Entry<String, String> entry : metadata.entrySet()) {
+            if (!allowedKeys.contains(entry.getKey())) {
+                throw new IllegalArgumentException("Unsupported metadata key: " + entry.getKey()
+                        + " (allowed: " + String.join(", ", allowedKeys) + ")");
+            }
+            String value = entry.getValue();
+            if (value == null || value.isBlank()) {
+                throw new IllegalArgumentException("Metadata value for '" + entry.getKey() + "' must not be empty");
+            }
+            if (value.length() > maxValueLength) {
+                throw new IllegalArgumentException("Metadata value for '" + entry.getKey() + "' exceeds "
+                        + maxValueLength + " characters");
+            }
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package com.order.service;
+
+import com.order.model.Order;
+import com.order.model.PriceSnapshot;
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.stereotype.Component;
+
+import java.math.BigDecimal;
+import java.math.RoundingMode;
+
+/**
+ * Prices new orders from the album price at creation time, less the discount of the album's best
+ * active promotion (or of the order's discount code), as album-service's effective price gives it.
+ * The snapshot is stored with the order and sent in its order-created event, so later price changes
+ * never alter what the customer was charged.
+ */
+@Component
+public class OrderPricing {
+
+    private final AlbumPriceClient albumPriceClient;
+    private final String currency;
+
+    public OrderPricing(
+            AlbumPriceClient albumPriceClient,
+            @Value("${order.pricing.currency:USD}") String currency) {
+        this.albumPriceClient = albumPriceClient;
+        this.currency = currency;
+    }
+
+    /**
+     * Sets the price snapshot of a new order. The order's album ID is replaced with the database ID
+     * album-service resolved it to, so the order and its events name the album the way
+     * inventory-service does even when the customer used a public ID.
+     */
+    public void price(Order order) {
+        AlbumPrice album = albumPriceClient.currentPrice(order.getAlbumId(), order.getDiscountCode());
+        order.setAlbumId(album.getAlbumId());
+        order.setPrice(snapshot(album, order.getQuantity()));
+    }
+
+    private PriceSnapshot snapshot(AlbumPrice album, int quantity) {
+        BigDecimal unitPrice = album.getPrice().setScale(2, RoundingMode.HALF_UP);
+        BigDecimal unitDiscount = unitPrice.subtract(album.getEffectivePrice().setScale(2, RoundingMode.HALF_UP))
+                .max(BigDecimal.ZERO);
+        BigDecimal discount = unitDiscount.multiply(BigDecimal.valueOf(quantity));
+        BigDecimal tax = BigDecimal.ZERO.setScale(2);
+        return PriceSnapshot.builder()
+                .unitPrice(unitPrice)
+                .discountAmount(discount)
+                .taxAmount(tax)
+                .totalPrice(unitPrice.multiply(BigDecimal.valueOf(quantity)).subtract(discount).add(tax))
+                .currency(currency)
+                .build();
+    }
+}
@@ -1,17 +1,36 @@
 package com.order.service;
 
 import com.order.model.Order;
+import com.order.model.OrderListQuery;
+import com.order.model.OrderPage;
+import com.order.model.OrderProgress;
+
 
 import java.util.List;
 
 public interface OrderService {
     
-    List<Order> getAllOrders();
+    /**
+     * Returns a page of the orders matching the query, with their total count.
+     */
+    OrderPage getOrders(OrderListQuery query);
     
     Order getOrderById(Long id);
     
     List<Order> getOrdersByUserId(String userId);
     
+    /**
+     * Persists the order and publishes an order-created event.
+     *
+     * @throws IllegalArgumentException if the order metadata fails validation
+     */
     Order createOrder(Order order);
+
+    /**
+     * Returns the progress of the order a status token was issued for.
+     *
+     * @throws javax.persistence.EntityNotFoundException if the token is invalid or its order no longer exists
+     */
+    OrderProgress getOrderProgress(String statusToken);
     
 } 
\ No newline at end of file
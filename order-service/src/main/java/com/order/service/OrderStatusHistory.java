@@ -0,0 +1,76 @@
+package com.order.service;
+
+import com.order.model.Order;
+import com.order.model.OrderProgress;
+import com.order.model.OrderStatusChange;
+import com.order.repository.OrderStatusChangeRepository;
+import lombok.RequiredArgsConstructor;
+import org.springframework.stereotype.Component;
+
+import java.util.List;
+
+/**
+ * Appends order status changes to order_status_history, returns them as the order's timeline and
+ * replays them into the steps of the public status page. An order is created, then its stock is confirmed (order-succeeded) or it
+ * fails (order-failed). Payment and shipping aren't tracked by any service yet, so they have no step.
+ */
+@Component
+@RequiredArgsConstructor
+public class OrderStatusHistory {
+
+    public static final String STATUS_PENDING = "PENDING";
+    public static final String STATUS_SUCCEEDED = "SUCCEEDED";
+    public static final String STATUS_FAILED = "FAILED";
+
+    private final OrderStatusChangeRepository repository;
+
+    public void record(Long orderId, String status, String reason, String sourceEventId) {
+        repository.save(OrderStatusChange.builder()
+                .orderId(orderId).status(status).reason(reason).sourceEventId(sourceEventId).build());
+    }
+
+    /** Whether the event was already recorded for the order, i.e. this is a redelivery. */
+    public boolean isRecorded(Long orderId, String sourceEventId) {
+        return repository.existsByOrderIdAndSourceEventId(orderId, sourceEventId);
+    }
+
+    public List<OrderStatusChange> timeline(Long orderId) {
+        return repository.findByOrderIdOrderByChangedAtAscIdAsc(orderId);
+    }
+
+    public OrderProgress progress(Order order) {
+        List<OrderStatusChange> changes = timeline(order.getId());
+
+        OrderProgress.Step created = new OrderProgress.Step(OrderProgress.STEP_CREATED, true, order.getCreatedAt());
+        OrderProgress.Step stockConfirmed = new OrderProgress.Step(OrderProgress.STEP_STOCK_CONFIRMED, false, null);
+        OrderProgress progress = OrderProgress.builder()
+                .albumId(order.getAlbumId())
+                .quantity(order.getQuantity())
+                .status(order.getStatus())
+                .steps(List.of(created, stockConfirmed))
+                .build();
+        for (OrderStatusChange change : changes) {
+            switch (change.getStatus()) {
+                case STATUS_PENDING:
+                    created.setAt(change.getChangedAt());
+                    break;
+                case STATUS_SUCCEEDED:
+                    if (!stockConfirmed.isReached()) {
+                        stockConfirmed.setReached(true);
+                        stockConfirmed.setAt(change.getChangedAt());
+                    }
+                    break;
+                case STATUS_FAILED:
+                    progress.setFailureReason(change.getReason());
+                    progress.setFailedAt(change.getChangedAt());
+                    break;
+                default:
+                    break;
+            }
+        }
+        if (STATUS_SUCCEEDED.equals(order.getStatus())) {
+            stockConfirmed.setReached(true); // Orders confirmed before the history was kept have no time
+        }
+        return progress;
+    }
+}
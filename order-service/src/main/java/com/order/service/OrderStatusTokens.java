@@ -0,0 +1,81 @@
+package com.order.service;
+
+import lombok.extern.slf4j.Slf4j;
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.stereotype.Component;
+
+import javax.crypto.Mac;
+import javax.crypto.spec.SecretKeySpec;
+import java.nio.charset.StandardCharsets;
+import java.security.GeneralSecurityException;
+import java.security.MessageDigest;
+import java.security.SecureRandom;
+import java.util.Arrays;
+import java.util.Base64;
+import java.util.Optional;
+
+/**
+ * Issues and checks the tokens of the public order status page. A token is the order ID and an
+ * HMAC-SHA256 signature of it ("42.3q2-7w..."), so it needs no storage, can't be guessed from the ID
+ * and stays valid for the life of the order.
+ */
+@Component
+@Slf4j
+public class OrderStatusTokens {
+
+    private static final String ALGORITHM = "HmacSHA256";
+    private static final int SIGNATURE_BYTES = 16; // 128 bits is plenty against guessing
+
+    private final SecretKeySpec key;
+
+    public OrderStatusTokens(@Value("${order.status-token.secret:}") String secret) {
+        byte[] keyBytes;
+        if (secret == null || secret.isBlank()) {
+            // Tokens from a random key stop working when the service restarts
+            log.warn("ORDER_STATUS_TOKEN_SECRET is not set; order status links are only valid until the next restart");
+            keyBytes = new byte[32];
+            new SecureRandom().nextBytes(keyBytes);
+        } else {
+            keyBytes = secret.getBytes(StandardCharsets.UTF_8);
+        }
+        this.key = new SecretKeySpec(keyBytes, ALGORITHM);
+    }
+
+    public String issue(Long orderId) {
+        return orderId + "." + Base64.getUrlEncoder().withoutPadding().encodeToString(sign(orderId));
+    }
+
+    /**
+     * @return the order ID of a valid token, or empty for malformed tokens and wrong signatures
+     */
+    public Optional<Long> verify(String token) {
+        if (token == null) {
+            return Optional.empty();
+        }
+        int dot = token.indexOf('.');
+        if (dot < 1) {
+            return Optional.empty();
+        }
+        try {
+            Long orderId = Long.valueOf(token.substring(0, dot));
+            byte[] signature = Base64.getUrlDecoder().decode(token.substring(dot + 1));
+            if (!MessageDigest.isEqual(signature, sign(orderId))) {
+                return Optional.empty();
+            }
+            return Optional.of(orderId);
+        } catch (IllegalArgumentException e) { // Not a number, or not base64url
+            return Optional.empty();
+        }
+    }
+
+    private byte[] sign(Long orderId) {
+        try {
+            Mac mac = Mac.getInstance(ALGORITHM);
+            mac.init(key);
+            byte[] full = mac.doFinal(("order-status:" + orderId).getBytes(StandardCharsets.UTF_8));
+            return Arrays.copyOf(full, SIGNATURE_BYTES);
+        } catch (GeneralSecurityException e) {
+            throw new IllegalStateException("HmacSHA256 is not available", e);
+        }
+    }
+}
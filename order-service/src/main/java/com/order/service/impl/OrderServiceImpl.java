@@ -2,8 +2,16 @@ package com.order.service.impl;
 
 import com.order.kafka.OrderProducer;
 import com.order.model.Order;
+import com.order.model.OrderListQuery;
+import com.order.model.OrderPage;
+import com.order.model.OrderProgress;
 import com.order.repository.OrderRepository;
+import com.order.service.OrderGifting;
+import com.order.service.OrderMetadataValidator;
+import com.order.service.OrderPricing;
 import com.order.service.OrderService;
+import com.order.service.OrderStatusHistory;
+import com.order.service.OrderStatusTokens;
 import lombok.RequiredArgsConstructor;
 import lombok.extern.slf4j.Slf4j;
 import org.springframework.stereotype.Service;
@@ -19,16 +27,31 @@ public class OrderServiceImpl implements OrderService {
 
     private final OrderRepository orderRepository;
     private final OrderProducer orderProducer;
+    private final OrderMetadataValidator metadataValidator;
+    private final OrderPricing orderPricing;
+    private final OrderStatusHistory statusHistory;
+    private final OrderStatusTokens statusTokens;
+    private final OrderGifting orderGifting;
 
     @Override
-    public List<Order> getAllOrders() {
-        return orderRepository.findAll();
+    public OrderPage getOrders(OrderListQuery query) {
+        List<Order> orders = orderRepository.findPage(query);
+        // A page shorter than the limit is the last one, so the total follows from it; only full
+        // pages and pages past the end are counted
+        long total = query.getOffset() + orders.size();
+        if (orders.size() == query.getLimit() || (orders.isEmpty() && query.getOffset() > 0)) {
+            total = orderRepository.countMatching(query);
+        }
+        return new OrderPage(orders, total, query.getLimit(), query.getOffset());
     }
 
     @Override
     public Order getOrderById(Long id) {
-        return orderRepository.findById(id)
+        Order order = orderRepository.findById(id)
                 .orElseThrow(() -> new EntityNotFoundException("Order not found with id: " + id));
+        order.setStatusToken(statusTokens.issue(order.getId()));
+        order.setTimeline(statusHistory.timeline(order.getId()));
+        return order;
     }
 
     @Override
@@ -40,12 +63,30 @@ public class OrderServiceImpl implements OrderService {
     public Order createOrder(Order order) {
         log.info("Creating order for user: {}, album: {}, quantity: {}", 
                 order.getUserId(), order.getAlbumId(), order.getQuantity());
+
+        metadataValidator.validate(order.getMetadata());
+        orderGifting.validate(order);
+        if (order.getQuantity() == null || order.getQuantity() < 1) {
+            throw new IllegalArgumentException("Quantity must be at least 1");
+        }
+        orderPricing.price(order);
         
         Order savedOrder = orderRepository.save(order);
-        
+        statusHistory.record(savedOrder.getId(), savedOrder.getStatus(), null,
+                OrderProducer.orderCreatedEventId(savedOrder.getId()));
+        savedOrder.setStatusToken(statusTokens.issue(savedOrder.getId()));
+
         // Publish order created event to Kafka
         orderProducer.sendOrderCreatedEvent(savedOrder);
         
         return savedOrder;
     }
+
+    @Override
+    public OrderProgress getOrderProgress(String statusToken) {
+        Order order = statusTokens.verify(statusToken)
+                .flatMap(orderRepository::findById)
+                .orElseThrow(() -> new EntityNotFoundException("Unknown order status token"));
+        return statusHistory.progress(order);
+    }
 } 
\ No newline at end of file
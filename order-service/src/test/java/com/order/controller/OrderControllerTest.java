@@ -2,6 +2,9 @@ package com.order.controller;
 
 import com.fasterxml.jackson.databind.ObjectMapper;
 import com.order.model.Order;
+import com.order.model.OrderListQuery;
+import com.order.model.OrderPage;
+import com.order.model.OrderProgress;
 // import com.order.model.OrderStatus; // Remove assumed import
 import com.order.service.OrderService;
 import org.junit.jupiter.api.BeforeEach;
@@ -66,7 +69,7 @@ class OrderControllerTest {
     void getAllOrders_shouldReturnListOfOrders_whenAdmin() throws Exception {
         // Arrange
         List<Order> orders = Collections.singletonList(sampleOrder1);
-        when(orderService.getAllOrders()).thenReturn(orders);
+        when(orderService.getOrders(any())).thenReturn(new OrderPage(orders, 1, OrderListQuery.DEFAULT_LIMIT, 0));
 
         // Act & Assert
         mockMvc.perform(get("/api/orders")
@@ -78,7 +81,37 @@ class OrderControllerTest {
                 .andExpect(jsonPath("$[0].id").value(sampleOrder1.getId()))
                 .andExpect(jsonPath("$[0].userId").value(sampleOrder1.getUserId()));
 
-        verify(orderService).getAllOrders(); // Verify service method was called
+        verify(orderService).getOrders(any()); // Verify service method was called
+    }
+
+    @Test
+    void getAllOrders_shouldPageLikeTheGoServices() throws Exception {
+        when(orderService.getOrders(any())).thenReturn(new OrderPage(List.of(sampleOrder1), 5, 2, 2));
+
+        mockMvc.perform(get("/api/orders")
+                        .param("status", "FAILED")
+                        .param("limit", "2")
+                        .param("offset", "2")
+                        .header("Client-Type", "admin")
+                        .accept(MediaType.APPLICATION_JSON))
+                .andExpect(status().isOk())
+                .andExpect(header().string("X-Total-Count", "5"))
+                .andExpect(header().string("Link",
+                        "</api/orders?limit=2&offset=4&status=FAILED>; rel=\"next\", "
+                                + "</api/orders?limit=2&offset=0&status=FAILED>; rel=\"prev\""))
+                .andExpect(jsonPath("$.size()").value(1));
+
+        verify(orderService).getOrders(argThat(query -> query.getLimit() == 2 && query.getOffset() == 2
+                && query.getFilters().get("status").equals(List.of("FAILED"))));
+    }
+
+    @Test
+    void getAllOrders_shouldRejectInvalidPages() throws Exception {
+        mockMvc.perform(get("/api/orders")
+                        .param("limit", "500")
+                        .header("Client-Type", "admin"))
+                .andExpect(status().isBadRequest())
+                .andExpect(jsonPath("$.error").value("limit must be between 1 and 200"));
     }
 
     @Test
@@ -216,6 +249,46 @@ class OrderControllerTest {
                 .andExpect(status().isBadRequest());
     }
 
+    @Test
+    void createOrder_withUnsupportedMetadata_shouldReturnBadRequest() throws Exception {
+        // Arrange
+        sampleOrderInput.setMetadata(java.util.Map.of("unknownKey", "value"));
+        when(orderService.createOrder(any(Order.class)))
+                .thenThrow(new IllegalArgumentException("Unsupported metadata key: unknownKey"));
+
+        // Act & Assert
+        mockMvc.perform(post("/api/orders")
+                        .header("Client-Type", "user")
+                        .contentType(MediaType.APPLICATION_JSON)
+                        .content(objectMapper.writeValueAsString(sampleOrderInput))
+                        .accept(MediaType.APPLICATION_JSON))
+                .andExpect(status().isBadRequest())
+                .andExpect(jsonPath("$.error").value("Unsupported metadata key: unknownKey"));
+    }
+
+    @Test
+    void getOrderStatus_withoutClientType_shouldReturnProgress() throws Exception {
+        // Arrange
+        OrderProgress progress = OrderProgress.builder()
+                .albumId("album456")
+                .quantity(2)
+                .status("PENDING")
+                .steps(List.of(new OrderProgress.Step(OrderProgress.STEP_CREATED, true, LocalDateTime.now()),
+                        new OrderProgress.Step(OrderProgress.STEP_STOCK_CONFIRMED, false, null)))
+                .build();
+        when(orderService.getOrderProgress("1.sig")).thenReturn(progress);
+        when(orderService.getOrderProgress("1.forged")).thenThrow(new javax.persistence.EntityNotFoundException());
+
+        // Act & Assert
+        mockMvc.perform(get("/api/orders/status/{token}", "1.sig").accept(MediaType.APPLICATION_JSON))
+                .andExpect(status().isOk())
+                .andExpect(jsonPath("$.steps[1].name").value("stock_confirmed"))
+                .andExpect(jsonPath("$.steps[1].reached").value(false))
+                .andExpect(jsonPath("$.userId").doesNotExist());
+        mockMvc.perform(get("/api/orders/status/{token}", "1.forged").accept(MediaType.APPLICATION_JSON))
+                .andExpect(status().isNotFound());
+    }
+
     // TODO: Add tests for other endpoints (PUT for status update, DELETE if applicable)
     // TODO: Add tests for different error scenarios (e.g., service layer exceptions)
 
@@ -0,0 +1,125 @@
+package com.order.kafka;
+
+import com.fasterxml.jackson.databind.JsonNode;
+import com.fasterxml.jackson.databind.ObjectMapper;
+import org.junit.jupiter.api.Test;
+
+import java.util.List;
+import java.util.Map;
+import java.util.Optional;
+import java.util.UUID;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertFalse;
+import static org.junit.jupiter.api.Assertions.assertNotEquals;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+import static org.junit.jupiter.api.Assertions.assertTrue;
+
+class OrderEventSchemaTest {
+
+    private final ObjectMapper objectMapper = new ObjectMapper();
+    private final OrderEventSchema schema = new OrderEventSchema(List.of(1, 2), 2, "staging.", "", JsonFieldNaming.CAMEL_CASE);
+
+    @Test
+    void topic_addsVersionAndEnvironmentScope() {
+        assertEquals("staging.order-created", schema.topic("order-created", OrderEventSchema.V1));
+        assertEquals("staging.order-created.v2", schema.topic("order-created", OrderEventSchema.V2));
+        assertEquals("staging.order-failed.v2", schema.consumeTopic("order-failed"));
+    }
+
+    @Test
+    void decode_acceptsBothVersions() throws Exception {
+        Map<String, Object> payload = Map.of("orderId", "42", "reason", "INSUFFICIENT_INVENTORY");
+        for (int version : List.of(OrderEventSchema.V1, OrderEventSchema.V2)) {
+            String message = objectMapper.writeValueAsString(schema.encode(version, "order.failed", "order.failed:42", payload));
+            JsonNode decoded = schema.decode(objectMapper, message);
+            assertEquals("42", decoded.get("orderId").asText());
+            assertEquals("INSUFFICIENT_INVENTORY", decoded.get("reason").asText());
+        }
+    }
+
+    @Test
+    void eventId_isReadFromTheEnvelope() throws Exception {
+        Map<String, Object> payload = Map.of("orderId", "42");
+        String v1 = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V1, "order.succeeded", "order.succeeded:42", payload));
+        String v2 = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V2, "order.succeeded", "order.succeeded:42", payload));
+
+        assertEquals(Optional.empty(), schema.eventId(objectMapper, v1));
+        assertEquals(Optional.of("order.succeeded:42"), schema.eventId(objectMapper, v2));
+    }
+
+    @Test
+    void eventId_isKeptWhenAnEventIsRepublished() throws Exception {
+        String eventId = OrderEventSchema.stableEventId("order.failed:42");
+        Map<String, Object> payload = Map.of("orderId", "42", "reason", "INSUFFICIENT_INVENTORY");
+        String first = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V2, "order.failed", eventId, payload));
+        String republished = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V2, "order.failed", eventId, payload));
+
+        // Consumers deduplicate by it, whatever offset the republished copy lands at
+        assertEquals(schema.eventId(objectMapper, first), schema.eventId(objectMapper, republished));
+        assertEquals(Optional.of(eventId), schema.eventId(objectMapper, republished));
+    }
+
+    @Test
+    void envelope_namesTheProducer() throws Exception {
+        String v2 = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V2, "order.created", "id", Map.of("orderId", "42")));
+        assertEquals("order-service", objectMapper.readTree(v2).get("producer").asText());
+    }
+
+    @Test
+    void stableEventId_isAUuidKeptAcrossPublishes() {
+        String id = OrderEventSchema.stableEventId("order.created:42");
+        assertEquals(id, UUID.fromString(id).toString());
+        assertEquals(id, OrderEventSchema.stableEventId("order.created:42"));
+        assertNotEquals(id, OrderEventSchema.stableEventId("order.created:43"));
+    }
+
+    @Test
+    void isEventType_matchesTheEnvelopeType() throws Exception {
+        Map<String, Object> payload = Map.of("orderId", "42");
+        String v1 = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V1, "order.failed", "id", payload));
+        String v2 = objectMapper.writeValueAsString(schema.encode(OrderEventSchema.V2, "order.failed", "id", payload));
+
+        assertTrue(schema.isEventType(objectMapper, v1, "order.failed"), "v1 events carry no type");
+        assertTrue(schema.isEventType(objectMapper, v2, "order.failed"));
+        assertFalse(schema.isEventType(objectMapper, v2, "order.succeeded"));
+        assertTrue(schema.isEventType(objectMapper, "{\"schema_version\":2,\"event_type\":\"order.failed\",\"data\":{}}", "order.failed"));
+    }
+
+    @Test
+    void snakeCase_isPublishedAndReadInEitherNaming() throws Exception {
+        OrderEventSchema snakeSchema = new OrderEventSchema(List.of(2), 2, "", "", JsonFieldNaming.SNAKE_CASE);
+        Map<String, Object> payload = Map.of("orderId", "42", "price", Map.of("unitPrice", 19.99));
+
+        String message = objectMapper.writeValueAsString(snakeSchema.encode(OrderEventSchema.V2, "order.created", "order.created:42", payload));
+        JsonNode published = objectMapper.readTree(message);
+        assertEquals("order.created:42", published.get("event_id").asText());
+        assertEquals("42", published.path("data").get("order_id").asText());
+        assertEquals(19.99, published.path("data").path("price").get("unit_price").asDouble());
+
+        // Consumers read either naming
+        for (OrderEventSchema consumer : List.of(schema, snakeSchema)) {
+            assertEquals(Optional.of("order.created:42"), consumer.eventId(objectMapper, message));
+            assertEquals("42", consumer.decode(objectMapper, message).get("orderId").asText());
+        }
+    }
+
+    @Test
+    void jsonFieldNaming_renamesIdentifiersOnly() {
+        assertEquals("release_year", JsonFieldNaming.snakeCase("releaseYear"));
+        assertEquals("album_id", JsonFieldNaming.snakeCase("albumID"));
+        assertEquals("album_ids", JsonFieldNaming.snakeCase("albumIDs"));
+        assertEquals("public_id_map", JsonFieldNaming.snakeCase("publicIDMap"));
+        assertEquals("Hip Hop", JsonFieldNaming.snakeCase("Hip Hop"));
+        assertEquals("releaseYear", JsonFieldNaming.camelCase("release_year"));
+        assertEquals("trailing_", JsonFieldNaming.camelCase("trailing_"));
+        assertThrows(IllegalArgumentException.class, () -> JsonFieldNaming.checkSupported("kebab-case"));
+    }
+
+    @Test
+    void rejectsUnsupportedVersions() {
+        assertThrows(IllegalArgumentException.class, () -> new OrderEventSchema(List.of(3), 1, "", "", JsonFieldNaming.CAMEL_CASE));
+        assertThrows(IllegalArgumentException.class,
+                () -> schema.decode(objectMapper, "{\"schemaVersion\":9,\"data\":{}}"));
+    }
+}
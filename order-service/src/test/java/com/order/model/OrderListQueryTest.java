@@ -0,0 +1,60 @@
+package com.order.model;
+
+import org.junit.jupiter.api.Test;
+import org.springframework.util.LinkedMultiValueMap;
+import org.springframework.util.MultiValueMap;
+
+import java.util.List;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+import static org.junit.jupiter.api.Assertions.assertTrue;
+
+class OrderListQueryTest {
+
+    private static MultiValueMap<String, String> params(String... pairs) {
+        MultiValueMap<String, String> params = new LinkedMultiValueMap<>();
+        for (int i = 0; i < pairs.length; i += 2) {
+            params.add(pairs[i], pairs[i + 1]);
+        }
+        return params;
+    }
+
+    @Test
+    void parse_defaults() {
+        OrderListQuery query = OrderListQuery.parse(params());
+
+        assertEquals(OrderListQuery.DEFAULT_LIMIT, query.getLimit());
+        assertEquals(0, query.getOffset());
+        assertEquals(1, query.getSort().size());
+        assertEquals("createdAt", query.getSort().get(0).getAttribute());
+        assertTrue(query.getSort().get(0).isDesc(), "newest orders first");
+        assertTrue(query.getFilters().isEmpty());
+    }
+
+    @Test
+    void parse_pageSortAndFilters() {
+        OrderListQuery query = OrderListQuery.parse(params(
+                "limit", "10", "offset", "20", "sort", "-totalPrice,createdAt",
+                "status", "FAILED,PENDING", "status", "SUCCEEDED", "userId", "user123"));
+
+        assertEquals(10, query.getLimit());
+        assertEquals(20, query.getOffset());
+        assertEquals("price.totalPrice", query.getSort().get(0).getAttribute());
+        assertTrue(query.getSort().get(0).isDesc());
+        assertEquals("createdAt", query.getSort().get(1).getAttribute());
+        assertEquals(List.of("FAILED", "PENDING", "SUCCEEDED"), query.getFilters().get("status"));
+        assertEquals(List.of("user123"), query.getFilters().get("userId"));
+    }
+
+    @Test
+    void parse_rejectsInvalidParameters() {
+        for (String[] invalid : new String[][]{{"limit", "0"}, {"limit", "201"}, {"limit", "ten"}, {"offset", "-1"},
+                {"sort", "createdAt,-createdAt"}}) {
+            assertThrows(IllegalArgumentException.class, () -> OrderListQuery.parse(params(invalid)), String.join("=", invalid));
+        }
+        IllegalArgumentException e = assertThrows(IllegalArgumentException.class,
+                () -> OrderListQuery.parse(params("sort", "userId")));
+        assertEquals("cannot sort by \"userId\", expected one of createdAt, totalPrice", e.getMessage());
+    }
+}
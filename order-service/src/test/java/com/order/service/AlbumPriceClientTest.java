@@ -0,0 +1,80 @@
+package com.order.service;
+
+import org.junit.jupiter.api.Test;
+import org.springframework.beans.factory.annotation.Autowired;
+import org.springframework.boot.test.autoconfigure.web.client.RestClientTest;
+import org.springframework.http.HttpStatus;
+import org.springframework.http.MediaType;
+import org.springframework.test.web.client.MockRestServiceServer;
+
+import java.math.BigDecimal;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+import static org.springframework.test.web.client.match.MockRestRequestMatchers.header;
+import static org.springframework.test.web.client.match.MockRestRequestMatchers.requestTo;
+import static org.springframework.test.web.client.response.MockRestResponseCreators.withStatus;
+import static org.springframework.test.web.client.response.MockRestResponseCreators.withSuccess;
+
+@RestClientTest(value = AlbumPriceClient.class, properties = "album-service.api-key=ask_0123456789abcdef")
+class AlbumPriceClientTest {
+
+    @Autowired
+    private AlbumPriceClient client;
+
+    @Autowired
+    private MockRestServiceServer server;
+
+    @Test
+    void currentPrice_resolvesPublicIdToDatabaseId() {
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/JgaEBg/effective-price?code=&naming=camelCase"))
+                .andExpect(header("Client-Type", "internal"))
+                .andExpect(header("X-API-Key", "ask_0123456789abcdef"))
+                .andRespond(withSuccess("{\"albumId\":\"42\",\"price\":19.99,\"effectivePrice\":19.99}", MediaType.APPLICATION_JSON));
+
+        AlbumPrice album = client.currentPrice("JgaEBg", null);
+
+        assertEquals("42", album.getAlbumId());
+        assertEquals(new BigDecimal("19.99"), album.getPrice());
+        server.verify();
+    }
+
+    @Test
+    void currentPrice_readsEffectivePriceWithDiscountCode() {
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/42/effective-price?code=SUMMER24&naming=camelCase"))
+                .andRespond(withSuccess("{\"albumId\":\"42\",\"price\":20,\"effectivePrice\":15,"
+                        + "\"promotion\":{\"id\":3,\"name\":\"Summer sale\",\"code\":\"SUMMER24\",\"percentOff\":25}}",
+                        MediaType.APPLICATION_JSON));
+
+        AlbumPrice album = client.currentPrice("42", "SUMMER24");
+
+        assertEquals(new BigDecimal("20"), album.getPrice());
+        assertEquals(new BigDecimal("15"), album.getEffectivePrice());
+    }
+
+    @Test
+    void currentPrice_failsOnSnakeCaseResponse() {
+        // album-service deployed with JSON_FIELD_NAMING=snake_case answers in camelCase only when asked
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/42/effective-price?code=&naming=camelCase"))
+                .andRespond(withSuccess("{\"album_id\":\"42\",\"price\":19.99,\"effective_price\":19.99}", MediaType.APPLICATION_JSON));
+
+        assertThrows(IllegalStateException.class, () -> client.currentPrice("42", null));
+        server.verify();
+    }
+
+    @Test
+    void currentPrice_rejectsInvalidDiscountCode() {
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/42/effective-price?code=EXPIRED&naming=camelCase"))
+                .andRespond(withStatus(HttpStatus.BAD_REQUEST));
+
+        assertThrows(IllegalArgumentException.class, () -> client.currentPrice("42", "EXPIRED"));
+    }
+
+    @Test
+    void currentPrice_rejectsUnknownAlbum() {
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/missing/effective-price?code=&naming=camelCase"))
+                .andRespond(withStatus(HttpStatus.NOT_FOUND));
+
+        assertThrows(IllegalArgumentException.class, () -> client.currentPrice("missing", null));
+    }
+}
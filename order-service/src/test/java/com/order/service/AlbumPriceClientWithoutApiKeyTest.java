@@ -0,0 +1,33 @@
+package com.order.service;
+
+import org.junit.jupiter.api.Test;
+import org.springframework.beans.factory.annotation.Autowired;
+import org.springframework.boot.test.autoconfigure.web.client.RestClientTest;
+import org.springframework.http.MediaType;
+import org.springframework.test.web.client.MockRestServiceServer;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.springframework.test.web.client.match.MockRestRequestMatchers.headerDoesNotExist;
+import static org.springframework.test.web.client.match.MockRestRequestMatchers.requestTo;
+import static org.springframework.test.web.client.response.MockRestResponseCreators.withSuccess;
+
+@RestClientTest(AlbumPriceClient.class)
+class AlbumPriceClientWithoutApiKeyTest {
+
+    @Autowired
+    private AlbumPriceClient client;
+
+    @Autowired
+    private MockRestServiceServer server;
+
+    @Test
+    void currentPrice_callsAsPublicClientWithoutApiKey() {
+        server.expect(requestTo("http://localhost:8080/api/v1/albums/42/effective-price?code=&naming=camelCase"))
+                .andExpect(headerDoesNotExist("Client-Type"))
+                .andExpect(headerDoesNotExist("X-API-Key"))
+                .andRespond(withSuccess("{\"albumId\":\"42\",\"price\":19.99,\"effectivePrice\":19.99}", MediaType.APPLICATION_JSON));
+
+        assertEquals("42", client.currentPrice("42", null).getAlbumId());
+        server.verify();
+    }
+}
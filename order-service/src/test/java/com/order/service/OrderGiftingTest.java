@@ -0,0 +1,67 @@
+package com.order.service;
+
+import com.order.kafka.OrderProducer;
+import com.order.model.LibraryItem;
+import com.order.model.Order;
+import com.order.repository.LibraryItemRepository;
+import org.junit.jupiter.api.Test;
+import org.mockito.ArgumentCaptor;
+
+import static org.junit.jupiter.api.Assertions.assertDoesNotThrow;
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+import static org.mockito.ArgumentMatchers.any;
+import static org.mockito.Mockito.mock;
+import static org.mockito.Mockito.never;
+import static org.mockito.Mockito.verify;
+import static org.mockito.Mockito.when;
+
+class OrderGiftingTest {
+
+    private final LibraryItemRepository libraryRepository = mock(LibraryItemRepository.class);
+    private final OrderProducer orderProducer = mock(OrderProducer.class);
+    private final OrderGifting gifting = new OrderGifting(libraryRepository, orderProducer);
+
+    private static Order giftOrder(String recipient) {
+        return Order.builder().id(7L).userId("user-1").albumId("42").quantity(1).recipientUserId(recipient).build();
+    }
+
+    @Test
+    void validate_acceptsGiftsToOtherUsers() {
+        assertDoesNotThrow(() -> gifting.validate(giftOrder("user-2")));
+        assertDoesNotThrow(() -> gifting.validate(giftOrder(null)));
+    }
+
+    @Test
+    void validate_rejectsInvalidRecipients() {
+        assertThrows(IllegalArgumentException.class, () -> gifting.validate(giftOrder(" ")));
+        assertThrows(IllegalArgumentException.class, () -> gifting.validate(giftOrder("user-1")));
+        assertThrows(IllegalArgumentException.class,
+                () -> gifting.validate(giftOrder("u".repeat(OrderGifting.MAX_RECIPIENT_ID_LENGTH + 1))));
+    }
+
+    @Test
+    void deliver_grantsTheAlbumToTheRecipient() {
+        Order order = giftOrder("user-2");
+
+        gifting.deliver(order);
+
+        ArgumentCaptor<LibraryItem> item = ArgumentCaptor.forClass(LibraryItem.class);
+        verify(libraryRepository).save(item.capture());
+        assertEquals("user-2", item.getValue().getUserId());
+        assertEquals("42", item.getValue().getAlbumId());
+        assertEquals(7L, item.getValue().getOrderId());
+        assertEquals("user-1", item.getValue().getGiftedBy());
+        verify(orderProducer).sendOrderGiftedEvent(order);
+    }
+
+    @Test
+    void deliver_ignoresOrdersThatArentGiftsOrWereGranted() {
+        gifting.deliver(giftOrder(null));
+        when(libraryRepository.existsByOrderId(7L)).thenReturn(true);
+        gifting.deliver(giftOrder("user-2"));
+
+        verify(libraryRepository, never()).save(any());
+        verify(orderProducer, never()).sendOrderGiftedEvent(any());
+    }
+}
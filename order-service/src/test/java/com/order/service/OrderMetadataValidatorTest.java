@@ -0,0 +1,37 @@
+package com.order.service;
+
+import org.junit.jupiter.api.Test;
+
+import java.util.List;
+import java.util.Map;
+
+import static org.junit.jupiter.api.Assertions.assertDoesNotThrow;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+
+class OrderMetadataValidatorTest {
+
+    private final OrderMetadataValidator validator =
+            new OrderMetadataValidator(List.of("giftNote", "giftWrap"), 10);
+
+    @Test
+    void validate_acceptsWhitelistedKeys() {
+        assertDoesNotThrow(() -> validator.validate(Map.of("giftNote", "Happy bday", "giftWrap", "red")));
+    }
+
+    @Test
+    void validate_acceptsMissingMetadata() {
+        assertDoesNotThrow(() -> validator.validate(null));
+        assertDoesNotThrow(() -> validator.validate(Map.of()));
+    }
+
+    @Test
+    void validate_rejectsUnknownKey() {
+        assertThrows(IllegalArgumentException.class, () -> validator.validate(Map.of("couponHack", "x")));
+    }
+
+    @Test
+    void validate_rejectsBlankOrOversizedValue() {
+        assertThrows(IllegalArgumentException.class, () -> validator.validate(Map.of("giftNote", " ")));
+        assertThrows(IllegalArgumentException.class, () -> validator.validate(Map.of("giftNote", "way too long note")));
+    }
+}
@@ -0,0 +1,78 @@
+package com.order.service;
+
+import com.order.model.Order;
+import com.order.model.PriceSnapshot;
+import org.junit.jupiter.api.Test;
+
+import java.math.BigDecimal;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+import static org.mockito.Mockito.mock;
+import static org.mockito.Mockito.when;
+
+class OrderPricingTest {
+
+    private final AlbumPriceClient albumPriceClient = mock(AlbumPriceClient.class);
+    private final OrderPricing pricing = new OrderPricing(albumPriceClient, "EUR");
+
+    private static Order order(String albumId, int quantity) {
+        Order order = new Order();
+        order.setUserId("user123");
+        order.setAlbumId(albumId);
+        order.setQuantity(quantity);
+        return order;
+    }
+
+    @Test
+    void price_usesCurrentAlbumPrice() {
+        when(albumPriceClient.currentPrice("42", null))
+                .thenReturn(new AlbumPrice("42", new BigDecimal("19.99"), new BigDecimal("19.99")));
+
+        Order order = order("42", 3);
+        pricing.price(order);
+
+        PriceSnapshot snapshot = order.getPrice();
+        assertEquals(new BigDecimal("19.99"), snapshot.getUnitPrice());
+        assertEquals(new BigDecimal("0.00"), snapshot.getDiscountAmount());
+        assertEquals(new BigDecimal("0.00"), snapshot.getTaxAmount());
+        assertEquals(new BigDecimal("59.97"), snapshot.getTotalPrice());
+        assertEquals("EUR", snapshot.getCurrency());
+    }
+
+    @Test
+    void price_ordersByDatabaseIdWhenPlacedWithPublicId() {
+        // With PUBLIC_ID_ENCODING=sqids customers order "JgaEBg"; inventory-service only knows album 42
+        when(albumPriceClient.currentPrice("JgaEBg", null))
+                .thenReturn(new AlbumPrice("42", new BigDecimal("19.99"), new BigDecimal("19.99")));
+
+        Order order = order("JgaEBg", 1);
+        pricing.price(order);
+
+        assertEquals("42", order.getAlbumId());
+        assertEquals(new BigDecimal("19.99"), order.getPrice().getTotalPrice());
+    }
+
+    @Test
+    void price_appliesPromotionAsDiscount() {
+        // A 25% promotion, or the SUMMER24 code, takes the album from 20.00 to 15.00
+        when(albumPriceClient.currentPrice("42", "SUMMER24"))
+                .thenReturn(new AlbumPrice("42", new BigDecimal("20"), new BigDecimal("15")));
+
+        Order order = order("42", 2);
+        order.setDiscountCode("SUMMER24");
+        pricing.price(order);
+
+        PriceSnapshot snapshot = order.getPrice();
+        assertEquals(new BigDecimal("20.00"), snapshot.getUnitPrice());
+        assertEquals(new BigDecimal("10.00"), snapshot.getDiscountAmount());
+        assertEquals(new BigDecimal("30.00"), snapshot.getTotalPrice());
+    }
+
+    @Test
+    void price_rejectsUnknownAlbum() {
+        when(albumPriceClient.currentPrice("missing", null)).thenThrow(new IllegalArgumentException("Album not found: missing"));
+
+        assertThrows(IllegalArgumentException.class, () -> pricing.price(order("missing", 1)));
+    }
+}
@@ -0,0 +1,102 @@
+package com.order.service;
+
+import com.order.kafka.OrderEventSchema;
+import com.order.model.Order;
+import com.order.model.OrderProgress;
+import com.order.model.OrderStatusChange;
+import com.order.repository.OrderStatusChangeRepository;
+import org.junit.jupiter.api.Test;
+
+import java.time.LocalDateTime;
+import java.util.List;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertFalse;
+import static org.junit.jupiter.api.Assertions.assertNull;
+import static org.junit.jupiter.api.Assertions.assertTrue;
+import static org.mockito.ArgumentMatchers.argThat;
+import static org.mockito.Mockito.mock;
+import static org.mockito.Mockito.verify;
+import static org.mockito.Mockito.when;
+
+class OrderStatusHistoryTest {
+
+    private static final LocalDateTime CREATED = LocalDateTime.of(2024, 5, 1, 12, 0);
+
+    private final OrderStatusChangeRepository repository = mock(OrderStatusChangeRepository.class);
+    private final OrderStatusHistory history = new OrderStatusHistory(repository);
+
+    private Order order(String status) {
+        Order order = new Order();
+        order.setId(7L);
+        order.setAlbumId("42");
+        order.setQuantity(2);
+        order.setStatus(status);
+        order.setCreatedAt(CREATED);
+        return order;
+    }
+
+    private OrderStatusChange change(String status, String reason, LocalDateTime at) {
+        return OrderStatusChange.builder().orderId(7L).status(status).reason(reason).changedAt(at).build();
+    }
+
+    @Test
+    void record_keepsTheSourceEvent() {
+        // The eventId of the v2 envelope, which consumers read by default
+        String eventId = OrderEventSchema.stableEventId("order.failed:7");
+        history.record(7L, "FAILED", "OUT_OF_STOCK", eventId);
+
+        verify(repository).save(argThat(change -> change.getOrderId().equals(7L)
+                && change.getStatus().equals("FAILED")
+                && change.getReason().equals("OUT_OF_STOCK")
+                && change.getSourceEventId().equals(eventId)));
+    }
+
+    @Test
+    void isRecorded_findsAnEventByItsId() {
+        String eventId = OrderEventSchema.stableEventId("order.failed:7");
+        when(repository.existsByOrderIdAndSourceEventId(7L, eventId)).thenReturn(true);
+
+        assertTrue(history.isRecorded(7L, eventId), "a republished event is skipped");
+        assertFalse(history.isRecorded(7L, OrderEventSchema.stableEventId("order.failed:8")));
+    }
+
+    @Test
+    void progress_replaysStockConfirmation() {
+        when(repository.findByOrderIdOrderByChangedAtAscIdAsc(7L)).thenReturn(List.of(
+                change("PENDING", null, CREATED),
+                change("SUCCEEDED", null, CREATED.plusSeconds(3))));
+
+        OrderProgress progress = history.progress(order("SUCCEEDED"));
+
+        assertEquals("SUCCEEDED", progress.getStatus());
+        assertEquals(List.of(
+                new OrderProgress.Step(OrderProgress.STEP_CREATED, true, CREATED),
+                new OrderProgress.Step(OrderProgress.STEP_STOCK_CONFIRMED, true, CREATED.plusSeconds(3))), progress.getSteps());
+        assertNull(progress.getFailureReason());
+    }
+
+    @Test
+    void progress_reportsFailure() {
+        when(repository.findByOrderIdOrderByChangedAtAscIdAsc(7L)).thenReturn(List.of(
+                change("PENDING", null, CREATED),
+                change("FAILED", "OUT_OF_STOCK", CREATED.plusSeconds(2))));
+
+        OrderProgress progress = history.progress(order("FAILED"));
+
+        assertFalse(progress.getSteps().get(1).isReached());
+        assertEquals("OUT_OF_STOCK", progress.getFailureReason());
+        assertEquals(CREATED.plusSeconds(2), progress.getFailedAt());
+    }
+
+    @Test
+    void progress_coversOrdersWithoutHistory() {
+        when(repository.findByOrderIdOrderByChangedAtAscIdAsc(7L)).thenReturn(List.of());
+
+        OrderProgress progress = history.progress(order("SUCCEEDED"));
+
+        assertEquals(CREATED, progress.getSteps().get(0).getAt());
+        assertTrue(progress.getSteps().get(1).isReached());
+        assertNull(progress.getSteps().get(1).getAt());
+    }
+}
@@ -0,0 +1,38 @@
+package com.order.service;
+
+import org.junit.jupiter.api.Test;
+
+import java.util.Optional;
+
+import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertNotEquals;
+import static org.junit.jupiter.api.Assertions.assertTrue;
+
+class OrderStatusTokensTest {
+
+    private final OrderStatusTokens tokens = new OrderStatusTokens("test-secret");
+
+    @Test
+    void verify_acceptsIssuedToken() {
+        String token = tokens.issue(42L);
+
+        assertTrue(token.startsWith("42."));
+        assertEquals(Optional.of(42L), tokens.verify(token));
+    }
+
+    @Test
+    void verify_rejectsTamperedTokens() {
+        String signature = tokens.issue(42L).substring(3);
+
+        assertEquals(Optional.empty(), tokens.verify("43." + signature), "signature of another order");
+        assertEquals(Optional.empty(), tokens.verify("42"));
+        assertEquals(Optional.empty(), tokens.verify("42.not-base64!"));
+        assertEquals(Optional.empty(), tokens.verify("abc." + signature));
+        assertEquals(Optional.empty(), tokens.verify(new OrderStatusTokens("other-secret").issue(42L)));
+    }
+
+    @Test
+    void issue_differsPerOrder() {
+        assertNotEquals(tokens.issue(1L).substring(2), tokens.issue(2L).substring(2));
+    }
+}
@@ -0,0 +1,163 @@
+// Package redaction redacts span attributes before spans are exported, for the tracing of the Go
+// services. Rules come from OTEL_REDACT_ATTRIBUTES, e.g.
+//
+//	user.id=hash,user.email=drop,db.statement=drop,enduser.*=drop
+//
+// A matching attribute is dropped, or its value replaced by a keyed hash (OTEL_REDACT_HASH_KEY) so
+// equal values still correlate across spans.
+package redaction
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Action is what happens to a matching attribute before export
+type Action string
+
+const (
+	Drop Action = "drop" // Remove the attribute entirely
+	Hash Action = "hash" // Replace the value with a keyed hash (still usable for correlation)
+)
+
+// Rule maps an attribute key, or a "prefix.*" pattern, to an action
+type Rule struct {
+	Pattern string
+	Action  Action
+}
+
+// matches reports whether the rule applies to the given attribute key
+func (r Rule) matches(key string) bool {
+	if strings.HasSuffix(r.Pattern, "*") {
+		return strings.HasPrefix(key, strings.TrimSuffix(r.Pattern, "*"))
+	}
+	return key == r.Pattern
+}
+
+// ParseRules parses a rule list such as "user.id=hash,user.email=drop,db.statement=drop,enduser.*=drop"
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, action, found := strings.Cut(entry, "=")
+		key, action = strings.TrimSpace(key), strings.TrimSpace(action)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid redaction rule %q: expected <attribute>=<drop|hash>", entry)
+		}
+		switch Action(action) {
+		case Drop, Hash:
+			rules = append(rules, Rule{Pattern: key, Action: Action(action)})
+		default:
+			return nil, fmt.Errorf("invalid redaction action %q for attribute %q: expected drop or hash", action, key)
+		}
+	}
+	return rules, nil
+}
+
+// Redactor applies redaction rules to attribute sets
+type Redactor struct {
+	rules   []Rule
+	hashKey []byte
+}
+
+// NewRedactorFromEnv builds a redactor from OTEL_REDACT_ATTRIBUTES and OTEL_REDACT_HASH_KEY. It
+// returns nil when no rules are configured. hash rules need the key: without it the digests of user
+// IDs and emails could be reversed by hashing guesses.
+func NewRedactorFromEnv() (*Redactor, error) {
+	rules, err := ParseRules(os.Getenv("OTEL_REDACT_ATTRIBUTES"))
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	hashKey := os.Getenv("OTEL_REDACT_HASH_KEY")
+	for _, rule := range rules {
+		if rule.Action == Hash && hashKey == "" {
+			return nil, fmt.Errorf("redaction rule %q hashes but OTEL_REDACT_HASH_KEY is not set", rule.Pattern)
+		}
+	}
+	return &Redactor{rules: rules, hashKey: []byte(hashKey)}, nil
+}
+
+// Redact returns a copy of attrs with the first matching rule applied to each attribute
+func (r *Redactor) Redact(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		rule, ok := r.ruleFor(string(kv.Key))
+		if !ok {
+			out = append(out, kv)
+			continue
+		}
+		if rule.Action == Hash {
+			out = append(out, attribute.String(string(kv.Key), r.hash(kv.Value.Emit())))
+		}
+		// Drop: skip the attribute
+	}
+	return out
+}
+
+func (r *Redactor) ruleFor(key string) (Rule, bool) {
+	for _, rule := range r.rules {
+		if rule.matches(key) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// hash returns a short, keyed, deterministic digest so equal values still correlate across spans
+func (r *Redactor) hash(value string) string {
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(value))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// exporter wraps a SpanExporter and redacts span and event attributes before export
+type exporter struct {
+	sdktrace.SpanExporter
+	redactor *Redactor
+}
+
+// NewExporter wraps next when a redactor is configured, otherwise returns it unchanged
+func NewExporter(next sdktrace.SpanExporter, redactor *Redactor) sdktrace.SpanExporter {
+	if redactor == nil {
+		return next
+	}
+	return &exporter{SpanExporter: next, redactor: redactor}
+}
+
+// ExportSpans redacts every span before handing the batch to the wrapped exporter
+func (e *exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		events := append([]sdktrace.Event(nil), s.Events()...) // Copy: the snapshot's slice is shared
+		for j := range events {
+			events[j].Attributes = e.redactor.Redact(events[j].Attributes)
+		}
+		redacted[i] = redactedSpan{ReadOnlySpan: s, attributes: e.redactor.Redact(s.Attributes()), events: events}
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan overrides the attribute accessors of a finished span
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	attributes []attribute.KeyValue
+	events     []sdktrace.Event
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue { return s.attributes }
+
+func (s redactedSpan) Events() []sdktrace.Event { return s.events }
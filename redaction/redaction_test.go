@@ -0,0 +1,91 @@
+package redaction
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseRedactionRules(t *testing.T) {
+	rules, err := ParseRules(" user.id=hash, db.statement=drop,enduser.*=drop ,")
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Pattern: "user.id", Action: Hash},
+		{Pattern: "db.statement", Action: Drop},
+		{Pattern: "enduser.*", Action: Drop},
+	}, rules)
+
+	rules, err = ParseRules("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	_, err = ParseRules("user.id")
+	assert.Error(t, err, "rule without an action should be rejected")
+
+	_, err = ParseRules("user.id=mask")
+	assert.Error(t, err, "unknown action should be rejected")
+}
+
+func TestExporter(t *testing.T) {
+	rules, err := ParseRules("user.id=hash,user.email=drop,enduser.*=drop")
+	require.NoError(t, err)
+	redactor := &Redactor{rules: rules, hashKey: []byte("test-key")}
+
+	inner := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(NewExporter(inner, redactor)))
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("test").Start(context.Background(), "processOrderCreated")
+	span.SetAttributes(
+		attribute.String("order.id", "42"),
+		attribute.String("user.id", "user-123"),
+		attribute.String("user.email", "someone@example.com"),
+		attribute.String("enduser.role", "admin"),
+	)
+	span.AddEvent("lookup", trace.WithAttributes(attribute.String("user.email", "someone@example.com")))
+	span.End()
+
+	spans := inner.GetSpans()
+	require.Len(t, spans, 1)
+
+	attrs := make(map[string]string)
+	for _, kv := range spans[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "42", attrs["order.id"], "unmatched attributes are exported unchanged")
+	assert.NotContains(t, attrs, "user.email", "dropped attribute should not be exported")
+	assert.NotContains(t, attrs, "enduser.role", "prefix pattern should drop matching attributes")
+	assert.Equal(t, redactor.hash("user-123"), attrs["user.id"], "hashed attribute should be replaced by its digest")
+	assert.NotContains(t, attrs["user.id"], "user-123")
+
+	require.Len(t, spans[0].Events, 1)
+	assert.Empty(t, spans[0].Events[0].Attributes, "event attributes should be redacted too")
+}
+
+func TestNewExporter_NoRulesIsPassthrough(t *testing.T) {
+	inner := tracetest.NewInMemoryExporter()
+	assert.Same(t, inner, NewExporter(inner, nil))
+}
+
+func TestNewRedactorFromEnv_HashNeedsKey(t *testing.T) {
+	t.Setenv("OTEL_REDACT_ATTRIBUTES", "user.id=hash,user.email=drop")
+	t.Setenv("OTEL_REDACT_HASH_KEY", "")
+	_, err := NewRedactorFromEnv()
+	assert.ErrorContains(t, err, "OTEL_REDACT_HASH_KEY", "hashing with an empty key should be rejected")
+
+	t.Setenv("OTEL_REDACT_HASH_KEY", "s3cret")
+	redactor, err := NewRedactorFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cret"), redactor.hashKey)
+
+	t.Setenv("OTEL_REDACT_ATTRIBUTES", "user.email=drop")
+	t.Setenv("OTEL_REDACT_HASH_KEY", "")
+	_, err = NewRedactorFromEnv()
+	assert.NoError(t, err, "drop rules don't need a key")
+}
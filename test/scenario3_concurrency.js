@@ -3,7 +3,7 @@ import http from "k6/http";
 import { check, sleep } from "k6";
 
 const BASE_URL = "http://localhost";
-const ALBUM_SERVICE = `${BASE_URL}:8080/api/albums`;
+const ALBUM_SERVICE = `${BASE_URL}:8080/api/v1/albums`;
 const ORDER_SERVICE = `${BASE_URL}:8082/api/orders`;
 
 const ADMIN_HEADERS = {
@@ -0,0 +1,47 @@
+// Package topics scopes the Kafka topic and consumer group names of the Go services to an
+// environment. KAFKA_TOPIC_PREFIX / KAFKA_TOPIC_SUFFIX (e.g. "staging.") are applied to every topic a
+// service produces to or consumes from, and to its consumer group IDs, so several environments can
+// share one Kafka cluster without reading each other's events or joining each other's groups.
+package topics
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var (
+	prefix string
+	suffix string
+)
+
+// Kafka allows ASCII alphanumerics, '.', '_' and '-' in topic names
+var validAffix = regexp.MustCompile(`^[a-zA-Z0-9._-]*$`)
+
+// LoadNaming reads the topic prefix/suffix from the environment
+func LoadNaming() error {
+	p, s := os.Getenv("KAFKA_TOPIC_PREFIX"), os.Getenv("KAFKA_TOPIC_SUFFIX")
+	for name, value := range map[string]string{"KAFKA_TOPIC_PREFIX": p, "KAFKA_TOPIC_SUFFIX": s} {
+		if !validAffix.MatchString(value) {
+			return fmt.Errorf("%s %q may only contain letters, digits, '.', '_' and '-'", name, value)
+		}
+	}
+	prefix, suffix = p, s
+	return nil
+}
+
+// Prefix returns the prefix applied to topic and group names
+func Prefix() string { return prefix }
+
+// Suffix returns the suffix applied to topic and group names
+func Suffix() string { return suffix }
+
+// Name returns the environment-scoped name of a base topic such as "order-created"
+func Name(base string) string {
+	return prefix + base + suffix
+}
+
+// GroupName returns the environment-scoped name of a consumer group
+func GroupName(base string) string {
+	return prefix + base + suffix
+}
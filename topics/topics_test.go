@@ -0,0 +1,36 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadNaming(t *testing.T) {
+	t.Cleanup(func() { prefix, suffix = "", "" })
+
+	t.Run("Unset keeps base names", func(t *testing.T) {
+		t.Setenv("KAFKA_TOPIC_PREFIX", "")
+		t.Setenv("KAFKA_TOPIC_SUFFIX", "")
+		require.NoError(t, LoadNaming())
+		assert.Equal(t, "order-created", Name("order-created"))
+		assert.Equal(t, "inventory-service-consumers", GroupName("inventory-service-consumers"))
+	})
+
+	t.Run("Prefix and suffix apply to topics and groups", func(t *testing.T) {
+		t.Setenv("KAFKA_TOPIC_PREFIX", "staging.")
+		t.Setenv("KAFKA_TOPIC_SUFFIX", ".v2")
+		require.NoError(t, LoadNaming())
+		assert.Equal(t, "staging.order-failed.v2", Name("order-failed"))
+		assert.Equal(t, "staging.inventory-service-album-init.v2", GroupName("inventory-service-album-init"))
+		assert.Equal(t, "staging.", Prefix())
+		assert.Equal(t, ".v2", Suffix())
+	})
+
+	t.Run("Invalid characters are rejected", func(t *testing.T) {
+		t.Setenv("KAFKA_TOPIC_PREFIX", "staging/")
+		t.Setenv("KAFKA_TOPIC_SUFFIX", "")
+		assert.Error(t, LoadNaming())
+	})
+}